@@ -35,6 +35,7 @@ import (
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/bootstrap"
 	"github.com/google/blueprint/pathtools"
+	"github.com/google/blueprint/proptools"
 )
 
 func init() {
@@ -440,6 +441,12 @@ func (a *AndroidMkEntries) getDistContributions(mod blueprint.Module) *distContr
 			}
 
 			copiesForGoals.addCopyInstruction(path, dest)
+
+			if proptools.Bool(dist.Provenance) {
+				if provenanceFile, ok := amod.distProvenanceFiles[path.String()]; ok {
+					copiesForGoals.addCopyInstruction(provenanceFile, dest+distProvenanceSuffix)
+				}
+			}
 		}
 	}
 
@@ -547,6 +554,9 @@ func (a *AndroidMkEntries) fillInEntries(ctx fillInEntriesContext, mod blueprint
 
 	if am, ok := mod.(ApexModule); ok {
 		a.SetBoolIfTrue("LOCAL_NOT_AVAILABLE_FOR_PLATFORM", am.NotAvailableForPlatform())
+		if am.NotAvailableForPlatform() {
+			a.SetString("LOCAL_NOT_AVAILABLE_FOR_PLATFORM_REASON", am.NotAvailableForPlatformReason())
+		}
 	}
 
 	archStr := base.Arch().ArchType.String()
@@ -720,6 +730,7 @@ func translateAndroidMk(ctx SingletonContext, absMkFile string, mods []blueprint
 	fmt.Fprintln(buf, "LOCAL_MODULE_MAKEFILE := $(lastword $(MAKEFILE_LIST))")
 
 	typeStats := make(map[string]int)
+	notAvailableForPlatform := make(map[string]string)
 	for _, mod := range mods {
 		err := translateAndroidMkModule(ctx, buf, mod)
 		if err != nil {
@@ -730,8 +741,17 @@ func translateAndroidMk(ctx SingletonContext, absMkFile string, mods []blueprint
 		if amod, ok := mod.(Module); ok && ctx.PrimaryModule(amod) == amod {
 			typeStats[ctx.ModuleType(amod)] += 1
 		}
+
+		if am, ok := mod.(ApexModule); ok && am.NotAvailableForPlatform() {
+			apexInfo := ctx.ModuleProvider(mod, ApexInfoProvider).(ApexInfo)
+			if apexInfo.IsForPlatform() {
+				notAvailableForPlatform[ctx.ModuleName(mod)] = am.NotAvailableForPlatformReason()
+			}
+		}
 	}
 
+	fmt.Fprint(buf, notAvailableForPlatformCheck(notAvailableForPlatform))
+
 	keys := []string{}
 	fmt.Fprintln(buf, "\nSTATS.SOONG_MODULE_TYPE :=")
 	for k := range typeStats {
@@ -746,6 +766,25 @@ func translateAndroidMk(ctx SingletonContext, absMkFile string, mods []blueprint
 	return pathtools.WriteFileIfChanged(absMkFile, buf.Bytes(), 0666)
 }
 
+// notAvailableForPlatformCheck returns the Make code that fails the build when a module that is not
+// available to platform is in PRODUCT_PACKAGES, with the reason, so that it's clear which
+// dependency made it unavailable. reasons maps the names of the modules to their reasons.
+func notAvailableForPlatformCheck(reasons map[string]string) string {
+	if len(reasons) == 0 {
+		return ""
+	}
+	names := SortedStringKeys(reasons)
+	var b strings.Builder
+	fmt.Fprintln(&b, "\nSOONG_NOT_AVAILABLE_FOR_PLATFORM :=", strings.Join(names, " "))
+	for _, name := range names {
+		fmt.Fprintf(&b, "SOONG_NOT_AVAILABLE_FOR_PLATFORM_REASON.%s := %s\n", name,
+			strings.ReplaceAll(reasons[name], "$", "$$"))
+	}
+	fmt.Fprintln(&b, "$(foreach m,$(filter $(SOONG_NOT_AVAILABLE_FOR_PLATFORM),$(PRODUCT_PACKAGES)),\\")
+	fmt.Fprintln(&b, "  $(error $(m) is not available for platform: $(SOONG_NOT_AVAILABLE_FOR_PLATFORM_REASON.$(m))))")
+	return b.String()
+}
+
 func translateAndroidMkModule(ctx SingletonContext, w io.Writer, mod blueprint.Module) error {
 	defer func() {
 		if r := recover(); r != nil {
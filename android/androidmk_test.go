@@ -776,4 +776,71 @@ func TestGetDistContributions(t *testing.T) {
 			},
 		},
 	})
+
+	testHelper(t, "dist-with-provenance", `
+			custom {
+				name: "foo",
+				dist: {
+					targets: ["my_goal"],
+					provenance: true,
+				}
+			}
+`, &distContributions{
+		copiesForGoals: []*copiesForGoals{
+			{
+				goals: "my_goal",
+				copies: []distCopy{
+					distCopyForTest("one.out", "one.out"),
+					distCopyForTest(".intermediates/foo/dist_provenance/one.out.intoto.json", "one.out.intoto.json"),
+				},
+			},
+		},
+	})
+
+	testHelper(t, "dists-with-provenance-for-one-tag", `
+			custom {
+				name: "foo",
+				dists: [
+					{
+						targets: ["my_goal"],
+					},
+					{
+						targets: ["my_second_goal"],
+						tag: ".another-tag",
+						provenance: true,
+					},
+				],
+			}
+`, &distContributions{
+		copiesForGoals: []*copiesForGoals{
+			{
+				goals: "my_goal",
+				copies: []distCopy{
+					distCopyForTest("one.out", "one.out"),
+				},
+			},
+			{
+				goals: "my_second_goal",
+				copies: []distCopy{
+					distCopyForTest("another.out", "another.out"),
+					distCopyForTest(".intermediates/foo/dist_provenance/another.out.intoto.json", "another.out.intoto.json"),
+				},
+			},
+		},
+	})
+}
+
+func TestNotAvailableForPlatformCheck(t *testing.T) {
+	AssertStringEquals(t, "no modules", "", notAvailableForPlatformCheck(nil))
+
+	AssertStringEquals(t, "check", `
+SOONG_NOT_AVAILABLE_FOR_PLATFORM := libbar libfoo
+SOONG_NOT_AVAILABLE_FOR_PLATFORM_REASON.libbar := libbar: apex_available doesn't include "//apex_available:platform"
+SOONG_NOT_AVAILABLE_FOR_PLATFORM_REASON.libfoo := libfoo -> lib$$bar: apex_available doesn't include "//apex_available:platform"
+$(foreach m,$(filter $(SOONG_NOT_AVAILABLE_FOR_PLATFORM),$(PRODUCT_PACKAGES)),\
+  $(error $(m) is not available for platform: $(SOONG_NOT_AVAILABLE_FOR_PLATFORM_REASON.$(m))))
+`, notAvailableForPlatformCheck(map[string]string{
+		"libfoo": `libfoo -> lib$bar: apex_available doesn't include "//apex_available:platform"`,
+		"libbar": `libbar: apex_available doesn't include "//apex_available:platform"`,
+	}))
 }
@@ -15,6 +15,7 @@
 package android
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
@@ -234,16 +235,25 @@ type ApexModule interface {
 	// is the case when this module depends on other module that isn't available to platform.
 	NotAvailableForPlatform() bool
 
-	// Marks that this module is not available to platform. Set by the
+	// Returns why this module is not available to platform, i.e. the chain of dependencies from
+	// this module to the module whose apex_available property doesn't have
+	// "//apex_available:platform". Returns an empty string if the module is available to platform.
+	NotAvailableForPlatformReason() string
+
+	// Marks that this module is not available to platform for the given reason. Set by the
 	// check-platform-availability mutator in the apex package.
-	SetNotAvailableForPlatform()
+	SetNotAvailableForPlatform(reason string)
 
-	// Returns the list of APEXes that this module is a test for. The module has access to the
-	// private part of the listed APEXes even when it is not included in the APEXes. This by
-	// default returns nil. A module type should override the default implementation. For
-	// example, cc_test module type returns the value of test_for here.
+	// Returns the list of APEXes that this module is a test for, i.e. the value of test_for. The
+	// module has access to the private part of the listed APEXes even when it is not included in
+	// the APEXes.
 	TestFor() []string
 
+	// Returns true if this module is a test, and is therefore allowed to set test_for. This by
+	// default returns false. A module type with test modules should override the default
+	// implementation. For example, cc_test, java_test, rust_test and sh_test return true here.
+	IsTestModule() bool
+
 	// Returns nil (success) if this module should support the given sdk version. Returns an
 	// error if not. No default implementation is provided for this method. A module type
 	// implementing this interface should provide an implementation. A module supports an sdk
@@ -254,6 +264,11 @@ type ApexModule interface {
 	// deduping. This is turned on when, for example if use_apex_name_macro is set so that each
 	// apex variant should be built with different macro definitions.
 	UniqueApexVariations() bool
+
+	// Returns the names of the APEX variations that were merged into the APEX variation of this
+	// module, e.g. ["apex.a", "apex.b"] for libfoo in the apex29 variation (see mergedName).
+	// Returns nil for the platform variant. Call this after apex.apexMutator is run.
+	ApexVariationConstituents() []string
 }
 
 // Properties that are common to all module types implementing ApexModule interface.
@@ -268,6 +283,11 @@ type ApexProperties struct {
 	// Default is ["//apex_available:platform"].
 	Apex_available []string
 
+	// List of APEXes that this module is a test for. The module has access to the private part
+	// of the listed APEXes, e.g. it links against the implementation of their libraries instead
+	// of their stubs, even though it is not included in them. Only allowed on test modules.
+	Test_for []string `android:"arch_variant"`
+
 	// See ApexModule.InAnyApex()
 	InAnyApex bool `blueprint:"mutated"`
 
@@ -285,8 +305,14 @@ type ApexProperties struct {
 	// See ApexModule.NotAvailableForPlatform()
 	NotAvailableForPlatform bool `blueprint:"mutated"`
 
+	// See ApexModule.NotAvailableForPlatformReason()
+	NotAvailableForPlatformReason string `blueprint:"mutated"`
+
 	// See ApexModule.UniqueApexVariants()
 	UniqueApexVariationsForDeps bool `blueprint:"mutated"`
+
+	// See ApexModule.ApexVariationConstituents()
+	ApexVariationConstituents []string `blueprint:"mutated"`
 }
 
 // Marker interface that identifies dependencies that are excluded from APEX contents.
@@ -325,6 +351,38 @@ type CopyDirectlyInAnyApexTag interface {
 	CopyDirectlyInAnyApex()
 }
 
+// Marker interface that identifies dependencies that are only added when code coverage is enabled,
+// e.g. on the native coverage runtime libraries. They are in the payload of the coverage variants
+// of APEXes, but are skipped by the min_sdk_version and apex_available checks of the payload.
+type CoverageDependencyTag interface {
+	blueprint.DependencyTag
+
+	// Method that differentiates this interface from others.
+	CoverageDependency()
+}
+
+// CoverageDependenciesModule is implemented by module types whose modules can depend on other
+// modules only because code coverage is enabled, e.g. java modules that are statically
+// instrumented, or bootclasspath_fragment modules with coverage specific contents.
+type CoverageDependenciesModule interface {
+	// Returns the names of the modules that this module only depends on because code coverage is
+	// enabled.
+	CoverageDependencies() []string
+}
+
+// IsCoverageDependency returns true if the dependency from from on to with the given tag only
+// exists because code coverage is enabled.
+func IsCoverageDependency(ctx BaseModuleContext, from, to blueprint.Module, tag blueprint.DependencyTag) bool {
+	if _, ok := tag.(CoverageDependencyTag); ok {
+		return true
+	}
+	if m, ok := from.(CoverageDependenciesModule); ok {
+		name := RemoveOptionalPrebuiltPrefix(ctx.OtherModuleName(to))
+		return InList(name, m.CoverageDependencies())
+	}
+	return false
+}
+
 // Interface that identifies dependencies to skip Apex dependency check
 type SkipApexAllowedDependenciesCheck interface {
 	// Returns true to skip the Apex dependency check, which limits the allowed dependency in build.
@@ -343,7 +401,8 @@ type ApexModuleBase struct {
 }
 
 // Initializes ApexModuleBase struct. Not calling this (even when inheriting from ApexModuleBase)
-// prevents the module from being mutated for apexBundle.
+// prevents the module from being mutated for apexBundle. It must be called before
+// InitAndroidArchModule for test_for to be arch-variant.
 func InitApexModule(m ApexModule) {
 	base := m.apexModuleBase()
 	base.canHaveApexVariants = true
@@ -376,9 +435,12 @@ func (m *ApexModuleBase) BuildForApex(apex ApexInfo) {
 			// `com.android.foo` (from the `apex` module type) and
 			// `com.mycompany.android.foo` (from the `override_apex` module type), both
 			// of which has the same ApexVariantName `com.android.foo`. Add the apex
-			// name to the list so that it's not lost.
+			// name to the list so that it's not lost. The contents of the APEXes can
+			// differ as override_apex can add and remove libraries, so keep the
+			// contents of both.
 			if !InList(apex.InApexModules[0], v.InApexModules) {
 				m.apexInfos[i].InApexModules = append(m.apexInfos[i].InApexModules, apex.InApexModules[0])
+				m.apexInfos[i].ApexContents = append(m.apexInfos[i].ApexContents, apex.ApexContents...)
 			}
 			return
 		}
@@ -413,11 +475,21 @@ func (m *ApexModuleBase) IsInstallableToApex() bool {
 	return false
 }
 
+// Implements ApexModule
+func (m *ApexModuleBase) ApexVariationConstituents() []string {
+	return m.ApexProperties.ApexVariationConstituents
+}
+
 // Implements ApexModule
 func (m *ApexModuleBase) TestFor() []string {
+	return m.ApexProperties.Test_for
+}
+
+// Implements ApexModule
+func (m *ApexModuleBase) IsTestModule() bool {
 	// If needed, this will be overridden by concrete types inheriting
 	// ApexModuleBase
-	return nil
+	return false
 }
 
 // Implements ApexModule
@@ -474,8 +546,14 @@ func (m *ApexModuleBase) NotAvailableForPlatform() bool {
 }
 
 // Implements ApexModule
-func (m *ApexModuleBase) SetNotAvailableForPlatform() {
+func (m *ApexModuleBase) NotAvailableForPlatformReason() string {
+	return m.ApexProperties.NotAvailableForPlatformReason
+}
+
+// Implements ApexModule
+func (m *ApexModuleBase) SetNotAvailableForPlatform(reason string) {
 	m.ApexProperties.NotAvailableForPlatform = true
+	m.ApexProperties.NotAvailableForPlatformReason = reason
 }
 
 // This function makes sure that the apex_available property is valid
@@ -608,6 +686,8 @@ func CreateApexVariations(mctx BottomUpMutatorContext, module ApexModule) []Modu
 		}
 		if !platformVariation {
 			mctx.SetVariationProvider(mod, ApexInfoProvider, apexInfos[i-1])
+			mod.(ApexModule).apexModuleBase().ApexProperties.ApexVariationConstituents =
+				CopyOf(apexInfos[i-1].InApexVariants)
 		}
 	}
 
@@ -768,8 +848,9 @@ func DirectlyInAllApexes(apexInfo ApexInfo, moduleName string) bool {
 //Below are routines for extra safety checks.
 //
 // BuildDepsInfoLists is to flatten the dependency graph for an apexBundle into a text file
-// (actually two in slightly different formats). The files are mostly for debugging, for example to
-// see why a certain module is included in an APEX via which dependency path.
+// (actually two in slightly different formats), and a JSON file for tools. The files are mostly for
+// debugging, for example to see why a certain module is included in an APEX via which dependency
+// path.
 //
 // CheckMinSdkVersion is to make sure that all modules in an apexBundle satisfy the min_sdk_version
 // requirement of the apexBundle.
@@ -784,38 +865,127 @@ type ApexModuleDepInfo struct {
 	IsExternal bool
 	// min_sdk_version of the ApexModule
 	MinSdkVersion string
+	// License kinds of the dependency.
+	LicenseKinds []string
+	// Whether the dependency is only used through its stubs or SDK, i.e. the APEX links against
+	// its API instead of its implementation.
+	UsedThroughStubs bool
+}
+
+// ApexStubsDependency is implemented by modules that modules in APEXes can depend on through their
+// stubs or SDK instead of including their implementation.
+type ApexStubsDependency interface {
+	// UsedThroughStubs returns true if a dependency on this module is satisfied by its stubs or
+	// SDK. externalDep is true if the dependency crosses the boundary of the APEX.
+	UsedThroughStubs(externalDep bool) bool
+}
+
+// NewApexModuleDepInfo returns the ApexModuleDepInfo of a dependency from "from" to "to".
+func NewApexModuleDepInfo(from blueprint.Module, to Module, externalDep bool, minSdkVersion string) ApexModuleDepInfo {
+	usedThroughStubs := false
+	if s, ok := to.(ApexStubsDependency); ok {
+		usedThroughStubs = s.UsedThroughStubs(externalDep)
+	}
+	return ApexModuleDepInfo{
+		To:               to.Name(),
+		From:             []string{from.Name()},
+		IsExternal:       externalDep,
+		MinSdkVersion:    minSdkVersion,
+		LicenseKinds:     to.EffectiveLicenseKinds(),
+		UsedThroughStubs: usedThroughStubs,
+	}
+}
+
+// AddDependent records another dependency from "from" on the module of info.
+func (info *ApexModuleDepInfo) AddDependent(from blueprint.Module, to Module, externalDep bool) {
+	if !InList(from.Name(), info.From) {
+		info.From = append(info.From, from.Name())
+	}
+	info.IsExternal = info.IsExternal && externalDep
+	if s, ok := to.(ApexStubsDependency); !ok || !s.UsedThroughStubs(externalDep) {
+		info.UsedThroughStubs = false
+	}
 }
 
 // A map of a dependency name to its ApexModuleDepInfo
 type DepNameToDepInfoMap map[string]ApexModuleDepInfo
 
 type ApexBundleDepsInfo struct {
-	flatListPath OutputPath
-	fullListPath OutputPath
+	flatListPath    OutputPath
+	fullListPath    OutputPath
+	jsonListPath    OutputPath
+	licenseListPath OutputPath
+
+	// The lines of the file at flatListPath.
+	flatList []string
 }
 
 type ApexBundleDepsInfoIntf interface {
 	Updatable() bool
 	FlatListPath() Path
 	FullListPath() Path
+	JsonListPath() Path
+	LicenseListPath() Path
+	FlatList() []string
 }
 
 func (d *ApexBundleDepsInfo) FlatListPath() Path {
 	return d.flatListPath
 }
 
+// FlatList returns the entries of the flat list of transitive deps, in the same format as the
+// lines of the file returned by FlatListPath.
+func (d *ApexBundleDepsInfo) FlatList() []string {
+	return d.flatList
+}
+
 func (d *ApexBundleDepsInfo) FullListPath() Path {
 	return d.fullListPath
 }
 
-// Generate two module out files:
+func (d *ApexBundleDepsInfo) JsonListPath() Path {
+	return d.jsonListPath
+}
+
+// LicenseListPath returns the file listing the license kinds of the transitive deps and whether
+// they are used through their stubs or SDK, for compliance review.
+func (d *ApexBundleDepsInfo) LicenseListPath() Path {
+	return d.licenseListPath
+}
+
+// The format of the JSON file written by BuildDepsInfoLists.
+type apexDepsInfoJson struct {
+	Name          string                `json:"name"`
+	MinSdkVersion string                `json:"min_sdk_version"`
+	Deps          []apexDepInfoJsonItem `json:"deps"`
+}
+
+type apexDepInfoJsonItem struct {
+	Name          string   `json:"name"`
+	MinSdkVersion string   `json:"min_sdk_version"`
+	External      bool     `json:"external"`
+	Parents       []string `json:"parents"`
+	LicenseKinds  []string `json:"license_kinds"`
+	UsesStubs     bool     `json:"used_through_stubs"`
+}
+
+// Generate four module out files:
 // 1. FullList with transitive deps and their parents in the dep graph
 // 2. FlatList with a flat list of transitive deps
-// In both cases transitive deps of external deps are not included. Neither are deps that are only
+// 3. JsonList with the same information as FullList in JSON, plus license kinds and stubs usage
+// 4. LicenseList with the license kinds of the transitive deps and their use through stubs or SDK
+// In all cases transitive deps of external deps are not included. Neither are deps that are only
 // available to APEXes; they are developed with updatability in mind and don't need manual approval.
 func (d *ApexBundleDepsInfo) BuildDepsInfoLists(ctx ModuleContext, minSdkVersion string, depInfos DepNameToDepInfoMap) {
 	var fullContent strings.Builder
 	var flatContent strings.Builder
+	var licenseContent strings.Builder
+	var flatList []string
+	jsonContent := apexDepsInfoJson{
+		Name:          ctx.ModuleName(),
+		MinSdkVersion: minSdkVersion,
+		Deps:          []apexDepInfoJsonItem{},
+	}
 
 	fmt.Fprintf(&fullContent, "%s(minSdkVersion:%s):\n", ctx.ModuleName(), minSdkVersion)
 	for _, key := range FirstUniqueStrings(SortedStringKeys(depInfos)) {
@@ -826,6 +996,25 @@ func (d *ApexBundleDepsInfo) BuildDepsInfoLists(ctx ModuleContext, minSdkVersion
 		}
 		fmt.Fprintf(&fullContent, "  %s <- %s\n", toName, strings.Join(SortedUniqueStrings(info.From), ", "))
 		fmt.Fprintf(&flatContent, "%s\n", toName)
+		flatList = append(flatList, toName)
+		jsonContent.Deps = append(jsonContent.Deps, apexDepInfoJsonItem{
+			Name:          info.To,
+			MinSdkVersion: info.MinSdkVersion,
+			External:      info.IsExternal,
+			Parents:       SortedUniqueStrings(info.From),
+			LicenseKinds:  append([]string{}, SortedUniqueStrings(info.LicenseKinds)...),
+			UsesStubs:     info.UsedThroughStubs,
+		})
+
+		licenseName := info.To
+		if info.UsedThroughStubs {
+			licenseName += " (stubs)"
+		}
+		licenseKinds := "(no license kinds)"
+		if len(info.LicenseKinds) > 0 {
+			licenseKinds = strings.Join(SortedUniqueStrings(info.LicenseKinds), ", ")
+		}
+		fmt.Fprintf(&licenseContent, "%s: %s\n", licenseName, licenseKinds)
 	}
 
 	d.fullListPath = PathForModuleOut(ctx, "depsinfo", "fulllist.txt").OutputPath
@@ -833,8 +1022,21 @@ func (d *ApexBundleDepsInfo) BuildDepsInfoLists(ctx ModuleContext, minSdkVersion
 
 	d.flatListPath = PathForModuleOut(ctx, "depsinfo", "flatlist.txt").OutputPath
 	WriteFileRule(ctx, d.flatListPath, flatContent.String())
+	d.flatList = flatList
 
-	ctx.Phony(fmt.Sprintf("%s-depsinfo", ctx.ModuleName()), d.fullListPath, d.flatListPath)
+	jsonBytes, err := json.MarshalIndent(jsonContent, "", "  ")
+	if err != nil {
+		ctx.ModuleErrorf("failed to marshal the dependency info to JSON: %s", err)
+		return
+	}
+	d.jsonListPath = PathForModuleOut(ctx, "depsinfo", "depsinfo.json").OutputPath
+	WriteFileRule(ctx, d.jsonListPath, string(jsonBytes))
+
+	d.licenseListPath = PathForModuleOut(ctx, "depsinfo", "licenses.txt").OutputPath
+	WriteFileRule(ctx, d.licenseListPath, licenseContent.String())
+
+	ctx.Phony(fmt.Sprintf("%s-depsinfo", ctx.ModuleName()), d.fullListPath, d.flatListPath, d.jsonListPath,
+		d.licenseListPath)
 }
 
 // TODO(b/158059172): remove minSdkVersion allowlist
@@ -895,10 +1097,91 @@ var minSdkVersionAllowlist = func(apiMap map[string]int) map[string]ApiLevel {
 	"xz-java":                                                  29,
 })
 
+var minSdkVersionAllowlistKey = NewOnceKey("minSdkVersionAllowlist")
+
+type minSdkVersionAllowlistResult struct {
+	list map[string]ApiLevel
+	err  error
+}
+
+// getMinSdkVersionAllowlist returns the modules that are allowed to not support the
+// min_sdk_version of the APEXes they are included in, with the API level they support instead.
+//
+// This is minSdkVersionAllowlist extended by the files listed in the MinSdkVersionAllowlistFiles
+// product variable, so that product and vendor trees can add to the list without modifying this
+// file. The files are merged in order: an entry in a file overrides the entry for the same module
+// in minSdkVersionAllowlist or in an earlier file.
+func getMinSdkVersionAllowlist(ctx PathContext) (map[string]ApiLevel, error) {
+	result := ctx.Config().Once(minSdkVersionAllowlistKey, func() interface{} {
+		list, err := loadMinSdkVersionAllowlist(ctx)
+		return minSdkVersionAllowlistResult{list, err}
+	}).(minSdkVersionAllowlistResult)
+	return result.list, result.err
+}
+
+func loadMinSdkVersionAllowlist(ctx PathContext) (map[string]ApiLevel, error) {
+	list := make(map[string]ApiLevel, len(minSdkVersionAllowlist))
+	for name, apiLevel := range minSdkVersionAllowlist {
+		list[name] = apiLevel
+	}
+	for _, file := range ctx.Config().MinSdkVersionAllowlistFiles() {
+		path := ExistentPathForSource(ctx, file)
+		if !path.Valid() {
+			return nil, fmt.Errorf("min_sdk_version allowlist %q does not exist", file)
+		}
+		content, err := ReadFileFromSourceTree(ctx, path.Path())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read min_sdk_version allowlist %q: %s", file, err)
+		}
+		entries, err := parseMinSdkVersionAllowlist(file, string(content))
+		if err != nil {
+			return nil, err
+		}
+		for name, apiLevel := range entries {
+			list[name] = apiLevel
+		}
+	}
+	return list, nil
+}
+
+// parseMinSdkVersionAllowlist parses the content of a min_sdk_version allowlist file. Each line
+// has the name of a module and the finalized API level it supports, separated by whitespace, e.g.
+// "libfoo 30". Empty lines and lines starting with '#' are ignored.
+func parseMinSdkVersionAllowlist(file, content string) (map[string]ApiLevel, error) {
+	entries := make(map[string]ApiLevel)
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<module name> <api level>\", got %q", file, i+1, line)
+		}
+		name := fields[0]
+		apiLevel, err := strconv.Atoi(fields[1])
+		// 10000 stands for the current, unfinalized, API level.
+		if err != nil || apiLevel <= 0 || apiLevel >= FutureApiLevelInt {
+			return nil, fmt.Errorf("%s:%d: invalid API level %q for %q, must be a finalized API level",
+				file, i+1, fields[1], name)
+		}
+		if _, ok := entries[name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate entry for %q", file, i+1, name)
+		}
+		entries[name] = uncheckedFinalApiLevel(apiLevel)
+	}
+	return entries, nil
+}
+
 // Function called while walking an APEX's payload dependencies.
 //
+// `from` depends on `to` with the dependency tag `tag`. `path` is the dependency path from the
+// module being walked to `to`, both included. It is only valid during the call and must be copied
+// to be retained.
+//
 // Return true if the `to` module should be visited, false otherwise.
-type PayloadDepsCallback func(ctx ModuleContext, from blueprint.Module, to ApexModule, externalDep bool) bool
+type PayloadDepsCallback func(ctx ModuleContext, from blueprint.Module, to ApexModule, externalDep bool,
+	tag blueprint.DependencyTag, path []Module) bool
 type WalkPayloadDepsFunc func(ctx ModuleContext, do PayloadDepsCallback)
 
 // ModuleWithMinSdkVersionCheck represents a module that implements min_sdk_version checks
@@ -916,23 +1199,31 @@ func CheckMinSdkVersion(ctx ModuleContext, minSdkVersion ApiLevel, walk WalkPayl
 		return
 	}
 
-	// do not enforce for coverage build
-	if ctx.Config().IsEnvTrue("EMMA_INSTRUMENT") || ctx.DeviceConfig().NativeCoverageEnabled() || ctx.DeviceConfig().ClangCoverageEnabled() {
+	// do not enforce deps.min_sdk_version if APEX/APK doesn't set min_sdk_version
+	if minSdkVersion.IsNone() {
 		return
 	}
 
-	// do not enforce deps.min_sdk_version if APEX/APK doesn't set min_sdk_version
-	if minSdkVersion.IsNone() {
+	allowlist, err := getMinSdkVersionAllowlist(ctx)
+	if err != nil {
+		ctx.ModuleErrorf("%s", err)
 		return
 	}
 
-	walk(ctx, func(ctx ModuleContext, from blueprint.Module, to ApexModule, externalDep bool) bool {
+	walk(ctx, func(ctx ModuleContext, from blueprint.Module, to ApexModule, externalDep bool,
+		tag blueprint.DependencyTag, path []Module) bool {
+
 		if externalDep {
 			// external deps are outside the payload boundary, which is "stable"
 			// interface. We don't have to check min_sdk_version for external
 			// dependencies.
 			return false
 		}
+		if IsCoverageDependency(ctx, from, to, tag) {
+			// The coverage runtime is only in the payload of coverage builds, which are
+			// not released.
+			return false
+		}
 		if am, ok := from.(DepIsInSameApex); ok && !am.DepIsInSameApex(ctx, to) {
 			return false
 		}
@@ -946,7 +1237,7 @@ func CheckMinSdkVersion(ctx ModuleContext, minSdkVersion ApiLevel, walk WalkPayl
 		}
 		if err := to.ShouldSupportSdkVersion(ctx, minSdkVersion); err != nil {
 			toName := ctx.OtherModuleName(to)
-			if ver, ok := minSdkVersionAllowlist[toName]; !ok || ver.GreaterThan(minSdkVersion) {
+			if ver, ok := allowlist[toName]; !ok || ver.GreaterThan(minSdkVersion) {
 				ctx.OtherModuleErrorf(to, "should support min_sdk_version(%v) for %q: %v."+
 					"\n\nDependency path: %s\n\n"+
 					"Consider adding 'min_sdk_version: %q' to %q",
@@ -959,3 +1250,32 @@ func CheckMinSdkVersion(ctx ModuleContext, minSdkVersion ApiLevel, walk WalkPayl
 		return true
 	})
 }
+
+// ApexFsConfig is the ownership, permissions and Linux capabilities of a file in an APEX, which
+// are written to the canned_fs_config of the APEX. Unset fields use the defaults of the APEX.
+type ApexFsConfig struct {
+	// User ID of the owner of the file.
+	Uid *int64
+
+	// Group ID of the owner of the file.
+	Gid *int64
+
+	// Mode of the file in octal, e.g. "0750".
+	Mode *string
+
+	// Linux capabilities of the file as a hexadecimal bit mask, e.g. "0x1000".
+	Capabilities *string
+}
+
+// IsSet returns true if any of the fields is set.
+func (c ApexFsConfig) IsSet() bool {
+	return c.Uid != nil || c.Gid != nil || c.Mode != nil || c.Capabilities != nil
+}
+
+// ApexFsConfigProvider is implemented by modules in the payload of APEXes that need a particular
+// ownership, permissions or capabilities for their files, e.g. privileged binaries.
+type ApexFsConfigProvider interface {
+	// ApexFsConfig returns the config of the file of the module in APEXes, or nil to use the
+	// defaults.
+	ApexFsConfig() *ApexFsConfig
+}
@@ -146,3 +146,56 @@ func Test_mergeApexVariations(t *testing.T) {
 		})
 	}
 }
+
+func TestMinSdkVersionAllowlistFiles(t *testing.T) {
+	fs := map[string][]byte{
+		"vendor/a/allowlist.txt": []byte(`
+			# Comments and empty lines are ignored.
+			libfoo 29
+
+			libbar 30
+		`),
+		"vendor/b/allowlist.txt": []byte(`
+			libbar 31
+		`),
+		"vendor/bad/format.txt":    []byte("libfoo\n"),
+		"vendor/bad/apilevel.txt":  []byte("libfoo S\n"),
+		"vendor/bad/current.txt":   []byte("libfoo 10000\n"),
+		"vendor/bad/duplicate.txt": []byte("libfoo 29\nlibfoo 30\n"),
+	}
+
+	load := func(files ...string) (map[string]ApiLevel, error) {
+		config := TestConfig(t.TempDir(), nil, "", fs)
+		config.productVariables.MinSdkVersionAllowlistFiles = files
+		return getMinSdkVersionAllowlist(&configErrorWrapper{config: config})
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		list, err := load("vendor/a/allowlist.txt", "vendor/b/allowlist.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		AssertStringEquals(t, "libfoo", "29", list["libfoo"].String())
+		// Later files override earlier ones.
+		AssertStringEquals(t, "libbar", "31", list["libbar"].String())
+		// The built-in entries are kept.
+		AssertStringEquals(t, "libzstd", "30", list["libzstd"].String())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for file, expected := range map[string]string{
+			"vendor/bad/format.txt":    `vendor/bad/format.txt:1: expected "<module name> <api level>", got "libfoo"`,
+			"vendor/bad/apilevel.txt":  `vendor/bad/apilevel.txt:1: invalid API level "S" for "libfoo", must be a finalized API level`,
+			"vendor/bad/current.txt":   `vendor/bad/current.txt:1: invalid API level "10000" for "libfoo", must be a finalized API level`,
+			"vendor/bad/duplicate.txt": `vendor/bad/duplicate.txt:2: duplicate entry for "libfoo"`,
+			"vendor/bad/missing.txt":   `min_sdk_version allowlist "vendor/bad/missing.txt" does not exist`,
+		} {
+			_, err := load(file)
+			if err == nil {
+				t.Errorf("%s: expected error %q, got none", file, expected)
+				continue
+			}
+			AssertStringEquals(t, file, expected, err.Error())
+		}
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Merged APEX variations are named after the min_sdk_version of the APEXes they were merged from
+// (e.g. apex29, see mergedName), which makes it hard to find out from a variant name in the build
+// log or the Ninja files which APEXes the variant is built for. Setting
+// SOONG_DUMP_APEX_VARIATIONS=true writes out/soong/apex_variations.txt, which lists for each APEX
+// variant of each module the APEX variations that were merged into it.
+
+func init() {
+	RegisterApexVariationsBuildComponents(InitRegistrationContext)
+}
+
+var PrepareForTestWithApexVariations = FixtureRegisterWithContext(RegisterApexVariationsBuildComponents)
+
+func RegisterApexVariationsBuildComponents(ctx RegistrationContext) {
+	ctx.RegisterSingletonType("apex_variations", apexVariationsSingletonFactory)
+}
+
+func apexVariationsSingletonFactory() Singleton {
+	return &apexVariationsSingleton{}
+}
+
+type apexVariationsSingleton struct{}
+
+func (s *apexVariationsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	if !ctx.Config().IsEnvTrue("SOONG_DUMP_APEX_VARIATIONS") {
+		return
+	}
+
+	var lines []string
+	ctx.VisitAllModules(func(module Module) {
+		apexModule, ok := module.(ApexModule)
+		if !ok {
+			return
+		}
+		constituents := apexModule.ApexVariationConstituents()
+		if len(constituents) == 0 {
+			return
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: %s",
+			ctx.ModuleName(module), ctx.ModuleSubDir(module), strings.Join(constituents, " ")))
+	})
+	sort.Strings(lines)
+
+	var content strings.Builder
+	fmt.Fprintln(&content, "# <module> <variant>: <APEX variations merged into the variant>")
+	for _, line := range lines {
+		fmt.Fprintln(&content, line)
+	}
+
+	path := PathForOutput(ctx, "apex_variations.txt")
+	if err := WriteFileToOutputDir(path, []byte(content.String()), 0666); err != nil {
+		ctx.Errorf("failed to write the APEX variations to %s: %s", path, err)
+	}
+}
@@ -109,6 +109,23 @@ type Arch struct {
 	ArchFeatures []string
 }
 
+// HasArchFeature returns true if the CPU architecture supports the given arch-specific feature,
+// for example "dotprod" or "avx2". It panics if the feature is not a known feature of any
+// architecture, so that typos are caught rather than silently treated as unsupported.
+func (a Arch) HasArchFeature(feature string) bool {
+	known := false
+	for _, features := range archFeatures {
+		if InList(feature, features) {
+			known = true
+			break
+		}
+	}
+	if !known {
+		panic(fmt.Errorf("unknown arch feature %q", feature))
+	}
+	return InList(feature, a.ArchFeatures)
+}
+
 // String returns the Arch as a string.  The value is used as the name of the variant created
 // by archMutator.
 func (a Arch) String() string {
@@ -318,6 +335,10 @@ var (
 	// Android is the OS for target devices that run all of Android, including the Linux kernel
 	// and the Bionic libc runtime.
 	Android = newOsType("android", Device, false, Arm, Arm64, X86, X86_64)
+	// Baremetal is the OS for device components that run without an operating system, e.g.
+	// firmware. Baremetal variants are only created for modules that enable them with
+	// target: { baremetal: { enabled: true } }.
+	Baremetal = newOsType("baremetal", Device, true, Arm64)
 
 	// CommonOS is a pseudo OSType for a common OS variant, which is OsType agnostic and which
 	// has dependencies on all the OS variants.
@@ -521,6 +542,13 @@ func osMutator(bpctx blueprint.BottomUpMutatorContext) {
 	for i, m := range modules {
 		m.base().commonProperties.CompileOS = moduleOSList[i]
 		m.base().setOSProperties(mctx)
+
+		// Make only knows about the Android variants of device modules, baremetal variants are
+		// used through references to their outputs instead of being installed.
+		if moduleOSList[i] == Baremetal {
+			m.base().HideFromMake()
+			m.base().SkipInstall()
+		}
 	}
 
 	if createCommonOSVariant {
@@ -1524,6 +1552,7 @@ func decodeTargetProductVariables(config *config) (map[OsType][]Target, error) {
 		archVariant              *string
 		cpuVariant               *string
 		abi                      []string
+		archFeatures             []string
 		nativeBridgeEnabled      NativeBridgeSupport
 		nativeBridgeHostArchName *string
 		nativeBridgeRelativePath *string
@@ -1539,6 +1568,11 @@ func decodeTargetProductVariables(config *config) (map[OsType][]Target, error) {
 			targetErr = err
 			return
 		}
+		arch, err = addArchFeatures(arch, target.archFeatures)
+		if err != nil {
+			targetErr = err
+			return
+		}
 		nativeBridgeRelativePathStr := String(target.nativeBridgeRelativePath)
 		nativeBridgeHostArchNameStr := String(target.nativeBridgeHostArchName)
 
@@ -1620,6 +1654,17 @@ func decodeTargetProductVariables(config *config) (map[OsType][]Target, error) {
 		}
 	}
 
+	// Optional baremetal target
+	if String(variables.BaremetalArch) != "" {
+		addTarget(targetConfig{
+			os:                  Baremetal,
+			archName:            *variables.BaremetalArch,
+			archVariant:         variables.BaremetalArchVariant,
+			cpuVariant:          variables.BaremetalCpuVariant,
+			nativeBridgeEnabled: NativeBridgeDisabled,
+		})
+	}
+
 	// Optional device targets
 	if variables.DeviceArch != nil && *variables.DeviceArch != "" {
 		// The primary device target.
@@ -1629,6 +1674,7 @@ func decodeTargetProductVariables(config *config) (map[OsType][]Target, error) {
 			archVariant:         variables.DeviceArchVariant,
 			cpuVariant:          variables.DeviceCpuVariant,
 			abi:                 variables.DeviceAbi,
+			archFeatures:        variables.DeviceArchFeatures,
 			nativeBridgeEnabled: NativeBridgeDisabled,
 		})
 
@@ -1640,6 +1686,7 @@ func decodeTargetProductVariables(config *config) (map[OsType][]Target, error) {
 				archVariant:         variables.DeviceSecondaryArchVariant,
 				cpuVariant:          variables.DeviceSecondaryCpuVariant,
 				abi:                 variables.DeviceSecondaryAbi,
+				archFeatures:        variables.DeviceSecondaryArchFeatures,
 				nativeBridgeEnabled: NativeBridgeDisabled,
 			})
 		}
@@ -1804,6 +1851,24 @@ func decodeArch(os OsType, arch string, archVariant, cpuVariant *string, abi []s
 	return a, nil
 }
 
+// addArchFeatures adds the arch features that the product configuration reports for the CPU,
+// e.g. crypto extensions, to those implied by the arch variant. It returns an error if a
+// feature is not a known feature of the arch type. The features come from the DeviceArchFeatures
+// and DeviceSecondaryArchFeatures product variables, which soong_config.mk sets from the
+// TARGET_ARCH_FEATURES and TARGET_2ND_ARCH_FEATURES board variables.
+func addArchFeatures(a Arch, features []string) (Arch, error) {
+	if len(features) == 0 {
+		return a, nil
+	}
+	for _, feature := range features {
+		if validFeatures := archFeatures[a.ArchType]; !InList(feature, validFeatures) {
+			return Arch{}, fmt.Errorf("[%q] unknown arch feature %q, supported features: %q", a.ArchType, feature, validFeatures)
+		}
+	}
+	a.ArchFeatures = FirstUniqueStrings(append(CopyOf(a.ArchFeatures), features...))
+	return a, nil
+}
+
 // filterMultilibTargets takes a list of Targets and a multilib value and returns a new list of
 // Targets containing only those that have the given multilib value.
 func filterMultilibTargets(targets []Target, multilib string) []Target {
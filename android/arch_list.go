@@ -100,6 +100,7 @@ var archFeatures = map[ArchType][]string{
 	},
 	Arm64: {
 		"dotprod",
+		"crypto",
 	},
 	X86: {
 		"ssse3",
@@ -655,3 +655,67 @@ func TestArchProperties(t *testing.T) {
 		})
 	}
 }
+
+func TestArchFeatures(t *testing.T) {
+	testCases := []struct {
+		name         string
+		archVariant  string
+		archFeatures []string
+		expected     []string
+		err          string
+	}{
+		{
+			name:        "arch variant only",
+			archVariant: "armv8-2a-dotprod",
+			expected:    []string{"dotprod"},
+		},
+		{
+			name:         "product features",
+			archVariant:  "armv8-a",
+			archFeatures: []string{"crypto"},
+			expected:     []string{"crypto"},
+		},
+		{
+			name:         "arch variant and product features",
+			archVariant:  "armv8-2a-dotprod",
+			archFeatures: []string{"crypto", "dotprod"},
+			expected:     []string{"dotprod", "crypto"},
+		},
+		{
+			name:         "unknown feature",
+			archVariant:  "armv8-a",
+			archFeatures: []string{"avx2"},
+			err:          `["arm64"] unknown arch feature "avx2", supported features: ["dotprod" "crypto"]`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &config{
+				productVariables: productVariables{
+					HostArch:           proptools.StringPtr("x86_64"),
+					DeviceArch:         proptools.StringPtr("arm64"),
+					DeviceArchVariant:  proptools.StringPtr(tt.archVariant),
+					DeviceArchFeatures: tt.archFeatures,
+				},
+			}
+			determineBuildOS(config)
+			targets, err := decodeTargetProductVariables(config)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			arch := targets[Android][0].Arch
+			AssertDeepEquals(t, "arch features", tt.expected, arch.ArchFeatures)
+			for _, feature := range tt.expected {
+				AssertBoolEquals(t, "HasArchFeature("+feature+")", true, arch.HasArchFeature(feature))
+			}
+			AssertBoolEquals(t, "HasArchFeature(avx2)", false, arch.HasArchFeature("avx2"))
+		})
+	}
+}
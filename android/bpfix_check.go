@@ -0,0 +1,77 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"path/filepath"
+
+	"github.com/google/blueprint"
+)
+
+// Setting SOONG_BPFIX_CHECK=true runs the bpfix fixes on every Android.bp file that defines
+// modules, and fails the bpfix-check and checkbuild targets if a file uses patterns that bpfix
+// would rewrite, showing the rewrite as a diff. Each file is checked by its own build statement,
+// so only the files that changed since the last check are checked again, which makes it possible
+// to keep the Android.bp files clean incrementally instead of running bpfix on the whole tree.
+
+const bpfixCheckEnv = "SOONG_BPFIX_CHECK"
+
+func init() {
+	RegisterSingletonType("bpfix_check", bpfixCheckSingletonFactory)
+}
+
+var (
+	_ = pctx.HostBinToolVariable("bpfixCmd", "bpfix")
+
+	bpfixCheckRule = pctx.AndroidStaticRule("bpfixCheck", blueprint.RuleParams{
+		Command:     "${bpfixCmd} -check $in && touch $out",
+		CommandDeps: []string{"${bpfixCmd}"},
+		Description: "bpfix check $in",
+	})
+)
+
+func bpfixCheckSingletonFactory() Singleton {
+	return &bpfixCheckSingleton{}
+}
+
+type bpfixCheckSingleton struct{}
+
+func (s *bpfixCheckSingleton) GenerateBuildActions(ctx SingletonContext) {
+	if !ctx.Config().IsEnvTrue(bpfixCheckEnv) {
+		return
+	}
+
+	files := make(map[string]bool)
+	ctx.VisitAllModules(func(module Module) {
+		// Files outside of the source tree, e.g. generated ones, are not checked.
+		if file := ctx.BlueprintFile(module); !filepath.IsAbs(file) {
+			files[file] = true
+		}
+	})
+
+	var timestamps Paths
+	for _, file := range SortedStringKeys(files) {
+		timestamp := PathForOutput(ctx, "bpfix_check", file+".timestamp")
+		ctx.Build(pctx, BuildParams{
+			Rule:   bpfixCheckRule,
+			Input:  PathForSource(ctx, file),
+			Output: timestamp,
+		})
+		timestamps = append(timestamps, timestamp)
+	}
+
+	ctx.Phony("bpfix-check", timestamps...)
+	ctx.Phony("checkbuild", timestamps...)
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func TestBpfixCheck(t *testing.T) {
+	prepare := GroupFixturePreparers(
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterModuleType("test", mutatorTestModuleFactory)
+			ctx.RegisterSingletonType("bpfix_check", bpfixCheckSingletonFactory)
+		}),
+		FixtureWithRootAndroidBp(`
+			test {
+				name: "foo",
+			}
+		`),
+		FixtureAddTextFile("dir/Android.bp", `
+			test {
+				name: "bar",
+			}
+
+			test {
+				name: "baz",
+			}
+		`),
+	)
+
+	t.Run("enabled", func(t *testing.T) {
+		result := GroupFixturePreparers(
+			prepare,
+			FixtureMergeEnv(map[string]string{
+				"SOONG_BPFIX_CHECK": "true",
+			}),
+		).RunTest(t)
+
+		singleton := result.SingletonForTests("bpfix_check")
+		check := singleton.Output("bpfix_check/dir/Android.bp.timestamp")
+		AssertPathRelativeToTopEquals(t, "input", "dir/Android.bp", check.Input)
+		AssertStringDoesContain(t, "command", check.RuleParams.Command, "-check")
+		singleton.Output("bpfix_check/Android.bp.timestamp")
+
+		AssertPathsRelativeToTopEquals(t, "bpfix-check phony",
+			[]string{
+				"out/soong/bpfix_check/Android.bp.timestamp",
+				"out/soong/bpfix_check/dir/Android.bp.timestamp",
+			},
+			getPhonyMap(result.Config)["bpfix-check"])
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		result := prepare.RunTest(t)
+
+		singleton := result.SingletonForTests("bpfix_check")
+		if check := singleton.MaybeOutput("bpfix_check/Android.bp.timestamp"); check.Rule != nil {
+			t.Errorf("expected no bpfix check without %s", bpfixCheckEnv)
+		}
+	})
+}
@@ -29,6 +29,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"unicode"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/bootstrap"
@@ -109,6 +110,10 @@ type config struct {
 	// Options configurable with soong.variables
 	productVariables productVariables
 
+	// The product configuration layers that assigned the product variables, see
+	// product_variables_report.go.
+	productVariablesProvenance map[string][]ProductVariableAssignment
+
 	// Only available on configs created by TestConfig
 	TestProductVariables *productVariables
 
@@ -182,7 +187,11 @@ type jsonConfigurable interface {
 }
 
 func loadConfig(config *config) error {
-	return loadFromConfigFile(&config.productVariables, absolutePath(config.ProductVariablesFileName))
+	filename := absolutePath(config.ProductVariablesFileName)
+	if err := loadFromConfigFile(&config.productVariables, filename); err != nil {
+		return err
+	}
+	return loadProductVariablesProvenance(config, filename+productVariablesProvenanceSuffix)
 }
 
 // loadFromConfigFile loads and decodes configuration options from a JSON file
@@ -909,6 +918,19 @@ func (c *config) UnbundledBuildApps() bool {
 	return len(c.productVariables.Unbundled_build_apps) > 0
 }
 
+// UnbundledBuildAppsList returns the apps and APEXes requested by an unbundled build.
+func (c *config) UnbundledBuildAppsList() []string {
+	return c.productVariables.Unbundled_build_apps
+}
+
+// MinimalApexAnalysis returns true if analysis of an unbundled build is limited to the transitive
+// dependencies of the requested apps and APEXes, which is enabled by setting
+// SOONG_MINIMAL_APEX_ANALYSIS=true. Other device modules are disabled, so the build must allow
+// missing dependencies.
+func (c *config) MinimalApexAnalysis() bool {
+	return c.UnbundledBuildApps() && c.AllowMissingDependencies() && c.IsEnvTrue("SOONG_MINIMAL_APEX_ANALYSIS")
+}
+
 // Returns true if building image that aren't bundled with the platform.
 // UnbundledBuild() is always true when this is true.
 func (c *config) UnbundledBuildImage() bool {
@@ -1047,6 +1069,29 @@ func (c *config) ClangTidy() bool {
 	return Bool(c.productVariables.ClangTidy)
 }
 
+// ClangTidyEnabledForPath returns true if clang-tidy is enabled globally by WITH_TIDY for the
+// modules in the given directory. WITH_TIDY_PATHS restricts it to the modules under a list of
+// directories, separated by commas or spaces, so that incremental builds don't run clang-tidy
+// on the whole tree.
+func (c *config) ClangTidyEnabledForPath(path string) bool {
+	if !c.ClangTidy() {
+		return false
+	}
+	paths := strings.FieldsFunc(c.Getenv("WITH_TIDY_PATHS"), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(paths) == 0 {
+		return true
+	}
+	path = strings.TrimSuffix(path, "/") + "/"
+	for _, prefix := range paths {
+		if prefix == "*" || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *config) TidyChecks() string {
 	if c.productVariables.TidyChecks == nil {
 		return ""
@@ -1054,6 +1099,13 @@ func (c *config) TidyChecks() string {
 	return *c.productVariables.TidyChecks
 }
 
+// TidyDirsConfigFiles returns the paths, relative to the top of the source tree, of the files that
+// map directories to the default clang-tidy checks and flags of the modules they contain. They are
+// set by soong_config.mk from PRODUCT_TIDY_DIRS_CONFIG_FILES.
+func (c *config) TidyDirsConfigFiles() []string {
+	return c.productVariables.TidyDirsConfigFiles
+}
+
 func (c *config) LibartImgHostBaseAddress() string {
 	return "0x60000000"
 }
@@ -1389,6 +1441,13 @@ func (c *config) CFIEnabledForPath(path string) bool {
 	return HasAnyPrefix(path, c.productVariables.CFIIncludePaths) && !c.CFIDisabledForPath(path)
 }
 
+func (c *config) CFIEnabledForArch(arch ArchType) bool {
+	if len(c.productVariables.CFIArches) == 0 {
+		return true
+	}
+	return InList(arch.Name, c.productVariables.CFIArches)
+}
+
 func (c *config) MemtagHeapDisabledForPath(path string) bool {
 	if len(c.productVariables.MemtagHeapExcludePaths) == 0 {
 		return false
@@ -1410,6 +1469,20 @@ func (c *config) MemtagHeapSyncEnabledForPath(path string) bool {
 	return HasAnyPrefix(path, c.productVariables.MemtagHeapSyncIncludePaths) && !c.MemtagHeapDisabledForPath(path)
 }
 
+func (c *config) MemtagStackDisabledForPath(path string) bool {
+	if len(c.productVariables.MemtagStackExcludePaths) == 0 {
+		return false
+	}
+	return HasAnyPrefix(path, c.productVariables.MemtagStackExcludePaths)
+}
+
+func (c *config) MemtagStackEnabledForPath(path string) bool {
+	if len(c.productVariables.MemtagStackIncludePaths) == 0 {
+		return false
+	}
+	return HasAnyPrefix(path, c.productVariables.MemtagStackIncludePaths) && !c.MemtagStackDisabledForPath(path)
+}
+
 func (c *config) VendorConfig(name string) VendorConfig {
 	return soongconfig.Config(c.productVariables.VendorVars[name])
 }
@@ -1422,10 +1495,21 @@ func (c *config) AmlAbis() bool {
 	return Bool(c.productVariables.Aml_abis)
 }
 
+// FlattenApex returns true if the APEXes are installed flattened, i.e. with their contents copied
+// directly to the partition instead of as images, which saves mounting them at boot on low-end
+// devices. It is a device-wide mode, and non-updatable APEXes can't be flattened on their own:
+// apexd only activates flattened APEXes when ro.apex.updatable is false, and then it doesn't
+// activate any APEX image.
 func (c *config) FlattenApex() bool {
 	return Bool(c.productVariables.Flatten_apex)
 }
 
+// UpdatableApex returns true if the product supports updating APEXes, i.e. it inherits
+// updatable_apex.mk and sets ro.apex.updatable=true.
+func (c *config) UpdatableApex() bool {
+	return Bool(c.productVariables.UpdatableApex)
+}
+
 func (c *config) ForceApexSymlinkOptimization() bool {
 	return Bool(c.productVariables.ForceApexSymlinkOptimization)
 }
@@ -1434,6 +1518,33 @@ func (c *config) CompressedApex() bool {
 	return Bool(c.productVariables.CompressedApex)
 }
 
+// CompressedApexDisabled returns true if the product disables the compression of the given APEX,
+// even if compressed APEXes are enabled and the APEX is compressible.
+func (c *config) CompressedApexDisabled(apexName string) bool {
+	return InList(apexName, c.productVariables.CompressedApexDisabledList)
+}
+
+// ApexSizeBudget returns the maximum size in bytes of the given APEX set by the product, and
+// whether the product sets one.
+func (c *config) ApexSizeBudget(apexName string) (int64, bool) {
+	budget, ok := c.productVariables.ApexSizeBudgets[apexName]
+	return budget, ok
+}
+
+// SelectedApex returns the name of the module that the product selects to be installed for the
+// APEX with the given apex_name, and whether the product selects one.
+func (c *config) SelectedApex(apexName string) (string, bool) {
+	module, ok := c.productVariables.SelectedApexes[apexName]
+	return module, ok
+}
+
+// MinSdkVersionAllowlistFiles returns the paths, relative to the top of the source tree, of the
+// files that extend the list of modules allowed to not support the min_sdk_version of the APEXes
+// they are included in.
+func (c *config) MinSdkVersionAllowlistFiles() []string {
+	return c.productVariables.MinSdkVersionAllowlistFiles
+}
+
 func (c *config) EnforceSystemCertificate() bool {
 	return Bool(c.productVariables.EnforceSystemCertificate)
 }
@@ -1454,6 +1565,19 @@ func (c *config) InterPartitionJavaLibraryAllowList() []string {
 	return c.productVariables.InterPartitionJavaLibraryAllowList
 }
 
+// EnforceUpdatableApexStubs returns true if the dependencies of the contents of updatable APEXes
+// on modules outside of the APEX must go through stubs or the SDK.
+func (c *config) EnforceUpdatableApexStubs() bool {
+	return Bool(c.productVariables.EnforceUpdatableApexStubs)
+}
+
+// ApexMinSdkVersionSdkLibraryStubs returns true if the contents of updatable APEXes should
+// compile against the prebuilt stubs of java_sdk_library modules for the min_sdk_version of the
+// APEX instead of the stubs built from source.
+func (c *config) ApexMinSdkVersionSdkLibraryStubs() bool {
+	return Bool(c.productVariables.ApexMinSdkVersionSdkLibraryStubs)
+}
+
 func (c *config) InstallExtraFlattenedApexes() bool {
 	return Bool(c.productVariables.InstallExtraFlattenedApexes)
 }
@@ -1701,6 +1825,12 @@ func (c *deviceConfig) GenerateAidlNdkPlatformBackend() bool {
 	return c.config.productVariables.GenerateAidlNdkPlatformBackend
 }
 
+// ReleaseFeatureFlagValues returns the files, relative to the top of the source tree, that
+// provide the default values of feature flags for the current release configuration.
+func (c *config) ReleaseFeatureFlagValues() []string {
+	return c.productVariables.ReleaseFeatureFlagValues
+}
+
 // The ConfiguredJarList struct provides methods for handling a list of (apex, jar) pairs.
 // Such lists are used in the build system for things like bootclasspath jars or system server jars.
 // The apex part is either an apex name, or a special names "platform" or "system_ext". Jar is a
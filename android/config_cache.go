@@ -0,0 +1,142 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"sort"
+	"sync"
+)
+
+// This file provides typed caches for state that is accumulated by mutators and read later in
+// the build, e.g. by singletons or make vars providers.
+//
+// The values are stored in the Config using Once, so each test fixture and each product in a
+// multi-product analysis gets its own copy, and the lock protecting the value is stored
+// alongside it. Package level variables must not be used for this kind of state, as they leak
+// between tests and products.
+
+// ConfigStringList is a handle to a list of strings that is scoped to a Config.
+type ConfigStringList struct {
+	key OnceKey
+}
+
+type configStringList struct {
+	lock sync.Mutex
+	list []string
+}
+
+// NewConfigStringList returns a handle to a new list of strings that is scoped to a Config.
+func NewConfigStringList(name string) ConfigStringList {
+	return ConfigStringList{NewOnceKey(name)}
+}
+
+func (l ConfigStringList) get(config Config) *configStringList {
+	return config.Once(l.key, func() interface{} {
+		return &configStringList{}
+	}).(*configStringList)
+}
+
+// Add appends s to the list for config.
+func (l ConfigStringList) Add(config Config, s string) {
+	list := l.get(config)
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	list.list = append(list.list, s)
+}
+
+// AddUnique appends s to the list for config if it is not already in the list.
+func (l ConfigStringList) AddUnique(config Config, s string) {
+	list := l.get(config)
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	if !InList(s, list.list) {
+		list.list = append(list.list, s)
+	}
+}
+
+// Contains returns true if s is in the list for config.
+func (l ConfigStringList) Contains(config Config, s string) bool {
+	list := l.get(config)
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	return InList(s, list.list)
+}
+
+// Get returns a copy of the list for config in the order the entries were added.
+func (l ConfigStringList) Get(config Config) []string {
+	list := l.get(config)
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	return CopyOf(list.list)
+}
+
+// SortedGet returns a sorted copy of the list for config.
+func (l ConfigStringList) SortedGet(config Config) []string {
+	ret := l.Get(config)
+	sort.Strings(ret)
+	return ret
+}
+
+// ConfigStringMap is a handle to a map from strings to strings that is scoped to a Config.
+type ConfigStringMap struct {
+	key OnceKey
+}
+
+type configStringMap struct {
+	lock sync.Mutex
+	m    map[string]string
+}
+
+// NewConfigStringMap returns a handle to a new map from strings to strings that is scoped to a
+// Config.
+func NewConfigStringMap(name string) ConfigStringMap {
+	return ConfigStringMap{NewOnceKey(name)}
+}
+
+func (m ConfigStringMap) get(config Config) *configStringMap {
+	return config.Once(m.key, func() interface{} {
+		return &configStringMap{m: make(map[string]string)}
+	}).(*configStringMap)
+}
+
+// Set sets the value of key in the map for config to value.
+func (m ConfigStringMap) Set(config Config, key, value string) {
+	cm := m.get(config)
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	cm.m[key] = value
+}
+
+// SetIfAbsent sets the value of key in the map for config to value if key is not already in the
+// map. It returns the value of key in the map after the call.
+func (m ConfigStringMap) SetIfAbsent(config Config, key, value string) string {
+	cm := m.get(config)
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	if existing, ok := cm.m[key]; ok {
+		return existing
+	}
+	cm.m[key] = value
+	return value
+}
+
+// Get returns the value of key in the map for config, and whether key was in the map.
+func (m ConfigStringMap) Get(config Config, key string) (string, bool) {
+	cm := m.get(config)
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	value, ok := cm.m[key]
+	return value, ok
+}
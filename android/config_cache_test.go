@@ -0,0 +1,58 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func TestConfigStringList(t *testing.T) {
+	list := NewConfigStringList("testConfigStringList")
+
+	config1 := TestConfig(t.TempDir(), nil, "", nil)
+	config2 := TestConfig(t.TempDir(), nil, "", nil)
+
+	list.Add(config1, "b")
+	list.Add(config1, "a")
+	list.AddUnique(config1, "b")
+	list.AddUnique(config1, "c")
+
+	AssertArrayString(t, "Get", []string{"b", "a", "c"}, list.Get(config1))
+	AssertArrayString(t, "SortedGet", []string{"a", "b", "c"}, list.SortedGet(config1))
+	AssertBoolEquals(t, "Contains", true, list.Contains(config1, "a"))
+
+	// A different config must not see the values added to the first one.
+	AssertArrayString(t, "Get other config", []string{}, list.Get(config2))
+	AssertBoolEquals(t, "Contains other config", false, list.Contains(config2, "a"))
+}
+
+func TestConfigStringMap(t *testing.T) {
+	m := NewConfigStringMap("testConfigStringMap")
+
+	config1 := TestConfig(t.TempDir(), nil, "", nil)
+	config2 := TestConfig(t.TempDir(), nil, "", nil)
+
+	m.Set(config1, "a", "1")
+	AssertStringEquals(t, "SetIfAbsent existing", "1", m.SetIfAbsent(config1, "a", "2"))
+	AssertStringEquals(t, "SetIfAbsent new", "3", m.SetIfAbsent(config1, "b", "3"))
+
+	value, ok := m.Get(config1, "a")
+	AssertBoolEquals(t, "Get ok", true, ok)
+	AssertStringEquals(t, "Get", "1", value)
+
+	// A different config must not see the values set in the first one.
+	_, ok = m.Get(config2, "a")
+	AssertBoolEquals(t, "Get other config ok", false, ok)
+}
@@ -120,6 +120,12 @@ var (
 
 	// Used for processes that need significant RAM to ensure there are not too many running in parallel.
 	highmemPool = blueprint.NewBuiltinPool("highmem_pool")
+
+	// HighmemPool is highmemPool, for static rules that are not created with RuleBuilder.HighMem.
+	HighmemPool = highmemPool
+
+	// Used for ThinLTO links, which need more RAM than other links but less than highmem processes.
+	LtoLinkPool = blueprint.NewBuiltinPool("lto_link_pool")
 )
 
 func init() {
@@ -0,0 +1,120 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// DIST_MODULES selects outputs of arbitrary modules to dist without editing Android.bp files, e.g.
+// `m dist DIST_MODULES='libfoo{.unstripped} bar'`. Each entry is a module name with an optional
+// output tag, and is resolved through OutputFiles in every enabled variant of the module that
+// supports the tag. The files are dist'ed with droidcore and by the dist-modules goal as
+// <module><tag>/<variant>/<file name>, so that the outputs of different variants and tags don't
+// clash.
+
+func init() {
+	RegisterDistModulesBuildComponents(InitRegistrationContext)
+}
+
+var PrepareForTestWithDistModules = FixtureRegisterWithContext(RegisterDistModulesBuildComponents)
+
+func RegisterDistModulesBuildComponents(ctx RegistrationContext) {
+	ctx.RegisterSingletonType("dist_modules", distModulesSingletonFactory)
+}
+
+func distModulesSingletonFactory() Singleton {
+	return &distModulesSingleton{}
+}
+
+// distModuleFile is an output file of a module selected by DIST_MODULES.
+type distModuleFile struct {
+	path Path
+	dest string
+}
+
+type distModulesSingleton struct {
+	files []distModuleFile
+}
+
+func (s *distModulesSingleton) GenerateBuildActions(ctx SingletonContext) {
+	entries := strings.Fields(ctx.Config().Getenv("DIST_MODULES"))
+	if len(entries) == 0 {
+		return
+	}
+
+	// The tags requested for each module name, in the order they appear in DIST_MODULES.
+	tags := make(map[string][]string)
+	var names []string
+	for _, entry := range entries {
+		name, tag := SrcIsModuleWithTag(":" + entry)
+		if name == "" {
+			ctx.Errorf("DIST_MODULES: invalid entry %q, expected <module> or <module>{<tag>}", entry)
+			continue
+		}
+		if _, exists := tags[name]; !exists {
+			names = append(names, name)
+		}
+		tags[name] = append(tags[name], tag)
+	}
+
+	variants := make(map[string][]Module)
+	ctx.VisitAllModules(func(module Module) {
+		if _, ok := tags[module.Name()]; ok && module.Enabled() {
+			variants[module.Name()] = append(variants[module.Name()], module)
+		}
+	})
+
+	var outputs Paths
+	for _, name := range names {
+		if len(variants[name]) == 0 {
+			ctx.Errorf("DIST_MODULES: module %q does not exist or is not enabled", name)
+			continue
+		}
+		for _, tag := range tags[name] {
+			var lastErr error
+			found := false
+			for _, module := range variants[name] {
+				paths, err := outputFilesForModule(ctx, module, tag)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				found = true
+				for _, path := range paths {
+					s.files = append(s.files, distModuleFile{
+						path: path,
+						dest: filepath.Join(name+tag, ctx.ModuleSubDir(module), path.Base()),
+					})
+					outputs = append(outputs, path)
+				}
+			}
+			if !found {
+				ctx.Errorf("DIST_MODULES: no variant of %q has outputs for tag %q: %s", name, tag, lastErr)
+			}
+		}
+	}
+
+	ctx.Phony("dist-modules", outputs...)
+}
+
+func (s *distModulesSingleton) MakeVars(ctx MakeVarsContext) {
+	for _, file := range s.files {
+		ctx.DistForGoalsWithFilename([]string{"droidcore", "dist-modules"}, file.path, file.dest)
+	}
+}
+
+var _ SingletonMakeVarsProvider = (*distModulesSingleton)(nil)
@@ -0,0 +1,88 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+var prepareForDistModulesTest = GroupFixturePreparers(
+	PrepareForTestWithDistModules,
+	FixtureRegisterWithContext(func(ctx RegistrationContext) {
+		ctx.RegisterModuleType("custom", customModuleFactory)
+	}),
+	FixtureWithRootAndroidBp(`
+		custom {
+			name: "foo",
+		}
+	`),
+)
+
+func TestDistModules(t *testing.T) {
+	result := GroupFixturePreparers(
+		prepareForDistModulesTest,
+		FixtureMergeEnv(map[string]string{
+			"DIST_MODULES": "foo foo{.multiple}",
+		}),
+	).RunTest(t)
+
+	s := result.SingletonForTests("dist_modules").Singleton().(*distModulesSingleton)
+	var dists []string
+	for _, file := range s.files {
+		dists = append(dists, file.path.String()+":"+file.dest)
+	}
+	AssertDeepEquals(t, "dist files", []string{
+		"one.out:foo/one.out",
+		"two.out:foo.multiple/two.out",
+		"three/four.out:foo.multiple/four.out",
+	}, dists)
+}
+
+func TestDistModulesErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		distModules string
+		err         string
+	}{
+		{
+			name:        "missing module",
+			distModules: "bar",
+			err:         `DIST_MODULES: module "bar" does not exist or is not enabled`,
+		},
+		{
+			name:        "unsupported tag",
+			distModules: "foo{.bad}",
+			err:         `DIST_MODULES: no variant of "foo" has outputs for tag ".bad"`,
+		},
+		{
+			name:        "invalid entry",
+			distModules: "//foo:bar",
+			err:         `DIST_MODULES: invalid entry "//foo:bar"`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			GroupFixturePreparers(
+				prepareForDistModulesTest,
+				FixtureMergeEnv(map[string]string{
+					"DIST_MODULES": test.distModules,
+				}),
+			).
+				ExtendWithErrorHandler(FixtureExpectsAtLeastOneErrorMatchingPattern(test.err)).
+				RunTest(t)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+)
+
+// The suffix appended to the dist destination of an artifact to form the destination of its
+// provenance statement.
+const distProvenanceSuffix = ".intoto.json"
+
+var (
+	_ = pctx.HostBinToolVariable("distProvenanceCmd", "gen_dist_provenance")
+
+	distProvenanceRule = pctx.AndroidStaticRule("distProvenanceRule", blueprint.RuleParams{
+		Command: "${distProvenanceCmd} --module_name ${moduleName} --artifact_path $in " +
+			"--command_sha256 ${commandHash} --builder ${builder} @${out}.rsp -o $out",
+		CommandDeps:    []string{"${distProvenanceCmd}"},
+		Rspfile:        "${out}.rsp",
+		RspfileContent: "--materials ${materials} --tools ${tools}",
+	}, "moduleName", "commandHash", "builder", "materials", "tools")
+
+	// The parameters of the rules created with PackageContext.StaticRule and
+	// PackageContext.AndroidRemoteStaticRule, used to reconstruct the commands that built dist'd
+	// artifacts.
+	distProvenanceStaticRuleParams sync.Map
+)
+
+// recordStaticRuleParams remembers the parameters of a rule defined at package scope.
+func recordStaticRuleParams(rule blueprint.Rule, params blueprint.RuleParams) {
+	distProvenanceStaticRuleParams.Store(rule, params)
+}
+
+// hasDistProvenance returns true if any of the module's dist or dists properties request a
+// provenance statement for the dist'd artifacts.
+func (m *ModuleBase) hasDistProvenance() bool {
+	for _, dist := range m.Dists() {
+		if proptools.Bool(dist.Provenance) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDistProvenanceParams remembers the build parameters that produced each output so that
+// a provenance statement can be generated for any of them that end up being dist'd.
+func (m *moduleContext) recordDistProvenanceParams(params BuildParams) {
+	if m.distProvenanceParams == nil {
+		m.distProvenanceParams = make(map[string]BuildParams)
+	}
+	outputs := append(WritablePaths{params.Output}, params.Outputs...)
+	outputs = append(outputs, params.ImplicitOutput)
+	outputs = append(outputs, params.ImplicitOutputs...)
+	for _, output := range outputs {
+		if output != nil {
+			m.distProvenanceParams[output.String()] = params
+		}
+	}
+}
+
+// distProvenanceRuleParams returns the parameters of a rule, whether it was defined by the module
+// or at package scope.
+func (m *moduleContext) distProvenanceRuleParams(rule blueprint.Rule) (blueprint.RuleParams, bool) {
+	if params, ok := m.distProvenanceModuleRuleParams[rule]; ok {
+		return params, true
+	}
+	if params, ok := distProvenanceStaticRuleParams.Load(rule); ok {
+		return params.(blueprint.RuleParams), true
+	}
+	return blueprint.RuleParams{}, false
+}
+
+// expandDistProvenanceCommand substitutes $in, $out and the build arguments into a ninja command
+// template. References to other variables, e.g. package scoped tool paths, are left as is since
+// their values are not known during analysis.
+func expandDistProvenanceCommand(command string, params BuildParams) string {
+	var outputs []string
+	for _, output := range append(WritablePaths{params.Output}, params.Outputs...) {
+		if output != nil {
+			outputs = append(outputs, output.String())
+		}
+	}
+	inputs := PathsIfNonNil(append(Paths{params.Input}, params.Inputs...)...).Strings()
+	vars := map[string]string{
+		"in":         strings.Join(inputs, " "),
+		"in_newline": strings.Join(inputs, "\n"),
+		"out":        strings.Join(outputs, " "),
+	}
+	for k, v := range params.Args {
+		vars[k] = v
+	}
+
+	isVarChar := func(c byte, braced bool) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '_' || c == '-' || braced && c == '.'
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '$' || i+1 == len(command) {
+			sb.WriteByte(command[i])
+			continue
+		}
+		start, braced := i+1, command[i+1] == '{'
+		if braced {
+			start++
+		}
+		end := start
+		for end < len(command) && isVarChar(command[end], braced) {
+			end++
+		}
+		if end == start || braced && (end == len(command) || command[end] != '}') {
+			// Escaped characters such as "$$" and "$ ".
+			sb.WriteString(command[i : i+2])
+			i++
+			continue
+		}
+		name, next := command[start:end], end
+		if braced {
+			next++
+		}
+		if v, ok := vars[name]; ok {
+			sb.WriteString(v)
+		} else {
+			sb.WriteString(command[i:next])
+		}
+		i = next - 1
+	}
+	return sb.String()
+}
+
+// distProvenanceCommand returns the command line that builds an artifact and the tools it runs.
+func distProvenanceCommand(ctx *moduleContext, params BuildParams) (string, []string) {
+	ruleParams, ok := ctx.distProvenanceRuleParams(params.Rule)
+	if !ok {
+		return "", nil
+	}
+	return expandDistProvenanceCommand(ruleParams.Command, params),
+		distProvenanceTools(ruleParams.CommandDeps, params)
+}
+
+// distProvenanceTools returns the paths of the tools in the CommandDeps of a rule. Tools that are
+// referenced through package scoped variables, e.g. ${config.SoongZipCmd}, are left out as their
+// paths are not known during analysis, and they can't be digested. The command hash still covers
+// them by name.
+func distProvenanceTools(commandDeps []string, params BuildParams) []string {
+	var tools []string
+	for _, dep := range commandDeps {
+		if tool := expandDistProvenanceCommand(dep, params); !strings.Contains(tool, "$") {
+			tools = append(tools, tool)
+		}
+	}
+	return SortedUniqueStrings(tools)
+}
+
+// distProvenanceCommandHash returns a digest of the command line used to build an artifact.
+func distProvenanceCommandHash(command string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(command)))
+}
+
+// distProvenanceMaterials returns the sorted, de-duplicated list of inputs that were used to
+// build an artifact, including implicit dependencies such as the tools run by the rule.
+func distProvenanceMaterials(params BuildParams) Paths {
+	var materials Paths
+	materials = append(materials, params.Input)
+	materials = append(materials, params.Inputs...)
+	materials = append(materials, params.Implicits...)
+	materials = append(materials, params.OrderOnly...)
+	materials = PathsIfNonNil(materials...)
+	return SortedUniquePaths(materials)
+}
+
+// generateDistProvenance creates a rule that writes an in-toto statement for each of the paths
+// dist'd by a dist property that requests provenance, describing the digests of the inputs, the
+// command and the versions of the tools used to build it. It returns a map from the string form
+// of each such path to its provenance statement.
+func generateDistProvenance(ctx *moduleContext, dists []Dist, distFiles TaggedDistFiles) map[string]Path {
+	ret := make(map[string]Path)
+	for _, dist := range dists {
+		if !proptools.Bool(dist.Provenance) {
+			continue
+		}
+		tag := proptools.StringDefault(dist.Tag, DefaultDistTag)
+		for _, path := range distFiles[tag] {
+			if path == nil {
+				continue
+			}
+			if _, exists := ret[path.String()]; exists {
+				continue
+			}
+
+			params, ok := ctx.distProvenanceParams[path.String()]
+			commandHash := ""
+			var materials Paths
+			var tools []string
+			builder := "source"
+			if ok {
+				var command string
+				command, tools = distProvenanceCommand(ctx, params)
+				if command != "" {
+					commandHash = distProvenanceCommandHash(command)
+				}
+				materials = distProvenanceMaterials(params)
+				if params.Rule != nil {
+					builder = params.Rule.String()
+				}
+			}
+
+			provenanceFile := PathForModuleOut(ctx, "dist_provenance", path.Rel()+distProvenanceSuffix)
+			ctx.Build(pctx, BuildParams{
+				Rule:        distProvenanceRule,
+				Description: "dist provenance " + path.Base(),
+				Input:       path,
+				Implicits:   materials,
+				Output:      provenanceFile,
+				Args: map[string]string{
+					"moduleName":  ctx.ModuleName(),
+					"commandHash": proptools.NinjaAndShellEscape(commandHash),
+					"builder":     proptools.NinjaAndShellEscape(builder),
+					"materials":   strings.Join(proptools.NinjaEscapeList(materials.Strings()), " "),
+					"tools":       strings.Join(proptools.NinjaEscapeList(tools), " "),
+				},
+			})
+			ret[path.String()] = provenanceFile
+		}
+	}
+	return ret
+}
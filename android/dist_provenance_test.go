@@ -0,0 +1,82 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func TestExpandDistProvenanceCommand(t *testing.T) {
+	ctx := PathContextForTesting(TestConfig("out", nil, "", nil))
+	output := PathForOutput(ctx, "foo.zip")
+	params := BuildParams{
+		Input:  PathForTesting("in.txt"),
+		Inputs: PathsForTesting("a.txt", "b.txt"),
+		Output: output,
+		Args: map[string]string{
+			"flags":  "-v -L 5",
+			"my-dir": "some/dir",
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		command  string
+		expected string
+	}{
+		{
+			name:     "inputs and outputs",
+			command:  "tool $in -o ${out}",
+			expected: "tool in.txt a.txt b.txt -o " + output.String(),
+		},
+		{
+			name:     "arguments",
+			command:  "tool $flags -C ${my-dir}",
+			expected: "tool -v -L 5 -C some/dir",
+		},
+		{
+			name:     "unknown variables are kept",
+			command:  "${config.ClangBin}/clang $cFlags -c $in",
+			expected: "${config.ClangBin}/clang $cFlags -c in.txt a.txt b.txt",
+		},
+		{
+			name:     "escapes are kept",
+			command:  "echo $$HOME$ $flags",
+			expected: "echo $$HOME$ -v -L 5",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			AssertStringEquals(t, "expanded command", tc.expected,
+				expandDistProvenanceCommand(tc.command, params))
+		})
+	}
+}
+
+func TestDistProvenanceTools(t *testing.T) {
+	params := BuildParams{
+		Args: map[string]string{
+			"tool": "out/host/linux-x86/bin/bar",
+		},
+	}
+	tools := distProvenanceTools([]string{
+		"out/host/linux-x86/bin/foo",
+		"${config.SoongZipCmd}",
+		"$tool",
+		"out/host/linux-x86/bin/foo",
+	}, params)
+	AssertDeepEquals(t, "tools", []string{"out/host/linux-x86/bin/bar", "out/host/linux-x86/bin/foo"}, tools)
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The enabled_for_products and enabled_for_devices properties restrict a module to the listed
+// products or devices, e.g.
+//
+//     cc_library {
+//         name: "libfoo_hal",
+//         enabled_for_devices: ["foo", "foo_pro"],
+//     }
+//
+// The module is disabled for the other products and devices, and modules that depend on it
+// report why it is disabled. This replaces the soong_config_module_type wrappers otherwise
+// needed to gate a module on the product.
+
+// enabledForProductsMutator disables the modules whose enabled_for_products or
+// enabled_for_devices properties don't include the current product or device.
+func enabledForProductsMutator(ctx BottomUpMutatorContext) {
+	m := ctx.Module().base()
+	props := &m.commonProperties
+
+	check := func(property string, list []string, current string, what string) {
+		if len(list) == 0 || props.ForcedDisabled {
+			return
+		}
+		for _, entry := range list {
+			if entry == "" || strings.TrimSpace(entry) != entry {
+				ctx.PropertyErrorf(property, "invalid %s name %q", what, entry)
+				return
+			}
+		}
+		if dup := FirstUniqueStrings(list); len(dup) != len(list) {
+			ctx.PropertyErrorf(property, "duplicate %s names in %q", what, list)
+			return
+		}
+		if !InList(current, list) {
+			props.ProductDisabledReason = fmt.Sprintf("not enabled for %s %q (%s: %q)",
+				what, current, property, list)
+			m.Disable()
+		}
+	}
+
+	config := ctx.Config()
+	check("enabled_for_products", props.Enabled_for_products, String(config.productVariables.DeviceProduct), "product")
+	check("enabled_for_devices", props.Enabled_for_devices, String(config.productVariables.DeviceName), "device")
+}
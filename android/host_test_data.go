@@ -0,0 +1,79 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+
+	"github.com/google/blueprint"
+)
+
+// This file implements support for host modules, usually host tests, that use the outputs of
+// device modules as data, for example an APEX used by the tests of apexd or of the APEX parsing
+// code. The host module references the device module with the ":module" syntax in a property
+// tagged with `android:"path"`, like the data property of the host tests:
+//
+//     java_test_host {
+//         name: "apex_parser_tests",
+//         data: [":com.android.foo"],
+//     }
+//
+// If the referenced module has no variant for the host, the dependency is added on its device
+// variant instead, and the reference resolves to the files in the HostTestDataInfo provided by
+// the device module. Device modules that don't set the provider can't be referenced from host
+// modules.
+
+// HostTestDataInfo is provided by device modules whose outputs host modules can use as data.
+type HostTestDataInfo struct {
+	// The files a reference to the module from a host module resolves to. Their base names are
+	// stable, so tests can find them next to the test.
+	Files Paths
+}
+
+var HostTestDataProvider = blueprint.NewProvider(HostTestDataInfo{})
+
+// addHostTestDataDependency adds a dependency from a host module on the device variant of the
+// referenced module if the module has no host variant, returning false if it doesn't apply.
+func addHostTestDataDependency(ctx BottomUpMutatorContext, tag blueprint.DependencyTag, name string) bool {
+	if !ctx.Host() || ctx.OtherModuleDependencyVariantExists(nil, name) {
+		return false
+	}
+	deviceVariations := ctx.Config().AndroidCommonTarget.Variations()
+	if !ctx.OtherModuleFarDependencyVariantExists(deviceVariations, name) {
+		return false
+	}
+	ctx.AddFarVariationDependencies(deviceVariations, tag, name)
+	return true
+}
+
+// hostTestDataFromDeviceModule returns the files that a reference from a host module to a device
+// module resolves to. ok is false if the reference isn't one from a host module to a device
+// module.
+func hostTestDataFromDeviceModule(ctx ModuleWithDepsPathContext, path, tag string, module blueprint.Module) (files Paths, ok bool, err error) {
+	mctx, isModuleContext := ctx.(ModuleContext)
+	if !isModuleContext || !mctx.Host() {
+		return nil, false, nil
+	}
+	if aModule, isModule := module.(Module); !isModule || aModule.Os().Class != Device {
+		return nil, false, nil
+	}
+	if tag != "" {
+		return nil, true, fmt.Errorf("path dependency %q: tags are not supported for device modules used by host modules", path)
+	}
+	if !mctx.OtherModuleHasProvider(module, HostTestDataProvider) {
+		return nil, true, fmt.Errorf("path dependency %q is a device module that doesn't provide host test data", path)
+	}
+	return mctx.OtherModuleProvider(module, HostTestDataProvider).(HostTestDataInfo).Files, true, nil
+}
@@ -62,6 +62,7 @@ func collectMetrics(config Config, eventHandler metrics.EventHandler) *soong_met
 	soongMetrics := ReadSoongMetrics(config)
 	metrics.Modules = proto.Uint32(uint32(soongMetrics.Modules))
 	metrics.Variants = proto.Uint32(uint32(soongMetrics.Variants))
+	metrics.ToolchainExperiments = appliedToolchainExperiments(config)
 
 	memStats := runtime.MemStats{}
 	runtime.ReadMemStats(&memStats)
@@ -488,6 +488,11 @@ type Module interface {
 	Target() Target
 	MultiTargets() []Target
 
+	// AllowedMissingDependencies returns the dependencies of the module that were missing, and
+	// allowed because missing dependencies are allowed. It is only complete once the build actions
+	// of the module have been generated.
+	AllowedMissingDependencies() []string
+
 	// ImageVariation returns the image variation of this module.
 	//
 	// The returned structure has its Mutator field set to "image" and its Variation field set to the
@@ -517,6 +522,7 @@ type Module interface {
 	VintfFragments() Paths
 	NoticeFiles() Paths
 	EffectiveLicenseFiles() Paths
+	EffectiveLicenseKinds() []string
 
 	AddProperties(props ...interface{})
 	GetProperties() []interface{}
@@ -626,6 +632,11 @@ type Dist struct {
 	// default output files provided by the modules, i.e. the result of calling
 	// OutputFiles("").
 	Tag *string `android:"arch_variant"`
+
+	// If true, an in-toto provenance statement listing the digests of the inputs and the
+	// command used to build each artifact is copied to the dist alongside it, with a
+	// ".intoto.json" suffix.
+	Provenance *bool `android:"arch_variant"`
 }
 
 // NamedPath associates a path with a name. e.g. a license text path with a package name
@@ -690,6 +701,14 @@ type commonProperties struct {
 	// and so prevent early detection of changes that have broken those modules.
 	Enabled *bool `android:"arch_variant"`
 
+	// If set, the module is only enabled when building one of the listed products
+	// (TARGET_PRODUCT), and is disabled for the other products.
+	Enabled_for_products []string
+
+	// If set, the module is only enabled when building for one of the listed devices
+	// (TARGET_DEVICE), and is disabled for the other devices.
+	Enabled_for_devices []string
+
 	// Controls the visibility of this module to other modules. Allowable values are one or more of
 	// these formats:
 	//
@@ -899,6 +918,10 @@ type commonProperties struct {
 	// Disabled by mutators. If set to true, it overrides Enabled property.
 	ForcedDisabled bool `blueprint:"mutated"`
 
+	// The reason the module was disabled by the enabled_for_products or enabled_for_devices
+	// properties, reported by modules that depend on it.
+	ProductDisabledReason string `blueprint:"mutated"`
+
 	NamespaceExportedToMake bool `blueprint:"mutated"`
 
 	MissingDeps []string `blueprint:"mutated"`
@@ -1364,6 +1387,13 @@ type ModuleBase struct {
 	// The files to copy to the dist as explicitly specified in the .bp file.
 	distFiles TaggedDistFiles
 
+	// The provenance statements for the dist files, keyed by the string form of the dist file.
+	// Only set if a dist property requests provenance.
+	distProvenanceFiles map[string]Path
+
+	// The dependencies that Blueprint found missing, when missing dependencies are allowed.
+	blueprintMissingDeps []string
+
 	// Used by buildTargetSingleton to create checkbuild and per-directory build targets
 	// Only set on the final variant of each module
 	installTarget    WritablePath
@@ -1462,6 +1492,10 @@ func (m *ModuleBase) GetMissingBp2buildDeps() []string {
 	return FirstUniqueStrings(m.commonProperties.MissingBp2buildDeps)
 }
 
+func (m *ModuleBase) AllowedMissingDependencies() []string {
+	return FirstUniqueStrings(append(CopyOf(m.commonProperties.MissingDeps), m.blueprintMissingDeps...))
+}
+
 func (m *ModuleBase) AddJSONData(d *map[string]interface{}) {
 	(*d)["Android"] = map[string]interface{}{
 		// Properties set in Blueprint or in blueprint of a defaults modules
@@ -1903,6 +1937,17 @@ func (m *ModuleBase) ExportedToMake() bool {
 	return m.commonProperties.NamespaceExportedToMake
 }
 
+// Licenses returns the names of the license modules listed in the licenses property of the
+// module, e.g. for passing them on to the modules it creates.
+func (m *ModuleBase) Licenses() []string {
+	return m.commonProperties.Licenses
+}
+
+// EffectiveLicenseKinds returns the license kinds of the licenses of the module.
+func (m *ModuleBase) EffectiveLicenseKinds() []string {
+	return m.commonProperties.Effective_license_kinds
+}
+
 func (m *ModuleBase) EffectiveLicenseFiles() Paths {
 	result := make(Paths, 0, len(m.commonProperties.Effective_license_text))
 	for _, p := range m.commonProperties.Effective_license_text {
@@ -2202,7 +2247,7 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 	// Temporarily continue to call blueprintCtx.GetMissingDependencies() to maintain the previous behavior of never
 	// reporting missing dependency errors in Blueprint when AllowMissingDependencies == true.
 	// TODO: This will be removed once defaults modules handle missing dependency errors
-	blueprintCtx.GetMissingDependencies()
+	m.blueprintMissingDeps = blueprintCtx.GetMissingDependencies()
 
 	// For the final GenerateAndroidBuildActions pass, require that all visited dependencies Soong modules and
 	// are enabled. Unless the module is a CommonOS variant which may have dependencies on disabled variants
@@ -2217,7 +2262,7 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 
 	desc := "//" + ctx.ModuleDir() + ":" + ctx.ModuleName() + " "
 	var suffix []string
-	if ctx.Os().Class != Device && ctx.Os().Class != Generic {
+	if ctx.Os() != Android && ctx.Os().Class != Generic {
 		suffix = append(suffix, ctx.Os().String())
 	}
 	if !ctx.PrimaryArch() {
@@ -2275,6 +2320,8 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 			return
 		}
 
+		ctx.recordDistProvenance = m.hasDistProvenance()
+
 		m.module.GenerateAndroidBuildActions(ctx)
 		if ctx.Failed() {
 			return
@@ -2301,6 +2348,10 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 			return
 		}
 
+		if ctx.recordDistProvenance {
+			m.distProvenanceFiles = generateDistProvenance(ctx, m.Dists(), m.distFiles)
+		}
+
 		m.installFiles = append(m.installFiles, ctx.installFiles...)
 		m.checkbuildFiles = append(m.checkbuildFiles, ctx.checkbuildFiles...)
 		m.packagingSpecs = append(m.packagingSpecs, ctx.packagingSpecs...)
@@ -2508,6 +2559,13 @@ type moduleContext struct {
 	katiInstalls []katiInstall
 	katiSymlinks []katiInstall
 
+	// Set when a dist property requests provenance, in which case the build parameters of
+	// every rule are recorded in distProvenanceParams keyed by their outputs, and the parameters
+	// of the rules defined by the module in distProvenanceModuleRuleParams.
+	recordDistProvenance           bool
+	distProvenanceParams           map[string]BuildParams
+	distProvenanceModuleRuleParams map[blueprint.Rule]blueprint.RuleParams
+
 	// For tests
 	buildParams []BuildParams
 	ruleParams  map[blueprint.Rule]blueprint.RuleParams
@@ -2676,6 +2734,13 @@ func (m *moduleContext) Rule(pctx PackageContext, name string, params blueprint.
 		m.ruleParams[rule] = params
 	}
 
+	if m.recordDistProvenance {
+		if m.distProvenanceModuleRuleParams == nil {
+			m.distProvenanceModuleRuleParams = make(map[blueprint.Rule]blueprint.RuleParams)
+		}
+		m.distProvenanceModuleRuleParams[rule] = params
+	}
+
 	return rule
 }
 
@@ -2693,6 +2758,10 @@ func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 		m.buildParams = append(m.buildParams, params)
 	}
 
+	if m.recordDistProvenance {
+		m.recordDistProvenanceParams(params)
+	}
+
 	bparams := convertBuildParams(params)
 	err := validateBuildParams(bparams)
 	if err != nil {
@@ -2746,7 +2815,11 @@ func (b *baseModuleContext) validateAndroidModule(module blueprint.Module, tag b
 			if b.Config().AllowMissingDependencies() {
 				b.AddMissingDependencies([]string{b.OtherModuleName(aModule)})
 			} else {
-				b.ModuleErrorf("depends on disabled module %q", b.OtherModuleName(aModule))
+				if reason := aModule.base().commonProperties.ProductDisabledReason; reason != "" {
+					b.ModuleErrorf("depends on disabled module %q, which is %s", b.OtherModuleName(aModule), reason)
+				} else {
+					b.ModuleErrorf("depends on disabled module %q", b.OtherModuleName(aModule))
+				}
 			}
 		}
 		return nil
@@ -3474,6 +3547,22 @@ type SourceFileProducer interface {
 	Srcs() Paths
 }
 
+// A module that implements OutputGlobsProducer generates files whose names are not known until the
+// rule that generates them has run, e.g. a code generator that writes a tree of files. The files
+// that match the output globs declared by the module are packaged into a zip file that is a
+// declared output of the rule, so that modules that use it are rebuilt whenever the set of
+// generated files or their contents change. The zip file is also returned by Srcs or OutputFiles
+// of a module that implements SourceFileProducer or OutputFileProducer.
+type OutputGlobsProducer interface {
+	// OutputGlobs returns the glob patterns, relative to the directory that the files are generated
+	// in, that select the files packaged into OutputGlobsZip.
+	OutputGlobs() []string
+
+	// OutputGlobsZip returns the zip file of the generated files that match OutputGlobs, or an
+	// invalid OptionalPath if the module declares no output globs.
+	OutputGlobsZip() OptionalPath
+}
+
 // A module that implements OutputFileProducer can be referenced from any property that is tagged with `android:"path"`
 // using the ":module" syntax or ":module{.tag}" syntax and provides a list of output files to be used as if they were
 // listed in the property.
@@ -16,6 +16,7 @@ package android
 
 import (
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"testing"
 )
@@ -243,6 +244,63 @@ func TestErrorDependsOnDisabledModule(t *testing.T) {
 		RunTestWithBp(t, bp)
 }
 
+func TestEnabledForProducts(t *testing.T) {
+	prepare := GroupFixturePreparers(prepareForModuleTests, PrepareForTestWithArchMutator)
+
+	t.Run("enabled", func(t *testing.T) {
+		result := prepare.RunTestWithBp(t, `
+			deps {
+				name: "foo",
+				deps: ["bar"],
+			}
+			deps {
+				name: "bar",
+				enabled_for_products: ["test_product", "other_product"],
+				enabled_for_devices: ["test_device"],
+			}
+		`)
+		bar := result.ModuleForTests("bar", "android_common").Module()
+		AssertBoolEquals(t, "bar enabled", true, bar.Enabled())
+	})
+
+	t.Run("disabled for product", func(t *testing.T) {
+		prepare.ExtendWithErrorHandler(FixtureExpectsAtLeastOneErrorMatchingPattern(regexp.QuoteMeta(
+			`module "foo": depends on disabled module "bar", which is not enabled for product "test_product" (enabled_for_products: ["other_product"])`))).
+			RunTestWithBp(t, `
+				deps {
+					name: "foo",
+					deps: ["bar"],
+				}
+				deps {
+					name: "bar",
+					enabled_for_products: ["other_product"],
+				}
+			`)
+	})
+
+	t.Run("disabled for device", func(t *testing.T) {
+		result := prepare.RunTestWithBp(t, `
+			deps {
+				name: "bar",
+				enabled_for_devices: ["other_device"],
+			}
+		`)
+		bar := result.ModuleForTests("bar", "android_common").Module()
+		AssertBoolEquals(t, "bar enabled", false, bar.Enabled())
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		prepare.ExtendWithErrorHandler(FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`enabled_for_devices: invalid device name ""`)).
+			RunTestWithBp(t, `
+				deps {
+					name: "bar",
+					enabled_for_devices: [""],
+				}
+			`)
+	})
+}
+
 func TestValidateCorrectBuildParams(t *testing.T) {
 	config := TestConfig(t.TempDir(), nil, "", nil)
 	pathContext := PathContextForTesting(config)
@@ -53,7 +53,12 @@ func RegisterMutatorsForBazelConversion(ctx *Context, preArchMutators []Register
 		f(mctx)
 	}
 
-	mctx.mutators.registerAll(ctx)
+	// Apply the ordering constraints of the mutators, e.g. of plugins, like in the normal build.
+	mutators, err := orderMutators(mctx.mutators)
+	if err != nil {
+		panic(err)
+	}
+	mutators.registerAll(ctx)
 }
 
 // collateGloballyRegisteredMutators constructs the list of mutators that have been registered
@@ -64,6 +69,16 @@ func collateGloballyRegisteredMutators() sortableComponents {
 
 // collateRegisteredMutators constructs a single list of mutators from the separate lists.
 func collateRegisteredMutators(preArch, preDeps, postDeps, finalDeps []RegisterMutatorFunc) sortableComponents {
+	mutators, err := orderMutators(registerMutatorPhases(preArch, preDeps, postDeps, finalDeps))
+	if err != nil {
+		panic(err)
+	}
+	return mutators
+}
+
+// registerMutatorPhases returns the mutators registered by the functions of each phase, in
+// registration order.
+func registerMutatorPhases(preArch, preDeps, postDeps, finalDeps []RegisterMutatorFunc) sortableComponents {
 	mctx := &registerMutatorsContext{}
 
 	register := func(funcs []RegisterMutatorFunc) {
@@ -74,12 +89,16 @@ func collateRegisteredMutators(preArch, preDeps, postDeps, finalDeps []RegisterM
 
 	register(preArch)
 
+	mctx.phase = preDepsPhase
 	register(preDeps)
 
+	mctx.phase = depsPhase
 	register([]RegisterMutatorFunc{registerDepsMutator})
 
+	mctx.phase = postDepsPhase
 	register(postDeps)
 
+	mctx.phase = finalDepsPhase
 	mctx.finalPhase = true
 	register(finalDeps)
 
@@ -90,6 +109,9 @@ type registerMutatorsContext struct {
 	mutators            sortableComponents
 	finalPhase          bool
 	bazelConversionMode bool
+
+	// The phase of the mutators being registered.
+	phase mutatorPhase
 }
 
 type RegisterMutatorsContext interface {
@@ -175,6 +197,9 @@ var preArch = []RegisterMutatorFunc{
 }
 
 func registerArchMutator(ctx RegisterMutatorsContext) {
+	// Disable the modules that aren't enabled for the current product before any variants are
+	// created.
+	ctx.BottomUp("enabled_for_products", enabledForProductsMutator).Parallel()
 	ctx.BottomUpBlueprint("os", osMutator).Parallel()
 	ctx.BottomUp("image", imageMutator).Parallel()
 	ctx.BottomUpBlueprint("arch", archMutator).Parallel()
@@ -393,10 +418,15 @@ type bottomUpMutatorContext struct {
 	bp blueprint.BottomUpMutatorContext
 	baseModuleContext
 	finalPhase bool
+
+	// The mutator trace if SOONG_DEBUG_MODULE is set, and the variants of the traced module
+	// created by this mutator.
+	trace          *mutatorTrace
+	tracedVariants []Module
 }
 
 func bottomUpMutatorContextFactory(ctx blueprint.BottomUpMutatorContext, a Module,
-	finalPhase, bazelConversionMode bool) BottomUpMutatorContext {
+	finalPhase, bazelConversionMode bool) *bottomUpMutatorContext {
 
 	moduleContext := a.base().baseModuleContextFactory(ctx)
 	moduleContext.bazelConversionMode = bazelConversionMode
@@ -405,6 +435,7 @@ func bottomUpMutatorContextFactory(ctx blueprint.BottomUpMutatorContext, a Modul
 		bp:                ctx,
 		baseModuleContext: a.base().baseModuleContextFactory(ctx),
 		finalPhase:        finalPhase,
+		trace:             getMutatorTrace(ctx.Config().(Config)),
 	}
 }
 
@@ -413,16 +444,30 @@ func (x *registerMutatorsContext) BottomUp(name string, m BottomUpMutator) Mutat
 	bazelConversionMode := x.bazelConversionMode
 	f := func(ctx blueprint.BottomUpMutatorContext) {
 		if a, ok := ctx.Module().(Module); ok {
-			m(bottomUpMutatorContextFactory(ctx, a, finalPhase, bazelConversionMode))
+			actx := bottomUpMutatorContextFactory(ctx, a, finalPhase, bazelConversionMode)
+			if trace := actx.trace.tracedModule(a); trace != nil {
+				before := snapshotMutatorProperties(a)
+				m(actx)
+				if len(actx.tracedVariants) == 0 {
+					trace.logPropertyChanges(ctx.MutatorName(), a, before)
+				}
+				for _, variant := range actx.tracedVariants {
+					trace.logPropertyChanges(ctx.MutatorName(), variant, before)
+				}
+				return
+			}
+			m(actx)
 		}
 	}
 	mutator := &mutator{name: x.mutatorName(name), bottomUpMutator: f}
+	mutator.phase = x.phase
 	x.mutators = append(x.mutators, mutator)
 	return mutator
 }
 
 func (x *registerMutatorsContext) BottomUpBlueprint(name string, m blueprint.BottomUpMutator) MutatorHandle {
 	mutator := &mutator{name: name, bottomUpMutator: m}
+	mutator.phase = x.phase
 	x.mutators = append(x.mutators, mutator)
 	return mutator
 }
@@ -443,10 +488,17 @@ func (x *registerMutatorsContext) TopDown(name string, m TopDownMutator) Mutator
 				bp:                ctx,
 				baseModuleContext: moduleContext,
 			}
+			if trace := getMutatorTrace(ctx.Config().(Config)).tracedModule(a); trace != nil {
+				before := snapshotMutatorProperties(a)
+				m(actx)
+				trace.logPropertyChanges(ctx.MutatorName(), a, before)
+				return
+			}
 			m(actx)
 		}
 	}
 	mutator := &mutator{name: x.mutatorName(name), topDownMutator: f}
+	mutator.phase = x.phase
 	x.mutators = append(x.mutators, mutator)
 	return mutator
 }
@@ -470,6 +522,21 @@ func (mutator *mutator) register(ctx *Context) {
 
 type MutatorHandle interface {
 	Parallel() MutatorHandle
+
+	// After declares that the mutator must run after the named mutators, which must be registered
+	// in the same or an earlier phase. Naming a mutator that is not registered at all is an error
+	// in the build, but is ignored by tests and bp2build, which may only register some of the
+	// mutators.
+	//
+	// Mutators run in the order in which they are registered, which depends on the order of the
+	// package init functions. Plugins should use After and Before to declare where their mutators
+	// must run relative to the mutators of build/soong, so that the build fails instead of silently
+	// misbehaving when those mutators are renamed or reordered.
+	After(names ...string) MutatorHandle
+
+	// Before declares that the mutator must run before the named mutators, which must be
+	// registered in the same or a later phase.
+	Before(names ...string) MutatorHandle
 }
 
 func (mutator *mutator) Parallel() MutatorHandle {
@@ -477,6 +544,16 @@ func (mutator *mutator) Parallel() MutatorHandle {
 	return mutator
 }
 
+func (mutator *mutator) After(names ...string) MutatorHandle {
+	mutator.after = append(mutator.after, names...)
+	return mutator
+}
+
+func (mutator *mutator) Before(names ...string) MutatorHandle {
+	mutator.before = append(mutator.before, names...)
+	return mutator
+}
+
 func RegisterComponentsMutator(ctx RegisterMutatorsContext) {
 	ctx.BottomUp("component-deps", componentDepsMutator).Parallel()
 }
@@ -589,10 +666,14 @@ func (b *bottomUpMutatorContext) Rename(name string) {
 }
 
 func (b *bottomUpMutatorContext) AddDependency(module blueprint.Module, tag blueprint.DependencyTag, name ...string) []blueprint.Module {
+	b.trace.logDependencies(b.MutatorName(), b.Module(), "local", nil, tag, name)
 	return b.bp.AddDependency(module, tag, name...)
 }
 
 func (b *bottomUpMutatorContext) AddReverseDependency(module blueprint.Module, tag blueprint.DependencyTag, name string) {
+	if b.trace != nil && (b.ModuleName() == b.trace.module || name == b.trace.module) {
+		b.trace.logf(b.MutatorName(), b.Module(), "added reverse dependency from %q with tag %T", name, tag)
+	}
 	b.bp.AddReverseDependency(module, tag, name)
 }
 
@@ -611,6 +692,11 @@ func (b *bottomUpMutatorContext) CreateVariations(variations ...string) []Module
 		base.commonProperties.DebugVariations = append(base.commonProperties.DebugVariations, variations[i])
 	}
 
+	if trace := b.trace.tracedModule(b.Module()); trace != nil {
+		trace.logf(b.MutatorName(), b.Module(), "created variations %q", variations)
+		b.tracedVariants = append(b.tracedVariants, aModules...)
+	}
+
 	return aModules
 }
 
@@ -629,6 +715,11 @@ func (b *bottomUpMutatorContext) CreateLocalVariations(variations ...string) []M
 		base.commonProperties.DebugVariations = append(base.commonProperties.DebugVariations, variations[i])
 	}
 
+	if trace := b.trace.tracedModule(b.Module()); trace != nil {
+		trace.logf(b.MutatorName(), b.Module(), "created variations %q", variations)
+		b.tracedVariants = append(b.tracedVariants, aModules...)
+	}
+
 	return aModules
 }
 
@@ -653,6 +744,7 @@ func (b *bottomUpMutatorContext) AddVariationDependencies(variations []blueprint
 		return b.bp.AddFarVariationDependencies(nil, tag, noSelfDeps...)
 	}
 
+	b.trace.logDependencies(b.MutatorName(), b.Module(), "variation", variations, tag, names)
 	return b.bp.AddVariationDependencies(variations, tag, names...)
 }
 
@@ -665,10 +757,15 @@ func (b *bottomUpMutatorContext) AddFarVariationDependencies(variations []bluepr
 		return b.bp.AddFarVariationDependencies(nil, tag, names...)
 	}
 
+	b.trace.logDependencies(b.MutatorName(), b.Module(), "far variation", variations, tag, names)
 	return b.bp.AddFarVariationDependencies(variations, tag, names...)
 }
 
 func (b *bottomUpMutatorContext) AddInterVariantDependency(tag blueprint.DependencyTag, from, to blueprint.Module) {
+	if trace := b.trace.tracedModule(b.Module()); trace != nil {
+		trace.logf(b.MutatorName(), b.Module(), "added inter-variant dependency from %s to %s with tag %T",
+			from.(Module).base(), to.(Module).base(), tag)
+	}
 	b.bp.AddInterVariantDependency(tag, from, to)
 }
 
@@ -681,10 +778,16 @@ func (b *bottomUpMutatorContext) ReplaceDependenciesIf(name string, predicate bl
 }
 
 func (b *bottomUpMutatorContext) AliasVariation(variationName string) {
+	if trace := b.trace.tracedModule(b.Module()); trace != nil {
+		trace.logf(b.MutatorName(), b.Module(), "aliased variation %q", variationName)
+	}
 	b.bp.AliasVariation(variationName)
 }
 
 func (b *bottomUpMutatorContext) CreateAliasVariation(fromVariationName, toVariationName string) {
+	if trace := b.trace.tracedModule(b.Module()); trace != nil {
+		trace.logf(b.MutatorName(), b.Module(), "created alias variation %q to %q", fromVariationName, toVariationName)
+	}
 	b.bp.CreateAliasVariation(fromVariationName, toVariationName)
 }
 
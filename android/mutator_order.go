@@ -0,0 +1,224 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"strings"
+)
+
+// mutatorPhase is the phase in which a mutator is registered, see the phases at the top of
+// mutator.go. A mutator can't be moved to another phase by its ordering constraints.
+type mutatorPhase int
+
+const (
+	preArchPhase mutatorPhase = iota
+	preDepsPhase
+	depsPhase
+	postDepsPhase
+	finalDepsPhase
+)
+
+func (p mutatorPhase) String() string {
+	switch p {
+	case preArchPhase:
+		return "pre-arch"
+	case preDepsPhase:
+		return "pre-deps"
+	case depsPhase:
+		return "deps"
+	case postDepsPhase:
+		return "post-deps"
+	case finalDepsPhase:
+		return "final-deps"
+	default:
+		panic(fmt.Errorf("unknown mutator phase %d", int(p)))
+	}
+}
+
+func (mutator *mutator) hasOrderingConstraints() bool {
+	return len(mutator.after) > 0 || len(mutator.before) > 0
+}
+
+// orderMutators reorders the mutators to satisfy the constraints set with MutatorHandle.After
+// and MutatorHandle.Before.
+//
+// The mutators without constraints keep their registration order. Each mutator with constraints
+// stays in the phase it was registered in, and is moved as little as possible from its
+// registration position to run after and before the mutators it names. Naming a mutator of a
+// phase that conflicts with the constraint or constraints that form a cycle is an error.
+//
+// Constraints on mutators that are not registered are ignored, as tests and bp2build only
+// register some of the mutators. The full list of mutators is checked for them by
+// checkMutatorConstraintNames.
+func orderMutators(components sortableComponents) (sortableComponents, error) {
+	mutators := make([]*mutator, len(components))
+	indexes := make(map[string]int)
+	for i, c := range components {
+		m := c.(*mutator)
+		mutators[i] = m
+		if _, exists := indexes[m.name]; !exists {
+			indexes[m.name] = i
+		}
+	}
+	constrained := false
+	for _, m := range mutators {
+		known := func(name string) bool {
+			_, ok := indexes[name]
+			return ok
+		}
+		m.after = FilterListPred(m.after, known)
+		m.before = FilterListPred(m.before, known)
+		constrained = constrained || m.hasOrderingConstraints()
+	}
+	if !constrained {
+		return components, nil
+	}
+
+	// A graph where an edge from a mutator to another means that the former must run first.
+	succs := make([][]int, len(mutators))
+	preds := make([]int, len(mutators))
+	addEdge := func(from, to int) {
+		succs[from] = append(succs[from], to)
+		preds[to]++
+	}
+
+	for i, m := range mutators {
+		for _, name := range m.after {
+			j := indexes[name]
+			if other := mutators[j]; other.phase > m.phase {
+				return nil, fmt.Errorf("mutator %q in the %s phase cannot run after mutator %q in the later %s phase",
+					m.name, m.phase, other.name, other.phase)
+			}
+			addEdge(j, i)
+		}
+		for _, name := range m.before {
+			j := indexes[name]
+			if other := mutators[j]; other.phase < m.phase {
+				return nil, fmt.Errorf("mutator %q in the %s phase cannot run before mutator %q in the earlier %s phase",
+					m.name, m.phase, other.name, other.phase)
+			}
+			addEdge(i, j)
+		}
+	}
+
+	// Chain the mutators without constraints in their registration order, and keep the mutators
+	// with constraints between the ones of the earlier and later phases.
+	prev := -1
+	for i, m := range mutators {
+		if m.hasOrderingConstraints() {
+			continue
+		}
+		if prev >= 0 {
+			addEdge(prev, i)
+		}
+		prev = i
+	}
+	for i, m := range mutators {
+		if !m.hasOrderingConstraints() {
+			continue
+		}
+		for j := len(mutators) - 1; j >= 0; j-- {
+			if other := mutators[j]; !other.hasOrderingConstraints() && other.phase < m.phase {
+				addEdge(j, i)
+				break
+			}
+		}
+		for j, other := range mutators {
+			if !other.hasOrderingConstraints() && other.phase > m.phase {
+				addEdge(i, j)
+				break
+			}
+		}
+	}
+
+	// Topological sort that picks the first registered mutator when there is a choice.
+	ordered := make(sortableComponents, 0, len(mutators))
+	done := make([]bool, len(mutators))
+	for len(ordered) < len(mutators) {
+		next := -1
+		for i := range mutators {
+			if !done[i] && preds[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			return nil, fmt.Errorf("the ordering constraints of mutators form a cycle between %s",
+				strings.Join(mutatorsInCycles(mutators, succs, done), ", "))
+		}
+		done[next] = true
+		ordered = append(ordered, mutators[next])
+		for _, j := range succs[next] {
+			preds[j]--
+		}
+	}
+	return ordered, nil
+}
+
+// mutatorsInCycles returns the names of the mutators that are not done and are part of a cycle,
+// by dropping the mutators that only lead to mutators that are done or dropped.
+func mutatorsInCycles(mutators []*mutator, succs [][]int, done []bool) []string {
+	dropped := append([]bool(nil), done...)
+	for changed := true; changed; {
+		changed = false
+		for i := range mutators {
+			if dropped[i] {
+				continue
+			}
+			leadsToCycle := false
+			for _, j := range succs[i] {
+				if !dropped[j] {
+					leadsToCycle = true
+					break
+				}
+			}
+			if !leadsToCycle {
+				dropped[i] = true
+				changed = true
+			}
+		}
+	}
+	var names []string
+	for i, m := range mutators {
+		if !dropped[i] {
+			names = append(names, m.name)
+		}
+	}
+	return names
+}
+
+// checkMutatorConstraintNames returns an error if a mutator has an ordering constraint on a
+// mutator that is not registered. It must only be called with the full list of mutators.
+func checkMutatorConstraintNames(components sortableComponents) error {
+	registered := make(map[string]bool)
+	for _, c := range components {
+		registered[c.(*mutator).name] = true
+	}
+	for _, c := range components {
+		m := c.(*mutator)
+		for _, name := range m.after {
+			if !registered[name] {
+				return fmt.Errorf("mutator %q must run after unknown mutator %q", m.name, name)
+			}
+		}
+		for _, name := range m.before {
+			if !registered[name] {
+				return fmt.Errorf("mutator %q must run before unknown mutator %q", m.name, name)
+			}
+		}
+	}
+	return nil
+}
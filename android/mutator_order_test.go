@@ -0,0 +1,168 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func TestOrderMutators(t *testing.T) {
+	noop := func(BottomUpMutatorContext) {}
+
+	collate := func(preDeps, postDeps []RegisterMutatorFunc) (names []string, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = r.(error)
+			}
+		}()
+		mutators := collateRegisteredMutators(nil, preDeps, postDeps, nil)
+		return componentsToNames(mutators), nil
+	}
+
+	core := func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("a", noop)
+		ctx.BottomUp("b", noop)
+		ctx.BottomUp("c", noop)
+	}
+	coreFinal := func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("x", noop)
+		ctx.BottomUp("y", noop)
+	}
+
+	testCases := []struct {
+		name     string
+		preDeps  []RegisterMutatorFunc
+		postDeps []RegisterMutatorFunc
+		expected []string
+		err      string
+	}{
+		{
+			name:     "no constraints",
+			preDeps:  []RegisterMutatorFunc{core},
+			postDeps: []RegisterMutatorFunc{coreFinal},
+			expected: []string{"a", "b", "c", "deps", "x", "y"},
+		},
+		{
+			name: "before",
+			preDeps: []RegisterMutatorFunc{core, func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin", noop).Before("b")
+			}},
+			postDeps: []RegisterMutatorFunc{coreFinal},
+			expected: []string{"a", "plugin", "b", "c", "deps", "x", "y"},
+		},
+		{
+			name: "after and before",
+			preDeps: []RegisterMutatorFunc{func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin", noop).After("a").Before("c")
+			}, core},
+			postDeps: []RegisterMutatorFunc{coreFinal},
+			expected: []string{"a", "plugin", "b", "c", "deps", "x", "y"},
+		},
+		{
+			name:    "after stays in its phase",
+			preDeps: []RegisterMutatorFunc{core},
+			postDeps: []RegisterMutatorFunc{func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin", noop).After("a")
+			}, coreFinal},
+			expected: []string{"a", "b", "c", "deps", "plugin", "x", "y"},
+		},
+		{
+			name: "between plugins",
+			preDeps: []RegisterMutatorFunc{core, func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin2", noop).After("plugin1")
+				ctx.BottomUp("plugin1", noop).Before("c")
+			}},
+			expected: []string{"a", "b", "plugin1", "c", "plugin2", "deps"},
+		},
+		{
+			// Tests only register some of the mutators, see TestCheckMutatorConstraintNames.
+			name: "unknown mutator",
+			preDeps: []RegisterMutatorFunc{func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin", noop).After("strip").Before("a")
+			}, core},
+			expected: []string{"plugin", "a", "b", "c", "deps"},
+		},
+		{
+			name:    "before earlier phase",
+			preDeps: []RegisterMutatorFunc{core},
+			postDeps: []RegisterMutatorFunc{coreFinal, func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin", noop).Before("b")
+			}},
+			err: `mutator "plugin" in the post-deps phase cannot run before mutator "b" in the earlier pre-deps phase`,
+		},
+		{
+			name: "after later phase",
+			preDeps: []RegisterMutatorFunc{core, func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin", noop).After("x")
+			}},
+			postDeps: []RegisterMutatorFunc{coreFinal},
+			err:      `mutator "plugin" in the pre-deps phase cannot run after mutator "x" in the later post-deps phase`,
+		},
+		{
+			name: "cycle",
+			preDeps: []RegisterMutatorFunc{core, func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin1", noop).After("plugin2")
+				ctx.BottomUp("plugin2", noop).After("plugin1")
+			}},
+			err: "the ordering constraints of mutators form a cycle between plugin1, plugin2",
+		},
+		{
+			name: "conflict with registration order",
+			preDeps: []RegisterMutatorFunc{core, func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("plugin", noop).After("c").Before("a")
+			}},
+			err: "the ordering constraints of mutators form a cycle between a, b, c, plugin",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			names, err := collate(test.preDeps, test.postDeps)
+			if test.err != "" {
+				if err == nil {
+					t.Fatalf("expected error %q, got none", test.err)
+				}
+				AssertStringEquals(t, "error", test.err, err.Error())
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			AssertDeepEquals(t, "order", test.expected, names)
+		})
+	}
+}
+
+func TestCheckMutatorConstraintNames(t *testing.T) {
+	noop := func(BottomUpMutatorContext) {}
+
+	mutators := registerMutatorPhases(nil, []RegisterMutatorFunc{func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("a", noop)
+		ctx.BottomUp("plugin", noop).After("a")
+	}}, nil, nil)
+	if err := checkMutatorConstraintNames(mutators); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	mutators = registerMutatorPhases(nil, []RegisterMutatorFunc{func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("a", noop)
+		ctx.BottomUp("plugin", noop).After("a").Before("strip")
+	}}, nil, nil)
+	err := checkMutatorConstraintNames(mutators)
+	if err == nil {
+		t.Fatalf("expected an error, got none")
+	}
+	AssertStringEquals(t, "error", `mutator "plugin" must run before unknown mutator "strip"`, err.Error())
+}
@@ -79,6 +79,8 @@ func TestMutatorAddMissingDependencies(t *testing.T) {
 	foo := result.ModuleForTests("foo", "").Module().(*mutatorTestModule)
 
 	AssertDeepEquals(t, "foo missing deps", []string{"added_missing_dep", "regular_missing_dep"}, foo.missingDeps)
+	AssertDeepEquals(t, "foo allowed missing deps", []string{"added_missing_dep", "regular_missing_dep"},
+		foo.AllowedMissingDependencies())
 }
 
 func TestModuleString(t *testing.T) {
@@ -0,0 +1,166 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+)
+
+// Setting SOONG_DEBUG_MODULE=<name> records the effect of every mutator on the module with the
+// given name into out/soong/mutator_trace/<name>.txt: the variants that were created, the
+// properties that were modified and the dependencies that were added, both from and to the
+// module. This makes it possible to find out why a variant or a dependency exists without
+// adding printfs to Soong.
+
+func init() {
+	RegisterSingletonType("mutator_trace", mutatorTraceSingletonFactory)
+}
+
+var mutatorTraceKey = NewOnceKey("mutatorTrace")
+
+type mutatorTrace struct {
+	// The name of the traced module.
+	module string
+
+	lock  sync.Mutex
+	lines []string
+}
+
+// getMutatorTrace returns the trace for the config, or nil if SOONG_DEBUG_MODULE is not set.
+func getMutatorTrace(config Config) *mutatorTrace {
+	return config.Once(mutatorTraceKey, func() interface{} {
+		if module := config.Getenv("SOONG_DEBUG_MODULE"); module != "" {
+			return &mutatorTrace{module: module}
+		}
+		return (*mutatorTrace)(nil)
+	}).(*mutatorTrace)
+}
+
+// tracedModule returns the trace if module is the traced module, or nil otherwise.
+func (t *mutatorTrace) tracedModule(module Module) *mutatorTrace {
+	if t == nil || module.Name() != t.module {
+		return nil
+	}
+	return t
+}
+
+func (t *mutatorTrace) logf(mutator string, module Module, format string, args ...interface{}) {
+	line := fmt.Sprintf("[%s] %s: ", mutator, module.base().String()) + fmt.Sprintf(format, args...)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.lines = append(t.lines, line)
+}
+
+// logDependencies records the dependencies added by a mutator if they are added from or to the
+// traced module.
+func (t *mutatorTrace) logDependencies(mutator string, module Module, kind string,
+	variations []blueprint.Variation, tag blueprint.DependencyTag, names []string) {
+
+	if t == nil || (module.Name() != t.module && !InList(t.module, names)) {
+		return
+	}
+	var variationStrings []string
+	for _, v := range variations {
+		variationStrings = append(variationStrings, v.Mutator+":"+v.Variation)
+	}
+	t.logf(mutator, module, "added %s dependencies on %q with tag %T and variations [%s]",
+		kind, names, tag, strings.Join(variationStrings, ","))
+}
+
+// mutatorPropertySnapshot contains the JSON representation of each field of the properties of a
+// module, keyed by the name of the property struct and the field, used to find the properties
+// modified by a mutator.
+type mutatorPropertySnapshot map[string]string
+
+func snapshotMutatorProperties(module Module) mutatorPropertySnapshot {
+	snapshot := make(mutatorPropertySnapshot)
+	for _, props := range module.GetProperties() {
+		v := reflect.ValueOf(props)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		v = v.Elem()
+		prefix := ""
+		if name := v.Type().Name(); name != "" {
+			prefix = name + "."
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				// Unexported fields can't be modified by other packages.
+				continue
+			}
+			// Fields that can't be represented in JSON (e.g. functions) are ignored.
+			if b, err := json.Marshal(v.Field(i).Interface()); err == nil {
+				snapshot[prefix+field.Name] = string(b)
+			}
+		}
+	}
+	return snapshot
+}
+
+// logPropertyChanges records the properties of module that are different from the snapshot taken
+// before the mutator ran.
+func (t *mutatorTrace) logPropertyChanges(mutator string, module Module, before mutatorPropertySnapshot) {
+	after := snapshotMutatorProperties(module)
+	for _, name := range SortedStringKeys(after) {
+		if old, ok := before[name]; ok && old != after[name] {
+			t.logf(mutator, module, "modified property %s: %s -> %s", name, old, after[name])
+		}
+	}
+}
+
+func mutatorTraceSingletonFactory() Singleton {
+	return &mutatorTraceSingleton{}
+}
+
+type mutatorTraceSingleton struct{}
+
+func (s *mutatorTraceSingleton) GenerateBuildActions(ctx SingletonContext) {
+	trace := getMutatorTrace(ctx.Config())
+	if trace == nil {
+		return
+	}
+
+	var variants []string
+	ctx.VisitAllModules(func(module Module) {
+		if module.Name() == trace.module {
+			variants = append(variants, module.base().String())
+		}
+	})
+	sort.Strings(variants)
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "Mutator trace of %s\n\n", trace.module)
+	for _, line := range trace.lines {
+		fmt.Fprintln(&content, line)
+	}
+	fmt.Fprintf(&content, "\nFinal variants:\n")
+	for _, variant := range variants {
+		fmt.Fprintf(&content, "  %s\n", variant)
+	}
+
+	path := PathForOutput(ctx, "mutator_trace", trace.module+".txt")
+	if err := WriteFileToOutputDir(path, []byte(content.String()), 0666); err != nil {
+		ctx.Errorf("failed to write the mutator trace to %s: %s", path, err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMutatorTrace(t *testing.T) {
+	bp := `
+		test {
+			name: "foo",
+		}
+
+		test {
+			name: "bar",
+			deps_missing_deps: ["foo"],
+		}
+
+		test {
+			name: "baz",
+		}
+	`
+
+	result := GroupFixturePreparers(
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterModuleType("test", mutatorTestModuleFactory)
+			ctx.RegisterSingletonType("mutator_trace", mutatorTraceSingletonFactory)
+			ctx.PreArchMutators(func(ctx RegisterMutatorsContext) {
+				ctx.BottomUp("variants", func(ctx BottomUpMutatorContext) {
+					variants := ctx.CreateVariations("a", "b")
+					if ctx.ModuleName() == "foo" {
+						variants[1].(*mutatorTestModule).props.Deps_missing_deps = []string{"baz"}
+					}
+				})
+			})
+		}),
+		FixtureMergeEnv(map[string]string{
+			"SOONG_DEBUG_MODULE": "foo",
+		}),
+		FixtureWithRootAndroidBp(bp),
+	).RunTest(t)
+
+	content, err := ioutil.ReadFile(filepath.Join(result.Config.SoongOutDir(), "mutator_trace", "foo.txt"))
+	if err != nil {
+		t.Fatalf("failed to read the mutator trace: %s", err)
+	}
+	trace := string(content)
+
+	AssertStringDoesContain(t, "created variations", trace,
+		`[variants] foo{}: created variations ["a" "b"]`)
+	AssertStringDoesContain(t, "modified property", trace,
+		`[variants] foo{variants:b}: modified property Deps_missing_deps: null -> ["baz"]`)
+	AssertStringDoesNotContain(t, "unmodified variant", trace,
+		`[variants] foo{variants:a}: modified property`)
+	AssertStringDoesContain(t, "added dependency", trace,
+		`[deps] bar{variants:a}: added local dependencies on ["foo"] with tag <nil> and variations []`)
+	AssertStringDoesContain(t, "dependency from traced module", trace,
+		`[deps] foo{variants:b}: added local dependencies on ["baz"] with tag <nil> and variations []`)
+	AssertStringDoesContain(t, "final variants", trace, "Final variants:\n  foo{variants:a}\n  foo{variants:b}\n")
+}
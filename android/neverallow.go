@@ -247,7 +247,8 @@ func neverallowMutator(ctx BottomUpMutatorContext) {
 	dir := ctx.ModuleDir() + "/"
 	properties := m.GetProperties()
 
-	osClass := ctx.Module().Target().Os.Class
+	os := ctx.Module().Target().Os
+	osClass := os.Class
 
 	for _, r := range neverallowRules(ctx.Config()) {
 		n := r.(*rule)
@@ -267,6 +268,10 @@ func neverallowMutator(ctx BottomUpMutatorContext) {
 			continue
 		}
 
+		if !n.appliesToOs(os) {
+			continue
+		}
+
 		if !n.appliesToDirectDeps(ctx) {
 			continue
 		}
@@ -382,6 +387,8 @@ type Rule interface {
 
 	WithOsClass(osClasses ...OsClass) Rule
 
+	WithOs(osTypes ...OsType) Rule
+
 	ModuleType(types ...string) Rule
 
 	NotModuleType(types ...string) Rule
@@ -408,6 +415,8 @@ type rule struct {
 
 	osClasses []OsClass
 
+	osTypes []OsType
+
 	moduleTypes       []string
 	unlessModuleTypes []string
 
@@ -448,6 +457,12 @@ func (r *rule) WithOsClass(osClasses ...OsClass) Rule {
 	return r
 }
 
+// WithOs adds os type(s) that this rule applies to.
+func (r *rule) WithOs(osTypes ...OsType) Rule {
+	r.osTypes = append(r.osTypes, osTypes...)
+	return r
+}
+
 // ModuleType adds type(s) that this rule applies to.
 func (r *rule) ModuleType(types ...string) Rule {
 	r.moduleTypes = append(r.moduleTypes, types...)
@@ -518,6 +533,9 @@ func (r *rule) String() string {
 	if len(r.osClasses) > 0 {
 		s = append(s, fmt.Sprintf("os class(es): %q", r.osClasses))
 	}
+	if len(r.osTypes) > 0 {
+		s = append(s, fmt.Sprintf("os type(s): %q", r.osTypes))
+	}
 	if len(r.unlessPaths) > 0 {
 		s = append(s, fmt.Sprintf("EXCEPT in dirs: %q", r.unlessPaths))
 	}
@@ -572,6 +590,20 @@ func (r *rule) appliesToOsClass(osClass OsClass) bool {
 	return false
 }
 
+func (r *rule) appliesToOs(os OsType) bool {
+	if len(r.osTypes) == 0 {
+		return true
+	}
+
+	for _, t := range r.osTypes {
+		if t == os {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (r *rule) appliesToModuleType(moduleType string) bool {
 	return (len(r.moduleTypes) == 0 || InList(moduleType, r.moduleTypes)) && !InList(moduleType, r.unlessModuleTypes)
 }
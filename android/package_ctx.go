@@ -230,9 +230,11 @@ func (p PackageContext) AndroidStaticRule(name string, params blueprint.RulePara
 // StaticRule wraps blueprint.StaticRule and provides a default Pool if none is specified.
 func (p PackageContext) StaticRule(name string, params blueprint.RuleParams,
 	argNames ...string) blueprint.Rule {
-	return p.RuleFunc(name, func(PackageRuleContext) blueprint.RuleParams {
+	rule := p.RuleFunc(name, func(PackageRuleContext) blueprint.RuleParams {
 		return params
 	}, argNames...)
+	recordStaticRuleParams(rule, params)
+	return rule
 }
 
 // RemoteRuleSupports configures rules with whether they have Goma and/or RBE support.
@@ -246,7 +248,7 @@ type RemoteRuleSupports struct {
 func (p PackageContext) AndroidRemoteStaticRule(name string, supports RemoteRuleSupports, params blueprint.RuleParams,
 	argNames ...string) blueprint.Rule {
 
-	return p.PackageContext.RuleFunc(name, func(config interface{}) (blueprint.RuleParams, error) {
+	rule := p.PackageContext.RuleFunc(name, func(config interface{}) (blueprint.RuleParams, error) {
 		ctx := &configErrorWrapper{p, config.(Config), nil}
 		if ctx.Config().UseGoma() && !supports.Goma {
 			// When USE_GOMA=true is set and the rule is not supported by goma, restrict jobs to the
@@ -262,6 +264,8 @@ func (p PackageContext) AndroidRemoteStaticRule(name string, supports RemoteRule
 
 		return params, nil
 	}, argNames...)
+	recordStaticRuleParams(rule, params)
+	return rule
 }
 
 // RemoteStaticRules returns a pair of rules based on the given RuleParams, where the first rule is a
@@ -51,6 +51,9 @@ func addPathDepsForProps(ctx BottomUpMutatorContext, props []interface{}) {
 	// Add dependencies to anything that is a module reference.
 	for _, s := range pathProperties {
 		if m, t := SrcIsModuleWithTag(s); m != "" {
+			if addHostTestDataDependency(ctx, sourceOrOutputDepTag(m, t), m) {
+				continue
+			}
 			ctx.AddDependency(ctx.Module(), sourceOrOutputDepTag(m, t), m)
 		}
 	}
@@ -495,6 +495,9 @@ func getPathsFromModuleDep(ctx ModuleWithDepsPathContext, path, moduleName, tag
 	if aModule, ok := module.(Module); ok && !aModule.Enabled() {
 		return nil, missingDependencyError{[]string{moduleName}}
 	}
+	if files, ok, err := hostTestDataFromDeviceModule(ctx, path, tag, module); ok {
+		return files, err
+	}
 	if outProducer, ok := module.(OutputFileProducer); ok {
 		outputFiles, err := outProducer.OutputFiles(tag)
 		if err != nil {
@@ -1162,6 +1165,19 @@ func ExistentPathForSource(ctx PathContext, pathComponents ...string) OptionalPa
 	return OptionalPathForPath(path)
 }
 
+// ReadFileFromSourceTree returns the contents of a file in the source tree, e.g. one returned by
+// ExistentPathForSource. A dependency is added so that the ninja file will be regenerated if the
+// contents of the file change.
+func ReadFileFromSourceTree(ctx PathContext, path Path) ([]byte, error) {
+	ctx.AddNinjaFileDeps(path.String())
+	r, err := ctx.Config().fs.Open(path.String())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
 func (p SourcePath) String() string {
 	return filepath.Join(p.srcDir, p.path)
 }
@@ -1474,6 +1490,23 @@ func pathForModuleOut(ctx ModuleOutPathContext) OutputPath {
 func PathForVndkRefAbiDump(ctx ModuleInstallPathContext, version, fileName string,
 	isNdk, isLlndkOrVndk, isGzip bool) OptionalPath {
 
+	return ExistentPathForSource(ctx, vndkRefAbiDumpPathComponents(ctx, version, fileName,
+		isNdk, isLlndkOrVndk, isGzip)...)
+}
+
+// PathForVndkRefAbiDumpUpdate returns the path in the output directory that the updated reference
+// abi dump for the given module is written to. It mirrors the path of the reference abi dump in the
+// source tree under abi-references, where the updated dumps are moved from into the source tree.
+func PathForVndkRefAbiDumpUpdate(ctx ModuleInstallPathContext, version, fileName string,
+	isNdk, isLlndkOrVndk, isGzip bool) OutputPath {
+
+	return PathForOutput(ctx, append([]string{"abi-references"}, vndkRefAbiDumpPathComponents(ctx,
+		version, fileName, isNdk, isLlndkOrVndk, isGzip)...)...)
+}
+
+func vndkRefAbiDumpPathComponents(ctx ModuleInstallPathContext, version, fileName string,
+	isNdk, isLlndkOrVndk, isGzip bool) []string {
+
 	currentArchType := ctx.Arch().ArchType
 	primaryArchType := ctx.Config().DevicePrimaryArchType()
 	archName := currentArchType.String()
@@ -1499,9 +1532,9 @@ func PathForVndkRefAbiDump(ctx ModuleInstallPathContext, version, fileName strin
 		ext = ".lsdump"
 	}
 
-	return ExistentPathForSource(ctx, "prebuilts", "abi-dumps", dirName,
+	return []string{"prebuilts", "abi-dumps", dirName,
 		version, binderBitness, archName, "source-based",
-		fileName+ext)
+		fileName + ext}
 }
 
 // PathForModuleOut returns a Path representing the paths... under the module's
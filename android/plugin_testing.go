@@ -0,0 +1,171 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+
+	"github.com/google/blueprint"
+)
+
+// This file contains the test fixture API for the Soong plugins that live outside of build/soong,
+// e.g. in vendor or device trees. Plugins should only depend on the preparers in this file and on
+// the generic fixture API in fixture.go, the other preparers are internal to build/soong and change
+// whenever the build components they register do.
+
+// PrepareForPluginTest prepares a test of the module types of a Soong plugin. It registers the build
+// components that the module types of every plugin rely on, i.e. arch variants, defaults,
+// filegroups, overrides, prebuilts and visibility.
+//
+// The build components of the plugin itself are registered with FixtureRegisterGlobalModuleTypes,
+// FixtureRegisterGlobalMutators and FixtureRegisterGlobalSingletonTypes, or with
+// FixtureRegisterWithContext when the plugin exports its registration function.
+var PrepareForPluginTest = GroupFixturePreparers(
+	PrepareForTestWithAndroidBuildComponents,
+)
+
+// FixtureRegisterGlobalModuleTypes registers the module types with the given names in the test
+// context, using the factories that were registered by the init functions of the plugin through
+// RegisterModuleType or InitRegistrationContext. This allows testing the module types of a plugin
+// without exporting their factories.
+//
+// Panics if one of the module types has not been registered.
+func FixtureRegisterGlobalModuleTypes(names ...string) FixturePreparer {
+	return FixtureRegisterWithContext(func(ctx RegistrationContext) {
+		factories := ModuleTypeFactories()
+		for _, name := range names {
+			factory, ok := factories[name]
+			if !ok {
+				panic(fmt.Errorf("module type %q has not been registered", name))
+			}
+			ctx.RegisterModuleType(name, factory)
+		}
+	})
+}
+
+// FixtureRegisterGlobalMutators registers the mutators with the given names in the test context, in
+// the phases in which they were registered by the init functions of the plugin through
+// PreArchMutators, PreDepsMutators, PostDepsMutators, FinalDepsMutators or InitRegistrationContext.
+// The other mutators registered by the same functions are left out.
+//
+// Panics if one of the mutators has not been registered.
+func FixtureRegisterGlobalMutators(names ...string) FixturePreparer {
+	return FixtureRegisterWithContext(func(ctx RegistrationContext) {
+		phases := []struct {
+			global   []RegisterMutatorFunc
+			register func(RegisterMutatorFunc)
+		}{
+			{preArch, ctx.PreArchMutators},
+			{preDeps, ctx.PreDepsMutators},
+			{postDeps, ctx.PostDepsMutators},
+			{finalDeps, ctx.FinalDepsMutators},
+		}
+		var found []string
+		for _, phase := range phases {
+			for _, f := range phase.global {
+				// Find out which mutators the function registers without registering them.
+				recorder := &globalMutatorFilter{}
+				f(recorder)
+				var wanted []string
+				for _, name := range recorder.seen {
+					if InList(name, names) {
+						wanted = append(wanted, name)
+					}
+				}
+				if len(wanted) == 0 {
+					continue
+				}
+				found = append(found, wanted...)
+				f := f
+				phase.register(func(mctx RegisterMutatorsContext) {
+					f(&globalMutatorFilter{ctx: mctx, names: names})
+				})
+			}
+		}
+		for _, name := range names {
+			if !InList(name, found) {
+				panic(fmt.Errorf("mutator %q has not been registered", name))
+			}
+		}
+	})
+}
+
+// globalMutatorFilter is a RegisterMutatorsContext that records the names of the mutators that
+// are registered through it, and forwards the ones with the given names to ctx, if any.
+type globalMutatorFilter struct {
+	ctx   RegisterMutatorsContext
+	names []string
+	seen  []string
+}
+
+func (f *globalMutatorFilter) register(name string, register func() MutatorHandle) MutatorHandle {
+	f.seen = append(f.seen, name)
+	if f.ctx == nil || !InList(name, f.names) {
+		return ignoredMutatorHandle{}
+	}
+	return register()
+}
+
+func (f *globalMutatorFilter) TopDown(name string, m TopDownMutator) MutatorHandle {
+	return f.register(name, func() MutatorHandle { return f.ctx.TopDown(name, m) })
+}
+
+func (f *globalMutatorFilter) BottomUp(name string, m BottomUpMutator) MutatorHandle {
+	return f.register(name, func() MutatorHandle { return f.ctx.BottomUp(name, m) })
+}
+
+func (f *globalMutatorFilter) BottomUpBlueprint(name string, m blueprint.BottomUpMutator) MutatorHandle {
+	return f.register(name, func() MutatorHandle { return f.ctx.BottomUpBlueprint(name, m) })
+}
+
+// ignoredMutatorHandle is returned for the mutators that globalMutatorFilter doesn't register.
+type ignoredMutatorHandle struct{}
+
+func (h ignoredMutatorHandle) Parallel() MutatorHandle              { return h }
+func (h ignoredMutatorHandle) After(names ...string) MutatorHandle  { return h }
+func (h ignoredMutatorHandle) Before(names ...string) MutatorHandle { return h }
+
+// FixtureRegisterGlobalSingletonTypes registers the singleton and pre-singleton types with the
+// given names in the test context, using the factories that were registered by the init functions
+// of the plugin through RegisterSingletonType, RegisterPreSingletonType or
+// InitRegistrationContext.
+//
+// Panics if one of the singleton types has not been registered.
+func FixtureRegisterGlobalSingletonTypes(names ...string) FixturePreparer {
+	return FixtureRegisterWithContext(func(ctx RegistrationContext) {
+		for _, name := range names {
+			s, ok := globalSingletonType(name)
+			if !ok {
+				panic(fmt.Errorf("singleton type %q has not been registered", name))
+			}
+			if s.pre {
+				ctx.RegisterPreSingletonType(name, s.factory)
+			} else {
+				ctx.RegisterSingletonType(name, s.factory)
+			}
+		}
+	})
+}
+
+func globalSingletonType(name string) (singleton, bool) {
+	for _, list := range []sortableComponents{singletons, preSingletons} {
+		for _, c := range list {
+			if s, ok := c.(singleton); ok && s.name == name {
+				return s, true
+			}
+		}
+	}
+	return singleton{}, false
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+// Registered the same way an out-of-tree plugin registers its build components.
+func init() {
+	InitRegistrationContext.RegisterModuleType("plugin_test_module", pluginTestModuleFactory)
+	InitRegistrationContext.RegisterSingletonType("plugin_test_singleton", pluginTestSingletonFactory)
+	InitRegistrationContext.PostDepsMutators(func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("plugin_test_mutator", func(ctx BottomUpMutatorContext) {
+			if m, ok := ctx.Module().(*pluginTestModule); ok {
+				m.mutated = append(m.mutated, "plugin_test_mutator")
+			}
+			// The constraint is ignored by tests that don't register the other mutator.
+		}).Parallel().After("plugin_test_other_mutator")
+		ctx.BottomUp("plugin_test_other_mutator", func(ctx BottomUpMutatorContext) {
+			if m, ok := ctx.Module().(*pluginTestModule); ok {
+				m.mutated = append(m.mutated, "plugin_test_other_mutator")
+			}
+		}).Parallel()
+	})
+}
+
+type pluginTestModule struct {
+	ModuleBase
+
+	properties struct {
+		Srcs []string `android:"path,arch_variant"`
+	}
+
+	// The plugin mutators that ran on this module.
+	mutated []string
+}
+
+func pluginTestModuleFactory() Module {
+	m := &pluginTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibCommon)
+	return m
+}
+
+func (m *pluginTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	ctx.Build(pctx, BuildParams{
+		Rule:      Touch,
+		Output:    PathForModuleOut(ctx, "out"),
+		Implicits: PathsForModuleSrc(ctx, m.properties.Srcs),
+	})
+}
+
+type pluginTestSingleton struct{}
+
+func pluginTestSingletonFactory() Singleton {
+	return &pluginTestSingleton{}
+}
+
+func (s *pluginTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(pctx, BuildParams{
+		Rule:   Touch,
+		Output: PathForOutput(ctx, "plugin_test_singleton"),
+	})
+}
+
+func TestPluginFixture(t *testing.T) {
+	result := GroupFixturePreparers(
+		PrepareForPluginTest,
+		FixtureRegisterGlobalModuleTypes("plugin_test_module"),
+		FixtureRegisterGlobalMutators("plugin_test_mutator"),
+		FixtureRegisterGlobalSingletonTypes("plugin_test_singleton"),
+		FixtureAddFile("foo.txt", nil),
+	).RunTestWithBp(t, `
+		plugin_test_module {
+			name: "foo",
+			target: {
+				android: {
+					srcs: ["foo.txt"],
+				},
+			},
+		}
+	`)
+
+	// The os and arch variants are set up by PrepareForPluginTest.
+	foo := result.ModuleForTests("foo", "android_common")
+	AssertPathsRelativeToTopEquals(t, "foo implicits", []string{"foo.txt"},
+		foo.Output("out").Implicits)
+	// Only the requested mutator is registered, not the others registered by the same function.
+	AssertArrayString(t, "foo mutators", []string{"plugin_test_mutator"},
+		foo.Module().(*pluginTestModule).mutated)
+	result.SingletonForTests("plugin_test_singleton").Output("plugin_test_singleton")
+}
+
+func TestPluginFixtureUnregistered(t *testing.T) {
+	for name, preparer := range map[string]FixturePreparer{
+		`module type "not_registered" has not been registered`:    FixtureRegisterGlobalModuleTypes("not_registered"),
+		`mutator "not_registered" has not been registered`:        FixtureRegisterGlobalMutators("not_registered"),
+		`singleton type "not_registered" has not been registered`: FixtureRegisterGlobalSingletonTypes("not_registered"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Fatalf("expected panic %q, got none", name)
+				}
+				AssertStringEquals(t, "panic", name, r.(error).Error())
+			}()
+			GroupFixturePreparers(PrepareForPluginTest, preparer).RunTest(t)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"strings"
+)
+
+// This file implements validation of combinations of product variables that are individually
+// valid but cannot be used together. The checks run in a pre-singleton so that an invalid product
+// configuration is reported before any module is analyzed, rather than surfacing as an obscure
+// failure in some unrelated module much later.
+//
+// To add a new constraint add a productVariableConstraint to productVariableConstraints. Each
+// constraint lists the Make variables that the product sets to control the product variables
+// involved so that the error message points at the product makefiles that need to change.
+
+func init() {
+	RegisterProductVariableConstraintsBuildComponents(InitRegistrationContext)
+}
+
+func RegisterProductVariableConstraintsBuildComponents(ctx RegistrationContext) {
+	ctx.RegisterPreSingletonType("product_variable_constraints", productVariableConstraintsSingletonFactory)
+}
+
+var PrepareForTestWithProductVariableConstraints = FixtureRegisterWithContext(RegisterProductVariableConstraintsBuildComponents)
+
+// productVariableConstraint describes a combination of product variables that is not allowed.
+type productVariableConstraint struct {
+	// A short name for the constraint, used in error messages.
+	name string
+
+	// The Make variables that control the product variables checked by this constraint.
+	makeVariables []string
+
+	// violated returns true if the product configuration breaks this constraint.
+	violated func(config Config) bool
+
+	// An explanation of why the combination is not allowed and how to fix it.
+	reason string
+}
+
+var productVariableConstraints = []productVariableConstraint{
+	{
+		// apexd only activates flattened APEXes when ro.apex.updatable is false, and the
+		// prebuilt APEXes that provide updatable mainline modules are disabled when APEXes are
+		// flattened.
+		name:          "updatable-flattened-apex",
+		makeVariables: []string{"TARGET_FLATTEN_APEX", "OVERRIDE_TARGET_FLATTEN_APEX", "ro.apex.updatable (updatable_apex.mk)"},
+		violated: func(config Config) bool {
+			return config.FlattenApex() && config.UpdatableApex()
+		},
+		reason: "flattened APEXes cannot be updated, a product that supports updatable APEXes must not flatten them",
+	},
+	{
+		// Only image APEXes are compressed.
+		name:          "flattened-compressed-apex",
+		makeVariables: []string{"TARGET_FLATTEN_APEX", "PRODUCT_COMPRESSED_APEX"},
+		violated: func(config Config) bool {
+			return config.FlattenApex() && config.CompressedApex()
+		},
+		reason: "compressed APEXes are only supported for image APEXes, flattened APEXes cannot be compressed",
+	},
+	{
+		// The extra flattened APEXes are only added next to image APEXes.
+		name:          "flattened-apex-extra-flattened-apexes",
+		makeVariables: []string{"TARGET_FLATTEN_APEX", "PRODUCT_INSTALL_EXTRA_FLATTENED_APEXES"},
+		violated: func(config Config) bool {
+			return config.FlattenApex() && config.InstallExtraFlattenedApexes()
+		},
+		reason: "extra flattened APEXes are only installed alongside image APEXes and have no effect when APEXes are flattened",
+	},
+	{
+		// The VNDK of the product partition uses the VNDK snapshots selected for the vendor
+		// partition.
+		name:          "product-vndk-without-board-vndk",
+		makeVariables: []string{"PRODUCT_PRODUCT_VNDK_VERSION", "BOARD_VNDK_VERSION"},
+		violated: func(config Config) bool {
+			return config.productVariables.ProductVndkVersion != nil &&
+				config.productVariables.DeviceVndkVersion == nil
+		},
+		reason: "the product partition can only use the VNDK when the vendor partition also uses it",
+	},
+}
+
+func productVariableConstraintsSingletonFactory() Singleton {
+	return &productVariableConstraintsSingleton{}
+}
+
+type productVariableConstraintsSingleton struct{}
+
+func (s *productVariableConstraintsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	for _, err := range checkProductVariableConstraints(ctx.Config(), productVariableConstraints) {
+		ctx.Errorf("%s", err)
+	}
+}
+
+// checkProductVariableConstraints returns an error message for each of the constraints that the
+// product configuration violates.
+func checkProductVariableConstraints(config Config, constraints []productVariableConstraint) []string {
+	var errs []string
+	for _, c := range constraints {
+		if c.violated(config) {
+			errs = append(errs, "invalid product configuration ("+c.name+"): "+c.reason+
+				"; check the values of "+strings.Join(c.makeVariables, ", ")+" in the product makefiles")
+		}
+	}
+	return errs
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func TestProductVariableConstraints(t *testing.T) {
+	testCases := []struct {
+		name          string
+		modify        func(variables FixtureProductVariables)
+		expectedError string
+	}{
+		{
+			name:   "valid",
+			modify: func(variables FixtureProductVariables) {},
+		},
+		{
+			name: "updatable flattened apex",
+			modify: func(variables FixtureProductVariables) {
+				variables.Flatten_apex = boolPtr(true)
+				variables.UpdatableApex = boolPtr(true)
+			},
+			expectedError: `invalid product configuration \(updatable-flattened-apex\): flattened APEXes cannot be updated.*TARGET_FLATTEN_APEX, OVERRIDE_TARGET_FLATTEN_APEX`,
+		},
+		{
+			name: "updatable image apex",
+			modify: func(variables FixtureProductVariables) {
+				variables.Flatten_apex = boolPtr(false)
+				variables.UpdatableApex = boolPtr(true)
+			},
+		},
+		{
+			name: "flattened apex with extra flattened apexes",
+			modify: func(variables FixtureProductVariables) {
+				variables.Flatten_apex = boolPtr(true)
+				variables.InstallExtraFlattenedApexes = boolPtr(true)
+			},
+			expectedError: `invalid product configuration \(flattened-apex-extra-flattened-apexes\)`,
+		},
+		{
+			name: "flattened compressed apex",
+			modify: func(variables FixtureProductVariables) {
+				variables.Flatten_apex = boolPtr(true)
+				variables.CompressedApex = boolPtr(true)
+			},
+			expectedError: `invalid product configuration \(flattened-compressed-apex\).*TARGET_FLATTEN_APEX, PRODUCT_COMPRESSED_APEX`,
+		},
+		{
+			name: "product vndk without board vndk",
+			modify: func(variables FixtureProductVariables) {
+				variables.DeviceVndkVersion = nil
+				variables.ProductVndkVersion = stringPtr("current")
+			},
+			expectedError: `invalid product configuration \(product-vndk-without-board-vndk\)`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			errorHandler := FixtureExpectsNoErrors
+			if test.expectedError != "" {
+				errorHandler = FixtureExpectsAtLeastOneErrorMatchingPattern(test.expectedError)
+			}
+			GroupFixturePreparers(
+				PrepareForTestWithProductVariableConstraints,
+				FixtureModifyProductVariables(test.modify),
+			).
+				ExtendWithErrorHandler(errorHandler).
+				RunTest(t)
+		})
+	}
+}
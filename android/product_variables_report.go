@@ -0,0 +1,181 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// The product variables report records the final value of every product variable read by Soong
+// from soong.variables, together with the product configuration layers that assigned it, so that
+// the reports of two lunch targets can be diffed to find out why Soong behaves differently.
+//
+// The provenance is read from soong.variables.provenance next to soong.variables, when the product
+// configuration writes it. It maps the name of a variable to its assignments in the order the
+// product configuration evaluated them, e.g.
+//
+//   {"DeviceArch": [{"file": "build/make/target/board/BoardConfigMainlineCommon.mk", "value": "arm64"}]}
+//
+// Without the file, the set_by field of the variables is left out of the report. A variable that
+// several layers assign is not a conflict, as overriding the values of inherited layers is how
+// the product configuration works.
+//
+// product_variables/conflicts.txt lists the entries of the product variables that contradict each
+// other, which usually come from different layers of the product inheritance:
+// - override rules for the same module with different values, of which only the first is used
+// - jars that are configured in more than one APEX or both in an APEX and the platform
+
+func init() {
+	RegisterSingletonType("product_variables_report", productVariablesReportSingletonFactory)
+}
+
+const productVariablesProvenanceSuffix = ".provenance"
+
+// ProductVariableAssignment is an assignment of a product variable by a layer of the product
+// configuration.
+type ProductVariableAssignment struct {
+	// The makefile or config file that assigned the variable.
+	File string `json:"file"`
+
+	// The value assigned to the variable, in the format of soong.variables.
+	Value json.RawMessage `json:"value"`
+}
+
+// loadProductVariablesProvenance reads the provenance of the product variables, if the product
+// configuration wrote it.
+func loadProductVariablesProvenance(config *config, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read %s: %s", filename, err)
+	}
+	if err := json.Unmarshal(data, &config.productVariablesProvenance); err != nil {
+		return fmt.Errorf("%s did not parse correctly: %s", filename, err)
+	}
+	// The file only exists for some products, so it can only be a dependency when it exists.
+	config.addNinjaFileDeps(filename)
+	return nil
+}
+
+type productVariableReportEntry struct {
+	Name  string                      `json:"name"`
+	Value json.RawMessage             `json:"value"`
+	SetBy []ProductVariableAssignment `json:"set_by,omitempty"`
+}
+
+func productVariablesReportSingletonFactory() Singleton {
+	return &productVariablesReportSingleton{}
+}
+
+type productVariablesReportSingleton struct{}
+
+// overrideRuleConflicts returns the conflicts between the <module_name>:<value> override rules of
+// a product variable. Only the first rule for a module is used, so later rules for the same module
+// with a different value are silently ignored.
+func overrideRuleConflicts(name string, rules []string) []string {
+	var conflicts []string
+	first := make(map[string]string)
+	for _, rule := range rules {
+		split := strings.Split(rule, ":")
+		if len(split) != 2 {
+			continue
+		}
+		module, value := split[0], split[1]
+		if prev, ok := first[module]; !ok {
+			first[module] = value
+		} else if prev != value {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s is overridden with both %s and %s, only %s is used",
+				name, module, prev, value, prev))
+		}
+	}
+	return conflicts
+}
+
+// configuredJarConflicts returns the jars that are configured in different APEXes, or both in an
+// APEX and the platform, by the given configured jar list product variables.
+func configuredJarConflicts(lists map[string]*ConfiguredJarList) []string {
+	var conflicts []string
+	type location struct{ variable, apex string }
+	first := make(map[string]location)
+	for _, name := range SortedStringKeys(lists) {
+		list := lists[name]
+		for i := 0; i < list.Len(); i++ {
+			jar, apex := list.Jar(i), list.Apex(i)
+			if prev, ok := first[jar]; !ok {
+				first[jar] = location{name, apex}
+			} else if prev.apex != apex {
+				conflicts = append(conflicts, fmt.Sprintf("%s: configured as %s:%s by %s and as %s:%s by %s",
+					jar, prev.apex, jar, prev.variable, apex, jar, name))
+			}
+		}
+	}
+	return conflicts
+}
+
+func (s *productVariablesReportSingleton) GenerateBuildActions(ctx SingletonContext) {
+	config := ctx.Config()
+	variables := &config.productVariables
+
+	var entries []productVariableReportEntry
+	// The fields are marshalled through pointers so that types like ConfiguredJarList use their
+	// JSON format.
+	v := reflect.ValueOf(variables).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		value, err := json.Marshal(v.Field(i).Addr().Interface())
+		if err != nil {
+			ctx.Errorf("failed to marshal product variable %s: %s", field.Name, err)
+			continue
+		}
+		entries = append(entries, productVariableReportEntry{
+			Name:  field.Name,
+			Value: value,
+			SetBy: config.productVariablesProvenance[field.Name],
+		})
+	}
+
+	var conflicts []string
+	conflicts = append(conflicts, overrideRuleConflicts("ManifestPackageNameOverrides", variables.ManifestPackageNameOverrides)...)
+	conflicts = append(conflicts, overrideRuleConflicts("CertificateOverrides", variables.CertificateOverrides)...)
+	conflicts = append(conflicts, overrideRuleConflicts("PackageNameOverrides", variables.PackageNameOverrides)...)
+	conflicts = append(conflicts, configuredJarConflicts(map[string]*ConfiguredJarList{
+		"ApexBootJars": &variables.ApexBootJars,
+		"BootJars":     &variables.BootJars,
+	})...)
+
+	report, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to marshal the product variables report: %s", err)
+		return
+	}
+
+	reportFile := PathForOutput(ctx, "product_variables", "report.json")
+	WriteFileRule(ctx, reportFile, string(report))
+
+	conflictsFile := PathForOutput(ctx, "product_variables", "conflicts.txt")
+	WriteFileRule(ctx, conflictsFile, strings.Join(conflicts, "\n"))
+
+	ctx.Phony("product-variables-report", reportFile, conflictsFile)
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestProductVariablesReport(t *testing.T) {
+	result := GroupFixturePreparers(
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterSingletonType("product_variables_report", productVariablesReportSingletonFactory)
+		}),
+		FixtureModifyProductVariables(func(variables FixtureProductVariables) {
+			variables.DeviceName = StringPtr("generic")
+			variables.BootJars = CreateTestConfiguredJarList([]string{"platform:framework", "com.android.art:core-oj"})
+			variables.ApexBootJars = CreateTestConfiguredJarList([]string{"com.android.art:core-oj", "com.android.foo:framework"})
+			variables.CertificateOverrides = []string{
+				"foo:foo.cert",
+				"bar:bar.cert",
+				"foo:foo.cert",
+				"foo:other.cert",
+			}
+		}),
+		FixtureModifyConfig(func(config Config) {
+			config.productVariablesProvenance = map[string][]ProductVariableAssignment{
+				"DeviceName": {
+					{File: "device/common/BoardConfig.mk", Value: json.RawMessage(`"common"`)},
+					{File: "device/generic/BoardConfig.mk", Value: json.RawMessage(`"generic"`)},
+				},
+			}
+		}),
+	).RunTest(t)
+
+	singleton := result.SingletonForTests("product_variables_report")
+
+	report := ContentFromFileRuleForTests(t, singleton.Output("product_variables/report.json"))
+	AssertStringDoesContain(t, "report", report, `"name": "DeviceName",
+    "value": "generic",
+    "set_by": [
+      {
+        "file": "device/common/BoardConfig.mk",
+        "value": "common"
+      },
+      {
+        "file": "device/generic/BoardConfig.mk",
+        "value": "generic"
+      }
+    ]
+  }`)
+	AssertStringDoesContain(t, "report", report, `"name": "Make_suffix",
+    "value": null
+  }`)
+	AssertStringDoesContain(t, "report", report, `"name": "BootJars",
+    "value": [
+      "platform:framework",
+      "com.android.art:core-oj"
+    ]
+  }`)
+
+	// Overriding an inherited value, repeated rules with the same value and jars in the same APEX
+	// in both lists are not conflicts.
+	conflicts := strings.Split(ContentFromFileRuleForTests(t, singleton.Output("product_variables/conflicts.txt")), "\n")
+	AssertArrayString(t, "conflicts", []string{
+		`CertificateOverrides: foo is overridden with both foo.cert and other.cert, only foo.cert is used`,
+		`framework: configured as com.android.foo:framework by ApexBootJars and as platform:framework by BootJars`,
+	}, conflicts)
+}
@@ -100,6 +100,11 @@ type mutator struct {
 	bottomUpMutator blueprint.BottomUpMutator
 	topDownMutator  blueprint.TopDownMutator
 	parallel        bool
+
+	// The phase the mutator was registered in, and the ordering constraints set with
+	// MutatorHandle.After and MutatorHandle.Before.
+	phase         mutatorPhase
+	after, before []string
 }
 
 var _ sortableComponent = &mutator{}
@@ -192,6 +197,11 @@ func (ctx *Context) Register() {
 		t.register(ctx)
 	}
 
+	// Only the build registers every mutator, so only it can tell that a mutator named by an
+	// ordering constraint doesn't exist.
+	if err := checkMutatorConstraintNames(registerMutatorPhases(preArch, preDeps, postDeps, finalDeps)); err != nil {
+		panic(err)
+	}
 	mutators := collateGloballyRegisteredMutators()
 	mutators.registerAll(ctx)
 
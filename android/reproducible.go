@@ -0,0 +1,141 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/blueprint"
+)
+
+// Images and packages built by Soong are compared byte for byte across builds, so every parameter
+// that a tool would otherwise pick from the clock or from a random source is pinned here. Rules that
+// produce zips, images, apks or apexes use these values instead of choosing their own, and register
+// their outputs with CheckReproducibleOutput so that `m reproducibility-check` verifies them.
+//
+// The check records the sha256 of each output. When REPRODUCIBILITY_REFERENCE_OUT_DIR is set to the
+// output directory of another build of the same sources, each output is also compared with the
+// same output in that directory, which fails on any difference between the two builds.
+
+// ReproducibleTime is the timestamp pinned into the files of every image. It matches the time that
+// soong_zip stores in zip entries.
+var ReproducibleTime = time.Date(2008, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// reproducibleNamespace is the namespace of the UUIDs returned by ReproducibleUUID, the URL
+// namespace of RFC 4122.
+var reproducibleNamespace = []byte{
+	0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1,
+	0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8,
+}
+
+// ReproducibleUUID returns a version 5 UUID derived from seed, so that an image built twice from
+// the same module gets the same UUID.
+func ReproducibleUUID(seed string) string {
+	h := sha1.New()
+	h.Write(reproducibleNamespace)
+	h.Write([]byte("android.com/soong/" + seed))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// ReproducibleSalt returns a hex encoded salt derived from seed, for avbtool which would otherwise
+// generate a random one.
+func ReproducibleSalt(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReproducibleOutputKind selects how CheckReproducibleOutput verifies an output.
+type ReproducibleOutputKind string
+
+const (
+	// ReproducibleZip verifies that all entries of a zip, apk or apex have ReproducibleTime as their
+	// timestamp, and that they are in the same order as in the reference build.
+	ReproducibleZip ReproducibleOutputKind = "zip"
+
+	// ReproducibleExt4 verifies the UUID and the directory hash seed of an ext4 image.
+	ReproducibleExt4 ReproducibleOutputKind = "ext4"
+
+	// ReproducibleFile only compares an output with the reference build, for outputs that have no
+	// pinned parameters that can be read back.
+	ReproducibleFile ReproducibleOutputKind = "file"
+)
+
+const reproducibilityCheckPhony = "reproducibility-check"
+
+// reproducibilityReferenceOutDirEnv is the environment variable that points at the output
+// directory of the build that outputs are compared with.
+const reproducibilityReferenceOutDirEnv = "REPRODUCIBILITY_REFERENCE_OUT_DIR"
+
+var (
+	_ = pctx.HostBinToolVariable("checkReproducibleOutputCmd", "check_reproducible_output")
+
+	checkReproducibleOutputRule = pctx.AndroidStaticRule("checkReproducibleOutput", blueprint.RuleParams{
+		Command:     "${checkReproducibleOutputCmd} --kind $kind $flags -o $out $in",
+		CommandDeps: []string{"${checkReproducibleOutputCmd}"},
+	}, "kind", "flags")
+)
+
+// CheckReproducibleOutput adds a rule that verifies output was built with the pinned parameters and
+// is identical to the same output of the reference build, if any, and adds the result to the
+// reproducibility-check target. For ReproducibleExt4 outputs, uuidSeed is the seed that was passed to
+// ReproducibleUUID for both the UUID and the hash seed of the image.
+func CheckReproducibleOutput(ctx ModuleContext, kind ReproducibleOutputKind, output Path, uuidSeed string) {
+	var flags []string
+	implicits := Paths{}
+	switch kind {
+	case ReproducibleZip:
+		flags = append(flags, "--timestamp "+ReproducibleTime.Format("2006-01-02T15:04:05"))
+	case ReproducibleFile:
+	case ReproducibleExt4:
+		tune2fs := ctx.Config().HostToolPath(ctx, "tune2fs")
+		implicits = append(implicits, tune2fs)
+		flags = append(flags,
+			"--tune2fs "+tune2fs.String(),
+			"--uuid "+ReproducibleUUID(uuidSeed),
+			"--hash-seed "+ReproducibleUUID(uuidSeed))
+	default:
+		panic(fmt.Errorf("unknown reproducible output kind %q", kind))
+	}
+
+	if referenceOutDir := ctx.Config().Getenv(reproducibilityReferenceOutDirEnv); referenceOutDir != "" {
+		if rel, isRel := MaybeRel(ctx, ctx.Config().OutDir(), output.String()); isRel {
+			// The reference is not an input of the rule, as it is not built by this build.
+			flags = append(flags, "--reference "+filepath.Join(referenceOutDir, rel))
+		}
+	}
+
+	checked := PathForModuleOut(ctx, "reproducibility", output.Base()+".checked")
+	ctx.Build(pctx, BuildParams{
+		Rule:        checkReproducibleOutputRule,
+		Description: "check reproducible " + output.Base(),
+		Input:       output,
+		Implicits:   implicits,
+		Output:      checked,
+		Args: map[string]string{
+			"kind":  string(kind),
+			"flags": strings.Join(flags, " "),
+		},
+	})
+	ctx.Phony(reproducibilityCheckPhony, checked)
+}
@@ -0,0 +1,35 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func TestReproducibleUUID(t *testing.T) {
+	// Matches uuid.uuid5(uuid.NAMESPACE_URL, "android.com/soong/system") in python.
+	AssertStringEquals(t, "UUID of system", "27d01217-3e72-5bf3-8379-715548938f0e",
+		ReproducibleUUID("system"))
+	AssertStringEquals(t, "UUID should be stable", ReproducibleUUID("vendor"),
+		ReproducibleUUID("vendor"))
+	if ReproducibleUUID("system") == ReproducibleUUID("vendor") {
+		t.Errorf("different seeds should give different UUIDs")
+	}
+}
+
+func TestReproducibleSalt(t *testing.T) {
+	AssertIntEquals(t, "salt length", 64, len(ReproducibleSalt("boot")))
+	AssertStringEquals(t, "salt should be stable", ReproducibleSalt("boot"), ReproducibleSalt("boot"))
+}
@@ -0,0 +1,195 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// Toolchain experiments apply experimental compiler or ART flags to a subset of the modules, so
+// that new toolchain options can be rolled out gradually. They are read from the JSON file that
+// SOONG_TOOLCHAIN_EXPERIMENTS points to, which is usually set through the environment config
+// fetched from the experiments server (see ANDROID_BUILD_ENVIRONMENT_CONFIG), e.g.:
+//
+//	{
+//	  "Experiments": [
+//	    {
+//	      "Name": "cc_new_inliner",
+//	      "Cflags": ["-mllvm", "-new-inliner"],
+//	      "Modules": ["libfoo"],
+//	      "Percent": 10
+//	    }
+//	  ]
+//	}
+//
+// An experiment applies to the modules listed in Modules, and to Percent percent of the other
+// modules. The latter are selected by a hash of the experiment and module names, so that the
+// selection is stable across builds and independent between experiments. The names of the
+// experiments whose flags were added to the command lines of at least one module are recorded in
+// the soong_build metrics.
+
+func init() {
+	RegisterToolchainExperimentsBuildComponents(InitRegistrationContext)
+}
+
+var PrepareForTestWithToolchainExperiments = FixtureRegisterWithContext(RegisterToolchainExperimentsBuildComponents)
+
+func RegisterToolchainExperimentsBuildComponents(ctx RegistrationContext) {
+	ctx.RegisterSingletonType("toolchain_experiments", toolchainExperimentsSingletonFactory)
+}
+
+// ToolchainExperiment is an experiment in the SOONG_TOOLCHAIN_EXPERIMENTS file.
+type ToolchainExperiment struct {
+	// The name of the experiment, which is recorded in the metrics.
+	Name string
+
+	// Flags added to the C and C++ compiler and linker command lines of cc modules.
+	Cflags  []string
+	Ldflags []string
+
+	// Flags added to the dex2oat command line of dexpreopted java modules.
+	Dex2oatFlags []string
+
+	// The modules that the experiment always applies to.
+	Modules []string
+
+	// The percentage of the other modules that the experiment applies to.
+	Percent int
+}
+
+// appliesTo returns true if the experiment applies to the module with the given name.
+func (e *ToolchainExperiment) appliesTo(name string) bool {
+	if InList(name, e.Modules) {
+		return true
+	}
+	if e.Percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(e.Name + ":" + name))
+	return int(h.Sum32()%100) < e.Percent
+}
+
+type toolchainExperiments struct {
+	path        string
+	experiments []ToolchainExperiment
+	err         error
+
+	// The names of the experiments whose flags were added to at least one module.
+	applied sync.Map
+}
+
+var toolchainExperimentsKey = NewOnceKey("toolchainExperiments")
+
+func loadToolchainExperiments(config Config) *toolchainExperiments {
+	return config.Once(toolchainExperimentsKey, func() interface{} {
+		e := &toolchainExperiments{path: config.Getenv("SOONG_TOOLCHAIN_EXPERIMENTS")}
+		if e.path == "" {
+			return e
+		}
+		data, err := ioutil.ReadFile(absolutePath(e.path))
+		if err != nil {
+			e.err = err
+			return e
+		}
+		var file struct {
+			Experiments []ToolchainExperiment
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			e.err = err
+			return e
+		}
+		seen := make(map[string]bool)
+		for _, experiment := range file.Experiments {
+			if experiment.Name == "" {
+				e.err = fmt.Errorf("experiment without a name")
+				return e
+			}
+			if seen[experiment.Name] {
+				e.err = fmt.Errorf("duplicate experiment %q", experiment.Name)
+				return e
+			}
+			if experiment.Percent < 0 || experiment.Percent > 100 {
+				e.err = fmt.Errorf("experiment %q: Percent must be between 0 and 100, got %d",
+					experiment.Name, experiment.Percent)
+				return e
+			}
+			seen[experiment.Name] = true
+		}
+		e.experiments = file.Experiments
+		return e
+	}).(*toolchainExperiments)
+}
+
+// ToolchainExperiments returns the toolchain experiments that apply to the module, in the order
+// they appear in the SOONG_TOOLCHAIN_EXPERIMENTS file. Module types that support toolchain
+// experiments add the flags of the returned experiments to their command lines, and call
+// MarkToolchainExperimentApplied for those that had flags for them. If the file can't be read or
+// is invalid then no experiments apply, and the toolchain_experiments singleton reports the error.
+func ToolchainExperiments(ctx BaseModuleContext) []ToolchainExperiment {
+	e := loadToolchainExperiments(ctx.Config())
+	if e.err != nil {
+		return nil
+	}
+	var ret []ToolchainExperiment
+	for _, experiment := range e.experiments {
+		if experiment.appliesTo(ctx.ModuleName()) {
+			ret = append(ret, experiment)
+		}
+	}
+	return ret
+}
+
+// MarkToolchainExperimentApplied records that flags of the experiment were added to the command
+// lines of the module, so that the experiment is reported in the metrics. An experiment that only
+// has flags for other module types, e.g. a dex2oat experiment matched by a cc module, is not marked.
+func MarkToolchainExperimentApplied(ctx BaseModuleContext, experiment ToolchainExperiment) {
+	loadToolchainExperiments(ctx.Config()).applied.Store(experiment.Name, true)
+}
+
+// appliedToolchainExperiments returns the sorted names of the toolchain experiments whose flags
+// were added to at least one module.
+func appliedToolchainExperiments(config Config) []string {
+	var names []string
+	loadToolchainExperiments(config).applied.Range(func(name, _ interface{}) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+func toolchainExperimentsSingletonFactory() Singleton {
+	return &toolchainExperimentsSingleton{}
+}
+
+type toolchainExperimentsSingleton struct{}
+
+func (s *toolchainExperimentsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	e := loadToolchainExperiments(ctx.Config())
+	if e.path == "" {
+		return
+	}
+	// Regenerate the Ninja files when the experiments change.
+	ctx.AddNinjaFileDeps(e.path)
+	if e.err != nil {
+		ctx.Errorf("SOONG_TOOLCHAIN_EXPERIMENTS: failed to load %s: %s", e.path, e.err)
+	}
+}
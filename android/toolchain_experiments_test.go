@@ -0,0 +1,124 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+type toolchainExperimentsTestModule struct {
+	ModuleBase
+	experiments []string
+}
+
+func toolchainExperimentsTestModuleFactory() Module {
+	m := &toolchainExperimentsTestModule{}
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *toolchainExperimentsTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	// Like a cc module, the test module only uses the Cflags of the experiments.
+	for _, experiment := range ToolchainExperiments(ctx) {
+		m.experiments = append(m.experiments, experiment.Name)
+		if len(experiment.Cflags) > 0 {
+			MarkToolchainExperimentApplied(ctx, experiment)
+		}
+	}
+}
+
+func prepareForToolchainExperimentsTest(t *testing.T, experiments string) FixturePreparer {
+	path := filepath.Join(t.TempDir(), "experiments.json")
+	if err := ioutil.WriteFile(path, []byte(experiments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return GroupFixturePreparers(
+		PrepareForTestWithToolchainExperiments,
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterModuleType("test_module", toolchainExperimentsTestModuleFactory)
+		}),
+		FixtureWithRootAndroidBp(`
+			test_module {
+				name: "foo",
+			}
+
+			test_module {
+				name: "bar",
+			}
+		`),
+		FixtureMergeEnv(map[string]string{
+			"SOONG_TOOLCHAIN_EXPERIMENTS": path,
+		}),
+	)
+}
+
+func TestToolchainExperiments(t *testing.T) {
+	result := prepareForToolchainExperimentsTest(t, `{
+		"Experiments": [
+			{"Name": "all", "Cflags": ["-fall"], "Percent": 100},
+			{"Name": "foo_only", "Cflags": ["-ffoo"], "Modules": ["foo"]},
+			{"Name": "none", "Cflags": ["-fnone"]},
+			{"Name": "dex2oat_only", "Dex2oatFlags": ["--foo"], "Percent": 100}
+		]
+	}`).RunTest(t)
+
+	experiments := func(name string) []string {
+		return result.ModuleForTests(name, "").Module().(*toolchainExperimentsTestModule).experiments
+	}
+	AssertDeepEquals(t, "foo experiments", []string{"all", "foo_only", "dex2oat_only"}, experiments("foo"))
+	AssertDeepEquals(t, "bar experiments", []string{"all", "dex2oat_only"}, experiments("bar"))
+	// dex2oat_only applies to the modules, but none of them adds its flags.
+	AssertDeepEquals(t, "applied experiments", []string{"all", "foo_only"},
+		appliedToolchainExperiments(result.Config))
+}
+
+func TestToolchainExperimentsErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		experiments string
+		err         string
+	}{
+		{
+			name:        "invalid json",
+			experiments: `{"Experiments": [`,
+			err:         `SOONG_TOOLCHAIN_EXPERIMENTS: failed to load .*: unexpected end of JSON input`,
+		},
+		{
+			name:        "missing name",
+			experiments: `{"Experiments": [{"Percent": 10}]}`,
+			err:         `SOONG_TOOLCHAIN_EXPERIMENTS: failed to load .*: experiment without a name`,
+		},
+		{
+			name:        "duplicate name",
+			experiments: `{"Experiments": [{"Name": "a"}, {"Name": "a"}]}`,
+			err:         `SOONG_TOOLCHAIN_EXPERIMENTS: failed to load .*: duplicate experiment "a"`,
+		},
+		{
+			name:        "invalid percent",
+			experiments: `{"Experiments": [{"Name": "a", "Percent": 101}]}`,
+			err:         `SOONG_TOOLCHAIN_EXPERIMENTS: failed to load .*: experiment "a": Percent must be between 0 and 100, got 101`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			prepareForToolchainExperimentsTest(t, test.experiments).
+				ExtendWithErrorHandler(FixtureExpectsAtLeastOneErrorMatchingPattern(test.err)).
+				RunTest(t)
+		})
+	}
+}
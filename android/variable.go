@@ -207,16 +207,18 @@ type productVariables struct {
 	DeviceArchVariant                     *string  `json:",omitempty"`
 	DeviceCpuVariant                      *string  `json:",omitempty"`
 	DeviceAbi                             []string `json:",omitempty"`
+	DeviceArchFeatures                    []string `json:",omitempty"`
 	DeviceVndkVersion                     *string  `json:",omitempty"`
 	DeviceCurrentApiLevelForVendorModules *string  `json:",omitempty"`
 	DeviceSystemSdkVersions               []string `json:",omitempty"`
 
 	RecoverySnapshotVersion *string `json:",omitempty"`
 
-	DeviceSecondaryArch        *string  `json:",omitempty"`
-	DeviceSecondaryArchVariant *string  `json:",omitempty"`
-	DeviceSecondaryCpuVariant  *string  `json:",omitempty"`
-	DeviceSecondaryAbi         []string `json:",omitempty"`
+	DeviceSecondaryArch         *string  `json:",omitempty"`
+	DeviceSecondaryArchVariant  *string  `json:",omitempty"`
+	DeviceSecondaryCpuVariant   *string  `json:",omitempty"`
+	DeviceSecondaryAbi          []string `json:",omitempty"`
+	DeviceSecondaryArchFeatures []string `json:",omitempty"`
 
 	NativeBridgeArch         *string  `json:",omitempty"`
 	NativeBridgeArchVariant  *string  `json:",omitempty"`
@@ -238,6 +240,10 @@ type productVariables struct {
 	CrossHostArch          *string `json:",omitempty"`
 	CrossHostSecondaryArch *string `json:",omitempty"`
 
+	BaremetalArch        *string `json:",omitempty"`
+	BaremetalArchVariant *string `json:",omitempty"`
+	BaremetalCpuVariant  *string `json:",omitempty"`
+
 	DeviceResourceOverlays     []string `json:",omitempty"`
 	ProductResourceOverlays    []string `json:",omitempty"`
 	EnforceRROTargets          []string `json:",omitempty"`
@@ -290,6 +296,7 @@ type productVariables struct {
 	EnableCFI       *bool    `json:",omitempty"`
 	CFIExcludePaths []string `json:",omitempty"`
 	CFIIncludePaths []string `json:",omitempty"`
+	CFIArches       []string `json:",omitempty"`
 
 	DisableScudo *bool `json:",omitempty"`
 
@@ -297,6 +304,9 @@ type productVariables struct {
 	MemtagHeapAsyncIncludePaths []string `json:",omitempty"`
 	MemtagHeapSyncIncludePaths  []string `json:",omitempty"`
 
+	MemtagStackExcludePaths []string `json:",omitempty"`
+	MemtagStackIncludePaths []string `json:",omitempty"`
+
 	VendorPath    *string `json:",omitempty"`
 	OdmPath       *string `json:",omitempty"`
 	ProductPath   *string `json:",omitempty"`
@@ -305,6 +315,8 @@ type productVariables struct {
 	ClangTidy  *bool   `json:",omitempty"`
 	TidyChecks *string `json:",omitempty"`
 
+	TidyDirsConfigFiles []string `json:",omitempty"`
+
 	JavaCoveragePaths        []string `json:",omitempty"`
 	JavaCoverageExcludePaths []string `json:",omitempty"`
 
@@ -377,11 +389,24 @@ type productVariables struct {
 
 	Ndk_abis *bool `json:",omitempty"`
 
-	Flatten_apex                 *bool `json:",omitempty"`
+	Flatten_apex *bool `json:",omitempty"`
+
+	// Set by soong_config.mk when PRODUCT_VENDOR_PROPERTIES has ro.apex.updatable=true, which
+	// updatable_apex.mk adds.
+	UpdatableApex *bool `json:",omitempty"`
+
 	ForceApexSymlinkOptimization *bool `json:",omitempty"`
 	CompressedApex               *bool `json:",omitempty"`
 	Aml_abis                     *bool `json:",omitempty"`
 
+	CompressedApexDisabledList []string `json:",omitempty"`
+
+	ApexSizeBudgets map[string]int64 `json:",omitempty"`
+
+	SelectedApexes map[string]string `json:",omitempty"`
+
+	MinSdkVersionAllowlistFiles []string `json:",omitempty"`
+
 	DexpreoptGlobalConfig *string `json:",omitempty"`
 
 	WithDexpreopt bool `json:",omitempty"`
@@ -413,6 +438,10 @@ type productVariables struct {
 	EnforceInterPartitionJavaSdkLibrary *bool    `json:",omitempty"`
 	InterPartitionJavaLibraryAllowList  []string `json:",omitempty"`
 
+	EnforceUpdatableApexStubs *bool `json:",omitempty"`
+
+	ApexMinSdkVersionSdkLibraryStubs *bool `json:",omitempty"`
+
 	InstallExtraFlattenedApexes *bool `json:",omitempty"`
 
 	BoardUsesRecoveryAsBoot *bool `json:",omitempty"`
@@ -443,6 +472,9 @@ type productVariables struct {
 	SepolicyFreezeTestExtraPrebuiltDirs []string `json:",omitempty"`
 
 	GenerateAidlNdkPlatformBackend bool `json:",omitempty"`
+
+	// Files that provide the release configuration's default values for feature flags.
+	ReleaseFeatureFlagValues []string `json:",omitempty"`
 }
 
 func boolPtr(v bool) *bool {
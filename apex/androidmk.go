@@ -104,7 +104,7 @@ func (a *apexBundle) androidMkForFiles(w io.Writer, apexBundleName, apexName, mo
 	}
 
 	// Avoid creating duplicate build rules for multi-installed APEXes.
-	if proptools.BoolDefault(a.properties.Multi_install_skip_symbol_files, false) {
+	if a.skipSymbolFiles() {
 		return moduleNames
 	}
 
@@ -259,7 +259,10 @@ func (a *apexBundle) androidMkForFiles(w io.Writer, apexBundleName, apexName, mo
 		case nativeSharedLib, nativeExecutable, nativeTest:
 			fmt.Fprintln(w, "LOCAL_MODULE_STEM :=", fi.stem())
 			if ccMod, ok := fi.module.(*cc.Module); ok {
-				if ccMod.UnstrippedOutputFile() != nil {
+				// The symbols of the native libraries may already be installed by Soong, see
+				// buildUnflattenedApex.
+				symbolsInstalled := fi.class == nativeSharedLib && a.installedNativeLibSymbols
+				if ccMod.UnstrippedOutputFile() != nil && !symbolsInstalled {
 					fmt.Fprintln(w, "LOCAL_SOONG_UNSTRIPPED_BINARY :=", ccMod.UnstrippedOutputFile().String())
 				}
 				ccMod.AndroidMkWriteAdditionalDependenciesForSourceAbiDiff(w)
@@ -413,6 +416,24 @@ func (a *apexBundle) androidMkForType() android.AndroidMkData {
 					fmt.Fprintf(w, "$(call dist-for-goals,%s,%s:%s)\n",
 						goal, a.installedFilesFile.String(), distFile)
 				}
+				if a.sizeReportFile != nil {
+					fmt.Fprintf(w, "$(call dist-for-goals,checkbuild,%s:%s)\n",
+						a.sizeReportFile.String(), name+"-size.txt")
+				}
+				if a.keyRotationNextFile != nil {
+					// The APEXs signed with the current and the next key have the same file name,
+					// so they are dist'ed to separate directories.
+					fmt.Fprintln(w, ".PHONY: apex_key_rotation")
+					fmt.Fprintf(w, "$(call dist-for-goals,apex_key_rotation,%s:apex_key_rotation/current/%s)\n",
+						a.keyRotationCurrentFile.String(), a.keyRotationCurrentFile.Base())
+					fmt.Fprintf(w, "$(call dist-for-goals,apex_key_rotation,%s:apex_key_rotation/next/%s)\n",
+						a.keyRotationNextFile.String(), a.keyRotationNextFile.Base())
+				}
+				if a.exportedSdkAbiDumpsFile != nil {
+					fmt.Fprintln(w, ".PHONY:", exportedSdkDistGoal)
+					fmt.Fprintf(w, "$(call dist-for-goals,%s,%s:%s/%s)\n", exportedSdkDistGoal,
+						a.exportedSdkAbiDumpsFile.String(), exportedSdkDistGoal, a.exportedSdkAbiDumpsFile.Base())
+				}
 				for _, dist := range data.Entries.GetDistForGoals(a) {
 					fmt.Fprintf(w, dist)
 				}
@@ -22,6 +22,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/bootstrap"
@@ -55,6 +56,8 @@ func registerApexBuildComponents(ctx android.RegistrationContext) {
 	ctx.PreArchMutators(registerPreArchMutators)
 	ctx.PreDepsMutators(RegisterPreDepsMutators)
 	ctx.PostDepsMutators(RegisterPostDepsMutators)
+
+	ctx.RegisterSingletonType("apex_available_suggestions", apexAvailableSuggestionsSingletonFactory)
 }
 
 func registerPreArchMutators(ctx android.RegisterMutatorsContext) {
@@ -67,6 +70,7 @@ func RegisterPreDepsMutators(ctx android.RegisterMutatorsContext) {
 }
 
 func RegisterPostDepsMutators(ctx android.RegisterMutatorsContext) {
+	ctx.TopDown("apex_minimal_analysis", apexMinimalAnalysisMutator).Parallel()
 	ctx.TopDown("apex_info", apexInfoMutator).Parallel()
 	ctx.BottomUp("apex_unique", apexUniqueVariationsMutator).Parallel()
 	ctx.BottomUp("apex_test_for_deps", apexTestForDepsMutator).Parallel()
@@ -81,17 +85,21 @@ func RegisterPostDepsMutators(ctx android.RegisterMutatorsContext) {
 	ctx.TopDown("apex_strict_updatability_lint", apexStrictUpdatibilityLintMutator).Parallel()
 }
 
-type apexBundleProperties struct {
-	// Json manifest file describing meta info of this APEX bundle. Refer to
-	// system/apex/proto/apex_manifest.proto for the schema. Default: "apex_manifest.json"
-	Manifest *string `android:"path"`
+type apexFsConfigEntry struct {
+	// Path of the file in the APEX, e.g. "bin/foo".
+	Path *string
 
-	// AndroidManifest.xml file used for the zip container of this APEX bundle. If unspecified,
-	// a default one is automatically generated.
-	AndroidManifest *string `android:"path"`
+	android.ApexFsConfig
+}
 
+type apexBundleProperties struct {
 	// Canonical name of this APEX bundle. Used to determine the path to the activated APEX on
-	// device (/apex/<apex_name>). If unspecified, follows the name property.
+	// device (/apex/<apex_name>). If unspecified, follows the name property. Several APEX
+	// bundles can share an apex_name to build different versions of the same APEX, e.g.
+	// com.android.foo and com.android.foo.v2. Each is installed to its own path, and the product
+	// can select the one that is installed with the SelectedApexes product variable. The selected
+	// version, or else the first by name, is the only one that installs the symbol files and the
+	// flattened APEX.
 	Apex_name *string
 
 	// Determines the file contexts file for setting the security contexts to files in this APEX
@@ -99,6 +107,11 @@ type apexBundleProperties struct {
 	// /system/sepolicy/apex/<module_name>_file_contexts.
 	File_contexts *string `android:"path"`
 
+	// Whether the file_contexts file may contain patterns that don't match any file or directory
+	// in this APEX bundle, e.g. because it is shared with a debug variant of the APEX that
+	// contains more files. Default: false.
+	Allow_stale_file_contexts *bool
+
 	// Path to the canned fs config file for customizing file's uid/gid/mod/capabilities. The
 	// format is /<path_or_glob> <uid> <gid> <mode> [capabilities=0x<cap>], where path_or_glob is a
 	// path or glob pattern for a file or set of files, uid/gid are numerial values of user ID
@@ -107,6 +120,11 @@ type apexBundleProperties struct {
 	// is used.
 	Canned_fs_config *string `android:"path"`
 
+	// Additional canned_fs_config entries for files in this APEX. Unlike canned_fs_config,
+	// entries conflicting with each other or with the ones requested by the modules in the
+	// payload (e.g. apex_fs_config of cc_binary) are reported as errors.
+	Canned_fs_config_entries []apexFsConfigEntry
+
 	ApexNativeDependencies
 
 	Multilib apexMultilibProperties
@@ -153,12 +171,17 @@ type apexBundleProperties struct {
 	// Multi-installed APEXes share the same apex_name and are installed at the same time.
 	// Default is false.
 	//
-	// Should be set to true for all multi-installed APEXes except the singular
-	// default version within the multi-installed group.
-	// Only the default version can install symbol files in $(PRODUCT_OUT}/apex,
-	// or else conflicting build rules may be created.
+	// Only the default version within the multi-installed group can install symbol files in
+	// $(PRODUCT_OUT}/apex, or else conflicting build rules may be created. The other versions
+	// skip them even if this is false, see defaultMultiInstalledApex.
 	Multi_install_skip_symbol_files *bool
 
+	// Whether to create an sdk module named <apex>-exported-sdk with the API surface of this APEX,
+	// i.e. the stubs of its native shared libraries and its java_sdk_library modules, and to build
+	// the ABI dumps of the native shared libraries with stubs. Both are dist'ed so that other
+	// branches can build against the APEX without its sources. Default is false.
+	Generate_exported_sdk *bool
+
 	// The type of APEX to build. Controls what the APEX payload is. Either 'image', 'zip' or
 	// 'both'. When set to image, contents are stored in a filesystem image inside a zip
 	// container. When set to zip, contents are stored in a zip container directly. This type is
@@ -170,6 +193,11 @@ type apexBundleProperties struct {
 	// or 'erofs'. Default 'ext4'.
 	Payload_fs_type *string
 
+	// List of modules that must never be in the payload of this APEX, even as transitive
+	// dependencies of its contents. An entry ending with "*" matches any module name with the
+	// preceding prefix, e.g. "libcrypto*".
+	Payload_dep_blocklist []string
+
 	// For telling the APEX to ignore special handling for system libraries such as bionic.
 	// Default is false.
 	Ignore_system_library_special_case *bool
@@ -313,6 +341,29 @@ type overridableProperties struct {
 	// List of java libraries that are embedded inside this APEX bundle.
 	Java_libs []string
 
+	// List of java libraries to embed in addition to java_libs. Only allowed in override_apex, so
+	// that it doesn't have to repeat the java_libs of the base APEX.
+	Add_java_libs []string
+
+	// List of java libraries of java_libs that are not embedded. Only allowed in override_apex.
+	Remove_java_libs []string
+
+	// List of native shared libraries to embed in addition to those of the base APEX, for all
+	// ABIs. Only allowed in override_apex.
+	Add_native_shared_libs []string
+
+	// List of native shared libraries of the base APEX that are not embedded directly. They are
+	// still embedded if other modules in the APEX depend on them. Only allowed in override_apex.
+	Remove_native_shared_libs []string
+
+	// Json manifest file describing meta info of this APEX bundle. Refer to
+	// system/apex/proto/apex_manifest.proto for the schema. Default: "apex_manifest.json"
+	Manifest *string `android:"path"`
+
+	// AndroidManifest.xml file used for the zip container of this APEX bundle. If unspecified,
+	// a default one is automatically generated.
+	AndroidManifest *string `android:"path"`
+
 	// Names of modules to be overridden. Listed modules can only be other binaries (in Make or
 	// Soong). This does not completely prevent installation of the overridden binaries, but if
 	// both binaries would be installed by default (in PRODUCT_PACKAGES) the other binary will
@@ -329,6 +380,11 @@ type overridableProperties struct {
 	// A txt file containing list of files that are allowed to be included in this APEX.
 	Allowed_files *string `android:"path"`
 
+	// A txt file containing the exact list of files that must be included in this APEX, one
+	// path relative to the root of the APEX per line starting with "./", sorted. The build fails
+	// with a diff if files are added to or removed from this APEX without updating the list.
+	Golden_contents *string `android:"path"`
+
 	// Name of the apex_key module that provides the private key to sign this APEX bundle.
 	Key *string
 
@@ -339,11 +395,23 @@ type overridableProperties struct {
 	// named "module".
 	Certificate *string
 
+	// Name of the signing certificate lineage file or filegroup module, used to sign the zip
+	// container of this APEX with a rotated certificate.
+	Lineage *string `android:"path"`
+
+	// The minimum SDK version at which the rotated certificate in the lineage is used to sign the
+	// zip container. Passed as --rotation-min-sdk-version to apksigner.
+	Rotation_min_sdk_version *string
+
 	// Whether this APEX can be compressed or not. Setting this property to false means this
 	// APEX will never be compressed. When set to true, APEX will be compressed if other
 	// conditions, e.g., target device needs to support APEX compression, are also fulfilled.
 	// Default: false.
 	Compressible *bool
+
+	// Maximum size in bytes of the uncompressed APEX file. The build fails when the APEX is
+	// larger than this. A size budget set by the product for this APEX takes precedence.
+	Max_size *int64
 }
 
 type apexBundle struct {
@@ -368,6 +436,10 @@ type apexBundle struct {
 	publicKeyFile  android.Path
 	privateKeyFile android.Path
 
+	// Keys for apex_payload.img of the next key of the apex_key, if any.
+	nextPublicKeyFile  android.Path
+	nextPrivateKeyFile android.Path
+
 	// Cert/priv-key for the zip container
 	containerCertificateFile android.Path
 	containerPrivateKeyFile  android.Path
@@ -390,13 +462,26 @@ type apexBundle struct {
 	// not
 	linkToSystemLib bool
 
+	// Whether another apex module sharing the apex_name of this one is the default version of the
+	// APEX, see defaultMultiInstalledApex.
+	notDefaultMultiInstalledApex bool
+
 	// List of files to be included in this APEX. This is filled in the first part of
 	// GenerateAndroidBuildActions.
 	filesInfo []apexFile
 
+	// The bpmodify commands that add the apex_available entries missing for the dependencies of
+	// this APEX, collected when APEX_AVAILABLE_SUGGESTIONS is set. They are written once for all
+	// variants of the APEX by apexAvailableSuggestionsSingleton.
+	apexAvailableSuggestions []string
+
 	// List of other module names that should be installed when this APEX gets installed.
 	requiredDeps []string
 
+	// Whether the unstripped native libraries in this APEX are installed to the symbols
+	// directory by Soong, instead of by Make from the Android.mk modules of the libraries.
+	installedNativeLibSymbols bool
+
 	///////////////////////////////////////////////////////////////////////////////////////////
 	// Outputs (final and intermediates)
 
@@ -439,6 +524,22 @@ type apexBundle struct {
 	// debugging purpose.
 	installedFilesFile android.WritablePath
 
+	// Text file having the size of the APEX, both uncompressed and compressed if the APEX is
+	// compressed.
+	sizeReportFile android.WritablePath
+
+	// DOT file having the dependency graph of the payload of this APEX.
+	depsGraphFile android.WritablePath
+
+	// The APEX signed with the current and with the next key of the apex_key, when building for a
+	// key rotation dry run.
+	keyRotationCurrentFile android.Path
+	keyRotationNextFile    android.Path
+
+	// Zip file with the ABI dumps of the native shared libraries with stubs, see the
+	// generate_exported_sdk property.
+	exportedSdkAbiDumpsFile android.Path
+
 	// List of module names that this APEX is including (to be shown via *-deps-info target).
 	// Used for debugging purpose.
 	android.ApexBundleDepsInfo
@@ -502,6 +603,7 @@ type apexFile struct {
 	lintDepSets             java.LintDepSets // only for javalibs and apps
 	certificate             java.Certificate // only for apps
 	overriddenPackageName   string           // only for apps
+	unstrippedBuiltFile     android.Path     // only for native libraries
 
 	transitiveDep bool
 	isJniLib      bool
@@ -791,6 +893,14 @@ func (a *apexBundle) OverridablePropertiesDepsMutator(ctx android.BottomUpMutato
 	if a.overridableProperties.Allowed_files != nil {
 		android.ExtractSourceDeps(ctx, a.overridableProperties.Allowed_files)
 	}
+	if a.overridableProperties.Golden_contents != nil {
+		android.ExtractSourceDeps(ctx, a.overridableProperties.Golden_contents)
+	}
+	if a.GetOverriddenBy() != "" {
+		// The path dependencies of the base APEX were added before the manifests were overridden.
+		android.ExtractSourceDeps(ctx, a.overridableProperties.Manifest)
+		android.ExtractSourceDeps(ctx, a.overridableProperties.AndroidManifest)
+	}
 
 	commonVariation := ctx.Config().AndroidCommonTarget.Variations()
 	ctx.AddFarVariationDependencies(commonVariation, androidAppTag, a.overridableProperties.Apps...)
@@ -798,7 +908,8 @@ func (a *apexBundle) OverridablePropertiesDepsMutator(ctx android.BottomUpMutato
 	ctx.AddFarVariationDependencies(commonVariation, rroTag, a.overridableProperties.Rros...)
 	ctx.AddFarVariationDependencies(commonVariation, bcpfTag, a.overridableProperties.Bootclasspath_fragments...)
 	ctx.AddFarVariationDependencies(commonVariation, sscpfTag, a.overridableProperties.Systemserverclasspath_fragments...)
-	ctx.AddFarVariationDependencies(commonVariation, javaLibTag, a.overridableProperties.Java_libs...)
+	ctx.AddFarVariationDependencies(commonVariation, javaLibTag, a.javaLibs()...)
+	a.addOverriddenPayloadDeps(ctx)
 	if prebuilts := a.overridableProperties.Prebuilts; len(prebuilts) > 0 {
 		// For prebuilt_etc, use the first variant (64 on 64/32bit device, 32 on 32bit device)
 		// regardless of the TARGET_PREFER_* setting. See b/144532908
@@ -834,6 +945,94 @@ func (a *apexBundle) OverridablePropertiesDepsMutator(ctx android.BottomUpMutato
 	}
 }
 
+// javaLibs returns the java libraries that are embedded in the APEX, i.e. java_libs with the
+// changes of add_java_libs and remove_java_libs.
+func (a *apexBundle) javaLibs() []string {
+	javaLibs := android.RemoveListFromList(a.overridableProperties.Java_libs, a.overridableProperties.Remove_java_libs)
+	return android.FirstUniqueStrings(append(javaLibs, a.overridableProperties.Add_java_libs...))
+}
+
+// nativeSharedLibs returns the native shared libraries that are listed in the properties of the
+// APEX for any ABI.
+func (a *apexBundle) nativeSharedLibs() []string {
+	var libs []string
+	libs = append(libs, a.properties.Native_shared_libs...)
+	for _, deps := range []ApexNativeDependencies{
+		a.properties.Multilib.Both,
+		a.properties.Multilib.First,
+		a.properties.Multilib.Lib32,
+		a.properties.Multilib.Lib64,
+		a.properties.Multilib.Prefer32,
+		a.archProperties.Arch.Arm.ApexNativeDependencies,
+		a.archProperties.Arch.Arm64.ApexNativeDependencies,
+		a.archProperties.Arch.X86.ApexNativeDependencies,
+		a.archProperties.Arch.X86_64.ApexNativeDependencies,
+	} {
+		libs = append(libs, deps.Native_shared_libs...)
+	}
+	return android.FirstUniqueStrings(libs)
+}
+
+// addOverriddenPayloadDeps checks the properties of override_apex that add or remove
+// individual native shared libraries and java libraries, and adds the dependencies on the added
+// native shared libraries. The dependencies on the native shared libraries of the base APEX were
+// added by DepsMutator before the properties were overridden, so the removed ones are instead
+// skipped by the walks over the contents of the APEX, see removedByOverride.
+func (a *apexBundle) addOverriddenPayloadDeps(ctx android.BottomUpMutatorContext) {
+	o := a.overridableProperties
+	if a.GetOverriddenBy() == "" {
+		for _, p := range []struct {
+			name  string
+			value []string
+		}{
+			{"add_java_libs", o.Add_java_libs},
+			{"remove_java_libs", o.Remove_java_libs},
+			{"add_native_shared_libs", o.Add_native_shared_libs},
+			{"remove_native_shared_libs", o.Remove_native_shared_libs},
+		} {
+			if len(p.value) > 0 {
+				ctx.PropertyErrorf(p.name, "is only allowed in override_apex")
+			}
+		}
+		return
+	}
+
+	for _, lib := range o.Remove_java_libs {
+		if !android.InList(lib, o.Java_libs) {
+			ctx.PropertyErrorf("remove_java_libs", "%q is not in java_libs", lib)
+		}
+	}
+	nativeSharedLibs := a.nativeSharedLibs()
+	for _, lib := range o.Remove_native_shared_libs {
+		if !android.InList(lib, nativeSharedLibs) {
+			ctx.PropertyErrorf("remove_native_shared_libs", "%q is not a native shared library of the base apex", lib)
+		}
+	}
+
+	// Libraries that the base APEX already has a dependency on for all ABIs don't need another one.
+	allAbis := append(android.CopyOf(a.properties.Native_shared_libs), a.properties.Multilib.Both.Native_shared_libs...)
+	added := android.RemoveListFromList(android.FirstUniqueStrings(o.Add_native_shared_libs), allAbis)
+	if len(added) == 0 {
+		return
+	}
+	imageVariation := a.getImageVariation(ctx)
+	for _, target := range ctx.MultiTargets() {
+		if target.HostCross {
+			continue
+		}
+		addDependenciesForNativeModules(ctx, ApexNativeDependencies{Native_shared_libs: added}, target, imageVariation)
+	}
+}
+
+// removedByOverride returns true if the direct dependency of the APEX with the given tag and name
+// is a native shared library that remove_native_shared_libs of an override_apex removed, and that
+// add_native_shared_libs didn't add back.
+func (a *apexBundle) removedByOverride(depTag blueprint.DependencyTag, depName string) bool {
+	o := a.overridableProperties
+	return depTag == sharedLibTag && android.InList(depName, o.Remove_native_shared_libs) &&
+		!android.InList(depName, o.Add_native_shared_libs)
+}
+
 type ApexBundleInfo struct {
 	Contents *android.ApexContents
 }
@@ -904,6 +1103,9 @@ func (a *apexBundle) ApexInfoMutator(mctx android.TopDownMutatorContext) {
 		if !android.IsDepInSameApex(mctx, parent, child) {
 			return false
 		}
+		if parent == mctx.Module() && a.removedByOverride(depTag, mctx.OtherModuleName(child)) {
+			return false
+		}
 		if excludeVndkLibs {
 			if c, ok := child.(*cc.Module); ok && c.IsVndk() {
 				return false
@@ -973,6 +1175,47 @@ type ApexInfoMutator interface {
 	ApexInfoMutator(android.TopDownMutatorContext)
 }
 
+var apexAnalysisClosureKey = android.NewOnceKey("apexAnalysisClosure")
+
+// apexAnalysisClosure returns the set of modules that are transitive dependencies of the apps and
+// APEXes requested by an unbundled build, see apexMinimalAnalysisMutator.
+func apexAnalysisClosure(config android.Config) *sync.Map {
+	return config.Once(apexAnalysisClosureKey, func() interface{} {
+		return &sync.Map{}
+	}).(*sync.Map)
+}
+
+// apexMinimalAnalysisMutator limits the analysis of unbundled builds to the transitive
+// dependencies of the requested apps and APEXes when android.Config.MinimalApexAnalysis is true.
+// Other device modules, including the APEXes that weren't requested, are disabled before
+// apexInfoMutator, so that no APEX variants are created for their contents and they are not
+// analyzed. As a top down mutator visits a module after all of its reverse dependencies, a module
+// is known to be outside of the closure if none of them added it.
+func apexMinimalAnalysisMutator(mctx android.TopDownMutatorContext) {
+	if !mctx.Config().MinimalApexAnalysis() {
+		return
+	}
+
+	module := mctx.Module()
+	name := mctx.ModuleName()
+	if o, ok := module.(android.OverridableModule); ok && o.GetOverriddenBy() != "" {
+		name = o.GetOverriddenBy()
+	}
+
+	closure := apexAnalysisClosure(mctx.Config())
+	if _, ok := closure.Load(module); !ok && !android.InList(name, mctx.Config().UnbundledBuildAppsList()) {
+		// Host modules are kept as they provide the tools used by the build.
+		if module.Target().Os.Class == android.Device {
+			module.Disable()
+		}
+		return
+	}
+
+	mctx.VisitDirectDeps(func(dep android.Module) {
+		closure.Store(dep, true)
+	})
+}
+
 // apexInfoMutator delegates the work of identifying which modules need an ApexInfo and apex
 // specific variant to modules that support the ApexInfoMutator.
 // It also propagates updatable=true to apps of updatable apexes
@@ -984,6 +1227,9 @@ func apexInfoMutator(mctx android.TopDownMutatorContext) {
 	if a, ok := mctx.Module().(ApexInfoMutator); ok {
 		a.ApexInfoMutator(mctx)
 	}
+	if a, ok := mctx.Module().(*apexBundle); ok && !a.vndkApex {
+		multiInstalledApexesForConfig(mctx.Config()).add(a.ApexVariationName(), mctx.ModuleName())
+	}
 	enforceAppUpdatability(mctx)
 }
 
@@ -1084,6 +1330,18 @@ func apexTestForDepsMutator(mctx android.BottomUpMutatorContext) {
 	}
 	if am, ok := mctx.Module().(android.ApexModule); ok {
 		if testFor := am.TestFor(); len(testFor) > 0 {
+			if !am.IsTestModule() {
+				mctx.PropertyErrorf("test_for", "is only allowed on test modules")
+				return
+			}
+			for _, name := range testFor {
+				if !mctx.OtherModuleExists(name) && !mctx.Config().AllowMissingDependencies() {
+					mctx.PropertyErrorf("test_for", "%q is not a valid module name", name)
+				}
+			}
+			if mctx.Failed() {
+				return
+			}
 			mctx.AddFarVariationDependencies([]blueprint.Variation{
 				{Mutator: "os", Variation: am.Target().OsVariation()},
 				{"arch", "common"},
@@ -1100,6 +1358,13 @@ func apexTestForMutator(mctx android.BottomUpMutatorContext) {
 	if _, ok := mctx.Module().(android.ApexModule); ok {
 		var contents []*android.ApexContents
 		for _, testFor := range mctx.GetDirectDepsWithTag(testForTag) {
+			if !mctx.OtherModuleHasProvider(testFor, ApexBundleInfoProvider) {
+				// Disabled APEXes have no contents to give access to.
+				if testFor.Enabled() {
+					mctx.PropertyErrorf("test_for", "%q is not an apex", mctx.OtherModuleName(testFor))
+				}
+				continue
+			}
 			abInfo := mctx.OtherModuleProvider(testFor, ApexBundleInfoProvider).(ApexBundleInfo)
 			contents = append(contents, abInfo.Contents)
 		}
@@ -1126,16 +1391,32 @@ func markPlatformAvailability(mctx android.BottomUpMutatorContext) {
 	}
 
 	availableToPlatform := am.AvailableFor(android.AvailableToPlatform)
+	// The reason is the chain of dependencies to the module that isn't available to platform, so
+	// that it can be reported when the platform variant of this module is used.
+	var reason string
+	if !availableToPlatform {
+		reason = fmt.Sprintf("%s: apex_available doesn't include %q", mctx.ModuleName(), android.AvailableToPlatform)
+	}
 
 	// If any of the dep is not available to platform, this module is also considered as being
 	// not available to platform even if it has "//apex_available:platform"
+	testFor := mctx.Provider(android.ApexTestForInfoProvider).(android.ApexTestForInfo)
 	mctx.VisitDirectDeps(func(child android.Module) {
 		if !android.IsDepInSameApex(mctx, am, child) {
 			// if the dependency crosses apex boundary, don't consider it
 			return
 		}
+		// A test has access to the contents of the APEXes it is a test for, see test_for.
+		for _, apexContents := range testFor.ApexContents {
+			if apexContents.DirectlyInApex(mctx.OtherModuleName(child)) {
+				return
+			}
+		}
 		if dep, ok := child.(android.ApexModule); ok && dep.NotAvailableForPlatform() {
 			availableToPlatform = false
+			if reason == "" {
+				reason = mctx.ModuleName() + " -> " + dep.NotAvailableForPlatformReason()
+			}
 			// TODO(b/154889534) trigger an error when 'am' has
 			// "//apex_available:platform"
 		}
@@ -1152,7 +1433,7 @@ func markPlatformAvailability(mctx android.BottomUpMutatorContext) {
 	}
 
 	if !availableToPlatform {
-		am.SetNotAvailableForPlatform()
+		am.SetNotAvailableForPlatform(reason)
 	}
 }
 
@@ -1417,6 +1698,63 @@ func (a *apexBundle) getCertString(ctx android.BaseModuleContext) string {
 	return String(a.overridableProperties.Certificate)
 }
 
+// isSelectedApex returns false if the SelectedApexes product variable selects another module than
+// moduleName to be installed for the APEX named apexName. It is used by apex, prebuilt_apex and
+// apex_set modules, which can all share an apex_name.
+func isSelectedApex(ctx android.BaseModuleContext, moduleName, apexName string) bool {
+	selected, ok := ctx.Config().SelectedApex(apexName)
+	return !ok || selected == moduleName
+}
+
+// multiInstalledApexes records the apex modules for each apex_name, to find the APEXes that are
+// built in several versions.
+type multiInstalledApexes struct {
+	sync.Mutex
+	byApexName map[string][]string
+}
+
+var multiInstalledApexesKey = android.NewOnceKey("multiInstalledApexes")
+
+func multiInstalledApexesForConfig(config android.Config) *multiInstalledApexes {
+	return config.Once(multiInstalledApexesKey, func() interface{} {
+		return &multiInstalledApexes{byApexName: make(map[string][]string)}
+	}).(*multiInstalledApexes)
+}
+
+func (m *multiInstalledApexes) add(apexName, moduleName string) {
+	m.Lock()
+	defer m.Unlock()
+	m.byApexName[apexName] = append(m.byApexName[apexName], moduleName)
+}
+
+// get returns the sorted names of the apex modules with the given apex_name.
+func (m *multiInstalledApexes) get(apexName string) []string {
+	m.Lock()
+	defer m.Unlock()
+	return android.SortedUniqueStrings(m.byApexName[apexName])
+}
+
+// defaultMultiInstalledApex returns the apex module that is the default version of the APEX named
+// apexName: the module selected by the SelectedApexes product variable, or else the first one
+// by name. Only the default version installs the files that are shared by all versions, like the
+// symbol files in $(PRODUCT_OUT)/apex/<apex_name> and the flattened APEX, to avoid duplicate
+// build rules.
+func defaultMultiInstalledApex(ctx android.BaseModuleContext, apexName string) string {
+	if selected, ok := ctx.Config().SelectedApex(apexName); ok {
+		return selected
+	}
+	if modules := multiInstalledApexesForConfig(ctx.Config()).get(apexName); len(modules) > 0 {
+		return modules[0]
+	}
+	return ""
+}
+
+// skipSymbolFiles returns true if this APEX doesn't install its symbol files in
+// $(PRODUCT_OUT)/apex/<apex_name>, as another version of the APEX installs them.
+func (a *apexBundle) skipSymbolFiles() bool {
+	return proptools.Bool(a.properties.Multi_install_skip_symbol_files) || a.notDefaultMultiInstalledApex
+}
+
 // See the installable property
 func (a *apexBundle) installable() bool {
 	return !a.properties.PreventInstall && (a.properties.Installable == nil || proptools.Bool(a.properties.Installable))
@@ -1517,7 +1855,9 @@ func apexFileForNativeLibrary(ctx android.BaseModuleContext, ccMod *cc.Module, h
 
 	fileToCopy := ccMod.OutputFile().Path()
 	androidMkModuleName := ccMod.BaseModuleName() + ccMod.Properties.SubName
-	return newApexFile(ctx, fileToCopy, androidMkModuleName, dirInApex, nativeSharedLib, ccMod)
+	af := newApexFile(ctx, fileToCopy, androidMkModuleName, dirInApex, nativeSharedLib, ccMod)
+	af.unstrippedBuiltFile = ccMod.UnstrippedOutputFile()
+	return af
 }
 
 func apexFileForExecutable(ctx android.BaseModuleContext, cc *cc.Module) apexFile {
@@ -1560,7 +1900,9 @@ func apexFileForRustLibrary(ctx android.BaseModuleContext, rustm *rust.Module) a
 	}
 	fileToCopy := rustm.OutputFile().Path()
 	androidMkModuleName := rustm.BaseModuleName() + rustm.Properties.SubName
-	return newApexFile(ctx, fileToCopy, androidMkModuleName, dirInApex, nativeSharedLib, rustm)
+	af := newApexFile(ctx, fileToCopy, androidMkModuleName, dirInApex, nativeSharedLib, rustm)
+	af.unstrippedBuiltFile = rustm.UnstrippedOutputFile()
+	return af
 }
 
 func apexFileForPyBinary(ctx android.BaseModuleContext, py *python.Module) apexFile {
@@ -1720,9 +2062,10 @@ func apexFileForFilesystem(ctx android.BaseModuleContext, buildFile android.Path
 }
 
 // WalkPayloadDeps visits dependencies that contributes to the payload of this APEX. For each of the
-// visited module, the `do` callback is executed. Returning true in the callback continues the visit
-// to the child modules. Returning false makes the visit to continue in the sibling or the parent
-// modules. This is used in check* functions below.
+// visited module, the `do` callback is executed with the dependency tag and the dependency path
+// through which the module is reached. Returning true in the callback continues the visit to the
+// child modules. Returning false makes the visit to continue in the sibling or the parent modules.
+// This is used in check* functions below.
 func (a *apexBundle) WalkPayloadDeps(ctx android.ModuleContext, do android.PayloadDepsCallback) {
 	ctx.WalkDeps(func(child, parent android.Module) bool {
 		am, ok := child.(android.ApexModule)
@@ -1738,12 +2081,15 @@ func (a *apexBundle) WalkPayloadDeps(ctx android.ModuleContext, do android.Paylo
 		if dt, ok := depTag.(dependencyTag); ok && !dt.payload {
 			return false
 		}
+		if parent == ctx.Module() && a.removedByOverride(depTag, ctx.OtherModuleName(child)) {
+			return false
+		}
 
 		ai := ctx.OtherModuleProvider(child, android.ApexInfoProvider).(android.ApexInfo)
 		externalDep := !android.InList(ctx.ModuleName(), ai.InApexVariants)
 
 		// Visit actually
-		return do(ctx, parent, am, externalDep)
+		return do(ctx, parent, am, externalDep, depTag, ctx.GetWalkPath())
 	})
 }
 
@@ -1783,6 +2129,17 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	a.CheckMinSdkVersion(ctx)
 	a.checkStaticLinkingToStubLibraries(ctx)
 	a.checkStaticExecutables(ctx)
+	a.checkPayloadDepBlocklist(ctx)
+	if !isSelectedApex(ctx, a.Name(), a.ApexVariationName()) {
+		a.SetPreventInstall()
+	}
+	if defaultApex := defaultMultiInstalledApex(ctx, a.ApexVariationName()); defaultApex != "" {
+		a.notDefaultMultiInstalledApex = defaultApex != ctx.ModuleName()
+	}
+	if a.notDefaultMultiInstalledApex && a.properties.ApexType == flattenedApex {
+		// The flattened versions would be activated from the same /apex/<apex_name> directory.
+		a.SetPreventInstall()
+	}
 	if len(a.properties.Tests) > 0 && !a.testApex {
 		ctx.PropertyErrorf("tests", "property allowed only in apex_test module type")
 		return
@@ -1815,6 +2172,9 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		}
 		depName := ctx.OtherModuleName(child)
 		if _, isDirectDep := parent.(*apexBundle); isDirectDep {
+			if a.removedByOverride(depTag, depName) {
+				return false
+			}
 			switch depTag {
 			case sharedLibTag, jniLibTag:
 				isJniLib := depTag == jniLibTag
@@ -1981,6 +2341,8 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 				if key, ok := child.(*apexKey); ok {
 					a.privateKeyFile = key.privateKeyFile
 					a.publicKeyFile = key.publicKeyFile
+					a.nextPrivateKeyFile = key.nextPrivateKeyFile
+					a.nextPublicKeyFile = key.nextPublicKeyFile
 				} else {
 					ctx.PropertyErrorf("key", "%q is not an apex_key module", depName)
 				}
@@ -2117,6 +2479,10 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 					switch child.(type) {
 					case *java.Library, *java.SdkLibrary:
 						af := apexFileForJavaModule(ctx, child.(javaModule))
+						if !af.ok() {
+							ctx.PropertyErrorf("systemserverclasspath_fragments", "systemserverclasspath_fragment content %q is not configured to be compiled into dex", depName)
+							return false
+						}
 						filesInfo = append(filesInfo, af)
 						return true // track transitive dependencies
 					default:
@@ -2171,6 +2537,7 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	// 3) some fields in apexBundle struct are configured
 	a.installDir = android.PathForModuleInstall(ctx, "apex")
 	a.filesInfo = filesInfo
+	a.checkSystemServerClasspathFragments(ctx)
 
 	// Set suffix and primaryApexType depending on the ApexType
 	buildFlattenedAsDefault := ctx.Config().FlattenApex()
@@ -2254,6 +2621,15 @@ func (a *apexBundle) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	}
 	a.buildApexDependencyInfo(ctx)
 	a.buildLintReports(ctx)
+	if proptools.Bool(a.properties.Generate_exported_sdk) && a.primaryApexType {
+		a.buildExportedSdkAbiDumps(ctx)
+	}
+	if a.properties.ApexType != flattenedApex && ctx.Device() {
+		// Let host tests of the APEX tooling use the APEX as data.
+		ctx.SetProvider(android.HostTestDataProvider, android.HostTestDataInfo{
+			Files: android.Paths{a.outputFile},
+		})
+	}
 
 	// Append meta-files to the filesInfo list so that they are reflected in Android.mk as well.
 	if a.installable() {
@@ -2525,7 +2901,9 @@ func (a *apexBundle) checkStaticLinkingToStubLibraries(ctx android.ModuleContext
 
 	abInfo := ctx.Provider(ApexBundleInfoProvider).(ApexBundleInfo)
 
-	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool) bool {
+	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool,
+		tag blueprint.DependencyTag, path []android.Module) bool {
+
 		if ccm, ok := to.(*cc.Module); ok {
 			apexName := ctx.ModuleName()
 			fromName := ctx.OtherModuleName(from)
@@ -2577,6 +2955,72 @@ func (a *apexBundle) checkUpdatable(ctx android.ModuleContext) {
 		}
 		a.checkJavaStableSdkVersion(ctx)
 		a.checkClasspathFragments(ctx)
+		if ctx.Config().EnforceUpdatableApexStubs() {
+			a.checkStubsAtBoundary(ctx)
+		}
+	}
+}
+
+// checkStubsAtBoundary ensures that the dependencies of the contents of an updatable APEX on
+// modules outside of the APEX go through stubs or the SDK, so that the APEX doesn't rely on
+// implementation details of the platform or of other APEXes.
+func (a *apexBundle) checkStubsAtBoundary(ctx android.ModuleContext) {
+	if ctx.Host() || a.testApex {
+		return
+	}
+
+	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool,
+		tag blueprint.DependencyTag, path []android.Module) bool {
+
+		if !externalDep {
+			// It is ok to call DepIsInSameApex() directly from within WalkPayloadDeps().
+			if am, ok := from.(android.DepIsInSameApex); ok && !am.DepIsInSameApex(ctx, to) {
+				return false
+			}
+			return true
+		}
+
+		fromName := ctx.OtherModuleName(from)
+		toName := android.RemoveOptionalPrebuiltPrefix(ctx.OtherModuleName(to))
+		if reason := nonStubBoundaryDepReason(from, to, toName, tag); reason != "" {
+			ctx.ModuleErrorf("%q links against the implementation of %q across the boundary of the "+
+				"updatable APEX, but %s.\n\nDependency path:%s",
+				fromName, toName, reason, ctx.GetPathString(true))
+		}
+		// As soon as the dependency graph crosses the APEX boundary, don't go further.
+		return false
+	})
+}
+
+// nonStubBoundaryDepReason returns why the dependency from `from` to `to`, which is outside of the
+// APEX, doesn't go through stubs or the SDK, or an empty string if it does.
+func nonStubBoundaryDepReason(from blueprint.Module, to android.ApexModule, toName string,
+	tag blueprint.DependencyTag) string {
+
+	switch to := to.(type) {
+	case *cc.Module:
+		if !cc.IsSharedDepTag(tag) && !cc.IsStaticDepTag(tag) {
+			return ""
+		}
+		if fromCc, ok := from.(*cc.Module); ok && fromCc.Static() && cc.IsSharedDepTag(tag) {
+			// Static libraries are not linked against their shared library dependencies.
+			return ""
+		}
+		if to.IsStubs() || to.HasStubsVariants() || to.IsLlndk() {
+			return ""
+		}
+		return fmt.Sprintf("%q has no stubs", toName)
+	default:
+		// Java modules are linked against their explicit libs, and the SDK they are built
+		// against. Only the former can be implementation libraries.
+		fromJava, ok := from.(interface{ CompilerDeps() []string })
+		if !ok || !android.InList(toName, fromJava.CompilerDeps()) {
+			return ""
+		}
+		if _, ok := to.(java.SdkLibraryDependency); ok {
+			return ""
+		}
+		return fmt.Sprintf("%q is not a java_sdk_library", toName)
 	}
 }
 
@@ -2592,6 +3036,45 @@ func (a *apexBundle) checkClasspathFragments(ctx android.ModuleContext) {
 	})
 }
 
+// checkSystemServerClasspathFragments enforces that the jars in the classpaths.proto config of the
+// systemserverclasspath fragments in deps, which come from PRODUCT_APEX_SYSTEM_SERVER_JARS and
+// PRODUCT_APEX_STANDALONE_SYSTEM_SERVER_JARS, are declared for this APEX and are packaged in its
+// payload. Otherwise system_server would look for the jars at paths that don't exist on device.
+func (a *apexBundle) checkSystemServerClasspathFragments(ctx android.ModuleContext) {
+	// apex_test adds test jars to the config that are not declared in the product.
+	if a.testApex {
+		return
+	}
+
+	packaged := make(map[string]bool)
+	for _, fi := range a.filesInfo {
+		if fi.class == javaSharedLib {
+			packaged[fi.path()] = true
+		}
+	}
+
+	apexName := a.ApexVariationName()
+	ctx.VisitDirectDepsWithTag(sscpfTag, func(module android.Module) {
+		info := ctx.OtherModuleProvider(module, java.ClasspathFragmentProtoContentInfoProvider).(java.ClasspathFragmentProtoContentInfo)
+		if !info.ClasspathFragmentProtoGenerated {
+			return
+		}
+		jars := info.ClasspathFragmentProtoContents
+		for i := 0; i < jars.Len(); i++ {
+			apex, jar := jars.Apex(i), jars.Jar(i)
+			if apex != apexName {
+				ctx.PropertyErrorf("systemserverclasspath_fragments",
+					"%q contains %q, which is declared as \"%s:%s\" in the product system server jars, but is packaged in %q",
+					ctx.OtherModuleName(module), jar, apex, jar, apexName)
+			} else if !packaged[filepath.Join("javalib", jar+".jar")] {
+				ctx.PropertyErrorf("systemserverclasspath_fragments",
+					"%q contains %q, which is declared as \"%s:%s\" in the product system server jars, but javalib/%s.jar is not in the payload of %q",
+					ctx.OtherModuleName(module), jar, apex, jar, jar, apexName)
+			}
+		}
+	})
+}
+
 // checkJavaStableSdkVersion enforces that all Java deps are using stable SDKs to compile.
 func (a *apexBundle) checkJavaStableSdkVersion(ctx android.ModuleContext) {
 	// Visit direct deps only. As long as we guarantee top-level deps are using stable SDKs,
@@ -2624,19 +3107,26 @@ func (a *apexBundle) checkApexAvailability(ctx android.ModuleContext) {
 		return
 	}
 
-	// Coverage build adds additional dependencies for the coverage-only runtime libraries.
-	// Requiring them and their transitive depencies with apex_available is not right
-	// because they just add noise.
-	if ctx.Config().IsEnvTrue("EMMA_INSTRUMENT") || a.IsNativeCoverageNeeded(ctx) {
-		return
-	}
+	// With APEX_AVAILABLE_SUGGESTIONS set, the missing apex_available entries don't fail the
+	// analysis. The commands that add them are collected instead, so that they don't need to be
+	// added by hand to every module along the dependency path.
+	collectSuggestions := ctx.Config().IsEnvTrue(apexAvailableSuggestionsEnv)
+
+	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool,
+		tag blueprint.DependencyTag, path []android.Module) bool {
 
-	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool) bool {
 		// As soon as the dependency graph crosses the APEX boundary, don't go further.
 		if externalDep {
 			return false
 		}
 
+		// Coverage build adds additional dependencies for the coverage-only runtime libraries.
+		// Requiring them and their transitive depencies with apex_available is not right
+		// because they just add noise.
+		if android.IsCoverageDependency(ctx, from, to, tag) {
+			return false
+		}
+
 		apexName := ctx.ModuleName()
 		fromName := ctx.OtherModuleName(from)
 		toName := ctx.OtherModuleName(to)
@@ -2654,15 +3144,68 @@ func (a *apexBundle) checkApexAvailability(ctx android.ModuleContext) {
 		if to.AvailableFor(apexName) || baselineApexAvailable(apexName, toName) {
 			return true
 		}
-		ctx.ModuleErrorf("%q requires %q that doesn't list the APEX under 'apex_available'."+
-			"\n\nDependency path:%s\n\n"+
-			"Consider adding %q to 'apex_available' property of %q",
-			fromName, toName, ctx.GetPathString(true), apexName, toName)
+		if !collectSuggestions {
+			ctx.ModuleErrorf("%q requires %q that doesn't list the APEX under 'apex_available'."+
+				"\n\nDependency path:%s\n\n"+
+				"Consider adding %q to 'apex_available' property of %q, "+
+				"or run `%s=true m %s` for a script that adds all the missing entries of this APEX",
+				fromName, toName, ctx.GetPathString(true), apexName, toName,
+				apexAvailableSuggestionsEnv, apexAvailableSuggestionsGoal)
+			return true
+		}
+		suggestion := fmt.Sprintf("bpmodify -w -m %s -property apex_available -a %s %s",
+			android.RemoveOptionalPrebuiltPrefix(toName), apexName, filepath.Join(ctx.OtherModuleDir(to), "Android.bp"))
+		if !android.InList(suggestion, a.apexAvailableSuggestions) {
+			a.apexAvailableSuggestions = append(a.apexAvailableSuggestions, suggestion)
+		}
 		// Visit this module's dependencies to check and report any issues with their availability.
 		return true
 	})
 }
 
+// checkPayloadDepBlocklist ensures that none of the modules in payload_dep_blocklist is in the
+// payload of this APEX.
+func (a *apexBundle) checkPayloadDepBlocklist(ctx android.ModuleContext) {
+	blocklist := a.properties.Payload_dep_blocklist
+	if len(blocklist) == 0 {
+		return
+	}
+
+	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool,
+		tag blueprint.DependencyTag, path []android.Module) bool {
+
+		// Dependencies outside of the APEX are not in the payload.
+		if externalDep {
+			return false
+		}
+		// It is ok to call DepIsInSameApex() directly from within WalkPayloadDeps().
+		if am, ok := from.(android.DepIsInSameApex); ok && !am.DepIsInSameApex(ctx, to) {
+			return false
+		}
+
+		toName := android.RemoveOptionalPrebuiltPrefix(ctx.OtherModuleName(to))
+		if entry, blocked := matchPayloadDepBlocklist(blocklist, toName); blocked {
+			ctx.PropertyErrorf("payload_dep_blocklist", "%q matches %q but is in the payload."+
+				"\n\nDependency path:%s", toName, entry, ctx.GetPathString(true))
+		}
+		return true
+	})
+}
+
+// matchPayloadDepBlocklist returns the first entry of the blocklist that matches the module name.
+func matchPayloadDepBlocklist(blocklist []string, name string) (string, bool) {
+	for _, entry := range blocklist {
+		if strings.HasSuffix(entry, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(entry, "*")) {
+				return entry, true
+			}
+		} else if entry == name {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
 // checkStaticExecutable ensures that executables in an APEX are not static.
 func (a *apexBundle) checkStaticExecutables(ctx android.ModuleContext) {
 	// No need to run this for host APEXes
@@ -2700,7 +3243,7 @@ func isStaticExecutableAllowed(apex string, exec string) bool {
 
 // Collect information for opening IDE project files in java/jdeps.go.
 func (a *apexBundle) IDEInfo(dpInfo *android.IdeInfo) {
-	dpInfo.Deps = append(dpInfo.Deps, a.overridableProperties.Java_libs...)
+	dpInfo.Deps = append(dpInfo.Deps, a.javaLibs()...)
 	dpInfo.Deps = append(dpInfo.Deps, a.overridableProperties.Bootclasspath_fragments...)
 	dpInfo.Deps = append(dpInfo.Deps, a.overridableProperties.Systemserverclasspath_fragments...)
 	dpInfo.Paths = append(dpInfo.Paths, a.modulePaths...)
@@ -3167,13 +3710,13 @@ func (a *apexBundle) ConvertWithBp2build(ctx android.TopDownMutatorContext) {
 	}
 
 	var manifestLabelAttribute bazel.LabelAttribute
-	if a.properties.Manifest != nil {
-		manifestLabelAttribute.SetValue(android.BazelLabelForModuleSrcSingle(ctx, *a.properties.Manifest))
+	if a.overridableProperties.Manifest != nil {
+		manifestLabelAttribute.SetValue(android.BazelLabelForModuleSrcSingle(ctx, *a.overridableProperties.Manifest))
 	}
 
 	var androidManifestLabelAttribute bazel.LabelAttribute
-	if a.properties.AndroidManifest != nil {
-		androidManifestLabelAttribute.SetValue(android.BazelLabelForModuleSrcSingle(ctx, *a.properties.AndroidManifest))
+	if a.overridableProperties.AndroidManifest != nil {
+		androidManifestLabelAttribute.SetValue(android.BazelLabelForModuleSrcSingle(ctx, *a.overridableProperties.AndroidManifest))
 	}
 
 	var fileContextsLabelAttribute bazel.LabelAttribute
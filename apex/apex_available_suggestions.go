@@ -0,0 +1,78 @@
+// Copyright (C) 2021 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apex
+
+import (
+	"strings"
+
+	"android/soong/android"
+)
+
+// The apex_available suggestions are scripts with the bpmodify commands that add the
+// apex_available entries missing for an APEX. As the missing entries fail the analysis, they are
+// only collected when APEX_AVAILABLE_SUGGESTIONS=true is set, with which the missing entries are
+// not errors. The scripts of all variants of an APEX are merged, and are built by the
+// apex_available_suggestions goal:
+//
+//	APEX_AVAILABLE_SUGGESTIONS=true m apex_available_suggestions
+//	bash out/soong/apex_available_suggestions/<apex>.sh
+
+const (
+	apexAvailableSuggestionsEnv  = "APEX_AVAILABLE_SUGGESTIONS"
+	apexAvailableSuggestionsGoal = "apex_available_suggestions"
+)
+
+func apexAvailableSuggestionsPath(ctx android.PathContext, apexName string) android.OutputPath {
+	return android.PathForOutput(ctx, "apex_available_suggestions", apexName+".sh")
+}
+
+type apexAvailableSuggestionsSingleton struct{}
+
+func apexAvailableSuggestionsSingletonFactory() android.Singleton {
+	return &apexAvailableSuggestionsSingleton{}
+}
+
+func (s *apexAvailableSuggestionsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue(apexAvailableSuggestionsEnv) {
+		return
+	}
+
+	// The bpmodify commands of each APEX, merged from all of its variants.
+	suggestions := make(map[string][]string)
+	ctx.VisitAllModules(func(module android.Module) {
+		a, ok := module.(*apexBundle)
+		if !ok {
+			return
+		}
+		name := ctx.ModuleName(module)
+		for _, suggestion := range a.apexAvailableSuggestions {
+			if !android.InList(suggestion, suggestions[name]) {
+				suggestions[name] = append(suggestions[name], suggestion)
+			}
+		}
+	})
+
+	var scripts android.Paths
+	for _, apexName := range android.SortedStringKeys(suggestions) {
+		path := apexAvailableSuggestionsPath(ctx, apexName)
+		content := "#!/bin/bash -e\n" +
+			"# Adds the apex_available entries missing for the APEX " + apexName + ".\n" +
+			"# Generated by Soong, review the changes before submitting them.\n" +
+			strings.Join(suggestions[apexName], "\n") + "\n"
+		android.WriteFileRule(ctx, path, content)
+		scripts = append(scripts, path)
+	}
+	ctx.Phony(apexAvailableSuggestionsGoal, scripts...)
+}
@@ -17,7 +17,9 @@
 package apex
 
 import (
-	"github.com/google/blueprint"
+	"fmt"
+	"sort"
+	"strings"
 
 	"android/soong/android"
 )
@@ -28,100 +30,146 @@ func init() {
 
 type apexDepsInfoSingleton struct {
 	allowedApexDepsInfoCheckResult android.OutputPath
+
+	// The JSON dependency info of each module, in the same order as jsonListNames.
+	jsonLists     android.Paths
+	jsonListNames []string
+
+	// The dependency graph of each APEX, in the same order as depsGraphNames.
+	depsGraphs     android.Paths
+	depsGraphNames []string
 }
 
 func apexDepsInfoSingletonFactory() android.Singleton {
 	return &apexDepsInfoSingleton{}
 }
 
-var (
-	// Generate new apex allowed_deps.txt by merging all internal dependencies.
-	generateApexDepsInfoFilesRule = pctx.AndroidStaticRule("generateApexDepsInfoFilesRule", blueprint.RuleParams{
-		Command: "cat $out.rsp | xargs cat" +
-			// Only track non-external dependencies, i.e. those that end up in the binary
-			" | grep -v '(external)'" +
-			// Ignore comments in any of the files
-			" | grep -v '^#'" +
-			" | sort -u -f >$out",
-		Rspfile:        "$out.rsp",
-		RspfileContent: "$in",
-	})
-
-	// Diff two given lists while ignoring comments in the allowed deps file.
-	diffAllowedApexDepsInfoRule = pctx.AndroidStaticRule("diffAllowedApexDepsInfoRule", blueprint.RuleParams{
-		Description: "Diff ${allowed_deps} and ${new_allowed_deps}",
-		Command: `
-			if grep -v '^#' ${allowed_deps} | diff -B - ${new_allowed_deps}; then
-			   touch ${out};
-			else
-				echo -e "\n******************************";
-				echo "ERROR: go/apex-allowed-deps-error";
-				echo "******************************";
-				echo "Detected changes to allowed dependencies in updatable modules.";
-				echo "To fix and update packages/modules/common/build/allowed_deps.txt, please run:";
-				echo -e "$$ (croot && packages/modules/common/build/update-apex-allowed-deps.sh)\n";
-				echo "When submitting the generated CL, you must include the following information";
-				echo "in the commit message if you are adding a new dependency:";
-				echo "Apex-Size-Increase:";
-				echo "Previous-Platform-Support:";
-				echo "Aosp-First:";
-				echo "Test-Info:";
-				echo "You do not need OWNERS approval to submit the change, but mainline-modularization@";
-				echo "will periodically review additions and may require changes.";
-				echo -e "******************************\n";
-				exit 1;
-			fi;
-		`,
-	}, "allowed_deps", "new_allowed_deps")
-)
+const allowedDepsFile = "packages/modules/common/build/allowed_deps.txt"
 
 func (s *apexDepsInfoSingleton) GenerateBuildActions(ctx android.SingletonContext) {
-	updatableFlatLists := android.Paths{}
+	// The modules of updatable APEXes and apps that require each dependency, keyed by the entries
+	// of their flat dependency lists.
+	updatableDeps := make(map[string][]string)
 	ctx.VisitAllModules(func(module android.Module) {
 		if binaryInfo, ok := module.(android.ApexBundleDepsInfoIntf); ok {
 			apexInfo := ctx.ModuleProvider(module, android.ApexInfoProvider).(android.ApexInfo)
-			if path := binaryInfo.FlatListPath(); path != nil {
-				if binaryInfo.Updatable() || apexInfo.Updatable {
-					updatableFlatLists = append(updatableFlatLists, path)
+			if binaryInfo.Updatable() || apexInfo.Updatable {
+				for _, dep := range binaryInfo.FlatList() {
+					// Only track non-external dependencies, i.e. those that end up in the binary
+					if strings.HasSuffix(dep, "(external)") {
+						continue
+					}
+					if !android.InList(ctx.ModuleName(module), updatableDeps[dep]) {
+						updatableDeps[dep] = append(updatableDeps[dep], ctx.ModuleName(module))
+					}
 				}
 			}
+			// Every variant of an app, and every variant of an APEX, writes the same dependency
+			// info, so only the first one visited is dist'ed to avoid duplicate destinations.
+			if path := binaryInfo.JsonListPath(); path != nil && path.String() != "" &&
+				!android.InList(ctx.ModuleName(module), s.jsonListNames) {
+				s.jsonLists = append(s.jsonLists, path)
+				s.jsonListNames = append(s.jsonListNames, ctx.ModuleName(module))
+			}
+		}
+		if a, ok := module.(*apexBundle); ok && a.depsGraphFile != nil && !android.InList(a.Name(), s.depsGraphNames) {
+			s.depsGraphs = append(s.depsGraphs, a.depsGraphFile)
+			s.depsGraphNames = append(s.depsGraphNames, a.Name())
 		}
 	})
 
-	allowedDepsSource := android.ExistentPathForSource(ctx, "packages/modules/common/build/allowed_deps.txt")
 	newAllowedDeps := android.PathForOutput(ctx, "apex", "depsinfo", "new-allowed-deps.txt")
 	s.allowedApexDepsInfoCheckResult = android.PathForOutput(ctx, newAllowedDeps.Rel()+".check")
 
-	if !allowedDepsSource.Valid() {
-		// Unbundled projects may not have packages/modules/common/ checked out; ignore those.
-		ctx.Build(pctx, android.BuildParams{
-			Rule:   android.Touch,
-			Output: s.allowedApexDepsInfoCheckResult,
-		})
-	} else {
-		allowedDeps := allowedDepsSource.Path()
-
-		ctx.Build(pctx, android.BuildParams{
-			Rule:   generateApexDepsInfoFilesRule,
-			Inputs: append(updatableFlatLists, allowedDeps),
-			Output: newAllowedDeps,
-		})
-
-		ctx.Build(pctx, android.BuildParams{
-			Rule:   diffAllowedApexDepsInfoRule,
-			Input:  newAllowedDeps,
-			Output: s.allowedApexDepsInfoCheckResult,
-			Args: map[string]string{
-				"allowed_deps":     allowedDeps.String(),
-				"new_allowed_deps": newAllowedDeps.String(),
-			},
-		})
+	// The check is done during analysis, so the check result is only kept for the Make rules that
+	// depend on it.
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   android.Touch,
+		Output: s.allowedApexDepsInfoCheckResult,
+	})
+	ctx.Phony("apex-allowed-deps-check", s.allowedApexDepsInfoCheckResult)
+	ctx.Phony("apex-depsinfo-json", s.jsonLists...)
+	ctx.Phony("apex-deps-graph", s.depsGraphs...)
+
+	// Unbundled projects may not have packages/modules/common/ checked out; ignore those.
+	if allowedDepsSource := android.ExistentPathForSource(ctx, allowedDepsFile); allowedDepsSource.Valid() {
+		s.checkAllowedDeps(ctx, allowedDepsSource.Path(), newAllowedDeps, updatableDeps)
 	}
+}
 
-	ctx.Phony("apex-allowed-deps-check", s.allowedApexDepsInfoCheckResult)
+// checkAllowedDeps reports an error for each dependency of updatable modules that is not listed in
+// allowedDeps, and writes the allowed_deps.txt that would list them to newAllowedDeps.
+func (s *apexDepsInfoSingleton) checkAllowedDeps(ctx android.SingletonContext, allowedDeps android.Path,
+	newAllowedDeps android.WritablePath, updatableDeps map[string][]string) {
+
+	content, err := android.ReadFileFromSourceTree(ctx, allowedDeps)
+	if err != nil {
+		ctx.Errorf("failed to read %s: %s", allowedDeps, err)
+		return
+	}
+	allowed := make(map[string]bool)
+	var newAllowedDepsList []string
+	for _, line := range strings.Split(string(content), "\n") {
+		// Ignore comments and empty lines in the allowed deps file.
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		allowed[strings.ToLower(line)] = true
+		newAllowedDepsList = append(newAllowedDepsList, line)
+	}
+
+	var missing []string
+	for _, dep := range android.SortedStringKeys(updatableDeps) {
+		if !allowed[strings.ToLower(dep)] {
+			missing = append(missing, dep)
+			newAllowedDepsList = append(newAllowedDepsList, dep)
+		}
+	}
+
+	// Sorted case insensitively, as the allowed deps file has always been sorted with sort -f.
+	sort.SliceStable(newAllowedDepsList, func(i, j int) bool {
+		return strings.ToLower(newAllowedDepsList[i]) < strings.ToLower(newAllowedDepsList[j])
+	})
+	newAllowedDepsList = android.FirstUniqueStrings(newAllowedDepsList)
+	if err := android.WriteFileToOutputDir(newAllowedDeps, []byte(strings.Join(newAllowedDepsList, "\n")+"\n"), 0666); err != nil {
+		ctx.Errorf("failed to write %s: %s", newAllowedDeps, err)
+		return
+	}
+
+	if len(missing) == 0 {
+		return
+	}
+
+	var diff strings.Builder
+	for _, dep := range missing {
+		fmt.Fprintf(&diff, "  +%s (required by %s)\n", dep, strings.Join(android.SortedUniqueStrings(updatableDeps[dep]), ", "))
+	}
+	ctx.Errorf("Detected changes to allowed dependencies in updatable modules (go/apex-allowed-deps-error).\n"+
+		"The following dependencies are not listed in %s:\n%s"+
+		"To fix this, run:\n"+
+		"  (croot && packages/modules/common/build/update-apex-allowed-deps.sh)\n"+
+		"or copy %s to %s.\n"+
+		"When submitting the generated CL, you must include the following information\n"+
+		"in the commit message if you are adding a new dependency:\n"+
+		"Apex-Size-Increase:\n"+
+		"Previous-Platform-Support:\n"+
+		"Aosp-First:\n"+
+		"Test-Info:\n"+
+		"You do not need OWNERS approval to submit the change, but mainline-modularization@\n"+
+		"will periodically review additions and may require changes.",
+		allowedDeps, diff.String(), newAllowedDeps, allowedDeps)
 }
 
 func (s *apexDepsInfoSingleton) MakeVars(ctx android.MakeVarsContext) {
 	// Export check result to Make. The path is added to droidcore.
 	ctx.Strict("APEX_ALLOWED_DEPS_CHECK", s.allowedApexDepsInfoCheckResult.String())
+
+	for i, path := range s.jsonLists {
+		ctx.DistForGoalWithFilename("apex-depsinfo-json", path, "apex_depsinfo/"+s.jsonListNames[i]+".json")
+	}
+
+	for i, path := range s.depsGraphs {
+		ctx.DistForGoalWithFilename("apex-deps-graph", path, "apex_deps_graph/"+s.depsGraphNames[i]+".dot")
+	}
 }
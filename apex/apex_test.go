@@ -16,6 +16,7 @@ package apex
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -607,6 +608,135 @@ func TestBasicApex(t *testing.T) {
 	ensureListContains(t, flatDepsInfo, "mylib2(minSdkVersion:(no version))")
 	ensureListContains(t, flatDepsInfo, "myotherjar(minSdkVersion:(no version))")
 	ensureListContains(t, flatDepsInfo, "mysharedjar(minSdkVersion:(no version)) (external)")
+
+	jsonDepsInfo := android.ContentFromFileRuleForTests(t, ctx.ModuleForTests("myapex", "android_common_myapex_image").Output("depsinfo/depsinfo.json"))
+	ensureContains(t, jsonDepsInfo, `"name": "myapex"`)
+	ensureContains(t, jsonDepsInfo, `"name": "myotherjar",
+      "min_sdk_version": "(no version)",
+      "external": false,
+      "parents": [
+        "myjar"
+      ]`)
+	ensureContains(t, jsonDepsInfo, `"name": "mysharedjar",
+      "min_sdk_version": "(no version)",
+      "external": true`)
+
+	depsGraph := android.ContentFromFileRuleForTests(t, ctx.ModuleForTests("myapex", "android_common_myapex_image").Output("depsinfo/deps.dot"))
+	ensureContains(t, depsGraph, `digraph "myapex" {`)
+	ensureContains(t, depsGraph, `"myotherjar" [label="myotherjar\nmin_sdk_version: (no version)", style=solid];`)
+	ensureContains(t, depsGraph, `"mysharedjar" [label="mysharedjar\nmin_sdk_version: (no version)", style=dashed];`)
+	ensureContains(t, depsGraph, `"myapex" -> "myjar";`)
+	ensureContains(t, depsGraph, `"myjar" -> "myotherjar";`)
+	ensureContains(t, depsGraph, `"myjar" -> "mysharedjar";`)
+}
+
+func TestApexAllowedDeps(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			min_sdk_version: "29",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["//apex_available:platform", "myapex"],
+			min_sdk_version: "29",
+			// Keep the implicit dependencies out of the dependency list.
+			nocrt: true,
+			no_libcrt: true,
+		}
+	`
+
+	prepareForAllowedDepsTest := android.GroupFixturePreparers(
+		prepareForApexTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("apex_depsinfo_singleton", apexDepsInfoSingletonFactory)
+		}),
+	)
+
+	t.Run("allowed", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForAllowedDepsTest,
+			android.FixtureAddTextFile(allowedDepsFile, "# comment\nMyLib(minSdkVersion:29)\nlibz(minSdkVersion:apex_inherit)\n"),
+		).RunTestWithBp(t, bp)
+
+		content, err := ioutil.ReadFile(filepath.Join(result.Config.SoongOutDir(), "apex", "depsinfo", "new-allowed-deps.txt"))
+		if err != nil {
+			t.Fatalf("failed to read the new allowed deps: %s", err)
+		}
+		android.AssertStringEquals(t, "new allowed deps",
+			"libz(minSdkVersion:apex_inherit)\nMyLib(minSdkVersion:29)\n", string(content))
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForAllowedDepsTest,
+			android.FixtureAddTextFile(allowedDepsFile, "libz(minSdkVersion:apex_inherit)\n"),
+		).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`(?s)The following dependencies are not listed in packages/modules/common/build/allowed_deps.txt:\n`+
+				`  \+mylib\(minSdkVersion:29\) \(required by myapex\)\n`,
+		)).RunTestWithBp(t, bp)
+
+		content, err := ioutil.ReadFile(filepath.Join(result.Config.SoongOutDir(), "apex", "depsinfo", "new-allowed-deps.txt"))
+		if err != nil {
+			t.Fatalf("failed to read the new allowed deps: %s", err)
+		}
+		android.AssertStringEquals(t, "new allowed deps",
+			"libz(minSdkVersion:apex_inherit)\nmylib(minSdkVersion:29)\n", string(content))
+	})
+
+	t.Run("no allowed deps file", func(t *testing.T) {
+		prepareForAllowedDepsTest.RunTestWithBp(t, bp)
+	})
+}
+
+func TestApexDepsInfoDistDestinations(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForApexTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("apex_depsinfo_singleton", apexDepsInfoSingletonFactory)
+		}),
+	).RunTestWithBp(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			apps: ["AppFoo"],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		android_app {
+			name: "AppFoo",
+			srcs: ["foo/bar/MyClass.java"],
+			sdk_version: "current",
+			system_modules: "none",
+			apex_available: ["//apex_available:platform", "myapex"],
+		}
+	`)
+
+	// Both the platform and the APEX variant of AppFoo write the dependency info, but it is dist'ed
+	// only once.
+	singleton := result.SingletonForTests("apex_depsinfo_singleton").Singleton().(*apexDepsInfoSingleton)
+	android.AssertDeepEquals(t, "depsinfo json names", []string{"AppFoo", "myapex"},
+		android.SortedUniqueStrings(singleton.jsonListNames))
+	android.AssertIntEquals(t, "depsinfo json count", 2, len(singleton.jsonLists))
+	android.AssertIntEquals(t, "deps graph count", len(singleton.depsGraphNames), len(android.FirstUniqueStrings(singleton.depsGraphNames)))
 }
 
 func TestDefaults(t *testing.T) {
@@ -1144,6 +1274,59 @@ func TestApexWithStubsWithMinSdkVersion(t *testing.T) {
 	})
 }
 
+func TestApexVariationConstituents(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			min_sdk_version: "29",
+			updatable: false,
+		}
+
+		apex {
+			name: "otherapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			min_sdk_version: "29",
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex", "otherapex"],
+			min_sdk_version: "29",
+		}
+	`,
+		android.PrepareForTestWithApexVariations,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_DUMP_APEX_VARIATIONS": "true",
+		}))
+
+	apexVariant := ctx.ModuleForTests("mylib", "android_arm64_armv8-a_shared_apex29").Module().(android.ApexModule)
+	android.AssertDeepEquals(t, "constituents of the merged variation",
+		[]string{"myapex", "otherapex"}, apexVariant.ApexVariationConstituents())
+
+	platformVariant := ctx.ModuleForTests("mylib", "android_arm64_armv8-a_shared").Module().(android.ApexModule)
+	android.AssertDeepEquals(t, "constituents of the platform variation",
+		[]string(nil), platformVariant.ApexVariationConstituents())
+
+	content, err := ioutil.ReadFile(filepath.Join(ctx.Config().SoongOutDir(), "apex_variations.txt"))
+	if err != nil {
+		t.Fatalf("failed to read the APEX variations: %s", err)
+	}
+	ensureContains(t, string(content), "mylib android_arm64_armv8-a_shared_apex29: myapex otherapex\n")
+}
+
 func TestApex_PlatformUsesLatestStubFromApex(t *testing.T) {
 	t.Parallel()
 	//   myapex (Z)
@@ -1304,6 +1487,149 @@ func TestApexWithExplicitStubsDependency(t *testing.T) {
 	ensureListContains(t, flatDepsInfo, "libfoo(minSdkVersion:(no version)) (external)")
 }
 
+func TestApexDepsInfoLicenses(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		license {
+			name: "mylicense",
+			license_kinds: ["SPDX-license-identifier-MIT"],
+		}
+
+		license_kind {
+			name: "SPDX-license-identifier-MIT",
+			conditions: ["notice"],
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			shared_libs: ["libfoo"],
+			static_libs: ["libbaz"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [ "//apex_available:platform", "myapex" ],
+		}
+
+		cc_library {
+			name: "libfoo",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			stubs: {
+				versions: ["10"],
+			},
+		}
+
+		cc_library_static {
+			name: "libbaz",
+			licenses: ["mylicense"],
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [ "//apex_available:platform", "myapex" ],
+		}
+	`, android.PrepareForTestWithLicenses)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+	licenses := strings.Split(module.Output("depsinfo/licenses.txt").Args["content"], "\\n")
+	ensureListContains(t, licenses, "libbaz: SPDX-license-identifier-MIT")
+	ensureListContains(t, licenses, "libfoo (stubs): (no license kinds)")
+	ensureListContains(t, licenses, "mylib: (no license kinds)")
+
+	jsonDepsInfo := android.ContentFromFileRuleForTests(t, module.Output("depsinfo/depsinfo.json"))
+	ensureContains(t, jsonDepsInfo, `"name": "libbaz",
+      "min_sdk_version": "(no version)",
+      "external": false,
+      "parents": [
+        "mylib"
+      ],
+      "license_kinds": [
+        "SPDX-license-identifier-MIT"
+      ],
+      "used_through_stubs": false`)
+	ensureContains(t, jsonDepsInfo, `"name": "libfoo",
+      "min_sdk_version": "(no version)",
+      "external": true,
+      "parents": [
+        "mylib"
+      ],
+      "license_kinds": [],
+      "used_through_stubs": true`)
+}
+
+func TestApexMinimalAnalysis(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			updatable: false,
+		}
+
+		apex {
+			name: "otherapex",
+			key: "myapex.key",
+			native_shared_libs: ["otherlib"],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [ "myapex" ],
+		}
+
+		cc_library {
+			name: "otherlib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [ "otherapex" ],
+		}
+	`,
+		android.PrepareForTestWithAllowMissingDependencies,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.Unbundled_build_apps = []string{"myapex"}
+		}),
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_MINIMAL_APEX_ANALYSIS": "true",
+		}),
+	)
+
+	// The requested APEX and its contents are analyzed as usual.
+	ensureListContains(t, ctx.ModuleVariantsForTests("mylib"), "android_arm64_armv8-a_shared_apex10000")
+	ctx.ModuleForTests("myapex", "android_common_myapex_image").Rule("apexRule")
+
+	// Other APEXes are disabled, so no APEX variants are created for their contents.
+	ensureListNotContains(t, ctx.ModuleVariantsForTests("otherlib"), "android_arm64_armv8-a_shared_apex10000")
+	if ctx.ModuleForTests("otherapex", "android_common_otherapex_image").Module().Enabled() {
+		t.Errorf("expected otherapex to be disabled")
+	}
+	if ctx.ModuleForTests("otherlib", "android_arm64_armv8-a_shared").Module().Enabled() {
+		t.Errorf("expected otherlib to be disabled")
+	}
+}
+
 func TestApexWithRuntimeLibsDependency(t *testing.T) {
 	/*
 		myapex
@@ -2053,6 +2379,50 @@ func TestQTargetApexUsesStaticUnwinder(t *testing.T) {
 	ensureListNotContains(t, cm.Properties.AndroidMkStaticLibs, "libunwind")
 }
 
+func TestApexMinSdkVersion_AllowlistFiles(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			min_sdk_version: "29",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [
+				"myapex",
+			],
+			min_sdk_version: "30",
+		}
+	`
+	withAllowlistFiles := func(files ...string) android.FixturePreparer {
+		return android.GroupFixturePreparers(
+			android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.MinSdkVersionAllowlistFiles = files
+			}),
+			android.FixtureMergeMockFs(android.MockFS{
+				"vendor/allowlist.txt":     []byte("mylib 29\n"),
+				"vendor/bad_allowlist.txt": []byte("mylib\n"),
+			}),
+		)
+	}
+
+	testApex(t, bp, withAllowlistFiles("vendor/allowlist.txt"))
+
+	testApexError(t, `vendor/bad_allowlist.txt:1: expected "<module name> <api level>", got "mylib"`, bp,
+		withAllowlistFiles("vendor/bad_allowlist.txt"))
+}
+
 func TestApexMinSdkVersion_ErrorIfIncompatibleVersion(t *testing.T) {
 	testApexError(t, `module "mylib".*: should support min_sdk_version\(29\)`, `
 		apex {
@@ -2346,12 +2716,14 @@ func TestJavaStableSdkVersion(t *testing.T) {
 	}
 }
 
-func TestApexMinSdkVersion_ErrorIfDepIsNewer(t *testing.T) {
-	testApexError(t, `module "mylib2".*: should support min_sdk_version\(29\) for "myapex"`, `
+func TestUpdatableApexStubsAtBoundary(t *testing.T) {
+	bp := `
 		apex {
 			name: "myapex",
 			key: "myapex.key",
-			native_shared_libs: ["mylib"],
+			native_shared_libs: ["libfoo"],
+			java_libs: ["myjar"],
+			updatable: true,
 			min_sdk_version: "29",
 		}
 
@@ -2362,27 +2734,97 @@ func TestApexMinSdkVersion_ErrorIfDepIsNewer(t *testing.T) {
 		}
 
 		cc_library {
-			name: "mylib",
+			name: "libfoo",
 			srcs: ["mylib.cpp"],
-			shared_libs: ["mylib2"],
+			shared_libs: ["libbar"],
 			system_shared_libs: [],
 			stl: "none",
-			apex_available: [
-				"myapex",
-			],
+			apex_available: ["myapex"],
 			min_sdk_version: "29",
 		}
 
-		// indirect part of the apex
 		cc_library {
-			name: "mylib2",
+			name: "libbar",
 			srcs: ["mylib.cpp"],
 			system_shared_libs: [],
 			stl: "none",
-			apex_available: [
-				"myapex",
-			],
-			min_sdk_version: "30",
+			stubs: {
+				versions: ["29"],
+			},
+		}
+
+		java_library {
+			name: "myjar",
+			srcs: ["foo/bar/MyClass.java"],
+			sdk_version: "current",
+			libs: %s,
+			apex_available: ["myapex"],
+			min_sdk_version: "29",
+		}
+
+		java_library {
+			name: "otherjar",
+			srcs: ["foo/bar/MyClass.java"],
+			sdk_version: "current",
+		}
+	`
+
+	enforceStubs := android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.EnforceUpdatableApexStubs = proptools.BoolPtr(true)
+	})
+
+	t.Run("stubs", func(t *testing.T) {
+		testApex(t, fmt.Sprintf(bp, "[]"), enforceStubs)
+	})
+
+	t.Run("java implementation", func(t *testing.T) {
+		testApexError(t, `"myjar" links against the implementation of "otherjar" across the boundary of the `+
+			`updatable APEX, but "otherjar" is not a java_sdk_library`,
+			fmt.Sprintf(bp, `["otherjar"]`), enforceStubs)
+	})
+
+	t.Run("not enforced", func(t *testing.T) {
+		testApex(t, fmt.Sprintf(bp, `["otherjar"]`))
+	})
+}
+
+func TestApexMinSdkVersion_ErrorIfDepIsNewer(t *testing.T) {
+	testApexError(t, `module "mylib2".*: should support min_sdk_version\(29\) for "myapex"`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			min_sdk_version: "29",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			shared_libs: ["mylib2"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [
+				"myapex",
+			],
+			min_sdk_version: "29",
+		}
+
+		// indirect part of the apex
+		cc_library {
+			name: "mylib2",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [
+				"myapex",
+			],
+			min_sdk_version: "30",
 		}
 	`)
 }
@@ -2607,6 +3049,101 @@ func TestFilesInSubDir(t *testing.T) {
 	ensureContains(t, cmd, "/bin/foo/bar ")
 }
 
+func TestCannedFsConfigEntries(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			binaries: ["mybin", "myotherbin"],
+			prebuilts: ["myetc"],
+			canned_fs_config_entries: [
+				{
+					path: "bin/myotherbin",
+					gid: 1000,
+					capabilities: "0x1000",
+				},
+				{
+					path: "/etc/myetc",
+					mode: "0640",
+				},
+			],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		prebuilt_etc {
+			name: "myetc",
+			src: "myprebuilt",
+		}
+
+		cc_binary {
+			name: "mybin",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [ "myapex" ],
+			apex_fs_config: {
+				uid: 1000,
+				mode: "0750",
+			},
+		}
+
+		cc_binary {
+			name: "myotherbin",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [ "myapex" ],
+		}
+	`)
+
+	cmd := ctx.ModuleForTests("myapex", "android_common_myapex_image").Rule("generateFsConfig").RuleParams.Command
+	ensureContains(t, cmd, "echo '/bin/mybin 1000 2000 0750';")
+	ensureContains(t, cmd, "echo '/bin/myotherbin 0 1000 0755 capabilities=0x1000';")
+	ensureContains(t, cmd, "echo '/etc/myetc 1000 1000 0640';")
+}
+
+func TestCannedFsConfigEntriesErrors(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			binaries: ["mybin"],
+			canned_fs_config_entries: [%s],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_binary {
+			name: "mybin",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [ "myapex" ],
+			apex_fs_config: {%s},
+		}
+	`
+
+	testApexError(t, `conflicting entries for "bin/mybin" from module "mybin" and canned_fs_config_entries`,
+		fmt.Sprintf(bp, `{path: "bin/mybin", capabilities: "0x2000"}`, `capabilities: "0x1000"`))
+	testApexError(t, `"bin/nosuchbin" is not a file in the APEX`,
+		fmt.Sprintf(bp, `{path: "bin/nosuchbin", mode: "0750"}`, ``))
+	testApexError(t, `Mode of "bin/mybin" must be octal permission bits, got "0999"`,
+		fmt.Sprintf(bp, ``, `mode: "0999"`))
+	testApexError(t, `Capabilities of "bin/mybin" must be a hexadecimal number starting with 0x, got "1000"`,
+		fmt.Sprintf(bp, `{path: "bin/mybin", capabilities: "1000"}`, ``))
+}
+
 func TestFilesInSubDirWhenNativeBridgeEnabled(t *testing.T) {
 	ctx := testApex(t, `
 		apex {
@@ -3231,6 +3768,32 @@ func TestCertificate(t *testing.T) {
 			t.Errorf("certificates should be %q, not %q", expected, actual)
 		}
 	})
+	t.Run("lineage and rotation_min_sdk_version", func(t *testing.T) {
+		ctx := testApex(t, `
+			apex {
+				name: "myapex",
+				key: "myapex.key",
+				lineage: "lineage.bin",
+				rotation_min_sdk_version: "32",
+				updatable: false,
+			}
+			apex_key {
+				name: "myapex.key",
+				public_key: "testkey.avbpubkey",
+				private_key: "testkey.pem",
+			}`,
+			withFiles(map[string][]byte{
+				"lineage.bin": nil,
+			}))
+		rule := ctx.ModuleForTests("myapex", "android_common_myapex_image").Rule("signapk")
+		ensureContains(t, rule.Args["flags"], "--lineage lineage.bin")
+		ensureContains(t, rule.Args["flags"], "--rotation-min-sdk-version 32")
+		android.AssertPathsRelativeToTopEquals(t, "implicits", []string{
+			"vendor/foo/devkeys/test.x509.pem",
+			"vendor/foo/devkeys/test.pk8",
+			"lineage.bin",
+		}, rule.Implicits)
+	})
 }
 
 func TestMacro(t *testing.T) {
@@ -3767,6 +4330,93 @@ func TestVndkApexNameRule(t *testing.T) {
 	assertApexName("com.android.vndk.v28", "com.android.vndk.v28")
 }
 
+func TestVndkApexMultipleVersions(t *testing.T) {
+	ctx := testApex(t, `
+		apex_vndk {
+			name: "com.android.vndk.current",
+			key: "myapex.key",
+			updatable: false,
+		}
+
+		apex_vndk {
+			name: "myvndk27",
+			key: "myapex.key",
+			vndk_version: "27",
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "libvndk",
+			srcs: ["mylib.cpp"],
+			vendor_available: true,
+			product_available: true,
+			vndk: {
+				enabled: true,
+			},
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [ "com.android.vndk.current" ],
+		}
+
+		vndk_prebuilt_shared {
+			name: "libvndk27",
+			version: "27",
+			vendor_available: true,
+			product_available: true,
+			vndk: {
+				enabled: true,
+			},
+			target_arch: "arm64",
+			arch: {
+				arm: {
+					srcs: ["libvndk27_arm.so"],
+				},
+				arm64: {
+					srcs: ["libvndk27_arm64.so"],
+				},
+			},
+			apex_available: [ "myvndk27" ],
+		}
+		`+vndkLibrariesTxtFiles("27", "current"),
+		withFiles(map[string][]byte{
+			"libvndk27_arm.so":   nil,
+			"libvndk27_arm64.so": nil,
+		}))
+
+	// Each VNDK version has its own APEX with its own libraries, regardless of the module name.
+	ensureExactContents(t, ctx, "com.android.vndk.current", "android_common_image", []string{
+		"lib/libvndk.so",
+		"lib64/libvndk.so",
+		"lib/libc++.so",
+		"lib64/libc++.so",
+		"etc/*",
+	})
+	ensureExactContents(t, ctx, "myvndk27", "android_common_image", []string{
+		"lib/libvndk27_arm.so",
+		"lib64/libvndk27_arm64.so",
+		"etc/*",
+	})
+
+	// The APEXes are activated at distinct mount points, which are also their apex variations.
+	bundle := ctx.ModuleForTests("myvndk27", "android_common_image").Module().(*apexBundle)
+	ensureEquals(t, proptools.String(bundle.properties.Apex_name), "com.android.vndk.v27")
+	lib := ctx.ModuleForTests("libvndk27", "android_vendor.27_arm64_armv8-a_shared_apex10000").Module()
+	apexInfo := ctx.ModuleProvider(lib, android.ApexInfoProvider).(android.ApexInfo)
+	android.AssertDeepEquals(t, "libvndk27 apex variants", []string{"com.android.vndk.v27"}, apexInfo.InApexVariants)
+
+	// VNDK APEXes without file_contexts of their own use the one for all VNDK versions.
+	rule := ctx.ModuleForTests("myvndk27", "android_common_image").Output("file_contexts")
+	ensureContains(t, rule.RuleParams.Command, "cat system/sepolicy/apex/com.android.vndk-file_contexts")
+	rule = ctx.ModuleForTests("com.android.vndk.current", "android_common_image").Output("file_contexts")
+	ensureContains(t, rule.RuleParams.Command, "cat system/sepolicy/apex/com.android.vndk.current-file_contexts")
+}
+
 func TestVndkApexSkipsNativeBridgeSupportedModules(t *testing.T) {
 	ctx := testApex(t, `
 		apex_vndk {
@@ -4097,15 +4747,30 @@ func TestApexName(t *testing.T) {
 	ensureNotContains(t, androidMk, "LOCAL_MODULE := mylib.com.android.myapex\n")
 }
 
-func TestNonTestApex(t *testing.T) {
-	ctx := testApex(t, `
+func TestMultiInstalledApex(t *testing.T) {
+	bp := `
 		apex {
 			name: "myapex",
 			key: "myapex.key",
-			native_shared_libs: ["mylib_common"],
+			apex_name: "com.android.myapex",
+			native_shared_libs: ["mylib"],
+			updatable: false,
+		}
+
+		apex {
+			name: "myapex.v2",
+			key: "myapex.key",
+			apex_name: "com.android.myapex",
+			native_shared_libs: ["mylib"],
 			updatable: false,
 		}
 
+		prebuilt_apex {
+			name: "com.company.android.myapex",
+			apex_name: "com.android.myapex",
+			src: "company-myapex-arm.apex",
+		}
+
 		apex_key {
 			name: "myapex.key",
 			public_key: "testkey.avbpubkey",
@@ -4113,36 +4778,133 @@ func TestNonTestApex(t *testing.T) {
 		}
 
 		cc_library {
-			name: "mylib_common",
+			name: "mylib",
 			srcs: ["mylib.cpp"],
 			system_shared_libs: [],
 			stl: "none",
 			apex_available: [
-					"//apex_available:platform",
-				  "myapex",
-		  ],
+				"//apex_available:platform",
+				"myapex",
+				"myapex.v2",
+			],
 		}
-	`)
+	`
+	files := withFiles(android.MockFS{
+		"system/sepolicy/apex/myapex.v2-file_contexts": nil,
+	})
 
-	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
-	apexRule := module.Rule("apexRule")
-	copyCmds := apexRule.Args["copy_commands"]
+	installable := func(ctx *android.TestContext) map[string]bool {
+		return map[string]bool{
+			"myapex":                     ctx.ModuleForTests("myapex", "android_common_com.android.myapex_image").Module().(*apexBundle).installable(),
+			"myapex.v2":                  ctx.ModuleForTests("myapex.v2", "android_common_com.android.myapex_image").Module().(*apexBundle).installable(),
+			"com.company.android.myapex": ctx.ModuleForTests("com.company.android.myapex", "android_common_com.android.myapex").Module().(*Prebuilt).installable(),
+		}
+	}
 
-	if apex, ok := module.Module().(*apexBundle); !ok || apex.testApex {
-		t.Log("Apex was a test apex!")
-		t.Fail()
+	flattenedInstallable := func(ctx *android.TestContext, name string) bool {
+		return ctx.ModuleForTests(name, "android_common_com.android.myapex_flattened").Module().(*apexBundle).installable()
 	}
-	// Ensure that main rule creates an output
-	ensureContains(t, apexRule.Output.String(), "myapex.apex.unsigned")
 
-	// Ensure that apex variant is created for the direct dep
-	ensureListContains(t, ctx.ModuleVariantsForTests("mylib_common"), "android_arm64_armv8-a_shared_apex10000")
+	androidMk := func(ctx *android.TestContext, name string) string {
+		apexBundle := ctx.ModuleForTests(name, "android_common_com.android.myapex_image").Module().(*apexBundle)
+		data := android.AndroidMkDataForTest(t, ctx, apexBundle)
+		var builder strings.Builder
+		data.Custom(&builder, name, "TARGET_", "", data)
+		return builder.String()
+	}
 
-	// Ensure that both direct and indirect deps are copied into apex
-	ensureContains(t, copyCmds, "image.apex/lib64/mylib_common.so")
+	t.Run("not selected", func(t *testing.T) {
+		ctx := testApex(t, bp, files)
 
-	// Ensure that the platform variant ends with _shared
-	ensureListContains(t, ctx.ModuleVariantsForTests("mylib_common"), "android_arm64_armv8-a_shared")
+		// Both APEXes are installed to their own paths.
+		for name, ok := range installable(ctx) {
+			android.AssertBoolEquals(t, name+" installable", true, ok)
+		}
+		android.AssertStringEquals(t, "installed file", "out/soong/target/product/test_device/system/apex/myapex.v2.apex",
+			ctx.ModuleForTests("myapex.v2", "android_common_com.android.myapex_image").Module().(*apexBundle).installedFile.RelativeToTop().String())
+
+		// The APEX variants of their dependencies are shared.
+		variants := ctx.ModuleVariantsForTests("mylib")
+		ensureListContains(t, variants, "android_arm64_armv8-a_shared_apex10000")
+		ensureListNotContains(t, variants, "android_arm64_armv8-a_shared_com.android.myapex")
+		for _, apex := range []string{"myapex", "myapex.v2"} {
+			copyCmds := ctx.ModuleForTests(apex, "android_common_com.android.myapex_image").Rule("apexRule").Args["copy_commands"]
+			ensureContains(t, copyCmds, "image.apex/lib64/mylib.so")
+		}
+
+		// The first APEX by name is the default version, the only one that installs the symbol
+		// files and the flattened APEX.
+		ensureContains(t, androidMk(ctx, "myapex"), "LOCAL_MODULE := mylib.myapex\n")
+		ensureNotContains(t, androidMk(ctx, "myapex.v2"), "LOCAL_MODULE := mylib.myapex.v2\n")
+		android.AssertBoolEquals(t, "myapex flattened installable", true, flattenedInstallable(ctx, "myapex"))
+		android.AssertBoolEquals(t, "myapex.v2 flattened installable", false, flattenedInstallable(ctx, "myapex.v2"))
+	})
+
+	t.Run("selected", func(t *testing.T) {
+		ctx := testApex(t, bp, files, android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SelectedApexes = map[string]string{"com.android.myapex": "myapex.v2"}
+		}))
+
+		android.AssertDeepEquals(t, "installable", map[string]bool{
+			"myapex":                     false,
+			"myapex.v2":                  true,
+			"com.company.android.myapex": false,
+		}, installable(ctx))
+
+		// The selected APEX is the default version.
+		ensureNotContains(t, androidMk(ctx, "myapex"), "LOCAL_MODULE := mylib.myapex\n")
+		ensureContains(t, androidMk(ctx, "myapex.v2"), "LOCAL_MODULE := mylib.myapex.v2\n")
+		android.AssertBoolEquals(t, "myapex flattened installable", false, flattenedInstallable(ctx, "myapex"))
+		android.AssertBoolEquals(t, "myapex.v2 flattened installable", true, flattenedInstallable(ctx, "myapex.v2"))
+	})
+}
+
+func TestNonTestApex(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib_common"],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib_common",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: [
+					"//apex_available:platform",
+				  "myapex",
+		  ],
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+	apexRule := module.Rule("apexRule")
+	copyCmds := apexRule.Args["copy_commands"]
+
+	if apex, ok := module.Module().(*apexBundle); !ok || apex.testApex {
+		t.Log("Apex was a test apex!")
+		t.Fail()
+	}
+	// Ensure that main rule creates an output
+	ensureContains(t, apexRule.Output.String(), "myapex.apex.unsigned")
+
+	// Ensure that apex variant is created for the direct dep
+	ensureListContains(t, ctx.ModuleVariantsForTests("mylib_common"), "android_arm64_armv8-a_shared_apex10000")
+
+	// Ensure that both direct and indirect deps are copied into apex
+	ensureContains(t, copyCmds, "image.apex/lib64/mylib_common.so")
+
+	// Ensure that the platform variant ends with _shared
+	ensureListContains(t, ctx.ModuleVariantsForTests("mylib_common"), "android_arm64_armv8-a_shared")
 
 	if !ctx.ModuleForTests("mylib_common", "android_arm64_armv8-a_shared_apex10000").Module().(*cc.Module).InAnyApex() {
 		t.Log("Found mylib_common not in any apex!")
@@ -4423,6 +5185,53 @@ func TestApexInVariousPartition(t *testing.T) {
 	}
 }
 
+func TestApexNativeLibSymbols(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+	`)
+
+	// The unstripped library is installed to the symbols directory with its path in the APEX.
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+	install := module.Output("out/soong/target/product/test_device/symbols/apex/myapex/lib64/mylib.so")
+	unstripped := ctx.ModuleForTests("mylib", "android_arm64_armv8-a_shared_apex10000").Module().(*cc.Module).UnstrippedOutputFile()
+	android.AssertStringEquals(t, "symbols input", unstripped.String(), install.Input.String())
+
+	// Make doesn't install the symbols again.
+	apexBundle := module.Module().(*apexBundle)
+	data := android.AndroidMkDataForTest(t, ctx, apexBundle)
+	var builder strings.Builder
+	data.Custom(&builder, apexBundle.BaseModuleName(), "TARGET_", "", data)
+	androidMk := builder.String()
+	ensureContains(t, androidMk, "LOCAL_MODULE := mylib.myapex\n")
+	ensureNotContains(t, androidMk, "LOCAL_SOONG_UNSTRIPPED_BINARY")
+
+	// The flattened APEX still relies on Make to install the symbols.
+	flattened := ctx.ModuleForTests("myapex", "android_common_myapex_flattened").Module().(*apexBundle)
+	data = android.AndroidMkDataForTest(t, ctx, flattened)
+	builder.Reset()
+	data.Custom(&builder, flattened.BaseModuleName(), "TARGET_", "", data)
+	ensureContains(t, builder.String(), "LOCAL_SOONG_UNSTRIPPED_BINARY := "+unstripped.String())
+}
+
 func TestFileContexts_FindInDefaultLocationIfNotSet(t *testing.T) {
 	ctx := testApex(t, `
 		apex {
@@ -4528,6 +5337,52 @@ func TestFileContexts_SetViaFileGroup(t *testing.T) {
 	ensureContains(t, rule.RuleParams.Command, "cat product_specific_file_contexts")
 }
 
+func TestFileContextsCheck(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			updatable: false,
+			%s
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+	`
+
+	ctx := testApex(t, fmt.Sprintf(bp, ""))
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+
+	payload := android.ContentFromFileRuleForTests(t, module.Output("file_contexts_check/payload.txt"))
+	ensureListContains(t, strings.Split(payload, "\n"), "/lib64/mylib.so")
+
+	check := module.Output("file_contexts_check/check.timestamp")
+	ensureContains(t, check.RuleParams.Command,
+		"--file-contexts system/sepolicy/apex/myapex-file_contexts "+
+			"--payload out/soong/.intermediates/myapex/android_common_myapex_image/file_contexts_check/payload.txt")
+	ensureNotContains(t, check.RuleParams.Command, "--allow-stale")
+
+	// The check runs before the file_contexts is used.
+	ensureListContains(t, module.Output("file_contexts").Implicits.Strings(),
+		"out/soong/.intermediates/myapex/android_common_myapex_image/file_contexts_check/check.timestamp")
+
+	ctx = testApex(t, fmt.Sprintf(bp, "allow_stale_file_contexts: true,"))
+	check = ctx.ModuleForTests("myapex", "android_common_myapex_image").Output("file_contexts_check/check.timestamp")
+	ensureContains(t, check.RuleParams.Command, "--allow-stale")
+}
+
 func TestApexKeyFromOtherModule(t *testing.T) {
 	ctx := testApex(t, `
 		apex_key {
@@ -4829,6 +5684,54 @@ func TestPrebuiltExportDexImplementationJars(t *testing.T) {
 		checkDexJarInstallPath(t, ctx, "libbar")
 	})
 
+	t.Run("apex_set", func(t *testing.T) {
+		bp := `
+		apex_set {
+			name: "myapex",
+			set: "myapex.apks",
+			exported_java_libs: ["libfoo", "libbar"],
+		}
+
+		java_import {
+			name: "libfoo",
+			jars: ["libfoo.jar"],
+		}
+
+		java_sdk_library_import {
+			name: "libbar",
+			public: {
+				jars: ["libbar.jar"],
+			},
+		}
+	`
+
+		ctx := testDexpreoptWithApexes(t, bp, "", transform)
+
+		// Make sure that the apex extracted from the apex set is decompressed, as it may be a .capex.
+		extractor := ctx.ModuleForTests("myapex.apex.extractor", "android_common")
+		decompress := extractor.Rule("decompressApex")
+		android.AssertPathRelativeToTopEquals(t, "decompress input",
+			"out/soong/.intermediates/myapex.apex.extractor/android_common/extracted/myapex.apks", decompress.Input)
+
+		// Make sure that the deapexer unpacks the decompressed apex.
+		deapexer := ctx.ModuleForTests(deapexerModuleName("myapex"), "android_common")
+		rule := deapexer.Rule("deapexer")
+		if expected, actual := []string{".intermediates/myapex.apex.extractor/android_common/decompressed/myapex.apks"}, android.NormalizePathsForTesting(rule.Implicits); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %q, found: %q", expected, actual)
+		}
+
+		// Make sure that the apex set installs the extracted apex as is.
+		rule = ctx.ModuleForTests("myapex", "android_common_myapex").Rule("android/soong/android.Cp")
+		android.AssertPathRelativeToTopEquals(t, "apex_set input",
+			"out/soong/.intermediates/myapex.apex.extractor/android_common/extracted/myapex.apks", rule.Input)
+
+		checkDexJarBuildPath(t, ctx, "libfoo")
+		checkDexJarInstallPath(t, ctx, "libfoo")
+
+		checkDexJarBuildPath(t, ctx, "libbar")
+		checkDexJarInstallPath(t, ctx, "libbar")
+	})
+
 	t.Run("prebuilt with source preferred", func(t *testing.T) {
 
 		bp := `
@@ -5548,101 +6451,269 @@ func TestInstallExtraFlattenedApexes(t *testing.T) {
 	ensureContains(t, androidMk, "LOCAL_REQUIRED_MODULES += myapex.flattened")
 }
 
-func TestErrorsIfDepsAreNotEnabled(t *testing.T) {
-	testApexError(t, `module "myapex" .* depends on disabled module "libfoo"`, `
+func TestFlattenedApexCheck(t *testing.T) {
+	ctx := testApex(t, `
 		apex {
 			name: "myapex",
 			key: "myapex.key",
-			native_shared_libs: ["libfoo"],
+			native_shared_libs: ["mylib"],
+			updatable: false,
 		}
-
 		apex_key {
 			name: "myapex.key",
 			public_key: "testkey.avbpubkey",
 			private_key: "testkey.pem",
 		}
-
 		cc_library {
-			name: "libfoo",
-			stl: "none",
+			name: "mylib",
+			srcs: ["mylib.cpp"],
 			system_shared_libs: [],
-			enabled: false,
+			stl: "none",
 			apex_available: ["myapex"],
 		}
-	`)
-	testApexError(t, `module "myapex" .* depends on disabled module "myjar"`, `
+	`,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.InstallExtraFlattenedApexes = proptools.BoolPtr(true)
+		}),
+	)
+	check := ctx.SingletonForTests("apex_flattened_check")
+	report := check.Output("apex/flattened_check/discrepancies.txt")
+	ensureEquals(t, android.ContentFromFileRuleForTests(t, report), "\n")
+	check.Output("apex/flattened_check/discrepancies.txt.check")
+}
+
+func TestApexDuplicateFiles(t *testing.T) {
+	ctx := testApex(t, `
 		apex {
 			name: "myapex",
 			key: "myapex.key",
-			java_libs: ["myjar"],
+			native_shared_libs: ["mylib"],
+			updatable: false,
+		}
+		apex {
+			name: "otherapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			updatable: false,
 		}
-
 		apex_key {
 			name: "myapex.key",
 			public_key: "testkey.avbpubkey",
 			private_key: "testkey.pem",
 		}
-
-		java_library {
-			name: "myjar",
-			srcs: ["foo/bar/MyClass.java"],
-			sdk_version: "none",
-			system_modules: "none",
-			enabled: false,
-			apex_available: ["myapex"],
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex", "otherapex"],
 		}
-	`)
+	`,
+		android.FixtureMergeEnv(map[string]string{
+			"APEX_DUPLICATE_FILES_SIZE_LIMIT": "4096",
+		}),
+	)
+
+	singleton := ctx.SingletonForTests("apex_duplicate_files")
+	list := android.ContentFromFileRuleForTests(t, singleton.Output("apex/duplicate_files/files.txt"))
+	ensureContains(t, list, "myapex\tlib64/mylib.so\tmylib\t")
+	ensureContains(t, list, "otherapex\tlib64/mylib.so\tmylib\t")
+
+	report := singleton.Rule("apex_duplicate_files")
+	ensureContains(t, report.RuleParams.Command, "--fail-above 4096")
+	mylib := ctx.ModuleForTests("mylib", "android_arm64_armv8-a_shared_apex10000").Module().(*cc.Module)
+	ensureListContains(t, report.Implicits.Strings(), mylib.OutputFile().Path().String())
 }
 
-func TestApexWithJavaImport(t *testing.T) {
+func TestOdrCheck(t *testing.T) {
 	ctx := testApex(t, `
 		apex {
 			name: "myapex",
 			key: "myapex.key",
-			java_libs: ["myjavaimport"],
+			native_shared_libs: ["mylib"],
 			updatable: false,
 		}
-
 		apex_key {
 			name: "myapex.key",
 			public_key: "testkey.avbpubkey",
 			private_key: "testkey.pem",
 		}
-
-		java_import {
-			name: "myjavaimport",
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			shared_libs: ["libplatform"],
+			system_shared_libs: [],
+			stl: "none",
 			apex_available: ["myapex"],
-			jars: ["my.jar"],
-			compile_dex: true,
 		}
-	`)
+		cc_library {
+			name: "libplatform",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			stubs: {
+				versions: ["1"],
+			},
+		}
+	`,
+		cc.PrepareForTestWithOdrCheck,
+		android.FixtureMergeEnv(map[string]string{
+			"ODR_CHECK_AS_ERRORS": "true",
+		}),
+	)
 
-	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
-	apexRule := module.Rule("apexRule")
-	copyCmds := apexRule.Args["copy_commands"]
-	ensureContains(t, copyCmds, "image.apex/javalib/myjavaimport.jar")
+	singleton := ctx.SingletonForTests("odr_check")
+	contexts := android.ContentFromFileRuleForTests(t, singleton.Output("odr/contexts.json"))
+	ensureContains(t, contexts, `"myapex/arm64"`)
+	ensureContains(t, contexts, `"module": "mylib",
+      "location": "apex"`)
+	ensureContains(t, contexts, `"module": "libplatform",
+      "location": "platform"`)
+
+	check := singleton.Rule("odr_check")
+	ensureContains(t, check.RuleParams.Command, "--as-errors")
+	mylib := ctx.ModuleForTests("mylib", "android_arm64_armv8-a_shared_apex10000").Module().(*cc.Module)
+	libplatform := ctx.ModuleForTests("libplatform", "android_arm64_armv8-a_shared").Module().(*cc.Module)
+	ensureListContains(t, check.Implicits.Strings(), mylib.UnstrippedOutputFile().String())
+	ensureListContains(t, check.Implicits.Strings(), libplatform.UnstrippedOutputFile().String())
 }
 
-func TestApexWithApps(t *testing.T) {
+func TestFlattenedApexCheckDisabled(t *testing.T) {
 	ctx := testApex(t, `
 		apex {
 			name: "myapex",
 			key: "myapex.key",
-			apps: [
-				"AppFoo",
-				"AppFooPriv",
-			],
 			updatable: false,
 		}
-
 		apex_key {
 			name: "myapex.key",
 			public_key: "testkey.avbpubkey",
 			private_key: "testkey.pem",
 		}
+	`)
+	check := ctx.SingletonForTests("apex_flattened_check")
+	if rule := check.MaybeOutput("apex/flattened_check/discrepancies.txt.check").Rule; rule != nil {
+		t.Errorf("expected no flattened APEX check without flattened APEXes, got %q", rule)
+	}
+}
 
-		android_app {
-			name: "AppFoo",
+func TestFlattenedApexDiscrepancies(t *testing.T) {
+	image := apexContents{
+		"lib64/mylib.so":   "mylib (android_arm64_armv8-a_shared_apex10000)",
+		"lib64/other.so":   "other (android_arm64_armv8-a_shared_apex10000)",
+		"apex_manifest.pb": "",
+	}
+	flattened := apexContents{
+		"lib64/mylib.so":   "mylib (android_arm64_armv8-a_shared)",
+		"bin/mybin":        "mybin (android_arm64_armv8-a_apex10000)",
+		"apex_manifest.pb": "",
+	}
+	android.AssertDeepEquals(t, "discrepancies", []string{
+		"myapex: lib64/mylib.so is built from mylib (android_arm64_armv8-a_shared_apex10000) in the image APEX but from mylib (android_arm64_armv8-a_shared) in the flattened APEX",
+		"myapex: lib64/other.so is missing from the flattened APEX",
+		"myapex: bin/mybin is missing from the image APEX",
+	}, flattenedApexDiscrepancies("myapex", image, flattened))
+
+	if got := flattenedApexDiscrepancies("myapex", image, image); len(got) != 0 {
+		t.Errorf("expected no discrepancies for identical contents, got %q", got)
+	}
+}
+
+func TestErrorsIfDepsAreNotEnabled(t *testing.T) {
+	testApexError(t, `module "myapex" .* depends on disabled module "libfoo"`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["libfoo"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "libfoo",
+			stl: "none",
+			system_shared_libs: [],
+			enabled: false,
+			apex_available: ["myapex"],
+		}
+	`)
+	testApexError(t, `module "myapex" .* depends on disabled module "myjar"`, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			java_libs: ["myjar"],
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		java_library {
+			name: "myjar",
+			srcs: ["foo/bar/MyClass.java"],
+			sdk_version: "none",
+			system_modules: "none",
+			enabled: false,
+			apex_available: ["myapex"],
+		}
+	`)
+}
+
+func TestApexWithJavaImport(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			java_libs: ["myjavaimport"],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		java_import {
+			name: "myjavaimport",
+			apex_available: ["myapex"],
+			jars: ["my.jar"],
+			compile_dex: true,
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+	apexRule := module.Rule("apexRule")
+	copyCmds := apexRule.Args["copy_commands"]
+	ensureContains(t, copyCmds, "image.apex/javalib/myjavaimport.jar")
+}
+
+func TestApexWithApps(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			apps: [
+				"AppFoo",
+				"AppFooPriv",
+			],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		android_app {
+			name: "AppFoo",
 			srcs: ["foo/bar/MyClass.java"],
 			sdk_version: "current",
 			system_modules: "none",
@@ -5973,6 +7044,117 @@ func TestApexAvailable_IndirectDep(t *testing.T) {
 	}`)
 }
 
+func TestApexPayloadDepBlocklist(t *testing.T) {
+	bp := `
+	apex {
+		name: "myapex",
+		key: "myapex.key",
+		native_shared_libs: ["libfoo"],
+		payload_dep_blocklist: ["%s"],
+		updatable: false,
+	}
+
+	apex_key {
+		name: "myapex.key",
+		public_key: "testkey.avbpubkey",
+		private_key: "testkey.pem",
+	}
+
+	cc_library {
+		name: "libfoo",
+		stl: "none",
+		shared_libs: ["libbar"],
+		system_shared_libs: [],
+		apex_available: ["myapex"],
+	}
+
+	cc_library {
+		name: "libbar",
+		stl: "none",
+		shared_libs: ["libbaz"],
+		system_shared_libs: [],
+		apex_available: ["myapex"],
+	}
+
+	cc_library {
+		name: "libbaz",
+		stl: "none",
+		system_shared_libs: [],
+		apex_available: ["myapex"],
+	}`
+
+	t.Run("exact name", func(t *testing.T) {
+		testApexError(t, `payload_dep_blocklist: "libbaz" matches "libbaz" but is in the payload.\n\nDependency path:
+.*via tag apex\.dependencyTag.*name:sharedLib.*
+.*-> libfoo.*link:shared.*
+.*via tag cc\.libraryDependencyTag.*Kind:sharedLibraryDependency.*
+.*-> libbar.*link:shared.*
+.*via tag cc\.libraryDependencyTag.*Kind:sharedLibraryDependency.*
+.*-> libbaz.*link:shared.*`, fmt.Sprintf(bp, "libbaz"))
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		testApexError(t, `payload_dep_blocklist: "libbar" matches "libba\*" but is in the payload`,
+			fmt.Sprintf(bp, "libba*"))
+	})
+
+	t.Run("not in payload", func(t *testing.T) {
+		testApex(t, fmt.Sprintf(bp, "libqux"))
+	})
+}
+
+func TestApexAvailable_Suggestions(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForApexTest,
+		android.FixtureAddTextFile("external/bar/Android.bp", `
+			cc_library {
+				name: "libbar",
+				stl: "none",
+				shared_libs: ["libbaz"],
+				system_shared_libs: [],
+			}`),
+		android.FixtureMergeEnv(map[string]string{
+			"APEX_AVAILABLE_SUGGESTIONS": "true",
+		}),
+	).RunTestWithBp(t, `
+	apex {
+		name: "myapex",
+		key: "myapex.key",
+		native_shared_libs: ["libfoo"],
+		updatable: false,
+	}
+
+	apex_key {
+		name: "myapex.key",
+		public_key: "testkey.avbpubkey",
+		private_key: "testkey.pem",
+	}
+
+	cc_library {
+		name: "libfoo",
+		stl: "none",
+		shared_libs: ["libbar"],
+		system_shared_libs: [],
+		apex_available: ["myapex"],
+	}
+
+	cc_library {
+		name: "libbaz",
+		stl: "none",
+		system_shared_libs: [],
+	}`)
+
+	// The missing entries are not errors, the commands that add them are merged from all variants
+	// of the APEX and written once.
+	suggestions := result.SingletonForTests("apex_available_suggestions")
+	android.AssertStringEquals(t, "suggestions", "#!/bin/bash -e\n"+
+		"# Adds the apex_available entries missing for the APEX myapex.\n"+
+		"# Generated by Soong, review the changes before submitting them.\n"+
+		"bpmodify -w -m libbar -property apex_available -a myapex external/bar/Android.bp\n"+
+		"bpmodify -w -m libbaz -property apex_available -a myapex Android.bp\n",
+		android.ContentFromFileRuleForTests(t, suggestions.Output("apex_available_suggestions/myapex.sh")))
+}
+
 func TestApexAvailable_InvalidApexName(t *testing.T) {
 	testApexError(t, "\"otherapex\" is not a valid module name", `
 	apex {
@@ -6088,6 +7270,12 @@ func TestApexAvailable_CheckForPlatform(t *testing.T) {
 	if libfoo.NotAvailableForPlatform() != true {
 		t.Errorf("%q shouldn't be available to platform", libfoo.String())
 	}
+	android.AssertStringEquals(t, "reason",
+		`libfoo -> libbar: apex_available doesn't include "//apex_available:platform"`,
+		libfoo.NotAvailableForPlatformReason())
+	entries := android.AndroidMkEntriesForTest(t, ctx, libfoo)[0]
+	android.AssertStringListContains(t, "LOCAL_NOT_AVAILABLE_FOR_PLATFORM_REASON",
+		entries.EntryMap["LOCAL_NOT_AVAILABLE_FOR_PLATFORM_REASON"], libfoo.NotAvailableForPlatformReason())
 
 	// libfoo2 however can be available to platform because it depends on libbaz which provides
 	// stubs
@@ -6350,6 +7538,185 @@ func TestOverrideApex(t *testing.T) {
 	ensureNotContains(t, androidMk, "LOCAL_MODULE_STEM := myapex.apex")
 }
 
+func TestOverrideApexPayload(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib", "mylib2"],
+			java_libs: ["myjavalib", "myjavalib2"],
+			compressible: false,
+			updatable: false,
+		}
+
+		override_apex {
+			name: "override_myapex",
+			base: "myapex",
+			add_native_shared_libs: ["mylib", "mylib3"],
+			remove_native_shared_libs: ["mylib2"],
+			add_java_libs: ["myjavalib3"],
+			remove_java_libs: ["myjavalib2"],
+			manifest: ":override_myapex.manifest",
+			androidManifest: ":override_myapex.androidmanifest",
+			compressible: true,
+			logging_parent: "com.foo.bar",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		filegroup {
+			name: "override_myapex.manifest",
+			srcs: ["override_apex_manifest.json"],
+		}
+
+		filegroup {
+			name: "override_myapex.androidmanifest",
+			srcs: ["OverrideAndroidManifest.xml"],
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+
+		cc_library {
+			name: "mylib2",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+
+		cc_library {
+			name: "mylib3",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+
+		java_library {
+			name: "myjavalib",
+			srcs: ["a.java"],
+			compile_dex: true,
+			apex_available: ["myapex"],
+		}
+
+		java_library {
+			name: "myjavalib2",
+			srcs: ["a.java"],
+			compile_dex: true,
+			apex_available: ["myapex"],
+		}
+
+		java_library {
+			name: "myjavalib3",
+			srcs: ["a.java"],
+			compile_dex: true,
+			apex_available: ["myapex"],
+		}
+	`, withFiles(map[string][]byte{
+		"override_apex_manifest.json": nil,
+		"OverrideAndroidManifest.xml": nil,
+	}))
+
+	// The base APEX is not affected by the override.
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+	copyCmds := module.Rule("apexRule").Args["copy_commands"]
+	ensureContains(t, copyCmds, "image.apex/lib64/mylib.so")
+	ensureContains(t, copyCmds, "image.apex/lib64/mylib2.so")
+	ensureNotContains(t, copyCmds, "image.apex/lib64/mylib3.so")
+	ensureContains(t, copyCmds, "image.apex/javalib/myjavalib.jar")
+	ensureContains(t, copyCmds, "image.apex/javalib/myjavalib2.jar")
+	ensureNotContains(t, copyCmds, "image.apex/javalib/myjavalib3.jar")
+	ensureEquals(t, module.Rule("apexManifestRule").Input.String(), "apex_manifest.json")
+
+	module = ctx.ModuleForTests("myapex", "android_common_override_myapex_myapex_image")
+	apexRule := module.Rule("apexRule")
+	copyCmds = apexRule.Args["copy_commands"]
+	ensureContains(t, copyCmds, "image.apex/lib64/mylib.so")
+	ensureNotContains(t, copyCmds, "image.apex/lib64/mylib2.so")
+	ensureContains(t, copyCmds, "image.apex/lib64/mylib3.so")
+	ensureContains(t, copyCmds, "image.apex/javalib/myjavalib.jar")
+	ensureNotContains(t, copyCmds, "image.apex/javalib/myjavalib2.jar")
+	ensureContains(t, copyCmds, "image.apex/javalib/myjavalib3.jar")
+	ensureEquals(t, module.Rule("apexManifestRule").Input.String(), "override_apex_manifest.json")
+	ensureContains(t, apexRule.Args["opt_flags"], "--android_manifest OverrideAndroidManifest.xml")
+
+	apexBundle := module.Module().(*apexBundle)
+	ensureEquals(t, apexBundle.overridableProperties.Logging_parent, "com.foo.bar")
+	android.AssertBoolEquals(t, "compressible", true, proptools.Bool(apexBundle.overridableProperties.Compressible))
+
+	// The libraries of both APEXes share the apex variant of myapex. The libraries that the override
+	// removes are only in the base APEX, and the ones that it adds only in the override.
+	ensureListContains(t, ctx.ModuleVariantsForTests("mylib2"), "android_arm64_armv8-a_shared_apex10000")
+	ensureListContains(t, ctx.ModuleVariantsForTests("mylib3"), "android_arm64_armv8-a_shared_apex10000")
+	apexInfo := func(name string) android.ApexInfo {
+		m := ctx.ModuleForTests(name, "android_arm64_armv8-a_shared_apex10000").Module()
+		return ctx.ModuleProvider(m, android.ApexInfoProvider).(android.ApexInfo)
+	}
+	android.AssertDeepEquals(t, "mylib apex modules", []string{"myapex", "override_myapex"},
+		android.SortedUniqueStrings(apexInfo("mylib").InApexModules))
+	android.AssertDeepEquals(t, "mylib2 apex modules", []string{"myapex"}, apexInfo("mylib2").InApexModules)
+	android.AssertDeepEquals(t, "mylib3 apex modules", []string{"override_myapex"}, apexInfo("mylib3").InApexModules)
+}
+
+func TestOverrideApexPayloadErrors(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			java_libs: ["myjavalib"],
+			updatable: false,
+			%s
+		}
+
+		override_apex {
+			name: "override_myapex",
+			base: "myapex",
+			%s
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+
+		java_library {
+			name: "myjavalib",
+			srcs: ["a.java"],
+			compile_dex: true,
+			apex_available: ["myapex"],
+		}
+	`
+
+	testApexError(t, `add_native_shared_libs: is only allowed in override_apex`,
+		fmt.Sprintf(bp, `add_native_shared_libs: ["mylib"],`, ``))
+	testApexError(t, `remove_java_libs: is only allowed in override_apex`,
+		fmt.Sprintf(bp, `remove_java_libs: ["myjavalib"],`, ``))
+	testApexError(t, `remove_native_shared_libs: "libfoo" is not a native shared library of the base apex`,
+		fmt.Sprintf(bp, ``, `remove_native_shared_libs: ["libfoo"],`))
+	testApexError(t, `remove_java_libs: "libfoo" is not in java_libs`,
+		fmt.Sprintf(bp, ``, `remove_java_libs: ["libfoo"],`))
+}
+
 func TestMinSdkVersionOverride(t *testing.T) {
 	// Override from 29 to 31
 	minSdkOverride31 := "31"
@@ -6670,6 +8037,74 @@ func TestJavaSDKLibrary_CrossBoundary(t *testing.T) {
 	}
 }
 
+func TestJavaSDKLibrary_CrossBoundaryMinSdkVersion(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			java_libs: ["bar"],
+			min_sdk_version: "30",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+			sdk_version: "none",
+			system_modules: "none",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+			libs: ["foo"],
+			sdk_version: "none",
+			system_modules: "none",
+			apex_available: ["myapex"],
+			min_sdk_version: "30",
+		}
+
+		prebuilt_apis {
+			name: "sdk",
+			api_dirs: ["100"],
+		}
+	`
+
+	withMinSdkVersionStubs := android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.ApexMinSdkVersionSdkLibraryStubs = proptools.BoolPtr(true)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		ctx := testApex(t, bp, withFiles(filesForSdkLibrary))
+
+		// The bar library in the apex should use the stubs built from source.
+		barLibrary := ctx.ModuleForTests("bar", "android_common_apex30").Rule("javac")
+		android.AssertStringDoesContain(t, "classpath", barLibrary.Args["classpath"], "turbine-combined/foo.stubs.jar")
+		android.AssertStringDoesNotContain(t, "classpath", barLibrary.Args["classpath"], "prebuilts/sdk")
+	})
+
+	t.Run("prebuilt stubs available", func(t *testing.T) {
+		ctx := testApex(t, bp, withFiles(filesForSdkLibrary), withMinSdkVersionStubs, withFiles(android.MockFS{
+			"prebuilts/sdk/30/public/foo.jar": nil,
+		}))
+
+		// The bar library in the apex should use the stubs for the apex min_sdk_version.
+		barLibrary := ctx.ModuleForTests("bar", "android_common_apex30").Rule("javac")
+		android.AssertStringDoesContain(t, "classpath", barLibrary.Args["classpath"], "prebuilts/sdk/30/public/foo.jar")
+	})
+
+	t.Run("prebuilt stubs missing", func(t *testing.T) {
+		testApexError(t, `cannot use java_sdk_library "foo" from apex "myapex": no stubs compatible with the apex min_sdk_version 30`,
+			bp, withFiles(filesForSdkLibrary), withMinSdkVersionStubs)
+	})
+}
+
 func TestJavaSDKLibrary_ImportPreferred(t *testing.T) {
 	ctx := testApex(t, `
 		prebuilt_apis {
@@ -6787,6 +8222,47 @@ func TestJavaSDKLibrary_ImportOnly(t *testing.T) {
 	`, withFiles(filesForSdkLibrary))
 }
 
+func TestApexAsHostTestData(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		java_test_host {
+			name: "apex_parser_tests",
+			srcs: ["a.java"],
+			data: [":myapex"],
+		}
+	`)
+
+	test := ctx.ModuleForTests("apex_parser_tests", "linux_glibc_common")
+	entries := android.AndroidMkEntriesForTest(t, ctx, test.Module())[0]
+	android.AssertStringPathsRelativeToTopEquals(t, "LOCAL_TEST_DATA", ctx.Config(),
+		[]string{"out/soong/.intermediates/myapex/android_common_myapex_image/:myapex.apex"},
+		entries.EntryMap["LOCAL_TEST_DATA"])
+
+	testApexError(t, `path dependency ":mylib" is a device module that doesn't provide host test data`, `
+		java_library {
+			name: "mylib",
+			srcs: ["a.java"],
+		}
+
+		java_test_host {
+			name: "apex_parser_tests",
+			srcs: ["a.java"],
+			data: [":mylib"],
+		}
+	`)
+}
+
 func TestCompatConfig(t *testing.T) {
 	result := android.GroupFixturePreparers(
 		prepareForApexTest,
@@ -8069,6 +9545,7 @@ func TestTestFor(t *testing.T) {
 			system_shared_libs: [],
 			shared_libs: ["mylib", "myprivlib"],
 			stl: "none",
+			test_only: true,
 			test_for: ["myapex"],
 		}
 
@@ -8136,6 +9613,7 @@ func TestIndirectTestFor(t *testing.T) {
 			system_shared_libs: [],
 			shared_libs: ["myprivlib"],
 			stl: "none",
+			test_only: true,
 			test_for: ["myapex"],
 		}
 	`)
@@ -8168,7 +9646,125 @@ func TestTestForForLibInOtherApex(t *testing.T) {
 		apex {
 			name: "com.android.art.debug",
 			key: "myapex.key",
-			native_shared_libs: ["mylib", "mytestlib"],
+			native_shared_libs: ["mylib", "mytestlib"],
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			stubs: {
+				versions: ["1"],
+			},
+			apex_available: ["com.android.art", "com.android.art.debug"],
+		}
+
+		cc_library {
+			name: "mytestlib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			shared_libs: ["mylib"],
+			stl: "none",
+			apex_available: ["com.android.art.debug"],
+			test_only: true,
+			test_for: ["com.android.art"],
+		}
+	`,
+		android.MockFS{
+			"system/sepolicy/apex/com.android.art-file_contexts":       nil,
+			"system/sepolicy/apex/com.android.art.debug-file_contexts": nil,
+		}.AddToFixture())
+}
+
+func TestTestForErrors(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`
+
+	testApexError(t, `module "mybin".*: test_for: is only allowed on test modules`, bp+`
+		cc_binary {
+			name: "mybin",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			test_for: ["myapex"],
+		}
+	`)
+
+	// Libraries must be marked as only used by tests.
+	testApexError(t, `module "mylib".*: test_for: is only allowed on test modules`, bp+`
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			test_for: ["myapex"],
+		}
+	`)
+
+	testApexError(t, `module "myjavalib".*: test_for: is only allowed on test modules`, bp+`
+		java_library {
+			name: "myjavalib",
+			srcs: ["foo/bar/MyClass.java"],
+			sdk_version: "none",
+			system_modules: "none",
+			test_for: ["myapex"],
+		}
+	`)
+
+	testApexError(t, `module "mytest".*: test_for: "otherapex" is not a valid module name`, bp+`
+		cc_test {
+			name: "mytest",
+			gtest: false,
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			test_for: ["otherapex"],
+		}
+	`)
+
+	testApexError(t, `module "mytest".*: test_for: "myjavalib" is not an apex`, bp+`
+		java_library {
+			name: "myjavalib",
+			srcs: ["foo/bar/MyClass.java"],
+			sdk_version: "none",
+			system_modules: "none",
+		}
+
+		cc_test {
+			name: "mytest",
+			gtest: false,
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			test_for: ["myjavalib"],
+		}
+	`)
+}
+
+func TestTestForModuleTypes(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
 			updatable: false,
 		}
 
@@ -8178,31 +9774,33 @@ func TestTestForForLibInOtherApex(t *testing.T) {
 			private_key: "testkey.pem",
 		}
 
-		cc_library {
-			name: "mylib",
-			srcs: ["mylib.cpp"],
-			system_shared_libs: [],
-			stl: "none",
-			stubs: {
-				versions: ["1"],
-			},
-			apex_available: ["com.android.art", "com.android.art.debug"],
+		java_test {
+			name: "myjavatest",
+			srcs: ["a.java"],
+			test_for: ["myapex"],
 		}
 
-		cc_library {
-			name: "mytestlib",
+		cc_test {
+			name: "mytest",
+			gtest: false,
 			srcs: ["mylib.cpp"],
 			system_shared_libs: [],
-			shared_libs: ["mylib"],
 			stl: "none",
-			apex_available: ["com.android.art.debug"],
-			test_for: ["com.android.art"],
+			target: {
+				android: {
+					test_for: ["myapex"],
+				},
+			},
 		}
-	`,
-		android.MockFS{
-			"system/sepolicy/apex/com.android.art-file_contexts":       nil,
-			"system/sepolicy/apex/com.android.art.debug-file_contexts": nil,
-		}.AddToFixture())
+	`)
+
+	// test_for is accepted on java tests.
+	javaTest := ctx.ModuleForTests("myjavatest", "android_common").Module().(android.ApexModule)
+	android.AssertDeepEquals(t, "myjavatest test_for", []string{"myapex"}, javaTest.TestFor())
+
+	// test_for is arch-variant.
+	ccTest := ctx.ModuleForTests("mytest", "android_arm64_armv8-a").Module().(android.ApexModule)
+	android.AssertDeepEquals(t, "mytest test_for", []string{"myapex"}, ccTest.TestFor())
 }
 
 // TODO(jungjw): Move this to proptools
@@ -8410,6 +10008,53 @@ func TestAllowedFiles(t *testing.T) {
 	}
 }
 
+func TestGoldenContents(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			golden_contents: "golden.txt",
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+	`, withFiles(map[string][]byte{
+		"golden.txt": nil,
+	}))
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+	content := module.Output("content.txt")
+	ensureContains(t, content.Args["emit_commands"], "echo './lib64/mylib.so' >> ")
+
+	check := module.Output("golden_contents_check.timestamp")
+	ensureContains(t, check.RuleParams.Command,
+		"if ! diff -u golden.txt out/soong/.intermediates/myapex/android_common_myapex_image/content.txt; then")
+	ensureContains(t, check.RuleParams.Command,
+		"cp out/soong/.intermediates/myapex/android_common_myapex_image/content.txt golden.txt")
+
+	// The check is run whenever the APEX is built.
+	ensureListContains(t, module.Rule("apexRule").Implicits.Strings(),
+		"out/soong/.intermediates/myapex/android_common_myapex_image/golden_contents_check.timestamp")
+
+	// allowed_files is not checked when only golden_contents is set.
+	if rule := module.MaybeRule("diffApexContentRule"); rule.Rule != nil {
+		t.Errorf("expected no allowed_files check")
+	}
+}
+
 func TestNonPreferredPrebuiltDependency(t *testing.T) {
 	testApex(t, `
 		apex {
@@ -8481,6 +10126,226 @@ func TestCompressedApex(t *testing.T) {
 	data.Custom(&builder, ab.BaseModuleName(), "TARGET_", "", data)
 	androidMk := builder.String()
 	ensureContains(t, androidMk, "LOCAL_MODULE_STEM := myapex.capex\n")
+
+	// Verify the size report has both the uncompressed and compressed size
+	sizeReport := ctx.ModuleForTests("myapex", "android_common_myapex_image").Output("size.txt")
+	ensureContains(t, sizeReport.RuleParams.Command, "uncompressed $$(wc -c < out/soong/.intermediates/myapex/android_common_myapex_image/myapex.apex)")
+	ensureContains(t, sizeReport.RuleParams.Command, "compressed $$(wc -c < out/soong/.intermediates/myapex/android_common_myapex_image/myapex.capex)")
+	ensureContains(t, androidMk, "size.txt:myapex-size.txt)")
+}
+
+func TestCompressedApexDisabledByProduct(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			compressible: true,
+			updatable: false,
+		}
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.CompressedApex = proptools.BoolPtr(true)
+			variables.CompressedApexDisabledList = []string{"myapex"}
+		}),
+	)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+	if compressRule := module.MaybeRule("compressRule"); compressRule.Rule != nil {
+		t.Errorf("expected myapex not to be compressed")
+	}
+	ab := module.Module().(*apexBundle)
+	ensureContains(t, ab.outputFile.String(), "myapex.apex")
+
+	sizeReport := module.Output("size.txt")
+	ensureNotContains(t, sizeReport.RuleParams.Command, "compressed $$(wc -c < out/soong/.intermediates/myapex/android_common_myapex_image/myapex.capex)")
+}
+
+func TestErofsPayload(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			payload_fs_type: "erofs",
+			updatable: false,
+		}
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+	`
+
+	t.Run("erofs", func(t *testing.T) {
+		ctx := testApex(t, bp)
+		apexRule := ctx.ModuleForTests("myapex", "android_common_myapex_image").Rule("apexRule")
+		android.AssertStringEquals(t, "payload_fs_type", "erofs", apexRule.Args["payload_fs_type"])
+		android.AssertStringListContains(t, "implicits", apexRule.Implicits.RelativeToTop().Strings(),
+			"out/soong/host/linux-x86/bin/make_erofs")
+	})
+
+	t.Run("ext4", func(t *testing.T) {
+		ctx := testApex(t, strings.Replace(bp, `payload_fs_type: "erofs",`, "", 1))
+		apexRule := ctx.ModuleForTests("myapex", "android_common_myapex_image").Rule("apexRule")
+		android.AssertStringEquals(t, "payload_fs_type", "ext4", apexRule.Args["payload_fs_type"])
+		android.AssertStringListDoesNotContain(t, "implicits", apexRule.Implicits.RelativeToTop().Strings(),
+			"out/soong/host/linux-x86/bin/make_erofs")
+	})
+}
+
+func TestApexSizeBudget(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			max_size: 1000,
+			updatable: false,
+		}
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+	`
+
+	t.Run("max_size", func(t *testing.T) {
+		ctx := testApex(t, bp)
+		module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+
+		sizeCheck := module.Output("size_check.timestamp")
+		ensureContains(t, sizeCheck.RuleParams.Command, "size=$$(wc -c < out/soong/.intermediates/myapex/android_common_myapex_image/myapex.apex);")
+		ensureContains(t, sizeCheck.RuleParams.Command, `if [ "$$size" -gt 1000 ]; then`)
+
+		signapk := module.Description("signapk")
+		android.AssertPathsRelativeToTopEquals(t, "signapk validations",
+			[]string{"out/soong/.intermediates/myapex/android_common_myapex_image/size_check.timestamp"},
+			signapk.Validations)
+
+		sizeReport := module.Output("size.txt")
+		ensureContains(t, sizeReport.RuleParams.Command, `echo "budget 1000"`)
+		ensureContains(t, sizeReport.RuleParams.Command, `stat -L -c "  %s lib64/mylib.so"`)
+	})
+
+	t.Run("product budget", func(t *testing.T) {
+		ctx := testApex(t, bp,
+			android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.ApexSizeBudgets = map[string]int64{"myapex": 2000}
+			}),
+		)
+		module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+
+		sizeCheck := module.Output("size_check.timestamp")
+		ensureContains(t, sizeCheck.RuleParams.Command, `if [ "$$size" -gt 2000 ]; then`)
+	})
+
+	t.Run("no budget", func(t *testing.T) {
+		ctx := testApex(t, strings.Replace(bp, "max_size: 1000,", "", 1))
+		module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+
+		if sizeCheck := module.MaybeOutput("size_check.timestamp"); sizeCheck.Rule != nil {
+			t.Errorf("expected no size check without a size budget")
+		}
+		if validations := module.Description("signapk").Validations; len(validations) > 0 {
+			t.Errorf("expected no validations on signapk, got %q", validations)
+		}
+	})
+
+	t.Run("invalid max_size", func(t *testing.T) {
+		testApexError(t, `"myapex" .*: max_size: must be a positive number of bytes, got 0`,
+			strings.Replace(bp, "max_size: 1000,", "max_size: 0,", 1))
+	})
+}
+
+func TestApexKeyRotationDryRun(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			updatable: false,
+		}
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+			next_public_key: "testkey2.avbpubkey",
+			next_private_key: "testkey2.pem",
+		}
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+	`
+
+	t.Run("dry run", func(t *testing.T) {
+		ctx := testApex(t, bp, android.FixtureMergeEnv(map[string]string{
+			"APEX_KEY_ROTATION_DRY_RUN": "true",
+		}))
+		module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+
+		apexRule := module.Description("apex (next key)")
+		android.AssertPathRelativeToTopEquals(t, "next key apex output",
+			"out/soong/.intermediates/myapex/android_common_myapex_image/next_key/myapex.apex.unsigned",
+			apexRule.Output)
+		android.AssertStringEquals(t, "key", "testkey2.pem", apexRule.Args["key"])
+		ensureContains(t, apexRule.Args["opt_flags"], "--pubkey testkey2.avbpubkey")
+		ensureContains(t, apexRule.Args["opt_flags"], "--do_not_check_keyname")
+		ensureNotContains(t, apexRule.Args["opt_flags"], "testkey.avbpubkey")
+		ensureContains(t, apexRule.Args["copy_commands"],
+			"out/soong/.intermediates/myapex/android_common_myapex_image/next_key/image.apex/lib64/mylib.so")
+		ensureNotContains(t, apexRule.Args["copy_commands"],
+			"out/soong/.intermediates/myapex/android_common_myapex_image/image.apex/")
+
+		// The APEX signed with the current key is unchanged.
+		ensureContains(t, module.Rule("apexRule").Args["opt_flags"], "--pubkey vendor/foo/devkeys/testkey.avbpubkey")
+
+		signapk := module.Description("signapk (next key)")
+		android.AssertPathRelativeToTopEquals(t, "next key signapk output",
+			"out/soong/.intermediates/myapex/android_common_myapex_image/next_key/myapex.apex",
+			signapk.Output)
+		android.AssertPathRelativeToTopEquals(t, "next key signapk input",
+			"out/soong/.intermediates/myapex/android_common_myapex_image/next_key/myapex.apex.unsigned",
+			signapk.Input)
+
+		ab := module.Module().(*apexBundle)
+		data := android.AndroidMkDataForTest(t, ctx, ab)
+		var builder strings.Builder
+		data.Custom(&builder, ab.BaseModuleName(), "TARGET_", "", data)
+		androidMk := android.StringRelativeToTop(ctx.Config(), builder.String())
+		ensureContains(t, androidMk, "$(call dist-for-goals,apex_key_rotation,"+
+			"out/soong/.intermediates/myapex/android_common_myapex_image/myapex.apex:apex_key_rotation/current/myapex.apex)\n")
+		ensureContains(t, androidMk, "$(call dist-for-goals,apex_key_rotation,"+
+			"out/soong/.intermediates/myapex/android_common_myapex_image/next_key/myapex.apex:apex_key_rotation/next/myapex.apex)\n")
+	})
+
+	t.Run("no dry run", func(t *testing.T) {
+		ctx := testApex(t, bp)
+		module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+
+		if rule := module.MaybeDescription("apex (next key)"); rule.Rule != nil {
+			t.Errorf("expected no APEX signed with the next key without %s", keyRotationDryRunEnv)
+		}
+	})
+
+	t.Run("missing next_private_key", func(t *testing.T) {
+		testApexError(t, `"myapex.key" .*: next_public_key and next_private_key must be set together`,
+			strings.Replace(bp, `next_private_key: "testkey2.pem",`, "", 1))
+	})
 }
 
 func TestPreferredPrebuiltSharedLibDep(t *testing.T) {
@@ -8844,6 +10709,56 @@ func TestApexJavaCoverage(t *testing.T) {
 	}
 }
 
+func TestApexNativeCoverageChecks(t *testing.T) {
+	bp := `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib"],
+			updatable: true,
+			min_sdk_version: "29",
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			static_libs: %s,
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+			min_sdk_version: "29",
+		}
+
+		cc_library {
+			name: "libnominsdk",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+	`
+
+	nativeCoverage := android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.GcovCoverage = proptools.BoolPtr(true)
+		variables.Native_coverage = proptools.BoolPtr(true)
+	})
+
+	t.Run("coverage runtime is not checked", func(t *testing.T) {
+		testApex(t, fmt.Sprintf(bp, "[]"), nativeCoverage)
+	})
+
+	t.Run("other dependencies are checked", func(t *testing.T) {
+		testApexError(t, `module "libnominsdk".*: should support min_sdk_version\(29\) for "myapex"`,
+			fmt.Sprintf(bp, `["libnominsdk"]`), nativeCoverage)
+	})
+}
+
 func TestProhibitStaticExecutable(t *testing.T) {
 	testApexError(t, `executable mybin is static`, `
 		apex {
@@ -9520,6 +11435,59 @@ func TestUpdatableApexEnforcesAppUpdatability(t *testing.T) {
 	}
 }
 
+func TestApexExportedSdkAbiDumps(t *testing.T) {
+	ctx := testApex(t, `
+		apex {
+			name: "myapex",
+			key: "myapex.key",
+			native_shared_libs: ["mylib", "myprivatelib"],
+			generate_exported_sdk: true,
+			updatable: false,
+		}
+
+		apex_key {
+			name: "myapex.key",
+			public_key: "testkey.avbpubkey",
+			private_key: "testkey.pem",
+		}
+
+		cc_library {
+			name: "mylib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			stubs: {
+				versions: ["1"],
+			},
+			apex_available: ["myapex"],
+		}
+
+		cc_library {
+			name: "myprivatelib",
+			srcs: ["mylib.cpp"],
+			system_shared_libs: [],
+			stl: "none",
+			apex_available: ["myapex"],
+		}
+	`)
+
+	module := ctx.ModuleForTests("myapex", "android_common_myapex_image")
+	cmd := android.StringRelativeToTop(ctx.Config(), module.Rule("exported_sdk_abi_dumps").RuleParams.Command)
+	android.AssertStringDoesContain(t, "abi dumps", cmd,
+		"-e mylib/arm64/mylib.so.lsdump -f out/soong/.intermediates/mylib/android_arm64_armv8-a_shared_apex10000/mylib.so.lsdump")
+	// Only the libraries with stubs are part of the API surface of the APEX.
+	android.AssertStringDoesNotContain(t, "abi dumps", cmd, "myprivatelib")
+
+	apexBundle := module.Module().(*apexBundle)
+	data := android.AndroidMkDataForTest(t, ctx, apexBundle)
+	var builder strings.Builder
+	data.Custom(&builder, apexBundle.BaseModuleName(), "TARGET_", "", data)
+	androidMk := android.StringRelativeToTop(ctx.Config(), builder.String())
+	ensureContains(t, androidMk, "$(call dist-for-goals,apex_exported_sdks,"+
+		"out/soong/.intermediates/myapex/android_common_myapex_image/myapex-exported-sdk-abi-dumps.zip:"+
+		"apex_exported_sdks/myapex-exported-sdk-abi-dumps.zip)\n")
+}
+
 func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
@@ -65,9 +65,9 @@ func init() {
 	pctx.HostBinToolVariable("extract_apks", "extract_apks")
 	pctx.HostBinToolVariable("make_f2fs", "make_f2fs")
 	pctx.HostBinToolVariable("sload_f2fs", "sload_f2fs")
-	pctx.HostBinToolVariable("make_erofs", "make_erofs")
 	pctx.HostBinToolVariable("apex_compression_tool", "apex_compression_tool")
 	pctx.HostBinToolVariable("dexdeps", "dexdeps")
+	pctx.HostBinToolVariable("deapexer", "deapexer")
 	pctx.SourcePathVariable("genNdkUsedbyApexPath", "build/soong/scripts/gen_ndk_usedby_apex.sh")
 }
 
@@ -107,9 +107,10 @@ var (
 			`--canned_fs_config ${canned_fs_config} ` +
 			`--include_build_info ` +
 			`--payload_type image ` +
+			`--payload_fs_type ${payload_fs_type} ` +
 			`--key ${key} ${opt_flags} ${image_dir} ${out} `,
 		CommandDeps: []string{"${apexer}", "${avbtool}", "${e2fsdroid}", "${merge_zips}",
-			"${mke2fs}", "${resize2fs}", "${sefcontext_compile}", "${make_f2fs}", "${sload_f2fs}", "${make_erofs}",
+			"${mke2fs}", "${resize2fs}", "${sefcontext_compile}", "${make_f2fs}", "${sload_f2fs}",
 			"${soong_zip}", "${zipalign}", "${aapt2}", "prebuilts/sdk/current/public/android.jar"},
 		Rspfile:        "${out}.copy_commands",
 		RspfileContent: "${copy_commands}",
@@ -182,7 +183,7 @@ var (
 // a.manifest.PbOut is protobuf format for R+ devices.
 // TODO(jiyong): make this to return paths instead of directly storing the paths to apexBundle
 func (a *apexBundle) buildManifest(ctx android.ModuleContext, provideNativeLibs, requireNativeLibs []string) {
-	src := android.PathForModuleSrc(ctx, proptools.StringDefault(a.properties.Manifest, "apex_manifest.json"))
+	src := android.PathForModuleSrc(ctx, proptools.StringDefault(a.overridableProperties.Manifest, "apex_manifest.json"))
 
 	// Put dependency({provide|require}NativeLibs) in apex_manifest.json
 	provideNativeLibs = android.SortedUniqueStrings(provideNativeLibs)
@@ -247,6 +248,11 @@ func (a *apexBundle) buildFileContexts(ctx android.ModuleContext) android.Output
 	var fileContextsDir string
 	if a.properties.File_contexts == nil {
 		fileContexts = android.PathForSource(ctx, "system/sepolicy/apex", ctx.ModuleName()+"-file_contexts")
+		// VNDK APEXes of all versions share the file_contexts of the pseudo module name, unless
+		// there is one for the version.
+		if a.vndkApex && !android.ExistentPathForSource(ctx, fileContexts.String()).Valid() {
+			fileContexts = android.PathForSource(ctx, "system/sepolicy/apex", vndkApexName+"-file_contexts")
+		}
 	} else {
 		if m, t := android.SrcIsModuleWithTag(*a.properties.File_contexts); m != "" {
 			otherModule := android.GetModuleFromPathDep(ctx, m, t)
@@ -268,6 +274,10 @@ func (a *apexBundle) buildFileContexts(ctx android.ModuleContext) android.Output
 		ctx.PropertyErrorf("file_contexts", "cannot find file_contexts file: %q", fileContexts.String())
 	}
 
+	// Check the file_contexts against the payload before using it, so that mistakes are reported
+	// before apexer runs.
+	check := a.buildFileContextsCheck(ctx, fileContexts)
+
 	output := android.PathForModuleOut(ctx, "file_contexts")
 	rule := android.NewRuleBuilder(pctx, ctx)
 
@@ -276,7 +286,7 @@ func (a *apexBundle) buildFileContexts(ctx android.ModuleContext) android.Output
 		// remove old file
 		rule.Command().Text("rm").FlagWithOutput("-f ", output)
 		// copy file_contexts
-		rule.Command().Text("cat").Input(fileContexts).Text(">>").Output(output)
+		rule.Command().Text("cat").Input(fileContexts).Implicit(check).Text(">>").Output(output)
 		// new line
 		rule.Command().Text("echo").Text(">>").Output(output)
 		// force-label /apex_manifest.pb and / as system_file so that apexd can read them
@@ -291,7 +301,7 @@ func (a *apexBundle) buildFileContexts(ctx android.ModuleContext) android.Output
 		// remove old file
 		rule.Command().Text("rm").FlagWithOutput("-f ", output)
 		// copy file_contexts
-		rule.Command().Text("awk").Text(`'/object_r/{printf("` + apexPath + `%s\n", $0)}'`).Input(fileContexts).Text(">").Output(output)
+		rule.Command().Text("awk").Text(`'/object_r/{printf("` + apexPath + `%s\n", $0)}'`).Input(fileContexts).Implicit(check).Text(">").Output(output)
 		// new line
 		rule.Command().Text("echo").Text(">>").Output(output)
 		// force-label /apex_manifest.pb and / as system_file so that apexd can read them
@@ -305,6 +315,35 @@ func (a *apexBundle) buildFileContexts(ctx android.ModuleContext) android.Output
 	return output.OutputPath
 }
 
+// buildFileContextsCheck creates a build rule that verifies that every file in the payload of this
+// APEX is labeled by the given file_contexts, and that the file_contexts has no patterns that don't
+// match any file or directory of the payload, unless allow_stale_file_contexts is set. It returns
+// the timestamp file written when the check passes.
+func (a *apexBundle) buildFileContextsCheck(ctx android.ModuleContext, fileContexts android.Path) android.Path {
+	var payload []string
+	for _, fi := range a.filesInfo {
+		payload = append(payload, "/"+fi.path())
+		for _, symlink := range fi.symlinkPaths() {
+			payload = append(payload, "/"+symlink)
+		}
+	}
+	payloadFile := android.PathForModuleOut(ctx, "file_contexts_check", "payload.txt")
+	android.WriteFileRule(ctx, payloadFile, strings.Join(android.SortedUniqueStrings(payload), "\n"))
+
+	timestamp := android.PathForModuleOut(ctx, "file_contexts_check", "check.timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().
+		BuiltTool("apex_file_contexts_check").
+		FlagWithInput("--file-contexts ", fileContexts).
+		FlagWithInput("--payload ", payloadFile)
+	if proptools.Bool(a.properties.Allow_stale_file_contexts) {
+		cmd.Flag("--allow-stale")
+	}
+	cmd.FlagWithOutput("-o ", timestamp)
+	rule.Build("file_contexts_check", "check file_contexts of "+a.Name())
+	return timestamp
+}
+
 // buildInstalledFilesFile creates a build rule for the installed-files.txt file where the list of
 // files included in this APEX is shown. The text file is dist'ed so that people can see what's
 // included in the APEX without actually downloading and extracting it.
@@ -321,6 +360,170 @@ func (a *apexBundle) buildInstalledFilesFile(ctx android.ModuleContext, builtApe
 	return output.OutputPath
 }
 
+// buildSizeReport creates a build rule for the size.txt file where the size in bytes of the
+// uncompressed APEX, and of the compressed APEX if it is compressed, is shown. The text file is
+// dist'ed so that the effect of changes and of compression on the size of the APEX can be tracked.
+func (a *apexBundle) buildSizeReport(ctx android.ModuleContext, uncompressedApex android.Path) {
+	output := android.PathForModuleOut(ctx, "size.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text(`echo "uncompressed $(wc -c <`).Input(uncompressedApex).Text(`)"`).
+		Text(">").Output(output)
+	if a.isCompressed {
+		rule.Command().
+			Text(`echo "compressed $(wc -c <`).Input(a.outputFile).Text(`)"`).
+			Text(">>").Text(output.String())
+	}
+	if budget, ok := a.sizeBudget(ctx); ok {
+		rule.Command().Textf(`echo "budget %d"`, budget).Text(">>").Text(output.String())
+	}
+	// Followed by the size of each file in the APEX, symlinks to the system partition excluded.
+	rule.Command().Text(`echo "files:"`).Text(">>").Text(output.String())
+	for _, fi := range a.filesInfo {
+		if fi.class == appSet || (a.linkToSystemLib && fi.transitiveDep && fi.availableToPlatform()) {
+			continue
+		}
+		rule.Command().
+			Textf(`stat -L -c "  %%s %s"`, fi.path()).Input(fi.builtFile).
+			Text(">>").Text(output.String())
+	}
+	rule.Build("size."+a.Name(), "APEX size")
+	a.sizeReportFile = output
+	ctx.Phony(a.Name()+"-size", output)
+}
+
+// sizeBudget returns the maximum size in bytes of the uncompressed APEX file, and whether the
+// APEX has a size budget. The budget set by the product takes precedence over the max_size
+// property.
+func (a *apexBundle) sizeBudget(ctx android.ModuleContext) (int64, bool) {
+	if budget, ok := ctx.Config().ApexSizeBudget(a.Name()); ok {
+		return budget, true
+	}
+	if a.overridableProperties.Max_size != nil {
+		return *a.overridableProperties.Max_size, true
+	}
+	return 0, false
+}
+
+// buildSizeCheck creates a build rule that fails when the uncompressed APEX file is larger than
+// the size budget of the APEX. It returns the timestamp file of the check, or nil if the APEX has
+// no size budget.
+func (a *apexBundle) buildSizeCheck(ctx android.ModuleContext, uncompressedApex android.Path) android.Path {
+	budget, ok := a.sizeBudget(ctx)
+	if !ok {
+		return nil
+	}
+	if budget <= 0 {
+		ctx.PropertyErrorf("max_size", "must be a positive number of bytes, got %d", budget)
+		return nil
+	}
+	timestamp := android.PathForModuleOut(ctx, "size_check.timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text(`size=$(wc -c <`).Input(uncompressedApex).Text(`);`).
+		Textf(`if [ "$size" -gt %d ]; then`, budget).
+		Textf(`echo "%s: the APEX is $size bytes, which exceeds its size budget of %d bytes" >&2;`,
+			a.Name(), budget).
+		Text(`exit 1; fi`)
+	rule.Command().Text("touch").Output(timestamp)
+	rule.Build("size_check."+a.Name(), "APEX size check")
+	return timestamp
+}
+
+// keyRotationDryRunEnv is the environment variable that enables building, for each APEX whose
+// apex_key has next_public_key and next_private_key set, an additional APEX whose payload is
+// signed with the next key. Both APEXs are dist'ed under apex_key_rotation/ so that a key rotation
+// can be rehearsed without invoking the signing tools by hand.
+const keyRotationDryRunEnv = "APEX_KEY_ROTATION_DRY_RUN"
+
+// shouldBuildKeyRotationDryRun returns true if an APEX signed with the next key of the apex_key
+// should be built.
+func (a *apexBundle) shouldBuildKeyRotationDryRun(ctx android.ModuleContext) bool {
+	return ctx.Config().IsEnvTrue(keyRotationDryRunEnv) && a.nextPrivateKeyFile != nil &&
+		!a.testOnlyShouldSkipPayloadSign()
+}
+
+// buildNextKeyApex creates a build rule for an unsigned APEX with the same content as the one
+// built with apexArgs, but whose payload is signed with the next key of the apex_key.
+func (a *apexBundle) buildNextKeyApex(ctx android.ModuleContext, apexArgs map[string]string,
+	optFlags []string, implicitInputs android.Paths, imageDir android.ModuleOutPath, suffix string) android.Path {
+
+	// apexer removes the image dir before populating it, so the APEX needs its own image dir.
+	nextKeyImageDir := android.PathForModuleOut(ctx, "next_key", "image"+suffix)
+	output := android.PathForModuleOut(ctx, "next_key", a.Name()+suffix+".unsigned")
+
+	// The name of the next key doesn't have to match the name of the APEX.
+	nextKeyOptFlags := []string{"--pubkey " + a.nextPublicKeyFile.String(), "--do_not_check_keyname"}
+	for _, flag := range optFlags {
+		if !strings.HasPrefix(flag, "--pubkey ") && flag != "--do_not_check_keyname" {
+			nextKeyOptFlags = append(nextKeyOptFlags, flag)
+		}
+	}
+
+	args := make(map[string]string)
+	for k, v := range apexArgs {
+		args[k] = v
+	}
+	args["image_dir"] = nextKeyImageDir.String()
+	args["copy_commands"] = strings.ReplaceAll(apexArgs["copy_commands"], imageDir.String(), nextKeyImageDir.String())
+	args["key"] = a.nextPrivateKeyFile.String()
+	args["opt_flags"] = strings.Join(nextKeyOptFlags, " ")
+
+	implicits := append(android.Paths{a.nextPrivateKeyFile, a.nextPublicKeyFile}, implicitInputs...)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        apexRule,
+		Implicits:   implicits,
+		Output:      output,
+		Description: "apex (next key)",
+		Args:        args,
+	})
+	return output
+}
+
+// buildImageContentFile creates a build rule for content.txt, the sorted list of the files in this
+// APEX relative to the root of the APEX, each starting with "./".
+func (a *apexBundle) buildImageContentFile(ctx android.ModuleContext, implicitInputs android.Paths) android.Path {
+	var emitCommands []string
+	imageContentFile := android.PathForModuleOut(ctx, "content.txt")
+	emitCommands = append(emitCommands, "echo ./apex_manifest.pb >> "+imageContentFile.String())
+	minSdkVersion := a.minSdkVersion(ctx)
+	if minSdkVersion.EqualTo(android.SdkVersion_Android10) {
+		emitCommands = append(emitCommands, "echo ./apex_manifest.json >> "+imageContentFile.String())
+	}
+	for _, fi := range a.filesInfo {
+		emitCommands = append(emitCommands, "echo './"+fi.path()+"' >> "+imageContentFile.String())
+	}
+	emitCommands = append(emitCommands, "sort -o "+imageContentFile.String()+" "+imageContentFile.String())
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        emitApexContentRule,
+		Implicits:   implicitInputs,
+		Output:      imageContentFile,
+		Description: "emit apex image content",
+		Args: map[string]string{
+			"emit_commands": strings.Join(emitCommands, " && "),
+		},
+	})
+	return imageContentFile
+}
+
+// buildGoldenContentsCheck creates a build rule that fails with a diff if the files in this APEX,
+// listed in imageContentFile, differ from the golden list given via the golden_contents property.
+func (a *apexBundle) buildGoldenContentsCheck(ctx android.ModuleContext, imageContentFile android.Path) android.Path {
+	goldenContentsFile := android.PathForModuleSrc(ctx, proptools.String(a.overridableProperties.Golden_contents))
+	timestamp := android.PathForModuleOut(ctx, "golden_contents_check.timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text("if ! diff -u").Input(goldenContentsFile).Input(imageContentFile).Text("; then").
+		Textf(`echo "The files in %s differ from its golden_contents %s (+: added, -: removed)." >&2;`,
+			a.Name(), goldenContentsFile).
+		Text(`echo "If the changes are intended, update the golden list by running:" >&2;`).
+		Textf(`echo "  cp %s %s" >&2;`, imageContentFile, goldenContentsFile).
+		Text("exit 1; fi")
+	rule.Command().Text("touch").Output(timestamp)
+	rule.Build("golden_contents_check", "check "+a.Name()+" contents against the golden list")
+	return timestamp
+}
+
 // buildBundleConfig creates a build rule for the bundle config file that will control the bundle
 // creation process.
 func (a *apexBundle) buildBundleConfig(ctx android.ModuleContext) android.OutputPath {
@@ -399,10 +602,17 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext) {
 	}
 
 	// Avoid creating duplicate build rules for multi-installed APEXes.
-	if proptools.BoolDefault(a.properties.Multi_install_skip_symbol_files, false) {
+	if a.skipSymbolFiles() {
 		installSymbolFiles = false
 
 	}
+	// Install the unstripped native libraries to $(PRODUCT_OUT)/symbols/apex/<apex_name> so
+	// that crashes in the APEX can be symbolized. Only the primary variant installs them, as
+	// the other variants would install them to the same paths.
+	installNativeLibSymbols := installSymbolFiles && a.primaryApexType
+	a.installedNativeLibSymbols = installNativeLibSymbols
+	symbolsPathWhenActivated := android.PathForModuleInPartitionInstall(ctx, "symbols", "apex", apexName)
+
 	// set of dependency module:location mappings
 	installMapSet := make(map[string]bool)
 
@@ -447,6 +657,9 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext) {
 			if installSymbolFiles {
 				implicitInputs = append(implicitInputs, installedPath)
 			}
+			if installNativeLibSymbols && fi.class == nativeSharedLib && fi.unstrippedBuiltFile != nil {
+				ctx.InstallFile(symbolsPathWhenActivated.Join(ctx, fi.installDir), fi.stem(), fi.unstrippedBuiltFile)
+			}
 
 			// Create additional symlinks pointing the file inside the APEX (if any). Note that
 			// this is independent from the symlink optimization.
@@ -494,61 +707,50 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext) {
 
 	////////////////////////////////////////////////////////////////////////////////////////////
 	// Step 1.a: Write the list of files in this APEX to a txt file and compare it against
-	// the allowed list given via the allowed_files property. Build fails when the two lists
-	// differ.
+	// the allowed list given via the allowed_files property and the golden list given via the
+	// golden_contents property. Build fails when the lists differ.
 	//
-	// TODO(jiyong): consider removing this. Nobody other than com.android.apex.cts.shim.* seems
-	// to be using this at this moment. Furthermore, this looks very similar to what
-	// buildInstalledFilesFile does. At least, move this to somewhere else so that this doesn't
-	// hurt readability.
+	// TODO(jiyong): consider removing the allowed_files check. Nobody other than
+	// com.android.apex.cts.shim.* seems to be using this at this moment. Furthermore, this looks
+	// very similar to what buildInstalledFilesFile does.
 	// TODO(jiyong): use RuleBuilder
-	if a.overridableProperties.Allowed_files != nil {
-		// Build content.txt
-		var emitCommands []string
-		imageContentFile := android.PathForModuleOut(ctx, "content.txt")
-		emitCommands = append(emitCommands, "echo ./apex_manifest.pb >> "+imageContentFile.String())
-		minSdkVersion := a.minSdkVersion(ctx)
-		if minSdkVersion.EqualTo(android.SdkVersion_Android10) {
-			emitCommands = append(emitCommands, "echo ./apex_manifest.json >> "+imageContentFile.String())
-		}
-		for _, fi := range a.filesInfo {
-			emitCommands = append(emitCommands, "echo './"+fi.path()+"' >> "+imageContentFile.String())
-		}
-		emitCommands = append(emitCommands, "sort -o "+imageContentFile.String()+" "+imageContentFile.String())
-		ctx.Build(pctx, android.BuildParams{
-			Rule:        emitApexContentRule,
-			Implicits:   implicitInputs,
-			Output:      imageContentFile,
-			Description: "emit apex image content",
-			Args: map[string]string{
-				"emit_commands": strings.Join(emitCommands, " && "),
-			},
-		})
+	if a.overridableProperties.Allowed_files != nil || a.overridableProperties.Golden_contents != nil {
+		imageContentFile := a.buildImageContentFile(ctx, implicitInputs)
 		implicitInputs = append(implicitInputs, imageContentFile)
 
-		// Compare content.txt against allowed_files.
-		allowedFilesFile := android.PathForModuleSrc(ctx, proptools.String(a.overridableProperties.Allowed_files))
-		phonyOutput := android.PathForModuleOut(ctx, a.Name()+"-diff-phony-output")
-		ctx.Build(pctx, android.BuildParams{
-			Rule:        diffApexContentRule,
-			Implicits:   implicitInputs,
-			Output:      phonyOutput,
-			Description: "diff apex image content",
-			Args: map[string]string{
-				"allowed_files_file": allowedFilesFile.String(),
-				"image_content_file": imageContentFile.String(),
-				"apex_module_name":   a.Name(),
-			},
-		})
-		implicitInputs = append(implicitInputs, phonyOutput)
+		if a.overridableProperties.Allowed_files != nil {
+			// Compare content.txt against allowed_files.
+			allowedFilesFile := android.PathForModuleSrc(ctx, proptools.String(a.overridableProperties.Allowed_files))
+			phonyOutput := android.PathForModuleOut(ctx, a.Name()+"-diff-phony-output")
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        diffApexContentRule,
+				Implicits:   implicitInputs,
+				Output:      phonyOutput,
+				Description: "diff apex image content",
+				Args: map[string]string{
+					"allowed_files_file": allowedFilesFile.String(),
+					"image_content_file": imageContentFile.String(),
+					"apex_module_name":   a.Name(),
+				},
+			})
+			implicitInputs = append(implicitInputs, phonyOutput)
+		}
+
+		if a.overridableProperties.Golden_contents != nil {
+			implicitInputs = append(implicitInputs, a.buildGoldenContentsCheck(ctx, imageContentFile))
+		}
 	}
 
 	unsignedOutputFile := android.PathForModuleOut(ctx, a.Name()+suffix+".unsigned")
 	outHostBinDir := ctx.Config().HostToolPath(ctx, "").String()
 	prebuiltSdkToolsBinDir := filepath.Join("prebuilts", "sdk", "tools", runtime.GOOS, "bin")
 
+	// The unsigned APEX whose payload is signed with the next key of the apex_key, if any.
+	var nextKeyUnsignedOutputFile android.Path
+
 	// Figure out if we need to compress the apex.
-	compressionEnabled := ctx.Config().CompressedApex() && proptools.BoolDefault(a.overridableProperties.Compressible, false) && !a.testApex && !ctx.Config().UnbundledBuildApps()
+	compressionEnabled := ctx.Config().CompressedApex() && proptools.BoolDefault(a.overridableProperties.Compressible, false) && !a.testApex && !ctx.Config().UnbundledBuildApps() &&
+		!ctx.Config().CompressedApexDisabled(a.Name())
 	if apexType == imageApex {
 
 		////////////////////////////////////////////////////////////////////////////////////
@@ -573,8 +775,8 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext) {
 			optFlags = append(optFlags, "--override_apk_package_name "+manifestPackageName)
 		}
 
-		if a.properties.AndroidManifest != nil {
-			androidManifestFile := android.PathForModuleSrc(ctx, proptools.String(a.properties.AndroidManifest))
+		if a.overridableProperties.AndroidManifest != nil {
+			androidManifestFile := android.PathForModuleSrc(ctx, proptools.String(a.overridableProperties.AndroidManifest))
 
 			if a.testApex {
 				androidManifestFile = markManifestTestOnly(ctx, androidManifestFile)
@@ -655,25 +857,35 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext) {
 			optFlags = append(optFlags, "--manifest_json "+a.manifestJsonOut.String())
 		}
 
-		optFlags = append(optFlags, "--payload_fs_type "+a.payloadFsType.string())
+		if a.payloadFsType == erofs {
+			// Only EROFS APEXes need the tool that builds EROFS images, which apexer finds in
+			// the host bin directory.
+			implicitInputs = append(implicitInputs, ctx.Config().HostToolPath(ctx, "make_erofs"))
+		}
 
+		apexArgs := map[string]string{
+			"tool_path":        outHostBinDir + ":" + prebuiltSdkToolsBinDir,
+			"image_dir":        imageDir.String(),
+			"copy_commands":    strings.Join(copyCommands, " && "),
+			"manifest":         a.manifestPbOut.String(),
+			"file_contexts":    fileContexts.String(),
+			"canned_fs_config": cannedFsConfig.String(),
+			"key":              a.privateKeyFile.String(),
+			"opt_flags":        strings.Join(optFlags, " "),
+			"payload_fs_type":  a.payloadFsType.string(),
+		}
 		ctx.Build(pctx, android.BuildParams{
 			Rule:        apexRule,
 			Implicits:   implicitInputs,
 			Output:      unsignedOutputFile,
 			Description: "apex (" + apexType.name() + ")",
-			Args: map[string]string{
-				"tool_path":        outHostBinDir + ":" + prebuiltSdkToolsBinDir,
-				"image_dir":        imageDir.String(),
-				"copy_commands":    strings.Join(copyCommands, " && "),
-				"manifest":         a.manifestPbOut.String(),
-				"file_contexts":    fileContexts.String(),
-				"canned_fs_config": cannedFsConfig.String(),
-				"key":              a.privateKeyFile.String(),
-				"opt_flags":        strings.Join(optFlags, " "),
-			},
+			Args:        apexArgs,
 		})
 
+		if a.shouldBuildKeyRotationDryRun(ctx) {
+			nextKeyUnsignedOutputFile = a.buildNextKeyApex(ctx, apexArgs, optFlags, implicitInputs, imageDir, suffix)
+		}
+
 		// TODO(jiyong): make the two rules below as separate functions
 		apexProtoFile := android.PathForModuleOut(ctx, a.Name()+".pb"+suffix)
 		bundleModuleFile := android.PathForModuleOut(ctx, a.Name()+suffix+"-base.zip")
@@ -755,6 +967,7 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext) {
 				"config": bundleConfig.String(),
 			},
 		})
+		android.CheckReproducibleOutput(ctx, android.ReproducibleZip, a.bundleModuleFile, "")
 	} else { // zipApex
 		ctx.Build(pctx, android.BuildParams{
 			Rule:        zipApexRule,
@@ -776,28 +989,70 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext) {
 
 	pem, key := a.getCertificateAndPrivateKey(ctx)
 	rule := java.Signapk
+	flags := []string{"-a 4096 --align-file-size"} //alignment
+	implicits := android.Paths{pem, key}
+	if lineage := String(a.overridableProperties.Lineage); lineage != "" {
+		lineageFile := android.PathForModuleSrc(ctx, lineage)
+		flags = append(flags, "--lineage", lineageFile.String())
+		implicits = append(implicits, lineageFile)
+	}
+	if rotationMinSdkVersion := String(a.overridableProperties.Rotation_min_sdk_version); rotationMinSdkVersion != "" {
+		flags = append(flags, "--rotation-min-sdk-version", rotationMinSdkVersion)
+	}
 	args := map[string]string{
 		"certificates": pem.String() + " " + key.String(),
-		"flags":        "-a 4096 --align-file-size", //alignment
+		"flags":        strings.Join(flags, " "),
 	}
-	implicits := android.Paths{pem, key}
 	if ctx.Config().UseRBE() && ctx.Config().IsEnvTrue("RBE_SIGNAPK") {
 		rule = java.SignapkRE
 		args["implicits"] = strings.Join(implicits.Strings(), ",")
 		args["outCommaList"] = signedOutputFile.String()
 	}
+	// The size check is a validation of the signed APEX so that it runs whenever the APEX is
+	// built, even though it depends on the signed APEX itself.
+	var validations android.Paths
+	if sizeCheck := a.buildSizeCheck(ctx, signedOutputFile); sizeCheck != nil {
+		validations = append(validations, sizeCheck)
+	}
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        rule,
 		Description: "signapk",
 		Output:      signedOutputFile,
 		Input:       unsignedOutputFile,
 		Implicits:   implicits,
+		Validations: validations,
 		Args:        args,
 	})
 	if suffix == imageApexSuffix {
 		a.outputApexFile = signedOutputFile
 	}
 	a.outputFile = signedOutputFile
+	android.CheckReproducibleOutput(ctx, android.ReproducibleZip, signedOutputFile, "")
+
+	if nextKeyUnsignedOutputFile != nil {
+		// Only the payload is signed with the next key, the container is signed with the same
+		// certificate as the APEX that is installed.
+		nextKeySignedOutputFile := android.PathForModuleOut(ctx, "next_key", a.Name()+suffix)
+		nextKeyArgs := make(map[string]string)
+		for k, v := range args {
+			nextKeyArgs[k] = v
+		}
+		if _, ok := nextKeyArgs["outCommaList"]; ok {
+			nextKeyArgs["outCommaList"] = nextKeySignedOutputFile.String()
+		}
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        rule,
+			Description: "signapk (next key)",
+			Output:      nextKeySignedOutputFile,
+			Input:       nextKeyUnsignedOutputFile,
+			Implicits:   implicits,
+			Args:        nextKeyArgs,
+		})
+		a.keyRotationCurrentFile = signedOutputFile
+		a.keyRotationNextFile = nextKeySignedOutputFile
+		ctx.Phony(a.Name()+"-key-rotation", signedOutputFile, nextKeySignedOutputFile)
+		ctx.Phony("apex_key_rotation", signedOutputFile, nextKeySignedOutputFile)
+	}
 
 	if ctx.ModuleDir() != "system/apex/apexd/apexd_testdata" && a.testOnlyShouldForceCompression() {
 		ctx.PropertyErrorf("test_only_force_compression", "not available")
@@ -833,8 +1088,11 @@ func (a *apexBundle) buildUnflattenedApex(ctx android.ModuleContext) {
 			Args:        args,
 		})
 		a.outputFile = signedCompressedOutputFile
+		android.CheckReproducibleOutput(ctx, android.ReproducibleZip, signedCompressedOutputFile, "")
 	}
 
+	a.buildSizeReport(ctx, signedOutputFile)
+
 	installSuffix := suffix
 	if a.isCompressed {
 		installSuffix = imageCapexSuffix
@@ -947,7 +1205,9 @@ func (a *apexBundle) buildApexDependencyInfo(ctx android.ModuleContext) {
 	}
 
 	depInfos := android.DepNameToDepInfoMap{}
-	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool) bool {
+	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool,
+		tag blueprint.DependencyTag, path []android.Module) bool {
+
 		if from.Name() == to.Name() {
 			// This can happen for cc.reuseObjTag. We are not interested in tracking this.
 			// As soon as the dependency graph crosses the APEX boundary, don't go further.
@@ -960,39 +1220,16 @@ func (a *apexBundle) buildApexDependencyInfo(ctx android.ModuleContext) {
 			return !externalDep
 		}
 
-		depTag := ctx.OtherModuleDependencyTag(to)
 		// Check to see if dependency been marked to skip the dependency check
-		if skipDepCheck, ok := depTag.(android.SkipApexAllowedDependenciesCheck); ok && skipDepCheck.SkipApexAllowedDependenciesCheck() {
+		if skipDepCheck, ok := tag.(android.SkipApexAllowedDependenciesCheck); ok && skipDepCheck.SkipApexAllowedDependenciesCheck() {
 			return !externalDep
 		}
 
 		if info, exists := depInfos[to.Name()]; exists {
-			if !android.InList(from.Name(), info.From) {
-				info.From = append(info.From, from.Name())
-			}
-			info.IsExternal = info.IsExternal && externalDep
+			info.AddDependent(from, to, externalDep)
 			depInfos[to.Name()] = info
 		} else {
-			toMinSdkVersion := "(no version)"
-			if m, ok := to.(interface {
-				MinSdkVersion(ctx android.EarlyModuleContext) android.SdkSpec
-			}); ok {
-				if v := m.MinSdkVersion(ctx); !v.ApiLevel.IsNone() {
-					toMinSdkVersion = v.ApiLevel.String()
-				}
-			} else if m, ok := to.(interface{ MinSdkVersion() string }); ok {
-				// TODO(b/175678607) eliminate the use of MinSdkVersion returning
-				// string
-				if v := m.MinSdkVersion(); v != "" {
-					toMinSdkVersion = v
-				}
-			}
-			depInfos[to.Name()] = android.ApexModuleDepInfo{
-				To:            to.Name(),
-				From:          []string{from.Name()},
-				IsExternal:    externalDep,
-				MinSdkVersion: toMinSdkVersion,
-			}
+			depInfos[to.Name()] = android.NewApexModuleDepInfo(from, to, externalDep, depMinSdkVersion(ctx, to))
 		}
 
 		// As soon as the dependency graph crosses the APEX boundary, don't go further.
@@ -1000,6 +1237,7 @@ func (a *apexBundle) buildApexDependencyInfo(ctx android.ModuleContext) {
 	})
 
 	a.ApexBundleDepsInfo.BuildDepsInfoLists(ctx, a.MinSdkVersion(ctx).Raw, depInfos)
+	a.buildDepsGraph(ctx)
 
 	ctx.Build(pctx, android.BuildParams{
 		Rule:   android.Phony,
@@ -1007,10 +1245,88 @@ func (a *apexBundle) buildApexDependencyInfo(ctx android.ModuleContext) {
 		Inputs: []android.Path{
 			a.ApexBundleDepsInfo.FullListPath(),
 			a.ApexBundleDepsInfo.FlatListPath(),
+			a.ApexBundleDepsInfo.JsonListPath(),
 		},
 	})
 }
 
+// depMinSdkVersion returns the min_sdk_version of a dependency of the APEX for the dependency info
+// and graph.
+func depMinSdkVersion(ctx android.ModuleContext, to android.ApexModule) string {
+	if m, ok := to.(interface {
+		MinSdkVersion(ctx android.EarlyModuleContext) android.SdkSpec
+	}); ok {
+		if v := m.MinSdkVersion(ctx); !v.ApiLevel.IsNone() {
+			return v.ApiLevel.String()
+		}
+	} else if m, ok := to.(interface{ MinSdkVersion() string }); ok {
+		// TODO(b/175678607) eliminate the use of MinSdkVersion returning
+		// string
+		if v := m.MinSdkVersion(); v != "" {
+			return v
+		}
+	}
+	return "(no version)"
+}
+
+// buildDepsGraph writes the dependency graph of the payload of the APEX in the DOT format. Unlike
+// the dependency info, the graph includes the dependencies that are only available to APEXes, so
+// that it shows everything that pulls a library into the APEX. Each node is annotated with the
+// min_sdk_version of the module, and the external dependencies, which aren't included in the
+// APEX, are drawn dashed. The graphs of all APEXes are built and dist'ed by the apex-deps-graph
+// goal.
+func (a *apexBundle) buildDepsGraph(ctx android.ModuleContext) {
+	type node struct {
+		minSdkVersion string
+		external      bool
+	}
+	nodes := make(map[string]*node)
+	edges := make(map[string]bool)
+
+	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool,
+		tag blueprint.DependencyTag, path []android.Module) bool {
+
+		// This can happen for cc.reuseObjTag.
+		if from.Name() == to.Name() {
+			return !externalDep
+		}
+
+		if n, exists := nodes[to.Name()]; exists {
+			n.external = n.external && externalDep
+		} else {
+			nodes[to.Name()] = &node{
+				minSdkVersion: depMinSdkVersion(ctx, to),
+				external:      externalDep,
+			}
+		}
+		edges[fmt.Sprintf("  %q -> %q;", from.Name(), to.Name())] = true
+
+		// As soon as the dependency graph crosses the APEX boundary, don't go further.
+		return !externalDep
+	})
+
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "digraph %q {\n", a.Name())
+	fmt.Fprintf(&graph, "  %q [shape=box, label=%q];\n", a.Name(),
+		fmt.Sprintf("%s\nmin_sdk_version: %s", a.Name(), a.MinSdkVersion(ctx).Raw))
+	for _, name := range android.SortedStringKeys(nodes) {
+		n := nodes[name]
+		style := "solid"
+		if n.external {
+			style = "dashed"
+		}
+		fmt.Fprintf(&graph, "  %q [label=%q, style=%s];\n", name,
+			fmt.Sprintf("%s\nmin_sdk_version: %s", name, n.minSdkVersion), style)
+	}
+	for _, edge := range android.SortedStringKeys(edges) {
+		fmt.Fprintln(&graph, edge)
+	}
+	fmt.Fprintln(&graph, "}")
+
+	a.depsGraphFile = android.PathForModuleOut(ctx, "depsinfo", "deps.dot")
+	android.WriteFileRule(ctx, a.depsGraphFile, graph.String())
+}
+
 func (a *apexBundle) buildLintReports(ctx android.ModuleContext) {
 	depSetsBuilder := java.NewLintDepSetBuilder()
 	for _, fi := range a.filesInfo {
@@ -1071,6 +1387,9 @@ func (a *apexBundle) buildCannedFsConfig(ctx android.ModuleContext) android.Outp
 		file := appSetFiles[dir]
 		cmd.Text("zipinfo -1").Input(file).Textf(`| sed "s:\(.*\):/%s/\1 1000 1000 0644:";`, dir)
 	}
+	for _, line := range a.extraCannedFsConfigEntries(ctx, executablePaths) {
+		cmd.Textf("echo '%s';", line)
+	}
 	// Custom fs_config is "appended" to the last so that entries from the file are preferred
 	// over default ones set above.
 	if a.properties.Canned_fs_config != nil {
@@ -1081,3 +1400,90 @@ func (a *apexBundle) buildCannedFsConfig(ctx android.ModuleContext) android.Outp
 
 	return cannedFsConfig.OutputPath
 }
+
+func sameFsConfig(a, b android.ApexFsConfig) bool {
+	sameInt := func(x, y *int64) bool {
+		return (x == nil) == (y == nil) && (x == nil || *x == *y)
+	}
+	return sameInt(a.Uid, b.Uid) && sameInt(a.Gid, b.Gid) &&
+		proptools.String(a.Mode) == proptools.String(b.Mode) &&
+		proptools.String(a.Capabilities) == proptools.String(b.Capabilities)
+}
+
+// extraCannedFsConfigEntries returns the canned_fs_config lines from the canned_fs_config_entries
+// property and from the payload modules implementing android.ApexFsConfigProvider. Unset fields
+// fall back to the defaults of the file. Entries for the same path from different sources must
+// agree.
+func (a *apexBundle) extraCannedFsConfigEntries(ctx android.ModuleContext, executablePaths []string) []string {
+	type source struct {
+		config android.ApexFsConfig
+		from   string
+	}
+	sources := make(map[string]source)
+	filesInApex := make(map[string]bool)
+	for _, f := range a.filesInfo {
+		filesInApex[f.path()] = true
+	}
+
+	add := func(path string, config android.ApexFsConfig, from string) {
+		if prev, ok := sources[path]; ok {
+			if !sameFsConfig(prev.config, config) {
+				ctx.PropertyErrorf("canned_fs_config_entries", "conflicting entries for %q from %s and %s",
+					path, prev.from, from)
+			}
+			return
+		}
+		sources[path] = source{config, from}
+	}
+
+	for _, f := range a.filesInfo {
+		if p, ok := f.module.(android.ApexFsConfigProvider); ok {
+			if config := p.ApexFsConfig(); config != nil {
+				add(f.path(), *config, fmt.Sprintf("module %q", f.module.Name()))
+			}
+		}
+	}
+	for _, e := range a.properties.Canned_fs_config_entries {
+		path := filepath.Clean(strings.TrimPrefix(proptools.String(e.Path), "/"))
+		if !filesInApex[path] {
+			ctx.PropertyErrorf("canned_fs_config_entries", "%q is not a file in the APEX", proptools.String(e.Path))
+			continue
+		}
+		add(path, e.ApexFsConfig, "canned_fs_config_entries")
+	}
+
+	var lines []string
+	for _, path := range android.SortedStringKeys(sources) {
+		config := sources[path].config
+		uid, gid, mode := int64(1000), int64(1000), "0644"
+		if android.InList(path, executablePaths) {
+			uid, gid, mode = 0, 2000, "0755"
+		}
+		if config.Uid != nil {
+			uid = *config.Uid
+		}
+		if config.Gid != nil {
+			gid = *config.Gid
+		}
+		mode = proptools.StringDefault(config.Mode, mode)
+		if uid < 0 || gid < 0 {
+			ctx.PropertyErrorf("canned_fs_config_entries", "Uid and Gid of %q must be non-negative", path)
+			continue
+		}
+		if m, err := strconv.ParseUint(mode, 8, 32); err != nil || m > 07777 {
+			ctx.PropertyErrorf("canned_fs_config_entries", "Mode of %q must be octal permission bits, got %q", path, mode)
+			continue
+		}
+		line := fmt.Sprintf("/%s %d %d %s", path, uid, gid, mode)
+		if config.Capabilities != nil {
+			caps := *config.Capabilities
+			if _, err := strconv.ParseUint(strings.TrimPrefix(caps, "0x"), 16, 64); err != nil || !strings.HasPrefix(caps, "0x") {
+				ctx.PropertyErrorf("canned_fs_config_entries", "Capabilities of %q must be a hexadecimal number starting with 0x, got %q", path, caps)
+				continue
+			}
+			line += " capabilities=" + caps
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2022 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"android/soong/android"
+)
+
+// The duplicate files report lists the files that are packaged with the same content into more than
+// one APEX, with the bytes they waste and the modules that provide them. Such files are candidates
+// for sharing through stubs or a sharedlibs APEX. It is built and dist'ed by the
+// apex-duplicate-files goal only, as it covers all the APEXes of the tree, and not only those
+// installed by the product. When APEX_DUPLICATE_FILES_SIZE_LIMIT is set, the build of the report
+// fails if a duplicated file is larger than that many bytes.
+
+func init() {
+	registerApexDuplicateFilesBuildComponents(android.InitRegistrationContext)
+}
+
+func registerApexDuplicateFilesBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("apex_duplicate_files", apexDuplicateFilesSingletonFactory)
+}
+
+type apexDuplicateFilesSingleton struct {
+	report android.WritablePath
+}
+
+func apexDuplicateFilesSingletonFactory() android.Singleton {
+	return &apexDuplicateFilesSingleton{}
+}
+
+func (s *apexDuplicateFilesSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var lines []string
+	var builtFiles android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		a, ok := module.(*apexBundle)
+		if !ok || !a.Enabled() || !a.installable() || a.testApex {
+			return
+		}
+		// Only image APEXes are compared. Variants created for override_apex modules package the
+		// same files as the APEX they override, and at most one of them is installed.
+		if a.properties.ApexType != imageApex || a.GetOverriddenBy() != "" {
+			return
+		}
+		apexName := ctx.ModuleName(module)
+		for _, fi := range a.filesInfo {
+			if fi.builtFile == nil {
+				continue
+			}
+			source := "-"
+			if fi.module != nil {
+				source = ctx.ModuleName(fi.module)
+			}
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s", apexName, fi.path(), source, fi.builtFile))
+			builtFiles = append(builtFiles, fi.builtFile)
+		}
+	})
+	if len(lines) == 0 {
+		return
+	}
+
+	list := android.PathForOutput(ctx, "apex", "duplicate_files", "files.txt")
+	android.WriteFileRule(ctx, list, strings.Join(lines, "\n"))
+
+	s.report = android.PathForOutput(ctx, "apex", "duplicate_files", "report.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().
+		BuiltTool("apex_duplicate_files").
+		FlagWithInput("--list ", list).
+		Implicits(android.FirstUniquePaths(builtFiles))
+	if limit := ctx.Config().Getenv("APEX_DUPLICATE_FILES_SIZE_LIMIT"); limit != "" {
+		if _, err := strconv.ParseUint(limit, 10, 64); err != nil {
+			ctx.Errorf("APEX_DUPLICATE_FILES_SIZE_LIMIT must be a number of bytes, got %q", limit)
+			return
+		}
+		cmd.FlagWithArg("--fail-above ", limit)
+	}
+	cmd.FlagWithOutput("-o ", s.report)
+	rule.Build("apex_duplicate_files", "find files duplicated across APEXes")
+
+	ctx.Phony("apex-duplicate-files", s.report)
+}
+
+func (s *apexDuplicateFilesSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if s.report != nil {
+		ctx.DistForGoal("apex-duplicate-files", s.report)
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*apexDuplicateFilesSingleton)(nil)
@@ -0,0 +1,143 @@
+// Copyright (C) 2022 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apex
+
+import (
+	"path/filepath"
+
+	"android/soong/android"
+	"android/soong/cc"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// The exported sdk of an APEX is the API surface of the APEX that other branches need to build
+// against it without its sources. It is made of two parts, which are dist'ed to
+// apex_exported_sdks/ by the apex_exported_sdks goal when the generate_exported_sdk property of
+// the APEX is set:
+//
+// * The snapshot of the <apex>-exported-sdk sdk module, which has the stubs and the headers of the
+//   native shared libraries of the APEX that have stubs, and the stubs and the API files of the
+//   java_sdk_library modules of the APEX, including those in its bootclasspath and
+//   systemserverclasspath fragments. The sdk module is created by the sdk package from the
+//   ExportedSdkMembers of the APEX, as the sdk package depends on this one.
+//
+// * <apex>-exported-sdk-abi-dumps.zip, which has the ABI dumps of the native shared libraries with
+//   stubs, built by the APEX as they are only created for the APEX variants of the libraries.
+
+const exportedSdkDistGoal = "apex_exported_sdks"
+
+// ExportedSdkName returns the name of the sdk module created for the exported sdk of an APEX.
+func ExportedSdkName(apexName string) string {
+	return apexName + "-exported-sdk"
+}
+
+// ExportedSdkMembers are the modules of an APEX that are searched for the members of its exported
+// sdk. Only the native shared libraries with stubs and the java_sdk_library modules among them,
+// and in the contents of the fragments, are added to the exported sdk.
+type ExportedSdkMembers struct {
+	// The native shared libraries of the APEX, for every architecture.
+	Native_shared_libs []string
+
+	// The java libraries of the APEX.
+	Java_libs []string
+
+	Bootclasspath_fragments         []string
+	Systemserverclasspath_fragments []string
+}
+
+// ApexWithExportedSdk is implemented by the APEXes that can have an exported sdk.
+type ApexWithExportedSdk interface {
+	android.Module
+
+	// ExportedSdkMembers returns the modules to search for the members of the exported sdk of the
+	// APEX, or nil if it doesn't have one. It is called after the defaults have been applied.
+	ExportedSdkMembers() *ExportedSdkMembers
+}
+
+var _ ApexWithExportedSdk = (*apexBundle)(nil)
+
+func (a *apexBundle) ExportedSdkMembers() *ExportedSdkMembers {
+	if !proptools.Bool(a.properties.Generate_exported_sdk) {
+		return nil
+	}
+
+	// The exported sdk has the libraries of every architecture, including those that are only in
+	// the APEX for some of them.
+	var nativeSharedLibs []string
+	for _, multilib := range []apexMultilibProperties{
+		a.properties.Multilib,
+		a.targetProperties.Target.Android.Multilib,
+	} {
+		for _, deps := range []ApexNativeDependencies{
+			multilib.First,
+			multilib.Both,
+			multilib.Prefer32,
+			multilib.Lib32,
+			multilib.Lib64,
+		} {
+			nativeSharedLibs = append(nativeSharedLibs, deps.Native_shared_libs...)
+		}
+	}
+	for _, deps := range []ApexNativeDependencies{
+		a.properties.ApexNativeDependencies,
+		a.archProperties.Arch.Arm.ApexNativeDependencies,
+		a.archProperties.Arch.Arm64.ApexNativeDependencies,
+		a.archProperties.Arch.X86.ApexNativeDependencies,
+		a.archProperties.Arch.X86_64.ApexNativeDependencies,
+	} {
+		nativeSharedLibs = append(nativeSharedLibs, deps.Native_shared_libs...)
+	}
+
+	return &ExportedSdkMembers{
+		Native_shared_libs:              android.FirstUniqueStrings(nativeSharedLibs),
+		Java_libs:                       android.RemoveListFromList(a.overridableProperties.Java_libs, a.overridableProperties.Remove_java_libs),
+		Bootclasspath_fragments:         a.overridableProperties.Bootclasspath_fragments,
+		Systemserverclasspath_fragments: a.overridableProperties.Systemserverclasspath_fragments,
+	}
+}
+
+// buildExportedSdkAbiDumps creates a build rule for the zip file with the ABI dumps of the native
+// shared libraries of this APEX that have stubs:
+//
+//	<lib>/<arch>/<lib>.so.lsdump
+func (a *apexBundle) buildExportedSdkAbiDumps(ctx android.ModuleContext) {
+	entries := make(map[string]android.Path)
+	for _, fi := range a.filesInfo {
+		if fi.class != nativeSharedLib || fi.transitiveDep {
+			continue
+		}
+		ccMod, ok := fi.module.(*cc.Module)
+		if !ok || !ccMod.HasStubsVariants() {
+			continue
+		}
+		if dump := ccMod.SAbiDumpFile(); dump.Valid() {
+			arch := ccMod.Target().Arch.ArchType.String()
+			entries[filepath.Join(ccMod.BaseModuleName(), arch, dump.Path().Base())] = dump.Path()
+		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	output := android.PathForModuleOut(ctx, ExportedSdkName(a.Name())+"-abi-dumps.zip")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("soong_zip").FlagWithOutput("-o ", output)
+	for _, dest := range android.SortedStringKeys(entries) {
+		cmd.FlagWithArg("-e ", dest).FlagWithInput("-f ", entries[dest])
+	}
+	rule.Build("exported_sdk_abi_dumps", "APEX exported sdk ABI dumps")
+	a.exportedSdkAbiDumpsFile = output
+}
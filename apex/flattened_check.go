@@ -0,0 +1,163 @@
+// Copyright (C) 2021 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apex
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// The flattened APEX consistency check compares the files installed by the flattened variant of
+// an APEX against the files packaged into the image variant of the same APEX. Both variants are
+// built from the same definition, but nothing else makes sure that they stay in sync; without
+// this check a flattened build can silently ship a different set of files (or files built for a
+// different variant) than the one that is tested as an image APEX.
+
+func init() {
+	registerFlattenedApexCheckBuildComponents(android.InitRegistrationContext)
+}
+
+func registerFlattenedApexCheckBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("apex_flattened_check", flattenedApexCheckSingletonFactory)
+}
+
+type flattenedApexCheckSingleton struct {
+	checkResult android.WritablePath
+}
+
+func flattenedApexCheckSingletonFactory() android.Singleton {
+	return &flattenedApexCheckSingleton{}
+}
+
+var (
+	// Fail the build if the given report is not empty.
+	flattenedApexCheckRule = pctx.AndroidStaticRule("flattenedApexCheckRule", blueprint.RuleParams{
+		Description: "Check flattened APEXes against image APEXes",
+		Command: `
+			if grep -q . ${in}; then
+				echo -e "\n******************************";
+				echo "ERROR: flattened APEXes differ from the image APEXes built from the same definition:";
+				cat ${in};
+				echo -e "******************************\n";
+				exit 1;
+			fi;
+			touch ${out};
+		`,
+	})
+)
+
+// apexContents maps the path of each file relative to the APEX root to a description of the
+// module variant that provides it. The description is empty for files that aren't provided by a
+// module (e.g. apex_manifest.pb), in which case only the presence of the file is compared.
+type apexContents map[string]string
+
+func collectApexContents(ctx android.SingletonContext, a *apexBundle) apexContents {
+	contents := make(apexContents)
+	for _, fi := range a.filesInfo {
+		source := ""
+		if fi.module != nil {
+			source = fmt.Sprintf("%s (%s)", ctx.ModuleName(fi.module), ctx.ModuleSubDir(fi.module))
+		}
+		contents[fi.path()] = source
+		for _, symlink := range fi.symlinkPaths() {
+			contents[symlink] = source
+		}
+	}
+	return contents
+}
+
+// flattenedApexDiscrepancies returns a human readable line for each difference between the
+// contents of the image and the flattened variants of the given APEX.
+func flattenedApexDiscrepancies(apexName string, image, flattened apexContents) []string {
+	var ret []string
+	for _, path := range android.SortedStringKeys(image) {
+		imageSource := image[path]
+		flattenedSource, ok := flattened[path]
+		if !ok {
+			ret = append(ret, fmt.Sprintf("%s: %s is missing from the flattened APEX", apexName, path))
+		} else if imageSource != "" && flattenedSource != "" && imageSource != flattenedSource {
+			ret = append(ret, fmt.Sprintf("%s: %s is built from %s in the image APEX but from %s in the flattened APEX",
+				apexName, path, imageSource, flattenedSource))
+		}
+	}
+	for _, path := range android.SortedStringKeys(flattened) {
+		if _, ok := image[path]; !ok {
+			ret = append(ret, fmt.Sprintf("%s: %s is missing from the image APEX", apexName, path))
+		}
+	}
+	return ret
+}
+
+func (s *flattenedApexCheckSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	// Only products that actually install flattened APEXes care about this.
+	if !ctx.Config().FlattenApex() && !ctx.Config().InstallExtraFlattenedApexes() {
+		return
+	}
+
+	imageApexes := make(map[string]*apexBundle)
+	flattenedApexes := make(map[string]*apexBundle)
+	ctx.VisitAllModules(func(module android.Module) {
+		a, ok := module.(*apexBundle)
+		if !ok || !a.installable() || a.testApex || a.vndkApex {
+			return
+		}
+		// Variants created for override_apex modules are reported under the name of the
+		// override_apex module.
+		apexName := a.GetOverriddenBy()
+		if apexName == "" {
+			apexName = ctx.ModuleName(module)
+		}
+		switch a.properties.ApexType {
+		case imageApex:
+			imageApexes[apexName] = a
+		case flattenedApex:
+			flattenedApexes[apexName] = a
+		}
+	})
+
+	var discrepancies []string
+	for _, apexName := range android.SortedStringKeys(flattenedApexes) {
+		image, ok := imageApexes[apexName]
+		if !ok {
+			continue
+		}
+		discrepancies = append(discrepancies, flattenedApexDiscrepancies(apexName,
+			collectApexContents(ctx, image), collectApexContents(ctx, flattenedApexes[apexName]))...)
+	}
+
+	report := android.PathForOutput(ctx, "apex", "flattened_check", "discrepancies.txt")
+	android.WriteFileRule(ctx, report, strings.Join(discrepancies, "\n"))
+
+	s.checkResult = android.PathForOutput(ctx, "apex", "flattened_check", "discrepancies.txt.check")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   flattenedApexCheckRule,
+		Input:  report,
+		Output: s.checkResult,
+	})
+
+	ctx.Phony("apex-flattened-check", s.checkResult)
+}
+
+func (s *flattenedApexCheckSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if s.checkResult == nil {
+		return
+	}
+	// Export check result to Make so that it can be added to droidcore.
+	ctx.Strict("APEX_FLATTENED_CHECK", s.checkResult.String())
+}
@@ -45,6 +45,10 @@ type apexKey struct {
 	publicKeyFile  android.Path
 	privateKeyFile android.Path
 
+	// The key pair that will replace the current one, if a key rotation is being prepared.
+	nextPublicKeyFile  android.Path
+	nextPrivateKeyFile android.Path
+
 	keyName string
 }
 
@@ -57,6 +61,14 @@ type apexKeyProperties struct {
 
 	// Whether this key is installable to one of the partitions. Defualt: true.
 	Installable *bool
+
+	// Path or module to the public key file in avbpubkey format of the key that will replace
+	// public_key. Only used to sign the additional APEXs built when
+	// APEX_KEY_ROTATION_DRY_RUN=true. Must be set together with next_private_key.
+	Next_public_key *string `android:"path"`
+	// Path or module to the private key file in pem format of the key that will replace
+	// private_key. Must be set together with next_public_key.
+	Next_private_key *string `android:"path"`
 }
 
 func ApexKeyFactory() android.Module {
@@ -72,27 +84,8 @@ func (m *apexKey) installable() bool {
 }
 
 func (m *apexKey) GenerateAndroidBuildActions(ctx android.ModuleContext) {
-	// If the keys are from other modules (i.e. :module syntax) respect it.
-	// Otherwise, try to locate the key files in the default cert dir or
-	// in the local module dir
-	if android.SrcIsModule(String(m.properties.Public_key)) != "" {
-		m.publicKeyFile = android.PathForModuleSrc(ctx, String(m.properties.Public_key))
-	} else {
-		m.publicKeyFile = ctx.Config().ApexKeyDir(ctx).Join(ctx, String(m.properties.Public_key))
-		// If not found, fall back to the local key pairs
-		if !android.ExistentPathForSource(ctx, m.publicKeyFile.String()).Valid() {
-			m.publicKeyFile = android.PathForModuleSrc(ctx, String(m.properties.Public_key))
-		}
-	}
-
-	if android.SrcIsModule(String(m.properties.Private_key)) != "" {
-		m.privateKeyFile = android.PathForModuleSrc(ctx, String(m.properties.Private_key))
-	} else {
-		m.privateKeyFile = ctx.Config().ApexKeyDir(ctx).Join(ctx, String(m.properties.Private_key))
-		if !android.ExistentPathForSource(ctx, m.privateKeyFile.String()).Valid() {
-			m.privateKeyFile = android.PathForModuleSrc(ctx, String(m.properties.Private_key))
-		}
-	}
+	m.publicKeyFile = m.keyFilePath(ctx, String(m.properties.Public_key))
+	m.privateKeyFile = m.keyFilePath(ctx, String(m.properties.Private_key))
 
 	pubKeyName := m.publicKeyFile.Base()[0 : len(m.publicKeyFile.Base())-len(m.publicKeyFile.Ext())]
 	privKeyName := m.privateKeyFile.Base()[0 : len(m.privateKeyFile.Base())-len(m.privateKeyFile.Ext())]
@@ -103,6 +96,36 @@ func (m *apexKey) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		return
 	}
 	m.keyName = pubKeyName
+
+	if (m.properties.Next_public_key == nil) != (m.properties.Next_private_key == nil) {
+		ctx.ModuleErrorf("next_public_key and next_private_key must be set together")
+		return
+	}
+	if m.properties.Next_public_key != nil {
+		m.nextPublicKeyFile = m.keyFilePath(ctx, String(m.properties.Next_public_key))
+		m.nextPrivateKeyFile = m.keyFilePath(ctx, String(m.properties.Next_private_key))
+		nextPubKeyName := strings.TrimSuffix(m.nextPublicKeyFile.Base(), m.nextPublicKeyFile.Ext())
+		nextPrivKeyName := strings.TrimSuffix(m.nextPrivateKeyFile.Base(), m.nextPrivateKeyFile.Ext())
+		if nextPubKeyName != nextPrivKeyName {
+			ctx.ModuleErrorf("next_public_key %q (keyname:%q) and next_private_key %q (keyname:%q) do not have same keyname",
+				m.nextPublicKeyFile.String(), nextPubKeyName, m.nextPrivateKeyFile, nextPrivKeyName)
+			return
+		}
+	}
+}
+
+// keyFilePath returns the path to a key file. If the key is from another module (i.e. :module
+// syntax) it is respected. Otherwise the key file is looked up in the default cert dir, falling
+// back to the local module dir.
+func (m *apexKey) keyFilePath(ctx android.ModuleContext, key string) android.Path {
+	if android.SrcIsModule(key) != "" {
+		return android.PathForModuleSrc(ctx, key)
+	}
+	path := ctx.Config().ApexKeyDir(ctx).Join(ctx, key)
+	if !android.ExistentPathForSource(ctx, path.String()).Valid() {
+		return android.PathForModuleSrc(ctx, key)
+	}
+	return path
 }
 
 ////////////////////////////////////////////////////////////////////////
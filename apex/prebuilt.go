@@ -39,6 +39,13 @@ var (
 			CommandDeps: []string{"${extract_apks}"},
 		},
 		"abis", "allow-prereleased", "sdk-version")
+
+	// Decompresses the apex extracted from an apex set so that the deapexer can unpack it. The apex
+	// set may contain either a compressed .capex or an .apex, which is copied as is.
+	decompressApex = pctx.StaticRule("decompressApex", blueprint.RuleParams{
+		Command:     `rm -rf $out && ${deapexer} decompress --copy-if-uncompressed --input ${in} --output ${out}`,
+		CommandDeps: []string{"${deapexer}"},
+	})
 )
 
 type prebuilt interface {
@@ -58,6 +65,9 @@ type prebuiltCommon struct {
 	installedFile   android.InstallPath
 	outputApex      android.WritablePath
 
+	// Whether the product selects another module to be installed for the apex_name of this one.
+	notSelected bool
+
 	// A list of apexFile objects created in prebuiltCommon.initApexFilesForAndroidMk which are used
 	// to create make modules in prebuiltCommon.AndroidMkEntries.
 	apexFilesForAndroidMk []apexFile
@@ -164,7 +174,7 @@ func (p *prebuiltCommon) Overrides() []string {
 }
 
 func (p *prebuiltCommon) installable() bool {
-	return proptools.BoolDefault(p.prebuiltCommonProperties.Installable, true)
+	return !p.notSelected && proptools.BoolDefault(p.prebuiltCommonProperties.Installable, true)
 }
 
 // initApexFilesForAndroidMk initializes the prebuiltCommon.apexFilesForAndroidMk field from the
@@ -768,6 +778,8 @@ func (p *Prebuilt) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		return
 	}
 
+	p.notSelected = !isSelectedApex(ctx, p.BaseModuleName(), p.ApexVariationName())
+
 	// Save the files that need to be made available to Make.
 	p.initApexFilesForAndroidMk(ctx)
 
@@ -796,6 +808,9 @@ type prebuiltApexExtractorModule struct {
 	properties ApexExtractorProperties
 
 	extractedApex android.WritablePath
+
+	// The extracted apex, decompressed if it is a .capex.
+	decompressedApex android.WritablePath
 }
 
 func privateApexExtractorModuleFactory() android.Module {
@@ -811,6 +826,19 @@ func (p *prebuiltApexExtractorModule) Srcs() android.Paths {
 	return android.Paths{p.extractedApex}
 }
 
+const decompressedApexTag = ".decompressed"
+
+func (p *prebuiltApexExtractorModule) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{p.extractedApex}, nil
+	case decompressedApexTag:
+		return android.Paths{p.decompressedApex}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
 func (p *prebuiltApexExtractorModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	srcsSupplier := func(ctx android.BaseModuleContext, prebuilt android.Module) []string {
 		return p.properties.prebuiltSrcs(ctx)
@@ -829,6 +857,15 @@ func (p *prebuiltApexExtractorModule) GenerateAndroidBuildActions(ctx android.Mo
 				"sdk-version":       ctx.Config().PlatformSdkVersion().String(),
 			},
 		})
+
+	p.decompressedApex = android.PathForModuleOut(ctx, "decompressed", apexSet.Base())
+	ctx.Build(pctx,
+		android.BuildParams{
+			Rule:        decompressApex,
+			Description: "Decompress the apex extracted from an apex set",
+			Input:       p.extractedApex,
+			Output:      p.decompressedApex,
+		})
 }
 
 type ApexSet struct {
@@ -939,8 +976,9 @@ func (a *ApexSet) createPrebuiltApexModules(ctx android.TopDownMutatorContext) {
 	apexExtractorModuleName := apexExtractorModuleName(baseModuleName)
 	createApexExtractorModule(ctx, apexExtractorModuleName, &a.properties.ApexExtractorProperties)
 
+	// The deapexer can only unpack an uncompressed apex, while the apex set may contain a .capex.
 	apexFileSource := ":" + apexExtractorModuleName
-	a.createDeapexerModuleIfNeeded(ctx, deapexerModuleName(baseModuleName), apexFileSource)
+	a.createDeapexerModuleIfNeeded(ctx, deapexerModuleName(baseModuleName), apexFileSource+"{"+decompressedApexTag+"}")
 
 	// After passing the arch specific src properties to the creating the apex selector module
 	a.prebuiltCommonProperties.Selected_apex = proptools.StringPtr(apexFileSource)
@@ -975,6 +1013,8 @@ func (a *ApexSet) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		return
 	}
 
+	a.notSelected = !isSelectedApex(ctx, a.BaseModuleName(), a.ApexVariationName())
+
 	// Save the files that need to be made available to Make.
 	a.initApexFilesForAndroidMk(ctx)
 
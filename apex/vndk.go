@@ -16,6 +16,7 @@ package apex
 
 import (
 	"strings"
+	"sync"
 
 	"android/soong/android"
 	"android/soong/cc"
@@ -58,6 +59,34 @@ type apexVndkProperties struct {
 	Vndk_version *string
 }
 
+// vndkApexes records the apex_vndk modules for each VNDK version, so that the VNDK libraries
+// can be added to the apex_vndk modules of their versions regardless of the module names.
+type vndkApexes struct {
+	sync.Mutex
+	byVersion map[string][]string
+}
+
+var vndkApexesKey = android.NewOnceKey("vndkApexes")
+
+func vndkApexesForConfig(config android.Config) *vndkApexes {
+	return config.Once(vndkApexesKey, func() interface{} {
+		return &vndkApexes{byVersion: make(map[string][]string)}
+	}).(*vndkApexes)
+}
+
+func (v *vndkApexes) add(vndkVersion, moduleName string) {
+	v.Lock()
+	defer v.Unlock()
+	v.byVersion[vndkVersion] = append(v.byVersion[vndkVersion], moduleName)
+}
+
+// get returns the sorted names of the apex_vndk modules for the given VNDK version.
+func (v *vndkApexes) get(vndkVersion string) []string {
+	v.Lock()
+	defer v.Unlock()
+	return android.SortedUniqueStrings(v.byVersion[vndkVersion])
+}
+
 func apexVndkMutator(mctx android.TopDownMutatorContext) {
 	if ab, ok := mctx.Module().(*apexBundle); ok && ab.vndkApex {
 		if ab.IsNativeBridgeSupported() {
@@ -67,6 +96,7 @@ func apexVndkMutator(mctx android.TopDownMutatorContext) {
 		vndkVersion := ab.vndkVersion(mctx.DeviceConfig())
 		// Ensure VNDK APEX mount point is formatted as com.android.vndk.v###
 		ab.properties.Apex_name = proptools.StringPtr(vndkApexNamePrefix + vndkVersion)
+		vndkApexesForConfig(mctx.Config()).add(vndkVersion, mctx.ModuleName())
 	}
 }
 
@@ -77,19 +107,17 @@ func apexVndkDepsMutator(mctx android.BottomUpMutatorContext) {
 		if vndkVersion == "" {
 			vndkVersion = mctx.DeviceConfig().PlatformVndkVersion()
 		}
+		// Several VNDK versions can be built in one product, each into its own apex_vndk module.
+		for _, vndkApex := range vndkApexesForConfig(mctx.Config()).get(vndkVersion) {
+			mctx.AddReverseDependency(mctx.Module(), sharedLibTag, vndkApex)
+		}
+	} else if a, ok := mctx.Module().(*apexBundle); ok && a.vndkApex {
+		// The libraries.txt files of the platform VNDK version are not versioned, even when the
+		// version is set explicitly.
+		vndkVersion := a.vndkVersion(mctx.DeviceConfig())
 		if vndkVersion == mctx.DeviceConfig().PlatformVndkVersion() {
 			vndkVersion = "current"
-		} else {
-			vndkVersion = "v" + vndkVersion
 		}
-
-		vndkApexName := "com.android.vndk." + vndkVersion
-
-		if mctx.OtherModuleExists(vndkApexName) {
-			mctx.AddReverseDependency(mctx.Module(), sharedLibTag, vndkApexName)
-		}
-	} else if a, ok := mctx.Module().(*apexBundle); ok && a.vndkApex {
-		vndkVersion := proptools.StringDefault(a.vndkProperties.Vndk_version, "current")
 		mctx.AddDependency(mctx.Module(), prebuiltTag, cc.VndkLibrariesTxtModules(vndkVersion)...)
 	}
 }
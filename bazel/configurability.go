@@ -28,6 +28,7 @@ const (
 
 	// OsType names in arch.go
 	osAndroid     = "android"
+	osBaremetal   = "baremetal"
 	osDarwin      = "darwin"
 	osLinux       = "linux_glibc"
 	osLinuxMusl   = "linux_musl"
@@ -39,6 +40,7 @@ const (
 	osArchAndroidArm64      = "android_arm64"
 	osArchAndroidX86        = "android_x86"
 	osArchAndroidX86_64     = "android_x86_64"
+	osArchBaremetalArm64    = "baremetal_arm64"
 	osArchDarwinArm64       = "darwin_arm64"
 	osArchDarwinX86_64      = "darwin_x86_64"
 	osArchLinuxX86          = "linux_glibc_x86"
@@ -84,6 +86,7 @@ var (
 	// constraint_value for the @platforms//os:os constraint_setting
 	platformOsMap = map[string]string{
 		osAndroid:                  "//build/bazel/platforms/os:android",
+		osBaremetal:                "//build/bazel/platforms/os:baremetal",
 		osDarwin:                   "//build/bazel/platforms/os:darwin",
 		osLinux:                    "//build/bazel/platforms/os:linux",
 		osLinuxMusl:                "//build/bazel/platforms/os:linux_musl",
@@ -97,6 +100,7 @@ var (
 		osArchAndroidArm64:         "//build/bazel/platforms/os_arch:android_arm64",
 		osArchAndroidX86:           "//build/bazel/platforms/os_arch:android_x86",
 		osArchAndroidX86_64:        "//build/bazel/platforms/os_arch:android_x86_64",
+		osArchBaremetalArm64:       "//build/bazel/platforms/os_arch:baremetal_arm64",
 		osArchDarwinArm64:          "//build/bazel/platforms/os_arch:darwin_arm64",
 		osArchDarwinX86_64:         "//build/bazel/platforms/os_arch:darwin_x86_64",
 		osArchLinuxX86:             "//build/bazel/platforms/os_arch:linux_glibc_x86",
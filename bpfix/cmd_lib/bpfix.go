@@ -38,6 +38,7 @@ var (
 	list   = flag.Bool("l", false, "list files whose formatting differs from bpfmt's")
 	write  = flag.Bool("w", false, "write result to (source) file instead of stdout")
 	doDiff = flag.Bool("d", false, "display diffs instead of rewriting files")
+	check  = flag.Bool("check", false, "display diffs and exit with an error if fixes are needed, ignoring formatting-only changes")
 )
 
 var (
@@ -74,6 +75,10 @@ func processFile(filename string, in io.Reader, out io.Writer, fixRequest bpfix.
 		return fmt.Errorf("%d parsing errors", len(errs))
 	}
 
+	if *check {
+		return checkFile(filename, src, file, out, fixRequest)
+	}
+
 	// compute and apply any requested fixes
 	fixer := bpfix.NewFixer(file)
 	file, err = fixer.Fix(fixRequest)
@@ -112,6 +117,33 @@ func processFile(filename string, in io.Reader, out io.Writer, fixRequest bpfix.
 	return err
 }
 
+// checkFile reports the fixes needed by the file, as a diff between the file formatted by bpfmt
+// and the fixed file, so that changes that are only reformatting aren't reported.
+func checkFile(filename string, src []byte, file *parser.File, out io.Writer, fixRequest bpfix.FixRequest) error {
+	formatted, err := parser.Print(file)
+	if err != nil {
+		return err
+	}
+	fixed, err := bpfix.NewFixer(file).Fix(fixRequest)
+	if err != nil {
+		return err
+	}
+	res, err := parser.Print(fixed)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(formatted, res) {
+		return nil
+	}
+	data, err := diff(formatted, res)
+	if err != nil {
+		return fmt.Errorf("computing diff: %s", err)
+	}
+	fmt.Fprintf(out, "diff %s bpfix/%s\n", filename, filename)
+	out.Write(data)
+	return fmt.Errorf("%s: deprecated patterns found, run \"bpfix -w %s\" to apply the changes above", filename, filename)
+}
+
 func makeFileVisitor(fixRequest bpfix.FixRequest) func(string, os.FileInfo, error) error {
 	return func(path string, f os.FileInfo, err error) error {
 		if err == nil && f.Name() == "Android.bp" {
@@ -68,3 +68,72 @@ func TestAfdoDeps(t *testing.T) {
 		t.Errorf("libTest missing dependency on afdo variant of libBar")
 	}
 }
+
+func TestAfdoProfileSelection(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libTest",
+		srcs: ["foo.c"],
+		afdo: true,
+	}
+
+	cc_library_shared {
+		name: "libExtra",
+		srcs: ["foo.c"],
+		afdo: true,
+	}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("toolchain/pgo-profiles/sampling/libTest.afdo", "TEST"),
+		android.FixtureAddTextFile("toolchain/pgo-profiles/sampling/libTest_arm64.afdo", "TEST"),
+		android.FixtureAddTextFile("vendor/profiles/libExtra.afdo", "TEST"),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.AfdoAdditionalProfileDirs = []string{"vendor/profiles"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	cFlags := func(module, variant string) string {
+		return result.ModuleForTests(module, variant).Rule("cc").Args["cFlags"]
+	}
+
+	// The profile for the arch is preferred over the generic one.
+	android.AssertStringDoesContain(t, "arm64 profile", cFlags("libTest", "android_arm64_armv8-a_shared"),
+		"-fprofile-sample-use=toolchain/pgo-profiles/sampling/libTest_arm64.afdo")
+	android.AssertStringDoesContain(t, "arm profile", cFlags("libTest", "android_arm_armv7-a-neon_shared"),
+		"-fprofile-sample-use=toolchain/pgo-profiles/sampling/libTest.afdo")
+
+	// Profiles are also looked up in the additional profile directories of the product.
+	android.AssertStringDoesContain(t, "additional profile dir", cFlags("libExtra", "android_arm64_armv8-a_shared"),
+		"-fprofile-sample-use=vendor/profiles/libExtra.afdo")
+}
+
+func TestAfdoWithoutProfile(t *testing.T) {
+	bp := `
+	cc_library_shared {
+		name: "libTest",
+		srcs: ["foo.c"],
+		static_libs: ["libFoo"],
+		afdo: true,
+	}
+
+	cc_library_static {
+		name: "libFoo",
+		srcs: ["foo.c"],
+	}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+	).RunTestWithBp(t, bp)
+
+	// Without a profile the module is built normally, and no afdo variants of its static
+	// dependencies are created.
+	libTest := result.ModuleForTests("libTest", "android_arm64_armv8-a_shared")
+	android.AssertStringDoesNotContain(t, "libTest cflags", libTest.Rule("cc").Args["cFlags"], "-fprofile-sample-use")
+
+	for _, variant := range result.ModuleVariantsForTests("libFoo") {
+		android.AssertStringDoesNotContain(t, "libFoo variants", variant, "afdo")
+	}
+}
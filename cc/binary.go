@@ -56,6 +56,10 @@ type BinaryLinkerProperties struct {
 
 	// Inject boringssl hash into the shared library.  This is only intended for use by external/boringssl.
 	Inject_bssl_hash *bool `android:"arch_variant"`
+
+	// Ownership, permissions and capabilities of the binary when it is installed in an APEX.
+	// They are added to the canned_fs_config of the APEX.
+	Apex_fs_config android.ApexFsConfig
 }
 
 func init() {
@@ -228,6 +232,11 @@ func (binary *binaryDecorator) linkerInit(ctx BaseModuleContext) {
 		}
 	}
 
+	if ctx.Os() == android.Baremetal {
+		// There is no dynamic linker for baremetal targets.
+		binary.Properties.Static_executable = BoolPtr(true)
+	}
+
 	if ctx.Darwin() || ctx.Windows() {
 		// Static executables are not supported on Darwin or Windows
 		binary.Properties.Static_executable = nil
@@ -357,6 +366,13 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 		transformDarwinUniversalBinary(ctx, fatOutputFile, outputFile, deps.DarwinSecondArchOutput.Path())
 	}
 
+	var implicitOutputs android.WritablePaths
+	var unusedDepsMap android.WritablePath
+	if binary.checkUnusedDeps(ctx) {
+		unusedDepsMap = binary.unusedDepsLinkerFlags(ctx, &flags, fileName)
+		implicitOutputs = append(implicitOutputs, unusedDepsMap)
+	}
+
 	builderFlags := flagsToBuilderFlags(flags)
 	stripFlags := flagsToStripFlags(flags)
 	if binary.stripper.NeedsStrip(ctx) {
@@ -427,13 +443,17 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 		linkerDeps = append(linkerDeps, ndkSharedLibDeps(ctx)...)
 	}
 
-	validations = append(validations, objs.tidyDepFiles...)
+	validations = append(validations, objs.validationFiles()...)
 	linkerDeps = append(linkerDeps, flags.LdFlagsDeps...)
 
+	if unusedDepsMap != nil {
+		validations = append(validations, binary.unusedDepsValidation(ctx, deps, outputFile, unusedDepsMap))
+	}
+
 	// Register link action.
 	transformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs, deps.StaticLibs,
 		deps.LateStaticLibs, deps.WholeStaticLibs, linkerDeps, deps.CrtBegin, deps.CrtEnd, true,
-		builderFlags, outputFile, nil, validations)
+		builderFlags, outputFile, implicitOutputs, validations)
 
 	objs.coverageFiles = append(objs.coverageFiles, deps.StaticLibObjs.coverageFiles...)
 	objs.coverageFiles = append(objs.coverageFiles, deps.WholeStaticLibObjs.coverageFiles...)
@@ -50,26 +50,65 @@ var (
 		},
 		"ccCmd", "cFlags")
 
-	// Rule to invoke gcc with given command and flags, but no dependencies.
-	ccNoDeps = pctx.AndroidStaticRule("ccNoDeps",
+	// Rules to assemble an assembly source with clang. Their depfiles also list the files
+	// included with the .include and .incbin directives, which the preprocessor doesn't see. Like
+	// cc, the compile runs through the compiler wrapper and can be executed remotely, only the
+	// directive scan runs locally.
+	ccAssemble = pctx.AndroidRemoteStaticRule("ccAssemble", android.RemoteRuleSupports{Goma: true, RBE: true},
 		blueprint.RuleParams{
-			Command:     "$relPwd $ccCmd -c $cFlags -o $out $in",
+			Depfile: "${out}.d",
+			Deps:    blueprint.DepsGCC,
+			Command: "$relPwd ${config.CcWrapper}$ccCmd -c $cFlags -o $out $in && " +
+				"$asmDepsCmd -o ${out}.d $out $in -- $cFlags",
+			CommandDeps: []string{"$ccCmd", "$asmDepsCmd"},
+		},
+		"ccCmd", "cFlags")
+
+	ccAssembleWithCpp = pctx.AndroidRemoteStaticRule("ccAssembleWithCpp", android.RemoteRuleSupports{Goma: true, RBE: true},
+		blueprint.RuleParams{
+			Depfile: "${out}.d",
+			Deps:    blueprint.DepsGCC,
+			Command: "$relPwd ${config.CcWrapper}$ccCmd -c $cFlags -MD -MF ${out}.cpp.d -o $out $in && " +
+				"$asmDepsCmd -o ${out}.d -merge ${out}.cpp.d $out $in -- $cFlags",
+			CommandDeps: []string{"$ccCmd", "$asmDepsCmd"},
+		},
+		"ccCmd", "cFlags")
+
+	// Rule to invoke clang to produce an annotated assembly listing of a source file instead of an
+	// object file. These are only generated for modules that set inspection_outputs or are listed
+	// in CC_INSPECTION_MODULES, and built when requested through the ".asm/<src>" output tag.
+	ccAsm = pctx.AndroidStaticRule("ccAsm",
+		blueprint.RuleParams{
+			Depfile:     "${out}.d",
+			Deps:        blueprint.DepsGCC,
+			Command:     "$relPwd $ccCmd -S $cFlags -fverbose-asm -fno-lto -MD -MF ${out}.d -o $out $in",
 			CommandDeps: []string{"$ccCmd"},
 		},
 		"ccCmd", "cFlags")
 
-	// Rules to invoke ld to link binaries. Uses a .rsp file to list dependencies, as there may
-	// be many.
-	ld, ldRE = pctx.RemoteStaticRules("ld",
+	// Rule to invoke clang to preprocess a source file. These are only generated like ccAsm, and
+	// built when requested through the ".i/<src>" output tag.
+	ccPreprocess = pctx.AndroidStaticRule("ccPreprocess",
 		blueprint.RuleParams{
-			Command: "$reTemplate$ldCmd ${crtBegin} @${out}.rsp " +
-				"${libFlags} ${crtEnd} -o ${out} ${ldFlags} ${extraLibFlags}",
-			CommandDeps:    []string{"$ldCmd"},
-			Rspfile:        "${out}.rsp",
-			RspfileContent: "${in}",
-			// clang -Wl,--out-implib doesn't update its output file if it hasn't changed.
-			Restat: true,
+			Depfile:     "${out}.d",
+			Deps:        blueprint.DepsGCC,
+			Command:     "$relPwd $ccCmd -E $cFlags -MD -MF ${out}.d -o $out $in",
+			CommandDeps: []string{"$ccCmd"},
 		},
+		"ccCmd", "cFlags")
+
+	// Rules to invoke ld to link binaries. Uses a .rsp file to list dependencies, as there may
+	// be many.
+	ldRuleParams = blueprint.RuleParams{
+		Command: "$reTemplate$ldCmd ${crtBegin} @${out}.rsp " +
+			"${libFlags} ${crtEnd} -o ${out} ${ldFlags} ${extraLibFlags}",
+		CommandDeps:    []string{"$ldCmd"},
+		Rspfile:        "${out}.rsp",
+		RspfileContent: "${in}",
+		// clang -Wl,--out-implib doesn't update its output file if it hasn't changed.
+		Restat: true,
+	}
+	ld, ldRE = pctx.RemoteStaticRules("ld", ldRuleParams,
 		&remoteexec.REParams{
 			Labels:          map[string]string{"type": "link", "tool": "clang"},
 			ExecStrategy:    "${config.RECXXLinksExecStrategy}",
@@ -80,6 +119,13 @@ var (
 			Platform:        map[string]string{remoteexec.PoolKey: "${config.RECXXLinksPool}"},
 		}, []string{"ldCmd", "crtBegin", "libFlags", "crtEnd", "ldFlags", "extraLibFlags"}, []string{"implicitInputs", "implicitOutputs"})
 
+	// Rules to invoke ld for LTO links. They run in pools that bound the number of parallel LTO
+	// links by the RAM of the build machine, see linkCost.
+	ldThinLto = pctx.AndroidStaticRule("ldThinLto", ldRuleParamsInPool(android.LtoLinkPool),
+		"ldCmd", "crtBegin", "libFlags", "crtEnd", "ldFlags", "extraLibFlags")
+	ldFullLto = pctx.AndroidStaticRule("ldFullLto", ldRuleParamsInPool(android.HighmemPool),
+		"ldCmd", "crtBegin", "libFlags", "crtEnd", "ldFlags", "extraLibFlags")
+
 	// Rules for .o files to combine to other .o files, using ld partial linking.
 	partialLd, partialLdRE = pctx.RemoteStaticRules("partialLd",
 		blueprint.RuleParams{
@@ -241,6 +287,43 @@ var (
 			Platform: map[string]string{remoteexec.PoolKey: "${config.REClangTidyPool}"},
 		}, []string{"cFlags", "tidyFlags", "tidyVars"}, []string{})
 
+	// Rule for invoking clang-tidy and filtering its findings through a baseline, writing them
+	// out to regenerate a baseline, or exporting its fixes to ${out}.yaml. clang-tidy is always
+	// run locally with this rule.
+	clangTidyBaseline = pctx.AndroidStaticRule("clangTidyBaseline",
+		blueprint.RuleParams{
+			Depfile: "${out}.d",
+			Deps:    blueprint.DepsGCC,
+			Command: "cp ${out}.dep ${out}.d && rm -f ${out}.yaml && " +
+				"($tidyVars${config.ClangBin}/clang-tidy $tidyFlags $in -- $cFlags > ${out}.log 2>&1; " +
+				"$tidyBaselineCmd $tidyBaselineFlags -status $$? ${out}.log) && " +
+				"rm -f ${out}.log && touch $out",
+			CommandDeps: []string{"${config.ClangBin}/clang-tidy", "$tidyBaselineCmd"},
+		},
+		"cFlags", "tidyFlags", "tidyVars", "tidyBaselineFlags")
+
+	// Rule for the .tidy file of a source in tidy_timeout_srcs that is skipped because
+	// TIDY_TIMEOUT is set, the file records why clang-tidy did not run on the source.
+	clangTidySkipped = pctx.AndroidStaticRule("clangTidySkipped",
+		blueprint.RuleParams{
+			Command: "echo \"$in: clang-tidy skipped, in tidy_timeout_srcs and TIDY_TIMEOUT is set\" > $out",
+		})
+
+	// Rule for collecting the findings of all the sources of a module into a baseline file.
+	tidyBaselineMerge = pctx.AndroidStaticRule("tidyBaselineMerge",
+		blueprint.RuleParams{
+			Command: "cat $in | sort -u > $out",
+		})
+
+	// Rule for merging the fixes exported by clang-tidy for all the sources of a module.
+	tidyFixesMerge = pctx.AndroidStaticRule("tidyFixesMerge",
+		blueprint.RuleParams{
+			Command:        "$mergeTidyFixesCmd -o $out -l $out.rsp",
+			CommandDeps:    []string{"$mergeTidyFixesCmd"},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+		})
+
 	_ = pctx.SourcePathVariable("yasmCmd", "prebuilts/misc/${config.HostPrebuiltTag}/yasm/yasm")
 
 	// Rule for invoking yasm to compile .asm assembly files.
@@ -306,6 +389,12 @@ var (
 		},
 		"extraFlags", "referenceDump", "libName", "arch", "createReferenceDumpFlags")
 
+	// Rule to write an updated reference abi dump from a linked sAbi dump file.
+	updateRefSAbiDump = pctx.AndroidStaticRule("updateRefSAbiDump",
+		blueprint.RuleParams{
+			Command: "${compress} $in > $out",
+		}, "compress")
+
 	// Rule to unzip a reference abi dump.
 	unzipRefSAbiDump = pctx.AndroidStaticRule("unzipRefSAbiDump",
 		blueprint.RuleParams{
@@ -359,6 +448,9 @@ func init() {
 	pctx.StaticVariable("relPwd", PwdPrefix())
 
 	pctx.HostBinToolVariable("SoongZipCmd", "soong_zip")
+	pctx.HostBinToolVariable("asmDepsCmd", "asm_deps")
+	pctx.HostBinToolVariable("tidyBaselineCmd", "tidy_baseline")
+	pctx.HostBinToolVariable("mergeTidyFixesCmd", "merge_tidy_fixes")
 }
 
 // builderFlags contains various types of command line flags (and settings) for use in building
@@ -395,6 +487,15 @@ type builderFlags struct {
 	rsFlags       string // Flags that apply to renderscript source files
 	toolchain     config.Toolchain
 
+	// clang-tidy findings that don't fail the build, see TidyProperties.Tidy_baseline.
+	tidyBaseline android.OptionalPath
+
+	// include-what-you-use settings, see IwyuProperties.
+	iwyu             bool
+	iwyuFlags        string
+	iwyuMappingFiles android.Paths
+	iwyuAsErrors     bool
+
 	// True if these extra features are enabled.
 	tidy          bool
 	needTidyFiles bool
@@ -402,7 +503,12 @@ type builderFlags struct {
 	sAbiDump      bool
 	emitXrefs     bool
 
-	assemblerWithCpp bool // True if .s files should be processed with the c preprocessor.
+	emitInspectionFiles bool // True if assembly listings and preprocessed sources should be generated.
+
+	assemblerWithCpp bool              // True if .s files should be processed with the c preprocessor.
+	perSrcAsFlags    map[string]string // Additional assembler flags of individual sources.
+
+	linkCost linkCost // Estimated memory cost of the link.
 
 	systemIncludeFlags string
 
@@ -434,6 +540,30 @@ type Objects struct {
 	coverageFiles android.Paths
 	sAbiDumpFiles android.Paths
 	kytheFiles    android.Paths
+
+	// The findings of the .tidy rules in the baseline format, when regenerating baselines.
+	tidyBaselineFiles android.Paths
+	// The fixes exported by the .tidy rules, when building with WITH_TIDY_EXPORT_FIXES=true.
+	tidyFixesFiles android.Paths
+
+	// The include-what-you-use reports, which are also link dependent.
+	iwyuFiles android.Paths
+
+	// The timestamps of the headers compiled on their own by verify_self_contained_headers, of
+	// the module or of the header libraries it depends on, which are validated by its link.
+	headerCheckFiles android.Paths
+
+	// Assembly listings and preprocessed sources of the C and C++ source files, used for the
+	// ".asm/<src>" and ".i/<src>" output tags.
+	inspectionFiles []inspectionFiles
+}
+
+// inspectionFiles are the outputs generated for inspecting how a single source file is compiled.
+type inspectionFiles struct {
+	// The source file, relative to its module directory or generated source directory.
+	src          string
+	asm          android.Path
+	preprocessed android.Path
 }
 
 func (a Objects) Copy() Objects {
@@ -444,6 +574,13 @@ func (a Objects) Copy() Objects {
 		coverageFiles: append(android.Paths{}, a.coverageFiles...),
 		sAbiDumpFiles: append(android.Paths{}, a.sAbiDumpFiles...),
 		kytheFiles:    append(android.Paths{}, a.kytheFiles...),
+
+		tidyBaselineFiles: append(android.Paths{}, a.tidyBaselineFiles...),
+		tidyFixesFiles:    append(android.Paths{}, a.tidyFixesFiles...),
+		iwyuFiles:         append(android.Paths{}, a.iwyuFiles...),
+		headerCheckFiles:  append(android.Paths{}, a.headerCheckFiles...),
+
+		inspectionFiles: append([]inspectionFiles{}, a.inspectionFiles...),
 	}
 }
 
@@ -455,6 +592,13 @@ func (a Objects) Append(b Objects) Objects {
 		coverageFiles: append(a.coverageFiles, b.coverageFiles...),
 		sAbiDumpFiles: append(a.sAbiDumpFiles, b.sAbiDumpFiles...),
 		kytheFiles:    append(a.kytheFiles, b.kytheFiles...),
+
+		tidyBaselineFiles: append(a.tidyBaselineFiles, b.tidyBaselineFiles...),
+		tidyFixesFiles:    append(a.tidyFixesFiles, b.tidyFixesFiles...),
+		iwyuFiles:         append(a.iwyuFiles, b.iwyuFiles...),
+		headerCheckFiles:  append(a.headerCheckFiles, b.headerCheckFiles...),
+
+		inspectionFiles: append(a.inspectionFiles, b.inspectionFiles...),
 	}
 }
 
@@ -464,8 +608,13 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 	// Source files are one-to-one with tidy, coverage, or kythe files, if enabled.
 	objFiles := make(android.Paths, len(srcFiles))
 	var tidyFiles android.Paths
+	var tidyBaselineFiles android.Paths
+	var tidyFixesFiles android.Paths
+	var iwyuFiles android.Paths
 	noTidySrcsMap := make(map[string]bool)
-	var tidyVars string
+	timeoutTidySrcsMap := make(map[string]bool)
+	var tidyVars, relaxedTidyVars string
+	skipTimeoutTidySrcs := false
 	if flags.tidy {
 		tidyFiles = make(android.Paths, 0, len(srcFiles))
 		for _, path := range noTidySrcs {
@@ -474,9 +623,15 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 		tidyTimeout := ctx.Config().Getenv("TIDY_TIMEOUT")
 		if len(tidyTimeout) > 0 {
 			tidyVars += "TIDY_TIMEOUT=" + tidyTimeout + " "
-			// add timeoutTidySrcs into noTidySrcsMap if TIDY_TIMEOUT is set
+			// timeoutTidySrcs are skipped if TIDY_TIMEOUT is set, unless TIDY_RELAXED_TIMEOUT
+			// gives them a longer time limit.
 			for _, path := range timeoutTidySrcs {
-				noTidySrcsMap[path.String()] = true
+				timeoutTidySrcsMap[path.String()] = true
+			}
+			if relaxedTimeout := ctx.Config().Getenv("TIDY_RELAXED_TIMEOUT"); len(relaxedTimeout) > 0 {
+				relaxedTidyVars = "TIDY_TIMEOUT=" + relaxedTimeout + " "
+			} else {
+				skipTimeoutTidySrcs = true
 			}
 		}
 	}
@@ -488,6 +643,7 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 	if flags.emitXrefs {
 		kytheFiles = make(android.Paths, 0, len(srcFiles))
 	}
+	var inspection []inspectionFiles
 
 	// Produce fully expanded flags for use by C tools, C compiles, C++ tools, C++ compiles, and asm compiles
 	// respectively.
@@ -552,8 +708,8 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 	// To simplify the code, the shared variables are all named as $flags<nnn>.
 	shared := ctx.getSharedFlags()
 
-	// Share flags only when there are multiple files or tidy rules.
-	var hasMultipleRules = len(srcFiles) > 1 || flags.tidy
+	// Share flags only when there are multiple files or tidy or iwyu rules.
+	var hasMultipleRules = len(srcFiles) > 1 || flags.tidy || flags.iwyu
 
 	var shareFlags = func(kind string, flags string) string {
 		if !hasMultipleRules || len(flags) < 60 {
@@ -599,24 +755,31 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 
 		var moduleFlags string
 		var moduleToolingFlags string
+		var preprocessedExt string
 
 		var ccCmd string
 		tidy := flags.tidy
+		iwyu := flags.iwyu
 		coverage := flags.gcovCoverage
 		dump := flags.sAbiDump
 		rule := cc
 		emitXref := flags.emitXrefs
 
 		switch srcFile.Ext() {
-		case ".s":
-			if !flags.assemblerWithCpp {
-				rule = ccNoDeps
-			}
-			fallthrough
-		case ".S":
+		case ".s", ".S":
 			ccCmd = "clang"
 			moduleFlags = asflags
+			if perSrc, ok := flags.perSrcAsFlags[srcFile.String()]; ok {
+				moduleFlags += " " + perSrc
+			}
+			if srcFile.Ext() == ".S" || flags.assemblerWithCpp ||
+				strings.Contains(moduleFlags, "-xassembler-with-cpp") {
+				rule = ccAssembleWithCpp
+			} else {
+				rule = ccAssemble
+			}
 			tidy = false
+			iwyu = false
 			coverage = false
 			dump = false
 			emitXref = false
@@ -624,10 +787,12 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 			ccCmd = "clang"
 			moduleFlags = cflags
 			moduleToolingFlags = toolingCflags
+			preprocessedExt = "i"
 		case ".cpp", ".cc", ".cxx", ".mm":
 			ccCmd = "clang++"
 			moduleFlags = cppflags
 			moduleToolingFlags = toolingCppflags
+			preprocessedExt = "ii"
 		case ".h", ".hpp":
 			ctx.PropertyErrorf("srcs", "Header file %s is not supported, instead use export_include_dirs or local_include_dirs.", srcFile)
 			continue
@@ -661,6 +826,44 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 			},
 		})
 
+		// Register the build statements for the assembly listing and preprocessed source if the
+		// module requests them. Nothing depends on them, so they are only built when requested.
+		if flags.emitInspectionFiles && preprocessedExt != "" {
+			asmFile := android.ObjPathWithExt(ctx, subdir, srcFile, "s")
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        ccAsm,
+				Description: ccDesc + " -S " + srcFile.Rel(),
+				Output:      asmFile,
+				Input:       srcFile,
+				Implicits:   cFlagsDeps,
+				OrderOnly:   pathDeps,
+				Args: map[string]string{
+					"cFlags": shareFlags("cFlags", moduleFlags),
+					"ccCmd":  ccCmd,
+				},
+			})
+
+			preprocessedFile := android.ObjPathWithExt(ctx, subdir, srcFile, preprocessedExt)
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        ccPreprocess,
+				Description: ccDesc + " -E " + srcFile.Rel(),
+				Output:      preprocessedFile,
+				Input:       srcFile,
+				Implicits:   cFlagsDeps,
+				OrderOnly:   pathDeps,
+				Args: map[string]string{
+					"cFlags": shareFlags("cFlags", moduleFlags),
+					"ccCmd":  ccCmd,
+				},
+			})
+
+			inspection = append(inspection, inspectionFiles{
+				src:          srcFile.Rel(),
+				asm:          asmFile,
+				preprocessed: preprocessedFile,
+			})
+		}
+
 		// Register post-process build statements (such as for tidy or kythe).
 		if emitXref {
 			kytheFile := android.ObjPathWithExt(ctx, subdir, srcFile, "kzip")
@@ -679,7 +882,16 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 		}
 
 		//  Even with tidy, some src file could be skipped by noTidySrcsMap.
-		if tidy && !noTidySrcsMap[srcFile.String()] {
+		if tidy && !noTidySrcsMap[srcFile.String()] && skipTimeoutTidySrcs && timeoutTidySrcsMap[srcFile.String()] {
+			tidyFile := android.ObjPathWithExt(ctx, subdir, srcFile, "tidy")
+			tidyFiles = append(tidyFiles, tidyFile)
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        clangTidySkipped,
+				Description: "clang-tidy skipped (TIDY_TIMEOUT) " + srcFile.Rel(),
+				Output:      tidyFile,
+				Input:       srcFile,
+			})
+		} else if tidy && !noTidySrcsMap[srcFile.String()] {
 			tidyFile := android.ObjPathWithExt(ctx, subdir, srcFile, "tidy")
 			tidyDepFile := android.ObjPathWithExt(ctx, subdir, srcFile, "tidy.dep")
 			tidyFiles = append(tidyFiles, tidyFile)
@@ -709,7 +921,7 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 				},
 			})
 			// Add the .tidy rule with order only dependency on the .tidy.d file
-			ctx.Build(pctx, android.BuildParams{
+			params := android.BuildParams{
 				Rule:        rule,
 				Description: "clang-tidy " + srcRelPath,
 				Output:      tidyFile,
@@ -721,6 +933,59 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 					"tidyFlags": shareFlags("tidyFlags", config.TidyFlagsForSrcFile(srcFile, flags.tidyFlags)),
 					"tidyVars":  tidyVars, // short and not shared
 				},
+			}
+			if timeoutTidySrcsMap[srcFile.String()] {
+				params.Args["tidyVars"] = relaxedTidyVars
+			}
+			updateTidyBaseline := ctx.Config().IsEnvTrue("TIDY_UPDATE_BASELINE")
+			exportTidyFixes := ctx.Config().IsEnvTrue("WITH_TIDY_EXPORT_FIXES")
+			if flags.tidyBaseline.Valid() || updateTidyBaseline || exportTidyFixes {
+				var baselineFlags []string
+				if flags.tidyBaseline.Valid() {
+					baselineFlags = append(baselineFlags, "-baseline "+flags.tidyBaseline.String())
+					params.Implicits = append(android.Paths{flags.tidyBaseline.Path()}, cFlagsDeps...)
+				}
+				if updateTidyBaseline {
+					tidyBaselineFile := android.ObjPathWithExt(ctx, subdir, srcFile, "tidy.baseline")
+					tidyBaselineFiles = append(tidyBaselineFiles, tidyBaselineFile)
+					baselineFlags = append(baselineFlags, "-update "+tidyBaselineFile.String())
+					params.ImplicitOutputs = append(params.ImplicitOutputs, tidyBaselineFile)
+				}
+				if exportTidyFixes {
+					// The rule removes the fixes of the previous run, as clang-tidy doesn't write
+					// the file when there is nothing to fix.
+					tidyFixesFile := android.ObjPathWithExt(ctx, subdir, srcFile, "tidy.yaml")
+					tidyFixesFiles = append(tidyFixesFiles, tidyFixesFile)
+					baselineFlags = append(baselineFlags, "-fixes "+tidyFixesFile.String())
+					params.Args["tidyFlags"] += " -export-fixes " + tidyFixesFile.String()
+					params.ImplicitOutputs = append(params.ImplicitOutputs, tidyFixesFile)
+				}
+				params.Rule = clangTidyBaseline
+				params.Args["tidyBaselineFlags"] = strings.Join(baselineFlags, " ")
+			}
+			ctx.Build(pctx, params)
+		}
+
+		if iwyu {
+			iwyuFile := android.ObjPathWithExt(ctx, subdir, srcFile, "iwyu")
+			iwyuFiles = append(iwyuFiles, iwyuFile)
+
+			iwyuAsErrors := ""
+			if flags.iwyuAsErrors {
+				iwyuAsErrors = "true"
+			}
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        includeWhatYouUse,
+				Description: "include-what-you-use " + srcFile.Rel(),
+				Output:      iwyuFile,
+				Input:       srcFile,
+				Implicits:   append(append(android.Paths{}, cFlagsDeps...), flags.iwyuMappingFiles...),
+				OrderOnly:   pathDeps,
+				Args: map[string]string{
+					"cFlags":       shareFlags("cFlags", moduleToolingFlags),
+					"iwyuFlags":    shareFlags("iwyuFlags", flags.iwyuFlags),
+					"iwyuAsErrors": iwyuAsErrors, // short and not shared
+				},
 			})
 		}
 
@@ -758,9 +1023,21 @@ func transformSourceToObj(ctx ModuleContext, subdir string, srcFiles, noTidySrcs
 		coverageFiles: coverageFiles,
 		sAbiDumpFiles: sAbiDumpFiles,
 		kytheFiles:    kytheFiles,
+
+		tidyBaselineFiles: tidyBaselineFiles,
+		tidyFixesFiles:    tidyFixesFiles,
+		iwyuFiles:         iwyuFiles,
+
+		inspectionFiles: inspection,
 	}
 }
 
+// validationFiles returns the outputs that the link of the objects has to validate.
+func (a Objects) validationFiles() android.Paths {
+	validations := append(append(android.Paths{}, a.tidyDepFiles...), a.iwyuFiles...)
+	return append(validations, a.headerCheckFiles...)
+}
+
 // Generate a rule for compiling multiple .o files to a static library (.a)
 func transformObjToStaticLib(ctx android.ModuleContext,
 	objFiles android.Paths, wholeStaticLibs android.Paths,
@@ -866,6 +1143,14 @@ func transformObjToDynamicBinary(ctx android.ModuleContext,
 		rule = ldRE
 		args["implicitOutputs"] = strings.Join(implicitOutputs.Strings(), ",")
 		args["implicitInputs"] = strings.Join(deps.Strings(), ",")
+	} else {
+		numInputs := len(objFiles) + len(staticLibs) + len(lateStaticLibs) + len(wholeStaticLibs)
+		switch estimateLinkCost(flags.linkCost, numInputs) {
+		case linkCostThinLto:
+			rule = ldThinLto
+		case linkCostFullLto:
+			rule = ldFullLto
+		}
 	}
 
 	ctx.Build(pctx, android.BuildParams{
@@ -881,6 +1166,14 @@ func transformObjToDynamicBinary(ctx android.ModuleContext,
 	})
 }
 
+// ldRuleParamsInPool returns the params of the local ld rule, running in pool.
+func ldRuleParamsInPool(pool blueprint.Pool) blueprint.RuleParams {
+	params := ldRuleParams
+	params.Command = strings.ReplaceAll(params.Command, "$reTemplate", "")
+	params.Pool = pool
+	return params
+}
+
 // Generate a rule to combine .dump sAbi dump files from multiple source files
 // into a single .ldump sAbi dump file
 func transformDumpToLinkedDump(ctx android.ModuleContext, sAbiDumps android.Paths, soFile android.Path,
@@ -996,6 +1289,29 @@ func sourceAbiDiff(ctx android.ModuleContext, inputDump android.Path, referenceD
 	return android.OptionalPathForPath(outputFile)
 }
 
+// Generate a rule for writing the updated reference dump of a library from its linked sAbi dump,
+// which replaces the sAbi diff when reference dumps are updated. The updated dump is written to the
+// output directory, and moved into the source tree by update_abi_references.
+func updateSourceAbiDump(ctx android.ModuleContext, inputDump android.Path,
+	referenceDump android.WritablePath) android.OptionalPath {
+
+	compress := "cat"
+	if strings.HasSuffix(referenceDump.Base(), ".gz") {
+		compress = "gzip -c -n"
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        updateRefSAbiDump,
+		Description: "update reference abi dump " + referenceDump.Base(),
+		Output:      referenceDump,
+		Input:       inputDump,
+		Args: map[string]string{
+			"compress": compress,
+		},
+	})
+	return android.OptionalPathForPath(referenceDump)
+}
+
 // Generate a rule for extracting a table of contents from a shared library (.so)
 func TransformSharedObjectToToc(ctx android.ModuleContext, inputFile android.Path, outputFile android.WritablePath) {
 
@@ -35,6 +35,14 @@ import (
 )
 
 func init() {
+	// Only allow the Windows variants of the host tools in the allowlist to be enabled.
+	android.AddNeverAllowRules(
+		android.NeverAllow().
+			WithOs(android.Windows).
+			With("enabled", "true").
+			WithMatcher("name", android.NotInList(config.WindowsHostToolModules)).
+			Because("only the host tools in cc/config.WindowsHostToolModules can be built for Windows"))
+
 	RegisterCCBuildComponents(android.InitRegistrationContext)
 
 	pctx.Import("android/soong/cc/config")
@@ -80,6 +88,8 @@ func RegisterCCBuildComponents(ctx android.RegistrationContext) {
 	})
 
 	ctx.RegisterSingletonType("kythe_extract_all", kytheExtractAllFactory)
+	ctx.RegisterSingletonType("thinlto_import_instr_limit", thinltoImportInstrLimitSingletonFactory)
+	ctx.RegisterSingletonType("tidy_dirs_config", tidyDirsConfigSingletonFactory)
 }
 
 // Deps is a struct containing module names of dependencies, separated by the kind of dependency.
@@ -125,6 +135,13 @@ type Deps struct {
 	ExcludeLibsForApex []string
 }
 
+// declaredLibDep is a library dependency along with the file that is used to check whether it
+// is used.
+type declaredLibDep struct {
+	name string
+	path android.Path
+}
+
 // PathDeps is a struct containing file paths to dependencies of a module.
 // It's constructed in depsToPath() by traversing the direct dependencies of the current module.
 // It's used to construct flags for various build statements (such as for compiling and linking).
@@ -138,6 +155,10 @@ type PathDeps struct {
 	// Paths to .a files
 	StaticLibs, LateStaticLibs, WholeStaticLibs android.Paths
 
+	// Direct shared (.so.toc files) and static (.a files) library dependencies by module name,
+	// used to check for unused dependencies
+	DeclaredSharedLibs, DeclaredStaticLibs []declaredLibDep
+
 	// Transitive static library dependencies of static libraries for use in ordering.
 	TranstiveStaticLibrariesForOrdering *android.DepSet
 
@@ -156,6 +177,9 @@ type PathDeps struct {
 	GeneratedSources android.Paths
 	GeneratedDeps    android.Paths
 
+	// Paths to the self-contained header checks of header libraries
+	HeaderChecks android.Paths
+
 	Flags                      []string
 	IncludeDirs                android.Paths
 	SystemIncludeDirs          android.Paths
@@ -206,6 +230,14 @@ type Flags struct {
 	TidyFlags     []string // Flags that apply to clang-tidy
 	SAbiFlags     []string // Flags that apply to header-abi-dumper
 
+	// clang-tidy findings that don't fail the build, see TidyProperties.Tidy_baseline.
+	TidyBaseline android.OptionalPath
+
+	Iwyu             bool          // True if ninja .iwyu rules should be generated.
+	IwyuFlags        []string      // Flags that apply to include-what-you-use
+	IwyuMappingFiles android.Paths // Files depended on by IwyuFlags
+	IwyuAsErrors     bool          // True if include-what-you-use suggestions fail the build
+
 	// Global include flags that apply to C, C++, and assembly source files
 	// These must be after any module include flags, which will be in CommonFlags.
 	SystemIncludeFlags []string
@@ -217,6 +249,10 @@ type Flags struct {
 	SAbiDump      bool // True if header abi dumps should be generated.
 	EmitXrefs     bool // If true, generate Ninja rules to generate emitXrefs input files for Kythe
 
+	// If true, generate Ninja rules for the assembly listings and preprocessed sources of the C
+	// and C++ source files.
+	EmitInspectionFiles bool
+
 	// The instruction set required for clang ("arm" or "thumb").
 	RequiredInstructionSet string
 	// The target-device system path to the dynamic linker.
@@ -228,6 +264,12 @@ type Flags struct {
 	// True if .s files should be processed with the c preprocessor.
 	AssemblerWithCpp bool
 
+	// Additional assembler flags of individual sources, keyed by the path of the source.
+	PerSrcAsFlags map[string][]string
+
+	// Estimated memory cost of linking the module, which selects the pool of the link rule.
+	LinkCost linkCost
+
 	proto            android.ProtoFlags
 	protoC           bool // Whether to use C instead of C++
 	protoOptionsFile bool // Whether to look for a .options file next to the .proto
@@ -241,6 +283,10 @@ type BaseProperties struct {
 	// Deprecated. true is the default, false is invalid.
 	Clang *bool `android:"arch_variant"`
 
+	// Whether this library is only used by tests. Such a library may set test_for, like
+	// cc_test_library.
+	Test_only *bool
+
 	// The API level that this module is built against. The APIs of this API level will be
 	// visible at build time, but use of any APIs newer than min_sdk_version will render the
 	// module unloadable on older devices.  In the future it will be possible to weakly-link new
@@ -359,11 +405,6 @@ type BaseProperties struct {
 	// framework module from the recovery snapshot.
 	Exclude_from_recovery_snapshot *bool
 
-	// List of APEXes that this module has private access to for testing purpose. The module
-	// can depend on libraries that are not exported by the APEXes and use private symbols
-	// from the exported libraries.
-	Test_for []string `android:"arch_variant"`
-
 	Target struct {
 		Platform struct {
 			// List of modules required by the core variant.
@@ -847,6 +888,12 @@ type Module struct {
 	objFiles android.Paths
 	// Tidy .tidy file output paths for this compilation module
 	tidyFiles android.Paths
+	// Fixes exported by clang-tidy for this compilation module, see tidyFixesSingleton
+	tidyFixesFiles android.Paths
+	// include-what-you-use .iwyu report paths for this compilation module
+	iwyuFiles android.Paths
+	// Assembly listings and preprocessed sources for the ".asm/<src>" and ".i/<src>" output tags
+	inspectionFiles []inspectionFiles
 
 	// For apex variants, this is set as apex.min_sdk_version
 	apexSdkVersion android.ApiLevel
@@ -1103,6 +1150,14 @@ func (c *Module) UnstrippedOutputFile() android.Path {
 	return nil
 }
 
+// SAbiDumpFile returns the linked source ABI dump of the library, if it has one.
+func (c *Module) SAbiDumpFile() android.OptionalPath {
+	if library, ok := c.linker.(*libraryDecorator); ok {
+		return library.sAbiOutputFile
+	}
+	return android.OptionalPath{}
+}
+
 func (c *Module) CoverageOutputFile() android.OptionalPath {
 	if c.linker != nil {
 		return c.linker.coverageOutputFilePath()
@@ -1160,11 +1215,11 @@ func (c *Module) Init() android.Module {
 		c.AddProperties(feature.props()...)
 	}
 
+	android.InitApexModule(c)
 	android.InitAndroidArchModule(c, c.hod, c.multilib)
 	if c.bazelable {
 		android.InitBazelModule(c)
 	}
-	android.InitApexModule(c)
 	android.InitSdkAwareModule(c)
 	android.InitDefaultableModule(c)
 
@@ -1192,7 +1247,7 @@ func (c *Module) isCoverageVariant() bool {
 }
 
 func (c *Module) IsNdk(config android.Config) bool {
-	return inList(c.BaseModuleName(), *getNDKKnownLibs(config))
+	return ndkKnownLibs.Contains(config, c.BaseModuleName())
 }
 
 func (c *Module) IsLlndk() bool {
@@ -1328,6 +1383,14 @@ func (c *Module) IsStubs() bool {
 	return false
 }
 
+// UsedThroughStubs implements android.ApexStubsDependency. Libraries with stubs are linked
+// through their stubs from other APEXes.
+func (c *Module) UsedThroughStubs(externalDep bool) bool {
+	return c.IsStubs() || (externalDep && c.HasStubsVariants())
+}
+
+var _ android.ApexStubsDependency = (*Module)(nil)
+
 func (c *Module) HasStubsVariants() bool {
 	if lib := c.library; lib != nil {
 		return lib.hasStubsVariants()
@@ -1654,6 +1717,7 @@ func newModule(hod android.HostOrDeviceSupported, multilib android.Multilib) *Mo
 	module := newBaseModule(hod, multilib)
 	module.features = []feature{
 		&tidyFeature{},
+		&iwyuFeature{},
 	}
 	module.stl = &stl{}
 	module.sanitize = &sanitize{}
@@ -1869,6 +1933,13 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 	for _, feature := range c.features {
 		flags = feature.flags(ctx, flags)
 	}
+	for _, experiment := range android.ToolchainExperiments(ctx) {
+		if len(experiment.Cflags) > 0 || len(experiment.Ldflags) > 0 {
+			flags.Local.CFlags = append(flags.Local.CFlags, experiment.Cflags...)
+			flags.Local.LdFlags = append(flags.Local.LdFlags, experiment.Ldflags...)
+			android.MarkToolchainExperimentApplied(ctx, experiment)
+		}
+	}
 	if ctx.Failed() {
 		return
 	}
@@ -1903,6 +1974,19 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		c.kytheFiles = objs.kytheFiles
 		c.objFiles = objs.objFiles
 		c.tidyFiles = objs.tidyFiles
+		c.tidyFixesFiles = objs.tidyFixesFiles
+		c.iwyuFiles = objs.iwyuFiles
+		buildTidyBaseline(ctx, objs.tidyBaselineFiles)
+		c.inspectionFiles = objs.inspectionFiles
+
+		// Allow `m <module>.s` to build the assembly listings of all the sources in the module.
+		if len(objs.inspectionFiles) > 0 {
+			var asmFiles android.Paths
+			for _, f := range objs.inspectionFiles {
+				asmFiles = append(asmFiles, f.asm)
+			}
+			ctx.Phony(ctx.ModuleName()+".s", asmFiles...)
+		}
 	}
 
 	if c.linker != nil {
@@ -1982,6 +2066,7 @@ func (c *Module) begin(ctx BaseModuleContext) {
 		c.stl.begin(ctx)
 	}
 	if c.sanitize != nil {
+		c.sanitize.checkIncompatibleProperties(ctx, c.lto)
 		c.sanitize.begin(ctx)
 	}
 	if c.coverage != nil {
@@ -2189,7 +2274,7 @@ func RewriteLibs(c LinkableInterface, snapshotInfo **SnapshotInfo, actx android.
 		name, _ := StubsLibNameAndVersion(entry)
 		if c.InRecovery() {
 			nonvariantLibs = append(nonvariantLibs, RewriteSnapshotLib(entry, GetSnapshot(c, snapshotInfo, actx).SharedLibs))
-		} else if c.UseSdk() && inList(name, *getNDKKnownLibs(config)) {
+		} else if c.UseSdk() && ndkKnownLibs.Contains(config, name) {
 			variantLibs = append(variantLibs, name+ndkLibrarySuffix)
 		} else if c.UseVndk() {
 			nonvariantLibs = append(nonvariantLibs, RewriteSnapshotLib(entry, GetSnapshot(c, snapshotInfo, actx).SharedLibs))
@@ -2262,11 +2347,9 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 	// sysprop_library, the C++ implementation library has to be linked. syspropImplLibraries is a
 	// map from sysprop_library to implementation library; it will be used in whole_static_libs,
 	// static_libs, and shared_libs.
-	syspropImplLibraries := syspropImplLibraries(actx.Config())
-
 	for _, lib := range deps.WholeStaticLibs {
 		depTag := libraryDependencyTag{Kind: staticLibraryDependency, wholeStatic: true, reexportFlags: true}
-		if impl, ok := syspropImplLibraries[lib]; ok {
+		if impl, ok := syspropImplLibraries.Get(actx.Config(), lib); ok {
 			lib = impl
 		}
 
@@ -2286,7 +2369,7 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 			depTag.excludeInApex = true
 		}
 
-		if impl, ok := syspropImplLibraries[lib]; ok {
+		if impl, ok := syspropImplLibraries.Get(actx.Config(), lib); ok {
 			lib = impl
 		}
 
@@ -2319,7 +2402,7 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 			depTag.excludeInApex = true
 		}
 
-		if impl, ok := syspropImplLibraries[lib]; ok {
+		if impl, ok := syspropImplLibraries.Get(actx.Config(), lib); ok {
 			lib = impl
 		}
 
@@ -2767,6 +2850,11 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 					break
 				}
 
+				implInfo := ctx.OtherModuleProvider(dep, SharedLibraryInfoProvider).(SharedLibraryInfo)
+				if sharedLibraryInfo.SharedLibrary == implInfo.SharedLibrary {
+					checkExportedTo(ctx, dep, depName)
+				}
+
 				linkFile = android.OptionalPathForPath(sharedLibraryInfo.SharedLibrary)
 				depFile = sharedLibraryInfo.TableOfContents
 
@@ -2779,6 +2867,10 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 					ptr = &depPaths.SharedLibs
 					depPtr = &depPaths.SharedLibsDeps
 					directSharedDeps = append(directSharedDeps, sharedLibraryInfo)
+					if depFile.Valid() {
+						depPaths.DeclaredSharedLibs = append(depPaths.DeclaredSharedLibs,
+							declaredLibDep{android.RemoveOptionalPrebuiltPrefix(depName), depFile.Path()})
+					}
 				case lateLibraryDependency:
 					ptr = &depPaths.LateSharedLibs
 					depPtr = &depPaths.LateSharedLibsDeps
@@ -2801,6 +2893,8 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 					break
 				}
 
+				checkExportedTo(ctx, dep, depName)
+
 				staticLibraryInfo := ctx.OtherModuleProvider(dep, StaticLibraryInfoProvider).(StaticLibraryInfo)
 				linkFile = android.OptionalPathForPath(staticLibraryInfo.StaticLibrary)
 				if libDepTag.wholeStatic {
@@ -2831,6 +2925,10 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 						// using transitive dependencies.
 						ptr = nil
 						directStaticDeps = append(directStaticDeps, staticLibraryInfo)
+						if linkFile.Valid() {
+							depPaths.DeclaredStaticLibs = append(depPaths.DeclaredStaticLibs,
+								declaredLibDep{android.RemoveOptionalPrebuiltPrefix(depName), linkFile.Path()})
+						}
 					case lateLibraryDependency:
 						ptr = &depPaths.LateStaticLibs
 					default:
@@ -2884,6 +2982,7 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 			depPaths.IncludeDirs = append(depPaths.IncludeDirs, depExporterInfo.IncludeDirs...)
 			depPaths.SystemIncludeDirs = append(depPaths.SystemIncludeDirs, depExporterInfo.SystemIncludeDirs...)
 			depPaths.GeneratedDeps = append(depPaths.GeneratedDeps, depExporterInfo.Deps...)
+			depPaths.HeaderChecks = append(depPaths.HeaderChecks, depExporterInfo.HeaderChecks...)
 			depPaths.Flags = append(depPaths.Flags, depExporterInfo.Flags...)
 
 			if libDepTag.reexportFlags {
@@ -2963,6 +3062,7 @@ func (c *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 	depPaths.IncludeDirs = android.FirstUniquePaths(depPaths.IncludeDirs)
 	depPaths.SystemIncludeDirs = android.FirstUniquePaths(depPaths.SystemIncludeDirs)
 	depPaths.GeneratedDeps = android.FirstUniquePaths(depPaths.GeneratedDeps)
+	depPaths.HeaderChecks = android.FirstUniquePaths(depPaths.HeaderChecks)
 	depPaths.ReexportedDirs = android.FirstUniquePaths(depPaths.ReexportedDirs)
 	depPaths.ReexportedSystemDirs = android.FirstUniquePaths(depPaths.ReexportedSystemDirs)
 	depPaths.ReexportedFlags = android.FirstUniqueStrings(depPaths.ReexportedFlags)
@@ -3070,6 +3170,30 @@ func ChooseStubOrImpl(ctx android.ModuleContext, dep android.Module) (SharedLibr
 	return sharedLibraryInfo, depExporterInfo
 }
 
+// checkExportedTo reports an error if the module links against the implementation of a library
+// whose exported_to property doesn't list the module.
+func checkExportedTo(ctx android.ModuleContext, dep android.Module, depName string) {
+	lib := moduleLibraryInterface(dep)
+	if lib == nil || lib.buildStubs() || len(lib.exportedTo()) == 0 {
+		return
+	}
+	name := android.RemoveOptionalPrebuiltPrefix(ctx.ModuleName())
+	if android.InList(name, lib.exportedTo()) {
+		return
+	}
+
+	stubs := ctx.OtherModuleProvider(dep, SharedLibraryStubsProvider).(SharedLibraryStubsInfo).SharedStubLibraries
+	if len(stubs) > 0 {
+		ctx.ModuleErrorf("links against the implementation of %q, which is only exported to %q. "+
+			"Link against its stubs instead with shared_libs: [\"%s#%s\"], or add %q to its exported_to property",
+			depName, lib.exportedTo(), depName, stubs[len(stubs)-1].Version, name)
+	} else {
+		ctx.ModuleErrorf("links against %q, which is only exported to %q. "+
+			"Add %q to its exported_to property",
+			depName, lib.exportedTo(), name)
+	}
+}
+
 // orderStaticModuleDeps rearranges the order of the static library dependencies of the module
 // to match the topological order of the dependency tree, including any static analogues of
 // direct shared libraries.  It returns the ordered static dependencies, and an android.DepSet
@@ -3203,11 +3327,47 @@ func (c *Module) OutputFiles(tag string) (android.Paths, error) {
 			return android.Paths{c.outputFile.Path()}, nil
 		}
 		return android.Paths{}, nil
+	case ".standalone_test_package":
+		if test, ok := c.installer.(*testBinary); ok && test.standaloneTestPackage != nil {
+			return android.Paths{test.standaloneTestPackage}, nil
+		}
+		return nil, fmt.Errorf("%q is not a cc_test with standalone_test_package set", c.Name())
+	case ".unstripped":
+		if path := c.UnstrippedOutputFile(); path != nil {
+			return android.Paths{path}, nil
+		}
+		return nil, fmt.Errorf("%q has no unstripped output file", c.Name())
 	default:
+		if path, ok := c.inspectionOutputFile(tag); ok {
+			return android.Paths{path}, nil
+		}
 		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
 	}
 }
 
+// inspectionOutputFile returns the assembly listing for a ".asm/<src>" tag or the preprocessed
+// source for a ".i/<src>" tag, where <src> is a C or C++ source file of the module.
+func (c *Module) inspectionOutputFile(tag string) (android.Path, bool) {
+	var src string
+	var asm bool
+	if strings.HasPrefix(tag, ".asm/") {
+		src, asm = strings.TrimPrefix(tag, ".asm/"), true
+	} else if strings.HasPrefix(tag, ".i/") {
+		src = strings.TrimPrefix(tag, ".i/")
+	} else {
+		return nil, false
+	}
+	for _, f := range c.inspectionFiles {
+		if f.src == src {
+			if asm {
+				return f.asm, true
+			}
+			return f.preprocessed, true
+		}
+	}
+	return nil, false
+}
+
 func (c *Module) static() bool {
 	if static, ok := c.linker.(interface {
 		static() bool
@@ -3347,10 +3507,24 @@ func (c *Module) AvailableFor(what string) bool {
 	}
 }
 
-func (c *Module) TestFor() []string {
-	return c.Properties.Test_for
+// Overrides android.ApexModuleBase.IsTestModule
+func (c *Module) IsTestModule() bool {
+	// testBinary is also true for test libraries. Other libraries are only accepted when they
+	// are marked with test_only.
+	return c.testBinary() || c.benchmarkBinary() ||
+		(c.CcLibraryInterface() && Bool(c.Properties.Test_only))
 }
 
+// ApexFsConfig implements android.ApexFsConfigProvider.
+func (c *Module) ApexFsConfig() *android.ApexFsConfig {
+	if binary, ok := c.linker.(*binaryDecorator); ok && binary.Properties.Apex_fs_config.IsSet() {
+		return &binary.Properties.Apex_fs_config
+	}
+	return nil
+}
+
+var _ android.ApexFsConfigProvider = (*Module)(nil)
+
 func (c *Module) EverInstallable() bool {
 	return c.installer != nil &&
 		// Check to see whether the module is actually ever installable.
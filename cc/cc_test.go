@@ -779,6 +779,61 @@ func TestDataLibsRelativeInstallPath(t *testing.T) {
 	}
 }
 
+func TestStandaloneTestPackage(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+		}
+
+		cc_test_library {
+			name: "test_lib",
+			srcs: ["test_lib.cpp"],
+			gtest: false,
+		}
+
+		cc_test {
+			name: "main_test",
+			srcs: ["main_test.cpp"],
+			shared_libs: ["libfoo"],
+			data: ["data/a.txt"],
+			data_libs: ["test_lib"],
+			gtest: false,
+			standalone_test_package: true,
+		}
+	`
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("data/a.txt", nil),
+	).RunTestWithBp(t, bp)
+
+	module := result.ModuleForTests("main_test", "android_arm_armv7-a-neon")
+	pkg := module.Output("standalone_test_package/main_test-android-arm.zip")
+	cmd := pkg.RuleParams.Command
+	android.AssertStringDoesContain(t, "binary", cmd,
+		"-j -P main_test -f out/soong/.intermediates/main_test/android_arm_armv7-a-neon/main_test")
+	android.AssertStringDoesContain(t, "test config", cmd,
+		"-f out/soong/.intermediates/main_test/android_arm_armv7-a-neon/main_test.config")
+	android.AssertStringDoesContain(t, "shared libs", cmd,
+		"-P main_test/lib -f out/soong/.intermediates/libfoo/android_arm_armv7-a-neon_shared/libfoo.so")
+	android.AssertStringDoesContain(t, "data", cmd, "-P main_test -C . -f data/a.txt")
+	android.AssertStringDoesContain(t, "data libs", cmd,
+		"-P main_test -C out/soong/.intermediates/test_lib/android_arm_armv7-a-neon_shared "+
+			"-f out/soong/.intermediates/test_lib/android_arm_armv7-a-neon_shared/test_lib.so")
+	android.AssertStringDoesNotContain(t, "data libs in lib", cmd,
+		"-f out/soong/.intermediates/test_lib/android_arm_armv7-a-neon_shared/test_lib.so -P main_test/lib")
+	// The system shared libraries are provided by the device.
+	for _, lib := range []string{"libc.so", "libm.so", "libdl.so"} {
+		android.AssertStringDoesNotContain(t, "system shared libs", cmd, "/"+lib)
+	}
+
+	outputFiles, err := module.Module().(android.OutputFileProducer).OutputFiles(".standalone_test_package")
+	android.AssertSame(t, "error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "standalone test package",
+		[]string{"out/soong/.intermediates/main_test/android_arm_armv7-a-neon/standalone_test_package/main_test-android-arm.zip"},
+		outputFiles)
+}
+
 func TestTestBinaryTestSuites(t *testing.T) {
 	bp := `
 		cc_test {
@@ -2681,6 +2736,120 @@ func TestStaticLibDepReorderingWithShared(t *testing.T) {
 	}
 }
 
+func TestCheckUnusedDeps(t *testing.T) {
+	ctx := testCc(t, `
+	cc_library_shared {
+		name: "libfoo",
+		srcs: ["foo.c"],
+		shared_libs: ["libbar"],
+		static_libs: ["libbaz"],
+		check_unused_deps: true,
+	}
+	cc_library_shared {
+		name: "libbar",
+		srcs: ["bar.c"],
+	}
+	cc_library_static {
+		name: "libbaz",
+		srcs: ["baz.c"],
+	}
+	`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	link := libfoo.Rule("ld")
+	check := libfoo.Output("unused_deps/unused_deps.txt")
+	mapFile := "out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/unused_deps/libfoo.so.map"
+
+	android.AssertStringDoesContain(t, "ldflags", android.StringRelativeToTop(ctx.Config(), link.Args["ldFlags"]), "-Wl,-Map="+mapFile)
+	android.AssertStringDoesContain(t, "ldflags", link.Args["ldFlags"], "-Wl,--no-allow-shlib-undefined")
+	android.AssertPathsRelativeToTopEquals(t, "link implicit outputs", []string{mapFile}, link.ImplicitOutputs.Paths())
+	android.AssertStringListContains(t, "link validations", link.Validations.RelativeToTop().Strings(),
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/unused_deps/unused_deps.txt")
+
+	android.AssertPathRelativeToTopEquals(t, "checked input",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/unstripped/libfoo.so", check.Input)
+	android.AssertStringEquals(t, "lib flags",
+		"--shared-lib libbar=out/soong/.intermediates/libbar/android_arm64_armv8-a_shared/libbar.so.toc "+
+			"--static-lib libbaz=out/soong/.intermediates/libbaz/android_arm64_armv8-a_static/libbaz.a",
+		android.StringRelativeToTop(ctx.Config(), check.Args["libFlags"]))
+
+	libbar := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_shared")
+	if rule := libbar.MaybeOutput("unused_deps/unused_deps.txt").Rule; rule != nil {
+		t.Errorf("expected no unused deps check for libbar, got %q", rule)
+	}
+}
+
+func TestDlopenSymbols(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("libfoo.dlopen_symbols.txt", "foo_open\n"),
+	).RunTestWithBp(t, `
+	cc_library_shared {
+		name: "libfoo",
+		srcs: ["foo.c"],
+		dlopen_symbols_file: "libfoo.dlopen_symbols.txt",
+	}
+	cc_library_shared {
+		name: "libbar",
+		srcs: ["bar.c"],
+	}
+	`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	link := libfoo.Rule("ld")
+	check := libfoo.Output("dlopen_symbols/dlopen_symbols.txt")
+
+	android.AssertStringListContains(t, "link validations", link.Validations.RelativeToTop().Strings(),
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/dlopen_symbols/dlopen_symbols.txt")
+	android.AssertPathRelativeToTopEquals(t, "checked input",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.toc", check.Input)
+	android.AssertPathsRelativeToTopEquals(t, "checked symbols",
+		[]string{"libfoo.dlopen_symbols.txt"}, check.Implicits)
+	android.AssertStringEquals(t, "symbols arg", "libfoo.dlopen_symbols.txt", check.Args["symbols"])
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared")
+	if rule := libbar.MaybeOutput("dlopen_symbols/dlopen_symbols.txt").Rule; rule != nil {
+		t.Errorf("expected no dlopen symbols check for libbar, got %q", rule)
+	}
+}
+
+func TestBaremetal(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureModifyConfig(func(config android.Config) {
+			config.Targets[android.Baremetal] = []android.Target{
+				{Os: android.Baremetal, Arch: android.Arch{ArchType: android.Arm64}, NativeBridge: android.NativeBridgeDisabled},
+			}
+		}),
+	).RunTestWithBp(t, `
+	cc_binary {
+		name: "firmware",
+		srcs: ["foo.c"],
+		target: {
+			baremetal: {
+				enabled: true,
+			},
+		},
+	}
+	cc_binary {
+		name: "bin",
+		srcs: ["foo.c"],
+	}
+	`)
+
+	firmware := result.ModuleForTests("firmware", "baremetal_arm64")
+	android.AssertStringDoesContain(t, "cflags", firmware.Rule("cc").Args["cFlags"], "-target aarch64-none-elf ")
+	android.AssertStringDoesContain(t, "cflags", firmware.Rule("cc").Args["cFlags"], "${config.BaremetalCflags}")
+	ldFlags := firmware.Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "ldflags", ldFlags, "-static")
+	android.AssertStringDoesNotContain(t, "ldflags", ldFlags, "-lpthread")
+	android.AssertStringEquals(t, "crtbegin", "", firmware.Rule("ld").Args["crtBegin"])
+	android.AssertBoolEquals(t, "firmware hidden from make", true, firmware.Module().IsHideFromMake())
+
+	bin := result.ModuleForTests("bin", "baremetal_arm64")
+	android.AssertBoolEquals(t, "bin baremetal variant enabled", false, bin.Module().Enabled())
+}
+
 func checkEquals(t *testing.T, message string, expected, actual interface{}) {
 	t.Helper()
 	if !reflect.DeepEqual(actual, expected) {
@@ -3358,6 +3527,55 @@ func TestFuzzTarget(t *testing.T) {
 func TestAidl(t *testing.T) {
 }
 
+func TestFuzzPackaging(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("cc_fuzz_packaging", fuzzPackagingFactory)
+		}),
+		android.FixtureAddTextFile("corpus/seed1", ""),
+		android.FixtureAddTextFile("corpus/seed2", ""),
+		android.FixtureAddTextFile("fuzz.dict", ""),
+	).RunTestWithBp(t, `
+		cc_fuzz {
+			name: "fuzz_pkg",
+			srcs: ["foo.c"],
+			corpus: ["corpus/*"],
+			dictionary: "fuzz.dict",
+			fuzz_config: {
+				cc: ["someone@example.com"],
+			},
+		}
+
+		cc_fuzz {
+			name: "fuzz_not_on_haiku",
+			srcs: ["foo.c"],
+			fuzz_config: {
+				fuzz_on_haiku_device: false,
+			},
+		}
+	`)
+
+	packaging := result.SingletonForTests("cc_fuzz_packaging")
+
+	// Each fuzz target is zipped with its corpus, dictionary and config.
+	targetZip := packaging.Output("out/soong/.intermediates/fuzz/target/arm64/fuzz_pkg.zip")
+	command := targetZip.RuleParams.Command
+	android.AssertStringDoesContain(t, "corpus should be zipped", command, "fuzz_pkg_seed_corpus.zip")
+	android.AssertStringListContains(t, "corpus should contain the seeds",
+		targetZip.Implicits.RelativeToTop().Strings(), "corpus/seed2")
+	android.AssertStringDoesContain(t, "dictionary should be packaged", command, "-f fuzz.dict")
+	android.AssertStringDoesContain(t, "config should be packaged",
+		command, "fuzz_pkg/android_arm64_armv8-a_fuzzer/config/config.json")
+
+	// The targets of an architecture are aggregated into the package that is dist'ed for haiku.
+	pkg := packaging.Output("out/soong/fuzz-target-arm64.zip")
+	android.AssertStringListContains(t, "package should contain the fuzz target",
+		pkg.Implicits.RelativeToTop().Strings(), "out/soong/.intermediates/fuzz/target/arm64/fuzz_pkg.zip")
+	android.AssertStringListDoesNotContain(t, "package should skip targets not fuzzed on haiku",
+		pkg.Implicits.RelativeToTop().Strings(), "out/soong/.intermediates/fuzz/target/arm64/fuzz_not_on_haiku.zip")
+}
+
 func assertString(t *testing.T, got, expected string) {
 	t.Helper()
 	if got != expected {
@@ -4196,3 +4414,675 @@ func TestIncludeDirectoryOrdering(t *testing.T) {
 	}
 
 }
+
+func TestInspectionOutputFiles(t *testing.T) {
+	ctx := prepareForCcTest.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.cpp", "bar.c", "baz.S"],
+			inspection_outputs: true,
+		}
+	`).TestContext
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+
+	asm := libfoo.Output("obj/foo.s")
+	android.AssertStringDoesContain(t, "asm rule", asm.Rule.String(), "ccAsm")
+	android.AssertStringEquals(t, "asm cFlags", libfoo.Output("obj/foo.o").Args["cFlags"], asm.Args["cFlags"])
+	libfoo.Output("obj/foo.ii")
+	libfoo.Output("obj/bar.s")
+	libfoo.Output("obj/bar.i")
+
+	if p := libfoo.MaybeOutput("obj/baz.s"); p.Rule != nil {
+		t.Errorf("expected no assembly listing for an assembly source, got %q", p.Output)
+	}
+
+	module := libfoo.Module().(android.OutputFileProducer)
+	for tag, expected := range map[string]string{
+		".asm/foo.cpp": "obj/foo.s",
+		".i/foo.cpp":   "obj/foo.ii",
+		".asm/bar.c":   "obj/bar.s",
+		".i/bar.c":     "obj/bar.i",
+	} {
+		outputFiles, err := module.OutputFiles(tag)
+		if err != nil {
+			t.Errorf("unexpected error for tag %q: %s", tag, err)
+			continue
+		}
+		if len(outputFiles) != 1 || !strings.HasSuffix(outputFiles[0].String(), "/"+expected) {
+			t.Errorf("expected %q for tag %q, got %q", expected, tag, outputFiles)
+		}
+	}
+
+	if _, err := module.OutputFiles(".asm/baz.S"); err == nil {
+		t.Errorf("expected an error for the assembly listing of an assembly source")
+	}
+}
+
+func TestInspectionOutputFilesOnRequest(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["bar.cpp"],
+		}
+	`
+
+	ctx := prepareForCcTest.RunTestWithBp(t, bp).TestContext
+	for _, module := range []string{"libfoo", "libbar"} {
+		m := ctx.ModuleForTests(module, "android_arm64_armv8-a_static")
+		for _, rule := range m.AllOutputs() {
+			if strings.HasSuffix(rule, ".s") || strings.HasSuffix(rule, ".ii") {
+				t.Errorf("expected no inspection files for %s by default, got %q", module, rule)
+			}
+		}
+	}
+
+	ctx = android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureMergeEnv(map[string]string{
+			"CC_INSPECTION_MODULES": "libother,libfoo",
+		}),
+	).RunTestWithBp(t, bp).TestContext
+	ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Output("obj/foo.s")
+	ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Output("obj/foo.ii")
+	if p := ctx.ModuleForTests("libbar", "android_arm64_armv8-a_static").MaybeOutput("obj/bar.s"); p.Rule != nil {
+		t.Errorf("expected no assembly listing for libbar, got %q", p.Output)
+	}
+}
+
+func TestWindowsHostToolAllowlist(t *testing.T) {
+	bp := `
+		cc_binary_host {
+			name: "adb",
+			srcs: ["foo.c"],
+			stl: "none",
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+		}
+
+		cc_binary_host {
+			name: "not_a_windows_tool",
+			srcs: ["foo.c"],
+			stl: "none",
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+		}
+	`
+
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		PrepareForTestOnWindows,
+		android.PrepareForTestWithNeverallowRules(nil),
+		android.FixtureModifyConfig(func(config android.Config) {
+			config.Targets[android.Windows] = []android.Target{
+				{android.Windows, android.Arch{ArchType: android.X86_64}, android.NativeBridgeDisabled, "", "", true},
+			}
+		}),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`"not_a_windows_tool" variant "windows_x86_64": violates neverallow requirements`)).
+		RunTestWithBp(t, bp)
+
+	// The modules in the allowlist can enable their Windows variants.
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		PrepareForTestOnWindows,
+		android.PrepareForTestWithNeverallowRules(nil),
+		android.FixtureModifyConfig(func(config android.Config) {
+			config.Targets[android.Windows] = []android.Target{
+				{android.Windows, android.Arch{ArchType: android.X86_64}, android.NativeBridgeDisabled, "", "", true},
+			}
+		}),
+	).RunTestWithBp(t, `
+		cc_binary_host {
+			name: "adb",
+			srcs: ["foo.c"],
+			stl: "none",
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+		}
+	`)
+	android.AssertBoolEquals(t, "adb windows variant enabled", true,
+		result.ModuleForTests("adb", "windows_x86_64").Module().Enabled())
+}
+
+func TestExportedTo(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			exported_to: ["liballowed"],
+		}
+
+		cc_library {
+			name: "libfoo_with_stubs",
+			srcs: ["foo.c"],
+			stubs: {
+				versions: ["29"],
+			},
+			exported_to: ["liballowed"],
+		}
+
+		cc_library {
+			name: "liballowed",
+			srcs: ["foo.c"],
+			shared_libs: ["libfoo", "libfoo_with_stubs"],
+		}
+
+		cc_library {
+			name: "libstubs_user",
+			srcs: ["foo.c"],
+			shared_libs: ["libfoo_with_stubs#29"],
+		}
+	`
+
+	t.Run("allowed users", func(t *testing.T) {
+		prepareForCcTest.RunTestWithBp(t, bp)
+	})
+
+	t.Run("static", func(t *testing.T) {
+		prepareForCcTest.
+			ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				`links against "libfoo", which is only exported to \["liballowed"\]. Add "libbar" to its exported_to property`)).
+			RunTestWithBp(t, bp+`
+				cc_library {
+					name: "libbar",
+					srcs: ["foo.c"],
+					static_libs: ["libfoo"],
+				}
+			`)
+	})
+
+	t.Run("shared with stubs", func(t *testing.T) {
+		prepareForCcTest.
+			ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				`links against the implementation of "libfoo_with_stubs", which is only exported to \["liballowed"\]. `+
+					`Link against its stubs instead with shared_libs: \["libfoo_with_stubs#current"\]`)).
+			RunTestWithBp(t, bp+`
+				cc_library {
+					name: "libbar",
+					srcs: ["foo.c"],
+					shared_libs: ["libfoo_with_stubs"],
+				}
+			`)
+	})
+}
+
+func TestAssemblerFlags(t *testing.T) {
+	ctx := prepareForCcTest.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.s", "bar.S", "baz.c"],
+			asflags: ["-DALL"],
+			per_src_asflags: [
+				{
+					srcs: ["foo.s"],
+					asflags: ["-DFOO"],
+				},
+			],
+			use_integrated_as: false,
+		}
+	`)
+
+	libfoo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+
+	foo := libfoo.Output("obj/foo.o")
+	android.AssertStringEquals(t, "foo.s rule", "android/soong/cc.ccAssemble", foo.Rule.String())
+	android.AssertStringDoesContain(t, "foo.s flags", foo.Args["cFlags"], "-DALL")
+	android.AssertStringDoesContain(t, "foo.s flags", foo.Args["cFlags"], "-DFOO")
+	android.AssertStringDoesContain(t, "foo.s flags", foo.Args["cFlags"], "-fno-integrated-as")
+
+	bar := libfoo.Output("obj/bar.o")
+	android.AssertStringEquals(t, "bar.S rule", "android/soong/cc.ccAssembleWithCpp", bar.Rule.String())
+	android.AssertStringDoesNotContain(t, "bar.S flags", bar.Args["cFlags"], "-DFOO")
+	android.AssertStringDoesContain(t, "bar.S flags", bar.Args["cFlags"], "-fno-integrated-as")
+
+	baz := libfoo.Output("obj/baz.o")
+	android.AssertStringDoesNotContain(t, "baz.c flags", baz.Args["cFlags"], "-fno-integrated-as")
+}
+
+func TestAssemblerFlagsErrors(t *testing.T) {
+	prepareForCcTest.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`per_src_asflags: "baz.c" is not a .s or .S source in srcs`)).
+		RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.s", "baz.c"],
+			per_src_asflags: [
+				{
+					srcs: ["baz.c"],
+					asflags: ["-DFOO"],
+				},
+			],
+		}
+	`)
+
+	prepareForCcTest.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`use_integrated_as: false has no effect without .s or .S sources`)).
+		RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["baz.c"],
+			use_integrated_as: false,
+		}
+	`)
+}
+
+func TestAssemblerFlagsDeprecatedIntegratedAs(t *testing.T) {
+	// Selecting the assembler through asflags is deprecated in favor of use_integrated_as, but
+	// still works for the existing modules.
+	ctx := prepareForCcTest.RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.s"],
+			asflags: ["-fno-integrated-as"],
+		}
+	`)
+	foo := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Output("obj/foo.o")
+	android.AssertStringDoesContain(t, "foo.s flags", foo.Args["cFlags"], "-fno-integrated-as")
+}
+
+func TestUseIntegratedAsWithGeneratedAssembly(t *testing.T) {
+	// use_integrated_as applies to the assembly sources generated by genrules too.
+	ctx := prepareForCcTest.RunTestWithBp(t, `
+		genrule {
+			name: "gen_asm",
+			cmd: "touch $(out)",
+			out: ["gen.S"],
+		}
+
+		cc_library_static {
+			name: "libfoo",
+			generated_sources: ["gen_asm"],
+			use_integrated_as: false,
+		}
+	`)
+	gen := ctx.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Output("obj/.intermediates/gen_asm/gen/gen.o")
+	android.AssertStringDoesContain(t, "gen.S flags", gen.Args["cFlags"], "-fno-integrated-as")
+}
+
+func TestTidyBaseline(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			tidy: true,
+			tidy_baseline: "tidy_baseline.txt",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("tidy_baseline.txt", ""),
+	).RunTestWithBp(t, bp)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	tidy := libfoo.Output("obj/foo.tidy")
+	android.AssertStringEquals(t, "tidy rule", "android/soong/cc.clangTidyBaseline", tidy.Rule.String())
+	android.AssertStringEquals(t, "tidy baseline flags", "-baseline tidy_baseline.txt", tidy.Args["tidyBaselineFlags"])
+	android.AssertStringListContains(t, "tidy implicits", tidy.Implicits.Strings(), "tidy_baseline.txt")
+
+	result = android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("tidy_baseline.txt", ""),
+		android.FixtureMergeEnv(map[string]string{
+			"TIDY_UPDATE_BASELINE": "true",
+		}),
+	).RunTestWithBp(t, bp)
+
+	libfoo = result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	tidy = libfoo.Output("obj/foo.tidy")
+	android.AssertStringDoesContain(t, "tidy baseline flags", tidy.Args["tidyBaselineFlags"], "-update ")
+	baselineFile := libfoo.Output("obj/foo.tidy.baseline")
+	baseline := libfoo.Output("tidy_baseline.txt")
+	android.AssertStringListContains(t, "module tidy baseline inputs", baseline.Inputs.Strings(),
+		baselineFile.Output.String())
+}
+
+func TestTidyExportFixes(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("tidy_fixes", tidyFixesSingletonFactory)
+		}),
+		android.FixtureMergeEnv(map[string]string{
+			"WITH_TIDY_EXPORT_FIXES": "true",
+		}),
+	).RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c", "bar.c"],
+			tidy: true,
+		}
+	`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	tidy := libfoo.Output("obj/foo.tidy")
+	android.AssertStringEquals(t, "tidy rule", "android/soong/cc.clangTidyBaseline", tidy.Rule.String())
+	fixes := libfoo.Output("obj/foo.tidy.yaml")
+	android.AssertStringDoesContain(t, "tidy flags", tidy.Args["tidyFlags"], "-export-fixes "+fixes.Output.String())
+	android.AssertStringDoesContain(t, "tidy baseline flags", tidy.Args["tidyBaselineFlags"], "-fixes "+fixes.Output.String())
+
+	merge := result.SingletonForTests("tidy_fixes").Output("tidy_fixes/libfoo.yaml")
+	android.AssertStringListContains(t, "merged tidy fixes inputs", merge.Inputs.Strings(), fixes.Output.String())
+	android.AssertStringListContains(t, "merged tidy fixes inputs", merge.Inputs.Strings(),
+		libfoo.Output("obj/bar.tidy.yaml").Output.String())
+}
+
+func TestTidyTimeoutSrcs(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c", "slow.c"],
+			tidy: true,
+			tidy_timeout_srcs: ["slow.c"],
+		}
+	`
+
+	run := func(env map[string]string) android.TestingModule {
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureMergeEnv(env),
+		).RunTestWithBp(t, bp)
+		return result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	}
+
+	// Without TIDY_TIMEOUT all the sources are checked without time limit.
+	libfoo := run(nil)
+	for _, tidyFile := range []string{"obj/foo.tidy", "obj/slow.tidy"} {
+		tidy := libfoo.Output(tidyFile)
+		android.AssertStringEquals(t, tidyFile+" rule", "android/soong/cc.clangTidy", tidy.Rule.String())
+		android.AssertStringEquals(t, tidyFile+" vars", "", tidy.Args["tidyVars"])
+	}
+
+	// With TIDY_TIMEOUT the timeout sources are skipped, with a note in their .tidy file.
+	libfoo = run(map[string]string{"TIDY_TIMEOUT": "90"})
+	android.AssertStringEquals(t, "foo.tidy vars", "TIDY_TIMEOUT=90 ", libfoo.Output("obj/foo.tidy").Args["tidyVars"])
+	slow := libfoo.Output("obj/slow.tidy")
+	android.AssertStringEquals(t, "slow.tidy rule", "android/soong/cc.clangTidySkipped", slow.Rule.String())
+	android.AssertStringDoesContain(t, "slow.tidy description", slow.Description, "TIDY_TIMEOUT")
+
+	// With TIDY_RELAXED_TIMEOUT the timeout sources are checked with the relaxed time limit.
+	libfoo = run(map[string]string{"TIDY_TIMEOUT": "90", "TIDY_RELAXED_TIMEOUT": "1200"})
+	android.AssertStringEquals(t, "foo.tidy vars", "TIDY_TIMEOUT=90 ", libfoo.Output("obj/foo.tidy").Args["tidyVars"])
+	slow = libfoo.Output("obj/slow.tidy")
+	android.AssertStringEquals(t, "slow.tidy rule", "android/soong/cc.clangTidy", slow.Rule.String())
+	android.AssertStringEquals(t, "slow.tidy vars", "TIDY_TIMEOUT=1200 ", slow.Args["tidyVars"])
+}
+
+func TestWithTidyPaths(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "lib%s",
+			srcs: ["foo.c"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("foo/Android.bp", fmt.Sprintf(bp, "foo")),
+		android.FixtureAddTextFile("foo/bar/Android.bp", fmt.Sprintf(bp, "foobar")),
+		android.FixtureAddTextFile("foobar/Android.bp", fmt.Sprintf(bp, "bar")),
+		android.FixtureAddFile("foo/foo.c", nil),
+		android.FixtureAddFile("foo/bar/foo.c", nil),
+		android.FixtureAddFile("foobar/foo.c", nil),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ClangTidy = BoolPtr(true)
+		}),
+		android.FixtureMergeEnv(map[string]string{
+			"WITH_TIDY_PATHS": "vendor/a, foo/",
+		}),
+	).RunTest(t)
+
+	for name, withTidy := range map[string]bool{
+		"libfoo":    true,
+		"libfoobar": true,
+		"libbar":    false,
+	} {
+		tidy := result.ModuleForTests(name, "android_arm64_armv8-a_static").Output("obj/foo.tidy")
+		// WITH_TIDY runs clang-tidy without -quiet on the modules it applies to.
+		android.AssertBoolEquals(t, name+" with tidy", withTidy,
+			!strings.Contains(tidy.Args["tidyFlags"], "-quiet"))
+	}
+}
+
+func TestTidyDirsConfig(t *testing.T) {
+	bp := `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			tidy: true,
+			tidy_checks: ["cert-*"],
+			tidy_flags: ["-extra-arg=-DFOO"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("vendor/foo/bar/Android.bp", bp),
+		android.FixtureAddTextFile("vendor/foo/bar/foo.c", ""),
+		android.FixtureAddTextFile("tidy_dirs.json", `[
+			{"path_prefix": "vendor/foo", "tidy_checks": ["misc-*"]},
+			{"path_prefix": "vendor/foo/bar/", "tidy_checks": ["-*", "bugprone-*"], "tidy_flags": ["-extra-arg=-DBAR"]}
+		]`),
+		android.FixtureAddTextFile("vendor/tidy_dirs.json", `[
+			{"path_prefix": "vendor/foo", "tidy_checks": ["performance-*"]}
+		]`),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.TidyDirsConfigFiles = []string{"tidy_dirs.json", "vendor/tidy_dirs.json"}
+		}),
+	).RunTest(t)
+
+	tidy := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static").Output("obj/foo.tidy")
+	tidyFlags := tidy.Args["tidyFlags"]
+	// The most specific path prefix wins, its checks come after the default checks of the
+	// directory and before the local tidy_checks.
+	android.AssertStringDoesContain(t, "tidy checks", tidyFlags,
+		"-checks=${config.TidyExternalVendorChecks},-*,bugprone-*,-misc-no-recursion,"+
+			"-readability-function-cognitive-complexity,cert-*,")
+	// The directory flags come before the local tidy_flags.
+	android.AssertStringDoesContain(t, "tidy flags", tidyFlags, "-extra-arg=-DBAR -extra-arg=-DFOO")
+}
+
+func TestTidyDirsConfigMissing(t *testing.T) {
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.TidyDirsConfigFiles = []string{"missing.json"}
+		}),
+	).
+		ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+			`tidy_dirs config "missing.json" does not exist`,
+		})).
+		RunTestWithBp(t, `
+			cc_library_static {
+				name: "libfoo",
+				srcs: ["foo.c"],
+				tidy: true,
+			}
+
+			cc_library_static {
+				name: "libbar",
+				srcs: ["foo.c"],
+				tidy: true,
+			}
+		`)
+}
+
+func TestIwyu(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("iwyu_report", iwyuReportSingletonFactory)
+		}),
+		android.FixtureAddTextFile("iwyu.imp", ""),
+	).RunTestWithBp(t, `
+		cc_library_static {
+			name: "libfoo",
+			srcs: ["foo.cpp", "bar.s"],
+			iwyu: true,
+			iwyu_flags: ["-Xiwyu", "--no_fwd_decls"],
+			iwyu_mapping_files: ["iwyu.imp"],
+			iwyu_as_errors: true,
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["foo.cpp"],
+		}
+	`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_static")
+	iwyu := libfoo.Output("obj/foo.iwyu")
+	android.AssertStringEquals(t, "iwyu as errors", "true", iwyu.Args["iwyuAsErrors"])
+	android.AssertStringDoesContain(t, "iwyu flags", iwyu.Args["iwyuFlags"],
+		"-Xiwyu --no_fwd_decls -Xiwyu --mapping_file=iwyu.imp")
+	android.AssertStringListContains(t, "iwyu implicits", iwyu.Implicits.Strings(), "iwyu.imp")
+	android.AssertStringListContains(t, "libfoo.a validations",
+		libfoo.Output("libfoo.a").Validations.Strings(), iwyu.Output.String())
+	if libfoo.MaybeOutput("obj/bar.iwyu").Rule != nil {
+		t.Errorf("expected no include-what-you-use rule for an assembly source")
+	}
+
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_static")
+	if libbar.MaybeOutput("obj/foo.iwyu").Rule != nil {
+		t.Errorf("expected no include-what-you-use rule without iwyu: true or WITH_IWYU=true")
+	}
+
+	report := result.SingletonForTests("iwyu_report").Output("iwyu/report.txt")
+	android.AssertStringListContains(t, "iwyu report inputs", report.Inputs.Strings(), iwyu.Output.String())
+}
+
+func TestNativeCoverageReport(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["foo.cpp"],
+			native_coverage: false,
+		}
+	`
+	covVariant := "android_arm64_armv8-a_shared_cov"
+
+	t.Run("clang", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+				ctx.RegisterSingletonType("native_coverage_report", nativeCoverageReportSingletonFactory)
+			}),
+			android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.ClangCoverage = BoolPtr(true)
+				variables.Native_coverage = BoolPtr(true)
+				variables.NativeCoveragePaths = []string{"*"}
+			}),
+			android.FixtureMergeEnv(map[string]string{
+				"NATIVE_COVERAGE_DATA": "/tmp/coverage",
+				"NATIVE_COVERAGE_HTML": "true",
+			}),
+		).RunTestWithBp(t, bp)
+
+		report := result.SingletonForTests("native_coverage_report").Rule("native_coverage_report")
+		android.AssertStringDoesContain(t, "report command", report.RuleParams.Command, "--mode clang")
+		android.AssertStringDoesContain(t, "report command", report.RuleParams.Command, "--data-dir /tmp/coverage")
+		html := result.SingletonForTests("native_coverage_report").Output("coverage/native_coverage_html.zip")
+		android.AssertStringEquals(t, "html report rule", report.RuleParams.Command, html.RuleParams.Command)
+
+		libfoo := result.ModuleForTests("libfoo", covVariant).Module().(*Module)
+		android.AssertStringListContains(t, "report inputs", report.Implicits.Strings(),
+			libfoo.UnstrippedOutputFile().String())
+		libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared").Module().(*Module)
+		android.AssertStringListDoesNotContain(t, "report inputs", report.Implicits.Strings(),
+			libbar.UnstrippedOutputFile().String())
+	})
+
+	t.Run("gcov", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForCcTest,
+			android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+				ctx.RegisterSingletonType("native_coverage_report", nativeCoverageReportSingletonFactory)
+			}),
+			android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+				variables.GcovCoverage = BoolPtr(true)
+				variables.Native_coverage = BoolPtr(true)
+				variables.NativeCoveragePaths = []string{"*"}
+			}),
+		).RunTestWithBp(t, bp)
+
+		report := result.SingletonForTests("native_coverage_report").Rule("native_coverage_report")
+		android.AssertStringDoesContain(t, "report command", report.RuleParams.Command, "--mode gcov")
+		android.AssertStringDoesNotContain(t, "report command", report.RuleParams.Command, "--data-dir")
+		android.AssertStringDoesNotContain(t, "report command", report.RuleParams.Command, "--html")
+
+		libfoo := result.ModuleForTests("libfoo", covVariant).Module().(*Module)
+		android.AssertStringListContains(t, "report inputs", report.Implicits.Strings(),
+			libfoo.CoverageOutputFile().String())
+	})
+}
+
+func TestCppModules(t *testing.T) {
+	result := prepareForCcTest.RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.cppm", "foo.util.cppm", "foo.cpp"],
+			cpp_modules: true,
+		}
+		cc_binary {
+			name: "bin",
+			srcs: ["main.cpp"],
+			shared_libs: ["libfoo"],
+			cpp_modules: true,
+		}
+	`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	foo := libfoo.Output("obj/cpp_modules/foo.pcm")
+	util := libfoo.Output("obj/cpp_modules/foo.util.pcm")
+	fooFlag := "-fmodule-file=foo=" + foo.Output.String()
+	utilFlag := "-fmodule-file=foo.util=" + util.Output.String()
+	android.AssertStringDoesContain(t, "interface unit cflags", foo.Args["cFlags"], "-std=gnu++2a")
+	android.AssertStringDoesNotContain(t, "first interface unit cflags", foo.Args["cFlags"], utilFlag)
+	android.AssertStringDoesContain(t, "second interface unit cflags", util.Args["cFlags"], fooFlag)
+	android.AssertStringListContains(t, "second interface unit implicits",
+		util.Implicits.Strings(), foo.Output.String())
+
+	android.AssertStringEquals(t, "interface object input", foo.Output.String(),
+		libfoo.Output("obj/cpp_modules/foo.o").Input.String())
+	impl := libfoo.Output("obj/foo.o")
+	android.AssertStringDoesContain(t, "implementation cflags", impl.Args["cFlags"], fooFlag)
+	android.AssertStringDoesContain(t, "implementation cflags", impl.Args["cFlags"], utilFlag)
+	android.AssertStringDoesContain(t, "implementation cflags", impl.Args["cFlags"], "-fmodule-file-deps")
+	objs := libfoo.Output("libfoo.so").Inputs.Strings()
+	android.AssertStringListContains(t, "libfoo objects", objs,
+		libfoo.Output("obj/cpp_modules/foo.util.o").Output.String())
+
+	importer := result.ModuleForTests("bin", "android_arm64_armv8-a").Output("obj/main.o")
+	android.AssertStringDoesContain(t, "importer cflags", importer.Args["cFlags"], fooFlag)
+	android.AssertStringDoesContain(t, "importer cflags", importer.Args["cFlags"], "-fmodule-file-deps")
+	android.AssertStringListContains(t, "importer order-only deps", importer.OrderOnly.Strings(),
+		util.Output.String())
+
+	testCcError(t, `module interface units foo.cppm and bar/foo.cppm both provide the module "foo"`, `
+		cc_library {
+			name: "libbar",
+			srcs: ["foo.cppm", "bar/foo.cppm"],
+			cpp_modules: true,
+		}
+	`)
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("cfi_report", cfiReportSingletonFactory)
+}
+
+func cfiReportSingletonFactory() android.Singleton {
+	return &cfiReportSingleton{}
+}
+
+// cfiReportSingleton writes cfi/report.txt, built by the cfi-report phony target, for security
+// review of the CFI coverage of the device. It has a line for each variant of the device
+// binaries and libraries, with whether the variant is built with CFI and why.
+type cfiReportSingleton struct{}
+
+func (s *cfiReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var lines []string
+	ctx.VisitAllModules(func(module android.Module) {
+		m, ok := module.(*Module)
+		if !ok || m.sanitize == nil || !m.Enabled() || !m.Device() {
+			return
+		}
+		if !m.Binary() && !m.Shared() && !m.Static() {
+			return
+		}
+
+		status := "disabled"
+		if m.sanitize.isSanitizerEnabled(cfi) {
+			status = "enabled"
+		}
+		reason := m.sanitize.Properties.CfiReason
+		if reason == "" {
+			reason = "not requested"
+		}
+		lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s\t%s",
+			ctx.ModuleName(m), ctx.ModuleSubDir(m), ctx.ModuleDir(m), status, reason))
+	})
+	if len(lines) == 0 {
+		return
+	}
+	sort.Strings(lines)
+
+	report := android.PathForOutput(ctx, "cfi", "report.txt")
+	android.WriteFileRule(ctx, report, "# module\tvariant\tdirectory\tcfi\treason\n"+strings.Join(lines, "\n"))
+	ctx.Phony("cfi-report", report)
+}
@@ -139,6 +139,19 @@ func CheckBadTidyFlags(ctx ModuleContext, prop string, flags []string) {
 	}
 }
 
+// Check for bad include-what-you-use flags
+func CheckBadIwyuFlags(ctx ModuleContext, prop string, flags []string) {
+	for _, flag := range flags {
+		flag = strings.TrimSpace(flag)
+
+		if strings.HasPrefix(flag, "--mapping_file") {
+			ctx.PropertyErrorf(prop, "Flag `%s` is not allowed, use `iwyu_mapping_files` property instead", flag)
+		} else if strings.Contains(flag, " ") {
+			ctx.PropertyErrorf(prop, "Bad flag: `%s` is not an allowed multi-word flag. Should it be split into multiple flags?", flag)
+		}
+	}
+}
+
 // Check for bad clang tidy checks
 func CheckBadTidyChecks(ctx ModuleContext, prop string, checks []string) {
 	for _, check := range checks {
@@ -16,6 +16,7 @@ package cc
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -33,6 +34,14 @@ var (
 
 // This file contains the basic C/C++/assembly to .o compliation steps
 
+type perSrcAsflags struct {
+	// list of assembly sources in srcs, relative to the module directory.
+	Srcs []string
+
+	// list of flags that will be used when compiling the sources.
+	Asflags []string
+}
+
 type BaseCompilerProperties struct {
 	// list of source files used to compile the C/C++ module.  May be .c, .cpp, or .S files.
 	// srcs may reference the outputs of other modules that produce source files like genrule
@@ -61,6 +70,15 @@ type BaseCompilerProperties struct {
 	// list of module-specific flags that will be used for .S compiles
 	Asflags []string `android:"arch_variant"`
 
+	// list of assembly sources with additional flags that will be used for compiling them,
+	// after asflags.
+	Per_src_asflags []perSrcAsflags
+
+	// whether to assemble .s and .S sources with the clang integrated assembler. Defaults to
+	// true. Setting it to false passes -fno-integrated-as to clang for modules with assembly
+	// that the integrated assembler doesn't accept.
+	Use_integrated_as *bool `android:"arch_variant"`
+
 	// list of module-specific flags that will be used for C and C++ compiles when
 	// compiling with clang
 	Clang_cflags []string `android:"arch_variant"`
@@ -116,6 +134,19 @@ type BaseCompilerProperties struct {
 	// if set to false, use -std=c++* instead of -std=gnu++*
 	Gnu_extensions *bool
 
+	// Experimental: compile the .cppm files in srcs as C++20 module interface units. Each unit
+	// must declare the module named after its file, and may import the units listed before it.
+	// The other sources of the module, and the modules depending on a library, may import all of
+	// them. Defaults to the experimental C++ standard when cpp_std is not set.
+	Cpp_modules *bool
+
+	// If true, generate an annotated assembly listing and a preprocessed copy of each C and C++
+	// source file, using the same flags as its object file. They can be referenced with the
+	// ".asm/<src>" and ".i/<src>" output tags, and `m <module>.s` builds all the assembly
+	// listings of the module. Also enabled for the modules listed in the comma separated
+	// CC_INSPECTION_MODULES environment variable.
+	Inspection_outputs *bool
+
 	Yacc *YaccProperties
 	Lex  *LexProperties
 
@@ -224,6 +255,7 @@ type baseCompiler struct {
 	cFlagsDeps android.Paths
 	pathDeps   android.Paths
 	flags      builderFlags
+	cppModules android.Paths // BMIs of the C++ module interface units
 
 	// Sources that were passed to the C/C++ compiler
 	srcs android.Paths
@@ -336,6 +368,9 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 	compiler.srcsBeforeGen = android.PathsForModuleSrcExcludes(ctx, compiler.Properties.Srcs, compiler.Properties.Exclude_srcs)
 	compiler.srcsBeforeGen = append(compiler.srcsBeforeGen, deps.GeneratedSources...)
 
+	flags.EmitInspectionFiles = Bool(compiler.Properties.Inspection_outputs) ||
+		android.InList(ctx.ModuleName(), strings.Split(ctx.Config().Getenv("CC_INSPECTION_MODULES"), ","))
+
 	CheckBadCompilerFlags(ctx, "cflags", compiler.Properties.Cflags)
 	CheckBadCompilerFlags(ctx, "cppflags", compiler.Properties.Cppflags)
 	CheckBadCompilerFlags(ctx, "conlyflags", compiler.Properties.Conlyflags)
@@ -354,6 +389,8 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 	flags.Local.AsFlags = append(flags.Local.AsFlags, esc(compiler.Properties.Asflags)...)
 	flags.Local.YasmFlags = append(flags.Local.YasmFlags, esc(compiler.Properties.Asflags)...)
 
+	flags = compiler.assemblerFlags(ctx, flags)
+
 	flags.Yacc = compiler.Properties.Yacc
 	flags.Lex = compiler.Properties.Lex
 
@@ -448,7 +485,7 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 	flags.Local.LdFlags = config.ClangFilterUnknownCflags(flags.Local.LdFlags)
 
 	target := "-target " + tc.ClangTriple()
-	if ctx.Os().Class == android.Device {
+	if ctx.Os() == android.Android {
 		version := ctx.minSdkVersion()
 		if version == "" || version == "current" {
 			target += strconv.Itoa(android.FutureApiLevelInt)
@@ -499,6 +536,9 @@ func (compiler *baseCompiler) compilerFlags(ctx ModuleContext, flags Flags, deps
 
 	cStd := parseCStd(compiler.Properties.C_std)
 	cppStd := parseCppStd(compiler.Properties.Cpp_std)
+	if Bool(compiler.Properties.Cpp_modules) && compiler.Properties.Cpp_std == nil {
+		cppStd = config.ExperimentalCppStdVersion
+	}
 
 	cStd, cppStd = maybeReplaceGnuToC(compiler.Properties.Gnu_extensions, cStd, cppStd)
 
@@ -660,6 +700,25 @@ func (compiler *baseCompiler) compile(ctx ModuleContext, flags Flags, deps PathD
 	srcs, genDeps, info := genSources(ctx, srcs, buildFlags)
 	pathDeps = append(pathDeps, genDeps...)
 
+	if !proptools.BoolDefault(compiler.Properties.Use_integrated_as, true) {
+		if _, asmSrcs := android.FilterPathListPredicate(srcs, isAssemblySrc); len(asmSrcs) == 0 {
+			ctx.PropertyErrorf("use_integrated_as", "false has no effect without .s or .S sources")
+		}
+	}
+
+	// Precompile the C++ module interface units before the other sources, which may import them.
+	var cppModuleObjs Objects
+	if Bool(compiler.Properties.Cpp_modules) {
+		var interfaces android.Paths
+		srcs, interfaces = android.FilterPathListPredicate(srcs, func(src android.Path) bool {
+			return src.Ext() == ".cppm"
+		})
+		compiler.cppModules, cppModuleObjs = compileCppModuleInterfaces(ctx, buildFlags, interfaces,
+			pathDeps, flags.CFlagsDeps)
+		buildFlags.localCppFlags += " " + strings.Join(cppModuleFlags(compiler.cppModules), " ")
+		pathDeps = append(pathDeps, compiler.cppModules...)
+	}
+
 	compiler.pathDeps = pathDeps
 	compiler.generatedSourceInfo = info
 	compiler.cFlagsDeps = flags.CFlagsDeps
@@ -677,7 +736,10 @@ func (compiler *baseCompiler) compile(ctx ModuleContext, flags Flags, deps PathD
 		return Objects{}
 	}
 
-	return objs
+	// The link validates the header checks of the header libraries, which nothing else builds.
+	objs.headerCheckFiles = append(objs.headerCheckFiles, deps.HeaderChecks...)
+
+	return cppModuleObjs.Append(objs)
 }
 
 // Compile a list of source files into objects a specified subdirectory
@@ -729,3 +791,69 @@ type RustBindgenClangProperties struct {
 
 	//TODO(b/161141999) Add support for headers from cc_library_header modules.
 }
+
+// integratedAsFlags are the flags that select the assembler of clang, which should be set through
+// use_integrated_as instead.
+var integratedAsFlags = []string{"-fintegrated-as", "-fno-integrated-as", "-integrated-as", "-no-integrated-as"}
+
+func isAssemblySrc(src android.Path) bool {
+	return src.Ext() == ".s" || src.Ext() == ".S"
+}
+
+// warnIntegratedAsFlag warns once per module about a flag of integratedAsFlags in asflags. The
+// flags still work there, as existing modules use them, but new modules should use
+// use_integrated_as.
+func warnIntegratedAsFlag(ctx ModuleContext, prop, flag string) {
+	key := android.NewCustomOnceKey([3]string{"integratedAsFlag", ctx.ModuleDir(), ctx.ModuleName()})
+	ctx.Config().Once(key, func() interface{} {
+		replacement := "use_integrated_as: false"
+		if !strings.Contains(flag, "-no-") {
+			replacement = "nothing, the integrated assembler is the default"
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s: module %q: %s: `%s` is deprecated, replace it with %s\n",
+			ctx.BlueprintsFile(), ctx.ModuleName(), prop, flag, replacement)
+		return true
+	})
+}
+
+// assemblerFlags adds the flags for use_integrated_as and per_src_asflags to flags.
+func (compiler *baseCompiler) assemblerFlags(ctx ModuleContext, flags Flags) Flags {
+	for _, prop := range []string{"asflags", "clang_asflags"} {
+		asflags := compiler.Properties.Asflags
+		if prop == "clang_asflags" {
+			asflags = compiler.Properties.Clang_asflags
+		}
+		for _, flag := range asflags {
+			if inList(strings.TrimSpace(flag), integratedAsFlags) {
+				warnIntegratedAsFlag(ctx, prop, flag)
+			}
+		}
+	}
+
+	if !proptools.BoolDefault(compiler.Properties.Use_integrated_as, true) {
+		// Whether the module has assembly sources is checked in compile, once the sources have
+		// been generated.
+		flags.Local.AsFlags = append(flags.Local.AsFlags, "-fno-integrated-as")
+	}
+
+	for _, entry := range compiler.Properties.Per_src_asflags {
+		CheckBadCompilerFlags(ctx, "per_src_asflags", entry.Asflags)
+		for _, flag := range entry.Asflags {
+			if inList(strings.TrimSpace(flag), integratedAsFlags) {
+				ctx.PropertyErrorf("per_src_asflags", "Bad flag: `%s`, use use_integrated_as instead", flag)
+			}
+		}
+		for _, src := range android.PathsForModuleSrc(ctx, entry.Srcs) {
+			if !isAssemblySrc(src) || !android.InList(src.String(), compiler.srcsBeforeGen.Strings()) {
+				ctx.PropertyErrorf("per_src_asflags", "%q is not a .s or .S source in srcs", src.Rel())
+				continue
+			}
+			if flags.PerSrcAsFlags == nil {
+				flags.PerSrcAsFlags = make(map[string][]string)
+			}
+			flags.PerSrcAsFlags[src.String()] = append(flags.PerSrcAsFlags[src.String()],
+				proptools.NinjaAndShellEscapeList(entry.Asflags)...)
+		}
+	}
+	return flags
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"android/soong/android"
+)
+
+var (
+	baremetalCflags = []string{
+		// There is no libc, so the compiler can't assume the standard library exists.
+		"-ffreestanding",
+		// Stack protector and fortify need support from libc.
+		"-fno-stack-protector",
+		"-U_FORTIFY_SOURCE",
+	}
+
+	baremetalLdflags = []string{
+		"-nostdlib",
+	}
+)
+
+func init() {
+	exportedVars.ExportStringListStaticVariable("BaremetalCflags", baremetalCflags)
+	exportedVars.ExportStringListStaticVariable("BaremetalLdflags", baremetalLdflags)
+}
+
+// toolchainBaremetal overrides the runtime of a device toolchain for baremetal targets, which
+// have no libc, crt objects or default shared libraries.
+type toolchainBaremetal struct {
+}
+
+func (toolchainBaremetal) Bionic() bool { return false }
+
+func (toolchainBaremetal) DefaultSharedLibraries() []string { return nil }
+
+func (toolchainBaremetal) CrtBeginStaticBinary() []string  { return nil }
+func (toolchainBaremetal) CrtBeginSharedBinary() []string  { return nil }
+func (toolchainBaremetal) CrtBeginSharedLibrary() []string { return nil }
+func (toolchainBaremetal) CrtEndStaticBinary() []string    { return nil }
+func (toolchainBaremetal) CrtEndSharedBinary() []string    { return nil }
+func (toolchainBaremetal) CrtEndSharedLibrary() []string   { return nil }
+
+// toolchain config for ARM64 baremetal targets. Almost everything is the same as the ARM64
+// Android target, the overridden methods below show the differences.
+type toolchainBaremetalArm64 struct {
+	toolchainArm64
+	toolchainBaremetal
+}
+
+func (toolchainBaremetalArm64) ClangTriple() string {
+	// Note the absence of "-linux-android". The compiler won't define __ANDROID__ or __linux__.
+	return "aarch64-none-elf"
+}
+
+func baremetalArm64ToolchainFactory(arch android.Arch) Toolchain {
+	archVariant := arch.ArchVariant
+	if archVariant == "" {
+		archVariant = "armv8-a"
+	}
+	if _, ok := arm64ArchVariantCflagsVar[archVariant]; !ok {
+		panic(fmt.Sprintf("Unknown ARM architecture version: %q", arch.ArchVariant))
+	}
+
+	toolchainCflags := []string{arm64ArchVariantCflagsVar[archVariant]}
+	toolchainCflags = append(toolchainCflags,
+		variantOrDefault(arm64CpuVariantCflagsVar, arch.CpuVariant),
+		// The toolchain cflags come after the global device cflags, which they need to override.
+		"${config.BaremetalCflags}")
+
+	extraLdflags := variantOrDefault(arm64CpuVariantLdflags, arch.CpuVariant)
+
+	ret := toolchainBaremetalArm64{}
+	ret.toolchainArm64.ldflags = strings.Join([]string{
+		"${config.Arm64Ldflags}",
+		"${config.BaremetalLdflags}",
+		extraLdflags,
+	}, " ")
+	ret.toolchainArm64.lldflags = strings.Join([]string{
+		"${config.Arm64Lldflags}",
+		"${config.BaremetalLdflags}",
+		extraLdflags,
+	}, " ")
+	ret.toolchainArm64.toolchainCflags = strings.Join(toolchainCflags, " ")
+	return &ret
+}
+
+func init() {
+	registerToolchainFactory(android.Baremetal, android.Arm64, baremetalArm64ToolchainFactory)
+}
@@ -15,8 +15,12 @@
 package config
 
 import (
-	"android/soong/android"
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strings"
+
+	"android/soong/android"
 )
 
 func init() {
@@ -158,3 +162,126 @@ func TidyFlagsForSrcFile(srcFile android.Path, flags string) string {
 	}
 	return flags
 }
+
+// TidyDirConfig is an entry of a tidy_dirs config file. It sets the default clang-tidy checks and
+// flags of the modules in the directories under PathPrefix, in addition to the ones selected by
+// TidyChecksForDir and before the module's own tidy_checks and tidy_flags.
+type TidyDirConfig struct {
+	// The directory the entry applies to, relative to the top of the source tree.
+	Path_prefix string `json:"path_prefix"`
+
+	// Checks added after the default checks of the directory. A leading "-*" disables the default
+	// checks.
+	Tidy_checks []string `json:"tidy_checks"`
+
+	// Flags added before the module's tidy_flags.
+	Tidy_flags []string `json:"tidy_flags"`
+}
+
+var tidyDirsConfigKey = android.NewOnceKey("tidyDirsConfig")
+
+type tidyDirsConfigResult struct {
+	entries []TidyDirConfig
+	err     error
+}
+
+// TidyDirConfigForDir returns the entry of the files listed in the TidyDirsConfigFiles product
+// variable with the longest path prefix that contains dir, or nil if there is none.
+//
+// The files are merged in order: an entry in a file overrides the entry for the same path prefix
+// in an earlier file, so that product and vendor trees can tighten or relax the checks of the
+// directories they own without modifying every Android.bp file.
+func TidyDirConfigForDir(ctx android.PathContext, dir string) (*TidyDirConfig, error) {
+	result := tidyDirsConfig(ctx)
+	if result.err != nil {
+		return nil, result.err
+	}
+	dir = dir + "/"
+	// The entries are sorted by decreasing path prefix length, the first match is the most
+	// specific one.
+	for i := range result.entries {
+		if strings.HasPrefix(dir, result.entries[i].Path_prefix) {
+			return &result.entries[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// TidyDirsConfigError returns the error, if any, of loading the files listed in the
+// TidyDirsConfigFiles product variable.
+func TidyDirsConfigError(ctx android.PathContext) error {
+	return tidyDirsConfig(ctx).err
+}
+
+func tidyDirsConfig(ctx android.PathContext) tidyDirsConfigResult {
+	return ctx.Config().Once(tidyDirsConfigKey, func() interface{} {
+		entries, err := loadTidyDirsConfig(ctx)
+		return tidyDirsConfigResult{entries, err}
+	}).(tidyDirsConfigResult)
+}
+
+func loadTidyDirsConfig(ctx android.PathContext) ([]TidyDirConfig, error) {
+	byPrefix := make(map[string]TidyDirConfig)
+	for _, file := range ctx.Config().TidyDirsConfigFiles() {
+		path := android.ExistentPathForSource(ctx, file)
+		if !path.Valid() {
+			return nil, fmt.Errorf("tidy_dirs config %q does not exist", file)
+		}
+		content, err := android.ReadFileFromSourceTree(ctx, path.Path())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tidy_dirs config %q: %s", file, err)
+		}
+		entries, err := parseTidyDirsConfig(file, content)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			byPrefix[entry.Path_prefix] = entry
+		}
+	}
+	entries := make([]TidyDirConfig, 0, len(byPrefix))
+	for _, entry := range byPrefix {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return len(entries[i].Path_prefix) > len(entries[j].Path_prefix)
+	})
+	return entries, nil
+}
+
+// parseTidyDirsConfig parses the content of a tidy_dirs config file, a JSON list of TidyDirConfig,
+// e.g. [{"path_prefix": "vendor/foo", "tidy_checks": ["-*", "bugprone-*"]}].
+func parseTidyDirsConfig(file string, content []byte) ([]TidyDirConfig, error) {
+	var entries []TidyDirConfig
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	seen := make(map[string]bool)
+	for i := range entries {
+		entry := &entries[i]
+		prefix := strings.TrimSuffix(strings.TrimSpace(entry.Path_prefix), "/")
+		if prefix == "" {
+			return nil, fmt.Errorf("%s: entry %d: path_prefix must not be empty", file, i)
+		}
+		entry.Path_prefix = prefix + "/"
+		if seen[entry.Path_prefix] {
+			return nil, fmt.Errorf("%s: duplicate entry for %q", file, prefix)
+		}
+		seen[entry.Path_prefix] = true
+		for _, check := range entry.Tidy_checks {
+			if check == "" || strings.ContainsAny(check, " ,") {
+				return nil, fmt.Errorf("%s: %s: invalid check %q, checks cannot be empty or contain spaces or commas",
+					file, prefix, check)
+			}
+		}
+		for _, flag := range entry.Tidy_flags {
+			if !strings.HasPrefix(flag, "-") || strings.Contains(flag, " ") {
+				return nil, fmt.Errorf("%s: %s: invalid flag %q, flags must start with `-` and cannot contain spaces",
+					file, prefix, flag)
+			} else if strings.HasPrefix(flag, "-fix") || strings.HasPrefix(flag, "-checks=") {
+				return nil, fmt.Errorf("%s: %s: flag %q is not allowed", file, prefix, flag)
+			}
+		}
+	}
+	return entries, nil
+}
@@ -16,6 +16,8 @@ package config
 
 import (
 	"testing"
+
+	"android/soong/android"
 )
 
 func TestTidyChecksForDir(t *testing.T) {
@@ -39,3 +41,64 @@ func TestTidyChecksForDir(t *testing.T) {
 		})
 	}
 }
+
+func TestTidyDirConfigForDir(t *testing.T) {
+	fs := map[string][]byte{
+		"a/tidy_dirs.json": []byte(`[
+			{"path_prefix": "foo", "tidy_checks": ["misc-*"]},
+			{"path_prefix": "foo/bar/", "tidy_checks": ["-*", "bugprone-*"], "tidy_flags": ["-extra-arg=-DBAR"]}
+		]`),
+		"b/tidy_dirs.json": []byte(`[
+			{"path_prefix": "foo/", "tidy_checks": ["cert-*"]}
+		]`),
+		"bad/json.json":      []byte(`{}`),
+		"bad/prefix.json":    []byte(`[{"path_prefix": "/"}]`),
+		"bad/duplicate.json": []byte(`[{"path_prefix": "foo"}, {"path_prefix": "foo/"}]`),
+		"bad/check.json":     []byte(`[{"path_prefix": "foo", "tidy_checks": ["misc-*,cert-*"]}]`),
+		"bad/flag.json":      []byte(`[{"path_prefix": "foo", "tidy_flags": ["-fix"]}]`),
+	}
+
+	load := func(dir string, files ...string) (*TidyDirConfig, error) {
+		config := android.TestConfig(t.TempDir(), nil, "", fs)
+		config.TestProductVariables.TidyDirsConfigFiles = files
+		return TidyDirConfigForDir(android.PathContextForTesting(config), dir)
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		for dir, expected := range map[string][]string{
+			"foo":         {"cert-*"},
+			"foo/baz":     {"cert-*"},
+			"foo/bar":     {"-*", "bugprone-*"},
+			"foo/bar/baz": {"-*", "bugprone-*"},
+			"foobar":      nil,
+		} {
+			entry, err := load(dir, "a/tidy_dirs.json", "b/tidy_dirs.json")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var checks []string
+			if entry != nil {
+				checks = entry.Tidy_checks
+			}
+			android.AssertDeepEquals(t, dir, expected, checks)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for file, expected := range map[string]string{
+			"bad/json.json":      "bad/json.json: json: cannot unmarshal object into Go value of type []config.TidyDirConfig",
+			"bad/prefix.json":    "bad/prefix.json: entry 0: path_prefix must not be empty",
+			"bad/duplicate.json": `bad/duplicate.json: duplicate entry for "foo"`,
+			"bad/check.json":     `bad/check.json: foo: invalid check "misc-*,cert-*", checks cannot be empty or contain spaces or commas`,
+			"bad/flag.json":      `bad/flag.json: foo: flag "-fix" is not allowed`,
+			"bad/missing.json":   `tidy_dirs config "bad/missing.json" does not exist`,
+		} {
+			_, err := load("foo", file)
+			if err == nil {
+				t.Errorf("%s: expected error %q, got none", file, expected)
+				continue
+			}
+			android.AssertStringEquals(t, file, expected, err.Error())
+		}
+	})
+}
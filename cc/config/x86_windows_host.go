@@ -117,6 +117,80 @@ var (
 		"ws2_32",
 		"windowscodecs",
 	}, "-l")
+
+	// WindowsHostToolModules is the allowlist of modules that can enable their Windows variants
+	// with target: { windows: { enabled: true } }. These are the host tools shipped for Windows in
+	// the SDK platform-tools and build-tools, i.e. adb, fastboot and aapt2, and the libraries they
+	// depend on.
+	WindowsHostToolModules = []string{
+		// Tools
+		"aapt2",
+		"adb",
+		"fastboot",
+		"make_f2fs",
+		"mke2fs",
+		"sload_f2fs",
+
+		// adb and its dependencies
+		"AdbWinApi",
+		"AdbWinUsbApi",
+		"libadb_crypto",
+		"libadb_host",
+		"libadb_pairing_auth",
+		"libadb_pairing_connection",
+		"libadb_protos",
+		"libadb_sysdeps",
+		"libadb_tls_connection",
+		"libapp_processes_protos_lite",
+		"libdiagnose_usb",
+		"libmdnssd",
+		"libopenscreen-discovery",
+		"libopenscreen-platform-impl",
+		"libopenscreen-protos",
+		"libusb",
+
+		// aapt2 and its dependencies
+		"libaapt2",
+		"libandroidfw",
+		"libbuildversion",
+		"libexpat",
+		"libidmap2_policies",
+		"libpng",
+
+		// fastboot and its dependencies
+		"libext2_blkid",
+		"libext2_com_err",
+		"libext2_e2p",
+		"libext2_misc",
+		"libext2_quota",
+		"libext2_uuid",
+		"libext2fs",
+		"libext4_utils",
+		"libf2fs_fmt_host",
+		"libfastboot",
+		"liblp",
+		"libsparse",
+		"libstorage_literals_headers",
+
+		// Common libraries
+		"libbase",
+		"libbrotli",
+		"libc++_static",
+		"libc++abi",
+		"libcrypto",
+		"libcrypto_utils",
+		"libcutils",
+		"liblog",
+		"liblz4",
+		"libprotobuf-cpp-full",
+		"libprotobuf-cpp-lite",
+		"libssl",
+		"libutils",
+		"libwinpthread",
+		"libz",
+		"libziparchive",
+		"libzstd",
+	}
 )
 
 const (
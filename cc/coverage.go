@@ -65,6 +65,17 @@ func getClangProfileLibraryName(ctx ModuleContextIntf) string {
 	}
 }
 
+// coverageDependencyTag is the dependency tag for the coverage runtime libraries, which are only
+// linked into modules when coverage is enabled.
+type coverageDependencyTag struct {
+	blueprint.BaseDependencyTag
+}
+
+// Implements android.CoverageDependencyTag
+func (coverageDependencyTag) CoverageDependency() {}
+
+var _ android.CoverageDependencyTag = coverageDependencyTag{}
+
 func (cov *coverage) deps(ctx DepsContext, deps Deps) Deps {
 	if cov.Properties.NeedCoverageVariant {
 		ctx.AddVariationDependencies([]blueprint.Variation{
@@ -0,0 +1,119 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+func init() {
+	android.RegisterSingletonType("native_coverage_report", nativeCoverageReportSingletonFactory)
+}
+
+func nativeCoverageReportSingletonFactory() android.Singleton {
+	return &nativeCoverageReportSingleton{}
+}
+
+// nativeCoverageReportSingleton writes coverage/native_coverage.info, an lcov tracefile of the
+// device modules built with native coverage, and with NATIVE_COVERAGE_HTML=true an HTML report in
+// coverage/native_coverage_html.zip. Both are built by and dist for the native-coverage-report
+// goal.
+//
+// The coverage data is read from the directory in NATIVE_COVERAGE_DATA, where the .profraw or
+// .gcda files pulled from a device are expected. The directory is read again on every build of the
+// goal, as its contents are not known to the build. Modules without coverage data have zero counts
+// for every line, except with clang coverage and no .profraw files at all, where the report is
+// empty.
+type nativeCoverageReportSingleton struct {
+	outputs android.Paths
+}
+
+func (s *nativeCoverageReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	deviceConfig := ctx.DeviceConfig()
+	if !deviceConfig.NativeCoverageEnabled() {
+		return
+	}
+	gcov := deviceConfig.GcovCoverageEnabled()
+
+	var inputs android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		m, ok := module.(*Module)
+		if !ok || m.coverage == nil || !m.Enabled() || !m.Device() {
+			return
+		}
+		if !m.coverage.Properties.CoverageEnabled {
+			return
+		}
+		if gcov {
+			if zip := m.CoverageOutputFile(); zip.Valid() {
+				inputs = append(inputs, zip.Path())
+			}
+		} else if m.Binary() || m.Shared() {
+			if unstripped := m.UnstrippedOutputFile(); unstripped != nil {
+				inputs = append(inputs, unstripped)
+			}
+		}
+	})
+	if len(inputs) == 0 {
+		return
+	}
+	inputs = android.SortedUniquePaths(inputs)
+
+	tracefile := android.PathForOutput(ctx, "coverage", "native_coverage.info")
+	s.outputs = android.Paths{tracefile}
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().
+		BuiltTool("native_coverage_report").
+		FlagWithInput("--llvm-cov ", config.ClangPath(ctx, "bin/llvm-cov"))
+	if gcov {
+		cmd.Flag("--mode gcov").
+			FlagWithRspFileInputList("--gcno-zips ",
+				android.PathForOutput(ctx, "coverage", "native_coverage_gcno_zips.rsp"), inputs)
+	} else {
+		cmd.Flag("--mode clang").
+			FlagWithInput("--llvm-profdata ", config.ClangPath(ctx, "bin/llvm-profdata")).
+			FlagWithRspFileInputList("--objects ",
+				android.PathForOutput(ctx, "coverage", "native_coverage_objects.rsp"), inputs)
+	}
+	if dataDir := ctx.Config().Getenv("NATIVE_COVERAGE_DATA"); dataDir != "" {
+		// The phony has no inputs, so it is always dirty and the report is rebuilt from the
+		// current contents of the directory.
+		dataChanged := android.PathForPhony(ctx, "native-coverage-data")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:   blueprint.Phony,
+			Output: dataChanged,
+		})
+		cmd.FlagWithArg("--data-dir ", dataDir).Implicit(dataChanged)
+	}
+	if ctx.Config().IsEnvTrue("NATIVE_COVERAGE_HTML") {
+		html := android.PathForOutput(ctx, "coverage", "native_coverage_html.zip")
+		cmd.FlagWithOutput("--html ", html)
+		s.outputs = append(s.outputs, html)
+	}
+	cmd.FlagWithOutput("-o ", tracefile)
+	rule.Build("native_coverage_report", "native coverage report")
+}
+
+func (s *nativeCoverageReportSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if len(s.outputs) == 0 {
+		return
+	}
+	ctx.Phony("native-coverage-report", s.outputs...)
+	ctx.DistForGoal("native-coverage-report", s.outputs...)
+}
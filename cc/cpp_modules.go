@@ -0,0 +1,143 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+// This file contains the experimental support for C++20 modules, enabled with cpp_modules: true.
+//
+// The .cppm sources of a module are module interface units. Each one is precompiled into a built
+// module interface (BMI), which is then compiled into the object file of the unit. The name of the
+// C++ module declared by a unit must be the name of its file without the extension, so that the
+// build can map module names to BMIs without scanning the sources. The BMIs are passed to the
+// compiler with -fmodule-file=<name>=<path> flags, which only read a BMI when its module is
+// imported, along with -fmodule-file-deps so that the BMIs that are read are listed in the
+// depfile and the importing compiles are run again when they change. The BMIs are dependencies
+// of the compiles that may import them:
+//  - an interface unit may import the units listed before it in srcs, which are implicit inputs
+//    of its precompile,
+//  - the other sources of the module may import all of its units, which are order-only
+//    dependencies of their compiles like generated headers,
+//  - the modules depending on a library may import all of its units, as the library exports
+//    the flags and the BMIs like it exports generated headers.
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+var (
+	// Rule to precompile a C++ module interface unit into a BMI. Outputs a .d depfile.
+	cppModuleInterface = pctx.AndroidStaticRule("cppModuleInterface",
+		blueprint.RuleParams{
+			Depfile:     "${out}.d",
+			Deps:        blueprint.DepsGCC,
+			Command:     "$relPwd $ccCmd --precompile -x c++-module $cFlags -MD -MF ${out}.d -o $out $in",
+			CommandDeps: []string{"$ccCmd"},
+		},
+		"ccCmd", "cFlags")
+
+	// Rule to compile a BMI into the object file of its module interface unit. Only the code
+	// generation flags of $cFlags are used.
+	cppModuleObject = pctx.AndroidStaticRule("cppModuleObject",
+		blueprint.RuleParams{
+			Command:     "$relPwd $ccCmd -c $cFlags -Wno-unused-command-line-argument -o $out $in",
+			CommandDeps: []string{"$ccCmd"},
+		},
+		"ccCmd", "cFlags")
+)
+
+// cppModuleName returns the name of the C++ module declared by a module interface unit, or
+// provided by its BMI.
+func cppModuleName(path android.Path) string {
+	return strings.TrimSuffix(path.Base(), path.Ext())
+}
+
+// cppModuleFlags returns the flags mapping the names of C++ modules to their BMIs, and adding the
+// BMIs that are read to the depfile.
+func cppModuleFlags(bmis android.Paths) []string {
+	if len(bmis) == 0 {
+		return nil
+	}
+	flags := make([]string, 0, len(bmis)+1)
+	flags = append(flags, "-fmodule-file-deps")
+	for _, bmi := range bmis {
+		flags = append(flags, "-fmodule-file="+cppModuleName(bmi)+"="+bmi.String())
+	}
+	return flags
+}
+
+// compileCppModuleInterfaces adds the rules precompiling the module interface units in srcs into
+// BMIs, in order, and compiling the BMIs into objects. It returns the BMIs and the objects.
+func compileCppModuleInterfaces(ctx ModuleContext, flags builderFlags, srcs android.Paths,
+	pathDeps android.Paths, cFlagsDeps android.Paths) (android.Paths, Objects) {
+
+	cFlags := strings.Join([]string{
+		flags.globalCommonFlags,
+		flags.globalCFlags,
+		flags.globalCppFlags,
+		flags.localCommonFlags,
+		flags.localCFlags,
+		flags.localCppFlags,
+		flags.systemIncludeFlags,
+		"${config.NoOverrideGlobalCflags}",
+	}, " ")
+	ccCmd := "${config.ClangBin}/clang++"
+
+	var bmis android.Paths
+	var objFiles android.Paths
+	units := make(map[string]android.Path)
+	for _, src := range srcs {
+		name := cppModuleName(src)
+		if other, exists := units[name]; exists {
+			ctx.PropertyErrorf("srcs", "module interface units %s and %s both provide the module %q",
+				other, src, name)
+			continue
+		}
+		units[name] = src
+
+		bmi := android.ObjPathWithExt(ctx, "cpp_modules", src, "pcm")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        cppModuleInterface,
+			Description: "clang++ --precompile " + src.Rel(),
+			Output:      bmi,
+			Input:       src,
+			Implicits:   append(append(android.Paths{}, cFlagsDeps...), bmis...),
+			OrderOnly:   pathDeps,
+			Args: map[string]string{
+				"ccCmd":  ccCmd,
+				"cFlags": strings.Join(append([]string{cFlags}, cppModuleFlags(bmis)...), " "),
+			},
+		})
+
+		objFile := android.ObjPathWithExt(ctx, "cpp_modules", src, "o")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        cppModuleObject,
+			Description: "clang++ " + bmi.Rel(),
+			Output:      objFile,
+			Input:       bmi,
+			Implicits:   cFlagsDeps,
+			Args: map[string]string{
+				"ccCmd":  ccCmd,
+				"cFlags": cFlags,
+			},
+		})
+
+		bmis = append(bmis, bmi)
+		objFiles = append(objFiles, objFile)
+	}
+	return bmis, Objects{objFiles: objFiles}
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// Checking the symbols of libraries that apps dlopen
+// Platform libraries that apps load with dlopen have no NDK stubs that pin down their interface.
+// Such libraries list the symbols apps may look up in dlopen_symbols_file, and the exported
+// symbols of the built library are compared against that list.
+
+func init() {
+	pctx.HostBinToolVariable("checkDlopenSymbolsCmd", "check_dlopen_symbols")
+}
+
+var checkDlopenSymbolsRule = pctx.AndroidStaticRule("checkDlopenSymbols",
+	blueprint.RuleParams{
+		Command:     "$checkDlopenSymbolsCmd --module $module --symbols $symbols -o $out $in",
+		CommandDeps: []string{"$checkDlopenSymbolsCmd"},
+	},
+	"module", "symbols")
+
+// dlopenSymbolsFile returns the dlopen_symbols_file of the library if it should be checked.
+func (library *libraryDecorator) dlopenSymbolsFile(ctx ModuleContext) android.OptionalPath {
+	symbols := ctx.ExpandOptionalSource(library.Properties.Dlopen_symbols_file, "dlopen_symbols_file")
+	if !symbols.Valid() {
+		return android.OptionalPath{}
+	}
+	if ctx.Darwin() || ctx.Windows() {
+		ctx.PropertyErrorf("dlopen_symbols_file", "Only supported for ELF libraries")
+		return android.OptionalPath{}
+	}
+	if library.buildStubs() {
+		// Stubs export the symbols of their symbol file, not the ones of the implementation.
+		return android.OptionalPath{}
+	}
+	return symbols
+}
+
+// checkDlopenSymbols creates a rule that compares the symbols exported by a shared library,
+// read from its table of contents file, against its dlopen symbol list, and returns the path to
+// its report for use as a validation of the link step.
+func checkDlopenSymbols(ctx ModuleContext, toc, symbols android.Path) android.Path {
+	report := android.PathForModuleOut(ctx, "dlopen_symbols", "dlopen_symbols.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkDlopenSymbolsRule,
+		Description: "check dlopen symbols",
+		Input:       toc,
+		Implicit:    symbols,
+		Output:      report,
+		Args: map[string]string{
+			"module":  ctx.ModuleName(),
+			"symbols": symbols.String(),
+		},
+	})
+	return report
+}
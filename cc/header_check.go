@@ -0,0 +1,116 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+var (
+	// Rule for compiling a header on its own. Only the syntax is checked, and $out is a timestamp.
+	headerCheck = pctx.AndroidStaticRule("headerCheck",
+		blueprint.RuleParams{
+			Depfile:     "${out}.d",
+			Deps:        blueprint.DepsGCC,
+			Command:     "$relPwd $ccCmd -fsyntax-only -x $language $cFlags -MD -MF ${out}.d -MQ $out $in && touch $out",
+			CommandDeps: []string{"$ccCmd"},
+		},
+		"ccCmd", "language", "cFlags")
+)
+
+// Extensions of the headers that are always checked as C++, whatever the language of the
+// module is.
+var cppHeaderExtensions = []string{".hpp", ".hh", ".hxx"}
+
+// verifySelfContainedHeaders adds a rule compiling each header in the exported include
+// directories of a cc_library_headers on its own, with the flags a source file of the library
+// would be compiled with. The timestamps of the rules are returned as validations of the library,
+// and are exported to the modules that depend on it, whose links validate them too.
+func (library *libraryDecorator) verifySelfContainedHeaders(ctx ModuleContext, flags Flags,
+	deps PathDeps) Objects {
+
+	var globs []string
+	dirs := append(append([]string{}, library.flagExporter.Properties.Export_include_dirs...),
+		library.flagExporter.Properties.Export_system_include_dirs...)
+	for _, dir := range android.FirstUniqueStrings(dirs) {
+		globs = append(globs, dir+"/**/*.h")
+		for _, ext := range cppHeaderExtensions {
+			globs = append(globs, dir+"/**/*"+ext)
+		}
+	}
+	headers := android.PathsForModuleSrcExcludes(ctx, globs,
+		library.Properties.Exclude_self_contained_headers)
+
+	pathDeps := append(append(android.Paths{}, deps.GeneratedDeps...), ndkPathDeps(ctx)...)
+	builderFlags := flagsToBuilderFlags(flags)
+	cFlags := strings.Join([]string{
+		builderFlags.globalCommonFlags,
+		builderFlags.globalCFlags,
+		builderFlags.globalConlyFlags,
+		builderFlags.localCommonFlags,
+		builderFlags.localCFlags,
+		builderFlags.localConlyFlags,
+		builderFlags.systemIncludeFlags,
+		"${config.NoOverrideGlobalCflags}",
+	}, " ")
+	cppFlags := strings.Join([]string{
+		builderFlags.globalCommonFlags,
+		builderFlags.globalCFlags,
+		builderFlags.globalCppFlags,
+		builderFlags.localCommonFlags,
+		builderFlags.localCFlags,
+		builderFlags.localCppFlags,
+		builderFlags.systemIncludeFlags,
+		"${config.NoOverrideGlobalCflags}",
+	}, " ")
+
+	// .h headers are checked in the language of the module, C++ unless it is set to C.
+	moduleIsC := false
+	switch language := String(library.Properties.Self_contained_headers_language); language {
+	case "", "c++":
+	case "c":
+		moduleIsC = true
+	default:
+		ctx.PropertyErrorf("self_contained_headers_language", "must be \"c\" or \"c++\", not %q", language)
+	}
+
+	var checkFiles android.Paths
+	for _, header := range headers {
+		checkFile := android.PathForModuleOut(ctx, "header_check", header.Rel()+".checked")
+		ccCmd, language, headerFlags := "${config.ClangBin}/clang++", "c++-header", cppFlags
+		if moduleIsC && !android.InList(header.Ext(), cppHeaderExtensions) {
+			ccCmd, language, headerFlags = "${config.ClangBin}/clang", "c-header", cFlags
+		}
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        headerCheck,
+			Description: "header check " + header.Rel(),
+			Output:      checkFile,
+			Input:       header,
+			Implicits:   flags.CFlagsDeps,
+			OrderOnly:   pathDeps,
+			Args: map[string]string{
+				"ccCmd":    ccCmd,
+				"language": language,
+				"cFlags":   headerFlags,
+			},
+		})
+		checkFiles = append(checkFiles, checkFile)
+	}
+	return Objects{headerCheckFiles: checkFiles}
+}
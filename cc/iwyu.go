@@ -0,0 +1,145 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// include-what-you-use (IWYU) reports the #includes of C and C++ sources that are missing or
+// unnecessary. Like clang-tidy it runs over each source in a separate .iwyu rule, whose output
+// is the report of the source. The reports of all modules are collected into iwyu/report.txt,
+// built by the iwyu-report phony target.
+
+type IwyuProperties struct {
+	// whether to run include-what-you-use over C and C++ sources. When true, building the module
+	// also builds the reports of its sources. Building with WITH_IWYU=true does the same for all
+	// modules that don't set this to false.
+	Iwyu *bool
+
+	// Extra flags to pass to include-what-you-use, e.g. ["-Xiwyu", "--no_fwd_decls"].
+	Iwyu_flags []string
+
+	// Mapping files that tell include-what-you-use which headers provide which symbols.
+	Iwyu_mapping_files []string `android:"path"`
+
+	// whether missing or unnecessary #includes fail the build. Ignored when building with
+	// WITH_IWYU=true, so that a global build only measures the include hygiene.
+	Iwyu_as_errors *bool
+}
+
+type iwyuFeature struct {
+	Properties IwyuProperties
+}
+
+var (
+	_ = pctx.StaticVariable("iwyuCmd", "${config.ClangBin}/include-what-you-use")
+
+	// Rule for invoking include-what-you-use. Its report is written to $out, and the rule fails
+	// if $iwyuAsErrors is set and the report suggests changes.
+	includeWhatYouUse = pctx.AndroidStaticRule("includeWhatYouUse",
+		blueprint.RuleParams{
+			Depfile: "${out}.d",
+			Deps:    blueprint.DepsGCC,
+			Command: "$iwyuCmd $iwyuFlags $cFlags -c $in -o /dev/null -MD -MF ${out}.d -MQ $out > ${out}.tmp 2>&1; " +
+				"mv ${out}.tmp $out && " +
+				"if [ -n \"$iwyuAsErrors\" ] && grep -q 'should add these lines:' $out; then " +
+				"cat $out; rm -f $out; exit 1; fi",
+			CommandDeps: []string{"$iwyuCmd"},
+		},
+		"cFlags", "iwyuFlags", "iwyuAsErrors")
+
+	// Rule for collecting the include-what-you-use reports that suggest changes.
+	iwyuReport = pctx.AndroidStaticRule("iwyuReport",
+		blueprint.RuleParams{
+			Command:        "(xargs cat < $out.rsp | grep -v 'has correct #includes/fwd-decls' || true) > $out",
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+		})
+)
+
+func (iwyu *iwyuFeature) props() []interface{} {
+	return []interface{}{&iwyu.Properties}
+}
+
+func (iwyu *iwyuFeature) flags(ctx ModuleContext, flags Flags) Flags {
+	CheckBadIwyuFlags(ctx, "iwyu_flags", iwyu.Properties.Iwyu_flags)
+
+	// Check if include-what-you-use is explicitly disabled for this module
+	if iwyu.Properties.Iwyu != nil && !*iwyu.Properties.Iwyu {
+		return flags
+	}
+
+	withIwyu := ctx.Config().IsEnvTrue("WITH_IWYU")
+	if !withIwyu && !Bool(iwyu.Properties.Iwyu) {
+		return flags
+	}
+
+	flags.Iwyu = true
+	flags.IwyuFlags = append(flags.IwyuFlags, checkNinjaAndShellEscapeList(ctx, "iwyu_flags", iwyu.Properties.Iwyu_flags)...)
+	flags.IwyuMappingFiles = android.PathsForModuleSrc(ctx, iwyu.Properties.Iwyu_mapping_files)
+	for _, mappingFile := range flags.IwyuMappingFiles {
+		flags.IwyuFlags = append(flags.IwyuFlags, "-Xiwyu", "--mapping_file="+mappingFile.String())
+	}
+	flags.IwyuAsErrors = !withIwyu && Bool(iwyu.Properties.Iwyu_as_errors)
+	return flags
+}
+
+func init() {
+	android.RegisterSingletonType("iwyu_report", iwyuReportSingletonFactory)
+}
+
+func iwyuReportSingletonFactory() android.Singleton {
+	return &iwyuReportSingleton{}
+}
+
+// iwyuReportSingleton collects the include-what-you-use reports of all modules into
+// iwyu/report.txt, built by the iwyu-report phony target. The reports of a single module are
+// built by the iwyu-<module> phony target.
+type iwyuReportSingleton struct{}
+
+func (s *iwyuReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var allIwyuFiles android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		if module != ctx.FinalModule(module) {
+			return
+		}
+		var iwyuFiles android.Paths
+		ctx.VisitAllModuleVariants(module, func(variant android.Module) {
+			if m, ok := variant.(*Module); ok {
+				iwyuFiles = append(iwyuFiles, m.iwyuFiles...)
+			}
+		})
+		if len(iwyuFiles) == 0 {
+			return
+		}
+		ctx.Phony("iwyu-"+ctx.ModuleName(module), iwyuFiles...)
+		allIwyuFiles = append(allIwyuFiles, iwyuFiles...)
+	})
+	if len(allIwyuFiles) == 0 {
+		return
+	}
+
+	report := android.PathForOutput(ctx, "iwyu", "report.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        iwyuReport,
+		Description: "include-what-you-use report",
+		Output:      report,
+		Inputs:      allIwyuFiles,
+	})
+	ctx.Phony("iwyu-report", report)
+}
@@ -21,7 +21,6 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 
 	"android/soong/android"
 	"android/soong/bazel"
@@ -72,6 +71,12 @@ type LibraryProperties struct {
 		Versions []string
 	}
 
+	// Names of the modules that are allowed to link against the implementation of this library.
+	// Unlike visibility, this doesn't restrict dependencies on the stubs of the library, so it can
+	// be used to limit a platform-private library to a list of known users while other modules
+	// use its stable API. Default is no restriction.
+	Exported_to []string
+
 	// set the name of the output
 	Stem *string `android:"arch_variant"`
 
@@ -118,6 +123,24 @@ type LibraryProperties struct {
 	// Inject boringssl hash into the shared library.  This is only intended for use by external/boringssl.
 	Inject_bssl_hash *bool `android:"arch_variant"`
 
+	// Path to a file listing the symbols that apps look up with dlsym after dlopening this
+	// library, one per line.  These symbols have no NDK stub coverage, so the build fails if the
+	// library stops exporting one of them, or exports a symbol that is not in the list.
+	Dlopen_symbols_file *string `android:"path,arch_variant"`
+
+	// Compile each header in export_include_dirs on its own, so that the build fails if a header
+	// only compiles when the files that include it have included something else first.  Only
+	// supported by cc_library_headers.
+	Verify_self_contained_headers *bool
+
+	// Headers in export_include_dirs that verify_self_contained_headers doesn't compile, for
+	// example headers that may only be included by another header.  Globs are supported.
+	Exclude_self_contained_headers []string `android:"path"`
+
+	// The language verify_self_contained_headers compiles the .h headers as, "c" or "c++".  Defaults
+	// to "c++".  Headers with a C++ extension, like .hpp, are always compiled as C++.
+	Self_contained_headers_language *string
+
 	// If this is an LLNDK library, properties to describe the LLNDK stubs.  Will be copied from
 	// the module pointed to by llndk_stubs if it is set.
 	Llndk llndkLibraryProperties
@@ -497,6 +520,10 @@ type flagExporter struct {
 	flags      []string      // Exported raw flags.
 	deps       android.Paths
 	headers    android.Paths
+
+	// The timestamps of the self-contained header checks of a cc_library_headers, validated by
+	// the links of the modules that depend on it.
+	headerChecks android.Paths
 }
 
 // exportedIncludes returns the effective include paths for this module and
@@ -573,6 +600,9 @@ func (f *flagExporter) setProvider(ctx android.ModuleContext) {
 		// For exported generated headers, such as exported aidl headers, proto headers, or
 		// sysprop headers.
 		GeneratedHeaders: f.headers,
+		// For the checks of verify_self_contained_headers, so that they are built with the
+		// modules that use the headers.
+		HeaderChecks: f.headerChecks,
 	})
 }
 
@@ -1062,8 +1092,16 @@ func (library *libraryDecorator) compile(ctx ModuleContext, flags Flags, deps Pa
 		if len(library.SharedProperties.Shared.Srcs) > 0 {
 			ctx.PropertyErrorf("shared.srcs", "cc_library_headers must not have any srcs")
 		}
+		if Bool(library.Properties.Verify_self_contained_headers) {
+			objs := library.verifySelfContainedHeaders(ctx, flags, deps)
+			library.flagExporter.headerChecks = objs.headerCheckFiles
+			return objs
+		}
 		return Objects{}
 	}
+	if library.Properties.Verify_self_contained_headers != nil {
+		ctx.PropertyErrorf("verify_self_contained_headers", "is only supported by cc_library_headers")
+	}
 	if library.sabi.shouldCreateSourceAbiDump() {
 		exportIncludeDirs := library.flagExporter.exportedIncludes(ctx)
 		var SourceAbiFlags []string
@@ -1127,6 +1165,10 @@ type libraryInterface interface {
 
 	availableFor(string) bool
 
+	// Returns the modules that are allowed to link against the implementation of this library, or
+	// nil if there is no restriction.
+	exportedTo() []string
+
 	getAPIListCoverageXMLPath() android.ModuleOutPath
 
 	installable() *bool
@@ -1196,8 +1238,6 @@ func (library *libraryDecorator) getLibName(ctx BaseModuleContext) string {
 	return name
 }
 
-var versioningMacroNamesListMutex sync.Mutex
-
 func (library *libraryDecorator) linkerInit(ctx BaseModuleContext) {
 	location := InstallInSystem
 	if library.baseLinker.sanitize.inSanitizerDir() {
@@ -1210,14 +1250,9 @@ func (library *libraryDecorator) linkerInit(ctx BaseModuleContext) {
 	library.baseLinker.dynamicProperties.BuildStubs = library.buildStubs()
 
 	if library.buildStubs() {
-		macroNames := versioningMacroNamesList(ctx.Config())
 		myName := versioningMacroName(ctx.ModuleName())
-		versioningMacroNamesListMutex.Lock()
-		defer versioningMacroNamesListMutex.Unlock()
-		if (*macroNames)[myName] == "" {
-			(*macroNames)[myName] = ctx.ModuleName()
-		} else if (*macroNames)[myName] != ctx.ModuleName() {
-			ctx.ModuleErrorf("Macro name %q for versioning conflicts with macro name from module %q ", myName, (*macroNames)[myName])
+		if owner := versioningMacroNamesList.SetIfAbsent(ctx.Config(), myName, ctx.ModuleName()); owner != ctx.ModuleName() {
+			ctx.ModuleErrorf("Macro name %q for versioning conflicts with macro name from module %q ", myName, owner)
 		}
 	}
 }
@@ -1373,7 +1408,7 @@ func (library *libraryDecorator) linkStatic(ctx ModuleContext,
 		}
 	}
 
-	transformObjToStaticLib(ctx, library.objects.objFiles, deps.WholeStaticLibsFromPrebuilts, builderFlags, outputFile, nil, objs.tidyDepFiles)
+	transformObjToStaticLib(ctx, library.objects.objFiles, deps.WholeStaticLibsFromPrebuilts, builderFlags, outputFile, nil, objs.validationFiles())
 
 	library.coverageOutputFile = transformCoverageFilesToZip(ctx, library.objects, ctx.ModuleName())
 
@@ -1461,6 +1496,12 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 		implicitOutputs = append(implicitOutputs, importLibraryPath)
 	}
 
+	var unusedDepsMap android.WritablePath
+	if library.checkUnusedDeps(ctx) && !library.buildStubs() {
+		unusedDepsMap = library.unusedDepsLinkerFlags(ctx, &flags, fileName)
+		implicitOutputs = append(implicitOutputs, unusedDepsMap)
+	}
+
 	builderFlags := flagsToBuilderFlags(flags)
 
 	if ctx.Darwin() && deps.DarwinSecondArchOutput.Valid() {
@@ -1519,9 +1560,18 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 	linkerDeps = append(linkerDeps, deps.EarlySharedLibsDeps...)
 	linkerDeps = append(linkerDeps, deps.SharedLibsDeps...)
 	linkerDeps = append(linkerDeps, deps.LateSharedLibsDeps...)
+
+	validations := objs.validationFiles()
+	if unusedDepsMap != nil {
+		validations = append(validations, library.unusedDepsValidation(ctx, deps, outputFile, unusedDepsMap))
+	}
+	if dlopenSymbols := library.dlopenSymbolsFile(ctx); dlopenSymbols.Valid() {
+		validations = append(validations, checkDlopenSymbols(ctx, tocFile, dlopenSymbols.Path()))
+	}
+
 	transformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs,
 		deps.StaticLibs, deps.LateStaticLibs, deps.WholeStaticLibs,
-		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile, implicitOutputs, objs.tidyDepFiles)
+		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile, implicitOutputs, validations)
 
 	objs.coverageFiles = append(objs.coverageFiles, deps.StaticLibObjs.coverageFiles...)
 	objs.coverageFiles = append(objs.coverageFiles, deps.WholeStaticLibObjs.coverageFiles...)
@@ -1609,6 +1659,17 @@ func getRefAbiDumpFile(ctx ModuleContext, vndkVersion, fileName string) android.
 	return nil
 }
 
+// getRefAbiDumpFileForUpdate returns the path in the output directory that the updated reference
+// dump is written to when reference dumps are updated. It is gzipped if the existing reference dump
+// is, and uncompressed otherwise.
+func getRefAbiDumpFileForUpdate(ctx ModuleContext, vndkVersion, fileName string) android.WritablePath {
+	isNdk := ctx.isNdk(ctx.Config())
+	isLlndkOrVndk := ctx.IsLlndkPublic() || (ctx.useVndk() && ctx.isVndk())
+
+	isGzip := android.PathForVndkRefAbiDump(ctx, vndkVersion, fileName, isNdk, isLlndkOrVndk, true).Valid()
+	return android.PathForVndkRefAbiDumpUpdate(ctx, vndkVersion, fileName, isNdk, isLlndkOrVndk, isGzip)
+}
+
 func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, objs Objects, fileName string, soFile android.Path) {
 	if library.sabi.shouldCreateSourceAbiDump() {
 		var vndkVersion string
@@ -1635,7 +1696,15 @@ func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, objs Objec
 			library.Properties.Header_abi_checker.Exclude_symbol_versions,
 			library.Properties.Header_abi_checker.Exclude_symbol_tags)
 
-		addLsdumpPath(classifySourceAbiDump(ctx) + ":" + library.sAbiOutputFile.String())
+		addLsdumpPath(ctx.Config(), classifySourceAbiDump(ctx)+":"+library.sAbiOutputFile.String())
+
+		// In update mode, write updated reference dumps instead of failing on ABI changes.
+		if ctx.Config().IsEnvTrue("UPDATE_ABI_REFERENCES") {
+			library.sAbiDiff = updateSourceAbiDump(ctx, library.sAbiOutputFile.Path(),
+				getRefAbiDumpFileForUpdate(ctx, vndkVersion, fileName))
+			ctx.Phony("update-abi-references", library.sAbiDiff.Path())
+			return
+		}
 
 		refAbiDumpFile := getRefAbiDumpFile(ctx, vndkVersion, fileName)
 		if refAbiDumpFile != nil {
@@ -1735,6 +1804,12 @@ func (library *libraryDecorator) link(ctx ModuleContext,
 	library.reexportDeps(deps.ReexportedDeps...)
 	library.addExportedGeneratedHeaders(deps.ReexportedGeneratedHeaders...)
 
+	// Export the BMIs of the C++ module interface units, which dependents may import.
+	if len(library.baseCompiler.cppModules) > 0 {
+		library.reexportFlags(cppModuleFlags(library.baseCompiler.cppModules)...)
+		library.reexportDeps(library.baseCompiler.cppModules...)
+	}
+
 	// Optionally export aidl headers.
 	if Bool(library.Properties.Aidl.Export_aidl_headers) {
 		if library.baseCompiler.hasSrcExt(".aidl") {
@@ -2073,6 +2148,10 @@ func (library *libraryDecorator) availableFor(what string) bool {
 	return android.CheckAvailableForApex(what, list)
 }
 
+func (library *libraryDecorator) exportedTo() []string {
+	return library.Properties.Exported_to
+}
+
 func (library *libraryDecorator) installable() *bool {
 	if library.static() {
 		return library.StaticProperties.Static.Installable
@@ -2097,9 +2176,7 @@ func (library *libraryDecorator) getAPIListCoverageXMLPath() android.ModuleOutPa
 	return library.apiListCoverageXmlPath
 }
 
-var versioningMacroNamesListKey = android.NewOnceKey("versioningMacroNamesList")
-
-// versioningMacroNamesList returns a singleton map, where keys are "version macro names",
+// versioningMacroNamesList is a map scoped to the config, where keys are "version macro names",
 // and values are the module name responsible for registering the version macro name.
 //
 // Version macros are used when building against stubs, to provide version information about
@@ -2110,12 +2187,7 @@ var versioningMacroNamesListKey = android.NewOnceKey("versioningMacroNamesList")
 // that are not available for the version).
 //
 // This map is used to ensure that there aren't conflicts between these version macro names.
-func versioningMacroNamesList(config android.Config) *map[string]string {
-	return config.Once(versioningMacroNamesListKey, func() interface{} {
-		m := make(map[string]string)
-		return &m
-	}).(*map[string]string)
-}
+var versioningMacroNamesList = android.NewConfigStringMap("versioningMacroNamesList")
 
 // alphanumeric and _ characters are preserved.
 // other characters are all converted to _
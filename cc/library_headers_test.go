@@ -58,6 +58,95 @@ func TestLibraryHeaders(t *testing.T) {
 	}
 }
 
+func TestVerifySelfContainedHeaders(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureMergeMockFs(android.MockFS{
+			"my_include/a.h":          nil,
+			"my_include/sub/b.hpp":    nil,
+			"my_include/sub/detail.h": nil,
+		}),
+	).RunTestWithBp(t, `
+		cc_library_headers {
+			name: "headers",
+			export_include_dirs: ["my_include"],
+			cflags: ["-DFOO"],
+			verify_self_contained_headers: true,
+			exclude_self_contained_headers: ["my_include/sub/detail.h"],
+		}
+
+		cc_library {
+			name: "libfoo",
+			header_libs: ["headers"],
+		}
+	`)
+
+	headers := result.ModuleForTests("headers", "android_arm64_armv8-a")
+	a := headers.Output("header_check/my_include/a.h.checked")
+	android.AssertStringDoesContain(t, "header check flags", a.Args["cFlags"], "-Imy_include")
+	android.AssertStringDoesContain(t, "header check flags", a.Args["cFlags"], "-DFOO")
+	android.AssertStringEquals(t, "header check language", "c++-header", a.Args["language"])
+	b := headers.Output("header_check/my_include/sub/b.hpp.checked")
+	if headers.MaybeOutput("header_check/my_include/sub/detail.h.checked").Rule != nil {
+		t.Errorf("expected no header check for an excluded header")
+	}
+
+	validations := headers.Output("headers.a").Validations.Strings()
+	android.AssertStringListContains(t, "headers.a validations", validations, a.Output.String())
+	android.AssertStringListContains(t, "headers.a validations", validations, b.Output.String())
+
+	// Nothing builds headers.a, so the links of the modules using the headers validate the checks.
+	link := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld")
+	android.AssertStringListContains(t, "libfoo.so validations", link.Validations.Strings(), a.Output.String())
+	android.AssertStringListContains(t, "libfoo.so validations", link.Validations.Strings(), b.Output.String())
+
+	testCcError(t, `self_contained_headers_language: must be "c" or "c\+\+", not "java"`, `
+		cc_library_headers {
+			name: "headers",
+			verify_self_contained_headers: true,
+			self_contained_headers_language: "java",
+		}
+	`)
+
+	testCcError(t, `verify_self_contained_headers: is only supported by cc_library_headers`, `
+		cc_library_static {
+			name: "libfoo",
+			verify_self_contained_headers: true,
+		}
+	`)
+}
+
+func TestVerifySelfContainedCHeaders(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureMergeMockFs(android.MockFS{
+			"my_include/a.h":   nil,
+			"my_include/b.hpp": nil,
+		}),
+	).RunTestWithBp(t, `
+		cc_library_headers {
+			name: "headers",
+			export_include_dirs: ["my_include"],
+			conlyflags: ["-DCONLY"],
+			cppflags: ["-DCPP"],
+			verify_self_contained_headers: true,
+			self_contained_headers_language: "c",
+		}
+	`)
+
+	headers := result.ModuleForTests("headers", "android_arm64_armv8-a")
+	a := headers.Output("header_check/my_include/a.h.checked")
+	android.AssertStringEquals(t, "C header check language", "c-header", a.Args["language"])
+	android.AssertStringEquals(t, "C header check command", "${config.ClangBin}/clang", a.Args["ccCmd"])
+	android.AssertStringDoesContain(t, "C header check flags", a.Args["cFlags"], "-DCONLY")
+	android.AssertStringDoesNotContain(t, "C header check flags", a.Args["cFlags"], "-DCPP")
+
+	b := headers.Output("header_check/my_include/b.hpp.checked")
+	android.AssertStringEquals(t, "C++ header check language", "c++-header", b.Args["language"])
+	android.AssertStringEquals(t, "C++ header check command", "${config.ClangBin}/clang++", b.Args["ccCmd"])
+	android.AssertStringDoesContain(t, "C++ header check flags", b.Args["cFlags"], "-DCPP")
+}
+
 func TestPrebuiltLibraryHeadersPreferred(t *testing.T) {
 	bp := `
 		cc_library_headers {
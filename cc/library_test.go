@@ -453,3 +453,80 @@ func TestWholeStaticLibPrebuilts(t *testing.T) {
 	android.AssertStringDoesContain(t, "missing flag for baz.o",
 		libtransitiveWithSrcs.Args["arObjs"], bazObj.Output.String())
 }
+
+func TestLibraryShim(t *testing.T) {
+	result := PrepareForIntegrationTestWithCc.RunTestWithBp(t, `
+		cc_library {
+			name: "libplatform",
+			srcs: ["foo.c"],
+		}
+
+		cc_library_shim {
+			name: "libplatform_shim",
+			impl_library: "libplatform",
+			symbol_file: "libplatform_shim.map.txt",
+		}`)
+
+	shim := result.ModuleForTests("libplatform_shim", "android_arm64_armv8-a_shared")
+	check := shim.Rule("shimSymbolCheck")
+	libplatform := result.ModuleForTests("libplatform", "android_arm64_armv8-a_shared").Module().(*Module)
+	android.AssertStringListContains(t, "shim symbol check inputs", check.Implicits.Strings(),
+		libplatform.UnstrippedOutputFile().String())
+	android.AssertStringEquals(t, "shim symbol check symbol file", "libplatform_shim.map.txt",
+		check.Args["symbolFile"])
+
+	forwarders := shim.Output("gen/shim_forwarders.c")
+	android.AssertStringEquals(t, "shim forwarders soname", "libplatform.so", forwarders.Args["implSoname"])
+	android.AssertPathRelativeToTopEquals(t, "shim forwarders input",
+		"out/soong/.intermediates/libplatform_shim/android_arm64_armv8-a_shared/gen/stub.c", forwarders.Input)
+
+	ld := shim.Rule("ld")
+	android.AssertStringDoesContain(t, "shim version script", ld.Args["ldFlags"],
+		"-Wl,--version-script,"+check.Output.String())
+	android.AssertPathsRelativeToTopEquals(t, "shim objects", []string{
+		"out/soong/.intermediates/libplatform_shim/android_arm64_armv8-a_shared/obj/.intermediates/libplatform_shim/android_arm64_armv8-a_shared/gen/shim_forwarders.o",
+	}, ld.Inputs)
+	android.AssertStringDoesContain(t, "shim shared libs", ld.Args["libFlags"], "libplatform.so")
+	android.AssertStringDoesNotContain(t, "shim static libs", ld.Args["libFlags"], "libplatform.a")
+}
+
+func TestAbiReferenceDumps(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			export_include_dirs: ["include"],
+			header_abi_checker: {
+				enabled: true,
+			},
+		}
+	`
+	refDump := "prebuilts/abi-dumps/platform/29/64/arm64/source-based/libfoo.so.lsdump"
+	variant := "android_arm64_armv8-a_shared"
+	preparer := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.Platform_vndk_version = StringPtr("29")
+		}),
+		android.FixtureAddTextFile(refDump, ""),
+	)
+
+	result := preparer.RunTestWithBp(t, bp)
+	diff := result.ModuleForTests("libfoo", variant).Output("libfoo.so.abidiff")
+	android.AssertStringDoesContain(t, "diff should use the reference dump",
+		diff.Args["referenceDump"], refDump)
+
+	result = android.GroupFixturePreparers(
+		preparer,
+		android.FixtureMergeEnv(map[string]string{"UPDATE_ABI_REFERENCES": "true"}),
+	).RunTestWithBp(t, bp)
+	libfoo := result.ModuleForTests("libfoo", variant)
+	if libfoo.MaybeOutput("libfoo.so.abidiff").Rule != nil {
+		t.Errorf("update mode should not diff against the reference dump")
+	}
+	// The updated reference dump is written to the output directory instead of the source tree.
+	update := libfoo.Output("out/soong/abi-references/" + refDump)
+	android.AssertPathRelativeToTopEquals(t, "update input",
+		"out/soong/.intermediates/libfoo/"+variant+"/libfoo.so.lsdump", update.Input)
+	android.AssertStringEquals(t, "reference dump is not compressed", "cat", update.Args["compress"])
+}
@@ -259,7 +259,7 @@ var (
 	// Dependency tag for crtend, an object file responsible for program termination.
 	CrtEndDepTag = dependencyTag{name: "crtend"}
 	// Dependency tag for coverage library.
-	CoverageDepTag = dependencyTag{name: "coverage"}
+	CoverageDepTag = coverageDependencyTag{}
 )
 
 // GetImageVariantType returns the ImageVariantType string value for the given module
@@ -384,6 +384,7 @@ type FlagExporterInfo struct {
 	Flags             []string      // Exported raw flags.
 	Deps              android.Paths
 	GeneratedHeaders  android.Paths
+	HeaderChecks      android.Paths // Timestamps of the self-contained header checks.
 }
 
 var FlagExporterInfoProvider = blueprint.NewProvider(FlagExporterInfo{})
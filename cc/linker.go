@@ -16,6 +16,7 @@ package cc
 
 import (
 	"fmt"
+	"strings"
 
 	"android/soong/android"
 	"android/soong/cc/config"
@@ -227,6 +228,11 @@ type BaseLinkerProperties struct {
 	// local file name to pass to the linker as --dynamic-list
 	Dynamic_list *string `android:"path,arch_variant"`
 
+	// add a validation that fails the build if any of the shared_libs provides no symbols to this
+	// module, or if any of the static_libs contributes no sections that are retained in the output.
+	// The module is linked with --no-allow-shlib-undefined when this is set.
+	Check_unused_deps *bool `android:"arch_variant"`
+
 	// list of static libs that should not be used to build this module
 	Exclude_static_libs []string `android:"arch_variant"`
 
@@ -477,7 +483,7 @@ func (linker *baseLinker) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 		flags.Global.LdFlags = append(flags.Global.LdFlags, fmt.Sprintf("${config.%sGlobalLldflags}", hod))
 		if !BoolDefault(linker.Properties.Pack_relocations, packRelocationsDefault) {
 			flags.Global.LdFlags = append(flags.Global.LdFlags, "-Wl,--pack-dyn-relocs=none")
-		} else if ctx.Device() {
+		} else if ctx.Os() == android.Android {
 			// SHT_RELR relocations are only supported at API level >= 30.
 			// ANDROID_RELR relocations were supported at API level >= 28.
 			// Relocation packer was supported at API level >= 23.
@@ -510,7 +516,7 @@ func (linker *baseLinker) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 		flags.Global.LdFlags = append(flags.Global.LdFlags, toolchain.Ldflags())
 	}
 
-	if !ctx.toolchain().Bionic() && ctx.Os() != android.LinuxMusl {
+	if ctx.Host() && !ctx.toolchain().Bionic() && ctx.Os() != android.LinuxMusl {
 		CheckBadHostLdlibs(ctx, "host_ldlibs", linker.Properties.Host_ldlibs)
 
 		flags.Local.LdFlags = append(flags.Local.LdFlags, linker.Properties.Host_ldlibs...)
@@ -655,3 +661,69 @@ func (linker *baseLinker) injectVersionSymbol(ctx ModuleContext, in android.Path
 		},
 	})
 }
+
+// Checking for unused dependencies
+// Modules that set check_unused_deps are linked with a linker map, which is analyzed together with
+// the dynamic symbols of the output to find shared_libs and static_libs that can be removed.
+
+func init() {
+	pctx.HostBinToolVariable("checkUnusedDepsCmd", "check_unused_cc_deps")
+}
+
+var checkUnusedDeps = pctx.AndroidStaticRule("checkUnusedDeps",
+	blueprint.RuleParams{
+		Command: "$checkUnusedDepsCmd --readelf ${config.ClangBin}/llvm-readelf --module $module " +
+			"--map $map $libFlags -o $out $in",
+		CommandDeps: []string{"$checkUnusedDepsCmd", "${config.ClangBin}/llvm-readelf"},
+	},
+	"module", "map", "libFlags")
+
+func (linker *baseLinker) checkUnusedDeps(ctx ModuleContext) bool {
+	return Bool(linker.Properties.Check_unused_deps) && !ctx.Darwin() && !ctx.Windows()
+}
+
+// unusedDepsLinkerFlags adds the linker flags needed to check for unused dependencies, and returns
+// the path of the linker map that the link step will write.
+func (linker *baseLinker) unusedDepsLinkerFlags(ctx ModuleContext, flags *Flags, fileName string) android.WritablePath {
+	mapFile := android.PathForModuleOut(ctx, "unused_deps", fileName+".map")
+	flags.Local.LdFlags = append(flags.Local.LdFlags,
+		"-Wl,-Map="+mapFile.String(),
+		"-Wl,--no-allow-shlib-undefined")
+	return mapFile
+}
+
+// unusedDepsValidation creates a rule that checks the linked output for unused shared_libs and
+// static_libs, and returns the path to its report for use as a validation of the link step.
+func (linker *baseLinker) unusedDepsValidation(ctx ModuleContext, deps PathDeps, linked, mapFile android.Path) android.Path {
+	report := android.PathForModuleOut(ctx, "unused_deps", "unused_deps.txt")
+
+	var libFlags []string
+	var implicits android.Paths
+	for _, dep := range deps.DeclaredSharedLibs {
+		if inList(dep.name, linker.Properties.Shared_libs) {
+			libFlags = append(libFlags, "--shared-lib "+dep.name+"="+dep.path.String())
+			implicits = append(implicits, dep.path)
+		}
+	}
+	for _, dep := range deps.DeclaredStaticLibs {
+		if inList(dep.name, linker.Properties.Static_libs) {
+			libFlags = append(libFlags, "--static-lib "+dep.name+"="+dep.path.String())
+			implicits = append(implicits, dep.path)
+		}
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkUnusedDeps,
+		Description: "check unused deps",
+		Input:       linked,
+		Implicit:    mapFile,
+		Implicits:   implicits,
+		Output:      report,
+		Args: map[string]string{
+			"module":   ctx.ModuleName(),
+			"map":      mapFile.String(),
+			"libFlags": strings.Join(libFlags, " "),
+		},
+	})
+	return report
+}
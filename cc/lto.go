@@ -15,6 +15,9 @@
 package cc
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
@@ -58,8 +61,55 @@ type LTOProperties struct {
 
 	// Use -fwhole-program-vtables cflag.
 	Whole_program_vtables *bool
+
+	// Limit on the size, in LLVM IR instructions, of functions that ThinLTO imports across
+	// translation units. Defaults to 5 for modules without a PGO or AFDO profile, or to the
+	// THINLTO_IMPORT_INSTR_LIMIT environment variable if it is set, and to the LLVM default for
+	// modules with a profile.
+	Import_instr_limit *int64 `android:"arch_variant"`
 }
 
+// linkCost is the estimated memory cost of a link action. LTO links run the optimizer over the
+// IR of the whole module, so running many of them in parallel can exhaust the RAM of the build
+// machine. Their rules run in pools with a depth derived from the RAM instead.
+type linkCost int
+
+const (
+	// Links of native object files, which run at full parallelism.
+	linkCostDefault linkCost = iota
+
+	// ThinLTO links, which optimize the module in bounded partitions. They run in the
+	// lto_link_pool.
+	linkCostThinLto
+
+	// Full LTO links, which hold the IR of the whole module in memory. They run in the
+	// highmem_pool.
+	linkCostFullLto
+)
+
+// ThinLTO links with at least this many objects and static libraries are as expensive as full LTO
+// links.
+const heavyThinLtoLinkInputs = 500
+
+// estimateLinkCost returns the cost of a link from the LTO mode of the module and the number of
+// its object and static library inputs.
+func estimateLinkCost(cost linkCost, numInputs int) linkCost {
+	if cost == linkCostThinLto && numInputs >= heavyThinLtoLinkInputs {
+		return linkCostFullLto
+	}
+	return cost
+}
+
+const (
+	// The ThinLTO cache is limited to the lesser of 10% of available disk space and 10GB,
+	// unless THINLTO_CACHE_POLICY overrides it.
+	defaultThinLTOCachePolicy = "cache_size=10%:cache_size_bytes=10g"
+
+	// Cross TU inline limit for modules without a profile, unless THINLTO_IMPORT_INSTR_LIMIT
+	// overrides it.
+	defaultImportInstrLimit = 5
+)
+
 type lto struct {
 	Properties LTOProperties
 }
@@ -104,6 +154,12 @@ func (lto *lto) flags(ctx BaseModuleContext, flags Flags) Flags {
 		flags.Local.LdFlags = append(flags.Local.LdFlags, ltoCFlag)
 		flags.Local.LdFlags = append(flags.Local.LdFlags, ltoLdFlag)
 
+		if lto.FullLTO() {
+			flags.LinkCost = linkCostFullLto
+		} else {
+			flags.LinkCost = linkCostThinLto
+		}
+
 		if Bool(lto.Properties.Whole_program_vtables) {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-fwhole-program-vtables")
 		}
@@ -114,23 +170,82 @@ func (lto *lto) flags(ctx BaseModuleContext, flags Flags) Flags {
 			cacheDir := android.PathForOutput(ctx, "thinlto-cache").String()
 			flags.Local.LdFlags = append(flags.Local.LdFlags, cacheDirFormat+cacheDir)
 
-			// Limit the size of the ThinLTO cache to the lesser of 10% of available
-			// disk space and 10GB.
+			// Limit the size of the ThinLTO cache, pruning the least recently used entries.
 			cachePolicyFormat := "-Wl,--thinlto-cache-policy="
-			policy := "cache_size=10%:cache_size_bytes=10g"
+			policy := ctx.Config().GetenvWithDefault("THINLTO_CACHE_POLICY", defaultThinLTOCachePolicy)
 			flags.Local.LdFlags = append(flags.Local.LdFlags, cachePolicyFormat+policy)
 		}
 
-		// If the module does not have a profile, be conservative and limit cross TU inline
-		// limit to 5 LLVM IR instructions, to balance binary size increase and performance.
-		if !ctx.isPgoCompile() && !ctx.isAfdoCompile() {
+		if limit, ok := lto.importInstrLimit(ctx); ok {
 			flags.Local.LdFlags = append(flags.Local.LdFlags,
-				"-Wl,-plugin-opt,-import-instr-limit=5")
+				"-Wl,-plugin-opt,-import-instr-limit="+strconv.FormatInt(limit, 10))
 		}
 	}
 	return flags
 }
 
+// importInstrLimit returns the cross TU inline limit of the module, and false if the LLVM
+// default should be used.
+func (lto *lto) importInstrLimit(ctx BaseModuleContext) (int64, bool) {
+	if limit := lto.Properties.Import_instr_limit; limit != nil {
+		if *limit < 0 {
+			ctx.PropertyErrorf("import_instr_limit", "must not be negative, got %d", *limit)
+			return 0, false
+		}
+		return *limit, true
+	}
+
+	// If the module has a profile, let it guide the inlining decisions.
+	if ctx.isPgoCompile() || ctx.isAfdoCompile() {
+		return 0, false
+	}
+
+	// Otherwise be conservative and limit cross TU inlining to balance binary size increase and
+	// performance.
+	return envImportInstrLimit(ctx.Config()).limit, true
+}
+
+var envImportInstrLimitKey = android.NewOnceKey("envImportInstrLimit")
+
+type envImportInstrLimitResult struct {
+	limit int64
+	err   error
+}
+
+// envImportInstrLimit returns the cross TU inline limit of the modules without a profile, from
+// THINLTO_IMPORT_INSTR_LIMIT if it is set. The variable is parsed once, an invalid value is
+// reported by the thinlto_import_instr_limit singleton and the default limit is used instead.
+func envImportInstrLimit(config android.Config) envImportInstrLimitResult {
+	return config.Once(envImportInstrLimitKey, func() interface{} {
+		env := config.Getenv("THINLTO_IMPORT_INSTR_LIMIT")
+		if env == "" {
+			return envImportInstrLimitResult{limit: defaultImportInstrLimit}
+		}
+		limit, err := strconv.ParseInt(env, 10, 64)
+		if err != nil || limit < 0 {
+			return envImportInstrLimitResult{
+				limit: defaultImportInstrLimit,
+				err:   fmt.Errorf("THINLTO_IMPORT_INSTR_LIMIT must be a non-negative integer, got %q", env),
+			}
+		}
+		return envImportInstrLimitResult{limit: limit}
+	}).(envImportInstrLimitResult)
+}
+
+func thinltoImportInstrLimitSingletonFactory() android.Singleton {
+	return &thinltoImportInstrLimitSingleton{}
+}
+
+// thinltoImportInstrLimitSingleton reports an invalid THINLTO_IMPORT_INSTR_LIMIT once, instead of
+// on every module that uses it.
+type thinltoImportInstrLimitSingleton struct{}
+
+func (s *thinltoImportInstrLimitSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if err := envImportInstrLimit(ctx.Config()).err; err != nil {
+		ctx.Errorf("%s", err)
+	}
+}
+
 func (lto *lto) LTO(ctx BaseModuleContext) bool {
 	return lto.ThinLTO() || lto.FullLTO() || lto.DefaultThinLTO(ctx)
 }
@@ -0,0 +1,148 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+const ltoTestBp = `
+	cc_binary {
+		name: "bin_default",
+		srcs: ["foo.c"],
+		lto: {
+			thin: true,
+		},
+	}
+
+	cc_binary {
+		name: "bin_limit",
+		srcs: ["foo.c"],
+		lto: {
+			thin: true,
+		},
+		import_instr_limit: 40,
+	}
+`
+
+func TestThinLTOCacheAndImportInstrLimit(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureMergeEnv(map[string]string{
+			"USE_THINLTO_CACHE":    "true",
+			"THINLTO_CACHE_POLICY": "cache_size_bytes=1g",
+		}),
+	).RunTestWithBp(t, ltoTestBp)
+
+	variant := "android_arm64_armv8-a"
+	defaultLdFlags := result.ModuleForTests("bin_default", variant).Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "cache dir", defaultLdFlags, "-Wl,--thinlto-cache-dir=out/soong/thinlto-cache")
+	android.AssertStringDoesContain(t, "cache policy", defaultLdFlags, "-Wl,--thinlto-cache-policy=cache_size_bytes=1g")
+	android.AssertStringDoesContain(t, "default import limit", defaultLdFlags, "-import-instr-limit=5")
+
+	limitLdFlags := result.ModuleForTests("bin_limit", variant).Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "import limit property", limitLdFlags, "-import-instr-limit=40")
+	android.AssertStringDoesNotContain(t, "default import limit", limitLdFlags, "-import-instr-limit=5 ")
+}
+
+func TestThinLTOImportInstrLimitFromEnv(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureMergeEnv(map[string]string{
+			"THINLTO_IMPORT_INSTR_LIMIT": "10",
+		}),
+	).RunTestWithBp(t, ltoTestBp)
+
+	variant := "android_arm64_armv8-a"
+	defaultLdFlags := result.ModuleForTests("bin_default", variant).Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "import limit from env", defaultLdFlags, "-import-instr-limit=10")
+	android.AssertStringDoesNotContain(t, "cache is off by default", defaultLdFlags, "--thinlto-cache-dir")
+
+	limitLdFlags := result.ModuleForTests("bin_limit", variant).Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "property overrides env", limitLdFlags, "-import-instr-limit=40")
+}
+
+func TestThinLTOImportInstrLimitErrors(t *testing.T) {
+	prepareForCcTest.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`import_instr_limit: must not be negative, got -1`)).
+		RunTestWithBp(t, `
+		cc_binary {
+			name: "bin",
+			srcs: ["foo.c"],
+			lto: {
+				thin: true,
+			},
+			import_instr_limit: -1,
+		}
+	`)
+}
+
+func TestThinLTOImportInstrLimitFromEnvErrors(t *testing.T) {
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureMergeEnv(map[string]string{
+			"THINLTO_IMPORT_INSTR_LIMIT": "-1",
+		}),
+	).
+		ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+			`THINLTO_IMPORT_INSTR_LIMIT must be a non-negative integer, got "-1"`,
+		})).
+		RunTestWithBp(t, ltoTestBp)
+}
+
+func TestLtoLinkPools(t *testing.T) {
+	result := prepareForCcTest.RunTestWithBp(t, `
+		cc_binary {
+			name: "bin_thin",
+			srcs: ["foo.c"],
+			lto: {
+				thin: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_full",
+			srcs: ["foo.c"],
+			lto: {
+				full: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_lto",
+			srcs: ["foo.c"],
+		}
+	`)
+
+	variant := "android_arm64_armv8-a"
+	linkRule := func(name string) string {
+		return result.ModuleForTests(name, variant).Output("unstripped/" + name).Rule.String()
+	}
+	android.AssertStringDoesContain(t, "ThinLTO link", linkRule("bin_thin"), "ldThinLto")
+	android.AssertStringDoesContain(t, "full LTO link", linkRule("bin_full"), "ldFullLto")
+	android.AssertStringDoesNotContain(t, "link without LTO", linkRule("bin_no_lto"), "Lto")
+}
+
+func TestEstimateLinkCost(t *testing.T) {
+	android.AssertIntEquals(t, "small ThinLTO link", int(linkCostThinLto),
+		int(estimateLinkCost(linkCostThinLto, 10)))
+	android.AssertIntEquals(t, "large ThinLTO link", int(linkCostFullLto),
+		int(estimateLinkCost(linkCostThinLto, heavyThinLtoLinkInputs)))
+	android.AssertIntEquals(t, "large link without LTO", int(linkCostDefault),
+		int(estimateLinkCost(linkCostDefault, heavyThinLtoLinkInputs)))
+}
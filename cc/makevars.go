@@ -115,8 +115,7 @@ func makeVarsProvider(ctx android.MakeVarsContext) {
 	sort.Strings(exportedVendorPublicLibraries)
 	ctx.Strict("VENDOR_PUBLIC_LIBRARIES", strings.Join(exportedVendorPublicLibraries, " "))
 
-	sort.Strings(lsdumpPaths)
-	ctx.Strict("LSDUMP_PATHS", strings.Join(lsdumpPaths, " "))
+	ctx.Strict("LSDUMP_PATHS", strings.Join(lsdumpPaths.SortedGet(ctx.Config()), " "))
 
 	ctx.Strict("ANDROID_WARNING_ALLOWED_PROJECTS", makeStringOfWarningAllowedProjects())
 	ctx.Strict("SOONG_MODULES_ADDED_WALL", makeStringOfKeys(ctx, modulesAddedWallKey))
@@ -162,9 +161,7 @@ func makeVarsProvider(ctx android.MakeVarsContext) {
 	ctx.StrictRaw("SRC_HEADERS", strings.Join(includes, " "))
 	ctx.StrictRaw("SRC_SYSTEM_HEADERS", strings.Join(systemIncludes, " "))
 
-	ndkKnownLibs := *getNDKKnownLibs(ctx.Config())
-	sort.Strings(ndkKnownLibs)
-	ctx.Strict("NDK_KNOWN_LIBS", strings.Join(ndkKnownLibs, " "))
+	ctx.Strict("NDK_KNOWN_LIBS", strings.Join(ndkKnownLibs.SortedGet(ctx.Config()), " "))
 
 	hostTargets := ctx.Config().Targets[ctx.Config().BuildOS]
 	makeVarsToolchain(ctx, "", hostTargets[0])
@@ -19,7 +19,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
@@ -69,9 +68,7 @@ var (
 
 	ndkLibrarySuffix = ".ndk"
 
-	ndkKnownLibsKey = android.NewOnceKey("ndkKnownLibsKey")
-	// protects ndkKnownLibs writes during parallel BeginMutator.
-	ndkKnownLibsLock sync.Mutex
+	ndkKnownLibs = android.NewConfigStringList("ndkKnownLibs")
 
 	stubImplementation = dependencyTag{name: "stubImplementation"}
 )
@@ -190,12 +187,6 @@ func (this *stubDecorator) initializeProperties(ctx BaseModuleContext) bool {
 	return true
 }
 
-func getNDKKnownLibs(config android.Config) *[]string {
-	return config.Once(ndkKnownLibsKey, func() interface{} {
-		return &[]string{}
-	}).(*[]string)
-}
-
 func (c *stubDecorator) compilerInit(ctx BaseModuleContext) {
 	c.baseCompiler.compilerInit(ctx)
 
@@ -204,15 +195,7 @@ func (c *stubDecorator) compilerInit(ctx BaseModuleContext) {
 		ctx.PropertyErrorf("name", "Do not append %q manually, just use the base name", ndkLibrarySuffix)
 	}
 
-	ndkKnownLibsLock.Lock()
-	defer ndkKnownLibsLock.Unlock()
-	ndkKnownLibs := getNDKKnownLibs(ctx.Config())
-	for _, lib := range *ndkKnownLibs {
-		if lib == name {
-			return
-		}
-	}
-	*ndkKnownLibs = append(*ndkKnownLibs, name)
+	ndkKnownLibs.AddUnique(ctx.Config(), name)
 }
 
 var stubLibraryCompilerFlags = []string{
@@ -0,0 +1,164 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"encoding/json"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+func init() {
+	registerOdrCheckBuildComponents(android.InitRegistrationContext)
+}
+
+func registerOdrCheckBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("odr_check", odrCheckSingletonFactory)
+}
+
+var PrepareForTestWithOdrCheck = android.FixtureRegisterWithContext(registerOdrCheckBuildComponents)
+
+func odrCheckSingletonFactory() android.Singleton {
+	return &odrCheckSingleton{}
+}
+
+// odrCheckSingleton writes odr/report.txt, built by the odr-check phony target. A process that
+// loads a shared library from an APEX, in the linker namespace of the APEX, also loads the
+// platform libraries that the namespace links to, which are the libraries that any library of
+// the APEX links against through their stubs, and the libraries that these load in turn from the
+// system namespace. The report lists the symbols that are defined both by a library of an APEX
+// and by one of these platform libraries, usually because the same static library is linked into
+// both: exported symbols defined twice are ODR violations, and hidden C++ variables defined twice
+// are duplicated state. Libraries loaded both from the APEX and from the platform, like libc++,
+// are skipped. With ODR_CHECK_AS_ERRORS=true, building the report fails if it isn't empty.
+type odrCheckSingleton struct{}
+
+// odrCheckLib is a library loaded in a process context, in the contexts file of odr_check.
+type odrCheckLib struct {
+	Module   string `json:"module"`
+	Location string `json:"location"`
+	File     string `json:"file"`
+}
+
+func (s *odrCheckSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	// The platform variant of each shared library, keyed by name and architecture. When there are
+	// several, such as sanitized variants, the one with the shortest variation is used.
+	platformLibs := make(map[string]*Module)
+	var apexLibs []*Module
+	ctx.VisitAllModules(func(module android.Module) {
+		m, ok := module.(*Module)
+		if !ok || !m.Enabled() || !m.Device() || !m.Shared() || m.IsStubs() {
+			return
+		}
+		if m.UnstrippedOutputFile() == nil {
+			return
+		}
+		apexInfo := ctx.ModuleProvider(m, android.ApexInfoProvider).(android.ApexInfo)
+		if !apexInfo.IsForPlatform() {
+			apexLibs = append(apexLibs, m)
+			return
+		}
+		key := ctx.ModuleName(m) + "/" + m.Target().Arch.ArchType.String()
+		if other, ok := platformLibs[key]; !ok || len(ctx.ModuleSubDir(m)) < len(ctx.ModuleSubDir(other)) {
+			platformLibs[key] = m
+		}
+	})
+
+	contexts := make(map[string][]odrCheckLib)
+	var files android.Paths
+	addLib := func(context string, m *Module, location string) {
+		file := m.UnstrippedOutputFile()
+		for _, lib := range contexts[context] {
+			if lib.File == file.String() {
+				return
+			}
+		}
+		contexts[context] = append(contexts[context], odrCheckLib{
+			Module:   ctx.ModuleName(m),
+			Location: location,
+			File:     file.String(),
+		})
+		files = append(files, file)
+	}
+	// The platform libraries the linker namespace of each context links to, from the stubs that
+	// the libraries of the APEX link against.
+	linkedLibs := make(map[string][]*Module)
+	for _, m := range apexLibs {
+		arch := m.Target().Arch.ArchType.String()
+		apexInfo := ctx.ModuleProvider(m, android.ApexInfoProvider).(android.ApexInfo)
+		for _, apex := range apexInfo.InApexVariants {
+			context := apex + "/" + arch
+			addLib(context, m, "apex")
+			ctx.VisitDirectDeps(m, func(dep android.Module) {
+				if d, ok := dep.(*Module); ok && d.IsStubs() {
+					if platformLib, ok := platformLibs[ctx.ModuleName(d)+"/"+arch]; ok {
+						linkedLibs[context] = append(linkedLibs[context], platformLib)
+					}
+				}
+			})
+		}
+	}
+
+	// The linked platform libraries are loaded in the system namespace, along with the shared
+	// libraries they depend on.
+	for _, context := range android.SortedStringKeys(linkedLibs) {
+		arch := linkedLibs[context][0].Target().Arch.ArchType.String()
+		visited := make(map[*Module]bool)
+		queue := linkedLibs[context]
+		for len(queue) > 0 {
+			m := queue[0]
+			queue = queue[1:]
+			if visited[m] {
+				continue
+			}
+			visited[m] = true
+			addLib(context, m, "platform")
+			ctx.VisitDirectDeps(m, func(dep android.Module) {
+				if d, ok := dep.(*Module); ok && d.Shared() {
+					if platformLib, ok := platformLibs[ctx.ModuleName(d)+"/"+arch]; ok {
+						queue = append(queue, platformLib)
+					}
+				}
+			})
+		}
+	}
+	if len(contexts) == 0 {
+		return
+	}
+
+	data, err := json.MarshalIndent(contexts, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to marshal the ODR check contexts: %s", err)
+		return
+	}
+	contextsFile := android.PathForOutput(ctx, "odr", "contexts.json")
+	android.WriteFileRule(ctx, contextsFile, string(data))
+
+	report := android.PathForOutput(ctx, "odr", "report.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().
+		BuiltTool("odr_check").
+		FlagWithInput("--readelf ", config.ClangPath(ctx, "bin/llvm-readelf")).
+		FlagWithInput("--cxxfilt ", config.ClangPath(ctx, "bin/llvm-cxxfilt")).
+		FlagWithInput("--contexts ", contextsFile).
+		Implicits(android.FirstUniquePaths(files))
+	if ctx.Config().IsEnvTrue("ODR_CHECK_AS_ERRORS") {
+		cmd.Flag("--as-errors")
+	}
+	cmd.FlagWithOutput("-o ", report)
+	rule.Build("odr_check", "check symbols defined in APEX and platform libraries")
+	ctx.Phony("odr-check", report)
+}
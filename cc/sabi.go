@@ -15,16 +15,11 @@
 package cc
 
 import (
-	"sync"
-
 	"android/soong/android"
 	"android/soong/cc/config"
 )
 
-var (
-	lsdumpPaths     []string
-	lsdumpPathsLock sync.Mutex
-)
+var lsdumpPaths = android.NewConfigStringList("lsdumpPaths")
 
 type SAbiProperties struct {
 	// Whether ABI dump should be created for this module.
@@ -193,10 +188,8 @@ func sabiDepsMutator(mctx android.TopDownMutatorContext) {
 	}
 }
 
-// Add an entry to the global list of lsdump. The list is exported to a Make variable by
+// Add an entry to the list of lsdump for the config. The list is exported to a Make variable by
 // `cc.makeVarsProvider`.
-func addLsdumpPath(lsdumpPath string) {
-	lsdumpPathsLock.Lock()
-	defer lsdumpPathsLock.Unlock()
-	lsdumpPaths = append(lsdumpPaths, lsdumpPath)
+func addLsdumpPath(config android.Config, lsdumpPath string) {
+	lsdumpPaths.Add(config, lsdumpPath)
 }
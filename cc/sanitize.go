@@ -17,6 +17,7 @@ package cc
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -77,6 +78,10 @@ var (
 		"-fno-sanitize-recover=integer,undefined"}
 	hwasanGlobalOptions = []string{"heap_history_size=1023", "stack_history_size=512",
 		"export_memory_stats=0", "max_malloc_fill_size=4096", "malloc_fill_byte=0"}
+
+	// Stack tagging is done by the compiler, so the memtag extension must be enabled when
+	// compiling, assembling and (for LTO) linking.
+	memtagStackCommonFlags = []string{"-march=armv8-a+memtag"}
 )
 
 type SanitizerType int
@@ -89,6 +94,7 @@ const (
 	scs
 	Fuzzer
 	Memtag_heap
+	Memtag_stack
 	cfi // cfi is last to prevent it running before incompatible mutators
 )
 
@@ -100,6 +106,7 @@ var Sanitizers = []SanitizerType{
 	scs,
 	Fuzzer,
 	Memtag_heap,
+	Memtag_stack,
 	cfi, // cfi is last to prevent it running before incompatible mutators
 }
 
@@ -120,6 +127,8 @@ func (t SanitizerType) variationName() string {
 		return "scs"
 	case Memtag_heap:
 		return "memtag_heap"
+	case Memtag_stack:
+		return "memtag_stack"
 	case Fuzzer:
 		return "fuzzer"
 	default:
@@ -136,6 +145,8 @@ func (t SanitizerType) name() string {
 		return "hwaddress"
 	case Memtag_heap:
 		return "memtag_heap"
+	case Memtag_stack:
+		return "memtag_stack"
 	case tsan:
 		return "thread"
 	case intOverflow:
@@ -153,7 +164,7 @@ func (t SanitizerType) name() string {
 
 func (t SanitizerType) registerMutators(ctx android.RegisterMutatorsContext) {
 	switch t {
-	case Asan, Hwasan, Fuzzer, scs, tsan, cfi:
+	case Asan, Hwasan, Fuzzer, scs, tsan, cfi, Memtag_stack:
 		ctx.TopDown(t.variationName()+"_deps", sanitizerDepsMutator(t))
 		ctx.BottomUp(t.variationName(), sanitizerMutator(t))
 	case Memtag_heap, intOverflow:
@@ -181,6 +192,8 @@ func (*Module) SanitizerSupported(t SanitizerType) bool {
 		return true
 	case Memtag_heap:
 		return true
+	case Memtag_stack:
+		return true
 	default:
 		return false
 	}
@@ -232,6 +245,9 @@ type SanitizeUserProps struct {
 	// Memory-tagging, only available on arm64
 	// if diag.memtag unset or false, enables async memory tagging
 	Memtag_heap *bool `android:"arch_variant"`
+	// Stack memory-tagging, only available on arm64
+	// the tagging mode of the process follows diag.memtag_heap and diag.memtag_stack
+	Memtag_stack *bool `android:"arch_variant"`
 
 	// A modifier for ASAN and HWASAN for write only instrumentation
 	Writeonly *bool `android:"arch_variant"`
@@ -250,6 +266,10 @@ type SanitizeUserProps struct {
 		// requires sanitizer.memtag: true
 		// if set, enables sync memory tagging
 		Memtag_heap *bool `android:"arch_variant"`
+		// Stack memory-tagging, only available on arm64
+		// requires sanitizer.memtag_stack: true
+		// if set, enables sync memory tagging
+		Memtag_stack *bool `android:"arch_variant"`
 		// List of specific undefined behavior sanitizers to enable in diagnostic mode
 		Misc_undefined []string `android:"arch_variant"`
 		// List of sanitizers to pass to -fno-sanitize-recover
@@ -269,8 +289,14 @@ type SanitizeUserProps struct {
 	// the first one
 	Recover []string
 
-	// value to pass to -fsanitize-ignorelist
-	Blocklist *string
+	// Path or module reference to the sanitizer special case list(s) to pass to
+	// -fsanitize-ignorelist. If a module is referenced, all of its output files are used.
+	Blocklist *string `android:"path"`
+
+	// Path or module reference to the sanitizer special case list(s) that are only passed to
+	// -fsanitize-ignorelist when building the apex variants of the module, in addition to
+	// blocklist.
+	Apex_blocklist *string `android:"path"`
 }
 
 type SanitizeProperties struct {
@@ -283,6 +309,9 @@ type SanitizeProperties struct {
 	InSanitizerDir    bool              `blueprint:"mutated"`
 	Sanitizers        []string          `blueprint:"mutated"`
 	DiagSanitizers    []string          `blueprint:"mutated"`
+
+	// Why CFI is enabled or disabled for this variant, for the CFI report.
+	CfiReason string `blueprint:"mutated"`
 }
 
 type sanitize struct {
@@ -313,11 +342,32 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		s.Never = BoolPtr(true)
 	}
 
+	// The sanitizer runtimes aren't available for baremetal targets.
+	if ctx.Os() == android.Baremetal {
+		s.Never = BoolPtr(true)
+	}
+
 	// Never always wins.
 	if Bool(s.Never) {
+		sanitize.Properties.CfiReason = "sanitizers disabled for the module"
 		return
 	}
 
+	if Bool(s.Cfi) {
+		sanitize.Properties.CfiReason = "enabled by sanitize.cfi"
+	} else if s.Cfi != nil {
+		sanitize.Properties.CfiReason = "disabled by sanitize.cfi"
+	}
+
+	// disableCfi turns off CFI, recording the reason if it had been enabled.
+	disableCfi := func(reason string) {
+		if Bool(s.Cfi) {
+			sanitize.Properties.CfiReason = reason
+		}
+		s.Cfi = nil
+		s.Diag.Cfi = nil
+	}
+
 	// cc_test targets default to SYNC MemTag unless explicitly set to ASYNC (via diag: {memtag_heap}).
 	if ctx.testBinary() {
 		if s.Memtag_heap == nil {
@@ -372,6 +422,7 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		if found, globalSanitizers = removeFromList("cfi", globalSanitizers); found && s.Cfi == nil {
 			if !ctx.Config().CFIDisabledForPath(ctx.ModuleDir()) {
 				s.Cfi = proptools.BoolPtr(true)
+				sanitize.Properties.CfiReason = "enabled by SANITIZE_TARGET"
 			}
 		}
 
@@ -404,6 +455,12 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 			}
 		}
 
+		if found, globalSanitizers = removeFromList("memtag_stack", globalSanitizers); found && s.Memtag_stack == nil {
+			if !ctx.Config().MemtagStackDisabledForPath(ctx.ModuleDir()) {
+				s.Memtag_stack = proptools.BoolPtr(true)
+			}
+		}
+
 		if len(globalSanitizers) > 0 {
 			ctx.ModuleErrorf("unknown global sanitizer option %s", globalSanitizers[0])
 		}
@@ -424,6 +481,11 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 			s.Diag.Memtag_heap = proptools.BoolPtr(true)
 		}
 
+		if found, globalSanitizersDiag = removeFromList("memtag_stack", globalSanitizersDiag); found &&
+			s.Diag.Memtag_stack == nil && Bool(s.Memtag_stack) {
+			s.Diag.Memtag_stack = proptools.BoolPtr(true)
+		}
+
 		if len(globalSanitizersDiag) > 0 {
 			ctx.ModuleErrorf("unknown global sanitizer diagnostics option %s", globalSanitizersDiag[0])
 		}
@@ -443,20 +505,31 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 				s.Memtag_heap = proptools.BoolPtr(true)
 			}
 		}
+		if s.Memtag_stack == nil && ctx.Config().MemtagStackEnabledForPath(ctx.ModuleDir()) {
+			s.Memtag_stack = proptools.BoolPtr(true)
+		}
 	}
 
 	// Enable CFI for non-host components in the include paths
 	if s.Cfi == nil && ctx.Config().CFIEnabledForPath(ctx.ModuleDir()) && !ctx.Host() {
 		s.Cfi = proptools.BoolPtr(true)
+		sanitize.Properties.CfiReason = "enabled by CFIIncludePaths"
 		if inList("cfi", ctx.Config().SanitizeDeviceDiag()) {
 			s.Diag.Cfi = proptools.BoolPtr(true)
 		}
 	}
+	if s.Cfi == nil && sanitize.Properties.CfiReason == "" && ctx.Config().CFIDisabledForPath(ctx.ModuleDir()) {
+		sanitize.Properties.CfiReason = "excluded by CFIExcludePaths"
+	}
 
 	// Is CFI actually enabled?
 	if !ctx.Config().EnableCFI() {
-		s.Cfi = nil
-		s.Diag.Cfi = nil
+		disableCfi("disabled by EnableCFI")
+	}
+
+	// Restrict CFI for device components to the architectures selected by the product.
+	if !ctx.Host() && !ctx.Config().CFIEnabledForArch(ctx.Arch().ArchType) {
+		disableCfi("disabled for " + ctx.Arch().ArchType.String() + " by CFIArches")
 	}
 
 	// HWASan requires AArch64 hardware feature (top-byte-ignore).
@@ -469,21 +542,20 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		s.Scs = nil
 	}
 
-	// Memtag_heap is only implemented on AArch64.
+	// Memtag_heap and Memtag_stack are only implemented on AArch64.
 	if ctx.Arch().ArchType != android.Arm64 {
 		s.Memtag_heap = nil
+		s.Memtag_stack = nil
 	}
 
 	// Also disable CFI if ASAN is enabled.
 	if Bool(s.Address) || Bool(s.Hwaddress) {
-		s.Cfi = nil
-		s.Diag.Cfi = nil
+		disableCfi("incompatible with the address sanitizers")
 	}
 
 	// Disable sanitizers that depend on the UBSan runtime for windows/darwin builds.
 	if !ctx.Os().Linux() {
-		s.Cfi = nil
-		s.Diag.Cfi = nil
+		disableCfi("not supported on " + ctx.Os().String())
 		s.Misc_undefined = nil
 		s.Undefined = nil
 		s.All_undefined = nil
@@ -492,8 +564,7 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 
 	// Disable CFI for musl
 	if ctx.toolchain().Musl() {
-		s.Cfi = nil
-		s.Diag.Cfi = nil
+		disableCfi("not supported with musl")
 	}
 
 	// Also disable CFI for VNDK variants of components
@@ -501,11 +572,9 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		if ctx.static() {
 			// Cfi variant for static vndk should be captured as vendor snapshot,
 			// so don't strictly disable Cfi.
-			s.Cfi = nil
-			s.Diag.Cfi = nil
+			disableCfi("disabled for VNDK variants")
 		} else {
-			s.Cfi = nil
-			s.Diag.Cfi = nil
+			disableCfi("disabled for VNDK variants")
 		}
 	}
 
@@ -534,7 +603,7 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 
 	if ctx.Os() != android.Windows && (Bool(s.All_undefined) || Bool(s.Undefined) || Bool(s.Address) || Bool(s.Thread) ||
 		Bool(s.Fuzzer) || Bool(s.Safestack) || Bool(s.Cfi) || Bool(s.Integer_overflow) || len(s.Misc_undefined) > 0 ||
-		Bool(s.Scudo) || Bool(s.Hwaddress) || Bool(s.Scs) || Bool(s.Memtag_heap)) {
+		Bool(s.Scudo) || Bool(s.Hwaddress) || Bool(s.Scs) || Bool(s.Memtag_heap) || Bool(s.Memtag_stack)) {
 		sanitize.Properties.SanitizerEnabled = true
 	}
 
@@ -551,10 +620,42 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 	// TODO(b/131771163): CFI transiently depends on LTO, and thus Fuzzer is
 	// mutually incompatible.
 	if Bool(s.Fuzzer) {
+		if Bool(s.Cfi) {
+			sanitize.Properties.CfiReason = "incompatible with the fuzzer sanitizer"
+		}
 		s.Cfi = nil
 	}
 }
 
+// checkIncompatibleProperties reports combinations of the sanitize and lto properties set in the
+// Android.bp file that can't be built together. It must be called before the begin methods
+// of sanitize and lto apply the global configuration, so that only the properties of the module
+// are checked, and it reports the property to change instead of letting the sanitizers be
+// silently disabled or the link fail.
+func (sanitize *sanitize) checkIncompatibleProperties(ctx BaseModuleContext, lto *lto) {
+	s := &sanitize.Properties.Sanitize
+	if Bool(s.Never) {
+		return
+	}
+
+	if Bool(s.Cfi) {
+		if lto.Never() {
+			ctx.PropertyErrorf("lto.never", "sanitize.cfi requires LTO, as CFI is compiled with -flto; "+
+				"remove lto.never or set sanitize.cfi: false")
+		}
+		if Bool(s.Address) || Bool(s.Hwaddress) {
+			ctx.PropertyErrorf("sanitize.cfi", "sanitize.cfi can't be combined with sanitize.address or "+
+				"sanitize.hwaddress, which would disable it; set sanitize.cfi: false or disable the "+
+				"address sanitizer")
+		}
+	}
+
+	if Bool(s.Fuzzer) && (lto.ThinLTO() || lto.FullLTO()) {
+		ctx.PropertyErrorf("lto", "sanitize.fuzzer builds with -fno-lto, which overrides lto.thin and "+
+			"lto.full; remove them or set sanitize.fuzzer: false")
+	}
+}
+
 func toDisableImplicitIntegerChange(flags []string) bool {
 	// Returns true if any flag is fsanitize*integer, and there is
 	// no explicit flag about sanitize=implicit-integer-sign-change.
@@ -702,6 +803,26 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		flags.Local.CFlags = append(flags.Local.CFlags, intOverflowCflags...)
 	}
 
+	if Bool(sanitize.Properties.Sanitize.Memtag_stack) {
+		flags.Local.CFlags = append(flags.Local.CFlags, memtagStackCommonFlags...)
+		flags.Local.AsFlags = append(flags.Local.AsFlags, memtagStackCommonFlags...)
+		flags.Local.LdFlags = append(flags.Local.LdFlags, memtagStackCommonFlags...)
+
+		// Stack tagging needs the loader to map the main thread stack with PROT_MTE, which
+		// it only does when the binary carries the memtag note, so have the linker emit it.
+		// The note covers heap tagging too, replacing the note_memtag_heap_* libraries.
+		if ctx.binary() {
+			mode := "async"
+			if Bool(sanitize.Properties.Sanitize.Diag.Memtag_heap) || Bool(sanitize.Properties.Sanitize.Diag.Memtag_stack) {
+				mode = "sync"
+			}
+			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,-z,memtag-mode="+mode, "-Wl,-z,memtag-stack")
+			if Bool(sanitize.Properties.Sanitize.Memtag_heap) {
+				flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,-z,memtag-heap")
+			}
+		}
+	}
+
 	if len(sanitize.Properties.Sanitizers) > 0 {
 		sanitizeArg := "-fsanitize=" + strings.Join(sanitize.Properties.Sanitizers, ",")
 		flags.Local.CFlags = append(flags.Local.CFlags, sanitizeArg)
@@ -760,17 +881,56 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 			strings.Join(sanitize.Properties.Sanitize.Diag.No_recover, ","))
 	}
 
-	blocklist := android.OptionalPathForModuleSrc(ctx, sanitize.Properties.Sanitize.Blocklist)
-	if blocklist.Valid() {
+	var blocklists android.Paths
+	if sanitize.Properties.Sanitize.Blocklist != nil {
+		blocklists = append(blocklists,
+			android.PathsForModuleSrc(ctx, []string{*sanitize.Properties.Sanitize.Blocklist})...)
+	}
+	if sanitize.Properties.Sanitize.Apex_blocklist != nil && !ctx.isForPlatform() {
+		blocklists = append(blocklists,
+			android.PathsForModuleSrc(ctx, []string{*sanitize.Properties.Sanitize.Apex_blocklist})...)
+	}
+	for _, blocklist := range verifySanitizerSpecialCaseLists(ctx, blocklists) {
 		flags.Local.CFlags = append(flags.Local.CFlags, "-fsanitize-ignorelist="+blocklist.String())
-		flags.CFlagsDeps = append(flags.CFlagsDeps, blocklist.Path())
+		flags.CFlagsDeps = append(flags.CFlagsDeps, blocklist)
 	}
 
 	return flags
 }
 
+// A line of a sanitizer special case list: an empty line, a comment, a [section] header or an
+// entry of the form <type>:<glob>[=<category>].
+const sanitizerSpecialCaseListLineRegexp = `^[[:space:]]*($|#|\[.+\][[:space:]]*$|[A-Za-z_]+:[^=]+(=[A-Za-z0-9_.-]+)?[[:space:]]*$)`
+
+// verifySanitizerSpecialCaseLists creates a build rule that checks that the sanitizer special
+// case lists can be parsed and copies them to the intermediates directory of the module variant,
+// and returns the copies. The copies are only updated when the content of the lists changes, so
+// that the module is only recompiled when it does, and the build fails if a list can't be
+// parsed instead of the list being silently ignored.
+func verifySanitizerSpecialCaseLists(ctx ModuleContext, lists android.Paths) android.Paths {
+	if len(lists) == 0 {
+		return nil
+	}
+	var verified android.Paths
+	rule := android.NewRuleBuilder(pctx, ctx)
+	for i, list := range lists {
+		output := android.PathForModuleOut(ctx, "sanitize_ignorelists", strconv.Itoa(i), list.Base())
+		rule.Command().
+			Textf("if grep -nvE '%s'", sanitizerSpecialCaseListLineRegexp).Input(list).Text(">&2; then").
+			Textf(`echo "%s: invalid lines in sanitizer special case list" >&2;`, list).
+			Text("exit 1; fi")
+		rule.Command().
+			Text("if ! cmp -s").Input(list).Output(output).Text("; then").
+			Text("cp -f").Input(list).Output(output).Text("; fi")
+		verified = append(verified, output)
+	}
+	rule.Restat()
+	rule.Build("sanitize_ignorelists", "verify sanitizer special case lists")
+	return verified
+}
+
 func (sanitize *sanitize) AndroidMkEntries(ctx AndroidMkContext, entries *android.AndroidMkEntries) {
-	// Add a suffix for cfi/hwasan/scs-enabled static/header libraries to allow surfacing
+	// Add a suffix for cfi/hwasan/scs/memtag_stack-enabled static/header libraries to allow surfacing
 	// both the sanitized and non-sanitized variants to make without a name conflict.
 	if entries.Class == "STATIC_LIBRARIES" || entries.Class == "HEADER_LIBRARIES" {
 		if Bool(sanitize.Properties.Sanitize.Cfi) {
@@ -782,6 +942,9 @@ func (sanitize *sanitize) AndroidMkEntries(ctx AndroidMkContext, entries *androi
 		if Bool(sanitize.Properties.Sanitize.Scs) {
 			entries.SubName += ".scs"
 		}
+		if Bool(sanitize.Properties.Sanitize.Memtag_stack) {
+			entries.SubName += ".memtag_stack"
+		}
 	}
 }
 
@@ -806,6 +969,8 @@ func (sanitize *sanitize) getSanitizerBoolPtr(t SanitizerType) *bool {
 		return sanitize.Properties.Sanitize.Scs
 	case Memtag_heap:
 		return sanitize.Properties.Sanitize.Memtag_heap
+	case Memtag_stack:
+		return sanitize.Properties.Sanitize.Memtag_stack
 	case Fuzzer:
 		return sanitize.Properties.Sanitize.Fuzzer
 	default:
@@ -821,6 +986,7 @@ func (sanitize *sanitize) isUnsanitizedVariant() bool {
 		!sanitize.isSanitizerEnabled(cfi) &&
 		!sanitize.isSanitizerEnabled(scs) &&
 		!sanitize.isSanitizerEnabled(Memtag_heap) &&
+		!sanitize.isSanitizerEnabled(Memtag_stack) &&
 		!sanitize.isSanitizerEnabled(Fuzzer)
 }
 
@@ -847,11 +1013,18 @@ func (sanitize *sanitize) SetSanitizer(t SanitizerType, b bool) {
 	case intOverflow:
 		sanitize.Properties.Sanitize.Integer_overflow = bPtr
 	case cfi:
+		if b && !Bool(sanitize.Properties.Sanitize.Cfi) {
+			sanitize.Properties.CfiReason = "required by a CFI enabled dependent module"
+		} else if !b && Bool(sanitize.Properties.Sanitize.Cfi) {
+			sanitize.Properties.CfiReason = "disabled for this variant"
+		}
 		sanitize.Properties.Sanitize.Cfi = bPtr
 	case scs:
 		sanitize.Properties.Sanitize.Scs = bPtr
 	case Memtag_heap:
 		sanitize.Properties.Sanitize.Memtag_heap = bPtr
+	case Memtag_stack:
+		sanitize.Properties.Sanitize.Memtag_stack = bPtr
 	case Fuzzer:
 		sanitize.Properties.Sanitize.Fuzzer = bPtr
 	default:
@@ -955,7 +1128,7 @@ func sanitizerDepsMutator(t SanitizerType) func(android.TopDownMutatorContext) {
 					if d, ok := child.(PlatformSanitizeable); ok && d.SanitizePropDefined() &&
 						!d.SanitizeNever() &&
 						!d.IsSanitizerExplicitlyDisabled(t) {
-						if t == cfi || t == Hwasan || t == scs || t == Asan {
+						if t == cfi || t == Hwasan || t == scs || t == Asan || t == Memtag_stack {
 							if d.StaticallyLinked() && d.SanitizerSupported(t) {
 								// Rust does not support some of these sanitizers, so we need to check if it's
 								// supported before setting this true.
@@ -1128,7 +1301,13 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 			sanitizers = append(sanitizers, "shadow-call-stack")
 		}
 
-		if Bool(c.sanitize.Properties.Sanitize.Memtag_heap) && c.Binary() {
+		if Bool(c.sanitize.Properties.Sanitize.Memtag_stack) {
+			sanitizers = append(sanitizers, "memtag-stack")
+		}
+
+		// Binaries with stack tagging get the memtag note from the linker instead.
+		if Bool(c.sanitize.Properties.Sanitize.Memtag_heap) && c.Binary() &&
+			!Bool(c.sanitize.Properties.Sanitize.Memtag_stack) {
 			noteDep := "note_memtag_heap_async"
 			if Bool(c.sanitize.Properties.Sanitize.Diag.Memtag_heap) {
 				noteDep = "note_memtag_heap_sync"
@@ -1366,10 +1545,10 @@ func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
 						modules[1].(PlatformSanitizeable).SetSanitizer(cfi, false)
 					}
 
-					// For cfi/scs/hwasan, we can export both sanitized and un-sanitized variants
-					// to Make, because the sanitized version has a different suffix in name.
+					// For cfi/scs/hwasan/memtag_stack, we can export both sanitized and un-sanitized
+					// variants to Make, because the sanitized version has a different suffix in name.
 					// For other types of sanitizers, suppress the variation that is disabled.
-					if t != cfi && t != scs && t != Hwasan {
+					if t != cfi && t != scs && t != Hwasan && t != Memtag_stack {
 						if isSanitizerEnabled {
 							modules[0].(PlatformSanitizeable).SetPreventInstall()
 							modules[0].(PlatformSanitizeable).SetHideFromMake()
@@ -551,3 +551,294 @@ func TestSanitizeMemtagHeapWithSanitizeDeviceDiag(t *testing.T) {
 	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_disable", variant), Sync)
 	checkHasMemtagNote(t, ctx.ModuleForTests("unset_test_override_default_sync", variant), Sync)
 }
+
+func TestSanitizeMemtagStack(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_memtag_stack",
+			static_libs: ["libstatic"],
+			sanitize: {
+				memtag_heap: true,
+				memtag_stack: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_with_memtag_stack_sync",
+			sanitize: {
+				memtag_stack: true,
+				diag: { memtag_stack: true },
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_memtag_stack",
+			static_libs: ["libstatic"],
+		}
+
+		cc_library_static {
+			name: "libstatic",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForTestWithMemtagHeap,
+		android.FixtureAddFile("subdir_stack/Android.bp", []byte(`
+			cc_binary {
+				name: "bin_in_include_path",
+			}
+		`)),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.MemtagStackIncludePaths = []string{"subdir_stack"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	memtagStackVariant := variant + "_memtag_stack"
+	staticVariant := variant + "_static"
+
+	binWithMemtag := result.ModuleForTests("bin_with_memtag_stack", memtagStackVariant)
+	binCflags := binWithMemtag.Rule("cc").Args["cFlags"]
+	android.AssertStringDoesContain(t, "cflags", binCflags, "-march=armv8-a+memtag")
+	android.AssertStringDoesContain(t, "cflags", binCflags, "-fsanitize=memtag-stack")
+
+	binLdflags := binWithMemtag.Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "ldflags", binLdflags, "-Wl,-z,memtag-mode=async")
+	android.AssertStringDoesContain(t, "ldflags", binLdflags, "-Wl,-z,memtag-stack")
+	android.AssertStringDoesContain(t, "ldflags", binLdflags, "-Wl,-z,memtag-heap")
+	// The linker emits the memtag note, so the note libraries must not be linked in.
+	checkHasMemtagNote(t, binWithMemtag, None)
+
+	// The static library gets a stack tagged variant for bin_with_memtag_stack.
+	libStaticMemtag := result.ModuleForTests("libstatic", staticVariant+"_memtag_stack")
+	android.AssertStringDoesContain(t, "libstatic memtag_stack variant cflags",
+		libStaticMemtag.Rule("cc").Args["cFlags"], "-fsanitize=memtag-stack")
+	android.AssertStringListContains(t, "bin_with_memtag_stack links libstatic memtag_stack variant",
+		binWithMemtag.Rule("ld").Implicits.Strings(), libStaticMemtag.Output("libstatic.a").Output.String())
+
+	binSyncLdflags := result.ModuleForTests("bin_with_memtag_stack_sync", memtagStackVariant).Rule("ld").Args["ldFlags"]
+	android.AssertStringDoesContain(t, "ldflags", binSyncLdflags, "-Wl,-z,memtag-mode=sync")
+	android.AssertStringDoesNotContain(t, "ldflags", binSyncLdflags, "-Wl,-z,memtag-heap")
+
+	binNoMemtag := result.ModuleForTests("bin_no_memtag_stack", variant)
+	android.AssertStringDoesNotContain(t, "cflags", binNoMemtag.Rule("cc").Args["cFlags"], "memtag")
+	libStatic := result.ModuleForTests("libstatic", staticVariant)
+	android.AssertStringListContains(t, "bin_no_memtag_stack links libstatic",
+		binNoMemtag.Rule("ld").Implicits.Strings(), libStatic.Output("libstatic.a").Output.String())
+
+	binInIncludePath := result.ModuleForTests("bin_in_include_path", memtagStackVariant)
+	android.AssertStringDoesContain(t, "cflags", binInIncludePath.Rule("cc").Args["cFlags"], "-fsanitize=memtag-stack")
+}
+
+func TestSanitizeMemtagStackWithSanitizeDevice(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_default",
+		}
+
+		cc_binary {
+			name: "bin_opt_out",
+			sanitize: { memtag_stack: false },
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForTestWithMemtagHeap,
+		android.FixtureAddFile("subdir_excluded/Android.bp", []byte(`
+			cc_binary {
+				name: "bin_excluded",
+			}
+		`)),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeDevice = []string{"memtag_stack"}
+			variables.SanitizeDeviceDiag = []string{"memtag_stack"}
+			variables.MemtagStackExcludePaths = []string{"subdir_excluded"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+
+	binDefault := result.ModuleForTests("bin_default", variant+"_memtag_stack")
+	android.AssertStringDoesContain(t, "ldflags", binDefault.Rule("ld").Args["ldFlags"], "-Wl,-z,memtag-mode=sync")
+
+	android.AssertStringDoesNotContain(t, "cflags",
+		result.ModuleForTests("bin_opt_out", variant).Rule("cc").Args["cFlags"], "memtag")
+	android.AssertStringDoesNotContain(t, "cflags",
+		result.ModuleForTests("bin_excluded", variant).Rule("cc").Args["cFlags"], "memtag")
+}
+
+func TestCfiArchesAndReport(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libcfi",
+			sanitize: { cfi: true },
+		}
+
+		cc_library_shared {
+			name: "libnocfi",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("cfi_report", cfiReportSingletonFactory)
+		}),
+		android.FixtureAddFile("included/Android.bp", []byte(`
+			cc_library_shared {
+				name: "libincluded",
+			}
+		`)),
+		android.FixtureAddFile("included/excluded/Android.bp", []byte(`
+			cc_library_shared {
+				name: "libexcluded",
+			}
+		`)),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.CFIArches = []string{"arm64"}
+			variables.CFIIncludePaths = []string{"included"}
+			variables.CFIExcludePaths = []string{"included/excluded"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	arm64Variant := "android_arm64_armv8-a_shared"
+	armVariant := "android_arm_armv7-a-neon_shared"
+
+	checkCfi := func(module, variant string, expected bool) {
+		t.Helper()
+		cflags := result.ModuleForTests(module, variant).Rule("cc").Args["cFlags"]
+		android.AssertStringContainsEquals(t, module+" "+variant+" cflags", cflags, "-fsanitize=cfi", expected)
+	}
+
+	checkCfi("libcfi", arm64Variant+"_cfi", true)
+	checkCfi("libcfi", armVariant, false)
+	checkCfi("libincluded", arm64Variant+"_cfi", true)
+	checkCfi("libincluded", armVariant, false)
+	checkCfi("libexcluded", arm64Variant, false)
+	checkCfi("libnocfi", arm64Variant, false)
+
+	report := android.ContentFromFileRuleForTests(t, result.SingletonForTests("cfi_report").Output("cfi/report.txt"))
+	for _, line := range []string{
+		"libcfi\t" + arm64Variant + "_cfi\t.\tenabled\tenabled by sanitize.cfi",
+		"libcfi\t" + armVariant + "\t.\tdisabled\tdisabled for arm by CFIArches",
+		"libexcluded\t" + arm64Variant + "\tincluded/excluded\tdisabled\texcluded by CFIExcludePaths",
+		"libincluded\t" + arm64Variant + "_cfi\tincluded\tenabled\tenabled by CFIIncludePaths",
+		"libnocfi\t" + arm64Variant + "\t.\tdisabled\tnot requested",
+	} {
+		android.AssertStringListContains(t, "cfi report", strings.Split(report, "\n"), line)
+	}
+}
+
+func TestSanitizeBlocklist(t *testing.T) {
+	bp := `
+		filegroup {
+			name: "ignorelists",
+			srcs: [
+				"ignorelist1.txt",
+				"sub/ignorelist2.txt",
+			],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				blocklist: ":ignorelists",
+				apex_blocklist: "apex_ignorelist.txt",
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureMergeMockFs(android.MockFS{
+			"ignorelist1.txt":     nil,
+			"sub/ignorelist2.txt": nil,
+			"apex_ignorelist.txt": nil,
+		}),
+	).RunTestWithBp(t, bp)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	intermediates := "out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/sanitize_ignorelists"
+
+	verify := libfoo.Output(intermediates + "/0/ignorelist1.txt")
+	android.AssertPathsRelativeToTopEquals(t, "verified ignorelists",
+		[]string{intermediates + "/1/ignorelist2.txt"},
+		verify.ImplicitOutputs.Paths())
+	android.AssertStringDoesContain(t, "verify command", verify.RuleParams.Command, "grep -nvE")
+	android.AssertBoolEquals(t, "restat", true, verify.RuleParams.Restat)
+
+	cc := libfoo.Rule("cc")
+	cFlags := cc.Args["cFlags"]
+	android.AssertStringDoesContain(t, "cflags", cFlags, "-fsanitize-ignorelist="+intermediates+"/0/ignorelist1.txt")
+	android.AssertStringDoesContain(t, "cflags", cFlags, "-fsanitize-ignorelist="+intermediates+"/1/ignorelist2.txt")
+	// apex_blocklist is not used by the platform variant.
+	android.AssertStringDoesNotContain(t, "cflags", cFlags, "apex_ignorelist.txt")
+	android.AssertPathsRelativeToTopEquals(t, "cc implicits",
+		[]string{intermediates + "/0/ignorelist1.txt", intermediates + "/1/ignorelist2.txt"},
+		cc.Implicits)
+}
+
+func TestSanitizeIncompatibleProperties(t *testing.T) {
+	testCases := []struct {
+		name       string
+		properties string
+		err        string
+	}{
+		{
+			name: "cfi without lto",
+			properties: `
+				sanitize: { cfi: true },
+				lto: { never: true },
+			`,
+			err: `"libfoo" .*: lto.never: sanitize.cfi requires LTO, as CFI is compiled with -flto; remove lto.never or set sanitize.cfi: false`,
+		},
+		{
+			name: "cfi with hwasan",
+			properties: `
+				sanitize: {
+					cfi: true,
+					hwaddress: true,
+				},
+			`,
+			err: `"libfoo" .*: sanitize.cfi: sanitize.cfi can't be combined with sanitize.address or sanitize.hwaddress`,
+		},
+		{
+			name: "fuzzer with lto",
+			properties: `
+				sanitize: { fuzzer: true },
+				lto: { thin: true },
+			`,
+			err: `"libfoo" .*: lto: sanitize.fuzzer builds with -fno-lto, which overrides lto.thin and lto.full`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prepareForCcTest.
+				ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(tc.err)).
+				RunTestWithBp(t, `
+					cc_library_shared {
+						name: "libfoo",
+						srcs: ["foo.c"],
+						`+tc.properties+`
+					}
+				`)
+		})
+	}
+
+	// A sanitizer that is never applied doesn't conflict with LTO.
+	prepareForCcTest.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			sanitize: {
+				never: true,
+				cfi: true,
+			},
+			lto: { never: true },
+		}
+	`)
+}
@@ -0,0 +1,183 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("cc_library_shim", LibraryShimFactory)
+
+	pctx.HostBinToolVariable("genShimForwarders", "gen_shim_forwarders")
+}
+
+var (
+	// Rule to check that the symbols of a shim library are defined by its implementation library.
+	// The version script of the shim is only copied to $out when they are, so the shim can't be
+	// linked with a symbol its implementation doesn't provide.
+	shimSymbolCheck = pctx.AndroidStaticRule("shimSymbolCheck",
+		blueprint.RuleParams{
+			Command: "rm -f $out && " +
+				"${config.ClangBin}/llvm-nm -D --defined-only -j $impl | sed 's/@.*//' | sort -u > ${out}.defined && " +
+				"tail -n +2 $symbolList | sort -u | comm -23 - ${out}.defined > ${out}.missing && " +
+				"if [ -s ${out}.missing ]; then " +
+				"echo \"error: symbols listed in $symbolFile are not defined by $impl:\" && " +
+				"cat ${out}.missing && exit 1; fi && " +
+				"cp $in $out",
+			CommandDeps: []string{"${config.ClangBin}/llvm-nm"},
+		},
+		"impl", "symbolList", "symbolFile")
+
+	// Rule to generate the functions of a shim library that forward to the functions of the same
+	// names in its implementation library.
+	shimForwarders = pctx.AndroidStaticRule("shimForwarders",
+		blueprint.RuleParams{
+			Command:     "$genShimForwarders --impl $implSoname $in $out",
+			CommandDeps: []string{"$genShimForwarders"},
+		},
+		"implSoname")
+
+	shimImplementationTag = dependencyTag{name: "shimImplementation"}
+)
+
+type shimLibraryProperties struct {
+	// Name of the library implementing the symbols of the shim. The shim links against its shared
+	// variant, and forwards the calls to the symbols to it.
+	Impl_library *string
+
+	// Relative path to the symbol map listing the symbols exposed by the shim, in the .map.txt
+	// format of stub libraries.
+	Symbol_file *string `android:"path"`
+}
+
+// shimLibraryDecorator builds a shared library exposing only the symbols listed in its symbol
+// file, implemented by the shared variant of another library.
+type shimLibraryDecorator struct {
+	*libraryDecorator
+
+	properties shimLibraryProperties
+
+	symbolList    android.Path
+	versionScript android.Path
+}
+
+// cc_library_shim creates a shared library that exposes a stable subset of the symbols of another
+// library, listed in a .map.txt symbol file, for instance to let vendor code link against a few
+// symbols of a platform library without a hand-written wrapper. The shim depends on the shared
+// variant of the implementation library, and defines each listed function as a jump to the
+// function of the same name in the implementation library, looked up when the shim is loaded.
+// The build fails if a listed symbol isn't defined by the implementation library, or is a
+// variable, which can't be forwarded.
+func LibraryShimFactory() android.Module {
+	module, library := NewLibrary(android.DeviceSupported)
+	library.BuildOnlyShared()
+
+	shim := &shimLibraryDecorator{
+		libraryDecorator: library,
+	}
+	module.compiler = shim
+	module.linker = shim
+	module.library = shim
+
+	module.AddProperties(&shim.properties)
+
+	return module.Init()
+}
+
+func (shim *shimLibraryDecorator) linkerDeps(ctx DepsContext, deps Deps) Deps {
+	deps = shim.libraryDecorator.linkerDeps(ctx, deps)
+	impl := String(shim.properties.Impl_library)
+	if impl == "" {
+		ctx.PropertyErrorf("impl_library", "is required")
+		return deps
+	}
+	deps.SharedLibs = append(deps.SharedLibs, impl)
+	ctx.AddVariationDependencies([]blueprint.Variation{
+		{Mutator: "link", Variation: "shared"},
+	}, shimImplementationTag, impl)
+	return deps
+}
+
+func (shim *shimLibraryDecorator) compile(ctx ModuleContext, flags Flags, deps PathDeps) Objects {
+	symbolFile := String(shim.properties.Symbol_file)
+	if !strings.HasSuffix(symbolFile, ".map.txt") {
+		ctx.PropertyErrorf("symbol_file", "%q doesn't have .map.txt suffix", symbolFile)
+		return Objects{}
+	}
+	impl := shim.implementation(ctx)
+	if impl == nil {
+		return Objects{}
+	}
+	nativeAbiResult := parseNativeAbiDefinition(ctx, symbolFile, android.FutureApiLevel, "")
+	shim.symbolList = nativeAbiResult.symbolList
+	shim.versionScript = nativeAbiResult.versionScript
+
+	forwarders := android.PathForModuleGen(ctx, "shim_forwarders.c")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        shimForwarders,
+		Description: "generate shim forwarders " + ctx.ModuleName(),
+		Output:      forwarders,
+		Input:       nativeAbiResult.stubSrc,
+		Args: map[string]string{
+			"implSoname": impl.OutputFile().Path().Base(),
+		},
+	})
+
+	objs := shim.libraryDecorator.compile(ctx, flags, deps)
+	return objs.Append(compileObjs(ctx, flagsToBuilderFlags(flags), "",
+		android.Paths{forwarders}, nil, nil, nil, nil))
+}
+
+// implementation returns the shared variant of the implementation library.
+func (shim *shimLibraryDecorator) implementation(ctx ModuleContext) *Module {
+	dep := ctx.GetDirectDepWithTag(String(shim.properties.Impl_library), shimImplementationTag)
+	impl, ok := dep.(*Module)
+	if !ok || !impl.OutputFile().Valid() || impl.UnstrippedOutputFile() == nil {
+		ctx.PropertyErrorf("impl_library", "%q is not a shared library", String(shim.properties.Impl_library))
+		return nil
+	}
+	return impl
+}
+
+func (shim *shimLibraryDecorator) link(ctx ModuleContext, flags Flags, deps PathDeps,
+	objs Objects) android.Path {
+
+	if shim.versionScript == nil {
+		return nil
+	}
+	impl := shim.implementation(ctx)
+
+	checkedVersionScript := android.PathForModuleGen(ctx, "shim.map")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        shimSymbolCheck,
+		Description: "check shim symbols " + ctx.ModuleName(),
+		Output:      checkedVersionScript,
+		Input:       shim.versionScript,
+		Implicits:   android.Paths{impl.UnstrippedOutputFile(), shim.symbolList},
+		Args: map[string]string{
+			"impl":       impl.UnstrippedOutputFile().String(),
+			"symbolList": shim.symbolList.String(),
+			"symbolFile": String(shim.properties.Symbol_file),
+		},
+	})
+	shim.libraryDecorator.versionScriptPath = android.OptionalPathForPath(checkedVersionScript)
+
+	return shim.libraryDecorator.link(ctx, flags, deps, objs)
+}
@@ -82,6 +82,15 @@ func (stl *stl) begin(ctx BaseModuleContext) {
 				ctx.ModuleErrorf("stl: %q is not a supported STL with sdk_version set", s)
 				return ""
 			}
+		} else if ctx.Os() == android.Baremetal {
+			switch s {
+			case "", "none":
+				// There is no STL for baremetal targets.
+				return ""
+			default:
+				ctx.ModuleErrorf("stl: %q is not a supported STL for baremetal", s)
+				return ""
+			}
 		} else if ctx.Windows() {
 			switch s {
 			case "libc++", "libc++_static", "":
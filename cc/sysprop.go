@@ -34,8 +34,6 @@ package cc
 // cc.Module.DepsMutator.
 
 import (
-	"sync"
-
 	"android/soong/android"
 )
 
@@ -44,26 +42,13 @@ type syspropLibraryInterface interface {
 	CcImplementationModuleName() string
 }
 
-var (
-	syspropImplLibrariesKey  = android.NewOnceKey("syspropImplLibirares")
-	syspropImplLibrariesLock sync.Mutex
-)
-
-func syspropImplLibraries(config android.Config) map[string]string {
-	return config.Once(syspropImplLibrariesKey, func() interface{} {
-		return make(map[string]string)
-	}).(map[string]string)
-}
+var syspropImplLibraries = android.NewConfigStringMap("syspropImplLibraries")
 
 // gather list of sysprop libraries
 func SyspropMutator(mctx android.BottomUpMutatorContext) {
 	if m, ok := mctx.Module().(syspropLibraryInterface); ok {
-		syspropImplLibraries := syspropImplLibraries(mctx.Config())
-		syspropImplLibrariesLock.Lock()
-		defer syspropImplLibrariesLock.Unlock()
-
 		// BaseModuleName is the name of sysprop_library
 		// CcImplementationModuleName is the name of cc_library generated by sysprop_library
-		syspropImplLibraries[m.BaseModuleName()] = m.CcImplementationModuleName()
+		syspropImplLibraries.Set(mctx.Config(), m.BaseModuleName(), m.CcImplementationModuleName())
 	}
 }
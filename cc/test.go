@@ -15,6 +15,7 @@
 package cc
 
 import (
+	"fmt"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -119,6 +120,12 @@ type TestBinaryProperties struct {
 
 	// Install the test into a folder named for the module in all test suites.
 	Per_testcase_directory *bool
+
+	// Build a self-contained zip of the test that can be uploaded directly to a device farm. It
+	// contains the test binary, the shared libraries it depends on for the same architecture, the
+	// test config and the data files, and is available through the ".standalone_test_package"
+	// output tag and the standalone-test-packages goal.
+	Standalone_test_package *bool
 }
 
 func init() {
@@ -340,6 +347,9 @@ type testBinary struct {
 	data             []android.DataPath
 	testConfig       android.Path
 	extraTestConfigs android.Paths
+
+	// zip of the test with its shared libraries, config and data, if standalone_test_package is set
+	standaloneTestPackage android.Path
 }
 
 func (test *testBinary) linkerProps() []interface{} {
@@ -471,6 +481,87 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 		test.Properties.Test_options.Unit_test = proptools.BoolPtr(true)
 	}
 	test.binaryDecorator.baseInstaller.install(ctx, file)
+
+	if Bool(test.Properties.Standalone_test_package) {
+		test.standaloneTestPackage = test.buildStandaloneTestPackage(ctx, file)
+	}
+}
+
+// buildStandaloneTestPackage creates a rule to zip the test binary together with the shared
+// libraries it transitively depends on, its test config and its data files, so that the test can
+// run on a device or host that doesn't have them installed. The files are stored under a
+// directory named after the test, with the shared libraries in its lib or lib64 subdirectory, and
+// the zip is named after the test and the target it is built for.
+func (test *testBinary) buildStandaloneTestPackage(ctx ModuleContext, file android.Path) android.Path {
+	var sharedLibs android.Paths
+	seen := make(map[string]bool)
+	ctx.WalkDeps(func(child, parent android.Module) bool {
+		// Data libraries are already packaged with the other data files.
+		if tag := ctx.OtherModuleDependencyTag(child); tag == dataLibDepTag || tag == dataBinDepTag {
+			return false
+		}
+		if !IsValidSharedDependency(child) {
+			return false
+		}
+		// Like when the test is installed, stubs, NDK libraries and the bionic libraries are
+		// provided by the device and are not packaged.
+		if m, ok := child.(*Module); ok {
+			if m.IsStubs() || m.IsNdk(ctx.Config()) || (ctx.Device() && isBionic(m.BaseModuleName())) {
+				return false
+			}
+		}
+		linkable := child.(LinkableInterface)
+		if !linkable.OutputFile().Valid() {
+			return false
+		}
+		lib := linkable.OutputFile().Path()
+		if seen[lib.Base()] {
+			return false
+		}
+		seen[lib.Base()] = true
+		sharedLibs = append(sharedLibs, lib)
+		return true
+	})
+
+	name := file.Base()
+	libDir := "lib"
+	if ctx.Arch().ArchType.Multilib == "lib64" {
+		libDir = "lib64"
+	}
+
+	zip := android.PathForModuleOut(ctx, "standalone_test_package",
+		fmt.Sprintf("%s-%s-%s.zip", name, ctx.Os(), ctx.Arch().ArchType))
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().
+		BuiltTool("soong_zip").
+		FlagWithOutput("-o ", zip).
+		Flag("-j").
+		FlagWithArg("-P ", name).
+		FlagWithInput("-f ", file)
+	if test.testConfig != nil {
+		cmd.FlagWithInput("-f ", test.testConfig)
+	}
+	if len(sharedLibs) > 0 {
+		cmd.FlagWithArg("-P ", filepath.Join(name, libDir))
+		for _, lib := range sharedLibs {
+			cmd.FlagWithInput("-f ", lib)
+		}
+	}
+	for _, data := range test.data {
+		// Data files keep their path relative to the module directory, like when they are
+		// installed.
+		root := strings.TrimSuffix(strings.TrimSuffix(data.SrcPath.String(), data.SrcPath.Rel()), "/")
+		if root == "" {
+			root = "."
+		}
+		cmd.FlagWithArg("-P ", filepath.Join(name, data.RelativeInstallPath)).
+			FlagWithArg("-C ", root).
+			FlagWithInput("-f ", data.SrcPath)
+	}
+	rule.Build("standalone_test_package", "standalone test package "+name)
+
+	ctx.Phony("standalone-test-packages", zip)
+	return zip
 }
 
 func NewTest(hod android.HostOrDeviceSupported) *Module {
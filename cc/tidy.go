@@ -37,6 +37,14 @@ type TidyProperties struct {
 
 	// Checks that should be treated as errors.
 	Tidy_checks_as_errors []string
+
+	// Baseline of known clang-tidy findings, which are removed from the output and don't fail the
+	// build. This allows enabling new checks without fixing all the existing findings first. Each
+	// line is a finding without its line and column numbers, e.g.
+	// "path/to/foo.cpp: use nullptr [modernize-use-nullptr]". Building the module with
+	// TIDY_UPDATE_BASELINE=true writes all of its findings in this format to tidy_baseline.txt in
+	// its output directory, which is also built by the <module>-tidy-baseline phony target.
+	Tidy_baseline *string `android:"path"`
 }
 
 type tidyFeature struct {
@@ -76,9 +84,13 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	// the global WITH_TIDY or module 'tidy' property is true.
 	flags.Tidy = true
 
+	// WITH_TIDY only applies to the modules under WITH_TIDY_PATHS if it is set,
+	// the other modules are handled as if WITH_TIDY was not set.
+	withTidy := ctx.Config().ClangTidyEnabledForPath(ctx.ModuleDir())
+
 	// If explicitly enabled, by global default or local tidy property,
 	// set flags.NeedTidyFiles to make this module depend on .tidy files.
-	if ctx.Config().ClangTidy() || Bool(tidy.Properties.Tidy) {
+	if withTidy || Bool(tidy.Properties.Tidy) {
 		flags.NeedTidyFiles = true
 	}
 
@@ -87,6 +99,13 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	if len(withTidyFlags) > 0 {
 		flags.TidyFlags = append(flags.TidyFlags, withTidyFlags)
 	}
+	// Add the defaults of the directory from the tidy_dirs config files, the local tidy_flags
+	// and tidy_checks come after them so they can override them. A load error is reported once by
+	// the tidy_dirs_config singleton.
+	dirConfig, _ := config.TidyDirConfigForDir(ctx, ctx.ModuleDir())
+	if dirConfig != nil {
+		flags.TidyFlags = append(flags.TidyFlags, proptools.NinjaAndShellEscapeList(dirConfig.Tidy_flags)...)
+	}
 	esc := checkNinjaAndShellEscapeList
 	flags.TidyFlags = append(flags.TidyFlags, esc(ctx, "tidy_flags", tidy.Properties.Tidy_flags)...)
 	// If TidyFlags does not contain -header-filter, add default header filter.
@@ -110,7 +129,7 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	}
 
 	// If clang-tidy is not enabled globally, add the -quiet flag.
-	if !ctx.Config().ClangTidy() {
+	if !withTidy {
 		flags.TidyFlags = append(flags.TidyFlags, "-quiet")
 		flags.TidyFlags = append(flags.TidyFlags, "-extra-arg-before=-fno-caret-diagnostics")
 	}
@@ -137,6 +156,10 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	} else {
 		tidyChecks += config.TidyChecksForDir(ctx.ModuleDir())
 	}
+	if dirConfig != nil && len(dirConfig.Tidy_checks) > 0 {
+		tidyChecks = tidyChecks + "," + strings.Join(proptools.NinjaAndShellEscapeList(
+			config.ClangRewriteTidyChecks(dirConfig.Tidy_checks)), ",")
+	}
 	if len(tidy.Properties.Tidy_checks) > 0 {
 		tidyChecks = tidyChecks + "," + strings.Join(esc(ctx, "tidy_checks",
 			config.ClangRewriteTidyChecks(tidy.Properties.Tidy_checks)), ",")
@@ -167,7 +190,11 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	tidyChecks = tidyChecks + ",-cert-err33-c"
 	flags.TidyFlags = append(flags.TidyFlags, tidyChecks)
 
-	if ctx.Config().IsEnvTrue("WITH_TIDY") {
+	if tidy.Properties.Tidy_baseline != nil {
+		flags.TidyBaseline = android.OptionalPathForPath(android.PathForModuleSrc(ctx, *tidy.Properties.Tidy_baseline))
+	}
+
+	if withTidy && ctx.Config().IsEnvTrue("WITH_TIDY") {
 		// WITH_TIDY=1 enables clang-tidy globally. There could be many unexpected
 		// warnings from new checks and many local tidy_checks_as_errors and
 		// -warnings-as-errors can break a global build.
@@ -198,8 +225,39 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	return flags
 }
 
+// buildTidyBaseline collects the findings of all the sources of a module, written when building
+// with TIDY_UPDATE_BASELINE=true, into a file that can be used as its tidy_baseline.
+func buildTidyBaseline(ctx ModuleContext, tidyBaselineFiles android.Paths) {
+	if len(tidyBaselineFiles) == 0 {
+		return
+	}
+	baseline := android.PathForModuleOut(ctx, "tidy_baseline.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        tidyBaselineMerge,
+		Description: "tidy baseline",
+		Output:      baseline,
+		Inputs:      tidyBaselineFiles,
+	})
+	ctx.Phony(ctx.ModuleName()+"-tidy-baseline", baseline)
+}
+
 func init() {
 	android.RegisterSingletonType("tidy_phony_targets", TidyPhonySingleton)
+	android.RegisterSingletonType("tidy_fixes", tidyFixesSingletonFactory)
+}
+
+func tidyDirsConfigSingletonFactory() android.Singleton {
+	return &tidyDirsConfigSingleton{}
+}
+
+// tidyDirsConfigSingleton reports an error loading the tidy_dirs config files once, instead of on
+// every module with clang-tidy enabled.
+type tidyDirsConfigSingleton struct{}
+
+func (s *tidyDirsConfigSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if err := config.TidyDirsConfigError(ctx); err != nil {
+		ctx.Errorf("%s", err)
+	}
 }
 
 // This TidyPhonySingleton generates both tidy-* and obj-* phony targets for C/C++ files.
@@ -348,3 +406,48 @@ func genObjTidyPhonyTargets(ctx android.SingletonContext, module android.Module,
 		targetGroups[group] = android.PathForPhony(ctx, groupName)
 	}
 }
+
+func tidyFixesSingletonFactory() android.Singleton {
+	return &tidyFixesSingleton{}
+}
+
+// tidyFixesSingleton merges the fixes exported by clang-tidy when building with
+// WITH_TIDY_EXPORT_FIXES=true into one file per module, tidy_fixes/<module dir>/<module>.yaml,
+// which can be applied with clang-apply-replacements. It is built by the tidy-fixes-<module>
+// phony target, and the files of all modules by the tidy-fixes phony target.
+type tidyFixesSingleton struct{}
+
+func (s *tidyFixesSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue("WITH_TIDY_EXPORT_FIXES") {
+		return
+	}
+
+	var allFixes android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		if module != ctx.FinalModule(module) {
+			return
+		}
+		var fixes android.Paths
+		ctx.VisitAllModuleVariants(module, func(variant android.Module) {
+			if m, ok := variant.(*Module); ok {
+				fixes = append(fixes, m.tidyFixesFiles...)
+			}
+		})
+		if len(fixes) == 0 {
+			return
+		}
+		name := ctx.ModuleName(module)
+		merged := android.PathForOutput(ctx, "tidy_fixes", ctx.ModuleDir(module), name+".yaml")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        tidyFixesMerge,
+			Description: "merge tidy fixes " + name,
+			Output:      merged,
+			Inputs:      fixes,
+		})
+		ctx.Phony("tidy-fixes-"+name, merged)
+		allFixes = append(allFixes, merged)
+	})
+	if len(allFixes) > 0 {
+		ctx.Phony("tidy-fixes", allFixes...)
+	}
+}
@@ -54,6 +54,17 @@ func moduleToLibName(module string) (string, error) {
 	return matches[1], nil
 }
 
+func joinFlagsMap(m map[string][]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		ret[k] = strings.Join(v, " ")
+	}
+	return ret
+}
+
 func flagsToBuilderFlags(in Flags) builderFlags {
 	return builderFlags{
 		globalCommonFlags:     strings.Join(in.Global.CommonFlags, " "),
@@ -81,6 +92,13 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 		libFlags:      strings.Join(in.libFlags, " "),
 		extraLibFlags: strings.Join(in.extraLibFlags, " "),
 		tidyFlags:     strings.Join(in.TidyFlags, " "),
+		tidyBaseline:  in.TidyBaseline,
+
+		iwyu:             in.Iwyu,
+		iwyuFlags:        strings.Join(in.IwyuFlags, " "),
+		iwyuMappingFiles: in.IwyuMappingFiles,
+		iwyuAsErrors:     in.IwyuAsErrors,
+
 		sAbiFlags:     strings.Join(in.SAbiFlags, " "),
 		toolchain:     in.Toolchain,
 		gcovCoverage:  in.GcovCoverage,
@@ -89,9 +107,14 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 		sAbiDump:      in.SAbiDump,
 		emitXrefs:     in.EmitXrefs,
 
+		emitInspectionFiles: in.EmitInspectionFiles,
+
 		systemIncludeFlags: strings.Join(in.SystemIncludeFlags, " "),
 
 		assemblerWithCpp: in.AssemblerWithCpp,
+		perSrcAsFlags:    joinFlagsMap(in.PerSrcAsFlags),
+
+		linkCost: in.LinkCost,
 
 		proto:            in.proto,
 		protoC:           in.protoC,
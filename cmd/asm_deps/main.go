@@ -0,0 +1,164 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This tool writes a "make"-like dependency file for an assembly source, listing the files it
+// pulls in with the .include and .incbin directives. Those are resolved by the assembler, so the
+// dependency files written by the C preprocessor don't contain them.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"android/soong/makedeps"
+)
+
+var directiveRe = regexp.MustCompile(`^\s*\.(include|incbin)\s+"([^"]+)"`)
+
+// includeDirs returns the include directories of the assembler from the compiler flags.
+func includeDirs(flags []string) []string {
+	var dirs []string
+	for i := 0; i < len(flags); i++ {
+		flag := flags[i]
+		switch {
+		case flag == "-I" && i+1 < len(flags):
+			i++
+			dirs = append(dirs, flags[i])
+		case strings.HasPrefix(flag, "-I"):
+			dirs = append(dirs, strings.TrimPrefix(flag, "-I"))
+		case strings.HasPrefix(flag, "-Wa,"):
+			args := strings.Split(strings.TrimPrefix(flag, "-Wa,"), ",")
+			for j := 0; j < len(args); j++ {
+				if args[j] == "-I" && j+1 < len(args) {
+					j++
+					dirs = append(dirs, args[j])
+				} else if strings.HasPrefix(args[j], "-I") {
+					dirs = append(dirs, strings.TrimPrefix(args[j], "-I"))
+				}
+			}
+		}
+	}
+	return dirs
+}
+
+func exists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// resolve finds an included file the way the assembler does: relative to the working directory,
+// then to the directory of the including file, then in the include directories.
+func resolve(name, includer string, dirs []string) (string, bool) {
+	if filepath.IsAbs(name) {
+		return name, exists(name)
+	}
+	candidates := []string{name, filepath.Join(filepath.Dir(includer), name)}
+	for _, dir := range dirs {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+	for _, c := range candidates {
+		if exists(c) {
+			return filepath.Clean(c), true
+		}
+	}
+	return "", false
+}
+
+// scan appends the files included by src to deps, recursing into files included with .include.
+// Files that can't be found are skipped, the assembler reports them.
+func scan(src string, dirs []string, seen map[string]bool, deps *[]string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		match := directiveRe.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		path, ok := resolve(match[2], src, dirs)
+		if !ok || seen[path] {
+			continue
+		}
+		seen[path] = true
+		*deps = append(*deps, path)
+		if match[1] == "include" {
+			if err := scan(path, dirs, seen, deps); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -o <output> [-merge <depfile.d>] <target> <source> [-- <compiler flags>]", os.Args[0])
+		flag.PrintDefaults()
+	}
+	output := flag.String("o", "", "Output dependency file")
+	merge := flag.String("merge", "", "Optional dependency file whose inputs are added to the output")
+	flag.Parse()
+
+	args := flag.Args()
+	if *output == "" || len(args) < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	target, src := args[0], args[1]
+	var cflags []string
+	if len(args) > 2 {
+		if args[2] != "--" {
+			log.Fatalf("Expected -- before the compiler flags, got %q", args[2])
+		}
+		cflags = args[3:]
+	}
+
+	deps := &makedeps.Deps{Output: target}
+	seen := make(map[string]bool)
+	if *merge != "" {
+		input, err := ioutil.ReadFile(*merge)
+		if err != nil {
+			log.Fatalf("Error opening %q: %v", *merge, err)
+		}
+		merged, err := makedeps.Parse(*merge, bytes.NewBuffer(input))
+		if err != nil {
+			log.Fatalf("Failed to parse: %v", err)
+		}
+		for _, input := range merged.Inputs {
+			seen[filepath.Clean(input)] = true
+		}
+		deps.Inputs = merged.Inputs
+	}
+
+	if err := scan(src, includeDirs(cflags), seen, &deps.Inputs); err != nil {
+		log.Fatalf("Failed to scan %q: %v", src, err)
+	}
+
+	if err := ioutil.WriteFile(*output, deps.Print(), 0666); err != nil {
+		log.Fatalf("Failed to write to %q: %v", *output, err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIncludeDirs(t *testing.T) {
+	flags := []string{"-O2", "-Ifoo", "-I", "bar", "-Wa,-Ibaz", "-Wa,-I,qux", "-isystem", "sys"}
+	want := []string{"foo", "bar", "baz", "qux"}
+	if got := includeDirs(flags); !reflect.DeepEqual(got, want) {
+		t.Errorf("includeDirs(%q) = %q, want %q", flags, got, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm_deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"src/foo.S": "  .include \"local.inc\"\n" +
+			"\t.include \"common.inc\"\n" +
+			"// .include \"commented.inc\"\n" +
+			"  .include \"missing.inc\"\n",
+		"src/local.inc":         ".incbin \"data.bin\"\n",
+		"src/data.bin":          "",
+		"include/common.inc":    ".include \"nested.inc\"\n.include \"local.inc\"\n",
+		"include/nested.inc":    "",
+		"include/commented.inc": "",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deps []string
+	err = scan(filepath.Join(dir, "src/foo.S"), []string{filepath.Join(dir, "include")}, map[string]bool{}, &deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "src/local.inc"),
+		filepath.Join(dir, "src/data.bin"),
+		filepath.Join(dir, "include/common.inc"),
+		filepath.Join(dir, "include/nested.inc"),
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("scan() = %q, want %q", deps, want)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This tool generates the C++, Java or Rust accessors of the feature flags declared in .flags
+// files for the feature_flag_library module type. Each line of a .flags file declares a boolean
+// flag, optionally followed by its default value:
+//
+//	my_flag
+//	my_other_flag = true
+//
+// Each line of a values file provided by the release configuration sets the value of a flag of
+// a package, overriding the default of the .flags file:
+//
+//	com.android.foo.my_flag = false
+//
+// Flags are false unless they are set by a .flags or values file. Text following a # is a
+// comment, and empty lines are ignored.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var flagNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+type multiString []string
+
+func (s *multiString) String() string     { return strings.Join(*s, ", ") }
+func (s *multiString) Set(v string) error { *s = append(*s, v); return nil }
+
+var (
+	pkg      = flag.String("package", "", "package of the flags")
+	language = flag.String("language", "", "language of the accessors: cc, java or rust")
+	outDir   = flag.String("out-dir", "", "directory to write the generated files to")
+	values   multiString
+)
+
+func init() {
+	flag.Var(&values, "values", "file of flag values of the release configuration, may be repeated")
+}
+
+type featureFlag struct {
+	name  string
+	value bool
+}
+
+// parseLines calls f with the name and value of each non-empty line of a .flags or values file.
+func parseLines(file string, contents []byte, f func(name string, value *bool) error) error {
+	for i, line := range strings.Split(string(contents), "\n") {
+		if hash := strings.IndexByte(line, '#'); hash >= 0 {
+			line = line[:hash]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value := line, (*bool)(nil)
+		if eq := strings.IndexByte(line, '='); eq >= 0 {
+			name = strings.TrimSpace(line[:eq])
+			switch v := strings.TrimSpace(line[eq+1:]); v {
+			case "true", "false":
+				b := v == "true"
+				value = &b
+			default:
+				return fmt.Errorf("%s:%d: invalid value %q, must be true or false", file, i+1, v)
+			}
+		}
+		if err := f(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %s", file, i+1, err)
+		}
+	}
+	return nil
+}
+
+// parseFlags returns the flags declared in the contents of .flags files, keyed by file name,
+// with the values set by the contents of values files applied.
+func parseFlags(pkg string, flagsFiles, valuesFiles map[string][]byte) ([]featureFlag, error) {
+	flags := make(map[string]*featureFlag)
+	for _, file := range sortedKeys(flagsFiles) {
+		err := parseLines(file, flagsFiles[file], func(name string, value *bool) error {
+			if !flagNameRe.MatchString(name) {
+				return fmt.Errorf("invalid flag name %q, must match %s", name, flagNameRe)
+			}
+			if flags[name] != nil {
+				return fmt.Errorf("duplicate flag %q", name)
+			}
+			flags[name] = &featureFlag{name: name, value: value != nil && *value}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range sortedKeys(valuesFiles) {
+		err := parseLines(file, valuesFiles[file], func(name string, value *bool) error {
+			if value == nil {
+				return fmt.Errorf("missing value for %q", name)
+			}
+			if !strings.HasPrefix(name, pkg+".") {
+				// Values of the flags of other packages.
+				return nil
+			}
+			f := flags[strings.TrimPrefix(name, pkg+".")]
+			if f == nil {
+				return fmt.Errorf("value set for undeclared flag %q", name)
+			}
+			f.value = *value
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ret []featureFlag
+	for _, f := range flags {
+		ret = append(ret, *f)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret, nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// camelCase converts a flag name to the lower camel case name of its Java accessor.
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+const header = "// Generated by feature_flag_codegen. DO NOT EDIT.\n\n"
+
+// generate returns the generated files, keyed by their path relative to the output directory.
+// The files are named after the last component of the package, which is also used as the
+// Java package, the C++ namespace and the Rust crate.
+func generate(pkg, language string, flags []featureFlag) (map[string][]byte, error) {
+	stem := pkg[strings.LastIndex(pkg, ".")+1:]
+	ret := make(map[string][]byte)
+	switch language {
+	case "cc":
+		namespace := strings.ReplaceAll(pkg, ".", "::")
+		h, cpp := &bytes.Buffer{}, &bytes.Buffer{}
+		guard := strings.ToUpper(strings.ReplaceAll(pkg, ".", "_")) + "_FLAGS_H_"
+		fmt.Fprintf(h, "%s#ifndef %s\n#define %s\n\nnamespace %s {\n\n", header, guard, guard, namespace)
+		fmt.Fprintf(cpp, "%s#include \"%s.h\"\n\nnamespace %s {\n\n", header, stem, namespace)
+		for _, f := range flags {
+			fmt.Fprintf(h, "bool %s();\n", f.name)
+			fmt.Fprintf(cpp, "bool %s() {\n  return %t;\n}\n\n", f.name, f.value)
+		}
+		fmt.Fprintf(h, "\n}  // namespace %s\n\n#endif  // %s\n", namespace, guard)
+		fmt.Fprintf(cpp, "}  // namespace %s\n", namespace)
+		ret[filepath.Join("include", stem+".h")] = h.Bytes()
+		ret[stem+".cpp"] = cpp.Bytes()
+	case "java":
+		java := &bytes.Buffer{}
+		fmt.Fprintf(java, "%spackage %s;\n\n/** @hide */\npublic final class Flags {\n", header, pkg)
+		fmt.Fprintf(java, "    private Flags() {}\n")
+		for _, f := range flags {
+			fmt.Fprintf(java, "\n    public static boolean %s() {\n        return %t;\n    }\n",
+				camelCase(f.name), f.value)
+		}
+		fmt.Fprintf(java, "}\n")
+		ret[filepath.Join(filepath.Join(strings.Split(pkg, ".")...), "Flags.java")] = java.Bytes()
+	case "rust":
+		rs := &bytes.Buffer{}
+		fmt.Fprint(rs, header)
+		for i, f := range flags {
+			if i > 0 {
+				fmt.Fprintln(rs)
+			}
+			fmt.Fprintf(rs, "pub fn %s() -> bool {\n    %t\n}\n", f.name, f.value)
+		}
+		ret["lib.rs"] = rs.Bytes()
+	default:
+		return nil, fmt.Errorf("unsupported language %q", language)
+	}
+	return ret, nil
+}
+
+func readFiles(files []string) (map[string][]byte, error) {
+	ret := make(map[string][]byte)
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		ret[file] = contents
+	}
+	return ret, nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: feature_flag_codegen --package <package> --language cc|java|rust "+
+			"[--values <file>]... --out-dir <dir> <flags file>...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *pkg == "" || *outDir == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	err := func() error {
+		flagsFiles, err := readFiles(flag.Args())
+		if err != nil {
+			return err
+		}
+		valuesFiles, err := readFiles(values)
+		if err != nil {
+			return err
+		}
+		flags, err := parseFlags(*pkg, flagsFiles, valuesFiles)
+		if err != nil {
+			return err
+		}
+		files, err := generate(*pkg, *language, flags)
+		if err != nil {
+			return err
+		}
+		for _, name := range sortedKeys(files) {
+			path := filepath.Join(*outDir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path, files[name], 0666); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "feature_flag_codegen:", err)
+		os.Exit(1)
+	}
+}
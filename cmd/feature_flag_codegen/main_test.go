@@ -0,0 +1,158 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	flagsFiles := map[string][]byte{
+		"foo.flags": []byte(`
+			# Flags of foo.
+			enable_bar
+			enable_baz = true  # On by default.
+			enable_qux = true
+		`),
+	}
+	valuesFiles := map[string][]byte{
+		"release.values": []byte(`
+			com.android.foo.enable_bar = true
+			com.android.foo.enable_qux = false
+			com.android.other.enable_bar = false
+		`),
+	}
+
+	flags, err := parseFlags("com.android.foo", flagsFiles, valuesFiles)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []featureFlag{
+		{name: "enable_bar", value: true},
+		{name: "enable_baz", value: true},
+		{name: "enable_qux", value: false},
+	}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("expected %v, got %v", expected, flags)
+	}
+}
+
+func TestParseFlagsErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		flags  string
+		values string
+		err    string
+	}{
+		{
+			name:  "invalid name",
+			flags: "EnableBar",
+			err:   `foo.flags:1: invalid flag name "EnableBar"`,
+		},
+		{
+			name:  "duplicate flag",
+			flags: "enable_bar\nenable_bar",
+			err:   `foo.flags:2: duplicate flag "enable_bar"`,
+		},
+		{
+			name:  "invalid value",
+			flags: "enable_bar = yes",
+			err:   `foo.flags:1: invalid value "yes", must be true or false`,
+		},
+		{
+			name:   "undeclared flag",
+			flags:  "enable_bar",
+			values: "com.android.foo.enable_baz = true",
+			err:    `release.values:1: value set for undeclared flag "com.android.foo.enable_baz"`,
+		},
+		{
+			name:   "missing value",
+			flags:  "enable_bar",
+			values: "com.android.foo.enable_bar",
+			err:    `release.values:1: missing value for "com.android.foo.enable_bar"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseFlags("com.android.foo",
+				map[string][]byte{"foo.flags": []byte(tc.flags)},
+				map[string][]byte{"release.values": []byte(tc.values)})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	flags := []featureFlag{
+		{name: "enable_bar", value: true},
+		{name: "enable_baz", value: false},
+	}
+
+	testCases := []struct {
+		language string
+		file     string
+		contains []string
+	}{
+		{
+			language: "cc",
+			file:     "include/foo.h",
+			contains: []string{"namespace com::android::foo {", "bool enable_bar();", "bool enable_baz();"},
+		},
+		{
+			language: "cc",
+			file:     "foo.cpp",
+			contains: []string{`#include "foo.h"`, "bool enable_bar() {\n  return true;\n}"},
+		},
+		{
+			language: "java",
+			file:     "com/android/foo/Flags.java",
+			contains: []string{"package com.android.foo;",
+				"public static boolean enableBar() {\n        return true;\n    }",
+				"public static boolean enableBaz() {\n        return false;\n    }"},
+		},
+		{
+			language: "rust",
+			file:     "lib.rs",
+			contains: []string{"pub fn enable_bar() -> bool {\n    true\n}", "pub fn enable_baz() -> bool {\n    false\n}"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.language+" "+tc.file, func(t *testing.T) {
+			files, err := generate("com.android.foo", tc.language, flags)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			contents, ok := files[tc.file]
+			if !ok {
+				t.Fatalf("%s not generated, got %v", tc.file, sortedKeys(files))
+			}
+			for _, s := range tc.contains {
+				if !strings.Contains(string(contents), s) {
+					t.Errorf("expected %s to contain %q, got:\n%s", tc.file, s, contents)
+				}
+			}
+		})
+	}
+
+	if _, err := generate("com.android.foo", "go", flags); err == nil {
+		t.Errorf("expected an error for an unsupported language")
+	}
+}
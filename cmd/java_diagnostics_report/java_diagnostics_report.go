@@ -0,0 +1,321 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// java_diagnostics_report merges the javac, errorprone and lint diagnostics of the java modules
+// into a single report keyed by source file. The same source file is often compiled by several
+// modules or variants, so identical diagnostics are only reported once, and each source file is
+// annotated with the owners and names of the modules that compile it.
+//
+// The input is a manifest with one line per diagnostics file, containing the tab separated name
+// of the module, the space separated OWNERS files that apply to the directory of the module from
+// the nearest one up (which may be empty), the kind of the file ("javac" for the files written by
+// soong_javac_wrapper, or "lint" for lint XML reports) and its path. The owners of a module are
+// the ones listed in its OWNERS files, up to the first one that contains "set noparent".
+// Diagnostics files that don't exist are skipped, as soong_javac_wrapper only writes them when
+// javac runs.
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	outputFile = flag.String("o", "", "output file")
+)
+
+var javacDiagnosticRe = regexp.MustCompile(`^(.+\.java):([0-9]+): (warning|error): (.*)$`)
+
+type diagnostic struct {
+	line     int
+	severity string
+	message  string
+}
+
+type sourceFile struct {
+	owners      map[string]bool
+	modules     map[string]bool
+	diagnostics map[diagnostic]bool
+}
+
+type report struct {
+	files map[string]*sourceFile
+
+	// The owners listed in each OWNERS file, and whether the file contains "set noparent".
+	owners   map[string][]string
+	noparent map[string]bool
+}
+
+func newReport() *report {
+	return &report{
+		files:    make(map[string]*sourceFile),
+		owners:   make(map[string][]string),
+		noparent: make(map[string]bool),
+	}
+}
+
+// parseOwners returns the owners listed in the content of an OWNERS file, and whether it contains
+// "set noparent". The per-file, file: and include directives are ignored, as they don't apply to
+// all the files of the directory.
+func parseOwners(content string) ([]string, bool) {
+	var owners []string
+	noparent := false
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "set noparent":
+			noparent = true
+		case strings.Contains(line, "@") && !strings.ContainsAny(line, " \t:="):
+			owners = append(owners, line)
+		}
+	}
+	return owners, noparent
+}
+
+// ownersOf returns the owners listed in the OWNERS files of a module, from the nearest one up to
+// the first one that contains "set noparent".
+func (r *report) ownersOf(ownersFiles []string) ([]string, error) {
+	var ret []string
+	for _, file := range ownersFiles {
+		if _, ok := r.owners[file]; !ok {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			owners, noparent := parseOwners(string(content))
+			r.owners[file] = owners
+			r.noparent[file] = noparent
+		}
+		ret = append(ret, r.owners[file]...)
+		if r.noparent[file] {
+			break
+		}
+	}
+	return ret, nil
+}
+
+func (r *report) add(module string, owners []string, file string, d diagnostic) {
+	f := r.files[file]
+	if f == nil {
+		f = &sourceFile{
+			owners:      make(map[string]bool),
+			modules:     make(map[string]bool),
+			diagnostics: make(map[diagnostic]bool),
+		}
+		r.files[file] = f
+	}
+	for _, owner := range owners {
+		f.owners[owner] = true
+	}
+	f.modules[module] = true
+	f.diagnostics[d] = true
+}
+
+// addJavac adds the diagnostics written by soong_javac_wrapper for a module.
+func (r *report) addJavac(module string, owners []string, in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 2*1024*1024)
+	for scanner.Scan() {
+		match := javacDiagnosticRe.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		line, err := strconv.Atoi(match[2])
+		if err != nil {
+			return err
+		}
+		r.add(module, owners, match[1], diagnostic{line, match[3], match[4]})
+	}
+	return scanner.Err()
+}
+
+type lintIssues struct {
+	Issues []lintIssue `xml:"issue"`
+}
+
+type lintIssue struct {
+	Id        string         `xml:"id,attr"`
+	Severity  string         `xml:"severity,attr"`
+	Message   string         `xml:"message,attr"`
+	Locations []lintLocation `xml:"location"`
+}
+
+type lintLocation struct {
+	File string `xml:"file,attr"`
+	Line int    `xml:"line,attr"`
+}
+
+// addLint adds the issues in the lint XML report of a module. Only the first location of an
+// issue is used, the others point to related code.
+func (r *report) addLint(module string, owners []string, in io.Reader) error {
+	var issues lintIssues
+	if err := xml.NewDecoder(in).Decode(&issues); err != nil {
+		return err
+	}
+	for _, issue := range issues.Issues {
+		if len(issue.Locations) == 0 {
+			continue
+		}
+		loc := issue.Locations[0]
+		r.add(module, owners, loc.File, diagnostic{
+			line:     loc.Line,
+			severity: strings.ToLower(issue.Severity),
+			message:  fmt.Sprintf("[%s] %s", issue.Id, issue.Message),
+		})
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (r *report) write(w io.Writer) {
+	var files []string
+	count := 0
+	for file, f := range r.files {
+		files = append(files, file)
+		count += len(f.diagnostics)
+	}
+	sort.Strings(files)
+
+	fmt.Fprintf(w, "%d diagnostics in %d files\n", count, len(files))
+	for _, file := range files {
+		f := r.files[file]
+
+		owners := "unknown"
+		if len(f.owners) > 0 {
+			owners = strings.Join(sortedKeys(f.owners), ", ")
+		}
+
+		var diagnostics []diagnostic
+		for d := range f.diagnostics {
+			diagnostics = append(diagnostics, d)
+		}
+		sort.Slice(diagnostics, func(i, j int) bool {
+			a, b := diagnostics[i], diagnostics[j]
+			if a.line != b.line {
+				return a.line < b.line
+			}
+			if a.severity != b.severity {
+				return a.severity < b.severity
+			}
+			return a.message < b.message
+		})
+
+		fmt.Fprintf(w, "\n%s\n", file)
+		fmt.Fprintf(w, "  owners: %s\n", owners)
+		fmt.Fprintf(w, "  modules: %s\n", strings.Join(sortedKeys(f.modules), ", "))
+		for _, d := range diagnostics {
+			fmt.Fprintf(w, "  %d: %s: %s\n", d.line, d.severity, d.message)
+		}
+	}
+}
+
+// addManifest adds the diagnostics files listed in the manifest.
+func (r *report) addManifest(manifest string) error {
+	for i, line := range strings.Split(manifest, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			return fmt.Errorf("manifest line %d: expected 4 tab separated fields, got %q", i+1, line)
+		}
+		module, ownersFiles, kind, path := fields[0], strings.Fields(fields[1]), fields[2], fields[3]
+
+		var add func(module string, owners []string, in io.Reader) error
+		switch kind {
+		case "javac":
+			add = r.addJavac
+		case "lint":
+			add = r.addLint
+		default:
+			return fmt.Errorf("manifest line %d: unknown kind %q", i+1, kind)
+		}
+
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		owners, err := r.ownersOf(ownersFiles)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = add(module, owners, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: java_diagnostics_report -o <output file> <manifest>")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *outputFile == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	manifest, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r := newReport()
+	if err := r.addManifest(string(manifest)); err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := os.Create(*outputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(out)
+	r.write(w)
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
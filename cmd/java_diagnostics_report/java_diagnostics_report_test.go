@@ -0,0 +1,153 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	r := newReport()
+
+	javacFoo := `a/Foo.java:12: warning: [deprecation] bar() in Bar has been deprecated
+a/Foo.java:3: error: cannot find symbol
+Note: Some input files use unchecked or unsafe operations.
+`
+	// The same source file compiled by a second module reports the same diagnostics.
+	javacFooHost := `a/Foo.java:12: warning: [deprecation] bar() in Bar has been deprecated
+b/Baz.java:7: warning: [RectIntersectReturnValueIgnored] Return value must be checked
+`
+	lint := `<?xml version="1.0" encoding="UTF-8"?>
+<issues format="6" by="lint">
+    <issue id="NewApi" severity="Error" message="Call requires API level 30">
+        <location file="a/Foo.java" line="20" column="5"/>
+        <location file="a/Other.java" line="1" column="1"/>
+    </issue>
+    <issue id="NoLocation" severity="Warning" message="ignored"/>
+</issues>
+`
+
+	if err := r.addJavac("libfoo", []string{"foo@google.com"}, strings.NewReader(javacFoo)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.addJavac("libfoo-host", nil, strings.NewReader(javacFooHost)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.addLint("libfoo", []string{"foo@google.com", "bar@google.com"}, strings.NewReader(lint)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	r.write(buf)
+
+	expected := `4 diagnostics in 2 files
+
+a/Foo.java
+  owners: bar@google.com, foo@google.com
+  modules: libfoo, libfoo-host
+  3: error: cannot find symbol
+  12: warning: [deprecation] bar() in Bar has been deprecated
+  20: error: [NewApi] Call requires API level 30
+
+b/Baz.java
+  owners: unknown
+  modules: libfoo-host
+  7: warning: [RectIntersectReturnValueIgnored] Return value must be checked
+`
+	if got := buf.String(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	testCases := []struct {
+		manifest string
+		err      string
+	}{
+		{
+			manifest: "libfoo\tfoo_team\tjavac\n",
+			err:      `manifest line 1: expected 4 tab separated fields, got "libfoo\tfoo_team\tjavac"`,
+		},
+		{
+			manifest: "\nlibfoo\tfoo_team\tkotlinc\tfoo.txt\n",
+			err:      `manifest line 2: unknown kind "kotlinc"`,
+		},
+	}
+	for _, tc := range testCases {
+		err := newReport().addManifest(tc.manifest)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestOwners(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"OWNERS": "top@google.com\n",
+		"a/OWNERS": `# The owners of a.
+set noparent
+a@google.com # lead
+per-file *.bp = build@google.com
+file:platform/build/soong:/OWNERS
+include /b/OWNERS
+`,
+		"a/b/OWNERS": "b@google.com\n*\n",
+		"c/OWNERS":   "c@google.com\n",
+	}
+	files["foo.txt"] = "a/b/Foo.java:1: warning: foo\n"
+	files["bar.txt"] = "c/Bar.java:2: warning: bar\n"
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := func(name string) string { return filepath.Join(dir, name) }
+	manifest := "libfoo\t" + path("a/b/OWNERS") + " " + path("a/OWNERS") + " " + path("OWNERS") + "\tjavac\t" + path("foo.txt") + "\n" +
+		"libbar\t" + path("c/OWNERS") + " " + path("OWNERS") + "\tjavac\t" + path("bar.txt") + "\n"
+	r := newReport()
+	if err := r.addManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	// The OWNERS file of a sets noparent, so the top-level owners don't own libfoo.
+	if got, want := sortedKeys(r.files["a/b/Foo.java"].owners), []string{"a@google.com", "b@google.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("owners of a/b/Foo.java: expected %q, got %q", want, got)
+	}
+	if got, want := sortedKeys(r.files["c/Bar.java"].owners), []string{"c@google.com", "top@google.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("owners of c/Bar.java: expected %q, got %q", want, got)
+	}
+}
+
+func TestManifestMissingFile(t *testing.T) {
+	r := newReport()
+	if err := r.addManifest("libfoo\t\tjavac\tdoes/not/exist.txt\n"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.files) != 0 {
+		t.Errorf("expected no files, got %v", r.files)
+	}
+}
@@ -18,6 +18,10 @@
 // It also hides the unhelpful and unhideable "warning there is a warning"
 // messages.
 //
+// If the command line starts with --diagnostics_file=<path>, the warnings and
+// errors reported for java source files are also written to <path>, one per
+// line and without colors, so that they can be collected into a report.
+//
 // Each javac build statement has an order-only dependency on the
 // soong_javac_wrapper tool, which means the javac command will not be rerun
 // if soong_javac_wrapper changes.  That means that soong_javac_wrapper must
@@ -32,6 +36,7 @@ import (
 	"os/exec"
 	"regexp"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -44,6 +49,8 @@ var (
 	errorRe        = regexp.MustCompile(filelinePrefix + `(.*?:) .*$`)
 	markerRe       = regexp.MustCompile(`()\s*(\^)\s*$`)
 
+	diagnosticRe = regexp.MustCompile(`^[-.\w/\\]+\.java:[0-9]+: (warning|error): .*$`)
+
 	escape  = "\x1b"
 	reset   = escape + "[0m"
 	bold    = escape + "[1m"
@@ -60,9 +67,27 @@ func main() {
 	os.Exit(exitCode)
 }
 
+const diagnosticsFileFlag = "--diagnostics_file="
+
 func Main(out io.Writer, name string, args []string) (int, error) {
+	var diagnosticsFile string
+	if len(args) > 0 && strings.HasPrefix(args[0], diagnosticsFileFlag) {
+		diagnosticsFile = strings.TrimPrefix(args[0], diagnosticsFileFlag)
+		args = args[1:]
+	}
+
 	if len(args) < 1 {
-		return 1, fmt.Errorf("usage: %s javac ...", name)
+		return 1, fmt.Errorf("usage: %s [%s<file>] javac ...", name, diagnosticsFileFlag)
+	}
+
+	proc := processor{}
+	if diagnosticsFile != "" {
+		f, err := os.Create(diagnosticsFile)
+		if err != nil {
+			return 1, fmt.Errorf("creating diagnostics file: %s", err)
+		}
+		defer f.Close()
+		proc.diagnostics = f
 	}
 
 	pr, pw, err := os.Pipe()
@@ -81,7 +106,6 @@ func Main(out io.Writer, name string, args []string) (int, error) {
 
 	pw.Close()
 
-	proc := processor{}
 	// Process subprocess stdout asynchronously
 	errCh := make(chan error)
 	go func() {
@@ -121,6 +145,9 @@ func Main(out io.Writer, name string, args []string) (int, error) {
 
 type processor struct {
 	silencedWarnings int
+
+	// diagnostics receives the uncolored warnings and errors for java source files, if set.
+	diagnostics io.Writer
 }
 
 func (proc *processor) process(r io.Reader, w io.Writer) error {
@@ -165,6 +192,9 @@ func (proc *processor) processLine(w io.Writer, line string) {
 			}
 		}
 	}
+	if proc.diagnostics != nil && diagnosticRe.MatchString(line) {
+		fmt.Fprintln(proc.diagnostics, line)
+	}
 	for _, p := range colorPatterns {
 		var matched bool
 		if line, matched = applyColor(line, p.color, p.re); matched {
@@ -17,6 +17,8 @@ package main
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 )
@@ -109,6 +111,29 @@ func TestJavacColorize(t *testing.T) {
 	}
 }
 
+func TestJavacDiagnostics(t *testing.T) {
+	in := `File.java:40: error: cannot find symbol
+import static com.blah.SYMBOL;
+          ^
+dir/File.java:398: warning: [RectIntersectReturnValueIgnored] Return value of com.blah.function() must be checked
+warning: [options] blah
+warning: [options] bootstrap class path not set in conjunction with -source 1.9
+2 warnings
+`
+	expected := `File.java:40: error: cannot find symbol
+dir/File.java:398: warning: [RectIntersectReturnValueIgnored] Return value of com.blah.function() must be checked
+`
+
+	diagnostics := new(bytes.Buffer)
+	proc := processor{diagnostics: diagnostics}
+	if err := proc.process(bytes.NewReader([]byte(in)), ioutil.Discard); err != nil {
+		t.Fatalf("error: %q", err)
+	}
+	if got := diagnostics.String(); got != expected {
+		t.Errorf("expected %q got %q", expected, got)
+	}
+}
+
 func TestSubprocess(t *testing.T) {
 	t.Run("failure", func(t *testing.T) {
 		exitCode, err := Main(ioutil.Discard, "test", []string{"sh", "-c", "exit 9"})
@@ -140,4 +165,28 @@ func TestSubprocess(t *testing.T) {
 		}
 	})
 
+	t.Run("diagnostics file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "javac_wrapper")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		diagnosticsFile := filepath.Join(dir, "diagnostics.txt")
+
+		exitCode, err := Main(ioutil.Discard, "test", []string{"--diagnostics_file=" + diagnosticsFile,
+			"sh", "-c", "echo 'File.java:1: warning: foo' >&2; exit 3"})
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if exitCode != 3 {
+			t.Fatal("expected exit code 3, got", exitCode)
+		}
+		got, err := ioutil.ReadFile(diagnosticsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "File.java:1: warning: foo\n"; string(got) != expected {
+			t.Errorf("expected %q got %q", expected, string(got))
+		}
+	})
 }
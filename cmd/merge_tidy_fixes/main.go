@@ -0,0 +1,125 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This tool merges the fixes exported by clang-tidy with -export-fixes for the sources of a
+// module into a single file, which can be applied with clang-apply-replacements. Fixes for
+// headers that are included by several sources are only listed once. Empty input files, which are
+// written when clang-tidy found nothing to fix, are skipped.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// diagnostics returns the entries of the Diagnostics list of a file of fixes exported by
+// clang-tidy, each with its trailing newline.
+func diagnostics(contents string) []string {
+	var ret []string
+	inList := false
+	var entry strings.Builder
+	flush := func() {
+		if entry.Len() > 0 {
+			ret = append(ret, entry.String())
+			entry.Reset()
+		}
+	}
+	for _, line := range strings.SplitAfter(contents, "\n") {
+		trimmed := strings.TrimRight(line, "\n")
+		switch {
+		case !inList:
+			inList = trimmed == "Diagnostics:"
+		case trimmed == "..." || (trimmed != "" && !strings.HasPrefix(trimmed, " ")):
+			// The end of the document or the next top level key.
+			flush()
+			inList = false
+		case strings.HasPrefix(trimmed, "  - "):
+			flush()
+			entry.WriteString(line)
+		default:
+			entry.WriteString(line)
+		}
+	}
+	flush()
+	return ret
+}
+
+// merge returns a file of fixes with the diagnostics of all the given files of fixes.
+func merge(files []string) string {
+	var entries []string
+	seen := make(map[string]bool)
+	for _, contents := range files {
+		for _, entry := range diagnostics(contents) {
+			if !seen[entry] {
+				seen[entry] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("---\n")
+	sb.WriteString("MainSourceFile:  ''\n")
+	if len(entries) == 0 {
+		sb.WriteString("Diagnostics:     []\n")
+	} else {
+		sb.WriteString("Diagnostics:\n")
+		for _, entry := range entries {
+			sb.WriteString(entry)
+		}
+	}
+	sb.WriteString("...\n")
+	return sb.String()
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -o <output> [-l <file list>] [<fixes>...]", os.Args[0])
+		flag.PrintDefaults()
+	}
+	output := flag.String("o", "", "Output file of merged fixes")
+	list := flag.String("l", "", "File containing a list of files of fixes")
+	flag.Parse()
+
+	if *output == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	inputs := flag.Args()
+	if *list != "" {
+		contents, err := ioutil.ReadFile(*list)
+		if err != nil {
+			log.Fatalf("Error opening %q: %v", *list, err)
+		}
+		inputs = append(inputs, strings.Fields(string(contents))...)
+	}
+
+	var files []string
+	for _, input := range inputs {
+		contents, err := ioutil.ReadFile(input)
+		if err != nil {
+			log.Fatalf("Error opening %q: %v", input, err)
+		}
+		files = append(files, string(contents))
+	}
+
+	if err := ioutil.WriteFile(*output, []byte(merge(files)), 0666); err != nil {
+		log.Fatalf("Failed to write to %q: %v", *output, err)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+const headerFix = `  - DiagnosticName:  modernize-use-nullptr
+    DiagnosticMessage:
+      Message:         use nullptr
+      FilePath:        'foo/a.h'
+      FileOffset:      10
+      Replacements:
+        - FilePath:        'foo/a.h'
+          Offset:          10
+          Length:          1
+          ReplacementText: nullptr
+    Level:           Warning
+`
+
+const sourceFix = `  - DiagnosticName:  modernize-use-nullptr
+    DiagnosticMessage:
+      Message:         use nullptr
+      FilePath:        'foo/a.cpp'
+      FileOffset:      20
+      Replacements:
+        - FilePath:        'foo/a.cpp'
+          Offset:          20
+          Length:          1
+          ReplacementText: nullptr
+    Level:           Warning
+`
+
+func TestMerge(t *testing.T) {
+	a := "---\nMainSourceFile:  'foo/a.cpp'\nDiagnostics:\n" + headerFix + sourceFix + "...\n"
+	b := "---\nMainSourceFile:  'foo/b.cpp'\nDiagnostics:\n" + headerFix + "...\n"
+
+	want := "---\nMainSourceFile:  ''\nDiagnostics:\n" + headerFix + sourceFix + "...\n"
+	if got := merge([]string{a, "", b}); got != want {
+		t.Errorf("merge() = %q, want %q", got, want)
+	}
+
+	want = "---\nMainSourceFile:  ''\nDiagnostics:     []\n...\n"
+	if got := merge([]string{""}); got != want {
+		t.Errorf("merge() of empty files = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"android/soong/android"
+	"android/soong/shared"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/bootstrap"
+)
+
+// The single-module analysis mode analyzes only the Android.bp files needed by one module, so that
+// IDE integrations can get the variants, outputs and dependencies of a module without waiting for
+// the whole tree to be analyzed. The files are the Android.bp files defining the module and the
+// files they reference, transitively, found the same way as for the Android.bp validation mode.
+//
+// The dependencies that mutators add implicitly (e.g. on libc, the crt objects or the system
+// modules) aren't found in the strings of the Android.bp files. So the analysis runs with missing
+// dependencies allowed, and is repeated with the files defining the dependencies that were missing
+// in the closure of the module, until none are. The files analyzed in the end follow the actual
+// dependency graph of the module. Dependencies that no Android.bp file defines fail the analysis.
+//
+// Finding the files needs the modules defined by and the strings in every Android.bp file of the
+// tree. They are kept in a package index that is updated incrementally: only the Android.bp files
+// whose modification time changed since the index was written are parsed again.
+
+// packageIndexEntry is the entry of an Android.bp file in the package index.
+type packageIndexEntry struct {
+	ModTime int64    `json:"mtime"`
+	Modules []string `json:"modules"`
+	Strings []string `json:"strings"`
+}
+
+// updatePackageIndex returns the information of the Android.bp files in allFiles, using the
+// package index at indexPath for the files that didn't change, and updates the index.
+func updatePackageIndex(indexPath string, allFiles []string) (map[string]bpFileInfo, error) {
+	absIndexPath := shared.JoinPath(topDir, indexPath)
+
+	old := make(map[string]packageIndexEntry)
+	if data, err := ioutil.ReadFile(absIndexPath); err == nil {
+		if err := json.Unmarshal(data, &old); err != nil {
+			// A corrupt index is rebuilt from scratch.
+			old = make(map[string]packageIndexEntry)
+		}
+	}
+
+	changed := len(old) != len(allFiles)
+	index := make(map[string]packageIndexEntry, len(allFiles))
+	infos := make(map[string]bpFileInfo, len(allFiles))
+	for _, path := range allFiles {
+		stat, err := os.Stat(shared.JoinPath(topDir, path))
+		if err != nil {
+			return nil, err
+		}
+		modTime := stat.ModTime().UnixNano()
+
+		entry, ok := old[path]
+		if !ok || entry.ModTime != modTime {
+			info, err := parseBpFileInfo(path)
+			if err != nil {
+				return nil, err
+			}
+			entry = packageIndexEntry{ModTime: modTime, Modules: info.modules, Strings: info.strings}
+			changed = true
+		}
+		index[path] = entry
+		infos[path] = bpFileInfo{modules: entry.Modules, strings: entry.Strings}
+	}
+
+	if changed {
+		data, err := json.Marshal(index)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(absIndexPath), 0777); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(absIndexPath, data, 0666); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// filesDefiningDependency returns the Android.bp files that can define the module named by a
+// missing dependency: the files defining it, or the files defining the module that creates it,
+// e.g. "foo" for the "prebuilt_foo" or the "foo.stubs.source" module.
+func (g *bpFileGraph) filesDefiningDependency(dep string) []string {
+	name := referencedName(dep)
+	if strings.HasPrefix(name, "//") {
+		// A fully qualified name, //namespace:module.
+		name = name[strings.LastIndex(name, ":")+1:]
+	}
+	for _, candidate := range []string{name, strings.TrimPrefix(name, "prebuilt_")} {
+		for {
+			if files := g.filesDefiningModule[candidate]; len(files) > 0 {
+				return files
+			}
+			i := strings.LastIndex(candidate, ".")
+			if i < 0 {
+				break
+			}
+			candidate = candidate[:i]
+		}
+	}
+	return nil
+}
+
+// analyzeModuleClosure analyzes the Android.bp files needed by the module named name with analyze,
+// which returns the dependencies that were missing in the closure of the module. It starts from
+// the closure of the files defining the module, and adds the files defining the missing
+// dependencies until none are. It returns the analyzed files.
+func analyzeModuleClosure(graph *bpFileGraph, name string,
+	analyze func(neededFiles []string) ([]string, error)) ([]string, error) {
+
+	roots := graph.filesDefiningModule[name]
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("module %q is not defined in any Android.bp file", name)
+	}
+	neededFiles := graph.closure(roots)
+	for {
+		missingDeps, err := analyze(neededFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		var newFiles, undefined, unresolved []string
+		for _, dep := range missingDeps {
+			files := graph.filesDefiningDependency(dep)
+			if len(files) == 0 {
+				undefined = append(undefined, dep)
+				continue
+			}
+			added := false
+			for _, file := range files {
+				if !android.InList(file, neededFiles) {
+					newFiles = append(newFiles, file)
+					added = true
+				}
+			}
+			if !added {
+				unresolved = append(unresolved, dep)
+			}
+		}
+
+		if len(undefined) > 0 {
+			return nil, fmt.Errorf("dependencies of %q are not defined in any Android.bp file: %s",
+				name, strings.Join(undefined, ", "))
+		}
+		if len(newFiles) == 0 {
+			if len(unresolved) > 0 {
+				// The files defining them were analyzed, so they are missing in the whole tree too.
+				return nil, fmt.Errorf("dependencies of %q are missing: %s",
+					name, strings.Join(unresolved, ", "))
+			}
+			return neededFiles, nil
+		}
+		neededFiles = graph.closure(append(neededFiles, newFiles...))
+	}
+}
+
+// missingDependencies returns the dependencies that were missing in ctx in the transitive closure
+// of the variants of the module named name.
+func missingDependencies(ctx *android.Context, name string) []string {
+	var missingDeps []string
+	visited := make(map[blueprint.Module]bool)
+	var visit func(m blueprint.Module)
+	visit = func(m blueprint.Module) {
+		if visited[m] {
+			return
+		}
+		visited[m] = true
+		if module, ok := m.(android.Module); ok {
+			missingDeps = append(missingDeps, module.AllowedMissingDependencies()...)
+		}
+		ctx.VisitDirectDeps(m, visit)
+	}
+	ctx.VisitAllModules(func(m blueprint.Module) {
+		if ctx.ModuleName(m) == name {
+			visit(m)
+		}
+	})
+	return android.SortedUniqueStrings(missingDeps)
+}
+
+// analyzedVariant is the analysis of one variant of the analyzed module.
+type analyzedVariant struct {
+	Name         string
+	Variant      string
+	Type         string
+	Blueprint    string
+	OutputFiles  []string `json:",omitempty"`
+	InstallFiles []string `json:",omitempty"`
+	Deps         []string `json:",omitempty"`
+}
+
+// analyzeVariants returns the analysis of the variants of the module named name in ctx.
+func analyzeVariants(ctx *android.Context, name string) []analyzedVariant {
+	var variants []analyzedVariant
+	ctx.VisitAllModules(func(m blueprint.Module) {
+		if ctx.ModuleName(m) != name {
+			return
+		}
+		variant := analyzedVariant{
+			Name:      name,
+			Variant:   ctx.ModuleSubDir(m),
+			Type:      ctx.ModuleType(m),
+			Blueprint: ctx.BlueprintFile(m),
+		}
+		if producer, ok := m.(android.OutputFileProducer); ok {
+			if outputs, err := producer.OutputFiles(""); err == nil {
+				variant.OutputFiles = outputs.Strings()
+			}
+		}
+		if module, ok := m.(android.Module); ok {
+			variant.InstallFiles = module.FilesToInstall().Strings()
+		}
+		ctx.VisitDirectDeps(m, func(dep blueprint.Module) {
+			variant.Deps = append(variant.Deps, ctx.ModuleName(dep)+"{"+ctx.ModuleSubDir(dep)+"}")
+		})
+		variant.Deps = android.FirstUniqueStrings(variant.Deps)
+		variants = append(variants, variant)
+	})
+	sort.Slice(variants, func(i, j int) bool { return variants[i].Variant < variants[j].Variant })
+	return variants
+}
+
+// runAnalyzeModule analyzes the Android.bp files needed by analyzeModule and writes the analysis
+// of its variants to analyzeModuleOutput.
+func runAnalyzeModule(configuration android.Config, extraNinjaDeps []string) {
+	if analyzeModuleOutput == "" {
+		fmt.Fprintf(os.Stderr, "--analyze-module requires --analyze-module-output\n")
+		os.Exit(1)
+	}
+	indexPath := packageIndexFile
+	if indexPath == "" {
+		indexPath = filepath.Join(filepath.Dir(analyzeModuleOutput), "package_index.json")
+	}
+
+	allFiles, err := readFileList(cmdlineArgs.ModuleListFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading module list file: %s\n", err)
+		os.Exit(1)
+	}
+	infos, err := updatePackageIndex(indexPath, allFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error updating package index %s: %s\n", indexPath, err)
+		os.Exit(1)
+	}
+
+	neededListFile := strings.TrimSuffix(analyzeModuleOutput, filepath.Ext(analyzeModuleOutput)) + ".list"
+	var ctx *android.Context
+	var ninjaDeps []string
+	runConfig := configuration
+	_, err = analyzeModuleClosure(newBpFileGraph(infos), analyzeModule, func(neededFiles []string) ([]string, error) {
+		fmt.Fprintf(os.Stderr, "Analyzing %s with %d of %d Android.bp files\n",
+			analyzeModule, len(neededFiles), len(allFiles))
+
+		err := ioutil.WriteFile(shared.JoinPath(topDir, neededListFile),
+			[]byte(strings.Join(neededFiles, "\n")+"\n"), 0666)
+		if err != nil {
+			return nil, fmt.Errorf("error writing %s: %s", neededListFile, err)
+		}
+
+		if ctx != nil {
+			// The configuration keeps state from the previous run.
+			runConfig, err = android.ConfigForAdditionalRun(configuration)
+			if err != nil {
+				return nil, err
+			}
+		}
+		runConfig.SetAllowMissingDependencies()
+		ctx = newContext(runConfig)
+		ctx.EventHandler.Begin("analyze_module")
+
+		blueprintArgs := cmdlineArgs
+		blueprintArgs.ModuleListFile = neededListFile
+		ninjaDeps = bootstrap.RunBlueprint(blueprintArgs, bootstrap.StopBeforeWriteNinja, ctx.Context, runConfig)
+		ctx.EventHandler.End("analyze_module")
+
+		return missingDependencies(ctx, analyzeModule), nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	ninjaDeps = append(ninjaDeps, extraNinjaDeps...)
+	ninjaDeps = append(ninjaDeps, cmdlineArgs.ModuleListFile)
+
+	if moduleGraphFile != "" {
+		writeJsonModuleGraphAndActions(ctx, moduleGraphFile, moduleActionsFile)
+	}
+
+	data, err := json.MarshalIndent(analyzeVariants(ctx, analyzeModule), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding the analysis of %s: %s\n", analyzeModule, err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(shared.JoinPath(topDir, analyzeModuleOutput), append(data, '\n'), 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %s\n", analyzeModuleOutput, err)
+		os.Exit(1)
+	}
+	writeDepFile(analyzeModuleOutput, *ctx.EventHandler, ninjaDeps)
+}
@@ -0,0 +1,275 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"android/soong/android"
+
+	"github.com/google/blueprint"
+)
+
+func TestUpdatePackageIndex(t *testing.T) {
+	withTestTree(t, map[string]string{
+		"a/Android.bp": `cc_library { name: "liba", shared_libs: ["libb"] }`,
+		"b/Android.bp": `cc_library { name: "libb" }`,
+	})
+	allFiles := []string{"a/Android.bp", "b/Android.bp"}
+	indexPath := "out/package_index.json"
+
+	infos, err := updatePackageIndex(indexPath, allFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bpFileInfo{
+		"a/Android.bp": {modules: []string{"liba"}, strings: []string{"liba", "libb"}},
+		"b/Android.bp": {modules: []string{"libb"}, strings: []string{"libb"}},
+	}
+	if !reflect.DeepEqual(infos, expected) {
+		t.Errorf("expected %v, got %v", expected, infos)
+	}
+
+	// Replace the entry of a/Android.bp in the index, which is used as long as the file doesn't
+	// change.
+	absIndexPath := filepath.Join(topDir, indexPath)
+	data, err := ioutil.ReadFile(absIndexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := make(map[string]packageIndexEntry)
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	entry := index["a/Android.bp"]
+	entry.Modules = []string{"libindexed"}
+	index["a/Android.bp"] = entry
+	if data, err = json.Marshal(index); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(absIndexPath, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change b/Android.bp, which is parsed again.
+	bPath := filepath.Join(topDir, "b/Android.bp")
+	if err := ioutil.WriteFile(bPath, []byte(`cc_library { name: "libnewb" }`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(bPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err = updatePackageIndex(indexPath, allFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := infos["a/Android.bp"].modules; !reflect.DeepEqual(got, []string{"libindexed"}) {
+		t.Errorf("unchanged file should use the index, got modules %q", got)
+	}
+	if got := infos["b/Android.bp"].modules; !reflect.DeepEqual(got, []string{"libnewb"}) {
+		t.Errorf("changed file should be parsed again, got modules %q", got)
+	}
+}
+
+func TestUpdatePackageIndexCorrupt(t *testing.T) {
+	withTestTree(t, map[string]string{
+		"Android.bp":             `cc_library { name: "liba" }`,
+		"out/package_index.json": `{"Android.bp": `,
+	})
+
+	infos, err := updatePackageIndex("out/package_index.json", []string{"Android.bp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := infos["Android.bp"].modules; !reflect.DeepEqual(got, []string{"liba"}) {
+		t.Errorf("corrupt index should be rebuilt, got modules %q", got)
+	}
+}
+
+var analyzeModuleTestInfos = map[string]bpFileInfo{
+	"Android.bp":           {},
+	"a/Android.bp":         {modules: []string{"liba"}, strings: []string{"liba", "libb"}},
+	"b/Android.bp":         {modules: []string{"libb"}, strings: []string{"libb"}},
+	"bionic/Android.bp":    {modules: []string{"libc", "crtbegin_so"}, strings: []string{"libc", "crtbegin_so"}},
+	"java/Android.bp":      {modules: []string{"core-libart"}, strings: []string{"core-libart"}},
+	"java/sdk/Android.bp":  {modules: []string{"sdk"}, strings: []string{"sdk"}},
+	"unrelated/Android.bp": {modules: []string{"libunrelated"}, strings: []string{"libunrelated"}},
+}
+
+func TestBpFileGraphClosure(t *testing.T) {
+	graph := newBpFileGraph(analyzeModuleTestInfos)
+
+	expected := []string{"Android.bp", "a/Android.bp", "b/Android.bp"}
+	if got := graph.closure([]string{"a/Android.bp"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected closure %q, got %q", expected, got)
+	}
+
+	expected = []string{"Android.bp", "java/Android.bp", "java/sdk/Android.bp"}
+	if got := graph.closure([]string{"java/sdk/Android.bp"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected closure with parent directories %q, got %q", expected, got)
+	}
+}
+
+func TestBpFileGraphFilesDefiningDependency(t *testing.T) {
+	graph := newBpFileGraph(analyzeModuleTestInfos)
+
+	testCases := map[string][]string{
+		"libc":                    {"bionic/Android.bp"},
+		"prebuilt_libc":           {"bionic/Android.bp"},
+		"//bionic:libc":           {"bionic/Android.bp"},
+		":libb{.tag}":             {"b/Android.bp"},
+		"core-libart.stubs":       {"java/Android.bp"},
+		"core-libart.stubs.cycle": {"java/Android.bp"},
+		"libundefined":            nil,
+	}
+	for dep, expected := range testCases {
+		if got := graph.filesDefiningDependency(dep); !reflect.DeepEqual(got, expected) {
+			t.Errorf("filesDefiningDependency(%q): expected %q, got %q", dep, expected, got)
+		}
+	}
+}
+
+func TestAnalyzeModuleClosure(t *testing.T) {
+	graph := newBpFileGraph(analyzeModuleTestInfos)
+
+	// Every module implicitly depends on libc and on crtbegin_so, which the strings of a/Android.bp
+	// don't reference.
+	var runs [][]string
+	analyze := func(neededFiles []string) ([]string, error) {
+		runs = append(runs, neededFiles)
+		if !android.InList("bionic/Android.bp", neededFiles) {
+			return []string{"crtbegin_so", "libc"}, nil
+		}
+		return nil, nil
+	}
+
+	neededFiles, err := analyzeModuleClosure(graph, "liba", analyze)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"Android.bp", "a/Android.bp", "b/Android.bp"},
+		{"Android.bp", "a/Android.bp", "b/Android.bp", "bionic/Android.bp"},
+	}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Errorf("expected runs with %q, got %q", expected, runs)
+	}
+	if !reflect.DeepEqual(neededFiles, expected[1]) {
+		t.Errorf("expected needed files %q, got %q", expected[1], neededFiles)
+	}
+}
+
+func TestAnalyzeModuleClosureErrors(t *testing.T) {
+	graph := newBpFileGraph(analyzeModuleTestInfos)
+
+	testCases := []struct {
+		name        string
+		module      string
+		missingDeps []string
+		err         string
+	}{
+		{
+			name:   "undefined module",
+			module: "libundefined",
+			err:    `module "libundefined" is not defined in any Android.bp file`,
+		},
+		{
+			name:        "undefined dependency",
+			module:      "liba",
+			missingDeps: []string{"libc", "libundefined"},
+			err:         `dependencies of "liba" are not defined in any Android.bp file: libundefined`,
+		},
+		{
+			name:        "dependency missing from an analyzed file",
+			module:      "liba",
+			missingDeps: []string{"libb"},
+			err:         `dependencies of "liba" are missing: libb`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := analyzeModuleClosure(graph, tc.module, func([]string) ([]string, error) {
+				return tc.missingDeps, nil
+			})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+type analyzeTestModule struct {
+	android.ModuleBase
+	properties struct {
+		Deps []string
+	}
+}
+
+var analyzeTestDepTag = struct {
+	blueprint.BaseDependencyTag
+}{}
+
+func analyzeTestModuleFactory() android.Module {
+	m := &analyzeTestModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidModule(m)
+	return m
+}
+
+func (m *analyzeTestModule) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), analyzeTestDepTag, m.properties.Deps...)
+}
+
+func (m *analyzeTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+}
+
+func TestMissingDependencies(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		android.PrepareForTestWithAllowMissingDependencies,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("analyze_test", analyzeTestModuleFactory)
+		}),
+	).RunTestWithBp(t, `
+		analyze_test {
+			name: "a",
+			deps: ["b", "libmissing"],
+		}
+
+		analyze_test {
+			name: "b",
+			deps: ["libc"],
+		}
+
+		analyze_test {
+			name: "unrelated",
+			deps: ["libunrelated"],
+		}
+	`)
+
+	expected := []string{"libc", "libmissing"}
+	if got := missingDependencies(result.TestContext.Context, "a"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected missing dependencies %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"android/soong/android"
+	"android/soong/shared"
+
+	"github.com/google/blueprint/bootstrap"
+	"github.com/google/blueprint/parser"
+)
+
+// The Android.bp validation mode analyzes only the Android.bp files that are affected by a list
+// of changed Android.bp files, so that presubmit can validate edits to them without analyzing the
+// whole tree. The affected files are:
+//
+// - the changed files themselves,
+// - the files that define modules referenced by affected files, transitively,
+// - the files that reference modules defined by the changed files, and
+// - the Android.bp files in the parent directories of affected files, which can define package
+//   defaults for them.
+//
+// References are found by looking for the names of modules in all the string values of a file,
+// which over-approximates the dependencies of its modules. Dependencies that are added
+// implicitly by mutators (e.g. on libc or on the SDK) aren't found this way, so the analysis runs
+// with missing dependencies allowed, and only reports the errors that aren't caused by those.
+
+type bpFileInfo struct {
+	// names of the modules defined by the file
+	modules []string
+	// all the string values in the file
+	strings []string
+}
+
+// parseBpFileInfo parses an Android.bp file and collects the information needed to compute the
+// affected files.
+func parseBpFileInfo(path string) (bpFileInfo, error) {
+	f, err := os.Open(shared.JoinPath(topDir, path))
+	if err != nil {
+		return bpFileInfo{}, err
+	}
+	defer f.Close()
+
+	file, errs := parser.ParseAndEval(path, f, parser.NewScope(nil))
+	if len(errs) > 0 {
+		return bpFileInfo{}, errs[0]
+	}
+
+	var info bpFileInfo
+	for _, def := range file.Defs {
+		module, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+		for _, prop := range module.Properties {
+			if prop.Name == "name" {
+				if name, ok := prop.Value.Eval().(*parser.String); ok {
+					info.modules = append(info.modules, name.Value)
+				}
+			}
+			info.strings = appendStringValues(info.strings, prop.Value)
+		}
+	}
+	return info, nil
+}
+
+func appendStringValues(strs []string, value parser.Expression) []string {
+	switch v := value.Eval().(type) {
+	case *parser.String:
+		strs = append(strs, v.Value)
+	case *parser.List:
+		for _, e := range v.Values {
+			strs = appendStringValues(strs, e)
+		}
+	case *parser.Map:
+		for _, prop := range v.Properties {
+			strs = appendStringValues(strs, prop.Value)
+		}
+	}
+	return strs
+}
+
+// referencedName returns the module name referenced by a string value, which can be a plain
+// module name, a ":module" or ":module{tag}" path reference, or a "module#version" reference.
+func referencedName(s string) string {
+	s = strings.TrimPrefix(s, ":")
+	if i := strings.IndexAny(s, "{#"); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// bpFileGraph indexes the modules defined by a set of Android.bp files, to find the files that
+// the strings of other files reference.
+type bpFileGraph struct {
+	infos               map[string]bpFileInfo
+	filesDefiningModule map[string][]string
+}
+
+func newBpFileGraph(infos map[string]bpFileInfo) *bpFileGraph {
+	g := &bpFileGraph{
+		infos:               infos,
+		filesDefiningModule: make(map[string][]string),
+	}
+	for _, path := range android.SortedStringKeys(infos) {
+		for _, module := range infos[path].modules {
+			g.filesDefiningModule[module] = append(g.filesDefiningModule[module], path)
+		}
+	}
+	return g
+}
+
+// closure returns the roots, everything that they reference, transitively, and the Android.bp
+// files in the parent directories of all of those, sorted.
+func (g *bpFileGraph) closure(roots []string) []string {
+	affected := make(map[string]bool)
+	var queue []string
+	add := func(path string) {
+		if _, isBpFile := g.infos[path]; isBpFile && !affected[path] {
+			affected[path] = true
+			queue = append(queue, path)
+		}
+	}
+
+	for _, path := range roots {
+		add(path)
+	}
+
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+
+		for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+			add(filepath.Join(dir, "Android.bp"))
+		}
+		add("Android.bp")
+
+		for _, s := range g.infos[path].strings {
+			// Some module types reference other Android.bp files directly,
+			// e.g. soong_config_module_type_import.
+			add(filepath.Clean(s))
+			for _, file := range g.filesDefiningModule[referencedName(s)] {
+				add(file)
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(affected))
+	for path := range affected {
+		ret = append(ret, path)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// affectedBpFiles returns the subset of allFiles that has to be analyzed to validate the changed
+// files.
+func affectedBpFiles(allFiles, changedFiles []string) ([]string, error) {
+	infos := make(map[string]bpFileInfo, len(allFiles))
+	for _, path := range allFiles {
+		info, err := parseBpFileInfo(path)
+		if err != nil {
+			return nil, err
+		}
+		infos[path] = info
+	}
+
+	// Start from the changed files and the files that reference the modules defined by them.
+	var roots []string
+	changedModules := make(map[string]bool)
+	for _, path := range changedFiles {
+		path = filepath.Clean(path)
+		if _, isBpFile := infos[path]; !isBpFile {
+			return nil, fmt.Errorf("%s is not one of the Android.bp files of the tree", path)
+		}
+		roots = append(roots, path)
+		for _, module := range infos[path].modules {
+			changedModules[module] = true
+		}
+	}
+	for _, path := range allFiles {
+		for _, s := range infos[path].strings {
+			if changedModules[referencedName(s)] {
+				roots = append(roots, path)
+				break
+			}
+		}
+	}
+
+	return newBpFileGraph(infos).closure(roots), nil
+}
+
+func readFileList(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(shared.JoinPath(topDir, path))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// runBpValidation analyzes the Android.bp files affected by the files listed in
+// changedBpFilesFile. Analysis errors are reported and terminate soong_build, otherwise the
+// marker file is touched.
+func runBpValidation(configuration android.Config, extraNinjaDeps []string) {
+	allFiles, err := readFileList(cmdlineArgs.ModuleListFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading module list file: %s\n", err)
+		os.Exit(1)
+	}
+	changedFiles, err := readFileList(changedBpFilesFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading changed Android.bp files: %s\n", err)
+		os.Exit(1)
+	}
+
+	affectedFiles, err := affectedBpFiles(allFiles, changedFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Validating %d changed Android.bp files by analyzing %d of %d Android.bp files\n",
+		len(changedFiles), len(affectedFiles), len(allFiles))
+
+	affectedListFile := filepath.Join(filepath.Dir(bpValidationMarker), "bp_validation.list")
+	err = ioutil.WriteFile(shared.JoinPath(topDir, affectedListFile),
+		[]byte(strings.Join(affectedFiles, "\n")+"\n"), 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %s\n", affectedListFile, err)
+		os.Exit(1)
+	}
+
+	configuration.SetAllowMissingDependencies()
+	ctx := newContext(configuration)
+	ctx.EventHandler.Begin("bp_validation")
+
+	blueprintArgs := cmdlineArgs
+	blueprintArgs.ModuleListFile = affectedListFile
+	ninjaDeps := bootstrap.RunBlueprint(blueprintArgs, bootstrap.StopBeforeWriteNinja, ctx.Context, configuration)
+	ninjaDeps = append(ninjaDeps, extraNinjaDeps...)
+	ninjaDeps = append(ninjaDeps, cmdlineArgs.ModuleListFile, changedBpFilesFile)
+	ninjaDeps = append(ninjaDeps, changedFiles...)
+	ctx.EventHandler.End("bp_validation")
+
+	touch(shared.JoinPath(topDir, bpValidationMarker))
+	writeDepFile(bpValidationMarker, *ctx.EventHandler, ninjaDeps)
+}
@@ -0,0 +1,181 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withTestTree writes the given files to a temporary directory and uses it as the top of the
+// source tree for the duration of the test.
+func withTestTree(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for path, contents := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldTopDir := topDir
+	topDir = dir
+	t.Cleanup(func() { topDir = oldTopDir })
+}
+
+func TestReferencedName(t *testing.T) {
+	testCases := map[string]string{
+		"libfoo":         "libfoo",
+		":libfoo":        "libfoo",
+		":libfoo{.tag}":  "libfoo",
+		"libfoo#current": "libfoo",
+	}
+	for s, expected := range testCases {
+		if got := referencedName(s); got != expected {
+			t.Errorf("referencedName(%q): expected %q, got %q", s, expected, got)
+		}
+	}
+}
+
+var bpValidationTestTree = map[string]string{
+	"Android.bp": `
+		package {
+			default_visibility: ["//visibility:public"],
+		}
+	`,
+	"a/Android.bp": `
+		cc_library {
+			name: "liba",
+			shared_libs: ["libb"],
+			srcs: [":a_srcs{.tag}"],
+		}
+		filegroup {
+			name: "a_srcs",
+			srcs: ["a.cpp"],
+		}
+	`,
+	"b/Android.bp": `
+		cc_library {
+			name: "libb",
+			static_libs: ["libc"],
+		}
+	`,
+	"c/Android.bp": `
+		cc_library {
+			name: "libc",
+		}
+	`,
+	"c/d/Android.bp": `
+		cc_binary {
+			name: "d",
+			shared_libs: ["libb"],
+		}
+	`,
+	"e/Android.bp": `
+		cc_library {
+			name: "libe",
+		}
+	`,
+}
+
+func TestAffectedBpFiles(t *testing.T) {
+	allFiles := []string{"Android.bp", "a/Android.bp", "b/Android.bp", "c/Android.bp", "c/d/Android.bp", "e/Android.bp"}
+
+	testCases := []struct {
+		name     string
+		changed  []string
+		expected []string
+	}{
+		{
+			name:     "leaf",
+			changed:  []string{"c/Android.bp"},
+			expected: []string{"Android.bp", "b/Android.bp", "c/Android.bp"},
+		},
+		{
+			name:    "users and dependencies",
+			changed: []string{"b/Android.bp"},
+			expected: []string{"Android.bp", "a/Android.bp", "b/Android.bp", "c/Android.bp",
+				"c/d/Android.bp"},
+		},
+		{
+			name:     "parent directories",
+			changed:  []string{"c/d/Android.bp"},
+			expected: []string{"Android.bp", "b/Android.bp", "c/Android.bp", "c/d/Android.bp"},
+		},
+		{
+			name:     "unrelated",
+			changed:  []string{"./e/Android.bp"},
+			expected: []string{"Android.bp", "e/Android.bp"},
+		},
+	}
+
+	withTestTree(t, bpValidationTestTree)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			affected, err := affectedBpFiles(allFiles, tc.changed)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(affected, tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, affected)
+			}
+		})
+	}
+}
+
+func TestAffectedBpFilesErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		files   map[string]string
+		changed []string
+		err     string
+	}{
+		{
+			name:    "changed file not in tree",
+			files:   bpValidationTestTree,
+			changed: []string{"f/Android.bp"},
+			err:     "f/Android.bp is not one of the Android.bp files of the tree",
+		},
+		{
+			name: "syntax error",
+			files: map[string]string{
+				"Android.bp": `cc_library { name: "liba", `,
+			},
+			changed: []string{"Android.bp"},
+			err:     "Android.bp:1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withTestTree(t, tc.files)
+			var allFiles []string
+			for path := range tc.files {
+				allFiles = append(allFiles, path)
+			}
+			_, err := affectedBpFiles(allFiles, tc.changed)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -54,6 +54,13 @@ var (
 	bazelQueryViewDir string
 	bp2buildMarker    string
 
+	bpValidationMarker string
+	changedBpFilesFile string
+
+	analyzeModule       string
+	analyzeModuleOutput string
+	packageIndexFile    string
+
 	cmdlineArgs bootstrap.Args
 )
 
@@ -82,6 +89,11 @@ func init() {
 	flag.StringVar(&docFile, "soong_docs", "", "build documentation file to output")
 	flag.StringVar(&bazelQueryViewDir, "bazel_queryview_dir", "", "path to the bazel queryview directory relative to --top")
 	flag.StringVar(&bp2buildMarker, "bp2build_marker", "", "If set, run bp2build, touch the specified marker file then exit")
+	flag.StringVar(&bpValidationMarker, "bp_validation_marker", "", "If set, analyze the Android.bp files affected by --changed_bp_files, touch the specified marker file then exit")
+	flag.StringVar(&changedBpFilesFile, "changed_bp_files", "", "file that lists the changed Android.bp files to validate with --bp_validation_marker")
+	flag.StringVar(&analyzeModule, "analyze-module", "", "If set, analyze only the Android.bp files needed by the named module, write its analysis to --analyze-module-output then exit")
+	flag.StringVar(&analyzeModuleOutput, "analyze-module-output", "", "JSON file to output the analysis of --analyze-module to")
+	flag.StringVar(&packageIndexFile, "package-index", "", "index of the Android.bp files used by --analyze-module, updated in place (defaults to package_index.json next to --analyze-module-output)")
 	flag.StringVar(&cmdlineArgs.OutFile, "o", "build.ninja", "the Ninja file to output")
 	flag.BoolVar(&cmdlineArgs.EmptyNinjaFile, "empty-ninja-file", false, "write out a 0-byte ninja file")
 
@@ -159,10 +171,18 @@ func runMixedModeBuild(configuration android.Config, firstCtx *android.Context,
 	secondCtx := newContext(secondConfig)
 	secondCtx.EventHandler = firstCtx.EventHandler
 	secondCtx.EventHandler.Begin("analyze")
-	ninjaDeps := bootstrap.RunBlueprint(cmdlineArgs, bootstrap.DoEverything, secondCtx.Context, secondConfig)
+	stopBefore := bootstrap.DoEverything
+	if partialNinjaFilesEnabled(secondConfig) {
+		stopBefore = bootstrap.StopBeforeWriteNinja
+	}
+	ninjaDeps := bootstrap.RunBlueprint(cmdlineArgs, stopBefore, secondCtx.Context, secondConfig)
 	ninjaDeps = append(ninjaDeps, extraNinjaDeps...)
 	secondCtx.EventHandler.End("analyze")
 
+	if stopBefore == bootstrap.StopBeforeWriteNinja {
+		writePartialNinjaFiles(secondCtx, cmdlineArgs.OutFile)
+	}
+
 	globListFiles := writeBuildGlobsNinjaFile(secondCtx, configuration.SoongOutDir(), configuration)
 	ninjaDeps = append(ninjaDeps, globListFiles...)
 
@@ -252,6 +272,18 @@ func doChosenActivity(configuration android.Config, extraNinjaDeps []string) str
 		return bp2buildMarker
 	}
 
+	if bpValidationMarker != "" {
+		// Analyze only the Android.bp files affected by a set of changed files.
+		runBpValidation(configuration, extraNinjaDeps)
+		return bpValidationMarker
+	}
+
+	if analyzeModule != "" {
+		// Analyze only the Android.bp files needed by a single module.
+		runAnalyzeModule(configuration, extraNinjaDeps)
+		return analyzeModuleOutput
+	}
+
 	blueprintArgs := cmdlineArgs
 
 	ctx := newContext(configuration)
@@ -265,6 +297,9 @@ func doChosenActivity(configuration android.Config, extraNinjaDeps []string) str
 			stopBefore = bootstrap.StopBeforePrepareBuildActions
 		} else if generateDocFile {
 			stopBefore = bootstrap.StopBeforePrepareBuildActions
+		} else if partialNinjaFilesEnabled(configuration) {
+			// The ninja files are written by writePartialNinjaFiles below.
+			stopBefore = bootstrap.StopBeforeWriteNinja
 		} else {
 			stopBefore = bootstrap.DoEverything
 		}
@@ -296,8 +331,11 @@ func doChosenActivity(configuration android.Config, extraNinjaDeps []string) str
 			writeDepFile(docFile, *ctx.EventHandler, ninjaDeps)
 			return docFile
 		} else {
-			// The actual output (build.ninja) was written in the RunBlueprint() call
-			// above
+			if stopBefore == bootstrap.StopBeforeWriteNinja {
+				writePartialNinjaFiles(ctx, cmdlineArgs.OutFile)
+			}
+			// Otherwise, the actual output (build.ninja) was written in the RunBlueprint()
+			// call above
 			writeDepFile(cmdlineArgs.OutFile, *ctx.EventHandler, ninjaDeps)
 		}
 	}
@@ -0,0 +1,349 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"android/soong/android"
+	"android/soong/shared"
+
+	"github.com/google/blueprint"
+)
+
+// With a single build.ninja, editing one Android.bp file rewrites the build statements of every
+// module in the tree. When SOONG_PARTIAL_NINJA_FILES=true, the build statements of the modules
+// are instead split into one ninja file per directory that contains an Android.bp file, which
+// are included by the main ninja file, and only the files whose content changed are rewritten.
+// The ninja file of the modules defined in <dir>/Android.bp is <dir>/build.ninja under the parts
+// directory, so that the files of nested module directories never collide. The directories of
+// the modules come from blueprint, and the files of directories whose modules have no build
+// statements are neither written nor included.
+//
+// The main ninja file keeps the global variables, pools and rules, and the singletons. It is
+// always rewritten, as ninja expects the generator of the main ninja file to update it.
+
+const partialNinjaFilesEnv = "SOONG_PARTIAL_NINJA_FILES"
+
+var (
+	// The first line of the header blueprint writes before the build actions of each module and
+	// singleton, followed by "Module:" and "Variant:" lines or a "Singleton:" line.
+	ninjaSectionSeparator    = []byte("# # # #")
+	ninjaModuleHeader        = []byte("# Module:")
+	ninjaVariantHeader       = []byte("# Variant:")
+	ninjaSingletonHeader     = []byte("# Singleton:")
+	partialNinjaDirRegexp    = regexp.MustCompile(`^[A-Za-z0-9_.+-]+(/[A-Za-z0-9_.+-]+)*$`)
+	partialNinjaFileBaseName = "build.ninja"
+)
+
+func partialNinjaFilesEnabled(configuration android.Config) bool {
+	return configuration.IsEnvTrue(partialNinjaFilesEnv) && !cmdlineArgs.EmptyNinjaFile
+}
+
+// partialNinjaFilesDir returns the directory that contains the per-directory ninja files included
+// by outFile.
+func partialNinjaFilesDir(outFile string) string {
+	return strings.TrimSuffix(outFile, ".ninja") + ".parts"
+}
+
+// writePartialNinjaFiles writes the build actions of ctx to outFile and the per-directory ninja
+// files included by it.
+func writePartialNinjaFiles(ctx *android.Context, outFile string) {
+	ctx.EventHandler.Begin("write_partial_ninja_files")
+	defer ctx.EventHandler.End("write_partial_ninja_files")
+
+	partsDir := partialNinjaFilesDir(outFile)
+	w := newPartialNinjaWriter(partsDir, partialNinjaModuleParts(ctx))
+	if err := ctx.WriteBuildFile(w); err != nil {
+		fmt.Fprintf(os.Stderr, "error generating Ninja file contents: %s\n", err)
+		os.Exit(1)
+	}
+	mainContent, parts := w.finish()
+
+	absPartsDir := shared.JoinPath(topDir, partsDir)
+	if err := os.MkdirAll(absPartsDir, 0777); err != nil {
+		fmt.Fprintf(os.Stderr, "error creating %s: %s\n", absPartsDir, err)
+		os.Exit(1)
+	}
+	for name, content := range parts {
+		if err := writeFileIfChanged(filepath.Join(absPartsDir, name), content); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing partial Ninja file: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := removeStalePartialNinjaFiles(absPartsDir, parts); err != nil {
+		fmt.Fprintf(os.Stderr, "error removing stale partial Ninja files: %s\n", err)
+		os.Exit(1)
+	}
+
+	absOutFile := shared.JoinPath(topDir, outFile)
+	if err := ioutil.WriteFile(absOutFile, mainContent, 0666); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %s\n", absOutFile, err)
+		os.Exit(1)
+	}
+}
+
+// moduleVariantKey returns the key of a module variant in the map passed to
+// newPartialNinjaWriter.
+func moduleVariantKey(name, variant string) string {
+	return name + " " + variant
+}
+
+// partialNinjaModuleParts returns the partial ninja file of each module variant of ctx, keyed by
+// moduleVariantKey. The module variants that stay in the main ninja file are not in the map.
+func partialNinjaModuleParts(ctx *android.Context) map[string]string {
+	parts := make(map[string]string)
+	ctx.VisitAllModules(func(m blueprint.Module) {
+		if part := partialNinjaFileName(ctx.ModuleDir(m)); part != "" {
+			parts[moduleVariantKey(ctx.ModuleName(m), ctx.ModuleSubDir(m))] = part
+		}
+	})
+	return parts
+}
+
+// partialNinjaFileName returns the name of the partial ninja file of the modules in dir, relative
+// to the parts directory, or an empty string if they stay in the main ninja file because dir
+// can't be used as a path under the parts directory.
+func partialNinjaFileName(dir string) string {
+	if dir == "." || dir == "" {
+		return partialNinjaFileBaseName
+	}
+	if filepath.IsAbs(dir) || !partialNinjaDirRegexp.MatchString(dir) {
+		return ""
+	}
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == "." || elem == ".." {
+			return ""
+		}
+	}
+	return filepath.Join(dir, partialNinjaFileBaseName)
+}
+
+// partialNinjaWriter is passed to blueprint to write the ninja file, and moves the build actions
+// of each module to its partial ninja file as blueprint writes them.
+type partialNinjaWriter struct {
+	partsDir    string
+	moduleParts map[string]string
+
+	main bytes.Buffer
+	// The offset in main where the include statements go, i.e. where the first module was.
+	includeOffset int
+	parts         map[string]*bytes.Buffer
+	// The parts that have build statements, only those are written and included.
+	needed map[string]bool
+
+	// The incomplete last line of the data written so far.
+	line []byte
+	// The header of the section being written, until its end tells where the section goes.
+	header   []byte
+	inHeader bool
+	// The partial ninja file of the section being written, or "" for the main ninja file.
+	current      string
+	inSingletons bool
+}
+
+func newPartialNinjaWriter(partsDir string, moduleParts map[string]string) *partialNinjaWriter {
+	return &partialNinjaWriter{
+		partsDir:      partsDir,
+		moduleParts:   moduleParts,
+		includeOffset: -1,
+		parts:         make(map[string]*bytes.Buffer),
+		needed:        make(map[string]bool),
+	}
+}
+
+func (w *partialNinjaWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.line = append(w.line, p...)
+			break
+		}
+		if len(w.line) > 0 {
+			w.line = append(w.line, p[:i+1]...)
+			w.writeLine(w.line)
+			w.line = w.line[:0]
+		} else {
+			w.writeLine(p[:i+1])
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (w *partialNinjaWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *partialNinjaWriter) writeLine(line []byte) {
+	if w.inHeader {
+		if bytes.HasPrefix(line, []byte("#")) {
+			w.header = append(w.header, line...)
+			return
+		}
+		w.endHeader()
+	}
+	if !w.inSingletons && bytes.HasPrefix(line, ninjaSectionSeparator) {
+		w.inHeader = true
+		w.header = append(w.header[:0], line...)
+		return
+	}
+	w.emit(line)
+}
+
+// endHeader selects the ninja file of the section whose header has been written.
+func (w *partialNinjaWriter) endHeader() {
+	w.inHeader = false
+	var module, variant []byte
+	isModule := false
+	for _, line := range bytes.Split(w.header, []byte("\n")) {
+		if bytes.HasPrefix(line, ninjaModuleHeader) {
+			module = bytes.TrimSpace(line[len(ninjaModuleHeader):])
+			isModule = true
+		} else if bytes.HasPrefix(line, ninjaVariantHeader) {
+			variant = bytes.TrimSpace(line[len(ninjaVariantHeader):])
+		} else if bytes.HasPrefix(line, ninjaSingletonHeader) {
+			// Everything from the first singleton onwards stays in the main ninja file.
+			w.inSingletons = true
+		}
+	}
+	w.current = ""
+	if isModule && !w.inSingletons {
+		if w.includeOffset < 0 {
+			w.includeOffset = w.main.Len()
+		}
+		w.current = w.moduleParts[moduleVariantKey(string(module), string(variant))]
+	}
+	w.emit(w.header)
+	w.header = w.header[:0]
+}
+
+func (w *partialNinjaWriter) emit(data []byte) {
+	if w.current == "" {
+		w.main.Write(data)
+		return
+	}
+	buf := w.parts[w.current]
+	if buf == nil {
+		buf = &bytes.Buffer{}
+		w.parts[w.current] = buf
+	}
+	buf.Write(data)
+	if !w.needed[w.current] {
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) > 0 && line[0] != '#' {
+				w.needed[w.current] = true
+				break
+			}
+		}
+	}
+}
+
+// finish returns the content of the main ninja file, with include statements for the partial
+// ninja files in place of the module sections, and the content of the partial ninja files keyed
+// by their path relative to the parts directory.
+func (w *partialNinjaWriter) finish() ([]byte, map[string][]byte) {
+	if len(w.line) > 0 {
+		w.writeLine(w.line)
+		w.line = nil
+	}
+	if w.inHeader {
+		w.endHeader()
+	}
+
+	mainContent := w.main.Bytes()
+	if w.includeOffset < 0 {
+		return mainContent, nil
+	}
+
+	var includes bytes.Buffer
+	parts := make(map[string][]byte)
+	for _, name := range android.SortedStringKeys(w.parts) {
+		if !w.needed[name] {
+			continue
+		}
+		fmt.Fprintf(&includes, "include %s\n", filepath.Join(w.partsDir, name))
+		parts[name] = w.parts[name].Bytes()
+	}
+	if includes.Len() > 0 {
+		includes.WriteString("\n")
+	}
+
+	ret := make([]byte, 0, len(mainContent)+includes.Len())
+	ret = append(ret, mainContent[:w.includeOffset]...)
+	ret = append(ret, includes.Bytes()...)
+	ret = append(ret, mainContent[w.includeOffset:]...)
+	return ret, parts
+}
+
+// writeFileIfChanged writes content to path unless the file already has that content, so that
+// its timestamp only changes when its content does.
+func writeFileIfChanged(path string, content []byte) error {
+	if old, err := ioutil.ReadFile(path); err == nil && bytes.Equal(old, content) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0666)
+}
+
+// removeStalePartialNinjaFiles removes the ninja files under dir that were written by a previous
+// run but aren't part of parts anymore, e.g. because the last module in a directory was removed,
+// and the directories that are left empty.
+func removeStalePartialNinjaFiles(dir string, parts map[string][]byte) error {
+	_, err := removeStalePartialNinjaFilesIn(dir, "", parts)
+	return err
+}
+
+// removeStalePartialNinjaFilesIn removes the stale ninja files under filepath.Join(dir, rel), and
+// returns whether that directory is empty afterwards.
+func removeStalePartialNinjaFilesIn(dir, rel string, parts map[string][]byte) (bool, error) {
+	files, err := ioutil.ReadDir(filepath.Join(dir, rel))
+	if err != nil {
+		return false, err
+	}
+	empty := true
+	for _, file := range files {
+		name := filepath.Join(rel, file.Name())
+		if file.IsDir() {
+			subdirEmpty, err := removeStalePartialNinjaFilesIn(dir, name, parts)
+			if err != nil {
+				return false, err
+			}
+			if !subdirEmpty {
+				empty = false
+			} else if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return false, err
+			}
+			continue
+		}
+		if _, ok := parts[name]; ok || !strings.HasSuffix(name, ".ninja") {
+			empty = false
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return false, err
+		}
+	}
+	return empty, nil
+}
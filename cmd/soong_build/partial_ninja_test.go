@@ -0,0 +1,220 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+const testNinjaSeparator = "# # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # # #\n"
+
+func testNinjaModule(name, defined string) string {
+	return testNinjaSeparator +
+		"# Module:  " + name + "\n" +
+		"# Variant: android_arm64\n" +
+		"# Type:    cc_library\n" +
+		"# Defined: " + defined + "\n" +
+		"\n" +
+		"build out/" + name + ": touch\n" +
+		"\n"
+}
+
+func TestPartialNinjaFileName(t *testing.T) {
+	testCases := []struct {
+		dir  string
+		want string
+	}{
+		{".", "build.ninja"},
+		{"frameworks/base", "frameworks/base/build.ninja"},
+		{"frameworks/base/core", "frameworks/base/core/build.ninja"},
+		{"/abs", ""},
+		{"../outside", ""},
+		{"dir with space", ""},
+	}
+	for _, tc := range testCases {
+		if got := partialNinjaFileName(tc.dir); got != tc.want {
+			t.Errorf("partialNinjaFileName(%q) = %q, want %q", tc.dir, got, tc.want)
+		}
+	}
+}
+
+// writeInChunks writes content to w in small chunks, like blueprint writes the ninja file in
+// many calls that don't end at line boundaries.
+func writeInChunks(t *testing.T, w *partialNinjaWriter, content string) {
+	for len(content) > 0 {
+		n := 7
+		if n > len(content) {
+			n = len(content)
+		}
+		if _, err := w.WriteString(content[:n]); err != nil {
+			t.Fatal(err)
+		}
+		content = content[n:]
+	}
+}
+
+func TestPartialNinjaWriter(t *testing.T) {
+	globals := "rule touch\n    command = touch $out\n\n"
+	libfoo := testNinjaModule("libfoo", "frameworks/base/Android.bp:1:1")
+	libbar := testNinjaModule("libbar", "frameworks/base/core/Android.bp:1:1")
+	libbaz := testNinjaModule("libbaz", "frameworks/base/Android.bp:9:1")
+	librel := testNinjaModule("librel", "../outside/Android.bp:1:1")
+	// A module without build statements.
+	libempty := testNinjaSeparator +
+		"# Module:  libempty\n" +
+		"# Variant: android_arm64\n" +
+		"# Defined: external/empty/Android.bp:1:1\n" +
+		"\n"
+	singleton := testNinjaSeparator +
+		"# Singleton: apex_keys_text\n" +
+		"# Factory:   android/soong/apex.apexKeysTextFactory\n" +
+		"\n" +
+		"build out/apexkeys.txt: touch\n"
+
+	// The directories of the modules come from blueprint, not from the comments.
+	moduleParts := map[string]string{
+		moduleVariantKey("libfoo", "android_arm64"):   "frameworks/base/build.ninja",
+		moduleVariantKey("libbar", "android_arm64"):   "frameworks/base/core/build.ninja",
+		moduleVariantKey("libbaz", "android_arm64"):   "frameworks/base/build.ninja",
+		moduleVariantKey("libempty", "android_arm64"): "external/empty/build.ninja",
+	}
+
+	w := newPartialNinjaWriter("out/soong/build.parts", moduleParts)
+	writeInChunks(t, w, globals+libfoo+libbar+libempty+libbaz+librel+singleton)
+	mainContent, parts := w.finish()
+
+	wantMain := globals +
+		"include out/soong/build.parts/frameworks/base/build.ninja\n" +
+		"include out/soong/build.parts/frameworks/base/core/build.ninja\n" +
+		"\n" +
+		librel + singleton
+	if string(mainContent) != wantMain {
+		t.Errorf("main ninja file:\n%s\nwant:\n%s", mainContent, wantMain)
+	}
+
+	wantParts := map[string]string{
+		"frameworks/base/build.ninja":      libfoo + libbaz,
+		"frameworks/base/core/build.ninja": libbar,
+	}
+	gotParts := make(map[string]string)
+	for name, part := range parts {
+		gotParts[name] = string(part)
+	}
+	if !reflect.DeepEqual(gotParts, wantParts) {
+		t.Errorf("partial ninja files:\n%q\nwant:\n%q", gotParts, wantParts)
+	}
+}
+
+func TestPartialNinjaWriterWithoutModules(t *testing.T) {
+	content := "rule touch\n    command = touch $out"
+	w := newPartialNinjaWriter("out/soong/build.parts", nil)
+	writeInChunks(t, w, content)
+	mainContent, parts := w.finish()
+	if string(mainContent) != content {
+		t.Errorf("main ninja file: %q, want %q", mainContent, content)
+	}
+	if len(parts) != 0 {
+		t.Errorf("partial ninja files: %q, want none", parts)
+	}
+}
+
+func TestWriteFileIfChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a", "build.ninja")
+	if err := writeFileIfChanged(path, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileIfChanged(path, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if !info.ModTime().Equal(old) {
+		t.Errorf("unchanged file was rewritten")
+	}
+
+	if err := writeFileIfChanged(path, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if string(content) != "bar" {
+		t.Errorf("content %q, want %q", content, "bar")
+	}
+}
+
+func TestRemoveStalePartialNinjaFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"build.ninja",
+		"frameworks/base/build.ninja",
+		"frameworks/base/core/build.ninja",
+		"external/foo/build.ninja",
+		"external/foo/README",
+		"packages/removed/build.ninja",
+	} {
+		if err := writeFileIfChanged(filepath.Join(dir, name), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parts := map[string][]byte{
+		"frameworks/base/build.ninja": nil,
+	}
+	if err := removeStalePartialNinjaFiles(dir, parts); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." {
+			got = append(got, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+
+	want := []string{
+		"external",
+		"external/foo",
+		"external/foo/README",
+		"frameworks",
+		"frameworks/base",
+		"frameworks/base/build.ninja",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files after removing stale ones: %q, want %q", got, want)
+	}
+}
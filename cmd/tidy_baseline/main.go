@@ -0,0 +1,168 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This tool filters the output of clang-tidy through a baseline of known findings, so that new
+// checks can be enabled for a module without fixing all of its existing findings first. Each
+// line of a baseline file is a finding with the line and column numbers removed, e.g.
+//
+//	path/to/foo.cpp: use nullptr [modernize-use-nullptr]
+//
+// Findings in the baseline are removed from the output and don't fail the build, even if their
+// check is one of the checks treated as errors. Lines that are empty or start with # are ignored.
+// The tool can also write the findings of a source file in the baseline format, which is used to
+// regenerate a baseline, and create the file of exported fixes when clang-tidy found nothing to
+// fix, in which case clang-tidy doesn't write it.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var diagnosticRe = regexp.MustCompile(`^(.+):\d+:\d+: (warning|error): (.*) \[([^\]]+)\]$`)
+
+// findingKey returns the baseline entry of a line of clang-tidy output, and whether it is an
+// error. It returns false if the line doesn't start a finding.
+func findingKey(line string) (key string, isError bool, ok bool) {
+	match := diagnosticRe.FindStringSubmatch(line)
+	if match == nil {
+		return "", false, false
+	}
+	check := strings.TrimSuffix(match[4], ",-warnings-as-errors")
+	return fmt.Sprintf("%s: %s [%s]", filepath.Clean(match[1]), match[3], check), match[2] == "error", true
+}
+
+// readBaseline returns the findings listed in a baseline file.
+func readBaseline(contents []byte) map[string]bool {
+	baseline := make(map[string]bool)
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		baseline[line] = true
+	}
+	return baseline
+}
+
+type result struct {
+	// output is the clang-tidy output without the findings in the baseline.
+	output []byte
+	// findings are the baseline entries of all findings in the clang-tidy output.
+	findings []string
+	// errors is the number of errors that are not in the baseline.
+	errors int
+	// sawErrors is true if the clang-tidy output contains any error.
+	sawErrors bool
+}
+
+// filter removes the findings in the baseline from the clang-tidy output. The notes and source
+// snippets that follow a finding are removed along with it.
+func filter(output []byte, baseline map[string]bool) result {
+	var ret result
+	var buf bytes.Buffer
+	skip := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key, isError, ok := findingKey(line); ok {
+			ret.findings = append(ret.findings, key)
+			ret.sawErrors = ret.sawErrors || isError
+			skip = baseline[key]
+			if isError && !skip {
+				ret.errors++
+			}
+		}
+		if !skip {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	ret.output = buf.Bytes()
+	return ret
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-baseline <baseline>] [-update <output>] [-fixes <fixes>] [-status <exit status>] <clang-tidy output>", os.Args[0])
+		flag.PrintDefaults()
+	}
+	baselineFile := flag.String("baseline", "", "Baseline of findings that are removed from the output")
+	update := flag.String("update", "", "Write all findings to this file in the baseline format and don't fail on errors")
+	fixes := flag.String("fixes", "", "File of fixes exported by clang-tidy, created empty if clang-tidy didn't write it")
+	status := flag.Int("status", 0, "Exit status of clang-tidy")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	output, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Error opening %q: %v", flag.Arg(0), err)
+	}
+	baseline := make(map[string]bool)
+	if *baselineFile != "" {
+		contents, err := ioutil.ReadFile(*baselineFile)
+		if err != nil {
+			log.Fatalf("Error opening %q: %v", *baselineFile, err)
+		}
+		baseline = readBaseline(contents)
+	}
+
+	res := filter(output, baseline)
+	os.Stdout.Write(res.output)
+
+	if *update != "" {
+		findings := res.findings
+		sort.Strings(findings)
+		var buf bytes.Buffer
+		for i, finding := range findings {
+			if i == 0 || findings[i-1] != finding {
+				buf.WriteString(finding)
+				buf.WriteByte('\n')
+			}
+		}
+		if err := ioutil.WriteFile(*update, buf.Bytes(), 0666); err != nil {
+			log.Fatalf("Failed to write to %q: %v", *update, err)
+		}
+	}
+
+	if *fixes != "" {
+		if _, err := os.Stat(*fixes); os.IsNotExist(err) {
+			if err := ioutil.WriteFile(*fixes, nil, 0666); err != nil {
+				log.Fatalf("Failed to write to %q: %v", *fixes, err)
+			}
+		}
+	}
+
+	switch {
+	case *status != 0 && !res.sawErrors:
+		// clang-tidy failed for another reason than its findings, e.g. a crash or a timeout.
+		os.Exit(*status)
+	case *update == "" && res.errors > 0:
+		os.Exit(1)
+	}
+}
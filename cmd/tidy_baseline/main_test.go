@@ -0,0 +1,96 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindingKey(t *testing.T) {
+	testCases := []struct {
+		line    string
+		key     string
+		isError bool
+		ok      bool
+	}{
+		{
+			line: "foo/./a.cpp:12:3: warning: use nullptr [modernize-use-nullptr]",
+			key:  "foo/a.cpp: use nullptr [modernize-use-nullptr]",
+			ok:   true,
+		},
+		{
+			line:    "foo/a.cpp:1:1: error: use nullptr [modernize-use-nullptr,-warnings-as-errors]",
+			key:     "foo/a.cpp: use nullptr [modernize-use-nullptr]",
+			isError: true,
+			ok:      true,
+		},
+		{
+			line: "foo/a.cpp:1:1: note: expanded from macro 'NULL'",
+		},
+		{
+			line: "3 warnings generated.",
+		},
+	}
+	for _, tc := range testCases {
+		key, isError, ok := findingKey(tc.line)
+		if key != tc.key || isError != tc.isError || ok != tc.ok {
+			t.Errorf("findingKey(%q) = %q, %v, %v, want %q, %v, %v", tc.line, key, isError, ok, tc.key, tc.isError, tc.ok)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	output := `2 warnings generated.
+foo/a.cpp:1:5: error: use nullptr [modernize-use-nullptr,-warnings-as-errors]
+  int *p = 0;
+      ^
+foo/a.cpp:2:5: warning: variable 'q' is not initialized [cppcoreguidelines-init-variables]
+  int q;
+      ^
+`
+	baseline := readBaseline([]byte(`# Known findings
+foo/a.cpp: use nullptr [modernize-use-nullptr]
+
+`))
+
+	res := filter([]byte(output), baseline)
+	wantOutput := `2 warnings generated.
+foo/a.cpp:2:5: warning: variable 'q' is not initialized [cppcoreguidelines-init-variables]
+  int q;
+      ^
+`
+	if string(res.output) != wantOutput {
+		t.Errorf("output = %q, want %q", res.output, wantOutput)
+	}
+	wantFindings := []string{
+		"foo/a.cpp: use nullptr [modernize-use-nullptr]",
+		"foo/a.cpp: variable 'q' is not initialized [cppcoreguidelines-init-variables]",
+	}
+	if !reflect.DeepEqual(res.findings, wantFindings) {
+		t.Errorf("findings = %q, want %q", res.findings, wantFindings)
+	}
+	if res.errors != 0 || !res.sawErrors {
+		t.Errorf("errors = %d, sawErrors = %v, want 0, true", res.errors, res.sawErrors)
+	}
+
+	res = filter([]byte(output), nil)
+	if string(res.output) != output {
+		t.Errorf("output without a baseline = %q, want %q", res.output, output)
+	}
+	if res.errors != 1 {
+		t.Errorf("errors without a baseline = %d, want 1", res.errors)
+	}
+}
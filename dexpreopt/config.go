@@ -153,6 +153,7 @@ type GlobalSoongConfig struct {
 	Zip2zip          android.Path
 	ManifestCheck    android.Path
 	ConstructContext android.Path
+	OatChecksums     android.Path
 }
 
 type ModuleConfig struct {
@@ -499,6 +500,7 @@ func createGlobalSoongConfig(ctx android.ModuleContext) *GlobalSoongConfig {
 		Zip2zip:          ctx.Config().HostToolPath(ctx, "zip2zip"),
 		ManifestCheck:    ctx.Config().HostToolPath(ctx, "manifest_check"),
 		ConstructContext: ctx.Config().HostToolPath(ctx, "construct_context"),
+		OatChecksums:     ctx.Config().HostToolPath(ctx, "dexpreopt_checksums"),
 	}
 }
 
@@ -550,6 +552,7 @@ type globalJsonSoongConfig struct {
 	Zip2zip          string
 	ManifestCheck    string
 	ConstructContext string
+	OatChecksums     string
 }
 
 // ParseGlobalSoongConfig parses the given data assumed to be read from the
@@ -571,6 +574,7 @@ func ParseGlobalSoongConfig(ctx android.PathContext, data []byte) (*GlobalSoongC
 		Zip2zip:          constructPath(ctx, jc.Zip2zip),
 		ManifestCheck:    constructPath(ctx, jc.ManifestCheck),
 		ConstructContext: constructPath(ctx, jc.ConstructContext),
+		OatChecksums:     constructPath(ctx, jc.OatChecksums),
 	}
 
 	return config, nil
@@ -616,6 +620,7 @@ func (s *globalSoongConfigSingleton) GenerateBuildActions(ctx android.SingletonC
 		Zip2zip:          config.Zip2zip.String(),
 		ManifestCheck:    config.ManifestCheck.String(),
 		ConstructContext: config.ConstructContext.String(),
+		OatChecksums:     config.OatChecksums.String(),
 	}
 
 	data, err := json.Marshal(jc)
@@ -646,6 +651,7 @@ func (s *globalSoongConfigSingleton) MakeVars(ctx android.MakeVarsContext) {
 		config.Zip2zip.String(),
 		config.ManifestCheck.String(),
 		config.ConstructContext.String(),
+		config.OatChecksums.String(),
 	}, " "))
 }
 
@@ -701,5 +707,6 @@ func globalSoongConfigForTests() *GlobalSoongConfig {
 		Zip2zip:          android.PathForTesting("zip2zip"),
 		ManifestCheck:    android.PathForTesting("manifest_check"),
 		ConstructContext: android.PathForTesting("construct_context"),
+		OatChecksums:     android.PathForTesting("dexpreopt_checksums"),
 	}
 }
@@ -97,9 +97,33 @@ func GenerateDexpreoptRule(ctx android.BuilderContext, globalSoong *GlobalSoongC
 		}
 	}
 
+	if len(rule.Installs()) > 0 {
+		checksumsCommand(ctx, globalSoong, module, rule)
+	}
+
 	return rule, nil
 }
 
+// ChecksumsPath returns the path to the checksums of the files installed by the rule that
+// GenerateDexpreoptRule returns for module, when it installs any.
+func ChecksumsPath(ctx android.PathContext, module *ModuleConfig) android.OutputPath {
+	return module.BuildPath.InSameDir(ctx, "dexpreopt_checksums.json")
+}
+
+// checksumsCommand writes the install path, the size and the sha256 of every file installed by
+// rule, so that OTA generation can tell which odex and vdex files are unchanged across builds.
+func checksumsCommand(ctx android.PathContext, globalSoong *GlobalSoongConfig, module *ModuleConfig,
+	rule *android.RuleBuilder) {
+
+	cmd := rule.Command().
+		Tool(globalSoong.OatChecksums).
+		FlagWithArg("--module ", module.Name)
+	for _, install := range rule.Installs() {
+		cmd.FlagWithArg("--file ", install.From.String()+":"+install.To)
+	}
+	cmd.FlagWithOutput("-o ", ChecksumsPath(ctx, module))
+}
+
 func dexpreoptDisabled(ctx android.PathContext, global *GlobalConfig, module *ModuleConfig) bool {
 	if contains(global.DisablePreoptModules, module.Name) {
 		return true
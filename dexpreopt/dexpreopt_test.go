@@ -17,6 +17,7 @@ package dexpreopt
 import (
 	"android/soong/android"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -107,6 +108,34 @@ func TestDexPreopt(t *testing.T) {
 	}
 }
 
+func TestDexPreoptChecksums(t *testing.T) {
+	config := android.TestConfig("out", nil, "", nil)
+	ctx := android.BuilderContextForTesting(config)
+	globalSoong := globalSoongConfigForTests()
+	global := GlobalConfigForTests(ctx)
+	module := testSystemModuleConfig(ctx, "test")
+
+	rule, err := GenerateDexpreoptRule(ctx, globalSoong, global, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksums := ChecksumsPath(ctx, module)
+	android.AssertStringListContains(t, "rule outputs", rule.Outputs().Strings(), checksums.String())
+	android.AssertStringDoesContain(t, "rule commands", strings.Join(rule.Commands(), "\n"),
+		"dexpreopt_checksums --module test"+
+			" --file "+android.PathForOutput(ctx, "test/oat/arm/package.odex").String()+":/system/app/test/oat/arm/test.odex"+
+			" --file "+android.PathForOutput(ctx, "test/oat/arm/package.vdex").String()+":/system/app/test/oat/arm/test.vdex"+
+			" -o "+checksums.String())
+
+	global.DisablePreoptModules = []string{"test"}
+	rule, err = GenerateDexpreoptRule(ctx, globalSoong, global, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	android.AssertStringListDoesNotContain(t, "rule outputs", rule.Outputs().Strings(), checksums.String())
+}
+
 func TestDexPreoptSystemOther(t *testing.T) {
 	config := android.TestConfig("out", nil, "", nil)
 	ctx := android.BuilderContextForTesting(config)
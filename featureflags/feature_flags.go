@@ -0,0 +1,406 @@
+// Copyright (C) 2022 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// featureflags package defines a module named feature_flag_library that generates C++, Java and
+// Rust libraries to read feature flags from declarative flag definition files (.flags). The
+// default value of each flag is controlled by the release configuration.
+package featureflags
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+	"android/soong/cc"
+	"android/soong/genrule"
+	"android/soong/java"
+	"android/soong/rust"
+)
+
+const (
+	languageCc   = "cc"
+	languageJava = "java"
+	languageRust = "rust"
+)
+
+var (
+	pctx = android.NewPackageContext("android/soong/featureflags")
+
+	featureFlagCodegen = pctx.AndroidStaticRule("featureFlagCodegen",
+		blueprint.RuleParams{
+			Command: `rm -rf $outDir && mkdir -p $outDir && ` +
+				`$featureFlagCodegenCmd --package $package --language $language ` +
+				`$values --out-dir $outDir $in`,
+			CommandDeps: []string{"$featureFlagCodegenCmd"},
+		}, "package", "language", "values", "outDir")
+
+	featureFlagCodegenSrcjar = pctx.AndroidStaticRule("featureFlagCodegenSrcjar",
+		blueprint.RuleParams{
+			Command: `rm -rf $out.tmp && mkdir -p $out.tmp && ` +
+				`$featureFlagCodegenCmd --package $package --language java ` +
+				`$values --out-dir $out.tmp $in && ` +
+				`$soongZipCmd -jar -o $out -C $out.tmp -D $out.tmp && rm -rf $out.tmp`,
+			CommandDeps: []string{
+				"$featureFlagCodegenCmd",
+				"$soongZipCmd",
+			},
+		}, "package", "values")
+)
+
+func init() {
+	pctx.HostBinToolVariable("soongZipCmd", "soong_zip")
+	pctx.HostBinToolVariable("featureFlagCodegenCmd", "feature_flag_codegen")
+
+	registerFeatureFlagBuildComponents(android.InitRegistrationContext)
+}
+
+func registerFeatureFlagBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterModuleType("feature_flag_library", featureFlagLibraryFactory)
+}
+
+type featureFlagGenProperties struct {
+	Name     *string
+	Srcs     []string `android:"path"`
+	Package  string
+	Language string
+}
+
+// featureFlagGen generates the flag accessors for a single language from the flag definition
+// files and the release configuration's flag values.
+type featureFlagGen struct {
+	android.ModuleBase
+
+	properties featureFlagGenProperties
+
+	genSrcs    android.Paths
+	genHeaders android.Paths
+	headerDirs android.Paths
+}
+
+var _ android.OutputFileProducer = (*featureFlagGen)(nil)
+var _ genrule.SourceFileGenerator = (*featureFlagGen)(nil)
+
+func featureFlagGenFactory() android.Module {
+	g := &featureFlagGen{}
+	g.AddProperties(&g.properties)
+	android.InitAndroidModule(g)
+	return g
+}
+
+func (g *featureFlagGen) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	srcs := android.PathsForModuleSrc(ctx, g.properties.Srcs)
+	values := android.PathsForSource(ctx, ctx.Config().ReleaseFeatureFlagValues())
+
+	var valuesArgs []string
+	for _, v := range values {
+		valuesArgs = append(valuesArgs, "--values "+v.String())
+	}
+
+	args := map[string]string{
+		"package": g.properties.Package,
+		"values":  strings.Join(valuesArgs, " "),
+	}
+
+	// The generated files are named after the last component of the package.
+	stem := g.properties.Package[strings.LastIndex(g.properties.Package, ".")+1:]
+
+	switch g.properties.Language {
+	case languageJava:
+		srcJar := android.PathForModuleGen(ctx, stem+".srcjar")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        featureFlagCodegenSrcjar,
+			Description: "feature flags java " + g.properties.Package,
+			Output:      srcJar,
+			Inputs:      srcs,
+			Implicits:   values,
+			Args:        args,
+		})
+		g.genSrcs = android.Paths{srcJar}
+	case languageCc:
+		outDir := android.PathForModuleGen(ctx, "cc")
+		src := outDir.Join(ctx, stem+".cpp")
+		header := outDir.Join(ctx, "include", stem+".h")
+		args["language"] = languageCc
+		args["outDir"] = outDir.String()
+		ctx.Build(pctx, android.BuildParams{
+			Rule:           featureFlagCodegen,
+			Description:    "feature flags cc " + g.properties.Package,
+			Output:         src,
+			ImplicitOutput: header,
+			Inputs:         srcs,
+			Implicits:      values,
+			Args:           args,
+		})
+		g.genSrcs = android.Paths{src}
+		g.genHeaders = android.Paths{header}
+		g.headerDirs = android.Paths{outDir.Join(ctx, "include")}
+	case languageRust:
+		outDir := android.PathForModuleGen(ctx, "rust")
+		src := outDir.Join(ctx, "lib.rs")
+		args["language"] = languageRust
+		args["outDir"] = outDir.String()
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        featureFlagCodegen,
+			Description: "feature flags rust " + g.properties.Package,
+			Output:      src,
+			Inputs:      srcs,
+			Implicits:   values,
+			Args:        args,
+		})
+		g.genSrcs = android.Paths{src}
+	default:
+		ctx.PropertyErrorf("language", "unsupported feature flag language %q", g.properties.Language)
+	}
+}
+
+func (g *featureFlagGen) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return g.genSrcs, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+func (g *featureFlagGen) GeneratedSourceFiles() android.Paths {
+	return g.genSrcs
+}
+
+func (g *featureFlagGen) GeneratedHeaderDirs() android.Paths {
+	return g.headerDirs
+}
+
+func (g *featureFlagGen) GeneratedDeps() android.Paths {
+	return append(append(android.Paths(nil), g.genSrcs...), g.genHeaders...)
+}
+
+type featureFlagLibraryProperties struct {
+	// list of .flags files which declare the flags.
+	Srcs []string `android:"path"`
+
+	// The package that the flags belong to, e.g. "com.android.foo".  It is used as the Java
+	// package, the C++ namespace and the Rust crate name of the generated accessors.
+	Package *string
+
+	// The languages to generate flag accessor libraries for.  Possible values are "cc", "java"
+	// and "rust".  Defaults to all of them.
+	Languages []string
+
+	// If set to true, build a variant of the libraries for the host.  Defaults to false.
+	Host_supported *bool
+
+	// Make the libraries available when building for vendor
+	Vendor_available *bool
+
+	// Make the libraries available when building for product
+	Product_available *bool
+
+	// Minimum sdk version that the generated libraries should support when they run as part of
+	// mainline modules(APEX).
+	Min_sdk_version *string
+}
+
+// featureFlagLibrary is a placeholder module that creates the flag accessor libraries in a load
+// hook.  The libraries are named lib<name> (C++), <name>-java (Java) and lib<name>_rust (Rust).
+type featureFlagLibrary struct {
+	android.ModuleBase
+	android.ApexModuleBase
+
+	properties featureFlagLibraryProperties
+}
+
+func (m *featureFlagLibrary) Name() string {
+	return m.BaseModuleName() + "_feature_flag_library"
+}
+
+func (m *featureFlagLibrary) BaseModuleName() string {
+	return m.ModuleBase.Name()
+}
+
+func (m *featureFlagLibrary) CcModuleName() string {
+	return "lib" + m.BaseModuleName()
+}
+
+func (m *featureFlagLibrary) JavaModuleName() string {
+	return m.BaseModuleName() + "-java"
+}
+
+func (m *featureFlagLibrary) RustModuleName() string {
+	return "lib" + m.BaseModuleName() + "_rust"
+}
+
+func (m *featureFlagLibrary) genModuleName(language string) string {
+	return m.BaseModuleName() + "_feature_flags_" + language
+}
+
+func (m *featureFlagLibrary) languages() []string {
+	if m.properties.Languages == nil {
+		return []string{languageCc, languageJava, languageRust}
+	}
+	return android.FirstUniqueStrings(m.properties.Languages)
+}
+
+func (m *featureFlagLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	for _, flagsFile := range android.PathsForModuleSrc(ctx, m.properties.Srcs) {
+		if flagsFile.Ext() != ".flags" {
+			ctx.PropertyErrorf("srcs", "srcs contains non-flags file %q", flagsFile.String())
+		}
+	}
+}
+
+var _ android.ApexModule = (*featureFlagLibrary)(nil)
+
+// Implements android.ApexModule
+func (m *featureFlagLibrary) ShouldSupportSdkVersion(ctx android.BaseModuleContext,
+	sdkVersion android.ApiLevel) error {
+	// The generated libraries are checked individually.
+	return nil
+}
+
+// feature_flag_library generates C++, Java and Rust libraries that provide typed accessors for
+// the feature flags declared in .flags files, so that a flag is declared once and read from any
+// language.  The default value of each flag is provided by the release configuration through the
+// ReleaseFeatureFlagValues product variable.
+func featureFlagLibraryFactory() android.Module {
+	m := &featureFlagLibrary{}
+
+	m.AddProperties(&m.properties)
+	android.InitAndroidModule(m)
+	android.InitApexModule(m)
+	android.AddLoadHook(m, func(ctx android.LoadHookContext) { featureFlagLibraryHook(ctx, m) })
+	return m
+}
+
+type ccLibraryProperties struct {
+	Name                     *string
+	Generated_sources        []string
+	Generated_headers        []string
+	Export_generated_headers []string
+	Soc_specific             *bool
+	Device_specific          *bool
+	Product_specific         *bool
+	Vendor_available         *bool
+	Product_available        *bool
+	Host_supported           *bool
+	Apex_available           []string
+	Min_sdk_version          *string
+}
+
+type javaLibraryProperties struct {
+	Name             *string
+	Srcs             []string
+	Soc_specific     *bool
+	Device_specific  *bool
+	Product_specific *bool
+	Host_supported   *bool
+	Sdk_version      *string
+	Apex_available   []string
+	Min_sdk_version  *string
+}
+
+type rustLibraryProperties struct {
+	Name              *string
+	Srcs              []string
+	Crate_name        string
+	Soc_specific      *bool
+	Device_specific   *bool
+	Product_specific  *bool
+	Vendor_available  *bool
+	Product_available *bool
+	Host_supported    *bool
+	Apex_available    []string
+	Min_sdk_version   *string
+}
+
+func featureFlagLibraryHook(ctx android.LoadHookContext, m *featureFlagLibrary) {
+	if len(m.properties.Srcs) == 0 {
+		ctx.PropertyErrorf("srcs", "feature_flag_library must specify srcs")
+	}
+	pkg := proptools.String(m.properties.Package)
+	if pkg == "" {
+		ctx.PropertyErrorf("package", "feature_flag_library must specify package")
+		return
+	}
+
+	for _, language := range m.languages() {
+		genName := m.genModuleName(language)
+		switch language {
+		case languageCc:
+			ctx.CreateModule(featureFlagGenFactory, &featureFlagGenProperties{
+				Name:     proptools.StringPtr(genName),
+				Srcs:     m.properties.Srcs,
+				Package:  pkg,
+				Language: language,
+			})
+			ctx.CreateModule(cc.LibraryFactory, &ccLibraryProperties{
+				Name:                     proptools.StringPtr(m.CcModuleName()),
+				Generated_sources:        []string{genName},
+				Generated_headers:        []string{genName},
+				Export_generated_headers: []string{genName},
+				Soc_specific:             proptools.BoolPtr(ctx.SocSpecific()),
+				Device_specific:          proptools.BoolPtr(ctx.DeviceSpecific()),
+				Product_specific:         proptools.BoolPtr(ctx.ProductSpecific()),
+				Vendor_available:         m.properties.Vendor_available,
+				Product_available:        m.properties.Product_available,
+				Host_supported:           m.properties.Host_supported,
+				Apex_available:           m.ApexProperties.Apex_available,
+				Min_sdk_version:          m.properties.Min_sdk_version,
+			})
+		case languageJava:
+			ctx.CreateModule(featureFlagGenFactory, &featureFlagGenProperties{
+				Name:     proptools.StringPtr(genName),
+				Srcs:     m.properties.Srcs,
+				Package:  pkg,
+				Language: language,
+			})
+			ctx.CreateModule(java.LibraryFactory, &javaLibraryProperties{
+				Name:             proptools.StringPtr(m.JavaModuleName()),
+				Srcs:             []string{":" + genName},
+				Soc_specific:     proptools.BoolPtr(ctx.SocSpecific()),
+				Device_specific:  proptools.BoolPtr(ctx.DeviceSpecific()),
+				Product_specific: proptools.BoolPtr(ctx.ProductSpecific()),
+				Host_supported:   m.properties.Host_supported,
+				Sdk_version:      proptools.StringPtr("core_current"),
+				Apex_available:   m.ApexProperties.Apex_available,
+				Min_sdk_version:  m.properties.Min_sdk_version,
+			})
+		case languageRust:
+			ctx.CreateModule(featureFlagGenFactory, &featureFlagGenProperties{
+				Name:     proptools.StringPtr(genName),
+				Srcs:     m.properties.Srcs,
+				Package:  pkg,
+				Language: language,
+			})
+			ctx.CreateModule(rust.RustLibraryFactory, &rustLibraryProperties{
+				Name:              proptools.StringPtr(m.RustModuleName()),
+				Srcs:              []string{":" + genName},
+				Crate_name:        strings.ReplaceAll(pkg, ".", "_"),
+				Soc_specific:      proptools.BoolPtr(ctx.SocSpecific()),
+				Device_specific:   proptools.BoolPtr(ctx.DeviceSpecific()),
+				Product_specific:  proptools.BoolPtr(ctx.ProductSpecific()),
+				Vendor_available:  m.properties.Vendor_available,
+				Product_available: m.properties.Product_available,
+				Host_supported:    m.properties.Host_supported,
+				Apex_available:    m.ApexProperties.Apex_available,
+				Min_sdk_version:   m.properties.Min_sdk_version,
+			})
+		default:
+			ctx.PropertyErrorf("languages", "unknown language %q, must be one of %q, %q or %q",
+				language, languageCc, languageJava, languageRust)
+		}
+	}
+}
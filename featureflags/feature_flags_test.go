@@ -0,0 +1,126 @@
+// Copyright (C) 2022 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package featureflags
+
+import (
+	"os"
+	"testing"
+
+	"android/soong/android"
+	"android/soong/cc"
+	"android/soong/java"
+	"android/soong/rust"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+var prepareForFeatureFlagTest = android.GroupFixturePreparers(
+	rust.PrepareForTestWithRustDefaultModules,
+	java.PrepareForTestWithJavaDefaultModules,
+	PrepareForTestWithFeatureFlagBuildComponents,
+	android.FixtureMergeMockFs(android.MockFS{
+		"foo/foo.flags":         nil,
+		"release/values.values": nil,
+	}),
+	android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+		variables.ReleaseFeatureFlagValues = []string{"release/values.values"}
+	}),
+)
+
+func TestFeatureFlagLibrary(t *testing.T) {
+	result := prepareForFeatureFlagTest.RunTestWithBp(t, `
+		feature_flag_library {
+			name: "foo_flags",
+			srcs: ["foo/foo.flags"],
+			package: "com.android.foo",
+		}
+	`)
+
+	genDir := func(language string) string {
+		return "out/soong/.intermediates/foo_flags_feature_flags_" + language + "/gen"
+	}
+
+	ccGen := result.ModuleForTests("foo_flags_feature_flags_cc", "").Rule("featureFlagCodegen")
+	android.AssertStringEquals(t, "cc language", "cc", ccGen.Args["language"])
+	android.AssertStringEquals(t, "cc package", "com.android.foo", ccGen.Args["package"])
+	android.AssertStringEquals(t, "cc values", "--values release/values.values", ccGen.Args["values"])
+	android.AssertStringEquals(t, "cc out dir", genDir("cc")+"/cc", ccGen.Args["outDir"])
+	android.AssertPathsRelativeToTopEquals(t, "cc inputs", []string{"foo/foo.flags"}, ccGen.Inputs)
+	android.AssertPathsRelativeToTopEquals(t, "cc implicits", []string{"release/values.values"}, ccGen.Implicits)
+	android.AssertPathRelativeToTopEquals(t, "cc source", genDir("cc")+"/cc/foo.cpp", ccGen.Output)
+	android.AssertPathsRelativeToTopEquals(t, "cc header", []string{genDir("cc") + "/cc/include/foo.h"},
+		ccGen.ImplicitOutputs)
+
+	javaGen := result.ModuleForTests("foo_flags_feature_flags_java", "").Rule("featureFlagCodegenSrcjar")
+	android.AssertStringEquals(t, "java package", "com.android.foo", javaGen.Args["package"])
+	android.AssertStringEquals(t, "java values", "--values release/values.values", javaGen.Args["values"])
+	android.AssertPathsRelativeToTopEquals(t, "java inputs", []string{"foo/foo.flags"}, javaGen.Inputs)
+	android.AssertPathsRelativeToTopEquals(t, "java implicits", []string{"release/values.values"}, javaGen.Implicits)
+	android.AssertPathRelativeToTopEquals(t, "java srcjar", genDir("java")+"/foo.srcjar", javaGen.Output)
+
+	rustGen := result.ModuleForTests("foo_flags_feature_flags_rust", "").Rule("featureFlagCodegen")
+	android.AssertStringEquals(t, "rust language", "rust", rustGen.Args["language"])
+	android.AssertStringEquals(t, "rust package", "com.android.foo", rustGen.Args["package"])
+	android.AssertStringEquals(t, "rust values", "--values release/values.values", rustGen.Args["values"])
+	android.AssertStringEquals(t, "rust out dir", genDir("rust")+"/rust", rustGen.Args["outDir"])
+	android.AssertPathsRelativeToTopEquals(t, "rust inputs", []string{"foo/foo.flags"}, rustGen.Inputs)
+	android.AssertPathRelativeToTopEquals(t, "rust source", genDir("rust")+"/rust/lib.rs", rustGen.Output)
+
+	// The C++ library is created for the device.
+	result.ModuleForTests("libfoo_flags", "android_arm64_armv8-a_shared")
+
+	javaLib := result.ModuleForTests("foo_flags-java", "android_common")
+	javac := javaLib.Rule("javac")
+	android.AssertStringDoesContain(t, "java srcjars", javac.Args["srcJars"], "foo_flags_feature_flags_java/gen/foo.srcjar")
+
+	rustLib := result.ModuleForTests("libfoo_flags_rust", "android_arm64_armv8-a_rlib_rlib-std")
+	android.AssertStringEquals(t, "crate name", "com_android_foo",
+		rustLib.Module().(*rust.Module).CrateName())
+}
+
+func TestFeatureFlagLibraryProductAvailable(t *testing.T) {
+	result := prepareForFeatureFlagTest.RunTestWithBp(t, `
+		feature_flag_library {
+			name: "foo_flags",
+			srcs: ["foo/foo.flags"],
+			package: "com.android.foo",
+			languages: ["cc", "rust"],
+			vendor_available: true,
+			product_available: true,
+		}
+	`)
+
+	ccLib := result.ModuleForTests("libfoo_flags", "android_arm64_armv8-a_shared").Module().(*cc.Module)
+	android.AssertBoolEquals(t, "cc library is product available", true, ccLib.ProductAvailable())
+
+	rustLib := result.ModuleForTests("libfoo_flags_rust", "android_arm64_armv8-a_rlib_rlib-std").Module().(*rust.Module)
+	android.AssertBoolEquals(t, "rust library is product available", true, rustLib.ProductAvailable())
+}
+
+func TestFeatureFlagLibraryErrors(t *testing.T) {
+	prepareForFeatureFlagTest.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`unknown language "go"`)).
+		RunTestWithBp(t, `
+			feature_flag_library {
+				name: "foo_flags",
+				srcs: ["foo/foo.flags"],
+				package: "com.android.foo",
+				languages: ["go"],
+			}
+		`)
+}
@@ -45,7 +45,7 @@ type bootimgProperties struct {
 	// Path to the linux kernel prebuilt file
 	Kernel_prebuilt *string `android:"arch_variant,path"`
 
-	// Filesystem module that is used as ramdisk
+	// Filesystem module that is used as ramdisk, e.g. an android_ramdisk module
 	Ramdisk_module *string
 
 	// Path to the device tree blob (DTB) prebuilt file to add to this boot image
@@ -120,6 +120,7 @@ func (b *bootimg) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	} else {
 		b.output = unsignedOutput
 	}
+	android.CheckReproducibleOutput(ctx, android.ReproducibleFile, b.output, "")
 
 	b.installDir = android.PathForModuleInstall(ctx, "etc")
 	ctx.InstallFile(b.installDir, b.installFileName(), b.output)
@@ -178,14 +179,14 @@ func (b *bootimg) buildBootImage(ctx android.ModuleContext, vendor bool) android
 	ramdiskName := proptools.String(b.properties.Ramdisk_module)
 	if ramdiskName != "" {
 		ramdisk := ctx.GetDirectDepWithTag(ramdiskName, bootimgRamdiskDep)
-		if filesystem, ok := ramdisk.(*filesystem); ok {
+		if filesystem, ok := ramdisk.(Filesystem); ok {
 			flag := "--ramdisk "
 			if vendor {
 				flag = "--vendor_ramdisk "
 			}
 			cmd.FlagWithInput(flag, filesystem.OutputPath())
 		} else {
-			ctx.PropertyErrorf("ramdisk", "%q is not android_filesystem module", ramdisk.Name())
+			ctx.PropertyErrorf("ramdisk", "%q is not android_filesystem or android_ramdisk module", ramdisk.Name())
 			return output
 		}
 	}
@@ -245,8 +246,9 @@ func (b *bootimg) buildPropFile(ctx android.ModuleContext) (propFile android.Out
 	addStr("avb_algorithm", algorithm)
 	key := android.PathForModuleSrc(ctx, proptools.String(b.properties.Avb_private_key))
 	addPath("avb_key_path", key)
-	addStr("avb_add_hash_footer_args", "") // TODO(jiyong): add --rollback_index
 	partitionName := proptools.StringDefault(b.properties.Partition_name, b.Name())
+	// TODO(jiyong): add --rollback_index
+	addStr("avb_add_hash_footer_args", "--salt "+android.ReproducibleSalt(partitionName))
 	addStr("partition_name", partitionName)
 
 	propFile = android.PathForModuleOut(ctx, "prop").OutputPath
@@ -17,6 +17,7 @@ package filesystem
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"android/soong/android"
@@ -31,6 +32,7 @@ func init() {
 
 func registerBuildComponents(ctx android.RegistrationContext) {
 	ctx.RegisterModuleType("android_filesystem", filesystemFactory)
+	ctx.RegisterModuleType("android_ramdisk", ramdiskFactory)
 	ctx.RegisterModuleType("android_system_image", systemImageFactory)
 }
 
@@ -151,13 +153,19 @@ func (f *filesystem) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	case ext4Type:
 		f.output = f.buildImageUsingBuildImage(ctx)
 	case compressedCpioType:
-		f.output = f.buildCpioImage(ctx, true)
+		f.output = f.buildCpioImage(ctx, lz4Compression, nil)
 	case cpioType:
-		f.output = f.buildCpioImage(ctx, false)
+		f.output = f.buildCpioImage(ctx, noCompression, nil)
 	default:
 		return
 	}
 
+	if f.fsType(ctx) == ext4Type {
+		android.CheckReproducibleOutput(ctx, android.ReproducibleExt4, f.output, f.BaseModuleName())
+	} else {
+		android.CheckReproducibleOutput(ctx, android.ReproducibleFile, f.output, "")
+	}
+
 	f.installDir = android.PathForModuleInstall(ctx, "etc")
 	ctx.InstallFile(f.installDir, f.installFileName(), f.output)
 }
@@ -305,6 +313,10 @@ func (f *filesystem) buildPropFile(ctx android.ModuleContext) (propFile android.
 	addStr("fs_type", fsTypeStr(f.fsType(ctx)))
 	addStr("mount_point", "/")
 	addStr("use_dynamic_partition_size", "true")
+	// Pin the parameters mke2fs would otherwise take from the clock or from a random source.
+	addStr("timestamp", strconv.FormatInt(android.ReproducibleTime.Unix(), 10))
+	addStr("uuid", android.ReproducibleUUID(f.BaseModuleName()))
+	addStr("hash_seed", android.ReproducibleUUID(f.BaseModuleName()))
 	addPath("ext_mkuserimg", ctx.Config().HostToolPath(ctx, "mkuserimg_mke2fs"))
 	// b/177813163 deps of the host tools have to be added. Remove this.
 	for _, t := range []string{"mke2fs", "e2fsdroid", "tune2fs"} {
@@ -321,6 +333,7 @@ func (f *filesystem) buildPropFile(ctx android.ModuleContext) (propFile android.
 		addStr("avb_add_hashtree_footer_args", "--do_not_generate_fec")
 		partitionName := proptools.StringDefault(f.properties.Partition_name, f.Name())
 		addStr("partition_name", partitionName)
+		addStr("avb_salt", android.ReproducibleSalt(partitionName))
 	}
 
 	if proptools.String(f.properties.File_contexts) != "" {
@@ -340,7 +353,19 @@ func (f *filesystem) buildPropFile(ctx android.ModuleContext) (propFile android.
 	return propFile, deps
 }
 
-func (f *filesystem) buildCpioImage(ctx android.ModuleContext, compressed bool) android.OutputPath {
+// cpioCompression is the compression of a cpio image.
+type cpioCompression int
+
+const (
+	noCompression cpioCompression = iota
+	lz4Compression
+	gzipCompression
+	zstdCompression
+)
+
+// buildCpioImage builds a cpio image with the given compression. If devNodes is not nil, it is
+// passed to mkbootfs to create the device nodes listed in it.
+func (f *filesystem) buildCpioImage(ctx android.ModuleContext, compression cpioCompression, devNodes android.Path) android.OutputPath {
 	if proptools.Bool(f.properties.Use_avb) {
 		ctx.PropertyErrorf("use_avb", "signing compresed cpio image using avbtool is not supported."+
 			"Consider adding this to bootimg module and signing the entire boot image.")
@@ -372,18 +397,29 @@ func (f *filesystem) buildCpioImage(ctx android.ModuleContext, compressed bool)
 
 	output := android.PathForModuleOut(ctx, f.installFileName()).OutputPath
 	cmd := builder.Command().
-		BuiltTool("mkbootfs").
-		Text(rootDir.String()) // input directory
-	if compressed {
+		BuiltTool("mkbootfs")
+	if devNodes != nil {
+		cmd.FlagWithInput("-n ", devNodes)
+	}
+	cmd.Text(rootDir.String()) // input directory
+	switch compression {
+	case lz4Compression:
 		cmd.Text("|").
 			BuiltTool("lz4").
 			Flag("--favor-decSpeed"). // for faster boot
 			Flag("-12").              // maximum compression level
-			Flag("-l").               // legacy format for kernel
-			Text(">").Output(output)
-	} else {
-		cmd.Text(">").Output(output)
+			Flag("-l")                // legacy format for kernel
+	case gzipCompression:
+		cmd.Text("|").
+			BuiltTool("minigzip").
+			Flag("-9") // maximum compression level
+	case zstdCompression:
+		cmd.Text("|").
+			BuiltTool("zstd").
+			Flag("-19"). // maximum compression level without --ultra
+			Flag("-c")
 	}
+	cmd.Text(">").Output(output)
 
 	// rootDir is not deleted. Might be useful for quick inspection.
 	builder.Build("build_cpio_image", fmt.Sprintf("Creating filesystem %s", f.BaseModuleName()))
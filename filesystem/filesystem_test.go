@@ -16,6 +16,7 @@ package filesystem
 
 import (
 	"os"
+	"regexp"
 	"testing"
 
 	"android/soong/android"
@@ -43,6 +44,53 @@ func TestFileSystemDeps(t *testing.T) {
 	result.ModuleForTests("myfilesystem", "android_common").Output("myfilesystem.img")
 }
 
+func TestFileSystemReproducibleParameters(t *testing.T) {
+	result := fixture.RunTestWithBp(t, `
+		android_filesystem {
+			name: "myfilesystem",
+		}
+	`)
+
+	module := result.ModuleForTests("myfilesystem", "android_common")
+	props := module.Output("prop").RuleParams.Command
+	uuid := android.ReproducibleUUID("myfilesystem")
+	android.AssertStringDoesContain(t, "timestamp should be pinned", props, `"timestamp=1199145600"`)
+	android.AssertStringDoesContain(t, "uuid should be pinned", props, `"uuid=`+uuid+`"`)
+	android.AssertStringDoesContain(t, "hash seed should be pinned", props, `"hash_seed=`+uuid+`"`)
+
+	check := module.Output("reproducibility/myfilesystem.img.checked")
+	android.AssertStringEquals(t, "check kind", "ext4", check.Args["kind"])
+	android.AssertStringDoesContain(t, "check should expect the pinned uuid", check.Args["flags"], "--uuid "+uuid)
+	android.AssertStringDoesNotContain(t, "check without a reference build", check.Args["flags"], "--reference")
+}
+
+func TestFileSystemReproducibilityReference(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		fixture,
+		android.FixtureMergeEnv(map[string]string{
+			"REPRODUCIBILITY_REFERENCE_OUT_DIR": "/reference/out",
+		}),
+	).RunTestWithBp(t, `
+		android_filesystem {
+			name: "myfilesystem",
+		}
+
+		android_filesystem {
+			name: "myramdisk",
+			type: "compressed_cpio",
+		}
+	`)
+
+	check := result.ModuleForTests("myfilesystem", "android_common").Output("reproducibility/myfilesystem.img.checked")
+	android.AssertStringDoesContain(t, "check should compare with the reference build", check.Args["flags"],
+		"--reference /reference/out/soong/.intermediates/myfilesystem/android_common/myfilesystem.img")
+
+	check = result.ModuleForTests("myramdisk", "android_common").Output("reproducibility/myramdisk.img.checked")
+	android.AssertStringEquals(t, "cpio check kind", "file", check.Args["kind"])
+	android.AssertStringDoesContain(t, "cpio check should compare with the reference build", check.Args["flags"],
+		"--reference /reference/out/soong/.intermediates/myramdisk/android_common/myramdisk.img")
+}
+
 func TestFileSystemFillsLinkerConfigWithStubLibs(t *testing.T) {
 	result := fixture.RunTestWithBp(t, `
 		android_system_image {
@@ -125,3 +173,105 @@ func TestFileSystemGathersItemsOnlyInSystemPartition(t *testing.T) {
 	module := result.ModuleForTests("myfilesystem", "android_common").Module().(*systemImage)
 	android.AssertDeepEquals(t, "entries should have foo only", []string{"components/foo"}, module.entries)
 }
+
+func TestRamdisk(t *testing.T) {
+	f := android.GroupFixturePreparers(
+		fixture,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("bootimg", bootimgFactory)
+		}),
+	)
+	result := f.RunTestWithBp(t, `
+		android_ramdisk {
+			name: "myramdisk",
+			compression: "gzip",
+			dirs: ["dev"],
+			dev_nodes: [
+				{
+					path: "dev/console",
+					type: "c",
+					major: 5,
+					minor: 1,
+				},
+				{
+					path: "dev/block/sda",
+					type: "b",
+					major: 8,
+					minor: 0,
+					mode: "0640",
+				},
+			],
+		}
+
+		bootimg {
+			name: "myvendorboot",
+			vendor_boot: true,
+			header_version: "3",
+			ramdisk_module: "myramdisk",
+		}
+	`)
+
+	module := result.ModuleForTests("myramdisk", "android_common")
+	command := module.Output("myramdisk.img").RuleParams.Command
+	android.AssertStringDoesContain(t, "mkbootfs should create the device nodes", command, "-n ")
+	android.AssertStringDoesContain(t, "ramdisk should be compressed with gzip", command, "minigzip -9")
+	android.AssertStringDoesNotContain(t, "ramdisk should not be compressed with lz4", command, "lz4")
+	android.AssertStringEquals(t, "device nodes",
+		"dev /dev/console 0600 0 0 c 5 1\ndev /dev/block/sda 0640 0 0 b 8 0",
+		android.ContentFromFileRuleForTests(t, module.Output("dev_nodes.txt")))
+
+	bootimg := result.ModuleForTests("myvendorboot", "android_arm64_armv8-a")
+	android.AssertStringDoesContain(t, "vendor_boot should use the ramdisk",
+		bootimg.Output("unsigned/myvendorboot.img").RuleParams.Command, "--vendor_ramdisk ")
+}
+
+func TestRamdiskErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		bp   string
+		err  string
+	}{
+		{
+			name: "unknown compression",
+			bp:   `compression: "xz",`,
+			err:  `compression: "xz" not supported`,
+		},
+		{
+			name: "type",
+			bp:   `type: "cpio",`,
+			err:  `type: not supported for android_ramdisk, use compression instead`,
+		},
+		{
+			name: "invalid node type",
+			bp:   `dev_nodes: [{path: "dev/console", type: "p", major: 5, minor: 1}],`,
+			err:  `dev_nodes: Type of "dev/console" must be "c" or "b", got "p"`,
+		},
+		{
+			name: "missing minor",
+			bp:   `dev_nodes: [{path: "dev/console", type: "c", major: 5}],`,
+			err:  `dev_nodes: Major and Minor of "dev/console" must be set to non-negative numbers`,
+		},
+		{
+			name: "invalid mode",
+			bp:   `dev_nodes: [{path: "dev/console", type: "c", major: 5, minor: 1, mode: "rw"}],`,
+			err:  `dev_nodes: Mode of "dev/console" must be octal permission bits, got "rw"`,
+		},
+		{
+			name: "absolute path",
+			bp:   `dev_nodes: [{path: "/dev/console", type: "c", major: 5, minor: 1}],`,
+			err:  `dev_nodes: Path "/dev/console" must be relative to root`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fixture.ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(regexp.QuoteMeta(test.err))).
+				RunTestWithBp(t, `
+					android_ramdisk {
+						name: "myramdisk",
+						`+test.bp+`
+					}
+				`)
+		})
+	}
+}
@@ -182,6 +182,7 @@ func (l *logicalPartition) GenerateAndroidBuildActions(ctx android.ModuleContext
 	cmd.FlagWithOutput("--output=", l.output)
 
 	builder.Build("build_logical_partition", fmt.Sprintf("Creating %s", l.BaseModuleName()))
+	android.CheckReproducibleOutput(ctx, android.ReproducibleFile, l.output, "")
 
 	l.installDir = android.PathForModuleInstall(ctx, "etc")
 	ctx.InstallFile(l.installDir, l.installFileName(), l.output)
@@ -0,0 +1,142 @@
+// Copyright (C) 2022 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+type ramdisk struct {
+	filesystem
+
+	properties ramdiskProperties
+}
+
+type devNodeDefinition struct {
+	// Path of the device node relative to root, e.g. "dev/console".
+	Path *string
+
+	// Type of the device node, "c" for a character device or "b" for a block device.
+	Type *string
+
+	// Major and minor numbers of the device node.
+	Major *int64
+	Minor *int64
+
+	// Permission bits of the device node in octal. Default is "0600".
+	Mode *string
+}
+
+type ramdiskProperties struct {
+	// Compression of the cpio archive. Currently, lz4, gzip, zstd, and none are supported.
+	// Default is lz4.
+	Compression *string
+
+	// Device nodes to be created in the cpio archive. They are created by mkbootfs, so that
+	// building the ramdisk doesn't require root.
+	Dev_nodes []devNodeDefinition
+}
+
+// android_ramdisk is a specialization of android_filesystem for ramdisks, e.g. the first stage
+// ramdisk, which are cpio archives created by mkbootfs that the kernel unpacks at boot. The
+// ramdisk can be used as ramdisk_module of a bootimg module.
+func ramdiskFactory() android.Module {
+	module := &ramdisk{}
+	module.AddProperties(&module.properties)
+	initFilesystemModule(&module.filesystem)
+	return module
+}
+
+func (r *ramdisk) compression(ctx android.ModuleContext) cpioCompression {
+	compression := proptools.StringDefault(r.properties.Compression, "lz4")
+	switch compression {
+	case "lz4":
+		return lz4Compression
+	case "gzip":
+		return gzipCompression
+	case "zstd":
+		return zstdCompression
+	case "none":
+		return noCompression
+	default:
+		ctx.PropertyErrorf("compression", "%q not supported", compression)
+		return noCompression
+	}
+}
+
+func (r *ramdisk) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if r.filesystem.properties.Type != nil {
+		ctx.PropertyErrorf("type", "not supported for android_ramdisk, use compression instead")
+		return
+	}
+	compression := r.compression(ctx)
+	devNodes := r.buildDevNodesFile(ctx)
+	if ctx.Failed() {
+		return
+	}
+
+	r.output = r.buildCpioImage(ctx, compression, devNodes)
+	r.installDir = android.PathForModuleInstall(ctx, "etc")
+	ctx.InstallFile(r.installDir, r.installFileName(), r.output)
+}
+
+// buildDevNodesFile writes the dev_nodes in the format of the nodes file of mkbootfs, i.e.
+// "dev <path> <mode> <uid> <gid> <type> <major> <minor>" for each node. Returns nil if there are
+// no device nodes.
+func (r *ramdisk) buildDevNodesFile(ctx android.ModuleContext) android.Path {
+	if len(r.properties.Dev_nodes) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, node := range r.properties.Dev_nodes {
+		path := strings.TrimSpace(proptools.String(node.Path))
+		if path == "" {
+			ctx.PropertyErrorf("dev_nodes", "Path can't be empty")
+			continue
+		}
+		if filepath.IsAbs(path) || strings.HasPrefix(filepath.Clean(path), "..") {
+			ctx.PropertyErrorf("dev_nodes", "Path %q must be relative to root", path)
+			continue
+		}
+		nodeType := proptools.String(node.Type)
+		if nodeType != "c" && nodeType != "b" {
+			ctx.PropertyErrorf("dev_nodes", "Type of %q must be \"c\" or \"b\", got %q", path, nodeType)
+			continue
+		}
+		if node.Major == nil || node.Minor == nil || *node.Major < 0 || *node.Minor < 0 {
+			ctx.PropertyErrorf("dev_nodes", "Major and Minor of %q must be set to non-negative numbers", path)
+			continue
+		}
+		mode := proptools.StringDefault(node.Mode, "0600")
+		if m, err := strconv.ParseUint(mode, 8, 32); err != nil || m > 07777 {
+			ctx.PropertyErrorf("dev_nodes", "Mode of %q must be octal permission bits, got %q", path, mode)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("dev /%s %s 0 0 %s %d %d",
+			filepath.Clean(path), mode, nodeType, *node.Major, *node.Minor))
+	}
+
+	output := android.PathForModuleOut(ctx, "dev_nodes.txt")
+	android.WriteFileRule(ctx, output, strings.Join(lines, "\n"))
+	return output
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -149,6 +150,12 @@ type generatorProperties struct {
 
 	// input files to exclude
 	Exclude_srcs []string `android:"path,arch_variant"`
+
+	// names of the environment variables read by cmd, as $$NAME. Their values are set in the
+	// environment of cmd, which reruns when they change. When GENRULE_SANDBOX_ENV=true, cmd can't
+	// read any other variable but PATH. The sha256 hashes of the tools of cmd are always written to
+	// genrule.tools.sha256 in the module's output directory, which is an input of cmd.
+	Env []string
 }
 
 type Module struct {
@@ -182,6 +189,9 @@ type Module struct {
 	outputFiles android.Paths
 	outputDeps  android.Paths
 
+	outputGlobs    []string
+	outputGlobsZip android.OptionalPath
+
 	subName string
 	subDir  string
 
@@ -196,9 +206,13 @@ type generateTask struct {
 	out        android.WritablePaths
 	depFile    android.WritablePath
 	copyTo     android.WritablePaths // For gensrcs to set on gensrcsMerge rule.
-	genDir     android.WritablePath
+	genDir     android.ModuleGenPath
 	extraTools android.Paths // dependencies on tools used by the generator
 
+	// Glob patterns relative to genDir of generated files that are packaged into globsZip.
+	globs    []string
+	globsZip android.WritablePath
+
 	cmd string
 	// For gensrsc sharding.
 	shard  int
@@ -234,8 +248,17 @@ func (g *Module) OutputFiles(tag string) (android.Paths, error) {
 	return nil, fmt.Errorf("unsupported module reference tag %q", tag)
 }
 
+func (g *Module) OutputGlobs() []string {
+	return g.outputGlobs
+}
+
+func (g *Module) OutputGlobsZip() android.OptionalPath {
+	return g.outputGlobsZip
+}
+
 var _ android.SourceFileProducer = (*Module)(nil)
 var _ android.OutputFileProducer = (*Module)(nil)
+var _ android.OutputGlobsProducer = (*Module)(nil)
 
 func toolDepsMutator(ctx android.BottomUpMutatorContext) {
 	if g, ok := ctx.Module().(*Module); ok {
@@ -412,13 +435,35 @@ func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	var zipArgs strings.Builder
 
 	cmd := String(g.properties.Cmd)
+
+	// The declared environment variables are set in the command, so that it reruns when they change.
+	sandboxEnv := ctx.Config().IsEnvTrue("GENRULE_SANDBOX_ENV")
+	var envVars []string
+	for _, name := range g.properties.Env {
+		if !envVarNameRegexp.MatchString(name) {
+			ctx.PropertyErrorf("env", "invalid environment variable name %q", name)
+			continue
+		}
+		envVars = append(envVars, name+"="+proptools.ShellEscape(ctx.Config().Getenv(name)))
+	}
+	if sandboxEnv {
+		if undeclared := undeclaredEnvVars(cmd, g.properties.Env); len(undeclared) > 0 {
+			ctx.PropertyErrorf("cmd", "reads the environment variables %s, which are not declared in env. "+
+				"Only PATH and the declared variables are set when GENRULE_SANDBOX_ENV=true.",
+				strings.Join(undeclared, ", "))
+		}
+	}
+	if ctx.Failed() {
+		return
+	}
+
 	if g.CmdModifier != nil {
 		cmd = g.CmdModifier(ctx, cmd)
 	}
 
 	// Generate tasks, either from genrule or gensrcs.
 	for _, task := range g.taskGenerator(ctx, cmd, srcFiles) {
-		if len(task.out) == 0 {
+		if len(task.out) == 0 && task.globsZip == nil {
 			ctx.ModuleErrorf("must have at least one output file")
 			return
 		}
@@ -529,7 +574,31 @@ func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		}
 		g.rawCommands = append(g.rawCommands, rawCommand)
 
-		cmd.Text(rawCommand)
+		if sandboxEnv {
+			// Run the command in an environment containing only PATH and the declared variables.
+			cmd.Text(`env -i PATH="$PATH"`)
+			for _, envVar := range envVars {
+				cmd.Text(envVar)
+			}
+			cmd.Text("bash -c").Text(proptools.ShellEscape(rawCommand))
+		} else if len(envVars) > 0 {
+			cmd.Text("export").Text(strings.Join(envVars, " ")).Text("&&").Text(rawCommand)
+		} else {
+			cmd.Text(rawCommand)
+		}
+
+		// The hashes of the tools are written by a separate rule and are an input of the command, so
+		// that the inputs of the command identify the contents of its tools.
+		if allTools := append(append(android.Paths{}, tools...), task.extraTools...); len(allTools) > 0 {
+			toolsHash := android.PathForModuleOut(ctx,
+				strings.TrimSuffix(manifestName, ".sbox.textproto")+".tools.sha256")
+			hashRule := android.NewRuleBuilder(pctx, ctx)
+			hashRule.Command().
+				Text("sha256sum").Inputs(allTools).
+				FlagWithOutput("> ", toolsHash)
+			hashRule.Build(name+"_tools_sha256", desc+" tools sha256")
+			cmd.Implicit(toolsHash)
+		}
 		cmd.ImplicitOutputs(task.out)
 		cmd.Implicits(task.in)
 		cmd.ImplicitTools(tools)
@@ -539,6 +608,19 @@ func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 			cmd.ImplicitDepFile(task.depFile)
 		}
 
+		if task.globsZip != nil {
+			// The names of the files matching the globs are not known until the command has run, so
+			// package them into a zip in the sandbox so that the zip can be a declared output.
+			zipCmd := rule.Command().BuiltTool("soong_zip").
+				FlagWithOutput("-o ", task.globsZip).
+				FlagWithArg("-C ", cmd.PathForOutput(task.genDir))
+			for _, glob := range task.globs {
+				zipCmd.FlagWithArg("-f ", proptools.ShellEscape(filepath.Join(cmd.PathForOutput(task.genDir), glob)))
+			}
+			g.outputGlobs = append(g.outputGlobs, task.globs...)
+			g.outputGlobsZip = android.OptionalPathForPath(task.globsZip)
+		}
+
 		// Create the rule to run the genrule command inside sbox.
 		rule.Build(name, desc)
 
@@ -556,6 +638,9 @@ func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		} else {
 			outputFiles = append(outputFiles, task.out...)
 		}
+		if task.globsZip != nil {
+			outputFiles = append(outputFiles, task.globsZip)
+		}
 	}
 
 	if len(copyFrom) > 0 {
@@ -813,12 +898,26 @@ func NewGenRule() *Module {
 			}
 			outs[i] = outPath
 		}
+		var globsZip android.WritablePath
+		if len(properties.Out_globs) > 0 {
+			for _, glob := range properties.Out_globs {
+				if clean := filepath.Clean(glob); filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+					ctx.PropertyErrorf("out_globs", "%q must be relative to $(genDir)", glob)
+				}
+			}
+			globsZip = android.PathForModuleGen(ctx, ctx.ModuleName()+".srcjar")
+			if depFile == nil {
+				depFile = globsZip.ReplaceExtension(ctx, "d")
+			}
+		}
 		return []generateTask{{
-			in:      srcFiles,
-			out:     outs,
-			depFile: depFile,
-			genDir:  android.PathForModuleGen(ctx),
-			cmd:     rawCommand,
+			in:       srcFiles,
+			out:      outs,
+			depFile:  depFile,
+			genDir:   android.PathForModuleGen(ctx),
+			cmd:      rawCommand,
+			globs:    properties.Out_globs,
+			globsZip: globsZip,
 		}}
 	}
 
@@ -836,6 +935,13 @@ func GenRuleFactory() android.Module {
 type genRuleProperties struct {
 	// names of the output files that will be generated
 	Out []string `android:"arch_variant"`
+
+	// Glob patterns, relative to $(genDir), of files generated by cmd whose names are not known
+	// in advance, e.g. the tree of files written by a code generator. The matching files are
+	// packaged with their paths relative to $(genDir) into $(genDir)/<name>.srcjar, which is an
+	// output of this module in addition to the files listed in out. A pattern that matches no
+	// files is an error.
+	Out_globs []string `android:"arch_variant"`
 }
 
 type bazelGenruleAttributes struct {
@@ -909,6 +1015,46 @@ func (m *Module) ConvertWithBp2build(ctx android.TopDownMutatorContext) {
 	ctx.CreateBazelTargetModule(props, android.CommonAttributes{Name: m.Name()}, attrs)
 }
 
+var envVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Matches the variables read in a cmd property, which are escaped as $$NAME or $${NAME}.
+var envVarReadRegexp = regexp.MustCompile(`\$\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Matches the shell variables assigned in a cmd property.
+var shellVarAssignRegexp = regexp.MustCompile(`(?:^|[\s;&|(])([A-Za-z_][A-Za-z0-9_]*)=`)
+
+// Matches the shell variables set by for loops and read commands in a cmd property.
+var shellVarLoopRegexp = regexp.MustCompile(`\b(?:for|read(?:\s+-\w+)*)\s+([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Matches the single-quoted strings of a cmd property, where $ is not expanded by the shell.
+var singleQuotedRegexp = regexp.MustCompile(`'[^']*'`)
+
+// Variables that are set by the shell, or kept in the sandboxed environment.
+var shellEnvVars = []string{"PATH", "PWD", "OLDPWD", "IFS", "RANDOM", "SECONDS", "LINENO",
+	"OPTARG", "OPTIND", "PIPESTATUS", "BASH_REMATCH", "BASHPID", "PPID", "UID", "EUID"}
+
+// undeclaredEnvVars returns the variables read by cmd that are neither declared in env, nor set
+// by cmd or by the shell.
+func undeclaredEnvVars(cmd string, env []string) []string {
+	cmd = singleQuotedRegexp.ReplaceAllString(cmd, "")
+	known := make(map[string]bool)
+	for _, name := range append(append([]string{}, env...), shellEnvVars...) {
+		known[name] = true
+	}
+	for _, re := range []*regexp.Regexp{shellVarAssignRegexp, shellVarLoopRegexp} {
+		for _, match := range re.FindAllStringSubmatch(cmd, -1) {
+			known[match[1]] = true
+		}
+	}
+	var undeclared []string
+	for _, match := range envVarReadRegexp.FindAllStringSubmatch(cmd, -1) {
+		if !known[match[1]] {
+			undeclared = append(undeclared, match[1])
+		}
+	}
+	return android.FirstUniqueStrings(undeclared)
+}
+
 var Bool = proptools.Bool
 var String = proptools.String
 
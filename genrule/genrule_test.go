@@ -722,6 +722,125 @@ func TestGenruleOutputFiles(t *testing.T) {
 		result.ModuleForTests("gen_all", "").Module().(*useSource).srcs)
 }
 
+func TestGenruleOutGlobs(t *testing.T) {
+	bp := `
+				genrule {
+					name: "gen",
+					out: ["foo"],
+					out_globs: ["java/**/*.java"],
+					cmd: "echo foo > $(out) && codegen --out $(genDir)/java",
+				}
+				use_source {
+					name: "gen_all",
+					srcs: [":gen"],
+				}
+				use_source {
+					name: "gen_srcjar",
+					srcs: [":gen{gen.srcjar}"],
+				}
+			`
+
+	result := prepareForGenRuleTest.RunTestWithBp(t, testGenruleBp()+bp)
+	gen := result.ModuleForTests("gen", "")
+	manifest := android.RuleBuilderSboxProtoForTests(t, gen.Output("genrule.sbox.textproto"))
+	android.AssertStringDoesContain(t, "command", manifest.Commands[0].GetCommand(),
+		"soong_zip -o __SBOX_SANDBOX_DIR__/out/gen.srcjar -C __SBOX_SANDBOX_DIR__/out -f '__SBOX_SANDBOX_DIR__/out/java/**/*.java'")
+
+	module := gen.Module().(*Module)
+	android.AssertDeepEquals(t, "output globs", []string{"java/**/*.java"}, module.OutputGlobs())
+	android.AssertPathRelativeToTopEquals(t, "output globs zip",
+		"out/soong/.intermediates/gen/gen/gen.srcjar", module.OutputGlobsZip().Path())
+
+	android.AssertPathsRelativeToTopEquals(t,
+		"genrule with all",
+		[]string{"out/soong/.intermediates/gen/gen/foo", "out/soong/.intermediates/gen/gen/gen.srcjar"},
+		result.ModuleForTests("gen_all", "").Module().(*useSource).srcs)
+	android.AssertPathsRelativeToTopEquals(t,
+		"genrule.tag with output globs zip",
+		[]string{"out/soong/.intermediates/gen/gen/gen.srcjar"},
+		result.ModuleForTests("gen_srcjar", "").Module().(*useSource).srcs)
+}
+
+func TestGenruleOutGlobsOutsideGenDir(t *testing.T) {
+	bp := `
+				genrule {
+					name: "gen",
+					out_globs: ["../*.java"],
+					cmd: "codegen --out $(genDir)",
+				}
+			`
+
+	prepareForGenRuleTest.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`out_globs: "../\*.java" must be relative to \$\(genDir\)`)).
+		RunTestWithBp(t, testGenruleBp()+bp)
+}
+
+func TestGenruleEnv(t *testing.T) {
+	bp := `
+				genrule {
+					name: "gen",
+					tools: ["tool"],
+					env: ["BUILD_FLAVOR"],
+					out: ["out"],
+					cmd: "$(location) --flavor $${BUILD_FLAVOR} > $(out)",
+				}
+			`
+
+	env := android.FixtureMergeEnv(map[string]string{"BUILD_FLAVOR": "userdebug"})
+	t.Run("declared", func(t *testing.T) {
+		result := android.GroupFixturePreparers(prepareForGenRuleTest, env).
+			RunTestWithBp(t, testGenruleBp()+bp)
+		gen := result.ModuleForTests("gen", "")
+		manifest := android.RuleBuilderSboxProtoForTests(t, gen.Output("genrule.sbox.textproto"))
+		android.AssertStringEquals(t, "command",
+			"export BUILD_FLAVOR=userdebug && __SBOX_SANDBOX_DIR__/tools/out/bin/tool --flavor ${BUILD_FLAVOR} > __SBOX_SANDBOX_DIR__/out/out",
+			manifest.Commands[0].GetCommand())
+
+		// The hashes of the tools are recorded without GENRULE_SANDBOX_ENV too.
+		toolsHash := gen.Output("genrule.tools.sha256")
+		android.AssertStringListContains(t, "generator inputs",
+			gen.Output("out").Implicits.Strings(), toolsHash.Output.String())
+	})
+
+	t.Run("sandboxed", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForGenRuleTest,
+			env,
+			android.FixtureMergeEnv(map[string]string{"GENRULE_SANDBOX_ENV": "true"}),
+		).RunTestWithBp(t, testGenruleBp()+bp)
+		gen := result.ModuleForTests("gen", "")
+		manifest := android.RuleBuilderSboxProtoForTests(t, gen.Output("genrule.sbox.textproto"))
+		android.AssertStringEquals(t, "command",
+			`env -i PATH="$PATH" BUILD_FLAVOR=userdebug bash -c '__SBOX_SANDBOX_DIR__/tools/out/bin/tool --flavor ${BUILD_FLAVOR} > __SBOX_SANDBOX_DIR__/out/out'`,
+			manifest.Commands[0].GetCommand())
+
+		toolsHash := gen.Output("genrule.tools.sha256")
+		android.AssertIntEquals(t, "tools hash inputs", 1, len(toolsHash.Implicits))
+		android.AssertStringEquals(t, "tools hash input", "tool", toolsHash.Implicits[0].Base())
+		android.AssertStringDoesContain(t, "tools hash command", toolsHash.RuleParams.Command,
+			"sha256sum "+toolsHash.Implicits[0].String())
+		android.AssertStringListContains(t, "generator inputs",
+			gen.Output("out").Implicits.Strings(), toolsHash.Output.String())
+	})
+
+	t.Run("undeclared", func(t *testing.T) {
+		android.GroupFixturePreparers(
+			prepareForGenRuleTest,
+			android.FixtureMergeEnv(map[string]string{"GENRULE_SANDBOX_ENV": "true"}),
+		).
+			ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+				`cmd: reads the environment variables HOME, which are not declared in env`)).
+			RunTestWithBp(t, testGenruleBp()+`
+				genrule {
+					name: "gen",
+					out: ["out"],
+					cmd: "for f in a b; do echo $$f $${HOME}; done; awk '{print $$NF}' < /dev/null > $(out)",
+				}
+			`)
+	})
+}
+
 func TestPrebuiltTool(t *testing.T) {
 	testcases := []struct {
 		name             string
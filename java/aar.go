@@ -48,6 +48,7 @@ func RegisterAARBuildComponents(ctx android.RegistrationContext) {
 	ctx.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
 		ctx.TopDown("propagate_rro_enforcement", propagateRROEnforcementMutator).Parallel()
 	})
+	ctx.RegisterSingletonType("transitive_r_classes", transitiveRClassesSingletonFactory)
 }
 
 //
@@ -56,6 +57,13 @@ func RegisterAARBuildComponents(ctx android.RegistrationContext) {
 
 type androidLibraryProperties struct {
 	BuildAAR bool `blueprint:"mutated"`
+
+	// If true, the R class of this library only has fields for the resources of this library and
+	// not for the resources of its static_libs, which must be referenced through their own R
+	// classes. References from the resources of this library to the resources of its static_libs
+	// are verified when the app is linked. Changes to the resources of the static_libs then don't
+	// change the R class, which avoids recompiling this library. Defaults to false.
+	Non_transitive_r_class *bool
 }
 
 type aaptProperties struct {
@@ -109,6 +117,7 @@ type aapt struct {
 	useEmbeddedDex          bool
 	usesNonSdkApis          bool
 	hasNoCode               bool
+	nonTransitiveRClass     bool
 	LoggingParent           string
 	resourceFiles           android.Paths
 
@@ -340,9 +349,17 @@ func (a *aapt) buildActions(ctx android.ModuleContext, sdkContext android.SdkCon
 
 	var compiledRes, compiledOverlay android.Paths
 
-	compiledOverlay = append(compiledOverlay, transitiveStaticLibs...)
+	overlayStaticLibs := transitiveStaticLibs
+	if a.nonTransitiveRClass {
+		// Only link the resources of this module so that the R class doesn't have fields for the
+		// resources of the static libraries, without verifying the references to them.
+		overlayStaticLibs = nil
+		linkFlags = append(linkFlags, "--merge-only")
+	}
 
-	if len(transitiveStaticLibs) > 0 {
+	compiledOverlay = append(compiledOverlay, overlayStaticLibs...)
+
+	if len(overlayStaticLibs) > 0 {
 		// If we are using static android libraries, every source file becomes an overlay.
 		// This is to emulate old AAPT behavior which simulated library support.
 		for _, compiledResDir := range compiledResDirs {
@@ -530,6 +547,7 @@ func (a *AndroidLibrary) DepsMutator(ctx android.BottomUpMutatorContext) {
 
 func (a *AndroidLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	a.aapt.isLibrary = true
+	a.aapt.nonTransitiveRClass = Bool(a.androidLibraryProperties.Non_transitive_r_class)
 	a.classLoaderContexts = a.usesLibrary.classLoaderContextForUsesLibDeps(ctx)
 	a.aapt.buildActions(ctx, android.SdkContext(a), a.classLoaderContexts, nil)
 
@@ -556,6 +574,7 @@ func (a *AndroidLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext)
 	if a.androidLibraryProperties.BuildAAR {
 		BuildAAR(ctx, a.aarFile, a.outputFile, a.manifestPath, a.rTxt, res)
 		ctx.CheckbuildFile(a.aarFile)
+		android.CheckReproducibleOutput(ctx, android.ReproducibleZip, a.aarFile, "")
 	}
 
 	a.exportedProguardFlagFiles = append(a.exportedProguardFlagFiles,
@@ -594,6 +613,44 @@ func AndroidLibraryFactory() android.Module {
 	return module
 }
 
+// The transitive_r_classes singleton writes $OUT_DIR/soong/transitive_r_classes.txt, which lists
+// the android_library modules that haven't set non_transitive_r_class and whose R class therefore
+// has fields for the resources of their static android libraries, with the number of those
+// libraries, to track the migration to non-transitive R classes.
+func transitiveRClassesSingletonFactory() android.Singleton {
+	return &transitiveRClassesSingleton{}
+}
+
+type transitiveRClassesSingleton struct {
+	reportFile android.WritablePath
+}
+
+func (s *transitiveRClassesSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var lines []string
+	ctx.VisitAllModules(func(m android.Module) {
+		lib, ok := m.(*AndroidLibrary)
+		if !ok || !lib.Enabled() || lib.aapt.nonTransitiveRClass {
+			return
+		}
+		if n := len(lib.exportedStaticPackages); n > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %d static android libraries", ctx.ModuleName(m), n))
+		}
+	})
+	lines = android.SortedUniqueStrings(lines)
+
+	s.reportFile = android.PathForOutput(ctx, "transitive_r_classes.txt")
+	android.WriteFileRule(ctx, s.reportFile, strings.Join(lines, "\n"))
+	ctx.Phony("transitive-r-classes", s.reportFile)
+}
+
+func (s *transitiveRClassesSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if s.reportFile != nil {
+		ctx.DistForGoal("transitive-r-classes", s.reportFile)
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*transitiveRClassesSingleton)(nil)
+
 //
 // AAR (android library) prebuilts
 //
@@ -106,6 +106,11 @@ type appProperties struct {
 	// Prefer using other specific properties if build behaviour must be changed; avoid using this
 	// flag for anything but neverallow rules (unless the behaviour change is invisible to owners).
 	Updatable *bool
+
+	// Path to a baseline of the permissions and the sharedUserId the app declared in the
+	// previous release. If set, the build fails when the manifest requests a permission or a
+	// sharedUserId that is not in the baseline.
+	Permissions_baseline *string `android:"path"`
 }
 
 // android_app properties that can be overridden by override_android_app
@@ -620,6 +625,10 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 		apkDeps = append(apkDeps, manifestCheckFile)
 	}
 
+	if baseline := a.appProperties.Permissions_baseline; baseline != nil {
+		apkDeps = append(apkDeps, a.checkPermissionsBaseline(ctx, android.PathForModuleSrc(ctx, *baseline)))
+	}
+
 	a.proguardBuildActions(ctx)
 
 	a.linter.mergedManifest = a.aapt.mergedManifestFile
@@ -669,6 +678,7 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 
 	CreateAndSignAppPackage(ctx, packageFile, a.exportPackage, jniJarFile, dexJarFile, certificates, apkDeps, v4SignatureFile, lineageFile, rotationMinSdkVersion)
 	a.outputFile = packageFile
+	android.CheckReproducibleOutput(ctx, android.ReproducibleZip, packageFile, "")
 	if v4SigningRequested {
 		a.extraOutputFiles = append(a.extraOutputFiles, v4SignatureFile)
 	}
@@ -707,6 +717,7 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 	bundleFile := android.PathForModuleOut(ctx, "base.zip")
 	BuildBundleModule(ctx, bundleFile, a.exportPackage, jniJarFile, dexJarFile)
 	a.bundleFile = bundleFile
+	android.CheckReproducibleOutput(ctx, android.ReproducibleZip, bundleFile, "")
 
 	apexInfo := ctx.Provider(android.ApexInfoProvider).(android.ApexInfo)
 
@@ -802,7 +813,7 @@ func (a *AndroidApp) WalkPayloadDeps(ctx android.ModuleContext, do android.Paylo
 	ctx.WalkDeps(func(child, parent android.Module) bool {
 		isExternal := !a.DepIsInSameApex(ctx, child)
 		if am, ok := child.(android.ApexModule); ok {
-			if !do(ctx, parent, am, isExternal) {
+			if !do(ctx, parent, am, isExternal, ctx.OtherModuleDependencyTag(child), ctx.GetWalkPath()) {
 				return false
 			}
 		}
@@ -816,7 +827,9 @@ func (a *AndroidApp) buildAppDependencyInfo(ctx android.ModuleContext) {
 	}
 
 	depsInfo := android.DepNameToDepInfoMap{}
-	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool) bool {
+	a.WalkPayloadDeps(ctx, func(ctx android.ModuleContext, from blueprint.Module, to android.ApexModule, externalDep bool,
+		tag blueprint.DependencyTag, path []android.Module) bool {
+
 		depName := to.Name()
 
 		// Skip dependencies that are only available to APEXes; they are developed with updatability
@@ -826,8 +839,7 @@ func (a *AndroidApp) buildAppDependencyInfo(ctx android.ModuleContext) {
 		}
 
 		if info, exist := depsInfo[depName]; exist {
-			info.From = append(info.From, from.Name())
-			info.IsExternal = info.IsExternal && externalDep
+			info.AddDependent(from, to, externalDep)
 			depsInfo[depName] = info
 		} else {
 			toMinSdkVersion := "(no version)"
@@ -844,12 +856,7 @@ func (a *AndroidApp) buildAppDependencyInfo(ctx android.ModuleContext) {
 					toMinSdkVersion = v
 				}
 			}
-			depsInfo[depName] = android.ApexModuleDepInfo{
-				To:            depName,
-				From:          []string{from.Name()},
-				IsExternal:    externalDep,
-				MinSdkVersion: toMinSdkVersion,
-			}
+			depsInfo[depName] = android.NewApexModuleDepInfo(from, to, externalDep, toMinSdkVersion)
 		}
 		return true
 	})
@@ -963,6 +970,11 @@ func (a *AndroidTest) InstallInTestcases() bool {
 	return true
 }
 
+// Overrides android.ApexModuleBase.IsTestModule
+func (a *AndroidTest) IsTestModule() bool {
+	return true
+}
+
 func (a *AndroidTest) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	var configs []tradefed.Config
 	if a.appTestProperties.Instrumentation_target_package != nil {
@@ -1054,6 +1066,7 @@ func AndroidTestFactory() android.Module {
 		&module.overridableAppProperties,
 		&module.testProperties)
 
+	android.InitApexModule(module)
 	android.InitAndroidMultiTargetsArchModule(module, android.DeviceSupported, android.MultilibCommon)
 	android.InitDefaultableModule(module)
 	android.InitOverridableModule(module, &module.overridableAppProperties.Overrides)
@@ -1353,6 +1366,21 @@ func (u *usesLibrary) freezeEnforceUsesLibraries() {
 	u.usesLibraryProperties.Enforce_uses_libs = &enforce
 }
 
+// checkPermissionsBaseline checks the permissions and the sharedUserId in the merged manifest
+// against a baseline from the previous release, and returns the path to the declarations found in
+// the manifest.
+func (a *AndroidApp) checkPermissionsBaseline(ctx android.ModuleContext, baseline android.Path) android.Path {
+	outputFile := android.PathForModuleOut(ctx, "permissions", "permissions.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("check_app_permissions").
+		FlagWithArg("--module ", ctx.ModuleName()).
+		FlagWithInput("--baseline ", baseline).
+		FlagWithOutput("-o ", outputFile).
+		Input(a.mergedManifestFile)
+	rule.Build("check_permissions_baseline", "check permissions baseline")
+	return outputFile
+}
+
 // verifyUsesLibraries checks the <uses-library> tags in the manifest against the ones specified
 // in the `uses_libs`/`optional_uses_libs` properties. The input can be either an XML manifest, or
 // an APK with the manifest embedded in it (manifest_check will know which one it is by the file
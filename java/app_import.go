@@ -341,10 +341,14 @@ func (a *AndroidAppImport) generateAndroidBuildActions(ctx android.ModuleContext
 
 		SignAppPackage(ctx, signed, jnisUncompressed, certificates, nil, lineageFile, rotationMinSdkVersion)
 		a.outputFile = signed
+		// The entries of prebuilt apks keep their own timestamps, so the apk is only compared with the
+		// reference build.
+		android.CheckReproducibleOutput(ctx, android.ReproducibleFile, a.outputFile, "")
 	} else {
 		alignedApk := android.PathForModuleOut(ctx, "zip-aligned", apkFilename)
 		TransformZipAlign(ctx, alignedApk, jnisUncompressed)
 		a.outputFile = alignedApk
+		android.CheckReproducibleOutput(ctx, android.ReproducibleFile, a.outputFile, "")
 		a.certificate = PresignedCertificate
 	}
 
@@ -126,6 +126,41 @@ func TestAppSplits(t *testing.T) {
 	android.AssertPathsRelativeToTopEquals(t, `OutputFiles("")`, expectedOutputs, outputFiles)
 }
 
+func TestAppPermissionsBaseline(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureAddTextFile("permissions_baseline.txt", "uses-permission android.permission.INTERNET\n"),
+	).RunTestWithBp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			permissions_baseline: "permissions_baseline.txt",
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	check := foo.Output("permissions/permissions.txt")
+	android.AssertStringDoesContain(t, "check should use the baseline",
+		check.RuleParams.Command, "--baseline permissions_baseline.txt")
+	android.AssertStringDoesContain(t, "check should read the merged manifest",
+		check.RuleParams.Command, "AndroidManifest.xml")
+	android.AssertStringListContains(t, "apk should depend on the check",
+		foo.Output("foo-unsigned.apk").Implicits.RelativeToTop().Strings(),
+		"out/soong/.intermediates/foo/android_common/permissions/permissions.txt")
+
+	bar := result.ModuleForTests("bar", "android_common")
+	if bar.MaybeOutput("permissions/permissions.txt").Rule != nil {
+		t.Errorf("the check should be opt-in")
+	}
+}
+
 func TestPlatformAPIs(t *testing.T) {
 	testJava(t, `
 		android_app {
@@ -672,6 +707,65 @@ func TestLibraryAssets(t *testing.T) {
 	}
 }
 
+func TestLibraryNonTransitiveRClass(t *testing.T) {
+	bp := `
+			android_app {
+				name: "foo",
+				sdk_version: "current",
+				static_libs: ["lib1", "lib2"],
+			}
+
+			android_library {
+				name: "lib1",
+				sdk_version: "current",
+				static_libs: ["lib3"],
+				non_transitive_r_class: true,
+			}
+
+			android_library {
+				name: "lib2",
+				sdk_version: "current",
+				static_libs: ["lib3"],
+			}
+
+			android_library {
+				name: "lib3",
+				sdk_version: "current",
+			}
+		`
+
+	ctx := testApp(t, bp)
+
+	// lib1 only links its own resources.
+	lib1 := ctx.ModuleForTests("lib1", "android_common")
+	android.AssertStringDoesContain(t, "lib1 aapt2 link flags",
+		lib1.Output("package-res.apk").Args["flags"], "--merge-only")
+	if overlayList := lib1.MaybeOutput("aapt2/overlay.list"); overlayList.Rule != nil {
+		t.Errorf("expected no overlays for lib1, got %q", overlayList.Inputs)
+	}
+
+	// lib2 still links the resources of lib3 as overlays.
+	lib2 := ctx.ModuleForTests("lib2", "android_common")
+	android.AssertStringDoesNotContain(t, "lib2 aapt2 link flags",
+		lib2.Output("package-res.apk").Args["flags"], "--merge-only")
+	android.AssertStringListContains(t, "lib2 overlays",
+		android.PathsRelativeToTop(lib2.Output("aapt2/overlay.list").Inputs),
+		"out/soong/.intermediates/lib3/android_common/package-res.apk")
+
+	// The app links the resources of all the static libraries.
+	foo := ctx.ModuleForTests("foo", "android_common")
+	fooOverlays := android.PathsRelativeToTop(foo.Output("aapt2/overlay.list").Inputs)
+	for _, lib := range []string{"lib1", "lib2", "lib3"} {
+		android.AssertStringListContains(t, "foo overlays", fooOverlays,
+			"out/soong/.intermediates/"+lib+"/android_common/package-res.apk")
+	}
+
+	report := ctx.SingletonForTests("transitive_r_classes").Output("transitive_r_classes.txt")
+	android.AssertStringEquals(t, "transitive R classes report",
+		"lib2: 1 static android libraries",
+		android.ContentFromFileRuleForTests(t, report))
+}
+
 func TestAppJavaResources(t *testing.T) {
 	bp := `
 			android_app {
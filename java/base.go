@@ -114,6 +114,11 @@ type CommonProperties struct {
 	// Add host jdk tools.jar to bootclasspath
 	Use_tools_jar *bool
 
+	// Sources that are compiled for a newer Java version and packaged under
+	// META-INF/versions/<version>/ to produce a multi-release jar, allowing a host tool to use
+	// newer JDK APIs when it is run on a JDK that provides them.  Only supported for host modules.
+	Multi_release []MultiReleaseProperties
+
 	Openjdk9 struct {
 		// List of source files that should only be used when passing -source 1.9 or higher
 		Srcs []string `android:"path"`
@@ -174,6 +179,10 @@ type CommonProperties struct {
 	// into the library.
 	Supports_static_instrumentation bool `blueprint:"mutated"`
 
+	// The static libraries that were added because the module is statically instrumented for
+	// code coverage.
+	Coverage_static_libs []string `blueprint:"mutated"`
+
 	// List of files to include in the META-INF/services folder of the resulting jar.
 	Services []string `android:"path,arch_variant"`
 
@@ -184,6 +193,16 @@ type CommonProperties struct {
 	Hiddenapi_additional_annotations []string
 }
 
+// MultiReleaseProperties describes the sources of one version of a multi-release jar.
+type MultiReleaseProperties struct {
+	// The Java version that the sources are compiled for.  Must be newer than the java_version of
+	// the module.
+	Java_version *string
+
+	// List of source files that are only used when running on the given Java version or newer.
+	Srcs []string `android:"path"`
+}
+
 // Properties that are specific to device modules. Host module factories should not add these when
 // constructing a new module.
 type DeviceProperties struct {
@@ -409,6 +428,17 @@ type Module struct {
 	// jar file containing only resources including from static library dependencies
 	resourceJar android.Path
 
+	// report listing the libs and static_libs that aren't referenced by any class of the module
+	unusedDepsReport android.Path
+
+	// srcjars compiled by the module merged together, when there is more than one
+	mergedSrcJar android.Path
+
+	// files that soong_javac_wrapper writes the javac and errorprone diagnostics to, and the
+	// outputs of the rules that write them
+	javacDiagnostics     android.Paths
+	javacDiagnosticsDeps android.Paths
+
 	// args and dependencies to package source files into a srcjar
 	srcJarArgs []string
 	srcJarDeps android.Paths
@@ -571,6 +601,16 @@ func (j *Module) OutputFiles(tag string) (android.Paths, error) {
 		return android.Paths{j.outputFile}, nil
 	case ".jar":
 		return android.Paths{j.implementationAndResourcesJar}, nil
+	case ".unused_deps":
+		if j.unusedDepsReport != nil {
+			return android.Paths{j.unusedDepsReport}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
+	case ".srcjar":
+		if j.mergedSrcJar != nil {
+			return android.Paths{j.mergedSrcJar}, nil
+		}
+		return nil, fmt.Errorf("%q was requested, but no output file was found.", tag)
 	case ".proguard_map":
 		if j.dexer.proguardDictionary.Valid() {
 			return android.Paths{j.dexer.proguardDictionary.Path()}, nil
@@ -630,6 +670,13 @@ func (j *Module) shouldInstrumentInApex(ctx android.BaseModuleContext) bool {
 	return false
 }
 
+// Implements android.CoverageDependenciesModule
+func (j *Module) CoverageDependencies() []string {
+	return j.properties.Coverage_static_libs
+}
+
+var _ android.CoverageDependenciesModule = (*Module)(nil)
+
 func (j *Module) SdkVersion(ctx android.EarlyModuleContext) android.SdkSpec {
 	return android.SdkSpecFrom(ctx, String(j.deviceProperties.Sdk_version))
 }
@@ -762,7 +809,8 @@ func (j *Module) deps(ctx android.BottomUpMutatorContext) {
 			j.properties.Instrument = true
 		}
 	} else if j.shouldInstrumentStatic(ctx) {
-		ctx.AddVariationDependencies(nil, staticLibTag, "jacocoagent")
+		j.properties.Coverage_static_libs = []string{"jacocoagent"}
+		ctx.AddVariationDependencies(nil, staticLibTag, j.properties.Coverage_static_libs...)
 	}
 
 	if j.useCompose() {
@@ -912,12 +960,36 @@ func (j *Module) collectBuilderFlags(ctx android.ModuleContext, deps deps) javaB
 	// systemModules
 	flags.systemModules = deps.systemModules
 
+	// Host modules that compile against the JDK's own system modules use --release so that
+	// they cannot accidentally use APIs that are newer than the requested Java version, and keep
+	// building when the default JDK moves.  Device modules always pass --system, which javac
+	// rejects in combination with --release, as it does flags that access the internals of
+	// system modules.
+	if ctx.Host() && flags.javaVersion.usesJavaModules() && flags.systemModules == nil &&
+		len(flags.bootClasspath) == 0 && j.properties.Patch_module == nil &&
+		!javacFlagsAccessSystemModuleInternals(j.properties.Javacflags) &&
+		!javacFlagsAccessSystemModuleInternals(j.properties.Openjdk9.Javacflags) {
+		flags.javaRelease = true
+	}
+
 	// aidl flags.
 	flags.aidlFlags, flags.aidlDeps = j.aidlFlags(ctx, deps.aidlPreprocess, deps.aidlIncludeDirs)
 
 	return flags
 }
 
+// javacFlagsAccessSystemModuleInternals returns true if any of the flags exports or opens
+// packages of a system module, which javac does not allow when --release is used.
+func javacFlagsAccessSystemModuleInternals(javacFlags []string) bool {
+	for _, flag := range javacFlags {
+		if strings.HasPrefix(flag, "--add-exports") || strings.HasPrefix(flag, "--add-opens") ||
+			strings.HasPrefix(flag, "--patch-module") || strings.HasPrefix(flag, "--add-reads") {
+			return true
+		}
+	}
+	return false
+}
+
 func (j *Module) collectJavacFlags(
 	ctx android.ModuleContext, flags javaBuilderFlags, srcFiles android.Paths) javaBuilderFlags {
 	// javac flags.
@@ -1036,6 +1108,10 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 	}
 	srcFiles = srcFiles.FilterOutByExt(".srcjar")
 
+	if len(srcJars) > 1 {
+		j.mergedSrcJar = mergeSrcJars(ctx, srcJars, j.srcJarProducers(ctx, srcJars, aaptSrcJar))
+	}
+
 	if j.properties.Jarjar_rules != nil {
 		j.expandJarjarRules = android.PathForModuleSrc(ctx, *j.properties.Jarjar_rules)
 	}
@@ -1171,6 +1247,12 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 	}
 	if len(uniqueSrcFiles) > 0 || len(srcJars) > 0 {
 		var extraJarDeps android.Paths
+		if j.mergedSrcJar != nil {
+			// Check that no class is provided by more than one srcjar before javac runs, so that the
+			// error naming the modules that produce them is reported instead of the javac one. The
+			// merged srcjar is order-only as javac doesn't read it.
+			flags.orderOnlyDeps = append(flags.orderOnlyDeps, j.mergedSrcJar)
+		}
 		if Bool(j.properties.Errorprone.Enabled) {
 			// If error-prone is enabled, enable errorprone flags on the regular
 			// build.
@@ -1185,8 +1267,9 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 			errorproneFlags := enableErrorproneFlags(flags)
 			errorprone := android.PathForModuleOut(ctx, "errorprone", jarName)
 
-			transformJavaToClasses(ctx, errorprone, -1, uniqueSrcFiles, srcJars, errorproneFlags, nil,
+			diagnostics := transformJavaToClasses(ctx, errorprone, -1, uniqueSrcFiles, srcJars, errorproneFlags, nil,
 				"errorprone", "errorprone")
+			j.addJavacDiagnostics(diagnostics, errorprone)
 
 			extraJarDeps = append(extraJarDeps, errorprone)
 		}
@@ -1219,6 +1302,14 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 		}
 	}
 
+	if len(j.properties.Multi_release) > 0 {
+		multiReleaseJars := j.compileMultiReleaseClasses(ctx, jarName, flags, jars)
+		if ctx.Failed() {
+			return
+		}
+		jars = append(jars, multiReleaseJars...)
+	}
+
 	j.srcJarArgs, j.srcJarDeps = resourcePathsToJarArgs(srcFiles), srcFiles
 
 	var includeSrcJar android.WritablePath
@@ -1271,6 +1362,11 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 		j.resourceJar = resourceJars[0]
 	}
 
+	if len(jars) > 0 && len(deps.declaredLibs) > 0 {
+		j.unusedDepsReport = buildUnusedDepsReport(ctx, jars, deps.declaredLibs)
+	}
+
+	localJars := android.CopyOfPaths(jars)
 	if len(deps.staticJars) > 0 {
 		jars = append(jars, deps.staticJars...)
 	}
@@ -1280,6 +1376,10 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 		manifest = android.OptionalPathForPath(android.PathForModuleSrc(ctx, *j.properties.Manifest))
 	}
 
+	if len(j.properties.Multi_release) > 0 {
+		manifest = android.OptionalPathForPath(multiReleaseManifest(ctx, manifest))
+	}
+
 	services := android.PathsForModuleSrc(ctx, j.properties.Services)
 	if len(services) > 0 {
 		servicesJar := android.PathForModuleOut(ctx, "services", jarName)
@@ -1406,6 +1506,11 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 		outputFile = j.instrument(ctx, flags, outputFile, jarName, specs)
 	}
 
+	if j.expandJarjarRules == nil && !j.shouldInstrument(ctx) {
+		// The classes jar is a plain combination of the class jars, so they can be dexed separately.
+		j.dexer.setIncrementalDexInputs(localJars, deps.staticJars, deps.staticHeaderJars)
+	}
+
 	// merge implementation jar with resources if necessary
 	implementationAndResourcesJar := outputFile
 	if j.resourceJar != nil {
@@ -1559,7 +1664,8 @@ func (j *Module) compileJavaClasses(ctx android.ModuleContext, jarName string, i
 	}
 
 	classes := android.PathForModuleOut(ctx, "javac", jarName).OutputPath
-	TransformJavaToClasses(ctx, classes, idx, srcFiles, srcJars, flags, extraJarDeps)
+	diagnostics := TransformJavaToClasses(ctx, classes, idx, srcFiles, srcJars, flags, extraJarDeps)
+	j.addJavacDiagnostics(diagnostics, classes)
 
 	if ctx.Config().EmitXrefRules() {
 		extractionFile := android.PathForModuleOut(ctx, kzipName)
@@ -1595,6 +1701,71 @@ func CheckKotlincFlags(ctx android.ModuleContext, flags []string) {
 	}
 }
 
+// compileMultiReleaseClasses compiles the sources of each multi_release entry against the
+// classes of the main sources and returns jars containing the resulting classes relocated under
+// META-INF/versions/<version>/.
+func (j *Module) compileMultiReleaseClasses(ctx android.ModuleContext, jarName string,
+	flags javaBuilderFlags, mainJars android.Paths) android.Paths {
+
+	if !ctx.Host() {
+		ctx.PropertyErrorf("multi_release", "multi-release jars are only supported for host modules")
+		return nil
+	}
+
+	var ret android.Paths
+	seen := make(map[javaVersion]bool)
+	for i, mr := range j.properties.Multi_release {
+		property := fmt.Sprintf("multi_release[%d].java_version", i)
+		if mr.Java_version == nil {
+			ctx.PropertyErrorf(property, "must be set")
+			continue
+		}
+		version := normalizeJavaVersion(ctx, *mr.Java_version)
+		if version == JAVA_VERSION_UNSUPPORTED {
+			continue
+		}
+		if version <= flags.javaVersion {
+			ctx.PropertyErrorf(property, "%s must be newer than the java_version of the module (%s)",
+				version.String(), flags.javaVersion.String())
+			continue
+		}
+		if seen[version] {
+			ctx.PropertyErrorf(property, "duplicate multi-release version %s", version.String())
+			continue
+		}
+		seen[version] = true
+
+		srcFiles := android.PathsForModuleSrc(ctx, mr.Srcs)
+		if len(srcFiles) == 0 {
+			continue
+		}
+
+		mrFlags := flags
+		mrFlags.javaVersion = version
+		mrFlags.javaRelease = flags.javaRelease
+		mrFlags.classpath = append(classpath(mainJars), flags.classpath...)
+
+		versionDir := "multi_release_" + version.releaseString()
+		classes := android.PathForModuleOut(ctx, versionDir, "classes", jarName)
+		diagnostics := transformJavaToClasses(ctx, classes, -1, srcFiles, nil, mrFlags, nil, versionDir,
+			"javac "+version.releaseString())
+		j.addJavacDiagnostics(diagnostics, classes)
+
+		versionedClasses := android.PathForModuleOut(ctx, versionDir, jarName)
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        multiReleaseRelocate,
+			Description: "multi-release " + version.releaseString(),
+			Input:       classes,
+			Output:      versionedClasses,
+			Args: map[string]string{
+				"version": version.releaseString(),
+			},
+		})
+		ret = append(ret, versionedClasses)
+	}
+	return ret
+}
+
 func (j *Module) compileJavaHeader(ctx android.ModuleContext, srcFiles, srcJars android.Paths,
 	deps deps, flags javaBuilderFlags, jarName string,
 	extraJars android.Paths) (headerJar, jarjarAndDepsHeaderJar android.Path) {
@@ -1897,6 +2068,7 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 				depHeaderJars := dep.SdkHeaderJars(ctx, j.SdkVersion(ctx))
 				deps.classpath = append(deps.classpath, depHeaderJars...)
 				deps.dexClasspath = append(deps.dexClasspath, depHeaderJars...)
+				deps.declaredLibs = append(deps.declaredLibs, declaredJavaDep{otherName, false, depHeaderJars})
 			case staticLibTag:
 				ctx.ModuleErrorf("dependency on java_sdk_library %q can only be in libs", otherName)
 			}
@@ -1919,11 +2091,15 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 				deps.aidlIncludeDirs = append(deps.aidlIncludeDirs, dep.AidlIncludeDirs...)
 				addPlugins(&deps, dep.ExportedPlugins, dep.ExportedPluginClasses...)
 				deps.disableTurbine = deps.disableTurbine || dep.ExportedPluginDisableTurbine
+				if tag == libTag {
+					deps.declaredLibs = append(deps.declaredLibs, declaredJavaDep{otherName, false, dep.HeaderJars})
+				}
 			case java9LibTag:
 				deps.java9Classpath = append(deps.java9Classpath, dep.HeaderJars...)
 			case staticLibTag:
 				deps.classpath = append(deps.classpath, dep.HeaderJars...)
 				deps.staticJars = append(deps.staticJars, dep.ImplementationJars...)
+				deps.declaredLibs = append(deps.declaredLibs, declaredJavaDep{otherName, true, dep.HeaderJars})
 				deps.staticHeaderJars = append(deps.staticHeaderJars, dep.HeaderJars...)
 				deps.staticResourceJars = append(deps.staticResourceJars, dep.ResourceJars...)
 				deps.aidlIncludeDirs = append(deps.aidlIncludeDirs, dep.AidlIncludeDirs...)
@@ -122,6 +122,10 @@ type bootclasspathFragmentProperties struct {
 	BootclasspathFragmentCoverageAffectedProperties
 	Coverage BootclasspathFragmentCoverageAffectedProperties
 
+	// True if the coverage specific properties have been appended to the other properties, i.e.
+	// if code coverage is enabled for the framework.
+	Coverage_applied bool `blueprint:"mutated"`
+
 	// Hidden API related properties.
 	Hidden_api HiddenAPIFlagFileProperties
 
@@ -290,6 +294,8 @@ func bootclasspathFragmentFactory() android.Module {
 				ctx.PropertyErrorf("coverage", "error trying to append hidden api coverage specific properties: %s", err)
 				return
 			}
+
+			m.properties.Coverage_applied = true
 		}
 
 		// Initialize the contents property from the image_name.
@@ -483,6 +489,18 @@ func (b *BootclasspathFragmentModule) ShouldSupportSdkVersion(ctx android.BaseMo
 	return nil
 }
 
+// Implements android.CoverageDependenciesModule
+func (b *BootclasspathFragmentModule) CoverageDependencies() []string {
+	// The coverage specific contents, e.g. jacocoagent in the ART bootclasspath_fragment, are only
+	// in the contents when code coverage is enabled for the framework.
+	if b.properties.Coverage_applied {
+		return b.properties.Coverage.Contents
+	}
+	return nil
+}
+
+var _ android.CoverageDependenciesModule = (*BootclasspathFragmentModule)(nil)
+
 // ComponentDepsMutator adds dependencies onto modules before any prebuilt modules without a
 // corresponding source module are renamed. This means that adding a dependency using a name without
 // a prebuilt_ prefix will always resolve to a source module and when using a name with that prefix
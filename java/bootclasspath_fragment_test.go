@@ -160,6 +160,11 @@ func TestBootclasspathFragment_Coverage(t *testing.T) {
 		android.AssertArrayString(t, "contents property", expected, module.properties.Contents)
 	}
 
+	checkCoverageDependencies := func(t *testing.T, result *android.TestResult, expected ...string) {
+		module := result.Module("myfragment", "android_common").(*BootclasspathFragmentModule)
+		android.AssertArrayString(t, "coverage dependencies", expected, module.CoverageDependencies())
+	}
+
 	preparer := android.GroupFixturePreparers(
 		prepareForTestWithBootclasspathFragment,
 		PrepareForTestWithJavaSdkLibraryFiles,
@@ -171,6 +176,7 @@ func TestBootclasspathFragment_Coverage(t *testing.T) {
 	t.Run("without coverage", func(t *testing.T) {
 		result := preparer.RunTest(t)
 		checkContents(t, result, "mybootlib")
+		checkCoverageDependencies(t, result)
 	})
 
 	t.Run("with coverage", func(t *testing.T) {
@@ -179,6 +185,7 @@ func TestBootclasspathFragment_Coverage(t *testing.T) {
 			preparer,
 		).RunTest(t)
 		checkContents(t, result, "mybootlib", "coveragelib")
+		checkCoverageDependencies(t, result, "coveragelib")
 	})
 }
 
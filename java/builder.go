@@ -42,13 +42,13 @@ var (
 	// TODO(b/143658984): goma can't handle the --system argument to javac.
 	javac, javacRE = pctx.MultiCommandRemoteStaticRules("javac",
 		blueprint.RuleParams{
-			Command: `rm -rf "$outDir" "$annoDir" "$srcJarDir" "$out" && mkdir -p "$outDir" "$annoDir" "$srcJarDir" && ` +
+			Command: `rm -rf "$outDir" "$annoDir" "$srcJarDir" "$out" $diagnosticsFile && mkdir -p "$outDir" "$annoDir" "$srcJarDir" && ` +
 				`${config.ZipSyncCmd} -d $srcJarDir -l $srcJarDir/list -f "*.java" $srcJars && ` +
 				`(if [ -s $srcJarDir/list ] || [ -s $out.rsp ] ; then ` +
-				`${config.SoongJavacWrapper} $javaTemplate${config.JavacCmd} ` +
+				`${config.SoongJavacWrapper} $javacWrapperFlags $javaTemplate${config.JavacCmd} ` +
 				`${config.JavacHeapFlags} ${config.JavacVmFlags} ${config.CommonJdkFlags} ` +
 				`$processorpath $processor $javacFlags $bootClasspath $classpath ` +
-				`-source $javaVersion -target $javaVersion ` +
+				`$javaVersionFlags ` +
 				`-d $outDir -s $annoDir @$out.rsp @$srcJarDir/list ; fi ) && ` +
 				`$zipTemplate${config.SoongZipCmd} -jar -o $out -C $outDir -D $outDir && ` +
 				`rm -rf "$srcJarDir"`,
@@ -74,7 +74,7 @@ var (
 				Platform:     map[string]string{remoteexec.PoolKey: "${config.REJavaPool}"},
 			},
 		}, []string{"javacFlags", "bootClasspath", "classpath", "processorpath", "processor", "srcJars", "srcJarDir",
-			"outDir", "annoDir", "javaVersion"}, nil)
+			"outDir", "annoDir", "javaVersionFlags", "javacWrapperFlags", "diagnosticsFile"}, nil)
 
 	_ = pctx.VariableFunc("kytheCorpus",
 		func(ctx android.PackageVarContext) string { return ctx.Config().XrefCorpusName() })
@@ -101,7 +101,7 @@ var (
 				`-jar ${config.JavaKytheExtractorJar} ` +
 				`${config.JavacHeapFlags} ${config.CommonJdkFlags} ` +
 				`$processorpath $processor $javacFlags $bootClasspath $classpath ` +
-				`-source $javaVersion -target $javaVersion ` +
+				`$javaVersionFlags ` +
 				`-d $outDir -s $annoDir @$out.rsp @$srcJarDir/list)`,
 			CommandDeps: []string{
 				"${config.JavaCmd}",
@@ -114,7 +114,7 @@ var (
 			RspfileContent:   "$in",
 		},
 		"javacFlags", "bootClasspath", "classpath", "processorpath", "processor", "srcJars", "srcJarDir",
-		"outDir", "annoDir", "javaVersion")
+		"outDir", "annoDir", "javaVersionFlags")
 
 	extractMatchingApks = pctx.StaticRule(
 		"extractMatchingApks",
@@ -191,6 +191,21 @@ var (
 		},
 		"jarArgs")
 
+	// Moves the classes of a multi-release jar version under META-INF/versions/<version>/.
+	multiReleaseRelocate = pctx.AndroidStaticRule("multiReleaseRelocate",
+		blueprint.RuleParams{
+			Command:     `${config.Zip2ZipCmd} -i $in -o $out -x META-INF/MANIFEST.MF "**/*:META-INF/versions/$version/"`,
+			CommandDeps: []string{"${config.Zip2ZipCmd}"},
+		},
+		"version")
+
+	// Appends the Multi-Release attribute to the main section of a jar manifest.
+	multiReleaseManifestRule = pctx.AndroidStaticRule("multiReleaseManifest",
+		blueprint.RuleParams{
+			Command: `rm -f $out && (sed -e '/^$$/q' $in | sed -e '/^$$/d'; echo "Multi-Release: true"; ` +
+				`sed -e '1,/^$$/d' $in | sed -e '1s/^/\n/') > $out`,
+		})
+
 	jarjar = pctx.AndroidStaticRule("jarjar",
 		blueprint.RuleParams{
 			Command: "" +
@@ -272,6 +287,15 @@ type javaBuilderFlags struct {
 	aidlDeps      android.Paths
 	javaVersion   javaVersion
 
+	// javaRelease is true when javac should be passed --release instead of -source and -target,
+	// which also restricts the available APIs to those of the requested Java version.  It is only
+	// set for host modules that compile against the JDK's own system modules.
+	javaRelease bool
+
+	// orderOnlyDeps is the list of files that must be built before the javac rules, to check
+	// their inputs, without causing them to rerun when they change.
+	orderOnlyDeps android.Paths
+
 	errorProneExtraJavacFlags string
 	errorProneProcessorPath   classpath
 
@@ -282,8 +306,19 @@ type javaBuilderFlags struct {
 	proto android.ProtoFlags
 }
 
+// javaVersionFlags returns the javac flags that select the Java language level and target.
+// --release is never combined with a --system or -bootclasspath flag, which javac rejects.
+func (flags javaBuilderFlags) javaVersionFlags(bootClasspath string) string {
+	if flags.javaRelease && bootClasspath == "" {
+		return "--release " + flags.javaVersion.releaseString()
+	}
+	return "-source " + flags.javaVersion.String() + " -target " + flags.javaVersion.String()
+}
+
+// TransformJavaToClasses returns the path to the file that javac diagnostics are written to,
+// or nil if the java diagnostics report is not enabled.
 func TransformJavaToClasses(ctx android.ModuleContext, outputFile android.WritablePath, shardIdx int,
-	srcFiles, srcJars android.Paths, flags javaBuilderFlags, deps android.Paths) {
+	srcFiles, srcJars android.Paths, flags javaBuilderFlags, deps android.Paths) android.WritablePath {
 
 	// Compile java sources into .class files
 	desc := "javac"
@@ -291,7 +326,7 @@ func TransformJavaToClasses(ctx android.ModuleContext, outputFile android.Writab
 		desc += strconv.Itoa(shardIdx)
 	}
 
-	transformJavaToClasses(ctx, outputFile, shardIdx, srcFiles, srcJars, flags, deps, "javac", desc)
+	return transformJavaToClasses(ctx, outputFile, shardIdx, srcFiles, srcJars, flags, deps, "javac", desc)
 }
 
 // Emits the rule to generate Xref input file (.kzip file) for the given set of source files and source jars
@@ -341,16 +376,16 @@ func emitXrefRule(ctx android.ModuleContext, xrefFile android.WritablePath, idx
 			Inputs:      srcFiles,
 			Implicits:   deps,
 			Args: map[string]string{
-				"annoDir":       android.PathForModuleOut(ctx, intermediatesDir, "anno").String(),
-				"bootClasspath": bootClasspath,
-				"classpath":     classpath.FormJavaClassPath("-classpath"),
-				"javacFlags":    flags.javacFlags,
-				"javaVersion":   flags.javaVersion.String(),
-				"outDir":        android.PathForModuleOut(ctx, "javac", "classes.xref").String(),
-				"processorpath": flags.processorPath.FormJavaClassPath("-processorpath"),
-				"processor":     processor,
-				"srcJarDir":     android.PathForModuleOut(ctx, intermediatesDir, "srcjars.xref").String(),
-				"srcJars":       strings.Join(srcJars.Strings(), " "),
+				"annoDir":          android.PathForModuleOut(ctx, intermediatesDir, "anno").String(),
+				"bootClasspath":    bootClasspath,
+				"classpath":        classpath.FormJavaClassPath("-classpath"),
+				"javacFlags":       flags.javacFlags,
+				"javaVersionFlags": flags.javaVersionFlags(bootClasspath),
+				"outDir":           android.PathForModuleOut(ctx, "javac", "classes.xref").String(),
+				"processorpath":    flags.processorPath.FormJavaClassPath("-processorpath"),
+				"processor":        processor,
+				"srcJarDir":        android.PathForModuleOut(ctx, intermediatesDir, "srcjars.xref").String(),
+				"srcJars":          strings.Join(srcJars.Strings(), " "),
 			},
 		})
 }
@@ -463,11 +498,13 @@ func TurbineApt(ctx android.ModuleContext, outputSrcJar, outputResJar android.Wr
 // argument specifies which command line to use and desc sets the description of the rule that will
 // be printed at build time.  The stem argument provides the file name of the output jar, and
 // suffix will be appended to various intermediate files and directories to avoid collisions when
-// this function is called twice in the same module directory.
+// this function is called twice in the same module directory.  It returns the path to the file
+// that the diagnostics of the compiler are written to, or nil if the java diagnostics report is
+// not enabled.
 func transformJavaToClasses(ctx android.ModuleContext, outputFile android.WritablePath,
 	shardIdx int, srcFiles, srcJars android.Paths,
 	flags javaBuilderFlags, deps android.Paths,
-	intermediatesDir, desc string) {
+	intermediatesDir, desc string) android.WritablePath {
 
 	deps = append(deps, srcJars...)
 
@@ -501,12 +538,27 @@ func transformJavaToClasses(ctx android.ModuleContext, outputFile android.Writab
 	srcJarDir := "srcjars"
 	outDir := "classes"
 	annoDir := "anno"
+	diagnosticsFile := "diagnostics.txt"
 	if shardIdx >= 0 {
 		shardDir := "shard" + strconv.Itoa(shardIdx)
 		srcJarDir = filepath.Join(shardDir, srcJarDir)
 		outDir = filepath.Join(shardDir, outDir)
 		annoDir = filepath.Join(shardDir, annoDir)
+		diagnosticsFile = filepath.Join(shardDir, diagnosticsFile)
 	}
+
+	// The diagnostics file is written by soong_javac_wrapper as a side effect of compiling, and
+	// is not an output of the rule as javac doesn't run when there are no sources to compile. It is
+	// removed before compiling, so that the diagnostics of a previous compile are never reported.
+	var diagnostics android.WritablePath
+	javacWrapperFlags := ""
+	diagnosticsFileArg := ""
+	if javaDiagnosticsReportEnabled(ctx.Config()) {
+		diagnostics = android.PathForModuleOut(ctx, intermediatesDir, diagnosticsFile)
+		javacWrapperFlags = "--diagnostics_file=" + diagnostics.String()
+		diagnosticsFileArg = diagnostics.String()
+	}
+
 	rule := javac
 	if ctx.Config().UseRBE() && ctx.Config().IsEnvTrue("RBE_JAVAC") {
 		rule = javacRE
@@ -517,19 +569,24 @@ func transformJavaToClasses(ctx android.ModuleContext, outputFile android.Writab
 		Output:      outputFile,
 		Inputs:      srcFiles,
 		Implicits:   deps,
+		OrderOnly:   flags.orderOnlyDeps,
 		Args: map[string]string{
-			"javacFlags":    flags.javacFlags,
-			"bootClasspath": bootClasspath,
-			"classpath":     classpath.FormJavaClassPath("-classpath"),
-			"processorpath": flags.processorPath.FormJavaClassPath("-processorpath"),
-			"processor":     processor,
-			"srcJars":       strings.Join(srcJars.Strings(), " "),
-			"srcJarDir":     android.PathForModuleOut(ctx, intermediatesDir, srcJarDir).String(),
-			"outDir":        android.PathForModuleOut(ctx, intermediatesDir, outDir).String(),
-			"annoDir":       android.PathForModuleOut(ctx, intermediatesDir, annoDir).String(),
-			"javaVersion":   flags.javaVersion.String(),
+			"javacFlags":        flags.javacFlags,
+			"bootClasspath":     bootClasspath,
+			"classpath":         classpath.FormJavaClassPath("-classpath"),
+			"processorpath":     flags.processorPath.FormJavaClassPath("-processorpath"),
+			"processor":         processor,
+			"srcJars":           strings.Join(srcJars.Strings(), " "),
+			"srcJarDir":         android.PathForModuleOut(ctx, intermediatesDir, srcJarDir).String(),
+			"outDir":            android.PathForModuleOut(ctx, intermediatesDir, outDir).String(),
+			"annoDir":           android.PathForModuleOut(ctx, intermediatesDir, annoDir).String(),
+			"javaVersionFlags":  flags.javaVersionFlags(bootClasspath),
+			"javacWrapperFlags": javacWrapperFlags,
+			"diagnosticsFile":   diagnosticsFileArg,
 		},
 	})
+
+	return diagnostics
 }
 
 func TransformResourcesToJar(ctx android.ModuleContext, outputFile android.WritablePath,
@@ -631,6 +688,23 @@ func GenerateMainClassManifest(ctx android.ModuleContext, outputFile android.Wri
 	android.WriteFileRule(ctx, outputFile, "Main-Class: "+mainClass+"\n")
 }
 
+// multiReleaseManifest returns a jar manifest that marks the jar as multi-release, preserving the
+// contents of the given manifest if it is valid.
+func multiReleaseManifest(ctx android.ModuleContext, manifest android.OptionalPath) android.Path {
+	outputFile := android.PathForModuleOut(ctx, "manifest", "multi_release.mf")
+	if !manifest.Valid() {
+		android.WriteFileRule(ctx, outputFile, "Multi-Release: true\n")
+		return outputFile
+	}
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        multiReleaseManifestRule,
+		Description: "multi-release manifest",
+		Input:       manifest.Path(),
+		Output:      outputFile,
+	})
+	return outputFile
+}
+
 func TransformZipAlign(ctx android.ModuleContext, outputFile android.WritablePath, inputFile android.Path) {
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        zipalign,
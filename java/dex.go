@@ -84,6 +84,15 @@ type dexer struct {
 	extraProguardFlagFiles android.Paths
 	proguardDictionary     android.OptionalPath
 	proguardUsageZip       android.OptionalPath
+
+	// Jars that were combined into the classes jar passed to compileDex, split into the ones
+	// built from the sources of the module and the ones from static libraries, and the header
+	// jars of the static libraries. When set and D8_INCREMENTAL=true, d8 dexes the jars
+	// separately into intermediate dex archives and merges them, so that a change to the sources
+	// of the module doesn't redex the static libraries.
+	incrementalDexLocalJars        android.Paths
+	incrementalDexStaticJars       android.Paths
+	incrementalDexStaticHeaderJars android.Paths
 }
 
 func (d *dexer) effectiveOptimizeEnabled() bool {
@@ -121,6 +130,41 @@ var d8, d8RE = pctx.MultiCommandRemoteStaticRules("d8",
 		},
 	}, []string{"outDir", "d8Flags", "zipFlags", "tmpJar", "mergeZipsFlags"}, nil)
 
+// d8Intermediate dexes a single classes jar into an intermediate dex archive with one dex file per
+// class, which is merged into the final dex jar by d8Merge. Like the combined classes jar, it
+// leaves out the module-info.class files of prebuilt jars.
+var d8Intermediate = pctx.AndroidStaticRule("d8Intermediate",
+	blueprint.RuleParams{
+		Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
+			`mkdir -p $$(dirname $tmpJar) && ` +
+			`${config.Zip2ZipCmd} -i $in -o $tmpJar -x module-info.class '**/*.class' && ` +
+			`${config.D8Cmd} ${config.DexFlags} --intermediate --file-per-class-file --output $outDir $d8Flags $tmpJar && ` +
+			`${config.SoongZipCmd} -o $out -C $outDir -D $outDir`,
+		CommandDeps: []string{
+			"${config.D8Cmd}",
+			"${config.Zip2ZipCmd}",
+			"${config.SoongZipCmd}",
+		},
+	}, "outDir", "d8Flags", "tmpJar")
+
+// d8Merge merges the intermediate dex archives into the final dex jar. The archives are combined
+// first, keeping the dex file of a class from the first archive that has it, so that classes
+// duplicated between static libraries resolve the same way as in the combined classes jar. The
+// resources, the manifest and the services come from the combined classes jar.
+var d8Merge = pctx.AndroidStaticRule("d8Merge",
+	blueprint.RuleParams{
+		Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
+			`${config.MergeZipsCmd} --ignore-duplicates $tmpZip $in && ` +
+			`${config.D8Cmd} ${config.DexFlags} --output $outDir $d8Flags $tmpZip && ` +
+			`${config.SoongZipCmd} $zipFlags -o $outDir/classes.dex.jar -C $outDir -f "$outDir/classes*.dex" && ` +
+			`${config.MergeZipsCmd} -D -stripFile "**/*.class" $mergeZipsFlags $out $outDir/classes.dex.jar $classesJar`,
+		CommandDeps: []string{
+			"${config.D8Cmd}",
+			"${config.SoongZipCmd}",
+			"${config.MergeZipsCmd}",
+		},
+	}, "outDir", "d8Flags", "zipFlags", "mergeZipsFlags", "classesJar", "tmpZip")
+
 var r8, r8RE = pctx.MultiCommandRemoteStaticRules("r8",
 	blueprint.RuleParams{
 		Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
@@ -355,6 +399,8 @@ func (d *dexer) compileDex(ctx android.ModuleContext, flags javaBuilderFlags, mi
 			Implicits:       r8Deps,
 			Args:            args,
 		})
+	} else if d.useIncrementalDex(ctx) {
+		d.compileDexIncremental(ctx, flags, commonFlags, commonDeps, classesJar, javalibJar, zipFlags, mergeZipsFlags)
 	} else {
 		d8Flags, d8Deps := d8Flags(flags)
 		d8Deps = append(d8Deps, commonDeps...)
@@ -385,3 +431,100 @@ func (d *dexer) compileDex(ctx android.ModuleContext, flags javaBuilderFlags, mi
 
 	return javalibJar
 }
+
+// useIncrementalDex returns true if incremental dexing is enabled with D8_INCREMENTAL=true, and the
+// input jars of d8 are known and can be dexed separately. It is opt-in as the intermediate dex
+// archives take more disk space and the merge adds a step to clean builds. Legacy multidex needs
+// all classes at once to compute the main dex list, and remote d8 isn't worth splitting into many
+// small actions.
+func (d *dexer) useIncrementalDex(ctx android.ModuleContext) bool {
+	if !ctx.Config().IsEnvTrue("D8_INCREMENTAL") {
+		return false
+	}
+	if len(d.incrementalDexLocalJars)+len(d.incrementalDexStaticJars) < 2 {
+		return false
+	}
+	if len(d.dexProperties.Main_dex_rules) > 0 {
+		return false
+	}
+	return !(ctx.Config().UseRBE() && ctx.Config().IsEnvTrue("RBE_D8"))
+}
+
+// dexIntermediateName returns the name of the intermediate dex archive of jar. It only depends on
+// the path of jar, so adding or removing a static library doesn't rename the archives of the others.
+func dexIntermediateName(ctx android.PathContext, jar android.Path) string {
+	if rel, isRel := android.MaybeRel(ctx, ctx.Config().SoongOutDir(), jar.String()); isRel {
+		return rel
+	}
+	return jar.String()
+}
+
+// compileDexIncremental dexes each of the jars combined into classesJar into an intermediate dex
+// archive, and merges them into javalibJar together with the resources of classesJar. The jars are
+// desugared against the header jars of the static libraries, which only change with their APIs,
+// and jars from the sources of the module are also desugared against each other. So editing the
+// sources of the module only redexes its own classes, and editing the implementation of a static
+// library only redexes that library.
+func (d *dexer) compileDexIncremental(ctx android.ModuleContext, flags javaBuilderFlags,
+	commonFlags []string, commonDeps android.Paths, classesJar android.Path, javalibJar android.WritablePath,
+	zipFlags, mergeZipsFlags string) {
+
+	d8Flags, d8Deps := d8Flags(flags)
+	d8Deps = append(d8Deps, commonDeps...)
+
+	var intermediates android.Paths
+	dexIntermediate := func(jar android.Path, classpath android.Paths) {
+		name := dexIntermediateName(ctx, jar)
+		intermediate := android.PathForModuleOut(ctx, "dex-intermediates", name+".zip")
+		intermediateFlags := append(android.CopyOf(commonFlags), d8Flags...)
+		intermediateFlags = append(intermediateFlags, classpath.FormRepeatedClassPath("--classpath ")...)
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        d8Intermediate,
+			Description: "d8 intermediate",
+			Output:      intermediate,
+			Input:       jar,
+			Implicits:   append(android.CopyOfPaths(d8Deps), classpath...),
+			Args: map[string]string{
+				"d8Flags": strings.Join(intermediateFlags, " "),
+				"outDir":  android.PathForModuleOut(ctx, "dex-intermediates", name).String(),
+				"tmpJar":  android.PathForModuleOut(ctx, "dex-intermediates", "classes", name+".jar").String(),
+			},
+		})
+		intermediates = append(intermediates, intermediate)
+	}
+
+	// The intermediates are merged in the order of the jars in the combined classes jar.
+	local, staticHeaders := d.incrementalDexLocalJars, d.incrementalDexStaticHeaderJars
+	for i, jar := range local {
+		classpath := append(android.Paths{}, local[:i]...)
+		classpath = append(classpath, local[i+1:]...)
+		dexIntermediate(jar, append(classpath, staticHeaders...))
+	}
+	for _, jar := range d.incrementalDexStaticJars {
+		dexIntermediate(jar, staticHeaders)
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        d8Merge,
+		Description: "d8 merge",
+		Output:      javalibJar,
+		Inputs:      intermediates,
+		Implicits:   append(android.Paths{classesJar}, commonDeps...),
+		Args: map[string]string{
+			"d8Flags":        strings.Join(commonFlags, " "),
+			"zipFlags":       zipFlags,
+			"outDir":         android.PathForModuleOut(ctx, "dex").String(),
+			"mergeZipsFlags": mergeZipsFlags,
+			"classesJar":     classesJar.String(),
+			"tmpZip":         android.PathForModuleOut(ctx, "dex-intermediates", "merged.zip").String(),
+		},
+	})
+}
+
+// setIncrementalDexInputs records the jars that were combined into the classes jar, see
+// incrementalDexLocalJars.
+func (d *dexer) setIncrementalDexInputs(localJars, staticJars, staticHeaderJars android.Paths) {
+	d.incrementalDexLocalJars = localJars
+	d.incrementalDexStaticJars = staticJars
+	d.incrementalDexStaticHeaderJars = staticHeaderJars
+}
@@ -100,4 +100,68 @@ func TestD8(t *testing.T) {
 		fooD8.Args["d8Flags"], libHeader.String())
 	android.AssertStringDoesNotContain(t, "expected no  static_lib header jar in foo javac classpath",
 		fooD8.Args["d8Flags"], staticLibHeader.String())
+
+	// Incremental dexing is opt-in.
+	if foo.MaybeRule("d8Intermediate").Rule != nil {
+		t.Errorf("expected no incremental dexing without D8_INCREMENTAL")
+	}
+}
+
+func TestD8Incremental(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModulesWithoutFakeDex2oatd,
+		android.FixtureMergeEnv(map[string]string{
+			"D8_INCREMENTAL": "true",
+		}),
+	).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["foo.java"],
+			static_libs: ["static_lib"],
+			installable: true,
+		}
+
+		java_library {
+			name: "static_lib",
+			srcs: ["foo.java"],
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	fooClasses := foo.Rule("javac").Output
+	staticLib := result.ModuleForTests("static_lib", "android_common")
+	staticLibClasses := staticLib.Rule("javac").Output
+	staticLibHeader := staticLib.Rule("turbine").Output
+
+	// The intermediates are named after the jars they are dexed from.
+	fooIntermediate := foo.Output("dex-intermediates/.intermediates/foo/android_common/javac/foo.jar.zip")
+	staticLibIntermediate := foo.Output("dex-intermediates/.intermediates/static_lib/android_common/javac/static_lib.jar.zip")
+	merge := foo.Rule("d8Merge")
+
+	android.AssertPathRelativeToTopEquals(t, "foo intermediate input",
+		android.PathRelativeToTop(fooClasses), fooIntermediate.Input)
+	android.AssertPathRelativeToTopEquals(t, "static_lib intermediate input",
+		android.PathRelativeToTop(staticLibClasses), staticLibIntermediate.Input)
+
+	// The jars are desugared against the header jars of the static libraries, so that changes to
+	// the implementation of static_lib don't redex foo.
+	android.AssertStringDoesContain(t, "expected static_lib header jar in foo intermediate classpath",
+		fooIntermediate.Args["d8Flags"], "--classpath "+staticLibHeader.String())
+	android.AssertStringDoesNotContain(t, "expected no static_lib classes in foo intermediate classpath",
+		fooIntermediate.Args["d8Flags"], staticLibClasses.String())
+	android.AssertStringDoesNotContain(t, "expected no foo classes in static_lib intermediate classpath",
+		staticLibIntermediate.Args["d8Flags"], fooClasses.String())
+
+	// Classes duplicated between the jars are deduped before merging, and module-info.class files
+	// are left out like in the combined classes jar.
+	android.AssertStringDoesContain(t, "expected duplicate classes to be ignored",
+		merge.RuleParams.Command, "--ignore-duplicates")
+	android.AssertStringDoesContain(t, "expected module-info.class to be stripped",
+		fooIntermediate.RuleParams.Command, "-x module-info.class")
+
+	android.AssertPathsRelativeToTopEquals(t, "d8 merge inputs",
+		[]string{android.PathRelativeToTop(fooIntermediate.Output), android.PathRelativeToTop(staticLibIntermediate.Output)},
+		merge.Inputs)
+	android.AssertStringEquals(t, "d8 merge output", "out/soong/.intermediates/foo/android_common/dex/foo.jar",
+		android.PathRelativeToTop(merge.Output))
 }
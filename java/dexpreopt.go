@@ -18,6 +18,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/google/blueprint"
+
 	"android/soong/android"
 	"android/soong/dexpreopt"
 )
@@ -27,6 +29,7 @@ type DexpreopterInterface interface {
 	dexpreoptDisabled(ctx android.BaseModuleContext) bool
 	DexpreoptBuiltInstalledForApex() []dexpreopterInstall
 	AndroidMkEntriesForApex() []android.AndroidMkEntries
+	dexpreoptChecksumsFile() android.Path
 }
 
 type dexpreopterInstall struct {
@@ -96,6 +99,15 @@ type dexpreopter struct {
 	builtInstalled        string
 	builtInstalledForApex []dexpreopterInstall
 
+	// The checksums of the files installed by the dexpreopt rule, collected by the
+	// dexpreopt_checksums singleton.
+	checksumsFile android.Path
+
+	// Whether dexpreopt rules were generated for the module, and if not, why. Reported by the
+	// dexpreopt_status singleton.
+	preopted         bool
+	preoptSkipReason string
+
 	// The config is used for two purposes:
 	// - Passing dexpreopt information about libraries from Soong to Make. This is needed when
 	//   a <uses-library> is defined in Android.bp, but used in Android.mk (see dex_preopt_config_merger.py).
@@ -122,6 +134,12 @@ type DexpreoptProperties struct {
 		// defaults to searching for a file that matches the name of this module in the default
 		// profile location set by PRODUCT_DEX_PREOPT_PROFILE_DIR, or empty if not found.
 		Profile *string `android:"path"`
+
+		// If set, provides the path to a file relative to the Android.bp file with hints about the
+		// hot classes and methods of this module, in the text format of the boot image profile. If
+		// this module is in a boot image, the hints are added to the boot image profile, which
+		// affects which classes are in the boot image and how they are laid out.
+		Boot_image_profile_hints *string `android:"path"`
 	}
 }
 
@@ -129,6 +147,25 @@ func init() {
 	dexpreopt.DexpreoptRunningInSoong = true
 }
 
+// BootImageProfileHintsInfo contains the hints that a boot jar provides for the boot image
+// profile.
+type BootImageProfileHintsInfo struct {
+	// The file set by the dex_preopt.boot_image_profile_hints property.
+	Hints android.Path
+}
+
+var BootImageProfileHintsInfoProvider = blueprint.NewProvider(BootImageProfileHintsInfo{})
+
+// provideBootImageProfileHints makes the hints set by the dex_preopt.boot_image_profile_hints
+// property available to the modules that build the boot images.
+func (d *dexpreopter) provideBootImageProfileHints(ctx android.ModuleContext) {
+	if hints := d.dexpreoptProperties.Dex_preopt.Boot_image_profile_hints; hints != nil {
+		ctx.SetProvider(BootImageProfileHintsInfoProvider, BootImageProfileHintsInfo{
+			Hints: android.PathForModuleSrc(ctx, *hints),
+		})
+	}
+}
+
 func isApexVariant(ctx android.BaseModuleContext) bool {
 	apexInfo := ctx.Provider(android.ApexInfoProvider).(android.ApexInfo)
 	return !apexInfo.IsForPlatform()
@@ -146,36 +183,41 @@ func moduleName(ctx android.BaseModuleContext) string {
 }
 
 func (d *dexpreopter) dexpreoptDisabled(ctx android.BaseModuleContext) bool {
+	return d.dexpreoptDisabledReason(ctx) != ""
+}
+
+// dexpreoptDisabledReason returns why the module is not dexpreopted, or an empty string if it is.
+func (d *dexpreopter) dexpreoptDisabledReason(ctx android.BaseModuleContext) string {
 	if !ctx.Device() {
-		return true
+		return "not a device module"
 	}
 
 	if d.isTest {
-		return true
+		return "test module"
 	}
 
 	if !BoolDefault(d.dexpreoptProperties.Dex_preopt.Enabled, true) {
-		return true
+		return "dex_preopt.enabled is false"
 	}
 
 	// If the module is from a prebuilt APEX, it shouldn't be installable, but it can still be
 	// dexpreopted.
 	if !ctx.Module().(DexpreopterInterface).IsInstallable() && !forPrebuiltApex(ctx) {
-		return true
+		return "not installable"
 	}
 
 	if !android.IsModulePreferred(ctx.Module()) {
-		return true
+		return "not preferred over its prebuilt or source"
 	}
 
 	global := dexpreopt.GetGlobalConfig(ctx)
 
 	if global.DisablePreopt {
-		return true
+		return "dexpreopt is disabled for the product"
 	}
 
 	if inList(moduleName(ctx), global.DisablePreoptModules) {
-		return true
+		return "dexpreopt is disabled for the module by the product"
 	}
 
 	isApexSystemServerJar := global.AllApexSystemServerJars(ctx).ContainsJar(moduleName(ctx))
@@ -183,18 +225,18 @@ func (d *dexpreopter) dexpreoptDisabled(ctx android.BaseModuleContext) bool {
 		// Don't preopt APEX variant module unless the module is an APEX system server jar and we are
 		// building the entire system image.
 		if !isApexSystemServerJar || ctx.Config().UnbundledBuild() {
-			return true
+			return "APEX variant"
 		}
 	} else {
 		// Don't preopt the platform variant of an APEX system server jar to avoid conflicts.
 		if isApexSystemServerJar {
-			return true
+			return "platform variant of an APEX system server jar"
 		}
 	}
 
 	// TODO: contains no java code
 
-	return false
+	return ""
 }
 
 func dexpreoptToolDepsMutator(ctx android.BottomUpMutatorContext) {
@@ -237,6 +279,7 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Wr
 	// e.g. in aar.go. This keeps the behaviour that dexpreopting is effectively
 	// disabled, even if installable is true.
 	if d.installPath.Base() == "." {
+		d.preoptSkipReason = "no install path"
 		return
 	}
 
@@ -253,6 +296,7 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Wr
 	// If it is test, make config files regardless of its dexpreopt setting.
 	// The config files are required for apps defined in make which depend on the lib.
 	if d.isTest && d.dexpreoptDisabled(ctx) {
+		d.preoptSkipReason = d.dexpreoptDisabledReason(ctx)
 		return
 	}
 
@@ -309,6 +353,19 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Wr
 		}
 	}
 
+	// Module specific dex2oat flags replace the global ones, so keep the global flags when adding
+	// the flags of toolchain experiments.
+	var preoptFlags []string
+	for _, experiment := range android.ToolchainExperiments(ctx) {
+		if len(experiment.Dex2oatFlags) > 0 {
+			preoptFlags = append(preoptFlags, experiment.Dex2oatFlags...)
+			android.MarkToolchainExperimentApplied(ctx, experiment)
+		}
+	}
+	if len(preoptFlags) > 0 {
+		preoptFlags = append(android.CopyOf(global.PreoptFlags), preoptFlags...)
+	}
+
 	// Full dexpreopt config, used to create dexpreopt build rules.
 	dexpreoptConfig := &dexpreopt.ModuleConfig{
 		Name:            moduleName(ctx),
@@ -318,7 +375,7 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Wr
 		ManifestPath:    android.OptionalPathForPath(d.manifestFile),
 		UncompressedDex: d.uncompressedDex,
 		HasApkLibraries: false,
-		PreoptFlags:     nil,
+		PreoptFlags:     preoptFlags,
 
 		ProfileClassListing:  profileClassListing,
 		ProfileIsTextListing: profileIsTextListing,
@@ -348,7 +405,8 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Wr
 	d.configPath = android.PathForModuleOut(ctx, "dexpreopt", "dexpreopt.config")
 	dexpreopt.WriteModuleConfig(ctx, dexpreoptConfig, d.configPath)
 
-	if d.dexpreoptDisabled(ctx) {
+	if reason := d.dexpreoptDisabledReason(ctx); reason != "" {
+		d.preoptSkipReason = reason
 		return
 	}
 
@@ -361,6 +419,10 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Wr
 	}
 
 	dexpreoptRule.Build("dexpreopt", "dexpreopt")
+	d.preopted = true
+	if len(dexpreoptRule.Installs()) > 0 {
+		d.checksumsFile = dexpreopt.ChecksumsPath(ctx, dexpreoptConfig)
+	}
 
 	isApexSystemServerJar := global.AllApexSystemServerJars(ctx).ContainsJar(moduleName(ctx))
 
@@ -399,6 +461,10 @@ func (d *dexpreopter) DexpreoptBuiltInstalledForApex() []dexpreopterInstall {
 	return d.builtInstalledForApex
 }
 
+func (d *dexpreopter) dexpreoptChecksumsFile() android.Path {
+	return d.checksumsFile
+}
+
 func (d *dexpreopter) AndroidMkEntriesForApex() []android.AndroidMkEntries {
 	var entries []android.AndroidMkEntries
 	for _, install := range d.builtInstalledForApex {
@@ -329,6 +329,10 @@ type bootImageVariant struct {
 
 	// Path to the license metadata file for the module that built the image.
 	licenseMetadataFile android.OptionalPath
+
+	// Path to the checksums of the installed .art, .oat and .vdex files of a device variant, which
+	// the dexpreopt_checksums singleton merges with those of the dexpreopted modules.
+	checksumsFile android.OptionalPath
 }
 
 // Get target-specific boot image variant for the given boot image config and target.
@@ -768,6 +772,20 @@ func buildBootImageVariant(ctx android.ModuleContext, image *bootImageVariant, p
 		}
 	}
 
+	var checksumsFile android.OptionalPath
+	if image.target.Os == android.Android {
+		path := outputDir.Join(ctx, image.stem+"_checksums.json")
+		checksumsCmd := rule.Command().
+			Tool(globalSoong.OatChecksums).
+			FlagWithArg("--module ", "boot_image_"+image.name+"_"+arch.String()).
+			FlagWithArg("--isa ", arch.String())
+		for _, install := range append(rule.Installs(), vdexInstalls...) {
+			checksumsCmd.FlagWithArg("--file ", install.From.String()+":"+install.To)
+		}
+		checksumsCmd.FlagWithOutput("-o ", path)
+		checksumsFile = android.OptionalPathForPath(path)
+	}
+
 	rule.Build(image.name+"JarsDexpreopt_"+image.target.String(), "dexpreopt "+image.name+" jars "+arch.String())
 
 	// save output and installed files for makevars
@@ -776,6 +794,7 @@ func buildBootImageVariant(ctx android.ModuleContext, image *bootImageVariant, p
 	image.unstrippedInstalls = unstrippedInstalls
 	image.deviceInstalls = deviceInstalls
 	image.licenseMetadataFile = android.OptionalPathForPath(ctx.LicenseMetadataFile())
+	image.checksumsFile = checksumsFile
 }
 
 const failureMessage = `ERROR: Dex2oat failed to compile a boot image.
@@ -794,15 +813,24 @@ func bootImageProfileRule(ctx android.ModuleContext, image *bootImageConfig) and
 
 	rule := android.NewRuleBuilder(pctx, ctx)
 
+	var bootImageProfiles android.Paths
+	if len(global.BootImageProfiles) > 0 {
+		bootImageProfiles = append(bootImageProfiles, global.BootImageProfiles...)
+	} else if path := android.ExistentPathForSource(ctx, defaultProfile); path.Valid() {
+		bootImageProfiles = append(bootImageProfiles, path.Path())
+	}
+
+	// Add the hints provided by the modules in the image.
+	hintModules, hints := gatherBootImageProfileHints(ctx, image)
+	bootImageProfiles = append(bootImageProfiles, hints...)
+
 	var bootImageProfile android.Path
-	if len(global.BootImageProfiles) > 1 {
+	if len(bootImageProfiles) > 1 {
 		combinedBootImageProfile := image.dir.Join(ctx, "boot-image-profile.txt")
-		rule.Command().Text("cat").Inputs(global.BootImageProfiles).Text(">").Output(combinedBootImageProfile)
+		rule.Command().Text("cat").Inputs(bootImageProfiles).Text(">").Output(combinedBootImageProfile)
 		bootImageProfile = combinedBootImageProfile
-	} else if len(global.BootImageProfiles) == 1 {
-		bootImageProfile = global.BootImageProfiles[0]
-	} else if path := android.ExistentPathForSource(ctx, defaultProfile); path.Valid() {
-		bootImageProfile = path.Path()
+	} else if len(bootImageProfiles) == 1 {
+		bootImageProfile = bootImageProfiles[0]
 	} else {
 		// No profile (not even a default one, which is the case on some branches
 		// like master-art-host that don't have frameworks/base).
@@ -831,9 +859,65 @@ func bootImageProfileRule(ctx android.ModuleContext, image *bootImageConfig) and
 
 	image.profilePathOnHost = profile
 
+	bootImageLayoutReportRule(ctx, image, profile, hintModules, hints)
+
 	return profile
 }
 
+// gatherBootImageProfileHints returns the names of the modules in the image that provide hints for
+// the boot image profile through the dex_preopt.boot_image_profile_hints property, and the files
+// with the hints.
+func gatherBootImageProfileHints(ctx android.ModuleContext, image *bootImageConfig) ([]string, android.Paths) {
+	var names []string
+	var hints android.Paths
+	seen := make(map[string]bool)
+	ctx.VisitDirectDeps(func(module android.Module) {
+		name := android.RemoveOptionalPrebuiltPrefix(ctx.OtherModuleName(module))
+		if !image.modules.ContainsJar(name) || !ctx.OtherModuleHasProvider(module, BootImageProfileHintsInfoProvider) {
+			return
+		}
+		info := ctx.OtherModuleProvider(module, BootImageProfileHintsInfoProvider).(BootImageProfileHintsInfo)
+		if seen[info.Hints.String()] {
+			return
+		}
+		seen[info.Hints.String()] = true
+		names = append(names, name)
+		hints = append(hints, info.Hints)
+	})
+	return names, hints
+}
+
+// bootImageLayoutReportRule generates the rule to create a report of the classes and methods in
+// the boot image profile, which decide the contents and layout of the boot image, and of the
+// modules that provided hints for it. The report is built by `m boot-image-layout-report`.
+func bootImageLayoutReportRule(ctx android.ModuleContext, image *bootImageConfig, profile android.Path, hintModules []string, hints android.Paths) {
+	globalSoong := dexpreopt.GetGlobalSoongConfig(ctx)
+
+	report := image.dir.Join(ctx, "boot-image-layout.txt")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		Text("echo").Text(proptools.ShellEscape("# Boot image profile hints for " + image.name + ":")).
+		Text(">").Output(report)
+	for i, hint := range hints {
+		rule.Command().
+			Text("echo").Text(proptools.ShellEscape("#   " + hintModules[i] + ": " + hint.String())).
+			Text(">>").Text(report.String()).
+			Implicit(hint)
+	}
+	rule.Command().
+		Text(`ANDROID_LOG_TAGS="*:e"`).
+		Tool(globalSoong.Profman).
+		Flag("--dump-classes-and-methods").
+		FlagWithInput("--profile-file=", profile).
+		FlagForEachInput("--apk=", image.dexPathsDeps.Paths()).
+		FlagForEachArg("--dex-location=", image.getAnyAndroidVariant().dexLocationsDeps).
+		Text(">>").Text(report.String())
+	rule.Build("bootImageLayoutReport", "boot image layout report")
+
+	ctx.Phony("boot-image-layout-report", report)
+}
+
 // bootFrameworkProfileRule generates the rule to create the boot framework profile and
 // returns a path to the generated file.
 func bootFrameworkProfileRule(ctx android.ModuleContext, image *bootImageConfig) android.WritablePath {
@@ -99,6 +99,7 @@ func TestDexpreoptBootJars(t *testing.T) {
 		"dex_bootjars/android/system/framework/arm64/boot-foo.vdex",
 		"dex_bootjars/android/system/framework/arm64/boot-bar.vdex",
 		"dex_bootjars/android/system/framework/arm64/boot-baz.vdex",
+		"dex_bootjars/android/system/framework/arm64/boot_checksums.json",
 		"dex_bootjars_unstripped/android/system/framework/arm64/boot-foo.oat",
 		"dex_bootjars_unstripped/android/system/framework/arm64/boot-bar.oat",
 		"dex_bootjars_unstripped/android/system/framework/arm64/boot-baz.oat",
@@ -128,3 +129,73 @@ func TestDexpreoptBootZip(t *testing.T) {
 
 	testDexpreoptBoot(t, ruleFile, expectedInputs, expectedOutputs)
 }
+
+func TestDexpreoptBootImageProfileHints(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		FixtureConfigureBootJars("platform:foo", "platform:bar"),
+	).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			installable: true,
+			dex_preopt: {
+				boot_image_profile_hints: "foo-hints.txt",
+			},
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			installable: true,
+		}
+
+		platform_bootclasspath {
+			name: "platform-bootclasspath",
+		}
+	`)
+
+	platformBootclasspath := result.ModuleForTests("platform-bootclasspath", "android_common")
+
+	profile := platformBootclasspath.Output("boot.prof")
+	android.AssertStringDoesContain(t, "profile command", profile.RuleParams.Command,
+		"--create-profile-from=foo-hints.txt")
+
+	report := platformBootclasspath.Output("boot-image-layout.txt")
+	android.AssertStringDoesContain(t, "report command", report.RuleParams.Command,
+		"'#   foo: foo-hints.txt'")
+	android.AssertStringDoesContain(t, "report command", report.RuleParams.Command,
+		"--dump-classes-and-methods --profile-file=out/soong/test_device/dex_bootjars/boot.prof")
+}
+
+func TestDexpreoptBootImageChecksums(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		FixtureConfigureBootJars("platform:foo"),
+	).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			installable: true,
+		}
+
+		platform_bootclasspath {
+			name: "platform-bootclasspath",
+		}
+	`)
+
+	platformBootclasspath := result.ModuleForTests("platform-bootclasspath", "android_common")
+	checksums := platformBootclasspath.Output("dex_bootjars/android/system/framework/arm64/boot_checksums.json")
+	for _, expected := range []string{
+		"--module boot_image_boot_arm64 --isa arm64",
+		"dex_bootjars/android/system/framework/arm64/boot-foo.art:/system/framework/arm64/boot-foo.art",
+		"dex_bootjars/android/system/framework/arm64/boot-foo.oat:/system/framework/arm64/boot-foo.oat",
+		"dex_bootjars/android/system/framework/arm64/boot-foo.vdex:/system/framework/arm64/boot-foo.vdex",
+	} {
+		android.AssertStringDoesContain(t, "checksums command", checksums.RuleParams.Command, expected)
+	}
+
+	merged := result.SingletonForTests("dexpreopt_checksums").Output("dexpreopt_checksums.json")
+	android.AssertStringListContains(t, "merged checksums inputs",
+		android.PathsRelativeToTop(merged.Implicits), checksums.Output.RelativeToTop().String())
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"android/soong/android"
+	"android/soong/dexpreopt"
+)
+
+// The dexpreopt_checksums singleton merges the checksums written by the dexpreopt rule of every
+// module and by the rules building the device boot images into
+// $OUT_DIR/soong/dexpreopt_checksums.json. For each installed odex, vdex, art, oat, dm or profile
+// file it has the install path, the instruction set, the size and the sha256, so that OTA
+// generation can leave out of a delta the dexpreopt outputs that did not change. It is dist'ed with
+// droidcore and by the dexpreopt-checksums goal.
+
+func init() {
+	registerDexpreoptChecksumsBuildComponents(android.InitRegistrationContext)
+}
+
+func registerDexpreoptChecksumsBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("dexpreopt_checksums", dexpreoptChecksumsSingletonFactory)
+}
+
+func dexpreoptChecksumsSingletonFactory() android.Singleton {
+	return &dexpreoptChecksumsSingleton{}
+}
+
+type dexpreoptChecksumsSingleton struct {
+	checksumsFile android.WritablePath
+}
+
+func (s *dexpreoptChecksumsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var inputs android.Paths
+	ctx.VisitAllModules(func(m android.Module) {
+		if !m.Enabled() {
+			return
+		}
+		d, ok := m.(DexpreopterInterface)
+		if !ok || !d.IsInstallable() || !android.IsModulePreferred(m) {
+			return
+		}
+		if checksums := d.dexpreoptChecksumsFile(); checksums != nil {
+			inputs = append(inputs, checksums)
+		}
+	})
+	if !SkipDexpreoptBootJars(ctx) && dexpreopt.GetCachedGlobalSoongConfig(ctx) != nil {
+		for _, image := range []*bootImageConfig{artBootImageConfig(ctx), defaultBootImageConfig(ctx)} {
+			for _, variant := range image.variants {
+				if variant.checksumsFile.Valid() {
+					inputs = append(inputs, variant.checksumsFile.Path())
+				}
+			}
+		}
+	}
+	if len(inputs) == 0 {
+		return
+	}
+
+	s.checksumsFile = android.PathForOutput(ctx, "dexpreopt_checksums.json")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		BuiltTool("dexpreopt_checksums").
+		Flag("--merge").
+		FlagWithOutput("-o ", s.checksumsFile).
+		FlagWithRspFileInputList("@", android.PathForOutput(ctx, "dexpreopt_checksums.rsp"), inputs)
+	rule.Build("dexpreopt_checksums", "merge dexpreopt checksums")
+	ctx.Phony("dexpreopt-checksums", s.checksumsFile)
+}
+
+func (s *dexpreoptChecksumsSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if s.checksumsFile != nil {
+		ctx.DistForGoals([]string{"droidcore", "dexpreopt-checksums"}, s.checksumsFile)
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*dexpreoptChecksumsSingleton)(nil)
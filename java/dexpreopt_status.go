@@ -0,0 +1,117 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"encoding/json"
+	"sort"
+
+	"android/soong/android"
+)
+
+// The dexpreopt_status singleton writes $OUT_DIR/soong/dexpreopt_status.json, which lists for each
+// app whether it is dexpreopted or why not, and the <uses-library> dependencies and class loader
+// context that its manifest is verified against and that it is dexpreopted with. It is dist'ed with
+// droidcore and by the dexpreopt-status goal, so that the apps that ship unoptimized can be
+// audited without parsing the Ninja files.
+
+func init() {
+	registerDexpreoptStatusBuildComponents(android.InitRegistrationContext)
+}
+
+func registerDexpreoptStatusBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("dexpreopt_status", dexpreoptStatusSingletonFactory)
+}
+
+type dexpreoptStatus struct {
+	Name                  string
+	Preopted              bool
+	SkipReason            string `json:",omitempty"`
+	EnforceUsesLibraries  bool
+	UsesLibraries         []string
+	OptionalUsesLibraries []string
+	ClassLoaderContexts   json.RawMessage
+}
+
+func (d *dexpreopter) dexpreoptStatus() dexpreoptStatus {
+	reason := d.preoptSkipReason
+	if !d.preopted && reason == "" {
+		// dexpreopt is not called for modules that have no dex jar.
+		reason = "no dex jar"
+	}
+	usesLibs, optionalUsesLibs := d.classLoaderContexts.UsesLibs()
+	return dexpreoptStatus{
+		Preopted:              d.preopted,
+		SkipReason:            reason,
+		EnforceUsesLibraries:  d.enforceUsesLibs,
+		UsesLibraries:         usesLibs,
+		OptionalUsesLibraries: optionalUsesLibs,
+		ClassLoaderContexts:   json.RawMessage(d.classLoaderContexts.Dump()),
+	}
+}
+
+func dexpreoptStatusSingletonFactory() android.Singleton {
+	return &dexpreoptStatusSingleton{}
+}
+
+type dexpreoptStatusSingleton struct {
+	statusFile android.WritablePath
+}
+
+func (s *dexpreoptStatusSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var statuses []dexpreoptStatus
+	ctx.VisitAllModules(func(m android.Module) {
+		if !m.Enabled() {
+			return
+		}
+		var d *dexpreopter
+		switch app := m.(type) {
+		case *AndroidApp:
+			d = &app.dexpreopter
+		case *AndroidAppImport:
+			d = &app.dexpreopter
+		default:
+			return
+		}
+		if !m.(DexpreopterInterface).IsInstallable() || !android.IsModulePreferred(m) {
+			return
+		}
+		status := d.dexpreoptStatus()
+		status.Name = android.RemoveOptionalPrebuiltPrefix(m.Name())
+		statuses = append(statuses, status)
+	})
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	content, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to marshal dexpreopt status: %s", err)
+		return
+	}
+
+	s.statusFile = android.PathForOutput(ctx, "dexpreopt_status.json")
+	android.WriteFileRule(ctx, s.statusFile, string(content))
+	ctx.Phony("dexpreopt-status", s.statusFile)
+}
+
+func (s *dexpreoptStatusSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if s.statusFile != nil {
+		ctx.DistForGoals([]string{"droidcore", "dexpreopt-status"}, s.statusFile)
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*dexpreoptStatusSingleton)(nil)
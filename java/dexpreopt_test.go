@@ -15,6 +15,7 @@
 package java
 
 import (
+	"encoding/json"
 	"fmt"
 	"runtime"
 	"strings"
@@ -437,3 +438,97 @@ func TestAndroidMkEntriesForApex(t *testing.T) {
 
 	android.AssertIntEquals(t, "entries count", 0, len(entriesList))
 }
+
+func TestDexpreoptStatus(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			uses_libs: ["runtime-lib"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			dex_preopt: {
+				enabled: false,
+			},
+		}
+
+		android_app {
+			name: "baz",
+			sdk_version: "current",
+		}
+
+		java_library {
+			name: "runtime-lib",
+			srcs: ["a.java"],
+			installable: true,
+			sdk_version: "current",
+		}
+	`)
+
+	singleton := result.SingletonForTests("dexpreopt_status")
+	var statuses []dexpreoptStatus
+	content := android.ContentFromFileRuleForTests(t, singleton.Output("dexpreopt_status.json"))
+	if err := json.Unmarshal([]byte(content), &statuses); err != nil {
+		t.Fatal(err)
+	}
+
+	android.AssertIntEquals(t, "status count", 3, len(statuses))
+
+	bar, baz, foo := statuses[0], statuses[1], statuses[2]
+	android.AssertStringEquals(t, "name", "bar", bar.Name)
+	android.AssertBoolEquals(t, "bar preopted", false, bar.Preopted)
+	android.AssertStringEquals(t, "bar skip reason", "dex_preopt.enabled is false", bar.SkipReason)
+
+	android.AssertStringEquals(t, "name", "baz", baz.Name)
+	android.AssertBoolEquals(t, "baz preopted", false, baz.Preopted)
+	android.AssertStringEquals(t, "baz skip reason", "no dex jar", baz.SkipReason)
+
+	android.AssertStringEquals(t, "name", "foo", foo.Name)
+	android.AssertBoolEquals(t, "foo preopted", true, foo.Preopted)
+	android.AssertStringEquals(t, "foo skip reason", "", foo.SkipReason)
+	android.AssertDeepEquals(t, "foo uses libraries", []string{"runtime-lib"}, foo.UsesLibraries)
+	android.AssertStringDoesContain(t, "foo class loader contexts", string(foo.ClassLoaderContexts),
+		"/system/framework/runtime-lib.jar")
+}
+
+func TestDexpreoptChecksums(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			dex_preopt: {
+				enabled: false,
+			},
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	fooChecksums := foo.Output("dexpreopt_checksums.json")
+	android.AssertStringEquals(t, "foo checksums rule", foo.Rule("dexpreopt").RuleParams.Command,
+		fooChecksums.RuleParams.Command)
+	android.AssertStringDoesContain(t, "foo checksums command", fooChecksums.RuleParams.Command,
+		"dexpreopt_checksums --module foo --file ")
+	android.AssertStringDoesContain(t, "foo checksums command", fooChecksums.RuleParams.Command,
+		":/system/app/foo/oat/arm64/foo.odex")
+
+	bar := result.ModuleForTests("bar", "android_common")
+	if bar.MaybeOutput("dexpreopt_checksums.json").Rule != nil {
+		t.Errorf("expected no dexpreopt checksums for bar")
+	}
+
+	merged := result.SingletonForTests("dexpreopt_checksums").Output("dexpreopt_checksums.json")
+	android.AssertStringListContains(t, "merged checksums inputs",
+		android.PathsRelativeToTop(merged.Implicits), fooChecksums.Output.RelativeToTop().String())
+}
@@ -0,0 +1,164 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// When JAVA_DIAGNOSTICS_REPORT=true, soong_javac_wrapper writes the javac and errorprone warnings
+// and errors of each java module to a file, and the java_diagnostics_report singleton merges them
+// with the lint XML reports of the modules into $OUT_DIR/soong/java_diagnostics_report.txt. The
+// report is keyed by source file, lists each diagnostic only once even when the file is compiled
+// by several modules, and annotates each file with the owners listed in the OWNERS files of the
+// directories of the modules that compile it. It is built and dist'ed by the
+// java-diagnostics-report goal.
+//
+// The report depends on the lint reports of all modules, so building it runs lint.
+
+const javaDiagnosticsReportEnv = "JAVA_DIAGNOSTICS_REPORT"
+
+func init() {
+	registerJavaDiagnosticsBuildComponents(android.InitRegistrationContext)
+}
+
+func registerJavaDiagnosticsBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("java_diagnostics_report", javaDiagnosticsReportSingletonFactory)
+}
+
+var javaDiagnosticsReportRule = pctx.AndroidStaticRule("javaDiagnosticsReport",
+	blueprint.RuleParams{
+		Command:     `${config.JavaDiagnosticsReportCmd} -o $out $in`,
+		CommandDeps: []string{"${config.JavaDiagnosticsReportCmd}"},
+	})
+
+func javaDiagnosticsReportEnabled(config android.Config) bool {
+	return config.IsEnvTrue(javaDiagnosticsReportEnv)
+}
+
+// addJavacDiagnostics records the diagnostics file written while compiling classes, if any.
+func (j *Module) addJavacDiagnostics(diagnostics android.Path, classes android.Path) {
+	if diagnostics != nil {
+		j.javacDiagnostics = append(j.javacDiagnostics, diagnostics)
+		j.javacDiagnosticsDeps = append(j.javacDiagnosticsDeps, classes)
+	}
+}
+
+type javacDiagnosticsProducer interface {
+	// javacDiagnosticsPaths returns the files that the javac diagnostics are written to, and the
+	// outputs of the rules that write them.
+	javacDiagnosticsPaths() (android.Paths, android.Paths)
+}
+
+func (j *Module) javacDiagnosticsPaths() (android.Paths, android.Paths) {
+	return j.javacDiagnostics, j.javacDiagnosticsDeps
+}
+
+var _ javacDiagnosticsProducer = (*Module)(nil)
+
+func javaDiagnosticsReportSingletonFactory() android.Singleton {
+	return &javaDiagnosticsReportSingleton{}
+}
+
+type javaDiagnosticsReportSingleton struct {
+	report android.WritablePath
+}
+
+func (s *javaDiagnosticsReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !javaDiagnosticsReportEnabled(ctx.Config()) {
+		return
+	}
+
+	// Each line of the manifest contains the tab separated module name, OWNERS files, kind and path
+	// of a diagnostics file, see cmd/java_diagnostics_report.
+	var manifest []string
+	var deps android.Paths
+	ownersFilesByDir := make(map[string]android.Paths)
+	add := func(m android.Module, kind string, path android.Path) {
+		dir := ctx.ModuleDir(m)
+		ownersFiles, ok := ownersFilesByDir[dir]
+		if !ok {
+			ownersFiles = javaDiagnosticsOwnersFiles(ctx, dir)
+			ownersFilesByDir[dir] = ownersFiles
+			deps = append(deps, ownersFiles...)
+		}
+		manifest = append(manifest, strings.Join([]string{ctx.ModuleName(m),
+			strings.Join(ownersFiles.Strings(), " "), kind, path.String()}, "\t"))
+	}
+
+	ctx.VisitAllModules(func(m android.Module) {
+		if !m.Enabled() {
+			return
+		}
+		if producer, ok := m.(javacDiagnosticsProducer); ok {
+			files, fileDeps := producer.javacDiagnosticsPaths()
+			for _, file := range files {
+				add(m, "javac", file)
+			}
+			deps = append(deps, fileDeps...)
+		}
+		if l, ok := m.(lintOutputsIntf); ok && isLintReportedModule(ctx, m) {
+			if xml := l.lintOutputs().xml; xml != nil {
+				add(m, "lint", xml)
+				deps = append(deps, xml)
+			}
+		}
+	})
+
+	if len(manifest) == 0 {
+		return
+	}
+
+	manifestFile := android.PathForOutput(ctx, "java_diagnostics_report.manifest")
+	android.WriteFileRule(ctx, manifestFile, strings.Join(manifest, "\n"))
+
+	s.report = android.PathForOutput(ctx, "java_diagnostics_report.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        javaDiagnosticsReportRule,
+		Description: "java diagnostics report",
+		Input:       manifestFile,
+		Implicits:   android.SortedUniquePaths(deps),
+		Output:      s.report,
+	})
+	ctx.Phony("java-diagnostics-report", s.report)
+}
+
+// javaDiagnosticsOwnersFiles returns the OWNERS files that apply to dir, from the one in dir itself
+// up to the one at the top of the source tree.
+func javaDiagnosticsOwnersFiles(ctx android.PathContext, dir string) android.Paths {
+	var ret android.Paths
+	for {
+		if owners := android.ExistentPathForSource(ctx, dir, "OWNERS"); owners.Valid() {
+			ret = append(ret, owners.Path())
+		}
+		if dir == "." || dir == "/" || dir == "" {
+			return ret
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func (s *javaDiagnosticsReportSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if s.report != nil {
+		ctx.DistForGoal("java-diagnostics-report", s.report)
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*javaDiagnosticsReportSingleton)(nil)
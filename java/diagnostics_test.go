@@ -0,0 +1,103 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestJavaDiagnosticsReport(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+	`
+	barBp := `
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			sdk_version: "current",
+			lint: {
+				enabled: false,
+			},
+		}
+	`
+
+	t.Run("disabled", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			PrepareForTestWithJavaDefaultModules,
+			android.FixtureAddTextFile("bar/Android.bp", barBp),
+			android.FixtureAddFile("bar/b.java", nil),
+		).RunTestWithBp(t, bp)
+
+		javac := result.ModuleForTests("foo", "android_common").Rule("javac")
+		android.AssertStringEquals(t, "javac wrapper flags", "", javac.Args["javacWrapperFlags"])
+		android.AssertStringEquals(t, "diagnostics file", "", javac.Args["diagnosticsFile"])
+
+		report := result.SingletonForTests("java_diagnostics_report").MaybeOutput("java_diagnostics_report.txt")
+		if report.Rule != nil {
+			t.Errorf("expected no java diagnostics report")
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			PrepareForTestWithJavaDefaultModules,
+			android.FixtureMergeEnv(map[string]string{
+				"JAVA_DIAGNOSTICS_REPORT": "true",
+			}),
+			android.FixtureAddTextFile("bar/Android.bp", barBp),
+			android.FixtureAddFile("bar/b.java", nil),
+			android.FixtureAddTextFile("OWNERS", "top@google.com\n"),
+			android.FixtureAddTextFile("bar/OWNERS", "bar@google.com\n"),
+		).RunTestWithBp(t, bp)
+
+		foo := result.ModuleForTests("foo", "android_common")
+		android.AssertStringEquals(t, "javac wrapper flags",
+			"--diagnostics_file=out/soong/.intermediates/foo/android_common/javac/diagnostics.txt",
+			android.StringRelativeToTop(result.Config, foo.Rule("javac").Args["javacWrapperFlags"]))
+		// The diagnostics of a previous compile are removed before compiling.
+		android.AssertStringEquals(t, "diagnostics file",
+			"out/soong/.intermediates/foo/android_common/javac/diagnostics.txt",
+			android.StringRelativeToTop(result.Config, foo.Rule("javac").Args["diagnosticsFile"]))
+
+		singleton := result.SingletonForTests("java_diagnostics_report")
+		manifest := strings.Split(android.ContentFromFileRuleForTests(t,
+			singleton.Output("java_diagnostics_report.manifest")), "\n")
+		manifest = android.StringsRelativeToTop(result.Config, manifest)
+		android.AssertStringListContains(t, "manifest", manifest,
+			"foo\tOWNERS\tjavac\tout/soong/.intermediates/foo/android_common/javac/diagnostics.txt")
+		android.AssertStringListContains(t, "manifest", manifest,
+			"foo\tOWNERS\tlint\tout/soong/.intermediates/foo/android_common/lint/lint-report.xml")
+		android.AssertStringListContains(t, "manifest", manifest,
+			"bar\tbar/OWNERS OWNERS\tjavac\tout/soong/.intermediates/bar/android_common/javac/diagnostics.txt")
+		android.AssertStringListDoesNotContain(t, "manifest", manifest,
+			"bar\tbar/OWNERS OWNERS\tlint\tout/soong/.intermediates/bar/android_common/lint/lint-report.xml")
+
+		report := singleton.Output("java_diagnostics_report.txt")
+		implicits := report.Implicits.RelativeToTop().Strings()
+		android.AssertStringListContains(t, "implicits", implicits,
+			"out/soong/.intermediates/foo/android_common/javac/foo.jar")
+		android.AssertStringListContains(t, "implicits", implicits,
+			"out/soong/.intermediates/foo/android_common/lint/lint-report.xml")
+		android.AssertStringListContains(t, "implicits", implicits, "OWNERS")
+		android.AssertStringListContains(t, "implicits", implicits, "bar/OWNERS")
+	})
+}
@@ -17,6 +17,7 @@ package java
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/google/blueprint/proptools"
@@ -144,6 +145,14 @@ type DroidstubsProperties struct {
 	// the filename which Metalava extracts API levels annotations from. Defaults to android.jar.
 	Api_levels_jar_filename *string
 
+	// the API signature files of the finalized API levels, oldest first, which Metalava generates
+	// the API levels database from together with the current sources, instead of the jars in
+	// api_levels_annotations_dirs. Usually a <module>.api.<scope>.all module created by
+	// prebuilt_apis, e.g. ":android.api.public.all". Metalava numbers the API levels from the
+	// order of the files, so each file must be in a <level>/<scope>/api directory and the levels
+	// must be contiguous.
+	Api_levels_signature_files []string `android:"path"`
+
 	// if set to true, collect the values used by the Dev tools and
 	// write them in files packaged with the SDK. Defaults to false.
 	Write_sdk_values *bool
@@ -364,6 +373,28 @@ func (d *Droidstubs) inclusionAnnotationsFlags(ctx android.ModuleContext, cmd *a
 	})
 }
 
+// checkApiLevelsSignatureFiles reports an error if the API signature files aren't of contiguous API
+// levels, oldest first, as metalava assigns the API levels from their order. The level of a file is
+// read from its <level>/<scope>/api/<module>.txt path.
+func checkApiLevelsSignatureFiles(ctx android.ModuleContext, signatureFiles android.Paths) {
+	prevLevel := 0
+	for i, f := range signatureFiles {
+		levelDir := filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(f.String()))))
+		level, err := strconv.Atoi(levelDir)
+		if err != nil {
+			ctx.PropertyErrorf("api_levels_signature_files",
+				"%q is not in a <level>/<scope>/api directory", f)
+			return
+		}
+		if i > 0 && level != prevLevel+1 {
+			ctx.PropertyErrorf("api_levels_signature_files",
+				"API levels must be contiguous, found %d after %d in %q", level, prevLevel, f)
+			return
+		}
+		prevLevel = level
+	}
+}
+
 func (d *Droidstubs) apiLevelsAnnotationsFlags(ctx android.ModuleContext, cmd *android.RuleBuilderCommand) {
 	if !Bool(d.properties.Api_levels_annotations_enabled) {
 		return
@@ -371,7 +402,8 @@ func (d *Droidstubs) apiLevelsAnnotationsFlags(ctx android.ModuleContext, cmd *a
 
 	d.apiVersionsXml = android.PathForModuleOut(ctx, "metalava", "api-versions.xml")
 
-	if len(d.properties.Api_levels_annotations_dirs) == 0 {
+	signatureFiles := android.PathsForModuleSrc(ctx, d.properties.Api_levels_signature_files)
+	if len(d.properties.Api_levels_annotations_dirs) == 0 && len(signatureFiles) == 0 {
 		ctx.PropertyErrorf("api_levels_annotations_dirs",
 			"has to be non-empty if api levels annotations was enabled!")
 	}
@@ -381,6 +413,12 @@ func (d *Droidstubs) apiLevelsAnnotationsFlags(ctx android.ModuleContext, cmd *a
 	cmd.FlagWithArg("--current-version ", ctx.Config().PlatformSdkVersion().String())
 	cmd.FlagWithArg("--current-codename ", ctx.Config().PlatformSdkCodename())
 
+	if len(signatureFiles) > 0 {
+		checkApiLevelsSignatureFiles(ctx, signatureFiles)
+		cmd.FlagWithInputList("--api-version-signature-files ", signatureFiles, ":")
+		return
+	}
+
 	filename := proptools.StringDefault(d.properties.Api_levels_jar_filename, "android.jar")
 
 	var dirs []string
@@ -152,6 +152,54 @@ func TestModuleLibDroidstubs(t *testing.T) {
 	}, patterns)
 }
 
+func TestDroidstubsApiLevelsSignatureFiles(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		FixtureWithPrebuiltApis(map[string][]string{
+			"30":      {},
+			"31":      {},
+			"current": {},
+		}),
+		android.FixtureAddFile("foo-doc/a.java", nil),
+	).RunTestWithBp(t, `
+		droidstubs {
+			name: "foo-stubs",
+			srcs: ["foo-doc/a.java"],
+			api_levels_annotations_enabled: true,
+			api_levels_signature_files: [":android.api.public.all"],
+		}
+	`)
+
+	m := result.ModuleForTests("foo-stubs", "android_common")
+	manifest := m.Output("metalava.sbox.textproto")
+	cmd := String(android.RuleBuilderSboxProtoForTests(t, manifest).Commands[0].Command)
+	android.AssertStringDoesContain(t, "metalava command", cmd,
+		"--api-version-signature-files prebuilts/sdk/30/public/api/android.txt:prebuilts/sdk/31/public/api/android.txt")
+	android.AssertStringDoesNotContain(t, "metalava command", cmd, "--android-jar-pattern")
+	android.AssertStringDoesContain(t, "metalava command", cmd, "--generate-api-levels")
+}
+
+func TestDroidstubsApiLevelsSignatureFilesNotContiguous(t *testing.T) {
+	android.GroupFixturePreparers(
+		prepareForJavaTest,
+		FixtureWithPrebuiltApis(map[string][]string{
+			"30":      {},
+			"32":      {},
+			"current": {},
+		}),
+		android.FixtureAddFile("foo-doc/a.java", nil),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`api_levels_signature_files: API levels must be contiguous, found 32 after 30`,
+	)).RunTestWithBp(t, `
+		droidstubs {
+			name: "foo-stubs",
+			srcs: ["foo-doc/a.java"],
+			api_levels_annotations_enabled: true,
+			api_levels_signature_files: [":android.api.public.all"],
+		}
+	`)
+}
+
 func TestDroidstubsSandbox(t *testing.T) {
 	ctx, _ := testJavaWithFS(t, `
 		genrule {
@@ -0,0 +1,162 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// The host ART test runs the JUnit tests of a device java_test with dalvikvm on the build host,
+// using the host variant of the ART boot image, so that libcore-style tests can be run without a
+// device. The test classes that are run are listed in test_options.host_art_test_classes.
+//
+// The test is run by building the <module>-host-art phony target, its output is written to
+// host_art/test.log in the module's output directory.
+
+// hostArtTestSuffix is the suffix of the phony target that runs a test on the host ART runtime.
+const hostArtTestSuffix = "-host-art"
+
+var (
+	hostArtTestLibTag = dependencyTag{name: "host-art-test-lib"}
+	hostArtRuntimeTag = dependencyTag{name: "host-art-runtime"}
+
+	// The libraries that are added to the classpath of the test, which provide the JUnit runner.
+	// They are usually static libraries that are not compiled to dex, so they are dexed for the
+	// test.
+	hostArtTestLibs = []string{"junit"}
+
+	// The host ART runtime, i.e. dalvikvm and the libraries that it loads when it starts.
+	hostArtRuntimeBinaries = []string{"dalvikvm"}
+	hostArtRuntimeLibs     = []string{"libart", "libjavacore", "libopenjdk", "libopenjdkjvm", "libicu_jni"}
+)
+
+// hostArtTestDeps adds the dependencies on the JUnit runner and on the host ART runtime.
+func (j *Test) hostArtTestDeps(ctx android.BottomUpMutatorContext) {
+	ctx.AddVariationDependencies(nil, hostArtTestLibTag, hostArtTestLibs...)
+
+	hostVariations := ctx.Config().BuildOSTarget.Variations()
+	ctx.AddFarVariationDependencies(hostVariations, hostArtRuntimeTag, hostArtRuntimeBinaries...)
+	ctx.AddFarVariationDependencies(append(hostVariations, blueprint.Variation{Mutator: "link", Variation: "shared"}),
+		hostArtRuntimeTag, hostArtRuntimeLibs...)
+}
+
+// buildHostArtTest generates the rules to run the test on the host ART runtime.
+func (j *Test) buildHostArtTest(ctx android.ModuleContext) {
+	dexJar := j.dexJarFile.PathOrNil()
+	if dexJar == nil {
+		ctx.PropertyErrorf("test_options.run_on_host_art", "requires the test to be compiled to dex")
+		return
+	}
+	testClasses := j.testProperties.Test_options.Host_art_test_classes
+	if len(testClasses) == 0 {
+		ctx.PropertyErrorf("test_options.host_art_test_classes",
+			"must list the test classes to run with run_on_host_art")
+		return
+	}
+
+	image := artBootImageConfig(ctx).getVariant(ctx.Config().BuildOSTarget)
+	if image == nil {
+		ctx.PropertyErrorf("test_options.run_on_host_art", "there is no host ART boot image for %s",
+			ctx.Config().BuildOSTarget.String())
+		return
+	}
+	imageLocations, _ := image.imageLocations()
+
+	var libJars android.Paths
+	ctx.VisitDirectDepsWithTag(hostArtTestLibTag, func(dep android.Module) {
+		if !ctx.OtherModuleHasProvider(dep, JavaInfoProvider) {
+			ctx.PropertyErrorf("test_options.run_on_host_art", "%q is not a java library",
+				ctx.OtherModuleName(dep))
+			return
+		}
+		libJars = append(libJars, ctx.OtherModuleProvider(dep, JavaInfoProvider).(JavaInfo).ImplementationAndResourcesJars...)
+	})
+	classpath := android.Paths{dexJar, j.dexHostArtTestLibs(ctx, libJars)}
+
+	// The runtime is run from its installed location so that it finds its libraries.
+	var dalvikvm android.OptionalPath
+	var runtimeFiles android.Paths
+	ctx.VisitDirectDepsWithTag(hostArtRuntimeTag, func(dep android.Module) {
+		runtimeFiles = append(runtimeFiles, dep.FilesToInstall().Paths()...)
+		if android.InList(ctx.OtherModuleName(dep), hostArtRuntimeBinaries) {
+			if tool, ok := dep.(android.HostToolProvider); ok {
+				dalvikvm = tool.HostToolPath()
+			}
+		}
+	})
+	if !dalvikvm.Valid() {
+		ctx.PropertyErrorf("test_options.run_on_host_art", "%q is not a host tool",
+			strings.Join(hostArtRuntimeBinaries, ", "))
+		return
+	}
+
+	// The runtime looks for the ART, i18n and tzdata modules relative to these roots.
+	hostOut := android.PathForOutput(ctx, "host", ctx.Config().PrebuiltOS())
+
+	testLog := android.PathForModuleOut(ctx, "host_art", "test.log")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().Text("rm -f").Output(testLog)
+	rule.Command().
+		Textf("ANDROID_ROOT=%s", hostOut.String()).
+		Textf("ANDROID_ART_ROOT=%s", hostOut.Join(ctx, "com.android.art").String()).
+		Textf("ANDROID_I18N_ROOT=%s", hostOut.Join(ctx, "com.android.i18n").String()).
+		Textf("ANDROID_TZDATA_ROOT=%s", hostOut.Join(ctx, "com.android.tzdata").String()).
+		Tool(dalvikvm.Path()).
+		FlagWithInputList("-Xbootclasspath:", image.dexPathsDeps.Paths(), ":").
+		FlagWithList("-Xbootclasspath-locations:", image.dexLocationsDeps, ":").
+		FlagWithArg("-Ximage:", strings.Join(imageLocations, ":")).
+		Implicits(image.imagesDeps.Paths()).
+		Implicits(runtimeFiles).
+		FlagWithInputList("-cp ", classpath, ":").
+		Text("org.junit.runner.JUnitCore").
+		Flags(testClasses).
+		Text(">").Output(testLog).Text("2>&1").
+		Text("|| (cat").Text(testLog.String()).Text("&& exit 1)")
+
+	rule.Build("host_art_test", "run "+ctx.ModuleName()+" on host ART")
+
+	ctx.Phony(ctx.ModuleName()+hostArtTestSuffix, testLog)
+}
+
+// dexHostArtTestLibs dexes the implementation jars of the libraries added to the classpath of the
+// test into a single dex jar. They only run on the host ART runtime, which is built from the same
+// tree, so they are dexed for the current API level and don't need to be desugared.
+func (j *Test) dexHostArtTestLibs(ctx android.ModuleContext, jars android.Paths) android.Path {
+	combinedJar := android.PathForModuleOut(ctx, "host_art", "combined", "test_libs.jar")
+	TransformJarsToJar(ctx, combinedJar, "for host ART", jars, android.OptionalPath{}, false, nil, nil)
+
+	dexJar := android.PathForModuleOut(ctx, "host_art", "dex", "test_libs.jar")
+	minApi := ctx.Config().PlatformSdkVersion().FinalOrFutureInt()
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        d8,
+		Description: "d8 for host ART",
+		Output:      dexJar,
+		Input:       combinedJar,
+		Args: map[string]string{
+			"d8Flags":        "--no-desugaring --min-api " + strconv.Itoa(minApi),
+			"zipFlags":       "--ignore_missing_files",
+			"outDir":         android.PathForModuleOut(ctx, "host_art", "dex").String(),
+			"tmpJar":         android.PathForModuleOut(ctx, "host_art", "withoutdex", "test_libs.jar").String(),
+			"mergeZipsFlags": "",
+		},
+	})
+	return dexJar
+}
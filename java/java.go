@@ -21,6 +21,7 @@ package java
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"android/soong/bazel"
@@ -455,6 +456,10 @@ type deps struct {
 	kotlinAnnotations       android.Paths
 	kotlinPlugins           android.Paths
 
+	// declaredLibs is the list of dependencies from the libs and static_libs properties, used
+	// to report the ones that aren't referenced by the module.
+	declaredLibs []declaredJavaDep
+
 	disableTurbine bool
 }
 
@@ -486,6 +491,7 @@ const (
 	JAVA_VERSION_8           = 8
 	JAVA_VERSION_9           = 9
 	JAVA_VERSION_11          = 11
+	JAVA_VERSION_17          = 17
 )
 
 func (v javaVersion) String() string {
@@ -500,11 +506,24 @@ func (v javaVersion) String() string {
 		return "1.9"
 	case JAVA_VERSION_11:
 		return "11"
+	case JAVA_VERSION_17:
+		return "17"
 	default:
 		return "unsupported"
 	}
 }
 
+// Returns the version in the form accepted by javac's --release flag, which does not accept the
+// legacy "1.x" names.
+func (v javaVersion) releaseString() string {
+	switch v {
+	case JAVA_VERSION_UNSUPPORTED:
+		return "unsupported"
+	default:
+		return strconv.Itoa(int(v))
+	}
+}
+
 // Returns true if javac targeting this version uses system modules instead of a bootclasspath.
 func (v javaVersion) usesJavaModules() bool {
 	return v >= 9
@@ -522,6 +541,8 @@ func normalizeJavaVersion(ctx android.BaseModuleContext, javaVersion string) jav
 		return JAVA_VERSION_9
 	case "11":
 		return JAVA_VERSION_11
+	case "17":
+		return JAVA_VERSION_17
 	case "10":
 		ctx.PropertyErrorf("java_version", "Java language levels 10 is not supported")
 		return JAVA_VERSION_UNSUPPORTED
@@ -603,6 +624,7 @@ func (j *Library) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	j.dexpreopter.uncompressedDex = *j.dexProperties.Uncompress_dex
 	j.classLoaderContexts = j.usesLibrary.classLoaderContextForUsesLibDeps(ctx)
 	j.compile(ctx, nil)
+	j.dexpreopter.provideBootImageProfileHints(ctx)
 
 	// Collect the module directory for IDE info in java/jdeps.go.
 	j.modulePaths = append(j.modulePaths, ctx.ModuleDir())
@@ -630,6 +652,7 @@ func (j *Library) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 			installDir = android.PathForModuleInstall(ctx, "framework")
 		}
 		j.installFile = ctx.InstallFile(installDir, j.Stem()+".jar", j.outputFile, extraInstallDeps...)
+		android.CheckReproducibleOutput(ctx, android.ReproducibleZip, j.outputFile, "")
 	}
 }
 
@@ -812,6 +835,14 @@ type TestOptions struct {
 
 	// If the test is a hostside(no device required) unittest that shall be run during presubmit check.
 	Unit_test *bool
+
+	// If true, a <module>-host-art target is generated that runs the device test with the host ART
+	// runtime and the host boot image, so that it can be run without a device.
+	Run_on_host_art *bool
+
+	// The fully qualified names of the test classes that the <module>-host-art target runs.
+	// Required with run_on_host_art.
+	Host_art_test_classes []string
 }
 
 type testProperties struct {
@@ -940,6 +971,16 @@ func (j *JavaTestImport) InstallInTestcases() bool {
 	return true
 }
 
+// Overrides android.ApexModuleBase.IsTestModule
+func (j *Test) IsTestModule() bool {
+	return true
+}
+
+// Overrides android.ApexModuleBase.IsTestModule
+func (j *JavaTestImport) IsTestModule() bool {
+	return true
+}
+
 func (j *TestHost) addDataDeviceBinsDeps(ctx android.BottomUpMutatorContext) {
 	if len(j.testHostProperties.Data_device_bins_first) > 0 {
 		deviceVariations := ctx.Config().AndroidFirstDeviceTarget.Variations()
@@ -1017,6 +1058,14 @@ func (j *TestHost) addDataDeviceBinsDeps(ctx android.BottomUpMutatorContext) {
 	}
 }
 
+func (j *Test) DepsMutator(ctx android.BottomUpMutatorContext) {
+	j.Library.DepsMutator(ctx)
+
+	if ctx.Device() && Bool(j.testProperties.Test_options.Run_on_host_art) {
+		j.hostArtTestDeps(ctx)
+	}
+}
+
 func (j *TestHost) DepsMutator(ctx android.BottomUpMutatorContext) {
 	if len(j.testHostProperties.Data_native_bins) > 0 {
 		for _, target := range ctx.MultiTargets() {
@@ -1129,6 +1178,10 @@ func (j *Test) generateAndroidBuildActionsWithConfig(ctx android.ModuleContext,
 	})
 
 	j.Library.GenerateAndroidBuildActions(ctx)
+
+	if ctx.Device() && Bool(j.testProperties.Test_options.Run_on_host_art) {
+		j.buildHostArtTest(ctx)
+	}
 }
 
 func (j *TestHelperLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
@@ -1219,6 +1272,7 @@ func TestFactory() android.Module {
 	module.Module.dexpreopter.isTest = true
 	module.Module.linter.test = true
 
+	android.InitApexModule(module)
 	android.InitSdkAwareModule(module)
 	InitJavaModule(module, android.HostAndDeviceSupported)
 	return module
@@ -1281,6 +1335,7 @@ func TestHostFactory() android.Module {
 		nil,
 		nil)
 
+	android.InitApexModule(module)
 	InitJavaModuleMultiTargets(module, android.HostSupported)
 
 	return module
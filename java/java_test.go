@@ -253,6 +253,20 @@ func TestSimple(t *testing.T) {
 	}
 }
 
+func TestJavaLibraryReproducibilityCheck(t *testing.T) {
+	ctx, _ := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+	`)
+
+	check := ctx.ModuleForTests("foo", "android_common").Output("reproducibility/foo.jar.checked")
+	android.AssertStringEquals(t, "check kind", "zip", check.Args["kind"])
+	android.AssertStringDoesContain(t, "check should expect the pinned timestamp", check.Args["flags"],
+		"--timestamp 2008-01-01T00:00:00")
+}
+
 func TestExportedPlugins(t *testing.T) {
 	type Result struct {
 		library        string
@@ -532,6 +546,98 @@ func TestHostBinaryNoJavaDebugInfoOverride(t *testing.T) {
 	}
 }
 
+func TestHostMultiReleaseJar(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java"],
+			multi_release: [
+				{
+					java_version: "17",
+					srcs: ["b.java"],
+				},
+			],
+		}
+	`)
+
+	buildOS := result.Config.BuildOS.String()
+	foo := result.ModuleForTests("foo", buildOS+"_common")
+
+	javac := foo.Output("javac/foo.jar")
+	android.AssertStringEquals(t, "javac version flags", "--release 11", javac.Args["javaVersionFlags"])
+
+	mrJavac := foo.Output("multi_release_17/classes/foo.jar")
+	android.AssertStringEquals(t, "multi-release javac version flags", "--release 17", mrJavac.Args["javaVersionFlags"])
+	android.AssertStringDoesContain(t, "multi-release classpath", mrJavac.Args["classpath"], "javac/foo.jar")
+
+	relocated := foo.Output("multi_release_17/foo.jar")
+	android.AssertStringEquals(t, "relocated version", "17", relocated.Args["version"])
+
+	combined := foo.Output("combined/foo.jar")
+	android.AssertPathsRelativeToTopEquals(t, "combined inputs", []string{
+		"out/soong/.intermediates/foo/" + buildOS + "_common/javac/foo.jar",
+		"out/soong/.intermediates/foo/" + buildOS + "_common/multi_release_17/foo.jar",
+	}, combined.Inputs)
+	android.AssertStringDoesContain(t, "combined manifest", combined.Args["jarArgs"], "manifest/multi_release.mf")
+}
+
+func TestJavaRelease(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library_host {
+			name: "host",
+			srcs: ["a.java"],
+			java_version: "11",
+		}
+
+		java_library_host {
+			name: "host_add_exports",
+			srcs: ["a.java"],
+			java_version: "11",
+			javacflags: ["--add-exports=java.base/sun.misc=ALL-UNNAMED"],
+		}
+
+		java_library {
+			name: "device",
+			srcs: ["a.java"],
+			sdk_version: "none",
+			system_modules: "none",
+			java_version: "11",
+		}
+	`)
+
+	buildOS := result.Config.BuildOS.String()
+	host := result.ModuleForTests("host", buildOS+"_common").Output("javac/host.jar")
+	android.AssertStringEquals(t, "host javac version flags", "--release 11", host.Args["javaVersionFlags"])
+	android.AssertStringEquals(t, "host javac system modules", "", host.Args["bootClasspath"])
+
+	addExports := result.ModuleForTests("host_add_exports", buildOS+"_common").Output("javac/host_add_exports.jar")
+	android.AssertStringEquals(t, "host javac version flags with --add-exports", "-source 11 -target 11",
+		addExports.Args["javaVersionFlags"])
+
+	// Device modules pass --system, which javac doesn't accept together with --release.
+	device := result.ModuleForTests("device", "android_common").Output("javac/device.jar")
+	android.AssertStringEquals(t, "device javac version flags", "-source 11 -target 11", device.Args["javaVersionFlags"])
+	android.AssertStringEquals(t, "device javac system modules", "--system=none", device.Args["bootClasspath"])
+}
+
+func TestHostMultiReleaseJarErrors(t *testing.T) {
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`must be newer than the java_version of the module`)).
+		RunTestWithBp(t, `
+			java_library_host {
+				name: "foo",
+				srcs: ["a.java"],
+				multi_release: [
+					{
+						java_version: "11",
+						srcs: ["b.java"],
+					},
+				],
+			}
+		`)
+}
+
 func TestPrebuilts(t *testing.T) {
 	ctx, _ := testJava(t, `
 		java_library {
@@ -1667,3 +1773,117 @@ func TestDataDeviceBinsBuildsDeviceBinary(t *testing.T) {
 		})
 	}
 }
+
+func TestJavaTestRunOnHostArt(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+			host_supported: true,
+			test_options: {
+				run_on_host_art: true,
+				host_art_test_classes: ["foo.FooTest", "foo.BarTest"],
+			},
+		}
+
+		java_library_static {
+			name: "junit",
+			srcs: ["b.java"],
+			host_supported: true,
+		}
+
+		cc_binary {
+			name: "dalvikvm",
+			host_supported: true,
+			compile_multilib: "both",
+			multilib: {
+				lib32: {
+					suffix: "32",
+				},
+				lib64: {
+					suffix: "64",
+				},
+			},
+		}
+
+		cc_defaults {
+			name: "host_art_lib_defaults",
+			host_supported: true,
+		}
+
+		cc_library_shared { name: "libart", defaults: ["host_art_lib_defaults"] }
+		cc_library_shared { name: "libjavacore", defaults: ["host_art_lib_defaults"] }
+		cc_library_shared { name: "libopenjdk", defaults: ["host_art_lib_defaults"] }
+		cc_library_shared { name: "libopenjdkjvm", defaults: ["host_art_lib_defaults"] }
+		cc_library_shared { name: "libicu_jni", defaults: ["host_art_lib_defaults"] }
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	buildOS := result.Config.BuildOS.String()
+	command := android.StringRelativeToTop(result.Config, foo.Output("host_art/test.log").RuleParams.Command)
+	android.AssertStringDoesContain(t, "command", command, "out/soong/host/"+buildOS+"-x86/bin/dalvikvm64 ")
+	android.AssertStringDoesContain(t, "command", command,
+		"-cp out/soong/.intermediates/foo/android_common/dex/foo.jar:"+
+			"out/soong/.intermediates/foo/android_common/host_art/dex/test_libs.jar "+
+			"org.junit.runner.JUnitCore foo.FooTest foo.BarTest >")
+
+	implicits := foo.Output("host_art/test.log").Implicits.Strings()
+	android.AssertStringListContains(t, "runtime", implicits, "out/soong/host/"+buildOS+"-x86/bin/dalvikvm64")
+	android.AssertStringListContains(t, "runtime", implicits, "out/soong/host/"+buildOS+"-x86/lib64/libart.so")
+
+	// junit isn't compiled to dex, so the test dexes it.
+	junit := result.ModuleForTests("junit", "android_common")
+	if rule := junit.MaybeOutput("dex/junit.jar").Rule; rule != nil {
+		t.Errorf("expected junit not to be dexed, got %q", rule)
+	}
+	combined := foo.Output("host_art/combined/test_libs.jar")
+	android.AssertPathsRelativeToTopEquals(t, "test libs", []string{
+		"out/soong/.intermediates/junit/android_common/javac/junit.jar",
+	}, combined.Inputs)
+	d8 := foo.Output("host_art/dex/test_libs.jar")
+	android.AssertPathRelativeToTopEquals(t, "d8 input",
+		"out/soong/.intermediates/foo/android_common/host_art/combined/test_libs.jar", d8.Input)
+	android.AssertStringDoesContain(t, "d8 flags", d8.Args["d8Flags"], "--no-desugaring")
+
+	// The host variant runs on the host JVM already.
+	host := result.ModuleForTests("foo", result.Config.BuildOSCommonTarget.String())
+	if rule := host.MaybeOutput("host_art/test.log").Rule; rule != nil {
+		t.Errorf("expected no host ART test for the host variant, got %q", rule)
+	}
+}
+
+func TestJavaTestRunOnHostArtWithoutTestClasses(t *testing.T) {
+	PrepareForTestWithJavaDefaultModules.
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`host_art_test_classes: must list the test classes to run with run_on_host_art`)).
+		RunTestWithBp(t, `
+			java_test {
+				name: "foo",
+				srcs: ["a.java"],
+				test_options: {
+					run_on_host_art: true,
+				},
+			}
+
+			java_library_static {
+				name: "junit",
+				srcs: ["b.java"],
+			}
+
+			cc_binary {
+				name: "dalvikvm",
+				host_supported: true,
+			}
+
+			cc_defaults {
+				name: "host_art_lib_defaults",
+				host_supported: true,
+			}
+
+			cc_library_shared { name: "libart", defaults: ["host_art_lib_defaults"] }
+			cc_library_shared { name: "libjavacore", defaults: ["host_art_lib_defaults"] }
+			cc_library_shared { name: "libopenjdk", defaults: ["host_art_lib_defaults"] }
+			cc_library_shared { name: "libopenjdkjvm", defaults: ["host_art_lib_defaults"] }
+			cc_library_shared { name: "libicu_jni", defaults: ["host_art_lib_defaults"] }
+		`)
+}
@@ -567,6 +567,25 @@ func copiedAPIVersionsXmlPath(ctx android.PathContext, name string) android.Writ
 	return android.PathForOutput(ctx, "lint", name)
 }
 
+// isLintReportedModule returns true if the lint outputs of the module should be included in the
+// reports that combine the lint outputs of all modules.
+func isLintReportedModule(ctx android.SingletonContext, m android.Module) bool {
+	if ctx.Config().KatiEnabled() && !m.ExportedToMake() {
+		return false
+	}
+
+	if apex, ok := m.(android.ApexModule); ok && apex.NotAvailableForPlatform() {
+		apexInfo := ctx.ModuleProvider(m, android.ApexInfoProvider).(android.ApexInfo)
+		if apexInfo.IsForPlatform() {
+			// There are stray platform variants of modules in apexes that are not available for
+			// the platform, and they sometimes can't be built.  Don't depend on them.
+			return false
+		}
+	}
+
+	return true
+}
+
 func (l *lintSingleton) generateLintReportZips(ctx android.SingletonContext) {
 	if ctx.Config().UnbundledBuild() {
 		return
@@ -575,19 +594,10 @@ func (l *lintSingleton) generateLintReportZips(ctx android.SingletonContext) {
 	var outputs []*lintOutputs
 	var dirs []string
 	ctx.VisitAllModules(func(m android.Module) {
-		if ctx.Config().KatiEnabled() && !m.ExportedToMake() {
+		if !isLintReportedModule(ctx, m) {
 			return
 		}
 
-		if apex, ok := m.(android.ApexModule); ok && apex.NotAvailableForPlatform() {
-			apexInfo := ctx.ModuleProvider(m, android.ApexInfoProvider).(android.ApexInfo)
-			if apexInfo.IsForPlatform() {
-				// There are stray platform variants of modules in apexes that are not available for
-				// the platform, and they sometimes can't be built.  Don't depend on them.
-				return
-			}
-		}
-
 		if l, ok := m.(lintOutputsIntf); ok {
 			outputs = append(outputs, l.lintOutputs())
 		}
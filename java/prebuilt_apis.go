@@ -17,6 +17,7 @@ package java
 import (
 	"fmt"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -135,6 +136,18 @@ func createApiModule(mctx android.LoadHookContext, name string, path string) {
 	mctx.CreateModule(genrule.GenRuleFactory, &genruleProps)
 }
 
+// createApiHistoryModule creates a filegroup of the api txt files of all the finalized versions of
+// a module, oldest first, from which metalava generates the api levels database.
+func createApiHistoryModule(mctx android.LoadHookContext, name string, paths []string) {
+	filegroupProps := struct {
+		Name *string
+		Srcs []string
+	}{}
+	filegroupProps.Name = proptools.StringPtr(name)
+	filegroupProps.Srcs = paths
+	mctx.CreateModule(android.FileGroupFactory, &filegroupProps)
+}
+
 func createEmptyFile(mctx android.LoadHookContext, name string) {
 	props := struct {
 		Name *string
@@ -223,9 +236,29 @@ func prebuiltApiFiles(mctx android.LoadHookContext, p *prebuiltApis) {
 	apiModuleName := func(module, scope, version string) string {
 		return module + ".api." + scope + "." + version
 	}
+	type apiHistoryEntry struct {
+		path    string
+		version int
+	}
+	history := make(map[string][]apiHistoryEntry)
 	for _, f := range apiLevelFiles {
 		module, version, scope := parseFinalizedPrebuiltPath(mctx, f)
 		createApiModule(mctx, apiModuleName(module, scope, strconv.Itoa(version)), f)
+		if !strings.HasSuffix(module, "incompatibilities") {
+			key := apiModuleName(module, scope, "all")
+			history[key] = append(history[key], apiHistoryEntry{f, version})
+		}
+	}
+
+	// Create <module>.api.<scope>.all modules for all the finalized versions of each module/scope
+	for _, name := range android.SortedStringKeys(history) {
+		entries := history[name]
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].version < entries[j].version })
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, entry.path)
+		}
+		createApiHistoryModule(mctx, name, paths)
 	}
 
 	// Figure out the latest version of each module/scope
@@ -303,7 +336,8 @@ func createPrebuiltApiModules(mctx android.LoadHookContext) {
 // Specifically, an API file located at ./<ver>/<scope>/api/<module>.txt
 // generates a module named <module>-api.<scope>.<ver>.
 //
-// It also creates <module>-api.<scope>.latest for the latest <ver>.
+// It also creates <module>-api.<scope>.latest for the latest <ver>, and a filegroup
+// <module>.api.<scope>.all of the API files of all <ver>s, oldest first.
 //
 // Similarly, it generates a java_import for all API .jar files found under the
 // directory where the Android.bp is located. Specifically, an API file located
@@ -90,3 +90,20 @@ func TestPrebuiltApis_WithExtensions(t *testing.T) {
 	android.AssertStringEquals(t, "Expected latest = api level 32", "prebuilts/sdk/32/public/api/foo.txt", foo_input)
 	android.AssertStringEquals(t, "Expected latest = api level 32", "prebuilts/sdk/32/public/api/bar.txt", bar_input)
 }
+
+func TestPrebuiltApis_ApiHistory(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		FixtureWithPrebuiltApis(map[string][]string{
+			"9":       {"foo"},
+			"10":      {"foo"},
+			"current": {"foo"},
+		}),
+	).RunTest(t)
+
+	history := result.ModuleForTests("foo.api.public.all", "").Module().(android.SourceFileProducer)
+	android.AssertPathsRelativeToTopEquals(t, "foo public api history", []string{
+		"prebuilts/sdk/9/public/api/foo.txt",
+		"prebuilts/sdk/10/public/api/foo.txt",
+	}, history.Srcs())
+}
@@ -20,9 +20,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
-	"sort"
 	"strings"
-	"sync"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
@@ -303,10 +301,6 @@ var (
 	}
 )
 
-var (
-	javaSdkLibrariesLock sync.Mutex
-)
-
 // TODO: these are big features that are currently missing
 // 1) disallowing linking to the runtime shared lib
 // 2) HTML generation
@@ -315,9 +309,7 @@ func init() {
 	RegisterSdkLibraryBuildComponents(android.InitRegistrationContext)
 
 	android.RegisterMakeVarsProvider(pctx, func(ctx android.MakeVarsContext) {
-		javaSdkLibraries := javaSdkLibraries(ctx.Config())
-		sort.Strings(*javaSdkLibraries)
-		ctx.Strict("JAVA_SDK_LIBRARIES", strings.Join(*javaSdkLibraries, " "))
+		ctx.Strict("JAVA_SDK_LIBRARIES", strings.Join(javaSdkLibraries.SortedGet(ctx.Config()), " "))
 	})
 
 	// Register sdk member types.
@@ -878,6 +870,14 @@ func (c *commonToSdkLibraryAndImport) selectHeaderJarsForSdkVersion(ctx android.
 		return PrebuiltJars(ctx, c.module.BaseModuleName(), sdkVersion)
 	}
 
+	// If the product requests it and the referencing module is part of an updatable APEX then it
+	// must only use the APIs that are available on the oldest release the APEX supports, so use
+	// the prebuilt versions of the sdk for the APEX's min_sdk_version.
+	if apexInfo := ctx.Provider(android.ApexInfoProvider).(android.ApexInfo); ctx.Config().ApexMinSdkVersionSdkLibraryStubs() &&
+		apexInfo.Updatable && !apexInfo.IsForPlatform() && !apexInfo.MinSdkVersion.IsPreview() {
+		return c.selectPrebuiltHeaderJarsForMinSdkVersion(ctx, sdkVersion.Kind, apexInfo)
+	}
+
 	paths := c.selectScopePaths(ctx, sdkVersion.Kind)
 	if paths == nil {
 		return nil
@@ -886,6 +886,37 @@ func (c *commonToSdkLibraryAndImport) selectHeaderJarsForSdkVersion(ctx android.
 	return paths.stubsHeaderPath
 }
 
+// selectPrebuiltHeaderJarsForMinSdkVersion returns the prebuilt stubs of this library for the
+// min_sdk_version of the APEX that the referencing module is part of.
+//
+// If the prebuilt stubs are not available for the requested kind then it will use the prebuilt
+// stubs of the closest kind which is a subset of the requested kind, in the same way as
+// selectScopePaths. It is an error if there are no prebuilt stubs for any compatible kind, e.g.
+// because the library was introduced after the min_sdk_version of the APEX.
+func (c *commonToSdkLibraryAndImport) selectPrebuiltHeaderJarsForMinSdkVersion(ctx android.BaseModuleContext,
+	kind android.SdkKind, apexInfo android.ApexInfo) android.Paths {
+
+	baseName := c.module.BaseModuleName()
+	minSdkVersion := apexInfo.MinSdkVersion
+
+	var checked []string
+	for scope := sdkKindToApiScope(kind); scope != nil; scope = scope.extends {
+		jar := filepath.Join("prebuilts", "sdk", minSdkVersion.String(), scope.name, baseName+".jar")
+		if jarPath := android.ExistentPathForSource(ctx, jar); jarPath.Valid() {
+			return android.Paths{jarPath.Path()}
+		}
+		checked = append(checked, jar)
+	}
+
+	if ctx.Config().AllowMissingDependencies() {
+		return android.Paths{android.PathForSource(ctx, checked[0])}
+	}
+	ctx.ModuleErrorf("cannot use java_sdk_library %q from apex %q: no stubs compatible with the "+
+		"apex min_sdk_version %s are available, checked %q. Either raise the min_sdk_version of the "+
+		"apex or stop depending on %q.", baseName, apexInfo.ApexVariationName, minSdkVersion, checked, baseName)
+	return nil
+}
+
 // selectScopePaths returns the *scopePaths appropriate for the specific kind.
 //
 // If the module does not support the specific kind then it will return the *scopePaths for the
@@ -972,6 +1003,12 @@ func (c *commonToSdkLibraryAndImport) sharedLibrary() bool {
 	return proptools.BoolDefault(c.commonSdkLibraryProperties.Shared_library, true)
 }
 
+// UsedThroughStubs implements android.ApexStubsDependency. Modules outside of the APEX of the
+// library use its stubs.
+func (c *commonToSdkLibraryAndImport) UsedThroughStubs(externalDep bool) bool {
+	return externalDep
+}
+
 // Check if the stub libraries should be compiled for dex
 func (c *commonToSdkLibraryAndImport) stubLibrariesCompiledForDex() bool {
 	// Always compile the dex file files for the stub libraries if they will be used on the
@@ -1138,7 +1175,7 @@ func (module *SdkLibrary) CheckMinSdkVersion(ctx android.ModuleContext) {
 		ctx.WalkDeps(func(child android.Module, parent android.Module) bool {
 			isExternal := !module.depIsInSameApex(ctx, child)
 			if am, ok := child.(android.ApexModule); ok {
-				if !do(ctx, parent, am, isExternal) {
+				if !do(ctx, parent, am, isExternal, ctx.OtherModuleDependencyTag(child), ctx.GetWalkPath()) {
 					return false
 				}
 			}
@@ -1274,6 +1311,61 @@ func (module *SdkLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext)
 	// Make the set of components exported by this module available for use elsewhere.
 	exportedComponentInfo := android.ExportedComponentsInfo{Components: android.SortedStringKeys(exportedComponents)}
 	ctx.SetProvider(android.ExportedComponentsInfoProvider, exportedComponentInfo)
+
+	// Check the java_sdk_library_import of this module, if any, against the source module.
+	ctx.VisitDirectDepsWithTag(android.PrebuiltDepTag, func(to android.Module) {
+		if prebuilt, ok := to.(*SdkLibraryImport); ok {
+			module.checkPrebuilt(ctx, prebuilt)
+		}
+	})
+}
+
+// checkPrebuilt creates a rule that checks that the API files and the permissions XML file of the
+// prebuilt of this module match the ones of the source module. The check is run as part of
+// checkbuild and by the <name>-check-prebuilt target.
+func (module *SdkLibrary) checkPrebuilt(ctx android.ModuleContext, prebuilt *SdkLibraryImport) {
+	type checkedFile struct {
+		description string
+		source      android.Path
+		prebuilt    android.Path
+	}
+	var checkedFiles []checkedFile
+	addCheckedFile := func(description string, source, prebuilt android.OptionalPath) {
+		if source.Valid() && prebuilt.Valid() && source.String() != prebuilt.String() {
+			checkedFiles = append(checkedFiles, checkedFile{description, source.Path(), prebuilt.Path()})
+		}
+	}
+
+	for _, apiScope := range allApiScopes {
+		sourcePaths := module.findScopePaths(apiScope)
+		prebuiltPaths := prebuilt.findScopePaths(apiScope)
+		if sourcePaths == nil || prebuiltPaths == nil {
+			continue
+		}
+		addCheckedFile(apiScope.name+" current_api", sourcePaths.currentApiFilePath, prebuiltPaths.currentApiFilePath)
+		addCheckedFile(apiScope.name+" removed_api", sourcePaths.removedApiFilePath, prebuiltPaths.removedApiFilePath)
+	}
+	if xml := prebuilt.xmlPermissionsFileModule; xml != nil {
+		addCheckedFile("permissions_xml", android.OptionalPathForPath(xml.outputFilePath), prebuilt.permissionsXml)
+	}
+
+	if len(checkedFiles) == 0 {
+		return
+	}
+
+	timestamp := android.PathForModuleOut(ctx, "check_prebuilt.timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	for _, f := range checkedFiles {
+		rule.Command().
+			Text("diff -u").Input(f.source).Input(f.prebuilt).
+			Textf(`|| (echo "error: the %s of %s does not match the source module %s" && exit 1)`,
+				f.description, ctx.OtherModuleName(prebuilt), ctx.ModuleName())
+	}
+	rule.Command().Text("touch").Output(timestamp)
+	rule.Build("check_prebuilt", "check prebuilt "+ctx.OtherModuleName(prebuilt))
+
+	ctx.CheckbuildFile(timestamp)
+	ctx.Phony(ctx.ModuleName()+"-check-prebuilt", timestamp)
 }
 
 func (module *SdkLibrary) AndroidMkEntries() []android.AndroidMkEntries {
@@ -1728,13 +1820,7 @@ func (module *SdkLibrary) SdkImplementationJars(ctx android.BaseModuleContext, s
 	return module.sdkJars(ctx, sdkVersion, false /*headerJars*/)
 }
 
-var javaSdkLibrariesKey = android.NewOnceKey("javaSdkLibraries")
-
-func javaSdkLibraries(config android.Config) *[]string {
-	return config.Once(javaSdkLibrariesKey, func() interface{} {
-		return &[]string{}
-	}).(*[]string)
-}
+var javaSdkLibraries = android.NewConfigStringList("javaSdkLibraries")
 
 func (module *SdkLibrary) getApiDir() string {
 	return proptools.StringDefault(module.sdkLibraryProperties.Api_dir, "api")
@@ -1819,10 +1905,7 @@ func (module *SdkLibrary) CreateInternalModules(mctx android.DefaultableHookCont
 		}
 
 		// record java_sdk_library modules so that they are exported to make
-		javaSdkLibraries := javaSdkLibraries(mctx.Config())
-		javaSdkLibrariesLock.Lock()
-		defer javaSdkLibrariesLock.Unlock()
-		*javaSdkLibraries = append(*javaSdkLibraries, module.BaseModuleName())
+		javaSdkLibraries.Add(mctx.Config(), module.BaseModuleName())
 	}
 
 	// Add the impl_only_libs and impl_only_static_libs *after* we're done using them in submodules.
@@ -1974,6 +2057,11 @@ type sdkLibraryImportProperties struct {
 
 	// If not empty, classes are restricted to the specified packages and their sub-packages.
 	Permitted_packages []string
+
+	// The permissions XML file of the library. It is installed to etc/permissions when the
+	// prebuilt is used and the source module does not exist. Otherwise the permissions XML file
+	// generated by the source module is used, and this file is checked against it.
+	Permissions_xml *string `android:"path"`
 }
 
 type SdkLibraryImport struct {
@@ -2007,6 +2095,11 @@ type SdkLibraryImport struct {
 	// Expected install file path of the source module(sdk_library)
 	// or dex implementation jar obtained from the prebuilt_apex, if any.
 	installFile android.Path
+
+	// The prebuilt permissions XML file, if any, and whether it has to be installed.
+	permissionsXml           android.OptionalPath
+	permissionsXmlInstallDir android.InstallPath
+	installPermissionsXml    bool
 }
 
 var _ SdkLibraryDependency = (*SdkLibraryImport)(nil)
@@ -2106,10 +2199,7 @@ func (module *SdkLibraryImport) createInternalModules(mctx android.DefaultableHo
 		}
 	}
 
-	javaSdkLibraries := javaSdkLibraries(mctx.Config())
-	javaSdkLibrariesLock.Lock()
-	defer javaSdkLibrariesLock.Unlock()
-	*javaSdkLibraries = append(*javaSdkLibraries, module.BaseModuleName())
+	javaSdkLibraries.Add(mctx.Config(), module.BaseModuleName())
 }
 
 func (module *SdkLibraryImport) createJavaImportForStubs(mctx android.DefaultableHookContext, apiScope *apiScope, scopeProperties *sdkLibraryScopeProperties) {
@@ -2121,8 +2211,11 @@ func (module *SdkLibraryImport) createJavaImportForStubs(mctx android.Defaultabl
 		Jars        []string
 		Prefer      *bool
 		Compile_dex *bool
+		Licenses    []string
 	}{}
 	props.Name = proptools.StringPtr(module.stubsLibraryModuleName(apiScope))
+	// The stubs carry the same license data as the java_sdk_library_import.
+	props.Licenses = module.Licenses()
 	props.Sdk_version = scopeProperties.Sdk_version
 	// Prepend any of the libs from the legacy public properties to the libs for each of the
 	// scopes to avoid having to duplicate them in each scope.
@@ -2144,12 +2237,14 @@ func (module *SdkLibraryImport) createJavaImportForStubs(mctx android.Defaultabl
 
 func (module *SdkLibraryImport) createPrebuiltStubsSources(mctx android.DefaultableHookContext, apiScope *apiScope, scopeProperties *sdkLibraryScopeProperties) {
 	props := struct {
-		Name   *string
-		Srcs   []string
-		Prefer *bool
+		Name     *string
+		Srcs     []string
+		Prefer   *bool
+		Licenses []string
 	}{}
 	props.Name = proptools.StringPtr(module.stubsSourceModuleName(apiScope))
 	props.Srcs = scopeProperties.Stub_srcs
+	props.Licenses = module.Licenses()
 	mctx.CreateModule(PrebuiltStubsSourcesFactory, &props)
 
 	// The stubs source is preferred if the java_sdk_library_import is preferred.
@@ -2194,7 +2289,25 @@ func (module *SdkLibraryImport) AndroidMkEntries() []android.AndroidMkEntries {
 	// don't need to install it. However, we need to add its dexpreopt outputs as sub-modules, if it
 	// is preopted.
 	dexpreoptEntries := module.dexpreopter.AndroidMkEntriesForApex()
-	return append(dexpreoptEntries, android.AndroidMkEntries{Disabled: true})
+	entriesList := append(dexpreoptEntries, android.AndroidMkEntries{Disabled: true})
+
+	// Install the prebuilt permissions XML file in place of the one the source module would have
+	// generated.
+	if module.installPermissionsXml && !module.IsHideFromMake() {
+		entriesList = append(entriesList, android.AndroidMkEntries{
+			Class:        "ETC",
+			OverrideName: module.xmlPermissionsModuleName(),
+			OutputFile:   module.permissionsXml,
+			ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+				func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+					entries.SetString("LOCAL_MODULE_TAGS", "optional")
+					entries.SetString("LOCAL_MODULE_PATH", module.permissionsXmlInstallDir.String())
+					entries.SetString("LOCAL_INSTALLED_MODULE_STEM", module.BaseModuleName()+".xml")
+				},
+			},
+		})
+	}
+	return entriesList
 }
 
 var _ android.ApexModule = (*SdkLibraryImport)(nil)
@@ -2287,6 +2400,15 @@ func (module *SdkLibraryImport) GenerateAndroidBuildActions(ctx android.ModuleCo
 		paths.removedApiFilePath = android.OptionalPathForModuleSrc(ctx, scopeProperties.Removed_api)
 	}
 
+	// The permissions XML file generated by the source module takes precedence over the prebuilt
+	// one.
+	module.permissionsXml = android.OptionalPathForModuleSrc(ctx, module.properties.Permissions_xml)
+	if module.permissionsXml.Valid() && module.sharedLibrary() && module.xmlPermissionsFileModule == nil {
+		ai := ctx.Provider(android.ApexInfoProvider).(android.ApexInfo)
+		module.installPermissionsXml = ai.IsForPlatform()
+		module.permissionsXmlInstallDir = android.PathForModuleInstall(ctx, "etc", "permissions")
+	}
+
 	if ctx.Device() {
 		// If this is a variant created for a prebuilt_apex then use the dex implementation jar
 		// obtained from the associated deapexer module.
@@ -908,6 +908,136 @@ func TestJavaSdkLibraryImport_Preferred(t *testing.T) {
 	})
 }
 
+func TestJavaSdkLibraryImport_CheckPrebuilt(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		FixtureWithLastReleaseApis("sdklib"),
+		android.MockFS{
+			"prebuilt/current.txt": nil,
+			"prebuilt/removed.txt": nil,
+			"prebuilt/sdklib.xml":  nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, `
+		java_sdk_library {
+			name: "sdklib",
+			srcs: ["a.java"],
+			sdk_version: "none",
+			system_modules: "none",
+			public: {
+				enabled: true,
+			},
+		}
+
+		java_sdk_library_import {
+			name: "sdklib",
+			prefer: true,
+			permissions_xml: "prebuilt/sdklib.xml",
+			public: {
+				jars: ["a.jar"],
+				current_api: "prebuilt/current.txt",
+				removed_api: "prebuilt/removed.txt",
+			},
+		}
+		`)
+
+	checkPrebuilt := result.ModuleForTests("sdklib", "android_common").Rule("check_prebuilt")
+	inputs := checkPrebuilt.Inputs.Strings()
+	android.AssertStringListContains(t, "check inputs", inputs, "prebuilt/current.txt")
+	android.AssertStringListContains(t, "check inputs", inputs, "prebuilt/removed.txt")
+	android.AssertStringListContains(t, "check inputs", inputs, "prebuilt/sdklib.xml")
+	android.AssertStringListContains(t, "check inputs", inputs, "out/soong/.intermediates/sdklib.xml/android_common/sdklib.xml")
+	android.AssertStringDoesContain(t, "check command", checkPrebuilt.RuleParams.Command,
+		"error: the public current_api of prebuilt_sdklib does not match the source module sdklib")
+
+	// The permissions XML file generated by the source module is installed.
+	prebuilt := result.ModuleForTests("prebuilt_sdklib", "android_common").Module()
+	for _, entries := range android.AndroidMkEntriesForTest(t, result.TestContext, prebuilt) {
+		if entries.Class == "ETC" {
+			t.Errorf("unexpected installation of the prebuilt permissions XML file")
+		}
+	}
+}
+
+func TestJavaSdkLibraryImport_PermissionsXml(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		android.MockFS{
+			"prebuilt/sdklib.xml": nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, `
+		java_sdk_library_import {
+			name: "sdklib",
+			permissions_xml: "prebuilt/sdklib.xml",
+			public: {
+				jars: ["a.jar"],
+			},
+		}
+		`)
+
+	prebuilt := result.ModuleForTests("sdklib", "android_common").Module()
+	var xmlEntries *android.AndroidMkEntries
+	entriesList := android.AndroidMkEntriesForTest(t, result.TestContext, prebuilt)
+	for i := range entriesList {
+		if entriesList[i].Class == "ETC" {
+			xmlEntries = &entriesList[i]
+		}
+	}
+	if xmlEntries == nil {
+		t.Fatalf("expected the prebuilt permissions XML file to be installed")
+	}
+	android.AssertStringEquals(t, "output file", "prebuilt/sdklib.xml", xmlEntries.OutputFile.String())
+	android.AssertDeepEquals(t, "module name", []string{"sdklib.xml"}, xmlEntries.EntryMap["LOCAL_MODULE"])
+	android.AssertDeepEquals(t, "installed stem", []string{"sdklib.xml"}, xmlEntries.EntryMap["LOCAL_INSTALLED_MODULE_STEM"])
+}
+
+func TestJavaSdkLibraryImport_Licenses(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		PrepareForTestWithJavaSdkLibraryFiles,
+		android.PrepareForTestWithLicenses,
+		android.PrepareForTestWithLicenseDefaultModules,
+		android.MockFS{
+			"prebuilt/sdklib.xml": nil,
+			"NOTICE":              nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, `
+		license {
+			name: "sdklib_license",
+			license_kinds: ["SPDX-license-identifier-Apache-2.0"],
+			license_text: ["NOTICE"],
+		}
+
+		java_sdk_library_import {
+			name: "sdklib",
+			licenses: ["sdklib_license"],
+			permissions_xml: "prebuilt/sdklib.xml",
+			public: {
+				jars: ["a.jar"],
+				stub_srcs: ["a.java"],
+			},
+		}
+		`)
+
+	for _, name := range []string{"sdklib", "sdklib.stubs", "sdklib.stubs.source"} {
+		module := result.ModuleForTests(name, "android_common").Module()
+		android.AssertDeepEquals(t, name+" license kinds", []string{"SPDX-license-identifier-Apache-2.0"},
+			module.EffectiveLicenseKinds())
+		android.AssertPathsRelativeToTopEquals(t, name+" license files", []string{"NOTICE"},
+			module.EffectiveLicenseFiles())
+	}
+
+	// The installed permissions XML file carries the license data of the java_sdk_library_import.
+	prebuilt := result.ModuleForTests("sdklib", "android_common").Module()
+	for _, entries := range android.AndroidMkEntriesForTest(t, result.TestContext, prebuilt) {
+		if entries.Class == "ETC" {
+			android.AssertDeepEquals(t, "permissions XML license kinds", []string{"SPDX-license-identifier-Apache-2.0"},
+				entries.EntryMap["LOCAL_LICENSE_KINDS"])
+		}
+	}
+}
+
 func TestJavaSdkLibraryEnforce(t *testing.T) {
 	partitionToBpOption := func(partition string) string {
 		switch partition {
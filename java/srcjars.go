@@ -0,0 +1,93 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// When a module compiles more than one srcjar, the srcjars are merged into a single srcjar
+// (available through the ".srcjar" output tag) along with a provenance file that lists the
+// producer of each of its entries. The merge is a validation of the javac rules, and fails,
+// naming both producers, when a class of the same package and name is provided by more than one
+// srcjar, instead of letting javac report duplicate classes in the temporary directories the
+// srcjars are extracted to.
+
+var mergeSrcJarsRule = pctx.AndroidStaticRule("mergeSrcJars",
+	blueprint.RuleParams{
+		Command:     `${config.MergeSrcJarsCmd} --module $module $srcJarArgs --provenance $provenance -o $out`,
+		CommandDeps: []string{"${config.MergeSrcJarsCmd}"},
+	},
+	"module", "srcJarArgs", "provenance")
+
+// mergeSrcJars creates a rule that merges the srcjars of the module, and returns the path to the
+// merged srcjar.
+func mergeSrcJars(ctx android.ModuleContext, srcJars android.Paths, producers map[string]string) android.Path {
+	merged := android.PathForModuleOut(ctx, "srcjars", ctx.ModuleName()+".srcjar")
+	provenance := android.PathForModuleOut(ctx, "srcjars", ctx.ModuleName()+".srcjar.provenance")
+
+	var srcJarArgs []string
+	for _, srcJar := range srcJars {
+		srcJarArgs = append(srcJarArgs, "--srcjar "+producers[srcJar.String()]+"="+srcJar.String())
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:           mergeSrcJarsRule,
+		Description:    "merge srcjars",
+		Output:         merged,
+		ImplicitOutput: provenance,
+		Inputs:         srcJars,
+		Args: map[string]string{
+			"module":     ctx.ModuleName(),
+			"srcJarArgs": strings.Join(srcJarArgs, " "),
+			"provenance": provenance.String(),
+		},
+	})
+
+	return merged
+}
+
+// srcJarProducers returns a description of the producer of each of the srcjars keyed by their
+// path, i.e. the module referenced in srcs that generated it, the source file itself, aapt or
+// this module.
+func (j *Module) srcJarProducers(ctx android.ModuleContext, srcJars android.Paths, aaptSrcJar android.Path) map[string]string {
+	fromModules := make(map[string]string)
+	for _, src := range j.properties.Srcs {
+		if module := android.SrcIsModule(src); module != "" {
+			for _, path := range android.PathsForModuleSrc(ctx, []string{src}) {
+				fromModules[path.String()] = ":" + module
+			}
+		}
+	}
+
+	producers := make(map[string]string, len(srcJars))
+	for _, srcJar := range srcJars {
+		path := srcJar.String()
+		if aaptSrcJar != nil && path == aaptSrcJar.String() {
+			producers[path] = "aapt"
+		} else if module, ok := fromModules[path]; ok {
+			producers[path] = module
+		} else if _, ok := srcJar.(android.SourcePath); ok {
+			producers[path] = path
+		} else {
+			producers[path] = ctx.ModuleName()
+		}
+	}
+	return producers
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestMergeSrcJars(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForJavaTest,
+		android.FixtureAddFile("b.srcjar", nil),
+	).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: [
+				"a.java",
+				":gen",
+				"b.srcjar",
+			],
+			sdk_version: "current",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: [
+				"a.java",
+				":gen",
+			],
+			sdk_version: "current",
+		}
+
+		genrule {
+			name: "gen",
+			cmd: "touch $(out)",
+			out: ["gen.srcjar"],
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	merge := foo.Output("srcjars/foo.srcjar")
+	android.AssertStringEquals(t, "srcjars",
+		"--srcjar :gen=out/soong/.intermediates/gen/gen/gen.srcjar --srcjar b.srcjar=b.srcjar",
+		android.StringRelativeToTop(result.Config, merge.Args["srcJarArgs"]))
+	android.AssertPathRelativeToTopEquals(t, "provenance",
+		"out/soong/.intermediates/foo/android_common/srcjars/foo.srcjar.provenance", merge.ImplicitOutput)
+
+	// The merge checks the srcjars before javac runs, without being one of its inputs.
+	javac := foo.Rule("javac")
+	android.AssertStringListContains(t, "javac order-only deps", javac.OrderOnly.Strings(), merge.Output.String())
+	android.AssertStringListDoesNotContain(t, "javac implicits", javac.Implicits.Strings(), merge.Output.String())
+
+	outputFiles, err := foo.Module().(*Library).OutputFiles(".srcjar")
+	android.AssertSame(t, "error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "merged srcjar output",
+		[]string{"out/soong/.intermediates/foo/android_common/srcjars/foo.srcjar"}, outputFiles)
+
+	// Modules with a single srcjar don't merge it.
+	bar := result.ModuleForTests("bar", "android_common")
+	android.AssertBoolEquals(t, "bar merges srcjars", false, bar.MaybeOutput("srcjars/bar.srcjar").Rule != nil)
+}
@@ -315,6 +315,10 @@ func registerRequiredBuildComponentsForTest(ctx android.RegistrationContext) {
 	RegisterSystemModulesBuildComponents(ctx)
 	registerSystemserverClasspathBuildComponents(ctx)
 	registerLintBuildComponents(ctx)
+	registerUnusedDepsBuildComponents(ctx)
+	registerJavaDiagnosticsBuildComponents(ctx)
+	registerDexpreoptChecksumsBuildComponents(ctx)
+	registerDexpreoptStatusBuildComponents(ctx)
 }
 
 // gatherRequiredDepsForTest gathers the module definitions used by
@@ -0,0 +1,128 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// The unused dependencies report lists the libs and static_libs of each java module that are not
+// referenced by any of the classes compiled from the module's sources. Each module produces its
+// own report (available through the ".unused_deps" output tag), and the java_unused_deps_report
+// singleton merges them into $OUT_DIR/soong/java_unused_deps.txt, which is built by the
+// java-unused-deps-report goal.
+
+func init() {
+	registerUnusedDepsBuildComponents(android.InitRegistrationContext)
+}
+
+func registerUnusedDepsBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterSingletonType("java_unused_deps_report", unusedDepsReportSingletonFactory)
+}
+
+var unusedDepsRule = pctx.AndroidStaticRule("unusedDeps",
+	blueprint.RuleParams{
+		Command: `${config.JavaUnusedDepsCmd} --jdeps ${config.JdepsCmd} --module $module ` +
+			`$classesJars $depsArgs -o $out`,
+		CommandDeps: []string{"${config.JavaUnusedDepsCmd}", "${config.JdepsCmd}"},
+	},
+	"module", "classesJars", "depsArgs")
+
+// declaredJavaDep is a dependency listed in the libs or static_libs property of a module.
+type declaredJavaDep struct {
+	name   string
+	static bool
+	jars   android.Paths
+}
+
+// buildUnusedDepsReport creates a rule that checks which of the declared dependencies provide
+// classes referenced from the given jars, and returns the path to the resulting report.
+func buildUnusedDepsReport(ctx android.ModuleContext, classesJars android.Paths, declaredDeps []declaredJavaDep) android.Path {
+	report := android.PathForModuleOut(ctx, "unused_deps", "unused_deps.txt")
+
+	var classesArgs, depsArgs []string
+	var implicits android.Paths
+	for _, jar := range classesJars {
+		classesArgs = append(classesArgs, "--classes "+jar.String())
+	}
+	for _, dep := range declaredDeps {
+		if len(dep.jars) == 0 {
+			continue
+		}
+		flag := "--lib"
+		if dep.static {
+			flag = "--static-lib"
+		}
+		depsArgs = append(depsArgs, flag+" "+dep.name+"="+strings.Join(dep.jars.Strings(), ","))
+		implicits = append(implicits, dep.jars...)
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        unusedDepsRule,
+		Description: "unused deps",
+		Output:      report,
+		Inputs:      classesJars,
+		Implicits:   implicits,
+		Args: map[string]string{
+			"module":      ctx.ModuleName(),
+			"classesJars": strings.Join(classesArgs, " "),
+			"depsArgs":    strings.Join(depsArgs, " "),
+		},
+	})
+
+	return report
+}
+
+type unusedDepsReportProducer interface {
+	unusedDepsReportPath() android.Path
+}
+
+func (j *Module) unusedDepsReportPath() android.Path {
+	return j.unusedDepsReport
+}
+
+var _ unusedDepsReportProducer = (*Module)(nil)
+
+func unusedDepsReportSingletonFactory() android.Singleton {
+	return &unusedDepsReportSingleton{}
+}
+
+type unusedDepsReportSingleton struct{}
+
+func (u *unusedDepsReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var reports android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() {
+			return
+		}
+		if producer, ok := module.(unusedDepsReportProducer); ok {
+			if report := producer.unusedDepsReportPath(); report != nil {
+				reports = append(reports, report)
+			}
+		}
+	})
+
+	if len(reports) == 0 {
+		return
+	}
+
+	aggregate := android.PathForOutput(ctx, "java_unused_deps.txt")
+	android.CatFileRule(ctx, android.SortedUniquePaths(reports), aggregate)
+	ctx.Phony("java-unused-deps-report", aggregate)
+}
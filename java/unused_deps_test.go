@@ -0,0 +1,70 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestUnusedDepsReport(t *testing.T) {
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["bar"],
+			static_libs: ["baz"],
+			sdk_version: "current",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			sdk_version: "current",
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+			sdk_version: "current",
+		}
+	`)
+
+	foo := result.ModuleForTests("foo", "android_common")
+	report := foo.Output("unused_deps/unused_deps.txt")
+
+	android.AssertStringEquals(t, "module", "foo", report.Args["module"])
+	android.AssertStringEquals(t, "classes jars", "--classes out/soong/.intermediates/foo/android_common/javac/foo.jar",
+		android.StringRelativeToTop(result.Config, report.Args["classesJars"]))
+	android.AssertStringEquals(t, "deps",
+		"--lib bar=out/soong/.intermediates/bar/android_common/turbine-combined/bar.jar "+
+			"--static-lib baz=out/soong/.intermediates/baz/android_common/turbine-combined/baz.jar",
+		android.StringRelativeToTop(result.Config, report.Args["depsArgs"]))
+
+	outputFiles, err := foo.Module().(*Library).OutputFiles(".unused_deps")
+	android.AssertSame(t, "error", nil, err)
+	android.AssertPathsRelativeToTopEquals(t, "unused deps output",
+		[]string{"out/soong/.intermediates/foo/android_common/unused_deps/unused_deps.txt"}, outputFiles)
+
+	// bar and baz have no dependencies to report on.
+	if rule := result.ModuleForTests("bar", "android_common").MaybeOutput("unused_deps/unused_deps.txt").Rule; rule != nil {
+		t.Errorf("expected no unused deps report for bar, got %q", rule)
+	}
+
+	aggregate := result.SingletonForTests("java_unused_deps_report").Output("java_unused_deps.txt")
+	android.AssertPathsRelativeToTopEquals(t, "aggregated reports",
+		[]string{"out/soong/.intermediates/foo/android_common/unused_deps/unused_deps.txt"}, aggregate.Inputs)
+}
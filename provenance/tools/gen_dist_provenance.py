@@ -0,0 +1,115 @@
+#!/usr/bin/env python3
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+import argparse
+import hashlib
+import json
+import os
+import subprocess
+import sys
+
+STATEMENT_TYPE = "https://in-toto.io/Statement/v0.1"
+PREDICATE_TYPE = "https://slsa.dev/provenance/v0.2"
+BUILDER_ID = "https://source.android.com/soong"
+
+def Log(*info):
+  if args.verbose:
+    for i in info:
+      print(i)
+
+def ParseArgs(argv):
+  parser = argparse.ArgumentParser(description='Create an in-toto provenance statement for a dist artifact')
+  parser.add_argument('-v', '--verbose', action='store_true', help='Print more information in execution')
+  parser.add_argument('--module_name', help='Module name', required=True)
+  parser.add_argument('--artifact_path', help='Path of the dist artifact', required=True)
+  parser.add_argument('--command_sha256', help='SHA256 hash of the command that built the artifact', default='')
+  parser.add_argument('--builder', help='Name of the rule that built the artifact', default='source')
+  parser.add_argument('--materials', help='Inputs used to build the artifact', nargs='*', default=[])
+  parser.add_argument('--tools', help='Tools run by the command that built the artifact', nargs='*', default=[])
+  parser.add_argument('-o', '--output', help='Path of the provenance statement', required=True)
+  return parser.parse_args(argv)
+
+def ExpandRspArgs(argv):
+  expanded = []
+  for arg in argv:
+    if arg.startswith('@') and os.path.isfile(arg[1:]):
+      with open(arg[1:], "rt") as rsp_file:
+        expanded.extend(rsp_file.read().split())
+    else:
+      expanded.append(arg)
+  return expanded
+
+def Sha256(path):
+  h = hashlib.sha256()
+  with open(path, "rb") as f:
+    for chunk in iter(lambda: f.read(1 << 20), b""):
+      h.update(chunk)
+  return h.hexdigest()
+
+def Material(path):
+  if os.path.isfile(path):
+    return {"uri": path, "digest": {"sha256": Sha256(path)}}
+  # Directories and phony dependencies have no meaningful digest.
+  return {"uri": path, "digest": {}}
+
+def ToolVersion(path):
+  """Returns the first line printed by `path --version`, or an empty string if the tool doesn't
+  support the flag."""
+  try:
+    result = subprocess.run([path, "--version"], stdin=subprocess.DEVNULL, capture_output=True,
+                            timeout=10, check=True, text=True)
+  except (OSError, subprocess.SubprocessError):
+    return ""
+  lines = result.stdout.strip().splitlines()
+  return lines[0] if lines else ""
+
+def Tool(path):
+  return {"uri": path, "digest": {"sha256": Sha256(path)}, "version": ToolVersion(path)}
+
+def main(argv):
+  global args
+  args = ParseArgs(ExpandRspArgs(argv))
+  Log("Args:", vars(args))
+
+  statement = {
+      "_type": STATEMENT_TYPE,
+      "subject": [{
+          "name": os.path.basename(args.artifact_path),
+          "digest": {"sha256": Sha256(args.artifact_path)},
+      }],
+      "predicateType": PREDICATE_TYPE,
+      "predicate": {
+          "builder": {"id": BUILDER_ID},
+          "buildType": args.builder,
+          "invocation": {
+              "parameters": {
+                  "module_name": args.module_name,
+                  "command_sha256": args.command_sha256,
+              },
+              "environment": {
+                  "tools": [Tool(t) for t in sorted(set(args.tools))],
+              },
+          },
+          "materials": [Material(m) for m in sorted(set(args.materials))],
+      },
+  }
+
+  with open(args.output, "wt") as output_file:
+    json.dump(statement, output_file, indent=2, sort_keys=True)
+    output_file.write("\n")
+
+if __name__ == '__main__':
+  main(sys.argv[1:])
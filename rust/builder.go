@@ -333,10 +333,6 @@ func Rustdoc(ctx ModuleContext, main android.Path, deps PathDeps,
 	rustdocFlags := append([]string{}, flags.RustdocFlags...)
 	rustdocFlags = append(rustdocFlags, "--sysroot=/dev/null")
 
-	// Build an index for all our crates. -Z unstable options is required to use
-	// this flag.
-	rustdocFlags = append(rustdocFlags, "-Z", "unstable-options", "--enable-index-page")
-
 	targetTriple := ctx.toolchain().RustTriple()
 
 	// Collect rustc flags
@@ -369,6 +365,9 @@ func Rustdoc(ctx ModuleContext, main android.Path, deps PathDeps,
 		Output:      docTimestampFile,
 		Input:       main,
 		Implicit:    ctx.RustModule().UnstrippedOutputFile(),
+		// rustdoc only links to the docs of other crates if they already exist in docDir, so
+		// the docs of the crates this one depends on have to be generated first.
+		Implicits: deps.docDeps,
 		Args: map[string]string{
 			"rustdocFlags": strings.Join(rustdocFlags, " "),
 			"outDir":       docDir.String(),
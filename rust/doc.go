@@ -15,13 +15,35 @@
 package rust
 
 import (
+	"fmt"
+	"strings"
+
 	"android/soong/android"
 )
 
+// `m rustdoc` generates the docs of all Rust libraries into out/soong/rustdoc, with one
+// subdirectory per crate and an index page of all crates, and zips them into
+// out/soong/rustdoc.zip. The docs of a crate are generated after those of the crates it depends
+// on, so that rustdoc links to them. The docs of a single library are available through its
+// .rustdoc output tag, e.g. DIST_MODULES='libfoo{.rustdoc}'.
+
 func init() {
 	android.RegisterSingletonType("rustdoc", RustdocSingleton)
 }
 
+// rustdocIndexPage returns the content of the index page of the docs of the given crates.
+func rustdocIndexPage(crates []string) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n")
+	b.WriteString("<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Rust crates</title>\n</head>\n")
+	b.WriteString("<body>\n<h1>Rust crates</h1>\n<ul>\n")
+	for _, crate := range crates {
+		fmt.Fprintf(&b, "<li><a href=\"%s/index.html\">%s</a></li>\n", crate, crate)
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+	return b.String()
+}
+
 func RustdocSingleton() android.Singleton {
 	return &rustdocSingleton{}
 }
@@ -37,6 +59,7 @@ func (n *rustdocSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 		FlagWithArg("-C ", docDir.String()).
 		FlagWithArg("-D ", docDir.String())
 
+	var crates []string
 	ctx.VisitAllModules(func(module android.Module) {
 		if !module.Enabled() {
 			return
@@ -45,9 +68,18 @@ func (n *rustdocSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 		if m, ok := module.(*Module); ok {
 			if m.docTimestampFile.Valid() {
 				zipCmd.Implicit(m.docTimestampFile.Path())
+				crates = append(crates, m.CrateName())
 			}
 		}
 	})
+
+	// rustdoc writes the docs of each crate into its own subdirectory of docDir, but the index
+	// page of all crates is generated here, as the concurrent rustdoc runs would each write an
+	// index page of the crates documented so far.
+	indexPage := docDir.Join(ctx, "index.html")
+	android.WriteFileRule(ctx, indexPage, rustdocIndexPage(android.SortedUniqueStrings(crates)))
+	zipCmd.Implicit(indexPage)
+
 	rule.Build("rustdoc-zip", "Zipping all built Rust documentation...")
 	ctx.Phony("rustdoc", docZip)
 }
@@ -344,3 +344,50 @@ func TestLibstdLinkage(t *testing.T) {
 	}
 
 }
+
+// Test that the docs of a library are generated after those of the libraries it depends on, so
+// that rustdoc links to them, that they are available through the .rustdoc output tag, and that
+// the index page lists all crates.
+func TestLibraryRustdoc(t *testing.T) {
+	ctx := testRust(t, `
+		rust_library_host {
+			name: "libfoo",
+			srcs: ["foo.rs"],
+			crate_name: "foo",
+			rustlibs: ["libbar"],
+		}
+		rust_library_host {
+			name: "libbar",
+			srcs: ["bar.rs"],
+			crate_name: "bar",
+		}`)
+
+	rustdocRule := func(name string) (android.TestingBuildParams, *Module) {
+		for _, variant := range ctx.ModuleVariantsForTests(name) {
+			m := ctx.ModuleForTests(name, variant)
+			if rule := m.MaybeRule("rustdoc"); rule.Rule != nil {
+				return rule, m.Module().(*Module)
+			}
+		}
+		t.Fatalf("no variant of %q generates docs", name)
+		return android.TestingBuildParams{}, nil
+	}
+
+	libfooDoc, libfoo := rustdocRule("libfoo")
+	libbarDoc, _ := rustdocRule("libbar")
+
+	android.AssertPathsRelativeToTopEquals(t, "libfoo rustdoc implicits",
+		[]string{android.PathRelativeToTop(libbarDoc.Output)}, libfooDoc.Implicits)
+
+	outputs, err := libfoo.OutputFiles(".rustdoc")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "libfoo .rustdoc output",
+		[]string{android.PathRelativeToTop(libfooDoc.Output)}, outputs)
+
+	indexPage := ctx.SingletonForTests("rustdoc").Output("rustdoc/index.html")
+	content := android.ContentFromFileRuleForTests(t, indexPage)
+	android.AssertStringDoesContain(t, "index page", content, `<li><a href="bar/index.html">bar</a></li>`)
+	android.AssertStringDoesContain(t, "index page", content, `<li><a href="foo/index.html">foo</a></li>`)
+}
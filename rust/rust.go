@@ -210,6 +210,11 @@ func (mod *Module) OutputFiles(tag string) (android.Paths, error) {
 			}
 			return android.Paths{}, nil
 		}
+	case ".rustdoc":
+		if mod.docTimestampFile.Valid() {
+			return android.Paths{mod.docTimestampFile.Path()}, nil
+		}
+		return nil, fmt.Errorf("%q has no rustdoc output in this variant", mod.Name())
 	default:
 		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
 	}
@@ -442,6 +447,11 @@ type PathDeps struct {
 	// Paths to generated source files
 	SrcDeps          android.Paths
 	srcProviderFiles android.Paths
+
+	// docDeps are the rustdoc timestamps of the libraries this module links against, which are
+	// documented before this module so that rustdoc can link to their docs. Only the primary
+	// variants of libraries generate docs, so the docs of other variants aren't linked.
+	docDeps android.Paths
 }
 
 type RustLibraries []RustLibrary
@@ -725,8 +735,8 @@ func (mod *Module) Init() android.Module {
 		mod.AddProperties(mod.sanitize.props()...)
 	}
 
-	android.InitAndroidArchModule(mod, mod.hod, mod.multilib)
 	android.InitApexModule(mod)
+	android.InitAndroidArchModule(mod, mod.hod, mod.multilib)
 
 	android.InitDefaultableModule(mod)
 	return mod
@@ -1099,6 +1109,9 @@ func (mod *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 					return
 				}
 				directDylibDeps = append(directDylibDeps, rustDep)
+				if rustDep.Enabled() && rustDep.docTimestampFile.Valid() {
+					depPaths.docDeps = append(depPaths.docDeps, rustDep.docTimestampFile.Path())
+				}
 				mod.Properties.AndroidMkDylibs = append(mod.Properties.AndroidMkDylibs, makeLibName)
 			case rlibDepTag:
 
@@ -1108,6 +1121,9 @@ func (mod *Module) depsToPaths(ctx android.ModuleContext) PathDeps {
 					return
 				}
 				directRlibDeps = append(directRlibDeps, rustDep)
+				if rustDep.Enabled() && rustDep.docTimestampFile.Valid() {
+					depPaths.docDeps = append(depPaths.docDeps, rustDep.docTimestampFile.Path())
+				}
 				mod.Properties.AndroidMkRlibs = append(mod.Properties.AndroidMkRlibs, makeLibName)
 			case procMacroDepTag:
 				directProcMacroDeps = append(directProcMacroDeps, rustDep)
@@ -1538,6 +1554,15 @@ func (mod *Module) MinSdkVersion() string {
 	return String(mod.Properties.Min_sdk_version)
 }
 
+// Overrides android.ApexModuleBase.IsTestModule
+func (mod *Module) IsTestModule() bool {
+	switch mod.compiler.(type) {
+	case *testDecorator, *benchmarkDecorator:
+		return true
+	}
+	return false
+}
+
 // Implements android.ApexModule
 func (mod *Module) ShouldSupportSdkVersion(ctx android.BaseModuleContext, sdkVersion android.ApiLevel) error {
 	minSdkVersion := mod.MinSdkVersion()
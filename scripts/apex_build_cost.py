@@ -0,0 +1,146 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Reports the build cost of APEXes.
+
+For each target, e.g. the name of an APEX module, extracts the subgraph of the
+ninja build graph that is needed to build it with `ninja -t graph`, and reports
+the number of actions in the subgraph and their total duration in the
+.ninja_log of a previous build. Actions that are not in the log, e.g. because
+they were never run or were built by a different build, are counted separately
+and don't contribute to the duration. Phony actions are not counted.
+
+Example:
+  apex_build_cost.py --ninja prebuilts/build-tools/linux-x86/bin/ninja \\
+    --ninja-file out/combined-aosp_arm64.ninja --ninja-log out/.ninja_log \\
+    com.android.adbd com.android.art
+"""
+
+import argparse
+import re
+import subprocess
+import sys
+
+# A node of `ninja -t graph`, either a file or a build edge with several inputs
+# or outputs, which is drawn as an ellipse labeled with the rule name.
+NODE_RE = re.compile(r'^"(0x[0-9a-f]+)" \[label="([^"]*)"(, shape=ellipse)?\]$')
+# An edge of `ninja -t graph`. Build edges with a single input and output are
+# drawn as an edge between the files labeled with the rule name.
+EDGE_RE = re.compile(r'^"(0x[0-9a-f]+)" -> "(0x[0-9a-f]+)"(?: \[(.*)\])?$')
+EDGE_LABEL_RE = re.compile(r'label=" ([^"]*)"')
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(
+      description=__doc__, formatter_class=argparse.RawDescriptionHelpFormatter)
+  parser.add_argument('--ninja', default='ninja', help='path to ninja')
+  parser.add_argument('--ninja-file', required=True,
+                      help='path to the ninja file of the build')
+  parser.add_argument('--ninja-log', required=True,
+                      help='path to the .ninja_log of a previous build')
+  parser.add_argument('targets', nargs='+', help='ninja targets to report')
+  return parser.parse_args()
+
+
+def parse_graph(content):
+  """Returns the actions of the output of `ninja -t graph`.
+
+  Each action is a (rule, outputs) tuple, where outputs is a sorted tuple of
+  the paths of the files written by the action. Phony actions are skipped.
+  """
+  files = {}
+  rules = {}
+  edges = []
+  for line in content.splitlines():
+    line = line.strip()
+    match = NODE_RE.match(line)
+    if match:
+      node, label, ellipse = match.groups()
+      if ellipse:
+        rules[node] = label
+      else:
+        files[node] = label
+      continue
+    match = EDGE_RE.match(line)
+    if match:
+      edges.append(match.groups())
+
+  outputs = {}
+  direct = []
+  for src, dst, attrs in edges:
+    if src in rules:
+      outputs.setdefault(src, []).append(files[dst])
+    elif dst not in rules:
+      label = EDGE_LABEL_RE.search(attrs or '')
+      direct.append((label.group(1) if label else '', files[dst]))
+
+  actions = set()
+  for node, rule in rules.items():
+    actions.add((rule, tuple(sorted(outputs.get(node, [])))))
+  for rule, output in direct:
+    actions.add((rule, (output,)))
+  return sorted(a for a in actions if a[0] != 'phony')
+
+
+def parse_ninja_log(content):
+  """Returns the duration in milliseconds of the last run of each output."""
+  durations = {}
+  for line in content.splitlines():
+    if line.startswith('#'):
+      continue
+    fields = line.split('\t')
+    if len(fields) < 4:
+      continue
+    start, end, output = int(fields[0]), int(fields[1]), fields[3]
+    durations[output] = end - start
+  return durations
+
+
+def cost(actions, durations):
+  """Returns the number of actions, the number of actions missing from the
+  log, and the total duration in milliseconds of the actions in the log.
+  """
+  missing = 0
+  total = 0
+  for _, outputs in actions:
+    # All outputs of an action share the same log entry.
+    known = [durations[o] for o in outputs if o in durations]
+    if known:
+      total += max(known)
+    else:
+      missing += 1
+  return len(actions), missing, total
+
+
+def format_report(target, actions, missing, total):
+  return '%s: %d actions, %.1fs (%d actions not in the log)' % (
+      target, actions, total / 1000.0, missing)
+
+
+def main():
+  args = parse_args()
+  with open(args.ninja_log) as f:
+    durations = parse_ninja_log(f.read())
+
+  for target in args.targets:
+    graph = subprocess.check_output(
+        [args.ninja, '-f', args.ninja_file, '-t', 'graph', target],
+        universal_newlines=True)
+    print(format_report(target, *cost(parse_graph(graph), durations)))
+
+
+if __name__ == '__main__':
+  sys.exit(main())
@@ -0,0 +1,88 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for apex_build_cost.py."""
+
+import sys
+import unittest
+
+import apex_build_cost
+
+sys.dont_write_bytecode = True
+
+# The output of `ninja -t graph myapex` for an APEX built from a library that is
+# compiled from two sources.
+GRAPH = """digraph ninja {
+rankdir="LR"
+node [fontsize=10, shape=box, height=0.25]
+edge [fontsize=10]
+"0x1" [label="myapex"]
+"0x2" -> "0x1" [label=" phony"]
+"0x2" [label="out/myapex.apex"]
+"0x3" -> "0x2" [label=" apexer"]
+"0x3" [label="out/libfoo.so"]
+"0x10" [label="ld", shape=ellipse]
+"0x10" -> "0x3"
+"0x10" -> "0x6"
+"0x4" -> "0x10" [arrowhead=none]
+"0x5" -> "0x10" [arrowhead=none]
+"0x6" [label="out/libfoo.so.toc"]
+"0x4" [label="out/foo.o"]
+"0x7" -> "0x4" [label=" cc"]
+"0x7" [label="foo.c"]
+"0x5" [label="out/bar.o"]
+"0x8" -> "0x5" [label=" cc"]
+"0x8" [label="bar.c"]
+}
+"""
+
+NINJA_LOG = """# ninja log v5
+0\t1000\t0\tout/foo.o\tabc
+0\t3000\t0\tout/bar.o\tdef
+3000\t5000\t0\tout/libfoo.so\t123
+3000\t5000\t0\tout/libfoo.so.toc\t123
+100\t200\t0\tout/unrelated.o\t456
+0\t500\t0\tout/foo.o\tabc
+"""
+
+
+class ApexBuildCostTest(unittest.TestCase):
+
+  def test_parse_graph(self):
+    self.assertEqual(apex_build_cost.parse_graph(GRAPH), [
+        ('apexer', ('out/myapex.apex',)),
+        ('cc', ('out/bar.o',)),
+        ('cc', ('out/foo.o',)),
+        ('ld', ('out/libfoo.so', 'out/libfoo.so.toc')),
+    ])
+
+  def test_parse_ninja_log(self):
+    durations = apex_build_cost.parse_ninja_log(NINJA_LOG)
+    # The last run of an output is used.
+    self.assertEqual(durations['out/foo.o'], 500)
+    self.assertEqual(durations['out/libfoo.so.toc'], 2000)
+
+  def test_cost(self):
+    actions = apex_build_cost.parse_graph(GRAPH)
+    durations = apex_build_cost.parse_ninja_log(NINJA_LOG)
+    self.assertEqual(apex_build_cost.cost(actions, durations), (4, 1, 5500))
+    self.assertEqual(
+        apex_build_cost.format_report('myapex', 4, 1, 5500),
+        'myapex: 4 actions, 5.5s (1 actions not in the log)')
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,128 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Reports identical files packaged into more than one APEX.
+
+The input list has a line for each file of each APEX, with the name of the
+APEX, the path of the file in the APEX, the module providing it and the built
+file, separated by tabs. Files with the same content in several APEXes are
+reported with the bytes they waste, largest first, so that they can be shared
+through stubs or a sharedlibs APEX instead.
+"""
+
+import argparse
+import collections
+import hashlib
+import os
+import sys
+
+Entry = collections.namedtuple('Entry', ['apex', 'path', 'module', 'built'])
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--list', required=True, help='the list of the files of every APEX')
+  parser.add_argument('--fail-above', type=int, default=-1, metavar='BYTES',
+                      help='fail if a duplicated file is larger than BYTES')
+  parser.add_argument('-o', '--output', required=True, help='path to the report')
+  return parser.parse_args()
+
+
+def parse_list(lines):
+  """Returns the entries of the list of the files of every APEX."""
+  entries = []
+  for line in lines:
+    line = line.rstrip('\n')
+    if not line:
+      continue
+    entries.append(Entry(*line.split('\t')))
+  return entries
+
+
+def sha256(path):
+  h = hashlib.sha256()
+  with open(path, 'rb') as f:
+    for chunk in iter(lambda: f.read(1 << 20), b''):
+      h.update(chunk)
+  return h.hexdigest()
+
+
+def find_duplicates(entries, digest, size):
+  """Returns the groups of entries with the same content in more than one APEX.
+
+  digest and size return the content hash and the size of a built file. Each
+  group is a (size, wasted bytes, entries) tuple, and the groups are sorted by
+  wasted bytes, largest first.
+  """
+  by_digest = collections.defaultdict(list)
+  for entry in entries:
+    by_digest[digest(entry.built)].append(entry)
+
+  groups = []
+  for group in by_digest.values():
+    apexes = set(entry.apex for entry in group)
+    if len(apexes) < 2:
+      continue
+    file_size = size(group[0].built)
+    wasted = file_size * (len(apexes) - 1)
+    groups.append((file_size, wasted, sorted(group)))
+  groups.sort(key=lambda g: (-g[1], g[2][0].path))
+  return groups
+
+
+def format_report(groups):
+  """Returns the report lines of the duplicated groups."""
+  lines = []
+  total = sum(wasted for _, wasted, _ in groups)
+  lines.append('%d bytes in %d files are duplicated across APEXes\n' % (total, len(groups)))
+  for file_size, wasted, group in groups:
+    lines.append('\n%d bytes wasted, %d bytes each:\n' % (wasted, file_size))
+    for entry in group:
+      lines.append('  %s:%s from %s\n' % (entry.apex, entry.path, entry.module))
+  return lines
+
+
+def main():
+  args = parse_args()
+
+  with open(args.list) as f:
+    entries = parse_list(f)
+
+  digests = {}
+
+  def digest(path):
+    if path not in digests:
+      digests[path] = sha256(path)
+    return digests[path]
+
+  groups = find_duplicates(entries, digest, os.path.getsize)
+
+  with open(args.output, 'w') as f:
+    f.writelines(format_report(groups))
+
+  if args.fail_above >= 0:
+    too_large = [g for g in groups if g[0] > args.fail_above]
+    if too_large:
+      sys.stderr.write('Files larger than %d bytes are packaged into more than one APEX.\n'
+                       % args.fail_above)
+      sys.stderr.write('Share them through stubs or a sharedlibs APEX instead:\n')
+      sys.stderr.writelines(format_report(too_large)[1:])
+      return 1
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
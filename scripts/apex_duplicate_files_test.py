@@ -0,0 +1,79 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for apex_duplicate_files.py."""
+
+import sys
+import unittest
+
+import apex_duplicate_files
+from apex_duplicate_files import Entry
+
+sys.dont_write_bytecode = True
+
+LIST = """\
+com.android.foo\tlib64/libbase.so\tlibbase\tout/foo/libbase.so
+com.android.bar\tlib64/libbase.so\tlibbase\tout/bar/libbase.so
+com.android.bar\tlib64/libbar.so\tlibbar\tout/bar/libbar.so
+com.android.foo\tetc/a.txt\ta\tout/foo/a.txt
+com.android.foo\tetc/b.txt\tb\tout/foo/b.txt
+"""
+
+CONTENTS = {
+    'out/foo/libbase.so': ('base', 100),
+    'out/bar/libbase.so': ('base', 100),
+    'out/bar/libbar.so': ('bar', 50),
+    # Identical files in one APEX are not reported.
+    'out/foo/a.txt': ('txt', 10),
+    'out/foo/b.txt': ('txt', 10),
+}
+
+
+class ApexDuplicateFilesTest(unittest.TestCase):
+
+  def test_parse_list(self):
+    entries = apex_duplicate_files.parse_list(LIST.splitlines(True))
+    self.assertEqual(len(entries), 5)
+    self.assertEqual(entries[0], Entry('com.android.foo', 'lib64/libbase.so', 'libbase',
+                                       'out/foo/libbase.so'))
+
+  def test_find_duplicates(self):
+    entries = apex_duplicate_files.parse_list(LIST.splitlines(True))
+    groups = apex_duplicate_files.find_duplicates(entries,
+                                                  lambda p: CONTENTS[p][0],
+                                                  lambda p: CONTENTS[p][1])
+    self.assertEqual(groups, [(100, 100, [
+        Entry('com.android.bar', 'lib64/libbase.so', 'libbase', 'out/bar/libbase.so'),
+        Entry('com.android.foo', 'lib64/libbase.so', 'libbase', 'out/foo/libbase.so'),
+    ])])
+
+  def test_format_report(self):
+    groups = [(100, 200, [
+        Entry('a', 'lib/x.so', 'x', 'out/a/x.so'),
+        Entry('b', 'lib/x.so', 'x', 'out/b/x.so'),
+        Entry('c', 'lib/x.so', 'x', 'out/c/x.so'),
+    ])]
+    self.assertEqual(''.join(apex_duplicate_files.format_report(groups)),
+                     '200 bytes in 1 files are duplicated across APEXes\n'
+                     '\n'
+                     '200 bytes wasted, 100 bytes each:\n'
+                     '  a:lib/x.so from x\n'
+                     '  b:lib/x.so from x\n'
+                     '  c:lib/x.so from x\n')
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
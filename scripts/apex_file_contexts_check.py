@@ -0,0 +1,112 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Checks the file_contexts of an APEX against the files in its payload.
+
+Every file in the payload must be labeled by one of the patterns of the
+file_contexts, and every pattern must match at least one file or directory of
+the payload, so that mistakes are reported when the APEX is built instead of
+by apexer or when the APEX is installed.
+"""
+
+import argparse
+import re
+import sys
+
+# The entries that Soong always appends to the file_contexts of an APEX.
+FORCED_ENTRIES = ['/', '/apex_manifest.pb']
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--file-contexts', required=True,
+                      help='path to the file_contexts of the APEX')
+  parser.add_argument('--payload', required=True,
+                      help='path to a file listing the payload files, one per line, '
+                      'relative to the root of the APEX and starting with /')
+  parser.add_argument('--allow-stale', action='store_true',
+                      help='do not report patterns that do not match any payload entry')
+  parser.add_argument('-o', '--output', required=True,
+                      help='path to the timestamp file written when the check passes')
+  return parser.parse_args()
+
+
+def parse_file_contexts(content):
+  """Returns a list of (line number, pattern) for each entry of a file_contexts."""
+  patterns = []
+  for lineno, line in enumerate(content.splitlines(), 1):
+    line = line.strip()
+    if not line or line.startswith('#'):
+      continue
+    patterns.append((lineno, line.split()[0]))
+  return patterns
+
+
+def payload_dirs(files):
+  """Returns the directories containing the given files, including /."""
+  dirs = {'/'}
+  for f in files:
+    parts = f.strip('/').split('/')[:-1]
+    for i in range(1, len(parts) + 1):
+      dirs.add('/' + '/'.join(parts[:i]))
+  return dirs
+
+
+def check(file_contexts, payload, allow_stale):
+  """Returns the list of errors found in the file_contexts for the payload."""
+  errors = []
+  compiled = []
+  for lineno, pattern in parse_file_contexts(file_contexts):
+    try:
+      compiled.append((lineno, pattern, re.compile(pattern)))
+    except re.error as e:
+      errors.append('line %d: invalid pattern %r: %s' % (lineno, pattern, e))
+
+  files = [f for f in payload if f not in FORCED_ENTRIES]
+  for f in sorted(files):
+    if not any(r.fullmatch(f) for _, _, r in compiled):
+      errors.append('%s is not labeled by any pattern' % f)
+
+  if not allow_stale:
+    entries = set(files) | payload_dirs(files)
+    for lineno, pattern, r in compiled:
+      if not any(r.fullmatch(e) for e in entries):
+        errors.append('line %d: pattern %r does not match any file in the APEX' %
+                      (lineno, pattern))
+  return errors
+
+
+def main():
+  args = parse_args()
+
+  with open(args.file_contexts) as f:
+    file_contexts = f.read()
+  with open(args.payload) as f:
+    payload = [line.strip() for line in f if line.strip()]
+
+  errors = check(file_contexts, payload, args.allow_stale)
+  if errors:
+    for error in errors:
+      print('%s: %s' % (args.file_contexts, error), file=sys.stderr)
+    return 1
+
+  with open(args.output, 'w'):
+    pass
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
@@ -0,0 +1,97 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for apex_file_contexts_check.py."""
+
+import os
+import sys
+import tempfile
+import unittest
+from unittest import mock
+
+import apex_file_contexts_check
+
+sys.dont_write_bytecode = True
+
+FILE_CONTEXTS = """
+# Comments and empty lines are ignored.
+(/.*)?                  u:object_r:system_file:s0
+/bin/foo                u:object_r:foo_exec:s0
+/lib(64)?(/.*)?         u:object_r:system_lib_file:s0
+"""
+
+
+class CheckTest(unittest.TestCase):
+
+  def test_ok(self):
+    errors = apex_file_contexts_check.check(
+        FILE_CONTEXTS, ['/bin/foo', '/lib64/libfoo.so', '/apex_manifest.pb'], False)
+    self.assertEqual(errors, [])
+
+  def test_stale(self):
+    errors = apex_file_contexts_check.check(FILE_CONTEXTS, ['/bin/bar'], False)
+    self.assertEqual(errors, [
+        "line 4: pattern '/bin/foo' does not match any file in the APEX",
+        "line 5: pattern '/lib(64)?(/.*)?' does not match any file in the APEX",
+    ])
+
+  def test_allow_stale(self):
+    errors = apex_file_contexts_check.check(FILE_CONTEXTS, ['/bin/bar'], True)
+    self.assertEqual(errors, [])
+
+  def test_not_labeled(self):
+    file_contexts = '/bin/foo u:object_r:foo_exec:s0\n'
+    errors = apex_file_contexts_check.check(
+        file_contexts, ['/bin/foo', '/etc/foo.conf', '/apex_manifest.pb'], False)
+    self.assertEqual(errors, ['/etc/foo.conf is not labeled by any pattern'])
+
+  def test_directory_pattern(self):
+    file_contexts = '/bin/foo u:object_r:foo_exec:s0\n/bin u:object_r:system_file:s0\n'
+    errors = apex_file_contexts_check.check(file_contexts, ['/bin/foo'], False)
+    self.assertEqual(errors, [])
+
+  def test_invalid_pattern(self):
+    errors = apex_file_contexts_check.check('/bin/(foo u:object_r:foo_exec:s0\n', [], True)
+    self.assertEqual(len(errors), 1)
+    self.assertTrue(errors[0].startswith("line 1: invalid pattern '/bin/(foo'"))
+
+
+class MainTest(unittest.TestCase):
+
+  def run_main(self, *flags):
+    with tempfile.TemporaryDirectory() as tmp:
+      file_contexts = os.path.join(tmp, 'file_contexts')
+      with open(file_contexts, 'w') as f:
+        f.write(FILE_CONTEXTS)
+      payload = os.path.join(tmp, 'payload.txt')
+      with open(payload, 'w') as f:
+        f.write('/bin/bar\n')
+      output = os.path.join(tmp, 'check.timestamp')
+      argv = ['apex_file_contexts_check', '--file-contexts', file_contexts,
+              '--payload', payload, '-o', output] + list(flags)
+      with mock.patch.object(sys, 'argv', argv), mock.patch('sys.stderr'):
+        ret = apex_file_contexts_check.main()
+      return ret, os.path.exists(output)
+
+  def test_stale_fails(self):
+    self.assertEqual(self.run_main(), (1, False))
+
+  def test_allow_stale_passes(self):
+    self.assertEqual(self.run_main('--allow-stale'), (0, True))
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
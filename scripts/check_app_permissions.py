@@ -0,0 +1,101 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Checks the permissions an app requests against a previous-release baseline.
+
+The baseline lists one declaration per line, '#' starting a comment:
+
+  uses-permission android.permission.INTERNET
+  sharedUserId android.uid.system
+
+Requesting a permission that is not in the baseline, or joining a shared user
+id that is not in the baseline, fails the check until the baseline is updated,
+so that privilege changes of preinstalled apps are reviewed. Removals are
+allowed.
+"""
+
+import argparse
+import sys
+from xml.dom import minidom
+
+from manifest import android_ns
+from manifest import get_children_with_tag
+from manifest import parse_manifest
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--module', required=True, help='name of the checked module')
+  parser.add_argument('--baseline', required=True, help='the permissions baseline')
+  parser.add_argument('-o', '--output', required=True,
+                      help='path to write the declarations of the manifest to')
+  parser.add_argument('manifest', help='the merged manifest of the app')
+  return parser.parse_args()
+
+
+def manifest_declarations(doc):
+  """Returns the permission declarations of a manifest as baseline lines."""
+  manifest = parse_manifest(doc)
+  declarations = set()
+  for tag in ('uses-permission', 'uses-permission-sdk-23'):
+    for element in get_children_with_tag(manifest, tag):
+      name = element.getAttributeNodeNS(android_ns, 'name')
+      if name is not None:
+        declarations.add('uses-permission ' + name.value)
+  shared_user_id = manifest.getAttributeNodeNS(android_ns, 'sharedUserId')
+  if shared_user_id is not None:
+    declarations.add('sharedUserId ' + shared_user_id.value)
+  return declarations
+
+
+def baseline_declarations(lines):
+  """Returns the declarations of a baseline file, with whitespace normalized."""
+  declarations = set()
+  for line in lines:
+    line = line.split('#', 1)[0].split()
+    if line:
+      declarations.add(' '.join(line))
+  return declarations
+
+
+def check(module, baseline_file, declared, baseline):
+  """Returns the error lines for declarations that are missing from the baseline."""
+  return ['%s: %s is not in %s\n' % (module, d, baseline_file)
+          for d in sorted(declared - baseline)]
+
+
+def main():
+  args = parse_args()
+
+  declared = manifest_declarations(minidom.parse(args.manifest))
+  with open(args.baseline) as f:
+    baseline = baseline_declarations(f)
+
+  with open(args.output, 'w') as f:
+    f.writelines(d + '\n' for d in sorted(declared))
+
+  errors = check(args.module, args.baseline, declared, baseline)
+  if errors:
+    sys.stderr.write('The app requests privileges that the previous release did not have.\n')
+    sys.stderr.write('If this is intended, update the baseline after review:\n')
+    sys.stderr.write('  cp %s %s\n' % (args.output, args.baseline))
+    sys.stderr.writelines(errors)
+    return 1
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
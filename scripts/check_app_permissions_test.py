@@ -0,0 +1,72 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for check_app_permissions.py."""
+
+import sys
+import unittest
+from xml.dom import minidom
+
+import check_app_permissions
+
+sys.dont_write_bytecode = True
+
+MANIFEST = """\
+<manifest xmlns:android="http://schemas.android.com/apk/res/android"
+    package="com.android.foo" android:sharedUserId="android.uid.system">
+  <uses-permission android:name="android.permission.INTERNET" />
+  <uses-permission-sdk-23 android:name="android.permission.CAMERA" />
+  <application>
+    <uses-library android:name="foo" />
+  </application>
+</manifest>
+"""
+
+
+class CheckAppPermissionsTest(unittest.TestCase):
+
+  def test_manifest_declarations(self):
+    self.assertEqual(
+        check_app_permissions.manifest_declarations(minidom.parseString(MANIFEST)),
+        {'uses-permission android.permission.INTERNET',
+         'uses-permission android.permission.CAMERA',
+         'sharedUserId android.uid.system'})
+
+  def test_baseline_declarations(self):
+    lines = ['# com.android.foo', 'uses-permission   android.permission.INTERNET',
+             '', 'sharedUserId android.uid.system  # since Q']
+    self.assertEqual(check_app_permissions.baseline_declarations(lines),
+                     {'uses-permission android.permission.INTERNET',
+                      'sharedUserId android.uid.system'})
+
+  def test_check_removal_allowed(self):
+    declared = {'uses-permission android.permission.INTERNET'}
+    baseline = declared | {'sharedUserId android.uid.system'}
+    self.assertEqual(check_app_permissions.check('Foo', 'b.txt', declared, baseline), [])
+
+  def test_check_addition(self):
+    declared = {'uses-permission android.permission.INTERNET',
+                'uses-permission android.permission.CAMERA',
+                'sharedUserId android.uid.system'}
+    baseline = {'uses-permission android.permission.INTERNET'}
+    self.assertEqual(check_app_permissions.check('Foo', 'b.txt', declared, baseline), [
+        'Foo: sharedUserId android.uid.system is not in b.txt\n',
+        'Foo: uses-permission android.permission.CAMERA is not in b.txt\n',
+    ])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
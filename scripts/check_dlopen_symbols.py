@@ -0,0 +1,104 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Checks the exported symbols of a library against its dlopen symbol list.
+
+Apps may dlopen a platform library and look up symbols with dlsym. Those
+symbols are not covered by NDK stubs, so the library declares them in a list
+with one symbol per line, and '#' starting a comment. Every listed symbol must
+still be exported by the library, and every exported symbol must be listed, so
+that additions to the interface are reviewed as changes to the list.
+"""
+
+import argparse
+import sys
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--module', required=True, help='name of the checked module')
+  parser.add_argument('--symbols', required=True, help='the dlopen symbol list')
+  parser.add_argument('-o', '--output', required=True, help='path to the report')
+  parser.add_argument('toc', help='table of contents file of the library')
+  return parser.parse_args()
+
+
+def exported_symbols(lines):
+  """Returns the symbols a table of contents file shows as exported.
+
+  Symbol entries of the table of contents look like
+  "1: FUNC GLOBAL DEFAULT 12 foo@@LIBFOO".
+  """
+  exported = set()
+  for line in lines:
+    fields = line.split()
+    if len(fields) < 6 or not fields[0].endswith(':') or not fields[0][:-1].isdigit():
+      continue
+    bind, visibility, section, name = fields[-4:]
+    if section == 'UND' or bind not in ('GLOBAL', 'WEAK'):
+      continue
+    if visibility not in ('DEFAULT', 'PROTECTED'):
+      continue
+    exported.add(name.split('@')[0])
+  return exported
+
+
+def declared_symbols(lines):
+  """Returns the symbols of a dlopen symbol list."""
+  declared = set()
+  for line in lines:
+    line = line.split('#', 1)[0].strip()
+    if line:
+      declared.add(line)
+  return declared
+
+
+def check(module, symbols_file, exported, declared):
+  """Returns the error lines for the differences between exported and declared."""
+  errors = []
+  for symbol in sorted(declared - exported):
+    errors.append('%s: %s is listed in %s but is no longer exported\n' %
+                  (module, symbol, symbols_file))
+  for symbol in sorted(exported - declared):
+    errors.append('%s: %s is exported but not listed in %s\n' %
+                  (module, symbol, symbols_file))
+  return errors
+
+
+def main():
+  args = parse_args()
+
+  with open(args.toc) as f:
+    exported = exported_symbols(f)
+  with open(args.symbols) as f:
+    declared = declared_symbols(f)
+
+  errors = check(args.module, args.symbols, exported, declared)
+
+  with open(args.output, 'w') as f:
+    f.writelines(errors)
+
+  if errors:
+    sys.stderr.write('The exported symbols do not match the dlopen symbol list.\n')
+    sys.stderr.write('Apps may look these symbols up with dlsym, so removing one breaks them and\n')
+    sys.stderr.write('adding one makes it part of the interface. Update the list after review:\n')
+    sys.stderr.writelines(errors)
+    return 1
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
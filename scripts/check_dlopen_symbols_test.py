@@ -0,0 +1,67 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for check_dlopen_symbols.py."""
+
+import sys
+import unittest
+
+import check_dlopen_symbols
+
+sys.dont_write_bytecode = True
+
+TOC = """\
+0x000000000000000e (SONAME) Library soname: [libfoo.so]
+
+Symbol table '.dynsym' contains 7 entries:
+   Num:    Type    Bind   Vis       Ndx Name
+     0:   NOTYPE  LOCAL  DEFAULT   UND
+     1:   FUNC    GLOBAL DEFAULT   UND malloc@LIBC
+     2:   FUNC    GLOBAL DEFAULT    12 foo_open@@LIBFOO
+     3:   FUNC    WEAK   DEFAULT    12 foo_close
+     4:   OBJECT  GLOBAL PROTECTED  20 foo_version
+     5:   FUNC    GLOBAL HIDDEN     12 foo_internal
+     6:   FUNC    LOCAL  DEFAULT    12 foo_local
+"""
+
+
+class CheckDlopenSymbolsTest(unittest.TestCase):
+
+  def test_exported_symbols(self):
+    self.assertEqual(check_dlopen_symbols.exported_symbols(TOC.splitlines()),
+                     {'foo_open', 'foo_close', 'foo_version'})
+
+  def test_declared_symbols(self):
+    lines = ['# apps dlopen libfoo.so', 'foo_open', '  foo_close  # since S', '', 'foo_version']
+    self.assertEqual(check_dlopen_symbols.declared_symbols(lines),
+                     {'foo_open', 'foo_close', 'foo_version'})
+
+  def test_check_matches(self):
+    symbols = {'foo_open', 'foo_close'}
+    self.assertEqual(check_dlopen_symbols.check('libfoo', 'syms.txt', symbols, symbols), [])
+
+  def test_check_mismatch(self):
+    errors = check_dlopen_symbols.check('libfoo', 'syms.txt',
+                                        exported={'foo_open', 'foo_new'},
+                                        declared={'foo_open', 'foo_old'})
+    self.assertEqual(errors, [
+        'libfoo: foo_old is listed in syms.txt but is no longer exported\n',
+        'libfoo: foo_new is exported but not listed in syms.txt\n',
+    ])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
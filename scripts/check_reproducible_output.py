@@ -0,0 +1,159 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Checks that a build output is reproducible.
+
+The sha256 of the output is written to the report. When the same output of
+another build of the same sources is given as a reference, the two must be
+identical, which is what proves that the output is reproducible.
+
+Zips, apks and apexes must also store the pinned timestamp in all of their
+entries instead of the modification times of the packaged files, and list their
+entries in the same order as the reference, if any. Ext4 images
+must use the UUID and directory hash seed that the build system derived for
+them instead of random ones. These point at the cause of a difference even
+without a reference.
+"""
+
+import argparse
+import datetime
+import hashlib
+import os
+import subprocess
+import sys
+import zipfile
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--kind', required=True, choices=['zip', 'ext4', 'file'],
+                      help='kind of the checked output')
+  parser.add_argument('--reference',
+                      help='path to the same output in another build of the same sources')
+  parser.add_argument('--timestamp',
+                      help='pinned timestamp of the entries of a zip, as YYYY-MM-DDTHH:MM:SS')
+  parser.add_argument('--tune2fs', help='path to tune2fs, for ext4 images')
+  parser.add_argument('--uuid', help='expected UUID of an ext4 image')
+  parser.add_argument('--hash-seed', help='expected directory hash seed of an ext4 image')
+  parser.add_argument('-o', '--output', required=True, help='path to the report')
+  parser.add_argument('input', help='the checked output')
+  return parser.parse_args()
+
+
+def parse_time(timestamp):
+  """Returns the zip date_time of a YYYY-MM-DDTHH:MM:SS timestamp."""
+  return datetime.datetime.strptime(timestamp, '%Y-%m-%dT%H:%M:%S').timetuple()[:6]
+
+
+def check_zip_timestamps(name, infos, pinned):
+  """Returns the error lines for zip entries whose timestamps differ from the pinned one."""
+  errors = []
+  for info in infos:
+    if tuple(info.date_time) != tuple(pinned):
+      errors.append('%s: entry %s has timestamp %s, expected %s\n' %
+                    (name, info.filename, format_time(info.date_time), format_time(pinned)))
+  return errors
+
+
+def check_zip_order(name, names, reference_names):
+  """Returns the error lines if the zip entries are not in the same order as in the reference."""
+  if names == reference_names:
+    return []
+  for i, (entry, reference_entry) in enumerate(zip(names, reference_names)):
+    if entry != reference_entry:
+      return ['%s: entry %d is %s, but it is %s in the reference build\n' %
+              (name, i, entry, reference_entry)]
+  return ['%s: has %d entries, but the reference build has %d\n' %
+          (name, len(names), len(reference_names))]
+
+
+def format_time(date_time):
+  return '%04d-%02d-%02d %02d:%02d:%02d' % date_time
+
+
+def parse_tune2fs(lines):
+  """Returns the fields of the output of tune2fs -l."""
+  fields = {}
+  for line in lines:
+    key, sep, value = line.partition(':')
+    if sep:
+      fields[key.strip()] = value.strip()
+  return fields
+
+
+def check_ext4_fields(name, fields, uuid, hash_seed):
+  """Returns the error lines for fields of an ext4 image that differ from the expected ones."""
+  errors = []
+  for field, expected in (('Filesystem UUID', uuid), ('Directory Hash Seed', hash_seed)):
+    if expected and fields.get(field) != expected:
+      errors.append('%s: %s is %s, expected %s\n' %
+                    (name, field, fields.get(field, 'missing'), expected))
+  return errors
+
+
+def sha256(path):
+  """Returns the hex encoded sha256 of the contents of a file."""
+  h = hashlib.sha256()
+  with open(path, 'rb') as f:
+    for chunk in iter(lambda: f.read(1024 * 1024), b''):
+      h.update(chunk)
+  return h.hexdigest()
+
+
+def check_reference(name, digest, reference):
+  """Returns the error lines if the reference output is missing or differs from the output."""
+  if not os.path.exists(reference):
+    return ['%s: %s is missing from the reference build\n' % (name, reference)]
+  reference_digest = sha256(reference)
+  if reference_digest != digest:
+    return ['%s: sha256 is %s, but %s has %s\n' % (name, digest, reference, reference_digest)]
+  return []
+
+
+def main():
+  args = parse_args()
+
+  errors = []
+  if args.kind == 'zip':
+    with zipfile.ZipFile(args.input) as z:
+      errors += check_zip_timestamps(args.input, z.infolist(), parse_time(args.timestamp))
+      if args.reference and zipfile.is_zipfile(args.reference):
+        with zipfile.ZipFile(args.reference) as reference:
+          errors += check_zip_order(args.input, z.namelist(), reference.namelist())
+  elif args.kind == 'ext4':
+    output = subprocess.check_output([args.tune2fs, '-l', args.input], text=True)
+    errors += check_ext4_fields(args.input, parse_tune2fs(output.splitlines()),
+                                args.uuid, args.hash_seed)
+
+  digest = sha256(args.input)
+  if args.reference:
+    errors += check_reference(args.input, digest, args.reference)
+
+  with open(args.output, 'w') as f:
+    f.write('%s  %s\n' % (digest, args.input))
+    f.writelines(errors)
+
+  if errors:
+    sys.stderr.write('The output is not reproducible. Two builds of the same sources must\n')
+    sys.stderr.write('produce identical bytes, so the tool building it must not depend on the\n')
+    sys.stderr.write('build environment and must use the pinned parameters of the build system:\n')
+    sys.stderr.writelines(errors)
+    return 1
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
@@ -0,0 +1,127 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for check_reproducible_output.py."""
+
+import os
+import shutil
+import sys
+import tempfile
+import unittest
+import zipfile
+
+import check_reproducible_output
+
+sys.dont_write_bytecode = True
+
+TUNE2FS = """\
+tune2fs 1.46.2 (28-Feb-2021)
+Filesystem volume name:   system
+Filesystem UUID:          da594c53-9beb-f85c-85c5-cedf76546f7a
+Directory Hash Seed:      da594c53-9beb-f85c-85c5-cedf76546f7a
+Filesystem created:       Tue Jan  1 00:00:00 2008
+"""
+
+PINNED = (2008, 1, 1, 0, 0, 0)
+
+
+class CheckReproducibleOutputTest(unittest.TestCase):
+
+  def test_parse_time(self):
+    self.assertEqual(check_reproducible_output.parse_time('2008-01-01T00:00:00'),
+                     (2008, 1, 1, 0, 0, 0))
+
+  def test_zip_pinned_timestamps(self):
+    infos = [zipfile.ZipInfo('a', (2008, 1, 1, 0, 0, 0)),
+             zipfile.ZipInfo('b', (2008, 1, 1, 0, 0, 0))]
+    self.assertEqual(
+        check_reproducible_output.check_zip_timestamps('foo.apk', infos, PINNED), [])
+
+  def test_zip_empty(self):
+    self.assertEqual(check_reproducible_output.check_zip_timestamps('foo.zip', [], PINNED), [])
+
+  def test_zip_unpinned_timestamp(self):
+    infos = [zipfile.ZipInfo('a', (2008, 1, 1, 0, 0, 0)),
+             zipfile.ZipInfo('b', (2022, 3, 4, 5, 6, 7))]
+    self.assertEqual(check_reproducible_output.check_zip_timestamps('foo.apk', infos, PINNED), [
+        'foo.apk: entry b has timestamp 2022-03-04 05:06:07, expected 2008-01-01 00:00:00\n',
+    ])
+
+  def test_zip_build_time(self):
+    # Entries that all share a timestamp other than the pinned one are not reproducible either.
+    infos = [zipfile.ZipInfo('a', (2022, 3, 4, 5, 6, 7)),
+             zipfile.ZipInfo('b', (2022, 3, 4, 5, 6, 7))]
+    self.assertEqual(len(check_reproducible_output.check_zip_timestamps('foo.apk', infos, PINNED)),
+                     2)
+
+  def test_zip_order(self):
+    self.assertEqual(
+        check_reproducible_output.check_zip_order('foo.apk', ['a', 'b'], ['a', 'b']), [])
+    self.assertEqual(
+        check_reproducible_output.check_zip_order('foo.apk', ['b', 'a'], ['a', 'b']), [
+            'foo.apk: entry 0 is b, but it is a in the reference build\n',
+        ])
+    self.assertEqual(
+        check_reproducible_output.check_zip_order('foo.apk', ['a', 'b'], ['a']), [
+            'foo.apk: has 2 entries, but the reference build has 1\n',
+        ])
+
+  def test_parse_tune2fs(self):
+    fields = check_reproducible_output.parse_tune2fs(TUNE2FS.splitlines())
+    self.assertEqual(fields['Filesystem UUID'], 'da594c53-9beb-f85c-85c5-cedf76546f7a')
+    self.assertEqual(fields['Filesystem created'], 'Tue Jan  1 00:00:00 2008')
+
+  def test_ext4_matches(self):
+    fields = check_reproducible_output.parse_tune2fs(TUNE2FS.splitlines())
+    uuid = 'da594c53-9beb-f85c-85c5-cedf76546f7a'
+    self.assertEqual(check_reproducible_output.check_ext4_fields('system.img', fields, uuid, uuid),
+                     [])
+
+  def test_ext4_mismatch(self):
+    fields = check_reproducible_output.parse_tune2fs(TUNE2FS.splitlines())
+    uuid = '00000000-0000-5000-8000-000000000000'
+    self.assertEqual(check_reproducible_output.check_ext4_fields('system.img', fields, uuid, None), [
+        'system.img: Filesystem UUID is da594c53-9beb-f85c-85c5-cedf76546f7a, expected ' + uuid + '\n',
+    ])
+
+  def test_reference(self):
+    tmpdir = tempfile.mkdtemp()
+    try:
+      output = os.path.join(tmpdir, 'foo.apk')
+      same = os.path.join(tmpdir, 'same.apk')
+      different = os.path.join(tmpdir, 'different.apk')
+      for path, content in ((output, b'foo'), (same, b'foo'), (different, b'bar')):
+        with open(path, 'wb') as f:
+          f.write(content)
+      digest = check_reproducible_output.sha256(output)
+
+      self.assertEqual(check_reproducible_output.check_reference('foo.apk', digest, same), [])
+      self.assertEqual(
+          check_reproducible_output.check_reference('foo.apk', digest, different), [
+              'foo.apk: sha256 is %s, but %s has %s\n' % (
+                  digest, different, check_reproducible_output.sha256(different)),
+          ])
+      missing = os.path.join(tmpdir, 'missing.apk')
+      self.assertEqual(
+          check_reproducible_output.check_reference('foo.apk', digest, missing), [
+              'foo.apk: %s is missing from the reference build\n' % missing,
+          ])
+    finally:
+      shutil.rmtree(tmpdir)
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
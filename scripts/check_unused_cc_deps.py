@@ -0,0 +1,115 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Checks that the shared_libs and static_libs of a linked module are used.
+
+A shared library is used if it defines one of the dynamic symbols that the
+linked module leaves undefined. The module is linked with
+--no-allow-shlib-undefined, so all of those symbols must be provided by one of
+its shared libraries. A static library is used if the linker map shows that at
+least one of its sections was retained in the output.
+"""
+
+import argparse
+import subprocess
+import sys
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--readelf', required=True, help='path to llvm-readelf')
+  parser.add_argument('--module', required=True, help='name of the checked module')
+  parser.add_argument('--map', required=True, help='linker map of the module')
+  parser.add_argument('--shared-lib', action='append', default=[],
+                      help='a dependency from shared_libs, as <name>=<toc file>')
+  parser.add_argument('--static-lib', action='append', default=[],
+                      help='a dependency from static_libs, as <name>=<archive>')
+  parser.add_argument('-o', '--output', required=True, help='path to the report')
+  parser.add_argument('input', help='the linked module')
+  return parser.parse_args()
+
+
+def parse_dyn_syms(lines):
+  """Yields (name, defined) for each symbol of a llvm-readelf --dyn-syms listing."""
+  for line in lines:
+    fields = line.split()
+    # Symbol entries start with their index, e.g. "1: FUNC GLOBAL DEFAULT 12 foo".
+    if len(fields) < 5 or not fields[0].endswith(':') or not fields[0][:-1].isdigit():
+      continue
+    # The null symbol at index 0 has no name.
+    if fields[0] == '0:':
+      continue
+    name = fields[-1].split('@')[0]
+    yield name, fields[-2] != 'UND'
+
+
+def undefined_symbols(readelf, path):
+  output = subprocess.check_output([readelf, '--dyn-syms', '--wide', path],
+                                   universal_newlines=True)
+  return set(name for name, defined in parse_dyn_syms(output.splitlines()) if not defined)
+
+
+def toc_defined_symbols(toc):
+  with open(toc) as f:
+    return set(name for name, defined in parse_dyn_syms(f) if defined)
+
+
+def find_unused(module, undefined, shared_libs, static_libs, linker_map):
+  """Returns the report lines for the unused dependencies.
+
+  shared_libs is a list of (name, symbols defined by the library), and
+  static_libs a list of (name, archive).
+  """
+  unused = []
+  for name, defined in shared_libs:
+    if not undefined & defined:
+      unused.append('%s: %s provides no symbols, remove it from shared_libs\n' % (module, name))
+  for name, archive in static_libs:
+    if archive + '(' not in linker_map:
+      unused.append('%s: %s contributes no retained sections, remove it from static_libs\n' %
+                    (module, name))
+  return unused
+
+
+def main():
+  args = parse_args()
+
+  undefined = undefined_symbols(args.readelf, args.input)
+  with open(args.map) as f:
+    linker_map = f.read()
+
+  shared_libs = []
+  for dep in args.shared_lib:
+    name, _, toc = dep.partition('=')
+    shared_libs.append((name, toc_defined_symbols(toc)))
+  static_libs = []
+  for dep in args.static_lib:
+    name, _, archive = dep.partition('=')
+    static_libs.append((name, archive))
+  unused = find_unused(args.module, undefined, shared_libs, static_libs, linker_map)
+
+  with open(args.output, 'w') as f:
+    f.writelines(unused)
+
+  if unused:
+    sys.stderr.write('Unused dependencies found:\n')
+    sys.stderr.writelines(unused)
+    return 1
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
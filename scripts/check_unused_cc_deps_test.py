@@ -0,0 +1,81 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for check_unused_cc_deps.py."""
+
+import sys
+import unittest
+
+import check_unused_cc_deps
+
+sys.dont_write_bytecode = True
+
+DYN_SYMS = """\
+
+Symbol table '.dynsym' contains 4 entries:
+   Num:    Value          Size Type    Bind   Vis       Ndx Name
+     0: 0000000000000000     0 NOTYPE  LOCAL  DEFAULT   UND
+     1: 0000000000000000     0 FUNC    GLOBAL DEFAULT   UND bar@LIBBAR
+     2: 0000000000001000    16 FUNC    GLOBAL DEFAULT    12 foo
+     3: 0000000000000000     0 OBJECT  GLOBAL DEFAULT   UND baz
+"""
+
+# The TOC files written by toc.sh drop the value and size columns.
+TOC = """\
+  0x000000000000000e (SONAME)             Library soname: [libbar.so]
+     1:   FUNC    GLOBAL DEFAULT    12 bar@@LIBBAR
+"""
+
+LINKER_MAP = """\
+             VMA              LMA     Size Align Out     In      Symbol
+            1000             1000       10    16 .text
+            1000             1000       10    16         out/libfoo.a(foo.o):(.text.foo)
+"""
+
+
+class CheckUnusedCcDepsTest(unittest.TestCase):
+
+  def test_parse_dyn_syms(self):
+    self.assertEqual(list(check_unused_cc_deps.parse_dyn_syms(DYN_SYMS.splitlines())), [
+        ('bar', False),
+        ('foo', True),
+        ('baz', False),
+    ])
+
+  def test_parse_toc(self):
+    self.assertEqual(list(check_unused_cc_deps.parse_dyn_syms(TOC.splitlines())), [
+        ('bar', True),
+    ])
+
+  def test_find_unused_none(self):
+    unused = check_unused_cc_deps.find_unused(
+        'foo', {'bar'}, [('libbar', {'bar'})], [('libfoo', 'out/libfoo.a')], LINKER_MAP)
+    self.assertEqual(unused, [])
+
+  def test_find_unused(self):
+    unused = check_unused_cc_deps.find_unused(
+        'foo', {'bar'},
+        [('libbar', {'bar'}), ('libqux', {'qux'})],
+        [('libfoo', 'out/libfoo.a'), ('libunused', 'out/libunused.a')],
+        LINKER_MAP)
+    self.assertEqual(unused, [
+        'foo: libqux provides no symbols, remove it from shared_libs\n',
+        'foo: libunused contributes no retained sections, remove it from static_libs\n',
+    ])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
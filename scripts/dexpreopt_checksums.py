@@ -0,0 +1,124 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Writes the checksums and the layout of the dexpreopt outputs of a module.
+
+For every file installed by the dexpreopt rule of a module or by the rule
+building a boot image, the output lists the install path on the device, the
+kind of the file (odex, vdex, art, oat, dm or prof), the instruction set it was
+compiled for, its size and its sha256. OTA
+generation compares these files across builds to find the odex and vdex files
+that did not change, without opening the images.
+
+With --merge, the checksum files of several modules are merged into one list
+sorted by module name.
+"""
+
+import argparse
+import hashlib
+import json
+import os
+import sys
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__, fromfile_prefix_chars='@')
+  parser.add_argument('--module', help='name of the dexpreopted module')
+  parser.add_argument('--file', action='append', default=[], metavar='BUILT:INSTALLED',
+                      help='a built file and its install path on the device')
+  parser.add_argument('--isa', default='',
+                      help='instruction set of the files that are not installed in an oat directory')
+  parser.add_argument('--merge', action='store_true',
+                      help='merge the checksum files given as inputs')
+  parser.add_argument('-o', '--output', required=True, help='path to the output')
+  parser.add_argument('inputs', nargs='*', help='checksum files to merge')
+  return parser.parse_args()
+
+
+def file_layout(installed):
+  """Returns the kind and the instruction set of an installed dexpreopt output.
+
+  The instruction set is the name of the directory of the file when it is
+  installed in an oat directory, as in /system/app/Foo/oat/arm64/Foo.odex.
+  """
+  kind = os.path.splitext(installed)[1].lstrip('.')
+  isa = ''
+  parent = os.path.dirname(installed)
+  if os.path.basename(os.path.dirname(parent)) == 'oat':
+    isa = os.path.basename(parent)
+  return kind, isa
+
+
+def sha256(path):
+  h = hashlib.sha256()
+  with open(path, 'rb') as f:
+    for chunk in iter(lambda: f.read(1 << 20), b''):
+      h.update(chunk)
+  return h.hexdigest()
+
+
+def checksums(module, files, isa=''):
+  """Returns the checksum entry of module for the (built, installed) pairs of files.
+
+  isa is the instruction set of the files that are not installed in an oat
+  directory, as the boot image files in /system/framework/arm64.
+  """
+  entries = []
+  for built, installed in sorted(files, key=lambda f: f[1]):
+    kind, file_isa = file_layout(installed)
+    entries.append({
+        'path': installed,
+        'kind': kind,
+        'isa': file_isa or isa,
+        'size': os.path.getsize(built),
+        'sha256': sha256(built),
+    })
+  return {'module': module, 'files': entries}
+
+
+def merge(modules):
+  """Returns the checksum entries of modules sorted by module name."""
+  return sorted(modules, key=lambda m: m['module'])
+
+
+def main():
+  args = parse_args()
+
+  if args.merge:
+    modules = []
+    for path in args.inputs:
+      with open(path) as f:
+        modules.append(json.load(f))
+    result = merge(modules)
+  else:
+    if not args.module:
+      sys.exit('--module is required without --merge')
+    files = []
+    for arg in args.file:
+      built, sep, installed = arg.partition(':')
+      if not sep:
+        sys.exit('--file %s is not BUILT:INSTALLED' % arg)
+      files.append((built, installed))
+    result = checksums(args.module, files, args.isa)
+
+  with open(args.output, 'w') as f:
+    json.dump(result, f, indent=2, sort_keys=True)
+    f.write('\n')
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
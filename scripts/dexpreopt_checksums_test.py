@@ -0,0 +1,95 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for dexpreopt_checksums.py."""
+
+import os
+import sys
+import tempfile
+import unittest
+
+import dexpreopt_checksums
+
+sys.dont_write_bytecode = True
+
+
+class DexpreoptChecksumsTest(unittest.TestCase):
+
+  def test_file_layout(self):
+    self.assertEqual(dexpreopt_checksums.file_layout('/system/app/Foo/oat/arm64/Foo.odex'),
+                     ('odex', 'arm64'))
+    self.assertEqual(dexpreopt_checksums.file_layout('/system/app/Foo/Foo.dm'), ('dm', ''))
+    self.assertEqual(dexpreopt_checksums.file_layout('/system/etc/boot-image.prof'),
+                     ('prof', ''))
+
+  def test_checksums(self):
+    with tempfile.TemporaryDirectory() as tmp_dir:
+      vdex = os.path.join(tmp_dir, 'package.vdex')
+      odex = os.path.join(tmp_dir, 'package.odex')
+      with open(vdex, 'wb') as f:
+        f.write(b'vdex')
+      with open(odex, 'wb') as f:
+        f.write(b'')
+      result = dexpreopt_checksums.checksums('Foo', [
+          (vdex, '/system/app/Foo/oat/arm/Foo.vdex'),
+          (odex, '/system/app/Foo/oat/arm/Foo.odex'),
+      ])
+
+    self.assertEqual(result['module'], 'Foo')
+    self.assertEqual(result['files'], [
+        {
+            'path': '/system/app/Foo/oat/arm/Foo.odex',
+            'kind': 'odex',
+            'isa': 'arm',
+            'size': 0,
+            'sha256': 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855',
+        },
+        {
+            'path': '/system/app/Foo/oat/arm/Foo.vdex',
+            'kind': 'vdex',
+            'isa': 'arm',
+            'size': 4,
+            'sha256': '032f348ea568c41e4417178d43640c355b691ff1a99dc4d25ca50f2a6cacb710',
+        },
+    ])
+
+  def test_checksums_isa(self):
+    with tempfile.TemporaryDirectory() as tmp_dir:
+      art = os.path.join(tmp_dir, 'boot.art')
+      with open(art, 'wb') as f:
+        f.write(b'')
+      result = dexpreopt_checksums.checksums('boot_image_boot_arm64', [
+          (art, '/system/framework/arm64/boot.art'),
+      ], 'arm64')
+
+    self.assertEqual(result['files'], [
+        {
+            'path': '/system/framework/arm64/boot.art',
+            'kind': 'art',
+            'isa': 'arm64',
+            'size': 0,
+            'sha256': 'e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855',
+        },
+    ])
+
+  def test_merge(self):
+    merged = dexpreopt_checksums.merge([{'module': 'b', 'files': []},
+                                        {'module': 'a', 'files': []}])
+    self.assertEqual([m['module'] for m in merged], ['a', 'b'])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,152 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Generates the forwarding symbols of a cc_library_shim.
+
+The input is the stub source written by ndkstubgen for the symbol file of the
+shim. For every function of the stub source, the output defines a function of
+the same name that jumps to the function of the implementation library. The
+addresses of the functions are looked up with dlsym in the implementation
+library when the shim is loaded, as the shim and the implementation library
+define the same names.
+"""
+
+import argparse
+import re
+import sys
+
+FUNCTION_RE = re.compile(r'^(?:__attribute__\(\(weak\)\) )?void (\w+)\(\) \{\}$')
+VARIABLE_RE = re.compile(r'^(?:__attribute__\(\(weak\)\) )?int (\w+) = 0;$')
+
+PRELUDE = """\
+// Generated by gen_shim_forwarders.py, do not edit.
+
+#include <dlfcn.h>
+#include <stddef.h>
+#include <stdlib.h>
+
+__attribute__((visibility("hidden"))) void* shim_targets[%(count)d];
+
+static const char* const shim_symbols[%(count)d] = {
+%(names)s};
+
+__attribute__((constructor)) static void shim_init(void) {
+  void* impl = dlopen("%(impl)s", RTLD_NOW | RTLD_NOLOAD);
+  if (impl == NULL) {
+    abort();
+  }
+  for (size_t i = 0; i < %(count)d; i++) {
+    shim_targets[i] = dlsym(impl, shim_symbols[i]);
+    if (shim_targets[i] == NULL) {
+      abort();
+    }
+  }
+}
+
+#if defined(__aarch64__)
+#define SHIM_JUMP(i) \\
+  "adrp x16, shim_targets\\n" \\
+  "ldr x16, [x16, :lo12:shim_targets+" #i "*8]\\n" \\
+  "br x16\\n"
+#elif defined(__arm__)
+#define SHIM_JUMP(i) \\
+  "ldr ip, 1f\\n" \\
+  "0: add ip, pc, ip\\n" \\
+  "ldr pc, [ip, #" #i "*4]\\n" \\
+  "1: .word shim_targets-(0b+8)\\n"
+#elif defined(__x86_64__)
+#define SHIM_JUMP(i) \\
+  "jmp *shim_targets+" #i "*8(%%rip)\\n"
+#elif defined(__i386__)
+#define SHIM_JUMP(i) \\
+  "call 0f\\n" \\
+  "0: popl %%eax\\n" \\
+  "jmp *shim_targets-0b+" #i "*4(%%eax)\\n"
+#elif defined(__riscv)
+#define SHIM_JUMP(i) \\
+  "1: auipc t1, %%pcrel_hi(shim_targets+" #i "*8)\\n" \\
+  "ld t1, %%pcrel_lo(1b)(t1)\\n" \\
+  "jr t1\\n"
+#else
+#error "unsupported architecture"
+#endif
+
+#if defined(__arm__)
+#define SHIM_MODE ".arm\\n"
+#else
+#define SHIM_MODE ""
+#endif
+
+#define SHIM_FORWARD(name, i) \\
+  __asm__(".text\\n" SHIM_MODE \\
+          ".globl " #name "\\n" \\
+          ".type " #name ", %%function\\n" \\
+          #name ":\\n" SHIM_JUMP(i) \\
+          ".size " #name ", .-" #name "\\n");
+
+"""
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--impl', required=True,
+                      help='the soname of the implementation library')
+  parser.add_argument('stub_src', help='the stub source written by ndkstubgen')
+  parser.add_argument('output', help='the source of the forwarding symbols')
+  return parser.parse_args()
+
+
+def parse_stub_src(lines):
+  """Returns the functions and the variables of a stub source."""
+  functions = []
+  variables = []
+  for line in lines:
+    line = line.strip()
+    match = FUNCTION_RE.match(line)
+    if match:
+      functions.append(match.group(1))
+      continue
+    match = VARIABLE_RE.match(line)
+    if match:
+      variables.append(match.group(1))
+  return functions, variables
+
+
+def generate(impl, functions):
+  """Returns the source of the forwarding symbols of functions."""
+  # An array can't be empty, so a shim without functions has an unused target.
+  names = ''.join('  "%s",\n' % f for f in functions)
+  out = [PRELUDE % {'count': max(len(functions), 1), 'names': names, 'impl': impl}]
+  for i, function in enumerate(functions):
+    out.append('SHIM_FORWARD(%s, %d)\n' % (function, i))
+  return ''.join(out)
+
+
+def main():
+  args = parse_args()
+  with open(args.stub_src) as f:
+    functions, variables = parse_stub_src(f)
+  if variables:
+    sys.stderr.write('error: variables can\'t be forwarded by a shim: %s\n' %
+                     ', '.join(variables))
+    return 1
+  with open(args.output, 'w') as f:
+    f.write(generate(args.impl, functions))
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
@@ -0,0 +1,58 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for gen_shim_forwarders.py."""
+
+import sys
+import unittest
+
+import gen_shim_forwarders
+
+sys.dont_write_bytecode = True
+
+STUB_SRC = """\
+void foo() {}
+__attribute__((weak)) void bar() {}
+int baz = 0;
+__attribute__((weak)) int qux = 0;
+"""
+
+
+class GenShimForwardersTest(unittest.TestCase):
+
+  def test_parse_stub_src(self):
+    functions, variables = gen_shim_forwarders.parse_stub_src(STUB_SRC.splitlines())
+    self.assertEqual(functions, ['foo', 'bar'])
+    self.assertEqual(variables, ['baz', 'qux'])
+
+  def test_generate(self):
+    src = gen_shim_forwarders.generate('libplatform.so', ['foo', 'bar'])
+    self.assertIn('void* shim_targets[2];', src)
+    self.assertIn('  "foo",\n  "bar",\n};', src)
+    self.assertIn('dlopen("libplatform.so", RTLD_NOW | RTLD_NOLOAD)', src)
+    self.assertIn('SHIM_FORWARD(foo, 0)\nSHIM_FORWARD(bar, 1)\n', src)
+    # The format escapes of the prelude are expanded.
+    self.assertIn('*8(%rip)', src)
+    self.assertNotIn('%%', src)
+
+  def test_generate_without_functions(self):
+    src = gen_shim_forwarders.generate('libplatform.so', [])
+    self.assertIn('void* shim_targets[1];', src)
+    self.assertNotIn('SHIM_FORWARD(', src.split('#define SHIM_FORWARD')[1].split('\n\n', 1)[1])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
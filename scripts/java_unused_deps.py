@@ -0,0 +1,107 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Reports declared java dependencies that are not referenced by a module's classes.
+
+The classes referenced by the module are collected with jdeps, and each declared
+dependency is considered used if any of the classes in its jars is referenced.
+"""
+
+import argparse
+import subprocess
+import sys
+import zipfile
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--jdeps', required=True, help='path to the jdeps tool')
+  parser.add_argument('--module', required=True, help='name of the analyzed module')
+  parser.add_argument('--classes', action='append', default=[],
+                      help='jar containing the classes of the analyzed module')
+  parser.add_argument('--lib', action='append', default=[],
+                      help='a dependency from libs, as <name>=<jar>[,<jar>...]')
+  parser.add_argument('--static-lib', action='append', default=[],
+                      help='a dependency from static_libs, as <name>=<jar>[,<jar>...]')
+  parser.add_argument('-o', '--output', required=True, help='path to the report')
+  return parser.parse_args()
+
+
+def parse_dep(arg):
+  name, _, jars = arg.partition('=')
+  return name, [jar for jar in jars.split(',') if jar]
+
+
+def referenced_classes(jdeps, classes_jars):
+  """Returns the names of all classes referenced from the given jars."""
+  cmd = [jdeps, '-verbose:class', '--multi-release', 'base'] + classes_jars
+  output = subprocess.check_output(cmd, universal_newlines=True)
+  return parse_jdeps(output.splitlines())
+
+
+def parse_jdeps(lines):
+  """Returns the names of the classes referenced in a jdeps -verbose:class listing."""
+  referenced = set()
+  for line in lines:
+    # Class level dependencies are indented, e.g.
+    #    com.foo.A    -> com.bar.B    not found
+    if not line.startswith(' ') or '->' not in line:
+      continue
+    target = line.split('->', 1)[1].split()
+    if target:
+      referenced.add(target[0])
+  return referenced
+
+
+def provided_classes(jars):
+  """Returns the names of all classes contained in the given jars."""
+  classes = set()
+  for jar in jars:
+    with zipfile.ZipFile(jar) as z:
+      for entry in z.namelist():
+        if entry.endswith('.class') and not entry.startswith('META-INF/'):
+          classes.add(entry[:-len('.class')].replace('/', '.'))
+  return classes
+
+
+def find_unused(module, referenced, deps):
+  """Returns the sorted report lines for the deps, a list of (property, name, classes), that
+  provide none of the referenced classes."""
+  lines = []
+  for prop, name, classes in deps:
+    if not referenced & classes:
+      lines.append('%s: %s: %s is not referenced by any class\n' % (module, prop, name))
+  return sorted(lines)
+
+
+def main():
+  args = parse_args()
+
+  referenced = set()
+  if args.classes:
+    referenced = referenced_classes(args.jdeps, args.classes)
+
+  deps = []
+  for prop, values in (('libs', args.lib), ('static_libs', args.static_lib)):
+    for name, jars in map(parse_dep, values):
+      deps.append((prop, name, provided_classes(jars)))
+
+  with open(args.output, 'w') as f:
+    f.writelines(find_unused(args.module, referenced, deps))
+
+
+if __name__ == '__main__':
+  sys.exit(main())
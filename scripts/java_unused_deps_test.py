@@ -0,0 +1,76 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for java_unused_deps.py."""
+
+import os
+import shutil
+import sys
+import tempfile
+import unittest
+import zipfile
+
+import java_unused_deps
+
+sys.dont_write_bytecode = True
+
+JDEPS = """\
+foo.jar -> java.base
+foo.jar -> bar.jar
+   com.foo.A                                          -> com.bar.B                                          bar.jar
+   com.foo.A                                          -> java.lang.Object                                   java.base
+   com.foo.C                                          -> com.baz.D                                          not found
+"""
+
+
+class JavaUnusedDepsTest(unittest.TestCase):
+
+  def test_parse_dep(self):
+    self.assertEqual(java_unused_deps.parse_dep('bar=a.jar,b.jar'), ('bar', ['a.jar', 'b.jar']))
+    self.assertEqual(java_unused_deps.parse_dep('bar='), ('bar', []))
+
+  def test_parse_jdeps(self):
+    self.assertEqual(java_unused_deps.parse_jdeps(JDEPS.splitlines()),
+                     {'com.bar.B', 'java.lang.Object', 'com.baz.D'})
+
+  def test_provided_classes(self):
+    tmpdir = tempfile.mkdtemp()
+    try:
+      jar = os.path.join(tmpdir, 'bar.jar')
+      with zipfile.ZipFile(jar, 'w') as z:
+        z.writestr('com/bar/B.class', b'')
+        z.writestr('com/bar/B$Inner.class', b'')
+        z.writestr('META-INF/versions/9/com/bar/B.class', b'')
+        z.writestr('com/bar/res.txt', b'')
+      self.assertEqual(java_unused_deps.provided_classes([jar]),
+                       {'com.bar.B', 'com.bar.B$Inner'})
+    finally:
+      shutil.rmtree(tmpdir)
+
+  def test_find_unused(self):
+    deps = [
+        ('static_libs', 'qux', {'com.qux.E'}),
+        ('libs', 'bar', {'com.bar.B'}),
+        ('libs', 'baz', set()),
+    ]
+    self.assertEqual(java_unused_deps.find_unused('foo', {'com.bar.B'}, deps), [
+        'foo: libs: baz is not referenced by any class\n',
+        'foo: static_libs: qux is not referenced by any class\n',
+    ])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,127 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Merges the srcjars of a java module and records which producer provided each entry.
+
+Fails, naming both producers, if a class is provided by more than one srcjar,
+instead of letting javac report duplicate classes in its temporary directories.
+"""
+
+import argparse
+import os
+import re
+import sys
+import zipfile
+
+SOURCE_EXTENSIONS = ('.java', '.kt')
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--srcjar', action='append', default=[],
+                      help='a srcjar of the module, as <producer>=<srcjar>')
+  parser.add_argument('--module', required=True, help='name of the module')
+  parser.add_argument('--provenance', required=True,
+                      help='path to the provenance of each entry of the merged srcjar')
+  parser.add_argument('-o', '--output', required=True, help='path to the merged srcjar')
+  return parser.parse_args()
+
+
+def parse_srcjar(arg):
+  producer, _, srcjar = arg.partition('=')
+  return producer, srcjar
+
+
+COMMENT_RE = re.compile(r'/\*.*?\*/|//[^\n]*', re.DOTALL)
+PACKAGE_RE = re.compile(r'^\s*package\s+([\w.]+)', re.MULTILINE)
+
+
+def package_name(source):
+  """Returns the package declared by the contents of a source file, or '' if there is none."""
+  match = PACKAGE_RE.search(COMMENT_RE.sub('', source))
+  if match:
+    return match.group(1)
+  return ''
+
+
+def class_name(entry, source):
+  """Returns the fully qualified name of the class defined by a source entry, or None.
+
+  The name is made of the package declared in the source and the name of the file, as
+  generated sources are not always stored at the path matching their package.
+  """
+  for ext in SOURCE_EXTENSIONS:
+    if entry.endswith(ext):
+      name = os.path.basename(entry)[:-len(ext)]
+      package = package_name(source.decode('utf-8', 'replace'))
+      if package:
+        return package + '.' + name
+      return name
+  return None
+
+
+def merge_srcjars(module, srcjars, output):
+  """Merges the srcjars into output.
+
+  Returns the provenance of each entry as a list of (entry, producer, srcjar),
+  and the list of errors for the classes provided by more than one srcjar.
+  """
+  provenance = []
+  errors = []
+  entries = {}
+  classes = {}
+  with zipfile.ZipFile(output, 'w', zipfile.ZIP_DEFLATED) as out:
+    for producer, srcjar in srcjars:
+      with zipfile.ZipFile(srcjar) as z:
+        for info in z.infolist():
+          if info.filename.endswith('/'):
+            continue
+          content = z.read(info)
+          cls = class_name(info.filename, content)
+          if cls is not None:
+            if cls in classes:
+              other_producer, other_srcjar = classes[cls]
+              errors.append('%s: class %s is provided by both %s (%s) and %s (%s)' % (
+                  module, cls, other_producer, other_srcjar, producer, srcjar))
+              continue
+            classes[cls] = (producer, srcjar)
+          # Other entries are taken from the first srcjar that contains them.
+          if info.filename in entries:
+            continue
+          entries[info.filename] = True
+          out.writestr(info, content)
+          provenance.append((info.filename, producer, srcjar))
+  return provenance, errors
+
+
+def main():
+  args = parse_args()
+
+  srcjars = [parse_srcjar(arg) for arg in args.srcjar]
+  provenance, errors = merge_srcjars(args.module, srcjars, args.output)
+  if errors:
+    for error in errors:
+      print('error: ' + error, file=sys.stderr)
+    return 1
+
+  with open(args.provenance, 'w') as f:
+    for entry, producer, srcjar in sorted(provenance):
+      f.write('%s\t%s\t%s\n' % (entry, producer, srcjar))
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
@@ -0,0 +1,102 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for merge_srcjars.py."""
+
+import os
+import shutil
+import sys
+import tempfile
+import unittest
+import zipfile
+
+import merge_srcjars
+
+sys.dont_write_bytecode = True
+
+
+class MergeSrcjarsTest(unittest.TestCase):
+
+  def setUp(self):
+    self.tmpdir = tempfile.mkdtemp()
+
+  def tearDown(self):
+    shutil.rmtree(self.tmpdir)
+
+  def srcjar(self, name, entries):
+    path = os.path.join(self.tmpdir, name)
+    with zipfile.ZipFile(path, 'w') as z:
+      for entry in entries:
+        if isinstance(entry, tuple):
+          z.writestr(entry[0], entry[1])
+        else:
+          z.writestr(entry, '// ' + entry)
+    return path
+
+  def test_package_name(self):
+    self.assertEqual(merge_srcjars.package_name('package com.foo;\nclass Bar {}'), 'com.foo')
+    self.assertEqual(merge_srcjars.package_name('// package com.bar;\npackage com.foo\n'), 'com.foo')
+    self.assertEqual(merge_srcjars.package_name('/* package com.bar; */ class Bar {}'), '')
+
+  def test_class_name(self):
+    self.assertEqual(merge_srcjars.class_name('com/foo/Bar.java', b'package com.foo;'), 'com.foo.Bar')
+    self.assertEqual(merge_srcjars.class_name('Bar.kt', b'package com.foo'), 'com.foo.Bar')
+    self.assertEqual(merge_srcjars.class_name('com/foo/Bar.java', b'class Bar {}'), 'Bar')
+    self.assertIsNone(merge_srcjars.class_name('com/foo/bar.txt', b''))
+
+  def test_merge(self):
+    a = self.srcjar('a.srcjar', ['com/foo/A.java', 'README'])
+    b = self.srcjar('b.srcjar', ['com/foo/', 'com/foo/B.java', 'README'])
+    output = os.path.join(self.tmpdir, 'merged.srcjar')
+
+    provenance, errors = merge_srcjars.merge_srcjars(
+        'foo', [(':gen_a', a), (':gen_b', b)], output)
+
+    self.assertEqual(errors, [])
+    self.assertEqual(provenance, [
+        ('com/foo/A.java', ':gen_a', a),
+        ('README', ':gen_a', a),
+        ('com/foo/B.java', ':gen_b', b),
+    ])
+    with zipfile.ZipFile(output) as z:
+      self.assertEqual(sorted(z.namelist()), ['README', 'com/foo/A.java', 'com/foo/B.java'])
+      self.assertEqual(z.read('com/foo/B.java'), b'// com/foo/B.java')
+
+  def test_duplicate_class(self):
+    a = self.srcjar('a.srcjar', [('com/foo/A.java', 'package com.foo;')])
+    b = self.srcjar('b.srcjar', [('gen/A.kt', 'package com.foo')])
+    output = os.path.join(self.tmpdir, 'merged.srcjar')
+
+    _, errors = merge_srcjars.merge_srcjars(
+        'foo', [(':gen_a', a), ('aapt', b)], output)
+
+    self.assertEqual(errors, [
+        'foo: class com.foo.A is provided by both :gen_a (%s) and aapt (%s)' % (a, b),
+    ])
+
+  def test_same_class_name_in_different_packages(self):
+    a = self.srcjar('a.srcjar', [('A.java', 'package com.foo;')])
+    b = self.srcjar('b.srcjar', [('gen/A.java', 'package com.bar;')])
+    output = os.path.join(self.tmpdir, 'merged.srcjar')
+
+    _, errors = merge_srcjars.merge_srcjars(
+        'foo', [(':gen_a', a), (':gen_b', b)], output)
+
+    self.assertEqual(errors, [])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,252 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Generates an lcov tracefile and an HTML report from native coverage data.
+
+The coverage data is the directory of .profraw files (clang coverage) or .gcda
+files (gcov coverage) pulled from a device. With clang coverage the profiles are
+merged with llvm-profdata and exported for the instrumented binaries with
+llvm-cov. With gcov coverage the .gcno files of the zips built next to every
+instrumented module are matched with the .gcda files by path and read with
+llvm-cov gcov.
+
+Instrumented modules without coverage data are reported with zero counts, as
+long as there is coverage data for at least one module. Without any .profraw
+files there is no profile to export the clang coverage with, and the report is
+empty.
+"""
+
+import argparse
+import html
+import os
+import shutil
+import subprocess
+import sys
+import tempfile
+import zipfile
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--mode', required=True, choices=['clang', 'gcov'],
+                      help='the kind of coverage the modules are built with')
+  parser.add_argument('--llvm-cov', required=True, help='path to llvm-cov')
+  parser.add_argument('--llvm-profdata', help='path to llvm-profdata, for clang coverage')
+  parser.add_argument('--data-dir', help='directory of the coverage data pulled from a device')
+  parser.add_argument('--objects', help='file listing the instrumented binaries, for clang coverage')
+  parser.add_argument('--gcno-zips', help='file listing the zips of .gcno files, for gcov coverage')
+  parser.add_argument('--html', help='path to a zip of the HTML report')
+  parser.add_argument('-o', '--output', required=True, help='path to the lcov tracefile')
+  return parser.parse_args()
+
+
+def read_list(path):
+  """Returns the paths of a list file.
+
+  The list files are the rsp files written by ninja from $in, with the paths
+  separated by spaces.
+  """
+  if not path:
+    return []
+  with open(path) as f:
+    return f.read().split()
+
+
+def find_files(data_dir, suffix):
+  """Returns the files under data_dir ending with suffix, sorted."""
+  found = []
+  if data_dir and os.path.isdir(data_dir):
+    for root, _, files in os.walk(data_dir):
+      found.extend(os.path.join(root, f) for f in files if f.endswith(suffix))
+  return sorted(found)
+
+
+def add_count(coverage, source, line, count):
+  lines = coverage.setdefault(source, {})
+  lines[line] = lines.get(line, 0) + count
+
+
+def parse_lcov(lines):
+  """Returns the line counts of an lcov tracefile, as {source: {line: count}}."""
+  coverage = {}
+  source = None
+  for line in lines:
+    line = line.strip()
+    if line.startswith('SF:'):
+      source = line[3:]
+      coverage.setdefault(source, {})
+    elif line.startswith('DA:') and source is not None:
+      fields = line[3:].split(',')
+      add_count(coverage, source, int(fields[0]), int(fields[1]))
+    elif line == 'end_of_record':
+      source = None
+  return coverage
+
+
+def parse_gcov(lines):
+  """Returns the source and the line counts of a .gcov file written by llvm-cov gcov.
+
+  Lines of a .gcov file look like "    3:   12:source", with "-" as the count
+  of lines without code and "#####" as the count of lines that never ran.
+  """
+  source = None
+  counts = {}
+  for line in lines:
+    fields = line.split(':', 2)
+    if len(fields) < 3:
+      continue
+    count, number = fields[0].strip(), fields[1].strip()
+    if number == '0':
+      if fields[2].startswith('Source:'):
+        source = fields[2][len('Source:'):].strip()
+      continue
+    if count == '-' or not number.isdigit():
+      continue
+    if count.startswith('#') or count.startswith('='):
+      counts[int(number)] = counts.get(int(number), 0)
+    else:
+      counts[int(number)] = counts.get(int(number), 0) + int(count.rstrip('*'))
+  return source, counts
+
+
+def merge(coverages):
+  """Returns the sum of the line counts of coverages."""
+  merged = {}
+  for coverage in coverages:
+    for source, lines in coverage.items():
+      merged.setdefault(source, {})
+      for line, count in lines.items():
+        add_count(merged, source, line, count)
+  return merged
+
+
+def format_lcov(coverage):
+  """Returns coverage as an lcov tracefile."""
+  out = []
+  for source in sorted(coverage):
+    lines = coverage[source]
+    out.append('SF:%s\n' % source)
+    for line in sorted(lines):
+      out.append('DA:%d,%d\n' % (line, lines[line]))
+    out.append('LH:%d\n' % sum(1 for count in lines.values() if count > 0))
+    out.append('LF:%d\n' % len(lines))
+    out.append('end_of_record\n')
+  return ''.join(out)
+
+
+def format_html(coverage):
+  """Returns an HTML page with the line coverage of every source of coverage."""
+  rows = []
+  total_hit = total_found = 0
+  for source in sorted(coverage):
+    lines = coverage[source]
+    hit = sum(1 for count in lines.values() if count > 0)
+    total_hit += hit
+    total_found += len(lines)
+    rows.append('<tr><td>%s</td><td>%d</td><td>%d</td><td>%s</td></tr>\n' %
+                (html.escape(source), hit, len(lines), percent(hit, len(lines))))
+  return ''.join([
+      '<!DOCTYPE html>\n<html><head><title>Native coverage</title></head><body>\n',
+      '<h1>Native coverage: %s of %d lines</h1>\n' % (percent(total_hit, total_found), total_found),
+      '<table>\n<tr><th>Source</th><th>Hit</th><th>Lines</th><th>Coverage</th></tr>\n',
+  ] + rows + ['</table>\n</body></html>\n'])
+
+
+def percent(hit, found):
+  if not found:
+    return '-'
+  return '%.1f%%' % (100.0 * hit / found)
+
+
+def match_gcda(gcno_entry, gcda_files):
+  """Returns the .gcda file of gcda_files written for the .gcno file gcno_entry.
+
+  gcno_entry is relative to the output directory, while the device writes the
+  .gcda files under the absolute path of the object, so they are matched by
+  suffix.
+  """
+  suffix = os.sep + gcno_entry[:-len('.gcno')] + '.gcda'
+  for gcda in gcda_files:
+    if gcda.endswith(suffix):
+      return gcda
+  return None
+
+
+def clang_coverage(args, tmp_dir):
+  objects = read_list(args.objects)
+  profiles = find_files(args.data_dir, '.profraw')
+  if not objects or not profiles:
+    sys.stderr.write('No clang coverage profiles in %s, the report is empty.\n' % args.data_dir)
+    return {}
+
+  profdata = os.path.join(tmp_dir, 'merged.profdata')
+  subprocess.check_call([args.llvm_profdata, 'merge', '-sparse', '-o', profdata] + profiles)
+  cmd = [args.llvm_cov, 'export', '-format=lcov', '-instr-profile=' + profdata, objects[0]]
+  for obj in objects[1:]:
+    cmd += ['-object', obj]
+  return parse_lcov(subprocess.check_output(cmd, universal_newlines=True).splitlines())
+
+
+def gcov_coverage(args, tmp_dir):
+  gcda_files = find_files(args.data_dir, '.gcda')
+  notes_dir = os.path.join(tmp_dir, 'notes')
+  coverages = []
+  for gcno_zip in read_list(args.gcno_zips):
+    with zipfile.ZipFile(gcno_zip) as z:
+      for entry in z.namelist():
+        if not entry.endswith('.gcno'):
+          continue
+        z.extract(entry, notes_dir)
+        gcno = os.path.join(notes_dir, entry)
+        gcda = match_gcda(entry, gcda_files)
+        if gcda:
+          shutil.copy(gcda, gcno[:-len('.gcno')] + '.gcda')
+
+        work_dir = tempfile.mkdtemp(dir=tmp_dir)
+        subprocess.check_call([os.path.abspath(args.llvm_cov), 'gcov', '-o', os.path.dirname(gcno), gcno],
+                              cwd=work_dir, stdout=subprocess.DEVNULL)
+        for name in sorted(os.listdir(work_dir)):
+          if name.endswith('.gcov'):
+            with open(os.path.join(work_dir, name)) as f:
+              source, counts = parse_gcov(f)
+            if source:
+              coverages.append({source: counts})
+  return merge(coverages)
+
+
+def main():
+  args = parse_args()
+
+  tmp_dir = tempfile.mkdtemp()
+  try:
+    if args.mode == 'clang':
+      coverage = clang_coverage(args, tmp_dir)
+    else:
+      coverage = gcov_coverage(args, tmp_dir)
+  finally:
+    shutil.rmtree(tmp_dir)
+
+  with open(args.output, 'w') as f:
+    f.write(format_lcov(coverage))
+
+  if args.html:
+    with zipfile.ZipFile(args.html, 'w', zipfile.ZIP_DEFLATED) as z:
+      z.writestr('index.html', format_html(coverage))
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
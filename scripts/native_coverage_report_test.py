@@ -0,0 +1,159 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for native_coverage_report.py."""
+
+import argparse
+import os
+import shutil
+import stat
+import sys
+import tempfile
+import unittest
+
+import native_coverage_report
+
+sys.dont_write_bytecode = True
+
+GCOV = """\
+        -:    0:Source:external/foo/foo.cpp
+        -:    0:Graph:foo.gcno
+        -:    1:#include "foo.h"
+        3:    2:int foo() {
+    #####:    3:  return bar();
+       1*:    4:}
+"""
+
+LCOV = """\
+TN:
+SF:external/foo/foo.cpp
+DA:2,3
+DA:3,0
+end_of_record
+SF:external/foo/bar.cpp
+DA:7,1
+end_of_record
+"""
+
+
+FAKE_LLVM_COV = """\
+#!/bin/sh
+echo "$@" > "$(dirname "$0")/llvm-cov.args"
+cat <<EOF
+%sEOF
+""" % LCOV
+
+FAKE_LLVM_PROFDATA = """\
+#!/bin/sh
+echo "$@" > "$(dirname "$0")/llvm-profdata.args"
+"""
+
+
+class NativeCoverageReportTest(unittest.TestCase):
+
+  def setUp(self):
+    self.tmp_dir = tempfile.mkdtemp()
+
+  def tearDown(self):
+    shutil.rmtree(self.tmp_dir)
+
+  def write(self, name, contents, executable=False):
+    path = os.path.join(self.tmp_dir, name)
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, 'w') as f:
+      f.write(contents)
+    if executable:
+      os.chmod(path, os.stat(path).st_mode | stat.S_IXUSR)
+    return path
+
+  def read(self, name):
+    with open(os.path.join(self.tmp_dir, name)) as f:
+      return f.read()
+
+  def test_read_list(self):
+    # Ninja writes $in to the rsp files on a single line.
+    rsp = self.write('objects.rsp', 'out/libfoo.so out/bin/bar\n')
+    self.assertEqual(native_coverage_report.read_list(rsp), ['out/libfoo.so', 'out/bin/bar'])
+    self.assertEqual(native_coverage_report.read_list(None), [])
+
+  def test_clang_coverage(self):
+    args = argparse.Namespace(
+        llvm_cov=self.write('tools/llvm-cov', FAKE_LLVM_COV, executable=True),
+        llvm_profdata=self.write('tools/llvm-profdata', FAKE_LLVM_PROFDATA, executable=True),
+        data_dir=os.path.join(self.tmp_dir, 'data'),
+        objects=self.write('objects.rsp', 'out/libfoo.so out/bin/bar\n'))
+    self.write('data/foo.profraw', '')
+    self.write('data/bar.profraw', '')
+
+    coverage = native_coverage_report.clang_coverage(args, self.tmp_dir)
+
+    self.assertEqual(coverage, native_coverage_report.parse_lcov(LCOV.splitlines()))
+    self.assertIn('data/bar.profraw', self.read('tools/llvm-profdata.args'))
+    self.assertIn('data/foo.profraw', self.read('tools/llvm-profdata.args'))
+    self.assertTrue(
+        self.read('tools/llvm-cov.args').endswith(' out/libfoo.so -object out/bin/bar\n'))
+
+  def test_clang_coverage_without_profiles(self):
+    args = argparse.Namespace(
+        llvm_cov=self.write('tools/llvm-cov', FAKE_LLVM_COV, executable=True),
+        llvm_profdata=self.write('tools/llvm-profdata', FAKE_LLVM_PROFDATA, executable=True),
+        data_dir=os.path.join(self.tmp_dir, 'data'),
+        objects=self.write('objects.rsp', 'out/libfoo.so out/bin/bar\n'))
+
+    self.assertEqual(native_coverage_report.clang_coverage(args, self.tmp_dir), {})
+
+  def test_parse_gcov(self):
+    source, counts = native_coverage_report.parse_gcov(GCOV.splitlines())
+    self.assertEqual(source, 'external/foo/foo.cpp')
+    self.assertEqual(counts, {2: 3, 3: 0, 4: 1})
+
+  def test_parse_lcov(self):
+    self.assertEqual(native_coverage_report.parse_lcov(LCOV.splitlines()), {
+        'external/foo/foo.cpp': {2: 3, 3: 0},
+        'external/foo/bar.cpp': {7: 1},
+    })
+
+  def test_merge(self):
+    merged = native_coverage_report.merge([
+        {'foo.cpp': {2: 3, 3: 0}},
+        {'foo.cpp': {3: 2}, 'bar.cpp': {7: 0}},
+    ])
+    self.assertEqual(merged, {'foo.cpp': {2: 3, 3: 2}, 'bar.cpp': {7: 0}})
+
+  def test_format_lcov(self):
+    tracefile = native_coverage_report.format_lcov({'foo.cpp': {3: 0, 2: 3}})
+    self.assertEqual(tracefile, 'SF:foo.cpp\nDA:2,3\nDA:3,0\nLH:1\nLF:2\nend_of_record\n')
+
+  def test_format_html(self):
+    page = native_coverage_report.format_html({'foo.cpp': {2: 3, 3: 0}, '<bar>.cpp': {}})
+    self.assertIn('Native coverage: 50.0% of 2 lines', page)
+    self.assertIn('<td>foo.cpp</td><td>1</td><td>2</td><td>50.0%</td>', page)
+    self.assertIn('<td>&lt;bar&gt;.cpp</td><td>0</td><td>0</td><td>-</td>', page)
+
+  def test_match_gcda(self):
+    gcda_files = [
+        '/data/cov/out/soong/.intermediates/libbar/obj/bar.gcda',
+        '/data/cov/out/soong/.intermediates/libfoo/obj/foo.gcda',
+    ]
+    self.assertEqual(
+        native_coverage_report.match_gcda('soong/.intermediates/libfoo/obj/foo.gcno', gcda_files),
+        '/data/cov/out/soong/.intermediates/libfoo/obj/foo.gcda')
+    self.assertIsNone(
+        native_coverage_report.match_gcda('soong/.intermediates/libbaz/obj/baz.gcno', gcda_files))
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,170 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Reports symbols defined both by an APEX library and by a platform library.
+
+A process that loads a library from an APEX also loads the platform libraries
+that it links against through their stubs. When a static library is linked into
+both, the process gets two definitions of its symbols:
+
+- an exported symbol defined by both libraries is an ODR violation, and calls
+  from one library may be bound to the definition in the other one;
+- a hidden C++ variable, thread-local or not, defined by both libraries is
+  state that is silently duplicated, so a singleton has one instance per
+  library. Hidden functions are not reported, as a copy of code without state
+  is harmless and every static library linked on both sides would be reported.
+
+The linker makes hidden symbols local in the libraries it links, but keeps their
+visibility, so they are told apart from symbols with internal linkage. Names are
+compared mangled and reported demangled.
+
+A library module that is loaded both from the APEX and from the platform, like
+libc++, is skipped, as both copies define the same symbols by design.
+
+The contexts file is a JSON object mapping the name of each process context
+(an APEX and an architecture) to the libraries loaded in it, each with the
+module name, "apex" or "platform" as location, and the unstripped file.
+"""
+
+import argparse
+import json
+import subprocess
+import sys
+
+DEFINED_TYPES = ('FUNC', 'OBJECT', 'TLS', 'IFUNC')
+STATE_TYPES = ('OBJECT', 'TLS')
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--readelf', required=True, help='path to llvm-readelf')
+  parser.add_argument('--cxxfilt', required=True, help='path to llvm-cxxfilt')
+  parser.add_argument('--contexts', required=True, help='the process contexts to check')
+  parser.add_argument('--as-errors', action='store_true', help='fail if a violation is found')
+  parser.add_argument('-o', '--output', required=True, help='path to the report')
+  return parser.parse_args()
+
+
+def parse_symbols(lines):
+  """Returns the defined symbols with external linkage of llvm-readelf -sW output.
+
+  Symbol entries look like "12: 0000000000001234 8 OBJECT LOCAL HIDDEN 20 _ZN3foo1xE".
+  The result maps the names of the symbols to their "exported" or "hidden" kind.
+  Hidden symbols are only kept if they are C++ variables. Local symbols are only
+  kept if they are hidden, as the others have internal linkage.
+  """
+  symbols = {}
+  for line in lines:
+    fields = line.split()
+    if len(fields) < 8 or not fields[0].endswith(':') or not fields[0][:-1].isdigit():
+      continue
+    sym_type, bind, vis, ndx, name = fields[3:8]
+    if sym_type not in DEFINED_TYPES or ndx in ('UND', 'ABS'):
+      continue
+    if bind == 'LOCAL':
+      if vis != 'HIDDEN' or sym_type not in STATE_TYPES:
+        continue
+    elif bind not in ('GLOBAL', 'WEAK'):
+      continue
+    name = name.split('@')[0]
+    if vis in ('DEFAULT', 'PROTECTED'):
+      symbols[name] = 'exported'
+    elif (sym_type in STATE_TYPES and name.startswith('_Z') and
+          symbols.get(name) != 'exported'):
+      symbols[name] = 'hidden'
+  return symbols
+
+
+def demangle(cxxfilt, names):
+  """Returns a dict mapping each of names to its demangled name."""
+  if not names:
+    return {}
+  output = subprocess.check_output([cxxfilt], input='\n'.join(names) + '\n',
+                                   universal_newlines=True)
+  return dict(zip(names, output.splitlines()))
+
+
+def find_violations(context, libs, symbols):
+  """Returns the symbols defined both in and out of the APEX of a context.
+
+  symbols maps the file of each library of libs to its parse_symbols result. Each
+  violation is a (context, APEX module, platform module, problem, symbol) tuple.
+  The modules loaded both from the APEX and from the platform are skipped.
+  """
+  apex_modules = {lib['module'] for lib in libs if lib['location'] == 'apex'}
+  platform_modules = {lib['module'] for lib in libs if lib['location'] == 'platform'}
+  both = apex_modules & platform_modules
+  apex_libs = [lib for lib in libs
+               if lib['location'] == 'apex' and lib['module'] not in both]
+  platform_libs = [lib for lib in libs
+                   if lib['location'] == 'platform' and lib['module'] not in both]
+  violations = []
+  for apex_lib in apex_libs:
+    apex_symbols = symbols[apex_lib['file']]
+    for platform_lib in platform_libs:
+      platform_symbols = symbols[platform_lib['file']]
+      for name in sorted(set(apex_symbols) & set(platform_symbols)):
+        kinds = {apex_symbols[name], platform_symbols[name]}
+        if kinds == {'exported'}:
+          problem = 'both export'
+        elif kinds == {'hidden'}:
+          problem = 'both have a copy of'
+        else:
+          continue
+        violations.append((context, apex_lib['module'], platform_lib['module'], problem, name))
+  return violations
+
+
+def format_violations(violations, demangled):
+  """Returns the report lines of violations, with the names of the symbols in demangled."""
+  return ['%s: %s and %s %s %s\n' % (context, apex_module, platform_module, problem,
+                                     demangled.get(name, name))
+          for context, apex_module, platform_module, problem, name in violations]
+
+
+def main():
+  args = parse_args()
+
+  with open(args.contexts) as f:
+    contexts = json.load(f)
+
+  symbols = {}
+  for libs in contexts.values():
+    for lib in libs:
+      if lib['file'] not in symbols:
+        output = subprocess.check_output([args.readelf, '-sW', lib['file']],
+                                         universal_newlines=True)
+        symbols[lib['file']] = parse_symbols(output.splitlines())
+
+  violations = []
+  for context in sorted(contexts):
+    violations.extend(find_violations(context, contexts[context], symbols))
+  names = sorted({v[4] for v in violations if v[4].startswith('_Z')})
+  lines = format_violations(violations, demangle(args.cxxfilt, names))
+
+  with open(args.output, 'w') as f:
+    f.writelines(lines)
+
+  if lines and args.as_errors:
+    sys.stderr.write('Symbols are defined both by APEX libraries and by platform libraries\n')
+    sys.stderr.write('loaded in the same process. Link the shared code dynamically instead:\n')
+    sys.stderr.writelines(lines)
+    return 1
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
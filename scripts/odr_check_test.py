@@ -0,0 +1,138 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for odr_check.py."""
+
+import sys
+import unittest
+
+import odr_check
+
+sys.dont_write_bytecode = True
+
+SYMBOLS = """\
+Symbol table '.dynsym' contains 4 entries:
+   Num:    Value          Size Type    Bind   Vis       Ndx Name
+     0: 0000000000000000     0 NOTYPE  LOCAL  DEFAULT   UND
+     1: 0000000000000000     0 FUNC    GLOBAL DEFAULT   UND malloc@LIBC
+     2: 0000000000001000    16 FUNC    GLOBAL DEFAULT    12 foo_open@@LIBFOO
+     3: 0000000000002000     8 OBJECT  WEAK   PROTECTED  20 foo_version
+
+Symbol table '.symtab' contains 10 entries:
+   Num:    Value          Size Type    Bind   Vis       Ndx Name
+     1: 0000000000003000     8 OBJECT  LOCAL  DEFAULT    20 counter
+     2: 0000000000003008     8 OBJECT  LOCAL  DEFAULT    20 _ZL7g_cache
+     3: 0000000000003010     8 OBJECT  LOCAL  HIDDEN     20 _ZN3foo5stateE
+     4: 0000000000001000    16 FUNC    GLOBAL DEFAULT    12 foo_open
+     5: 0000000000004000     8 OBJECT  GLOBAL HIDDEN     20 _ZN3foo8instanceE
+     6: 0000000000005000     8 OBJECT  GLOBAL HIDDEN     20 plain_c_state
+     7: 0000000000006000    16 FUNC    LOCAL  HIDDEN     12 _ZN3foo6helperEv
+     8: 0000000000000000     0 NOTYPE  GLOBAL DEFAULT   ABS _end
+     9: 0000000000000010     8 TLS     LOCAL  HIDDEN     22 _ZN3foo9tls_stateE
+"""
+
+# The symbol tables of an APEX library and of a platform library that both link the static
+# library defining the hidden variable foo::registry, which the linker made local.
+APEX_LIB_SYMBOLS = """\
+Symbol table '.symtab' contains 3 entries:
+   Num:    Value          Size Type    Bind   Vis       Ndx Name
+     1: 0000000000003000     8 OBJECT  LOCAL  HIDDEN     20 _ZN3foo8registryE
+     2: 0000000000003008     8 OBJECT  LOCAL  DEFAULT    20 _ZL5count
+     3: 0000000000001000    16 FUNC    GLOBAL DEFAULT    12 apex_open
+"""
+
+PLATFORM_LIB_SYMBOLS = """\
+Symbol table '.symtab' contains 3 entries:
+   Num:    Value          Size Type    Bind   Vis       Ndx Name
+     1: 0000000000005000     8 OBJECT  LOCAL  HIDDEN     21 _ZN3foo8registryE
+     2: 0000000000005008     8 OBJECT  LOCAL  DEFAULT    21 _ZL5count
+     3: 0000000000002000     16 FUNC   GLOBAL DEFAULT    13 platform_open
+"""
+
+
+class OdrCheckTest(unittest.TestCase):
+
+  def test_parse_symbols(self):
+    self.assertEqual(odr_check.parse_symbols(SYMBOLS.splitlines()), {
+        'foo_open': 'exported',
+        'foo_version': 'exported',
+        '_ZN3foo5stateE': 'hidden',
+        '_ZN3foo8instanceE': 'hidden',
+        '_ZN3foo9tls_stateE': 'hidden',
+    })
+
+  def test_find_violations(self):
+    libs = [
+        {'module': 'libapex', 'location': 'apex', 'file': 'apex.so'},
+        {'module': 'libplatform', 'location': 'platform', 'file': 'platform.so'},
+        {'module': 'libother', 'location': 'platform', 'file': 'other.so'},
+    ]
+    symbols = {
+        'apex.so': {'foo_open': 'exported', '_ZN3foo8instanceE': 'hidden', 'mixed': 'hidden'},
+        'platform.so': {'foo_open': 'exported', '_ZN3foo8instanceE': 'hidden', 'mixed': 'exported'},
+        'other.so': {'foo_open': 'exported'},
+    }
+    self.assertEqual(odr_check.find_violations('com.android.foo/arm64', libs, symbols), [
+        ('com.android.foo/arm64', 'libapex', 'libplatform', 'both have a copy of',
+         '_ZN3foo8instanceE'),
+        ('com.android.foo/arm64', 'libapex', 'libplatform', 'both export', 'foo_open'),
+        ('com.android.foo/arm64', 'libapex', 'libother', 'both export', 'foo_open'),
+    ])
+
+  def test_find_violations_skips_modules_on_both_sides(self):
+    libs = [
+        {'module': 'libapex', 'location': 'apex', 'file': 'apex.so'},
+        {'module': 'libc++', 'location': 'apex', 'file': 'apex/libc++.so'},
+        {'module': 'libc++', 'location': 'platform', 'file': 'platform/libc++.so'},
+        {'module': 'libplatform', 'location': 'platform', 'file': 'platform.so'},
+    ]
+    symbols = {
+        'apex.so': {'apex_open': 'exported'},
+        'apex/libc++.so': {'_ZNSt3__14coutE': 'exported'},
+        'platform/libc++.so': {'_ZNSt3__14coutE': 'exported'},
+        'platform.so': {'platform_open': 'exported', '_ZNSt3__14coutE': 'exported'},
+    }
+    self.assertEqual(odr_check.find_violations('com.android.foo/arm64', libs, symbols), [])
+
+  def test_format_violations(self):
+    violations = [
+        ('com.android.foo/arm64', 'libapex', 'libplatform', 'both have a copy of',
+         '_ZN3foo8instanceE'),
+        ('com.android.foo/arm64', 'libapex', 'libplatform', 'both export', 'foo_open'),
+    ]
+    demangled = {'_ZN3foo8instanceE': 'foo::instance'}
+    self.assertEqual(odr_check.format_violations(violations, demangled), [
+        'com.android.foo/arm64: libapex and libplatform both have a copy of foo::instance\n',
+        'com.android.foo/arm64: libapex and libplatform both export foo_open\n',
+    ])
+
+  def test_hidden_variable_in_apex_and_platform_libs(self):
+    libs = [
+        {'module': 'libapex', 'location': 'apex', 'file': 'apex.so'},
+        {'module': 'libplatform', 'location': 'platform', 'file': 'platform.so'},
+    ]
+    symbols = {
+        'apex.so': odr_check.parse_symbols(APEX_LIB_SYMBOLS.splitlines()),
+        'platform.so': odr_check.parse_symbols(PLATFORM_LIB_SYMBOLS.splitlines()),
+    }
+    self.assertEqual(odr_check.find_violations('com.android.foo/arm64', libs, symbols), [
+        ('com.android.foo/arm64', 'libapex', 'libplatform', 'both have a copy of',
+         '_ZN3foo8registryE'),
+    ])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
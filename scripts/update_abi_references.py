@@ -0,0 +1,77 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Moves the reference ABI dumps updated by the build into the source tree.
+
+With UPDATE_ABI_REFERENCES=true, `m update-abi-references` writes the updated
+reference dumps of the checked libraries to $OUT_DIR/soong/abi-references, at
+the paths that the reference dumps have in the source tree, e.g.
+prebuilts/abi-dumps/platform/33/64/arm64/source-based/libfoo.so.lsdump.
+
+The dumps are moved rather than copied, so that they are only moved into the
+source tree once, and a later update never brings back the dumps of an earlier
+one.
+"""
+
+import argparse
+import os
+import shutil
+import sys
+
+
+def parse_args():
+  parser = argparse.ArgumentParser(description=__doc__)
+  parser.add_argument('--soong-out-dir',
+                      default=os.path.join(os.environ.get('OUT_DIR', 'out'), 'soong'),
+                      help='the soong output directory, $OUT_DIR/soong by default')
+  parser.add_argument('--top', default=os.environ.get('ANDROID_BUILD_TOP', '.'),
+                      help='the root of the source tree, $ANDROID_BUILD_TOP by default')
+  return parser.parse_args()
+
+
+def update_references(updates_dir, top):
+  """Moves the files of updates_dir to the same relative paths in top.
+
+  Returns the sorted list of the relative paths of the moved files.
+  """
+  moved = []
+  for root, _, files in os.walk(updates_dir):
+    for name in files:
+      src = os.path.join(root, name)
+      rel = os.path.relpath(src, updates_dir)
+      dst = os.path.join(top, rel)
+      os.makedirs(os.path.dirname(dst), exist_ok=True)
+      shutil.move(src, dst)
+      moved.append(rel)
+  return sorted(moved)
+
+
+def main():
+  args = parse_args()
+
+  updates_dir = os.path.join(args.soong_out_dir, 'abi-references')
+  if not os.path.isdir(updates_dir):
+    print('error: %s does not exist, run `UPDATE_ABI_REFERENCES=true m update-abi-references` '
+          'first' % updates_dir, file=sys.stderr)
+    return 1
+
+  for rel in update_references(updates_dir, args.top):
+    print('Updated ' + rel)
+  return 0
+
+
+if __name__ == '__main__':
+  sys.exit(main())
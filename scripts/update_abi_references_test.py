@@ -0,0 +1,70 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2022 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for update_abi_references.py."""
+
+import os
+import shutil
+import sys
+import tempfile
+import unittest
+
+import update_abi_references
+
+sys.dont_write_bytecode = True
+
+DUMP_DIR = os.path.join('prebuilts', 'abi-dumps', 'platform', '33', '64', 'arm64', 'source-based')
+
+
+class UpdateAbiReferencesTest(unittest.TestCase):
+
+  def setUp(self):
+    self.tmpdir = tempfile.mkdtemp()
+    self.updates_dir = os.path.join(self.tmpdir, 'out', 'soong', 'abi-references')
+    self.top = os.path.join(self.tmpdir, 'top')
+
+  def tearDown(self):
+    shutil.rmtree(self.tmpdir)
+
+  def write(self, path, content):
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    with open(path, 'w') as f:
+      f.write(content)
+
+  def read(self, path):
+    with open(path) as f:
+      return f.read()
+
+  def test_update_references(self):
+    self.write(os.path.join(self.updates_dir, DUMP_DIR, 'libfoo.so.lsdump'), 'new foo')
+    self.write(os.path.join(self.updates_dir, DUMP_DIR, 'libbar.so.lsdump'), 'new bar')
+    self.write(os.path.join(self.top, DUMP_DIR, 'libfoo.so.lsdump'), 'old foo')
+
+    moved = update_abi_references.update_references(self.updates_dir, self.top)
+
+    self.assertEqual(moved, [
+        os.path.join(DUMP_DIR, 'libbar.so.lsdump'),
+        os.path.join(DUMP_DIR, 'libfoo.so.lsdump'),
+    ])
+    self.assertEqual(self.read(os.path.join(self.top, DUMP_DIR, 'libfoo.so.lsdump')), 'new foo')
+    self.assertEqual(self.read(os.path.join(self.top, DUMP_DIR, 'libbar.so.lsdump')), 'new bar')
+
+    # The updated dumps are moved, so a second update has nothing left to move.
+    self.assertEqual(update_abi_references.update_references(self.updates_dir, self.top), [])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
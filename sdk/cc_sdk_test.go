@@ -2473,6 +2473,94 @@ cc_prebuilt_library_shared {
 `))
 }
 
+func TestApexExportedSdk(t *testing.T) {
+	result := testSdkWithCc(t, `
+		apex_defaults {
+			name: "myapex-defaults",
+			generate_exported_sdk: true,
+		}
+
+		apex {
+			name: "myapex",
+			defaults: ["myapex-defaults"],
+			key: "myapex.key",
+			native_shared_libs: ["stubslib", "privatelib"],
+			arch: {
+				arm64: {
+					native_shared_libs: ["arm64stubslib"],
+				},
+			},
+			updatable: false,
+		}
+
+		cc_library {
+			name: "stubslib",
+			srcs: ["Test.cpp"],
+			export_include_dirs: ["myinclude"],
+			apex_available: ["myapex"],
+			system_shared_libs: [],
+			stl: "none",
+			stubs: {
+				symbol_file: "some/where/stubslib.map.txt",
+				versions: ["1", "2"],
+			},
+		}
+
+		cc_library {
+			name: "arm64stubslib",
+			srcs: ["Test.cpp"],
+			apex_available: ["myapex"],
+			system_shared_libs: [],
+			stl: "none",
+			stubs: {
+				symbol_file: "some/where/stubslib.map.txt",
+				versions: ["1"],
+			},
+		}
+
+		cc_library {
+			name: "privatelib",
+			srcs: ["Test.cpp"],
+			apex_available: ["myapex"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+
+	exportedSdk := result.Module("myapex-exported-sdk", "common_os").(*sdk)
+	info := getSdkSnapshotBuildInfo(t, result, exportedSdk)
+	android.AssertStringDoesContain(t, "exported sdk snapshot", info.androidUnversionedBpContents, `
+cc_prebuilt_library_shared {
+    name: "stubslib",
+    prefer: false,
+    visibility: ["//visibility:public"],
+    apex_available: ["myapex"],
+`)
+	android.AssertStringDoesContain(t, "exported sdk snapshot", info.androidUnversionedBpContents, `
+    stubs: {
+        versions: [
+            "1",
+            "2",
+            "current",
+        ],
+    },
+`)
+	android.AssertStringDoesContain(t, "exported sdk headers", info.copyRules,
+		"myinclude/Test.h -> include/myinclude/Test.h\n")
+	android.AssertStringDoesContain(t, "exported sdk arch specific lib", info.androidUnversionedBpContents,
+		`name: "arm64stubslib",`)
+	// Only the libraries with stubs are part of the API surface of the APEX.
+	android.AssertStringDoesNotContain(t, "exported sdk private lib", info.androidUnversionedBpContents,
+		"privatelib")
+
+	entries := android.AndroidMkEntriesForTest(t, result.TestContext, exportedSdk)
+	goals := entries[0].GetDistForGoals(exportedSdk)
+	android.AssertStringEquals(t, "exported sdk dist goals phony", ".PHONY: apex_exported_sdks\n", goals[0])
+	android.AssertStringEquals(t, "exported sdk dist goals call",
+		"$(call dist-for-goals,apex_exported_sdks,out/soong/mainline-sdks/myapex-exported-sdk-current.zip:apex_exported_sdks/myapex-exported-sdk-current.zip)\n",
+		android.StringRelativeToTop(result.Config, goals[1]))
+}
+
 func TestDeviceAndHostSnapshotWithStubsLibrary(t *testing.T) {
 	result := testSdkWithCc(t, `
 		sdk {
@@ -0,0 +1,80 @@
+// Copyright (C) 2022 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdk
+
+import (
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+	"android/soong/apex"
+	"android/soong/cc"
+	"android/soong/java"
+)
+
+// This file creates the sdk modules of the exported sdks of APEXes, see apex/exported_sdk.go.
+
+const exportedSdkDistGoal = "apex_exported_sdks"
+
+func registerExportedSdkMutator(ctx android.RegisterMutatorsContext) {
+	// Registered as a pre-arch mutator after the defaults, so that generate_exported_sdk and the
+	// members can be set through apex_defaults.
+	ctx.TopDown("apex_exported_sdk", exportedSdkMutator)
+}
+
+// exportedSdkMutator creates the sdk module of the exported sdk of an APEX.
+func exportedSdkMutator(ctx android.TopDownMutatorContext) {
+	a, ok := ctx.Module().(apex.ApexWithExportedSdk)
+	if !ok {
+		return
+	}
+	members := a.ExportedSdkMembers()
+	if members == nil {
+		return
+	}
+
+	props := struct {
+		Name                            *string
+		Compile_multilib                *string
+		Native_shared_libs              []string
+		Java_sdk_libs                   []string
+		Bootclasspath_fragments         []string
+		Systemserverclasspath_fragments []string
+		Dist                            android.Dist
+	}{
+		Name:                            proptools.StringPtr(apex.ExportedSdkName(ctx.ModuleName())),
+		Compile_multilib:                proptools.StringPtr("both"),
+		Native_shared_libs:              members.Native_shared_libs,
+		Java_sdk_libs:                   members.Java_libs,
+		Bootclasspath_fragments:         members.Bootclasspath_fragments,
+		Systemserverclasspath_fragments: members.Systemserverclasspath_fragments,
+		Dist: android.Dist{
+			Targets: []string{exportedSdkDistGoal},
+			Dir:     proptools.StringPtr(exportedSdkDistGoal),
+		},
+	}
+	s := ctx.CreateModule(SdkModuleFactory, &props).(*sdk)
+	s.properties.Api_surface_only = true
+}
+
+// isApiSurfaceMember returns true if the module is part of the API surface of its APEX, i.e. it is
+// a native shared library with stubs or a java_sdk_library. Only their stubs are added to the
+// snapshot.
+func isApiSurfaceMember(module android.Module) bool {
+	if ccModule, ok := module.(*cc.Module); ok {
+		return ccModule.HasStubsVariants()
+	}
+	_, ok := module.(java.SdkLibraryDependency)
+	return ok
+}
@@ -38,6 +38,7 @@ func init() {
 func registerSdkBuildComponents(ctx android.RegistrationContext) {
 	ctx.RegisterModuleType("sdk", SdkModuleFactory)
 	ctx.RegisterModuleType("sdk_snapshot", SnapshotModuleFactory)
+	ctx.PreArchMutators(registerExportedSdkMutator)
 	ctx.PreDepsMutators(RegisterPreDepsMutators)
 }
 
@@ -85,6 +86,12 @@ type sdkProperties struct {
 	// True if this is a module_exports (or module_exports_snapshot) module type.
 	Module_exports bool `blueprint:"mutated"`
 
+	// True if this is the exported sdk of an APEX, which only has the members that are part of the
+	// API surface of the APEX. The other members are only searched for such members, e.g. a
+	// bootclasspath_fragment is not added to the sdk, but the java_sdk_library modules in its
+	// contents are.
+	Api_surface_only bool `blueprint:"mutated"`
+
 	// The additional visibility to add to the prebuilt modules to allow them to
 	// reference each other.
 	//
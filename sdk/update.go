@@ -206,6 +206,17 @@ func (s *sdk) collectMembers(ctx android.ModuleContext) {
 				return false
 			}
 
+			if s.properties.Api_surface_only {
+				if !memberTag.ExportMember() {
+					return false
+				}
+				if !isApiSurfaceMember(child) {
+					// Search the dependencies of the module for members that are part of the API
+					// surface.
+					return true
+				}
+			}
+
 			// Make sure that the resolved module is allowed in the member list property.
 			if !memberType.IsInstance(child) {
 				ctx.ModuleErrorf("module %q is not valid in property %s", ctx.OtherModuleName(child), memberType.SdkPropertyName())
@@ -171,6 +171,7 @@ var _ android.HostToolProvider = (*ShBinary)(nil)
 
 type ShTest struct {
 	ShBinary
+	android.ApexModuleBase
 
 	testProperties TestProperties
 
@@ -430,6 +431,19 @@ func (s *ShTest) InstallInData() bool {
 	return true
 }
 
+// Overrides android.ApexModuleBase.IsTestModule
+func (s *ShTest) IsTestModule() bool {
+	return true
+}
+
+// Implements android.ApexModule
+func (s *ShTest) ShouldSupportSdkVersion(ctx android.BaseModuleContext, sdkVersion android.ApiLevel) error {
+	// sh_test modules are never in APEXes, they only embed ApexModuleBase for test_for.
+	return nil
+}
+
+var _ android.ApexModule = (*ShTest)(nil)
+
 func (s *ShTest) AndroidMkEntries() []android.AndroidMkEntries {
 	return []android.AndroidMkEntries{android.AndroidMkEntries{
 		Class:      "NATIVE_TESTS",
@@ -502,6 +516,7 @@ func ShTestFactory() android.Module {
 	InitShBinaryModule(&module.ShBinary)
 	module.AddProperties(&module.testProperties)
 
+	android.InitApexModule(module)
 	android.InitAndroidArchModule(module, android.HostAndDeviceSupported, android.MultilibFirst)
 	return module
 }
@@ -516,6 +531,7 @@ func ShTestHostFactory() android.Module {
 		module.testProperties.Test_options.Unit_test = proptools.BoolPtr(true)
 	}
 
+	android.InitApexModule(module)
 	android.InitAndroidArchModule(module, android.HostSupported, android.MultilibFirst)
 	return module
 }
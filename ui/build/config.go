@@ -62,6 +62,7 @@ type configImpl struct {
 	jsonModuleGraph bool
 	bp2build        bool
 	queryview       bool
+	validateBp      bool
 	reportMkMetrics bool // Collect and report mk2bp migration progress metrics.
 	soongDocs       bool
 	skipConfig      bool
@@ -769,6 +770,8 @@ func (c *configImpl) parseArgs(ctx Context, args []string) {
 			c.bp2build = true
 		} else if arg == "queryview" {
 			c.queryview = true
+		} else if arg == "validate_bp" {
+			c.validateBp = true
 		} else if arg == "soong_docs" {
 			c.soongDocs = true
 		} else {
@@ -844,7 +847,7 @@ func (c *configImpl) SoongBuildInvocationNeeded() bool {
 		return true
 	}
 
-	if !c.JsonModuleGraph() && !c.Bp2Build() && !c.Queryview() && !c.SoongDocs() {
+	if !c.JsonModuleGraph() && !c.Bp2Build() && !c.Queryview() && !c.ValidateBp() && !c.SoongDocs() {
 		// Command line was empty, the default Ninja target is built
 		return true
 	}
@@ -931,6 +934,16 @@ func (c *configImpl) QueryviewMarkerFile() string {
 	return shared.JoinPath(c.SoongOutDir(), "queryview.marker")
 }
 
+func (c *configImpl) BpValidationMarkerFile() string {
+	return shared.JoinPath(c.SoongOutDir(), "bp_validation.marker")
+}
+
+// ChangedBpFilesFile returns the file that lists the Android.bp files validated by the
+// validate_bp goal, as given by the CHANGED_BP_FILES environment variable.
+func (c *configImpl) ChangedBpFilesFile() string {
+	return shared.JoinPath(c.SoongOutDir(), "bp_validation.changed_files")
+}
+
 func (c *configImpl) ModuleGraphFile() string {
 	return shared.JoinPath(c.SoongOutDir(), "module-graph.json")
 }
@@ -976,6 +989,10 @@ func (c *configImpl) Queryview() bool {
 	return c.queryview
 }
 
+func (c *configImpl) ValidateBp() bool {
+	return c.validateBp
+}
+
 func (c *configImpl) SoongDocs() bool {
 	return c.soongDocs
 }
@@ -1068,6 +1085,31 @@ func (c *configImpl) HighmemParallel() int {
 	return parallel
 }
 
+// LtoLinkParallel returns the depth of the pool for ThinLTO links, which need more RAM than other
+// links but less than highmem processes.
+func (c *configImpl) LtoLinkParallel() int {
+	if i, ok := c.environ.GetInt("NINJA_LTO_LINK_NUM_JOBS"); ok {
+		return i
+	}
+
+	const minMemPerLtoLinkProcess = 4 * 1024 * 1024 * 1024
+	parallel := c.Parallel()
+	if c.UseRemoteBuild() {
+		// See HighmemParallel, ninja doesn't support nested pools.
+		return (parallel + 7) / 8
+	} else if c.totalRAM == 0 {
+		// Couldn't detect the total RAM, don't restrict LTO links.
+		return parallel
+	} else if p := int(c.totalRAM / minMemPerLtoLinkProcess); p < 1 {
+		return 1
+	} else if p < parallel {
+		// If less than 4GB total RAM per process, reduce the number of LTO links
+		return p
+	}
+	// No restriction on LTO links
+	return parallel
+}
+
 func (c *configImpl) TotalRAM() uint64 {
 	return c.totalRAM
 }
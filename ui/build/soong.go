@@ -15,11 +15,13 @@
 package build
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"android/soong/ui/metrics"
 	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
@@ -43,6 +45,7 @@ const (
 	bp2buildTag        = "bp2build"
 	jsonModuleGraphTag = "modulegraph"
 	queryviewTag       = "queryview"
+	bpValidationTag    = "bp_validation"
 	soongDocsTag       = "soong_docs"
 
 	// bootstrapEpoch is used to determine if an incremental build is incompatible with the current
@@ -208,6 +211,28 @@ func primaryBuilderInvocation(
 	}
 }
 
+// writeChangedBpFilesFile writes the Android.bp files listed in the CHANGED_BP_FILES environment
+// variable to the file read by the bp_validation invocation of soong_build. The file is only
+// rewritten when its contents change so that validating the same files twice is a no-op.
+func writeChangedBpFilesFile(ctx Context, config Config) {
+	changedFiles, _ := config.Environment().Get("CHANGED_BP_FILES")
+	if strings.TrimSpace(changedFiles) == "" {
+		ctx.Fatalln("validate_bp requires CHANGED_BP_FILES to list the changed Android.bp files")
+	}
+	contents := []byte(strings.Join(strings.Fields(changedFiles), "\n") + "\n")
+
+	path := config.ChangedBpFilesFile()
+	if existing, err := ioutil.ReadFile(path); err == nil && bytes.Equal(existing, contents) {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		ctx.Fatalf("Failed to create parent directories of '%s': %s", path, err)
+	}
+	if err := ioutil.WriteFile(path, contents, 0666); err != nil {
+		ctx.Fatalf("Failed to write '%s': %s", path, err)
+	}
+}
+
 // bootstrapEpochCleanup deletes files used by bootstrap during incremental builds across
 // incompatible changes.  Incompatible changes are marked by incrementing the bootstrapEpoch
 // constant.  A tree is considered out of date for the current epoch of the
@@ -236,6 +261,7 @@ func bootstrapGlobFileList(config Config) []string {
 		config.NamedGlobFile(bp2buildTag),
 		config.NamedGlobFile(jsonModuleGraphTag),
 		config.NamedGlobFile(queryviewTag),
+		config.NamedGlobFile(bpValidationTag),
 		config.NamedGlobFile(soongDocsTag),
 	}
 }
@@ -300,6 +326,18 @@ func bootstrapBlueprint(ctx Context, config Config) {
 		fmt.Sprintf("generating the Soong module graph as a Bazel workspace at %s", queryviewDir),
 	)
 
+	bpValidationInvocation := primaryBuilderInvocation(
+		config,
+		bpValidationTag,
+		config.BpValidationMarkerFile(),
+		[]string{
+			"--bp_validation_marker", config.BpValidationMarkerFile(),
+			"--changed_bp_files", config.ChangedBpFilesFile(),
+		},
+		fmt.Sprintf("validating the Android.bp files listed in %s", config.ChangedBpFilesFile()),
+	)
+	bpValidationInvocation.Inputs = append(bpValidationInvocation.Inputs, config.ChangedBpFilesFile())
+
 	soongDocsInvocation := primaryBuilderInvocation(
 		config,
 		soongDocsTag,
@@ -315,6 +353,7 @@ func bootstrapBlueprint(ctx Context, config Config) {
 		config.NamedGlobFile(bp2buildTag),
 		config.NamedGlobFile(jsonModuleGraphTag),
 		config.NamedGlobFile(queryviewTag),
+		config.NamedGlobFile(bpValidationTag),
 		config.NamedGlobFile(soongDocsTag),
 	}
 
@@ -346,6 +385,7 @@ func bootstrapBlueprint(ctx Context, config Config) {
 			bp2buildInvocation,
 			jsonModuleGraphInvocation,
 			queryviewInvocation,
+			bpValidationInvocation,
 			soongDocsInvocation},
 	}
 
@@ -422,11 +462,19 @@ func runSoong(ctx Context, config Config) {
 			checkEnvironmentFile(soongBuildEnv, config.UsedEnvFile(queryviewTag))
 		}
 
+		if config.ValidateBp() {
+			checkEnvironmentFile(soongBuildEnv, config.UsedEnvFile(bpValidationTag))
+		}
+
 		if config.SoongDocs() {
 			checkEnvironmentFile(soongBuildEnv, config.UsedEnvFile(soongDocsTag))
 		}
 	}()
 
+	if config.ValidateBp() {
+		writeChangedBpFilesFile(ctx, config)
+	}
+
 	runMicrofactory(ctx, config, "bpglob", "github.com/google/blueprint/bootstrap/bpglob",
 		map[string]string{"github.com/google/blueprint": "build/blueprint"})
 
@@ -480,6 +528,10 @@ func runSoong(ctx Context, config Config) {
 		targets = append(targets, config.QueryviewMarkerFile())
 	}
 
+	if config.ValidateBp() {
+		targets = append(targets, config.BpValidationMarkerFile())
+	}
+
 	if config.SoongDocs() {
 		targets = append(targets, config.SoongDocsHtml())
 	}
@@ -0,0 +1,239 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"android/soong/ui/logger"
+)
+
+// maxBuildFailureErrorLines is the maximum number of lines of the action output that are included
+// in each build failure record.
+const maxBuildFailureErrorLines = 10
+
+// BuildFailure is a single record of the build failures log. Each failed action is written as one
+// JSON object per line (ndjson), so that CI systems can attribute failures without parsing the
+// terminal output.
+type BuildFailure struct {
+	// ActionId identifies the failed action within this build, in the order the actions were
+	// started.
+	ActionId int `json:"action_id"`
+
+	Description string `json:"description,omitempty"`
+
+	// Module and ModuleDir are derived from the outputs of the action, when they are in a
+	// module's intermediates directory.
+	Module    string `json:"module,omitempty"`
+	ModuleDir string `json:"module_dir,omitempty"`
+
+	Outputs []string `json:"outputs"`
+
+	// ErrorLines are the first lines of the action output that look like errors, or the first
+	// lines of the output if none of them do.
+	ErrorLines []string `json:"error_lines"`
+
+	// Owners are the owners listed in the closest OWNERS file of the module directory.
+	Owners []string `json:"owners,omitempty"`
+}
+
+type buildFailuresLog struct {
+	w      io.WriteCloser
+	log    logger.Logger
+	topDir string
+
+	nextActionId int
+	actionIds    map[*Action]int
+	owners       map[string][]string
+}
+
+// NewBuildFailuresLog returns a StatusOutput that writes a BuildFailure record to filename for
+// each action that fails.
+func NewBuildFailuresLog(log logger.Logger, filename string) StatusOutput {
+	return newBuildFailuresLog(log, filename, ".")
+}
+
+func newBuildFailuresLog(log logger.Logger, filename string, topDir string) StatusOutput {
+	f, err := logger.CreateFileWithRotation(filename, 5)
+	if err != nil {
+		log.Println("Failed to create build failures log file:", err)
+		return nil
+	}
+
+	return &buildFailuresLog{
+		w:         f,
+		log:       log,
+		topDir:    topDir,
+		actionIds: make(map[*Action]int),
+		owners:    make(map[string][]string),
+	}
+}
+
+func (b *buildFailuresLog) StartAction(action *Action, counts Counts) {
+	b.nextActionId++
+	b.actionIds[action] = b.nextActionId
+}
+
+func (b *buildFailuresLog) FinishAction(result ActionResult, counts Counts) {
+	id := b.actionIds[result.Action]
+	delete(b.actionIds, result.Action)
+
+	if result.Error == nil {
+		return
+	}
+
+	failure := BuildFailure{
+		ActionId:    id,
+		Description: result.Description,
+		Outputs:     result.Outputs,
+		ErrorLines:  firstErrorLines(result.Output, maxBuildFailureErrorLines),
+	}
+	if failure.Outputs == nil {
+		failure.Outputs = []string{}
+	}
+	failure.Module, failure.ModuleDir = b.moduleForOutputs(result.Outputs)
+	if failure.ModuleDir != "" {
+		failure.Owners = b.ownersForDir(failure.ModuleDir)
+	}
+
+	data, err := json.Marshal(failure)
+	if err != nil {
+		b.log.Println("Failed to marshal build failure:", err)
+		return
+	}
+	b.w.Write(append(data, '\n'))
+}
+
+func (b *buildFailuresLog) Flush() {
+	b.w.Close()
+}
+
+func (b *buildFailuresLog) Message(level MsgLevel, message string) {}
+
+func (b *buildFailuresLog) Write(p []byte) (int, error) {
+	return 0, errors.New("not supported")
+}
+
+var (
+	ansiEscapeRegexp = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]")
+	errorLineRegexp  = regexp.MustCompile(`(?i)\b(error|fatal|failed)\b`)
+
+	// Make puts the intermediates of a module in obj/<class>/<module>_intermediates.
+	makeIntermediatesRegexp = regexp.MustCompile(`/obj(?:_[^/]+)?/[A-Z_]+/([^/]+)_intermediates/`)
+)
+
+// firstErrorLines returns up to max lines of output that look like errors, or the first max lines
+// of output if none of them do.
+func firstErrorLines(output string, max int) []string {
+	var lines, errorLines []string
+	for _, line := range strings.Split(ansiEscapeRegexp.ReplaceAllString(output, ""), "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" {
+			continue
+		}
+		if len(lines) < max {
+			lines = append(lines, line)
+		}
+		if errorLineRegexp.MatchString(line) {
+			errorLines = append(errorLines, line)
+			if len(errorLines) == max {
+				break
+			}
+		}
+	}
+	if len(errorLines) > 0 {
+		return errorLines
+	}
+	if lines == nil {
+		return []string{}
+	}
+	return lines
+}
+
+// moduleForOutputs returns the name and the directory of the module that produces the given
+// outputs. The directory is only known for Soong modules, and is empty for Make modules.
+func (b *buildFailuresLog) moduleForOutputs(outputs []string) (module, dir string) {
+	for _, output := range outputs {
+		if i := strings.Index(output, "/.intermediates/"); i >= 0 {
+			// Soong puts the intermediates of a module in .intermediates/<dir>/<module>/<variant>,
+			// find the longest prefix that is a directory in the source tree with a blueprint file.
+			parts := strings.Split(output[i+len("/.intermediates/"):], "/")
+			for j := len(parts) - 2; j > 0; j-- {
+				candidate := filepath.Join(parts[:j]...)
+				if fileExists(filepath.Join(b.topDir, candidate, "Android.bp")) {
+					return parts[j], candidate
+				}
+			}
+		} else if match := makeIntermediatesRegexp.FindStringSubmatch(output); match != nil {
+			return match[1], ""
+		}
+	}
+	return "", ""
+}
+
+// ownersForDir returns the owners listed in the closest OWNERS file in dir or any of its parent
+// directories.
+func (b *buildFailuresLog) ownersForDir(dir string) []string {
+	if owners, ok := b.owners[dir]; ok {
+		return owners
+	}
+
+	var owners []string
+	if ownersFile := filepath.Join(b.topDir, dir, "OWNERS"); fileExists(ownersFile) {
+		owners = readOwners(ownersFile)
+	}
+	if len(owners) == 0 && dir != "." && dir != "" {
+		owners = b.ownersForDir(filepath.Dir(dir))
+	}
+
+	b.owners[dir] = owners
+	return owners
+}
+
+// readOwners returns the email addresses listed in an OWNERS file, ignoring comments, per-file
+// rules and directives.
+func readOwners(ownersFile string) []string {
+	f, err := os.Open(ownersFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var owners []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "@") && !strings.ContainsAny(line, " :=") {
+			owners = append(owners, line)
+		}
+	}
+	return owners
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
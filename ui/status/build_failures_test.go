@@ -0,0 +1,109 @@
+// Copyright 2022 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/ui/logger"
+)
+
+func TestBuildFailuresLog(t *testing.T) {
+	topDir := t.TempDir()
+	writeFile := func(path, content string) {
+		path = filepath.Join(topDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("foo/OWNERS", "# comment\nfoo@example.com\nper-file *.mk=bar@example.com\ninclude /OWNERS\n")
+	writeFile("foo/bar/Android.bp", "")
+
+	logFile := filepath.Join(t.TempDir(), "build_failures.ndjson")
+	out := newBuildFailuresLog(logger.New(ioutil.Discard), logFile, topDir)
+
+	succeeded := &Action{Description: "succeeded"}
+	soongAction := &Action{
+		Description: "compile baz.cpp",
+		Outputs:     []string{"out/soong/.intermediates/foo/bar/libbaz/android_arm64_armv8-a_shared/obj/baz.o"},
+	}
+	makeAction := &Action{
+		Description: "link qux",
+		Outputs:     []string{"out/target/product/generic/obj/EXECUTABLES/qux_intermediates/qux"},
+	}
+
+	out.StartAction(succeeded, Counts{})
+	out.StartAction(soongAction, Counts{})
+	out.StartAction(makeAction, Counts{})
+	out.FinishAction(ActionResult{Action: succeeded}, Counts{})
+	out.FinishAction(ActionResult{
+		Action: soongAction,
+		Output: "foo/bar/baz.cpp:1:1: warning: unused\n\x1b[1mfoo/bar/baz.cpp:2:1: error: oops\x1b[0m\n1 error generated.\n",
+		Error:  errors.New("exit status 1"),
+	}, Counts{})
+	out.FinishAction(ActionResult{
+		Action: makeAction,
+		Output: "ld.lld: undefined symbol: qux\n",
+		Error:  errors.New("exit status 1"),
+	}, Counts{})
+	out.Flush()
+
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failures []BuildFailure
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var failure BuildFailure
+		if err := json.Unmarshal([]byte(line), &failure); err != nil {
+			t.Fatalf("failed to parse %q: %s", line, err)
+		}
+		failures = append(failures, failure)
+	}
+
+	expected := []BuildFailure{
+		{
+			ActionId:    2,
+			Description: "compile baz.cpp",
+			Module:      "libbaz",
+			ModuleDir:   "foo/bar",
+			Outputs:     soongAction.Outputs,
+			ErrorLines:  []string{"foo/bar/baz.cpp:2:1: error: oops", "1 error generated."},
+			Owners:      []string{"foo@example.com"},
+		},
+		{
+			ActionId:    3,
+			Description: "link qux",
+			Module:      "qux",
+			Outputs:     makeAction.Outputs,
+			ErrorLines:  []string{"ld.lld: undefined symbol: qux"},
+		},
+	}
+
+	if !reflect.DeepEqual(failures, expected) {
+		t.Errorf("unexpected build failures:\nwant: %#v\n got: %#v", expected, failures)
+	}
+}